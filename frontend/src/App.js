@@ -152,6 +152,12 @@ const Seedling = ({ seedling, onDelete, onEdit }) => {
                 {seedling.step.includes("Complete") && (
                     <span className="text-green-500">Complete</span>
                 )}
+                {seedling.step === "SeedlingStepAwaitingConfig" && (
+                    <span className="text-yellow-600">Needs configuration</span>
+                )}
+                {seedling.step === "SeedlingStepAwaitingHooks" && (
+                    <span className="text-yellow-600">Hook failed</span>
+                )}
             </div>
             <div className="mt-auto flex justify-end space-x-2">
                 <button
@@ -451,13 +457,21 @@ const SeedlingManager = () => {
         const method = seedling.id ? "PUT" : "POST";
         const url = seedling.id ? `${baseURL}/${seedling.id}` : baseURL;
 
+        // New seedlings are built straight away rather than waiting for
+        // their plan to be approved
+        const body = seedling.id ? seedling : { ...seedling, autoApprove: true };
+
+        // Updates are only made to the version of the seedling shown
+        const headers = { "Content-Type": "application/json" };
+        if (seedling.id) {
+            headers["If-Match"] = `"${seedling.version}"`;
+        }
+
         // Send the fetch request with the seedling data
         fetch(url, {
             method,
-            headers: {
-                "Content-Type": "application/json",
-            },
-            body: JSON.stringify(seedling),
+            headers,
+            body: JSON.stringify(body),
         })
             .then((response) => {
                 if (!response.ok) {
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// acceptedSeedling is a created seedling as it's returned, with where to
+// poll its status and stream its build's events. Its build goes on after the
+// response.
+type acceptedSeedling struct {
+	*store.Seedling
+	StatusURL string `json:"statusUrl"`
+	EventsURL string `json:"eventsUrl"`
+}
+
+func newAcceptedSeedling(seedling *store.Seedling) acceptedSeedling {
+	return acceptedSeedling{
+		Seedling:  seedling,
+		StatusURL: "/api/v1/seedlings/status?ids=" + pipeline.PublicID(seedling.ID),
+		EventsURL: pipeline.SeedlingPath(seedling.ID) + "/events",
+	}
+}
+
+// syncParam parses ?sync=, responding 400 if it isn't a bool.
+func (s *Server) syncParam(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	param := r.URL.Query().Get("sync")
+	if param == "" {
+		return false, true
+	}
+	sync, err := strconv.ParseBool(param)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "sync must be true or false", nil)
+		return false, false
+	}
+	return sync, true
+}
+
+// checkQueue responds 429 if queueing n more builds would take the build
+// queue past BuildQueueMaxDepth, with a Retry-After of about when a worker
+// takes the next one off it. It returns whether they can be queued.
+func (s *Server) checkQueue(w http.ResponseWriter, r *http.Request, n int) bool {
+	max, depth := s.Config.BuildQueueMaxDepth, s.Scheduler.QueueDepth()
+	if max <= 0 || n == 0 || depth+n <= max {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(s.queueRetryAfter(r.Context()).Seconds())))
+	s.respondError(w, http.StatusTooManyRequests, pipeline.ErrCodeTooManyRequests, "the build queue is full",
+		map[string]int{"queued": depth, "max": max})
+	return false
+}
+
+// queueRetryAfter estimates how long until a worker is free for a queued
+// build: how long a whole build usually takes, shared by the workers.
+func (s *Server) queueRetryAfter(ctx context.Context) time.Duration {
+	estimates, err := s.stepEstimates(ctx)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to estimate step durations")
+		return time.Minute
+	}
+	var build time.Duration
+	for _, step := range pipeline.SeedlingSteps(store.Seedling{}) {
+		build += estimates[step]
+	}
+	if wait := build / time.Duration(s.Settings.Current().BuildWorkers); wait > time.Second {
+		return wait
+	}
+	return time.Second
+}
+
+// awaitFirstStep waits for the seedling's build to get past the step it was
+// created at, for at most CreateSyncMaxWait, and returns the seedling with
+// the step it's at then. events has to be subscribed to before the build is
+// queued. It returns false if the build is still at the step.
+func (s *Server) awaitFirstStep(ctx context.Context, events <-chan pipeline.SeedlingEvent, seedling store.Seedling) (store.Seedling, bool, error) {
+	timer := time.NewTimer(s.Config.CreateSyncMaxWait)
+	defer timer.Stop()
+	step := seedling.Step
+	for {
+		select {
+		case <-ctx.Done():
+			return seedling, false, ctx.Err()
+		case <-timer.C:
+			return seedling, false, nil
+		case event := <-events:
+			if event.Type != pipeline.EventStepChanged && event.Type != pipeline.EventFailed && event.Type != pipeline.EventCompleted {
+				continue
+			}
+			var current store.Seedling
+			if err := s.DB.GetContext(ctx, &current, "SELECT * FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+				return seedling, false, err
+			}
+			if current.Step != step {
+				seedling.Step, seedling.StepStartedAt = current.Step, current.StepStartedAt
+				seedling.FailureReason, seedling.FailedStep, seedling.FailedAt = current.FailureReason, current.FailedStep, current.FailedAt
+				seedling.ModifiedAt, seedling.Version = current.ModifiedAt, current.Version
+				return seedling, true, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// MAX_REJECTION_COMMENT is the longest comment a rejection can add to the
+// prompt of the step it regenerates.
+const MAX_REJECTION_COMMENT = 4000
+
+// approvalSteps are the steps a build can stop after for what they
+// generated to be approved: the ones that aren't branch steps, since the
+// build doesn't wait on those.
+var approvalSteps = []string{
+	pipeline.SeedlingStepProtobufs,
+	pipeline.SeedlingStepServer,
+	pipeline.SeedlingStepServerTests,
+	pipeline.SeedlingStepDockerfile,
+}
+
+// checkApprovalSteps returns why the steps can't be approval gates, or "" if
+// they can.
+func checkApprovalSteps(steps store.ApprovalSteps) string {
+	for _, step := range steps {
+		if !stepIn(step, approvalSteps) {
+			return fmt.Sprintf("%q can't require approval, only %s can", step, strings.Join(approvalSteps, ", "))
+		}
+	}
+	return ""
+}
+
+func stepIn(step string, steps []string) bool {
+	for _, s := range steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// approvedStep is the step the build of a seedling whose artifact was
+// approved goes on from: the first of its steps that hasn't succeeded,
+// which may be a branch step that was running when the build stopped.
+func (s *Server) approvedStep(ctx context.Context, seedling store.Seedling) (string, error) {
+	statuses, err := s.stepStatuses(ctx, seedling)
+	if err != nil {
+		return "", err
+	}
+	for _, status := range statuses {
+		if status.Status != pipeline.StepStatusSucceeded {
+			return status.Step, nil
+		}
+	}
+	return pipeline.SeedlingStepComplete, nil
+}
+
+type rejectRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ApproveSeedling goes on with the build of a seedling waiting at
+// SeedlingStepAwaitingApproval, from the step after the one it's waiting
+// with.
+func (s *Server) ApproveSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepAwaitingApproval {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling isn't waiting for approval", map[string]string{"step": seedling.Step})
+		return
+	}
+	step, err := s.approvedStep(r.Context(), seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get step statuses")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	now := time.Now()
+	result, err := s.DB.ExecContext(r.Context(), `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, modified_at = $2, approval_step = '', approval_requested_at = NULL,
+	   approval_comment = '', version = version + 1
+	 WHERE id = $3 AND step = $4
+	 `, step, now, seedling.ID, pipeline.SeedlingStepAwaitingApproval)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to approve seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling was already approved or rejected", nil)
+		return
+	}
+
+	approved := seedling.ApprovalStep
+	seedling.Step = step
+	seedling.StepStartedAt = &now
+	seedling.ModifiedAt = now
+	seedling.ApprovalStep = ""
+	seedling.ApprovalRequestedAt = nil
+	seedling.ApprovalComment = ""
+	seedling.Version++
+	pipeline.LoggerFromContext(r.Context()).WithField("name", seedling.Name).WithField("step", approved).Info("Approved seedling")
+	s.Emit(r.Context(), seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventApproved, Step: approved})
+	s.Notify(r.Context(), seedling, pipeline.EventStepChanged, step)
+	s.SubmitBuild(r.Context(), seedling)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// RejectSeedling builds the step a seedling waiting at
+// SeedlingStepAwaitingApproval is waiting with again, with the comment in
+// the body, if any, added to its prompt.
+func (s *Server) RejectSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	var req rejectRequest
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to read request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		invalid, err := decodeStrict(bytes.NewReader(body), &req)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+			return
+		}
+		if invalid != nil {
+			s.respondInvalid(w, invalid)
+			return
+		}
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if len(req.Comment) > MAX_REJECTION_COMMENT {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+			fmt.Sprintf("comment must be at most %d bytes", MAX_REJECTION_COMMENT), nil)
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepAwaitingApproval {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling isn't waiting for approval", map[string]string{"step": seedling.Step})
+		return
+	}
+
+	step := seedling.ApprovalStep
+	now := time.Now()
+	result, err := s.DB.ExecContext(r.Context(), `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, modified_at = $2, approval_requested_at = NULL, approval_comment = $3,
+	   version = version + 1
+	 WHERE id = $4 AND step = $5
+	 `, step, now, req.Comment, seedling.ID, pipeline.SeedlingStepAwaitingApproval)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to reject seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling was already approved or rejected", nil)
+		return
+	}
+	// The checkpoint is of the step after the rejected one.
+	if err := s.ClearCheckpoint(r.Context(), seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to clear checkpoint")
+	}
+
+	seedling.Step = step
+	seedling.StepStartedAt = &now
+	seedling.ModifiedAt = now
+	seedling.ApprovalRequestedAt = nil
+	seedling.ApprovalComment = req.Comment
+	seedling.Version++
+	pipeline.LoggerFromContext(r.Context()).WithField("name", seedling.Name).WithField("step", step).Info("Rejected seedling")
+	s.Emit(r.Context(), seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventRejected, Step: step,
+		Payload: pipeline.EventPayload{"comment": pipeline.EventText(req.Comment)}})
+	s.Notify(r.Context(), seedling, pipeline.EventStepChanged, step)
+	s.SubmitBuild(r.Context(), seedling)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// approvalLoop fails the seedlings that have waited for approval for longer
+// than ApprovalTimeout, every ApprovalCheckInterval until ctx is done.
+func (s *Server) approvalLoop(ctx context.Context) {
+	if s.Config.ApprovalTimeout <= 0 || s.Config.ApprovalCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.Config.ApprovalCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.expireApprovals(ctx); err != nil {
+				s.log.WithField("error", err).Error("failed to expire seedling approvals")
+			}
+		}
+	}
+}
+
+// expireApprovals fails every seedling waiting for approval since before
+// ApprovalTimeout ago at the step it was waiting with, which a retry builds
+// again.
+func (s *Server) expireApprovals(ctx context.Context) error {
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(ctx, &seedlings, `
+	 SELECT * FROM seedlings
+	 WHERE step = $1 AND approval_requested_at <= $2 AND deleted_at IS NULL
+	 `, pipeline.SeedlingStepAwaitingApproval, time.Now().Add(-s.Config.ApprovalTimeout)); err != nil {
+		return err
+	}
+	for _, seedling := range seedlings {
+		// Unless it was reviewed since.
+		result, err := s.DB.ExecContext(ctx,
+			"UPDATE seedlings SET step = approval_step, approval_requested_at = NULL WHERE id = $1 AND step = $2",
+			seedling.ID, pipeline.SeedlingStepAwaitingApproval)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		s.FailSeedling(ctx, seedling, "approval timeout")
+	}
+	return nil
+}
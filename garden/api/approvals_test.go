@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/pipelinetest"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// waitForStep waits for the seedling's build to get to step, failing t if
+// it doesn't in time.
+func waitForStep(t *testing.T, s *Server, id hide.Int64, step string) store.Seedling {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		var seedling store.Seedling
+		if err := s.DB.GetContext(context.Background(), &seedling, "SELECT * FROM seedlings WHERE id = $1", id); err != nil {
+			t.Fatal(err)
+		}
+		if seedling.Step == step && !building(s, id) {
+			return seedling
+		}
+		if seedling.Step != step && seedling.Step == pipeline.SeedlingStepFailed {
+			t.Fatalf("seedling failed: %s", seedling.FailureReason)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("seedling is at %s, not %s", seedling.Step, step)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// building reports whether the seedling is being built.
+func building(s *Server, id hide.Int64) bool {
+	for _, build := range s.BuildRegistry.List() {
+		if build.SeedlingID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestApprovalGate(t *testing.T) {
+	s, env := testServer(t)
+	seedling := env.Seedling(t, "echo")
+	if _, err := s.DB.Exec("UPDATE seedlings SET approval_required = $1 WHERE id = $2",
+		`["SeedlingStepProtobufs"]`, seedling.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The proto is regenerated as the rejection asks.
+	env.LLM.Reply = func(prompt string) (string, bool) {
+		if strings.HasSuffix(prompt, "```protobuf\n") && strings.Contains(prompt, "call the rpc Echo") {
+			return strings.Replace(pipelinetest.Replies["protobuf"], "rpc Say(", "rpc Echo(", 1), true
+		}
+		return "", false
+	}
+	path := pipeline.SeedlingPath(seedling.ID)
+	h := s.Routes()
+
+	s.SubmitBuild(context.Background(), seedling)
+	parked := waitForStep(t, s, seedling.ID, pipeline.SeedlingStepAwaitingApproval)
+	if parked.ApprovalStep != pipeline.SeedlingStepProtobufs {
+		t.Fatalf("waiting for approval of %q", parked.ApprovalStep)
+	}
+	if n := env.Runner.Ran("sh"); n != 0 {
+		t.Errorf("server built %d times before the protobufs were approved", n)
+	}
+
+	w := serve(h, "GET", path+"/files/protobufs/echo.proto", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "service Echo") {
+		t.Fatalf("proto to review: %d %s", w.Code, w.Body)
+	}
+
+	w = serve(h, "POST", path+"/reject", strings.NewReader(`{"comment": "call the rpc Echo"}`))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("reject: %d %s", w.Code, w.Body)
+	}
+	waitForStep(t, s, seedling.ID, pipeline.SeedlingStepAwaitingApproval)
+	w = serve(h, "GET", path+"/files/protobufs/echo.proto", nil)
+	if !strings.Contains(w.Body.String(), "rpc Echo(") {
+		t.Errorf("the protobufs weren't regenerated with the rejection comment: %s", w.Body)
+	}
+
+	w = serve(h, "POST", path+"/approve", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("approve: %d %s", w.Code, w.Body)
+	}
+	waitForStep(t, s, seedling.ID, pipeline.SeedlingStepComplete)
+	if n := env.Runner.Ran("protoc"); n != 2 {
+		t.Errorf("protoc ran %d times, want once and once more after the rejection", n)
+	}
+
+	w = serve(h, "POST", path+"/approve", nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("approve of a complete seedling: %d %s", w.Code, w.Body)
+	}
+
+	var events []pipeline.SeedlingEvent
+	if err := s.DB.Select(&events, "SELECT id, type, step, actor, payload, created_at FROM seedling_events WHERE seedling_id = $1 ORDER BY id", seedling.ID); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, event := range events {
+		switch event.Type {
+		case pipeline.EventApprovalRequested, pipeline.EventApproved, pipeline.EventRejected:
+			types = append(types, event.Type)
+		}
+	}
+	want := []string{pipeline.EventApprovalRequested, pipeline.EventRejected, pipeline.EventApprovalRequested, pipeline.EventApproved}
+	if got, _ := json.Marshal(types); string(got) != mustJSON(t, want) {
+		t.Errorf("approval events %s, want %s", got, mustJSON(t, want))
+	}
+}
+
+func mustJSON(t testing.TB, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// ListAttempts returns the seedling's attempts, oldest first, without their
+// code.
+func (s *Server) ListAttempts(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	attempts := []store.Attempt{}
+	if err := s.Reads.SelectContext(r.Context(), &attempts,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 ORDER BY id", seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get attempts")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	for i := range attempts {
+		attempts[i].TraceURL = s.TraceURL(attempts[i].TraceID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&attempts); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// AttemptDiff returns a unified diff of the code attempt {n} generated
+// against attempt ?against=, which defaults to the previous attempt at the
+// same step. Attempts are identified by the ids ListAttempts returns.
+func (s *Server) AttemptDiff(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	a, err := s.GetAttempt(r.Context(), seedling.ID, mux.Vars(r)["n"])
+	if err == sql.ErrNoRows {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "attempt not found", nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get attempt")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	var against *store.Attempt
+	if param := r.URL.Query().Get("against"); param != "" {
+		against, err = s.GetAttempt(r.Context(), seedling.ID, param)
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "attempt to diff against not found", nil)
+			return
+		}
+	} else {
+		against, err = s.PreviousAttempt(r.Context(), a)
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get attempt")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	diff, err := pipeline.DiffAttempts(against, a)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to diff attempts")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&diff); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
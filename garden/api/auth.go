@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// requestAPIKey returns the key presented in X-API-Key or as a bearer token.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(pipeline.APIKeyHeader); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// requiresAPIKey is whether a path is a management endpoint. Requests proxied
+// to seedlings, of the default garden or any other, are left to the seedling
+// to authenticate.
+func requiresAPIKey(path string) bool {
+	return strings.HasPrefix(path, "/api/") && !isInvokePath(path)
+}
+
+// isInvokePath is whether a path is proxied to a seedling:
+// /api/v1/seedlings/invoke/... or /api/v1/gardens/{garden}/invoke/....
+func isInvokePath(path string) bool {
+	if strings.HasPrefix(path, "/api/v1/seedlings/invoke/") {
+		return true
+	}
+	rest := strings.TrimPrefix(path, "/api/v1/gardens/")
+	if rest == path {
+		return false
+	}
+	i := strings.Index(rest, "/")
+	return i > 0 && strings.HasPrefix(rest[i:], "/invoke/")
+}
+
+// requiresAdmin is whether a request is restricted to AdminKeys: anything
+// under /api/v1/admin/, and registering templates, which every seedling
+// may be prompted with.
+func requiresAdmin(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/v1/admin/") ||
+		(r.URL.Path == "/api/v1/templates" && r.Method == http.MethodPost)
+}
+
+func (s *Server) isAdmin(name string) bool {
+	for _, admin := range s.Config.AdminKeys {
+		if admin == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithAPIKey requires a valid API key on management endpoints when API_KEYS
+// is set, and one of ADMIN_API_KEYS on admin endpoints. It records the
+// key's name on the request context so actions can be attributed to it.
+// Only the name is ever stored or logged.
+func (s *Server) WithAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.Config.APIKeys) == 0 || !requiresAPIKey(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		presented := requestAPIKey(r)
+		for name, key := range s.Config.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(key)) == 1 {
+				if requiresAdmin(r) && !s.isAdmin(name) {
+					s.respondError(w, http.StatusForbidden, pipeline.ErrCodeForbidden, "an admin API key is required", nil)
+					return
+				}
+				r = r.WithContext(pipeline.WithAPIKey(r.Context(), name))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		s.respondError(w, http.StatusUnauthorized, pipeline.ErrCodeUnauthorized, "a valid API key is required", nil)
+	})
+}
@@ -0,0 +1,782 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+	"github.com/urfave/cli"
+)
+
+const (
+	// BackupFormat is the layout of backup archives. Restores refuse
+	// archives of a later one.
+	BackupFormat = 1
+
+	// A backup archive is a gzipped tarball of BackupManifestEntry first,
+	// then BackupDBEntry, a git bundle per repo under BackupReposDir and
+	// files under BackupDataDir, which are restored to the same path under
+	// DATA_DIR: the repos' untracked files, such as secrets, and the local
+	// bucket without the module cache.
+	BackupManifestEntry = "manifest.json"
+	BackupDBEntry       = "garden.sqlite3"
+	BackupReposDir      = "repos"
+	BackupDataDir       = "data"
+)
+
+// BackupManifest describes a backup archive: what wrote it, the schema
+// version of its database and the repos in it.
+type BackupManifest struct {
+	Format        int          `json:"format"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	GardenVersion string       `json:"gardenVersion"`
+	GoVersion     string       `json:"goVersion"`
+	SQLiteVersion string       `json:"sqliteVersion"`
+	SchemaVersion uint64       `json:"schemaVersion"`
+	BlobStore     string       `json:"blobStore"`
+	Repos         []BackupRepo `json:"repos"`
+}
+
+// BackupRepo is a git repo under DATA_DIR/repos, by its slash separated
+// path there. Head is the ref HEAD points at, or its commit if it's
+// detached, and Bundle the archive entry of its bundle. Repos without
+// commits have neither.
+type BackupRepo struct {
+	Path   string `json:"path"`
+	Head   string `json:"head,omitempty"`
+	Bundle string `json:"bundle,omitempty"`
+}
+
+// preparedBackup is a backup whose database copy and bundles have been
+// written to dir, ready to be archived.
+type preparedBackup struct {
+	cfg      pipeline.Config
+	dir      string
+	manifest BackupManifest
+	// untracked are the repos' untracked files, relative to DATA_DIR.
+	untracked []string
+}
+
+// prepareBackup copies the database with SQLite's backup API, which is
+// consistent while the server writes to it, and bundles every repo. The
+// rest is read as the archive is written.
+func prepareBackup(ctx context.Context, cfg pipeline.Config) (*preparedBackup, error) {
+	dir, err := ioutil.TempDir("", "garden-backup")
+	if err != nil {
+		return nil, err
+	}
+	b := &preparedBackup{cfg: cfg, dir: dir, manifest: BackupManifest{
+		Format:        BackupFormat,
+		CreatedAt:     time.Now().UTC(),
+		GardenVersion: gardenVersion(),
+		BlobStore:     cfg.BlobStore,
+		Repos:         []BackupRepo{},
+	}}
+	b.manifest.SQLiteVersion, _, _ = sqlite3.Version()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		b.manifest.GoVersion = info.GoVersion
+	}
+	if err := b.prepare(ctx); err != nil {
+		b.close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *preparedBackup) prepare(ctx context.Context) error {
+	dbFile := filepath.Join(b.dir, BackupDBEntry)
+	if err := backupDB(ctx, b.cfg, dbFile); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	version, dirty, err := schemaVersion(ctx, dbFile)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty, fix the failed migration first", version)
+	}
+	b.manifest.SchemaVersion = version
+
+	repos, err := findRepos(b.cfg.ReposDir())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(b.dir, BackupReposDir), 0755); err != nil {
+		return err
+	}
+	for i, rel := range repos {
+		repo, untracked, err := b.bundle(ctx, rel, i)
+		if err != nil {
+			return fmt.Errorf("failed to bundle %s: %w", rel, err)
+		}
+		b.manifest.Repos = append(b.manifest.Repos, repo)
+		b.untracked = append(b.untracked, untracked...)
+	}
+	return nil
+}
+
+// bundle writes the repo's git bundle and lists its untracked files.
+func (b *preparedBackup) bundle(ctx context.Context, rel string, i int) (BackupRepo, []string, error) {
+	dir := filepath.Join(b.cfg.ReposDir(), filepath.FromSlash(rel))
+	repo := BackupRepo{Path: rel}
+	var untracked []string
+	out, err := pipeline.GitOutput(ctx, dir, "ls-files", "-z", "--others")
+	if err != nil {
+		return repo, nil, err
+	}
+	for _, file := range strings.Split(out, "\x00") {
+		if file != "" {
+			untracked = append(untracked, path.Join(BackupReposDir, rel, file))
+		}
+	}
+	if _, err := pipeline.GitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		// No commits, so nothing to bundle.
+		return repo, untracked, nil
+	}
+	if repo.Head, err = pipeline.GitOutput(ctx, dir, "symbolic-ref", "--quiet", "HEAD"); err != nil {
+		if repo.Head, err = pipeline.GitOutput(ctx, dir, "rev-parse", "HEAD"); err != nil {
+			return repo, nil, err
+		}
+	}
+	repo.Head = strings.TrimSpace(repo.Head)
+	repo.Bundle = path.Join(BackupReposDir, strconv.Itoa(i)+".bundle")
+	if _, err := pipeline.GitOutput(ctx, dir, "bundle", "create", "--quiet", filepath.Join(b.dir, filepath.FromSlash(repo.Bundle)), "--all"); err != nil {
+		return repo, nil, err
+	}
+	return repo, untracked, nil
+}
+
+func (b *preparedBackup) close() {
+	os.RemoveAll(b.dir)
+}
+
+// write writes the archive to w.
+func (b *preparedBackup) write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name: BackupManifestEntry, Mode: 0644, Size: int64(len(manifest)), ModTime: b.manifest.CreatedAt,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+	if err := addTarFile(tw, filepath.Join(b.dir, BackupDBEntry), BackupDBEntry); err != nil {
+		return err
+	}
+	for _, repo := range b.manifest.Repos {
+		if repo.Bundle == "" {
+			continue
+		}
+		if err := addTarFile(tw, filepath.Join(b.dir, filepath.FromSlash(repo.Bundle)), repo.Bundle); err != nil {
+			return err
+		}
+	}
+	for _, rel := range b.untracked {
+		err := addTarFile(tw, b.cfg.DataPath(filepath.FromSlash(rel)), path.Join(BackupDataDir, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := b.writeBucket(tw); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeBucket adds the local bucket, outputs and archives in it, without
+// the module cache, which is only a cache.
+func (b *preparedBackup) writeBucket(tw *tar.Writer) error {
+	root := b.cfg.DataPath(pipeline.BlobRoot)
+	modCache, _ := filepath.Abs(b.cfg.ModCacheDir)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if abs, _ := filepath.Abs(file); info.IsDir() && abs == modCache {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(b.cfg.DataDir, file)
+		if err != nil {
+			return err
+		}
+		return addTarFile(tw, file, path.Join(BackupDataDir, filepath.ToSlash(rel)))
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// addTarFile adds the regular file to the archive as name.
+func addTarFile(tw *tar.Writer, file, name string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, src, header.Size)
+	return err
+}
+
+// findRepos lists the git repos under dir, slash separated and relative to
+// it. The shared repo's seedling directories are in it rather than repos
+// of their own.
+func findRepos(dir string) ([]string, error) {
+	repos := []string{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(file, ".git")); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		repos = append(repos, filepath.ToSlash(rel))
+		return filepath.SkipDir
+	})
+	if os.IsNotExist(err) {
+		return repos, nil
+	}
+	return repos, err
+}
+
+// backupDB copies the database to dst with SQLite's online backup API. The
+// connections are opened without the OTel wrapper, whose connections don't
+// expose SQLite's.
+func backupDB(ctx context.Context, cfg pipeline.Config, dst string) error {
+	src, err := sql.Open("sqlite3", store.SQLiteDSN(cfg.DBPath(), cfg.SQLiteBusyTimeout, true))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest, err := sql.Open("sqlite3", dst)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("not a SQLite connection")
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// schemaVersion is the migration the database at file is at, as
+// golang-migrate records it, 0 if none has been run.
+func schemaVersion(ctx context.Context, file string) (uint64, bool, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return 0, false, err
+	}
+	defer db.Close()
+	var version uint64
+	var dirty bool
+	err = db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err == sql.ErrNoRows || err != nil && strings.Contains(err.Error(), "no such table") {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// gardenVersion is the commit the binary was built from, or its module
+// version without one.
+func gardenVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
+
+// Backup streams a backup archive of the instance's database, repos and
+// local bucket.
+func (s *Server) Backup(w http.ResponseWriter, r *http.Request) {
+	b, err := prepareBackup(r.Context(), s.Config)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to prepare backup")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to back up", nil)
+		return
+	}
+	defer b.close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, backupFileName(b.manifest.CreatedAt)))
+	// The status has been sent by the time the archive fails, so the
+	// truncated stream is all the client gets.
+	if err := b.write(w); err != nil {
+		s.log.WithField("error", err).Error("failed to write backup")
+		return
+	}
+	pipeline.LoggerFromContext(r.Context()).
+		WithField("repos", len(b.manifest.Repos)).
+		WithField("api_key", pipeline.APIKeyFromContext(r.Context())).
+		Info("Backed up")
+}
+
+func backupFileName(at time.Time) string {
+	return "garden-backup-" + at.Format("20060102T150405Z") + ".tar.gz"
+}
+
+// createBackup writes a backup archive of DATA_DIR to file.
+func createBackup(ctx context.Context, cfg pipeline.Config, file string) (*BackupManifest, error) {
+	b, err := prepareBackup(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer b.close()
+	// The archive has the instance's secrets.
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.write(f); err != nil {
+		f.Close()
+		os.Remove(file)
+		return nil, err
+	}
+	return &b.manifest, f.Close()
+}
+
+// RestoreOptions are how restoreBackup restores an archive. Force restores
+// into a DATA_DIR with garden state in it, which is removed first.
+// Migrations is the directory of migrations to bring the database's schema
+// up to date with.
+type RestoreOptions struct {
+	Force      bool
+	Migrations string
+}
+
+// RestoreResult is what restoreBackup restored: the repos, the migrations
+// it ran after the archive's schema version, and the complete seedlings,
+// whose images are built when they're next started since there are none
+// on a new host.
+type RestoreResult struct {
+	Manifest   BackupManifest
+	Migrations []uint64
+	Complete   int
+}
+
+// restoreBackup restores the archive at file into DATA_DIR, which is locked
+// while it does.
+func restoreBackup(ctx context.Context, cfg pipeline.Config, file string, opts RestoreOptions) (*RestoreResult, error) {
+	lock, err := lockDataDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	migrations, err := readMigrations(opts.Migrations)
+	if err != nil {
+		return nil, err
+	}
+	state, err := gardenState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(state) > 0 {
+		if !opts.Force {
+			return nil, fmt.Errorf("%s already has %s, restore with --force to replace them", cfg.DataDir, strings.Join(state, ", "))
+		}
+		for _, rel := range state {
+			if err := os.RemoveAll(cfg.DataPath(rel)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	result, err := extractBackup(ctx, cfg, f)
+	if err != nil {
+		return nil, err
+	}
+	if result.Migrations, err = migrateRestored(ctx, cfg, result.Manifest.SchemaVersion, migrations); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", cfg.DBPath())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM seedlings WHERE step = $1 AND NOT archived AND deleted_at IS NULL",
+		pipeline.SeedlingStepComplete).Scan(&result.Complete); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// gardenState lists what in DATA_DIR is garden state, relative to it: the
+// database, repos and bucket entries other than the module cache. DATA_DIR
+// may be the source tree, so anything else in it is left alone.
+func gardenState(cfg pipeline.Config) ([]string, error) {
+	state := []string{}
+	for _, rel := range []string{pipeline.DBFile, pipeline.DBFile + "-wal", pipeline.DBFile + "-shm", "repos"} {
+		if _, err := os.Stat(cfg.DataPath(rel)); err == nil {
+			state = append(state, rel)
+		}
+	}
+	modCache, _ := filepath.Abs(cfg.ModCacheDir)
+	entries, err := ioutil.ReadDir(cfg.DataPath(pipeline.BlobRoot))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if abs, _ := filepath.Abs(cfg.DataPath(pipeline.BlobRoot, entry.Name())); abs == modCache {
+			continue
+		}
+		state = append(state, filepath.Join(pipeline.BlobRoot, entry.Name()))
+	}
+	return state, nil
+}
+
+// extractBackup restores the archive's database, repos and files.
+func extractBackup(ctx context.Context, cfg pipeline.Config, r io.Reader) (*RestoreResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	if header.Name != BackupManifestEntry {
+		return nil, fmt.Errorf("not a backup archive: it starts with %s", header.Name)
+	}
+	result := &RestoreResult{}
+	if err := json.NewDecoder(tr).Decode(&result.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if result.Manifest.Format > BackupFormat {
+		return nil, fmt.Errorf("backup is format %d, this garden restores up to %d", result.Manifest.Format, BackupFormat)
+	}
+	bundles := map[string]BackupRepo{}
+	for _, repo := range result.Manifest.Repos {
+		dir, err := safeJoin(cfg.ReposDir(), repo.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := pipeline.InitSeedlingRepo(ctx, dir); err != nil {
+			return nil, err
+		}
+		if repo.Bundle != "" {
+			bundles[repo.Bundle] = repo
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "garden-restore")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		switch {
+		case header.Name == BackupDBEntry:
+			if err := extractFile(tr, cfg.DBPath(), 0644); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(header.Name, BackupDataDir+"/"):
+			target, err := safeJoin(cfg.DataDir, strings.TrimPrefix(header.Name, BackupDataDir+"/"))
+			if err != nil {
+				return nil, err
+			}
+			if err := extractFile(tr, target, os.FileMode(header.Mode).Perm()); err != nil {
+				return nil, err
+			}
+		default:
+			repo, ok := bundles[header.Name]
+			if !ok {
+				return nil, fmt.Errorf("unexpected archive entry %q", header.Name)
+			}
+			bundle := filepath.Join(tmp, "repo.bundle")
+			if err := extractFile(tr, bundle, 0644); err != nil {
+				return nil, err
+			}
+			if err := restoreBundle(ctx, filepath.Join(cfg.ReposDir(), filepath.FromSlash(repo.Path)), bundle, repo.Head); err != nil {
+				return nil, fmt.Errorf("failed to restore %s: %w", repo.Path, err)
+			}
+		}
+	}
+	if _, err := os.Stat(cfg.DBPath()); err != nil {
+		return nil, fmt.Errorf("backup has no database: %w", err)
+	}
+	return result, nil
+}
+
+// restoreBundle fetches every ref of the bundle into the freshly
+// initialized repo at dir and checks out head.
+func restoreBundle(ctx context.Context, dir, bundle, head string) error {
+	if _, err := pipeline.GitOutput(ctx, dir, "fetch", "--quiet", "--update-head-ok", bundle, "refs/*:refs/*"); err != nil {
+		return err
+	}
+	if strings.HasPrefix(head, "refs/") {
+		if _, err := pipeline.GitOutput(ctx, dir, "symbolic-ref", "HEAD", head); err != nil {
+			return err
+		}
+	} else if _, err := pipeline.GitOutput(ctx, dir, "update-ref", "--no-deref", "HEAD", head); err != nil {
+		return err
+	}
+	_, err := pipeline.GitOutput(ctx, dir, "reset", "--quiet", "--hard")
+	return err
+}
+
+// safeJoin joins the slash separated rel onto root, refusing paths that
+// escape it.
+func safeJoin(root, rel string) (string, error) {
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	if target != filepath.Clean(root) && !strings.HasPrefix(target, filepath.Clean(root)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %q escapes %s", rel, root)
+	}
+	return target, nil
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// migration is an up migration by its golang-migrate version.
+type migration struct {
+	version uint64
+	file    string
+}
+
+// readMigrations lists the up migrations in dir, oldest first.
+func readMigrations(dir string) ([]migration, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no migrations in %s, set --migrations to garden's migrations directory", dir)
+	}
+	migrations := []migration{}
+	for _, file := range files {
+		prefix := strings.SplitN(filepath.Base(file), "_", 2)[0]
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version", file)
+		}
+		migrations = append(migrations, migration{version: version, file: file})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrateRestored runs the migrations after the restored database's schema
+// version, recording each the way golang-migrate does, and returns their
+// versions. A backup from a garden with migrations this one doesn't have
+// is refused.
+func migrateRestored(ctx context.Context, cfg pipeline.Config, version uint64, migrations []migration) ([]uint64, error) {
+	latest := migrations[len(migrations)-1].version
+	if version > latest {
+		return nil, fmt.Errorf("backup's schema version %d is newer than this garden's latest migration %d", version, latest)
+	}
+	db, err := sql.Open("sqlite3", cfg.DBPath())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	applied := []uint64{}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		up, err := ioutil.ReadFile(m.file)
+		if err != nil {
+			return applied, err
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return applied, err
+		}
+		if _, err := tx.ExecContext(ctx, string(up)); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %d failed: %w", m.version, err)
+		}
+		if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version uint64, dirty bool)"); err != nil {
+			tx.Rollback()
+			return applied, err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations"); err != nil {
+			tx.Rollback()
+			return applied, err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, FALSE)", m.version); err != nil {
+			tx.Rollback()
+			return applied, err
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m.version)
+	}
+	return applied, nil
+}
+
+func BackupCommand(log *logrus.Entry, cfg pipeline.Config) cli.Command {
+	return cli.Command{
+		Name:  "backup",
+		Usage: "Back up DATA_DIR to an archive or restore one into it",
+		Subcommands: []cli.Command{
+			{
+				Name:      "create",
+				Usage:     "Write the database, repos and local bucket to an archive, which has the instance's secrets",
+				ArgsUsage: "<path>",
+				Action: func(cliCtx *cli.Context) error {
+					file := cliCtx.Args().First()
+					if file == "" {
+						return errors.New("usage: backup create <path>")
+					}
+					manifest, err := createBackup(context.Background(), cfg, file)
+					if err != nil {
+						return err
+					}
+					log.WithField("path", file).
+						WithField("repos", len(manifest.Repos)).
+						WithField("schema_version", manifest.SchemaVersion).
+						Info("Backed up")
+					return nil
+				},
+			},
+			{
+				Name:      "restore",
+				Usage:     "Restore an archive into DATA_DIR and migrate its database",
+				ArgsUsage: "<path>",
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "force", Usage: "replace the database, repos and bucket already in DATA_DIR"},
+					cli.StringFlag{Name: "migrations", Value: "migrations", Usage: "directory of the migrations to run after the archive's schema version"},
+				},
+				Action: func(cliCtx *cli.Context) error {
+					file := cliCtx.Args().First()
+					if file == "" {
+						return errors.New("usage: backup restore [--force] <path>")
+					}
+					result, err := restoreBackup(context.Background(), cfg, file, RestoreOptions{
+						Force:      cliCtx.Bool("force"),
+						Migrations: cliCtx.String("migrations"),
+					})
+					if err != nil {
+						return err
+					}
+					log.WithField("path", file).
+						WithField("repos", len(result.Manifest.Repos)).
+						WithField("schema_version", result.Manifest.SchemaVersion).
+						WithField("migrations", result.Migrations).
+						WithField("complete_seedlings", result.Complete).
+						Info("Restored backup; complete seedlings' images are built when they're next started")
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/c2h5oh/hide"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// BatchMaxSeedlings caps how many seedlings one batch may create, and
+	// StatusMaxIDs how many seedlings one status query may ask about.
+	BatchMaxSeedlings = 50
+	StatusMaxIDs      = 100
+)
+
+// BatchItemError is why one seedling in a batch was rejected. Index is its
+// position in the request.
+type BatchItemError struct {
+	Index   int         `json:"index"`
+	Name    string      `json:"name,omitempty"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// SeedlingStatus is the part of a seedling a dashboard polls for.
+type SeedlingStatus struct {
+	Step       string `json:"step"`
+	LastError  string `json:"lastError,omitempty"`
+	ETASeconds int64  `json:"etaSeconds"`
+}
+
+func nameTakenMessage(deleted bool) string {
+	if deleted {
+		return "a deleted seedling with that name exists, restore it or delete it with ?hard=true"
+	}
+	return "a seedling with that name already exists"
+}
+
+// CreateSeedlings creates a batch of seedlings in one transaction. Every
+// seedling is validated first and the whole batch is rejected if any of them
+// is invalid or has a name that's taken, in the batch or already, or if its
+// builds don't fit in the build queue. It responds like CreateSeedling for
+// each of them: 202 while they're built, and with ?sync=true it waits for
+// those built straight away to get past protobufs, responding 200 if none
+// is still at it. Those that failed there are in the response at
+// SeedlingStepFailed.
+func (s *Server) CreateSeedlings(w http.ResponseWriter, r *http.Request) {
+	wait, ok := s.syncParam(w, r)
+	if !ok {
+		return
+	}
+	var seedlings []store.Seedling
+	invalid, err := decodeStrict(r.Body, &seedlings)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+	if len(seedlings) == 0 {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "at least one seedling is required", nil)
+		return
+	}
+	if len(seedlings) > BatchMaxSeedlings {
+		s.respondError(w, http.StatusRequestEntityTooLarge, pipeline.ErrCodeTooLarge,
+			fmt.Sprintf("a batch may create at most %d seedlings", BatchMaxSeedlings),
+			map[string]int{"max": BatchMaxSeedlings, "got": len(seedlings)})
+		return
+	}
+
+	if garden := mux.Vars(r)["garden"]; garden != "" {
+		for i := range seedlings {
+			seedlings[i].Garden = garden
+		}
+	}
+
+	itemErrs := []BatchItemError{}
+	names := []string{}
+	indexes := map[string]int{}
+	for i := range seedlings {
+		invalid, err := s.prepareSeedling(r.Context(), &seedlings[i])
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to validate seedling")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		if invalid != nil {
+			itemErrs = append(itemErrs, BatchItemError{
+				Index:   i,
+				Name:    seedlings[i].Name,
+				Code:    invalid.Code,
+				Message: invalid.Message,
+				Details: invalid.Details,
+			})
+			continue
+		}
+		name := seedlings[i].ResourceName()
+		if first, ok := indexes[name]; ok {
+			itemErrs = append(itemErrs, BatchItemError{
+				Index:   i,
+				Name:    seedlings[i].Name,
+				Code:    pipeline.ErrCodeConflict,
+				Message: fmt.Sprintf("name is also used by seedling %d in the batch", first),
+			})
+			continue
+		}
+		indexes[name] = i
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		taken, err := s.TakenNames(r.Context(), names)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get seedling names")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		for name, deleted := range taken {
+			itemErrs = append(itemErrs, BatchItemError{
+				Index:   indexes[name],
+				Name:    seedlings[indexes[name]].Name,
+				Code:    pipeline.ErrCodeConflict,
+				Message: nameTakenMessage(deleted),
+			})
+		}
+	}
+	if len(itemErrs) > 0 {
+		sort.Slice(itemErrs, func(i, j int) bool { return itemErrs[i].Index < itemErrs[j].Index })
+		s.respondError(w, http.StatusUnprocessableEntity, pipeline.ErrCodeValidation, "some seedlings in the batch are invalid", itemErrs)
+		return
+	}
+	vectors, duplicates, err := s.findDuplicates(r.Context(), seedlings)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to find duplicate seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	for i, seedling := range seedlings {
+		if len(duplicates[i]) > 0 && !seedling.IgnoreDuplicates {
+			itemErrs = append(itemErrs, BatchItemError{
+				Index:   i,
+				Name:    seedling.Name,
+				Code:    pipeline.ErrCodePossibleDuplicate,
+				Message: duplicateMessage(duplicates[i]),
+				Details: map[string][]SimilarSeedling{"matches": duplicates[i]},
+			})
+		}
+	}
+	if len(itemErrs) > 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodePossibleDuplicate, "some seedlings in the batch look like existing ones", itemErrs)
+		return
+	}
+
+	key, ok := s.checkLLMKey(w, r)
+	if !ok {
+		return
+	}
+	for i := range seedlings {
+		seedlings[i].LLMKeyID, seedlings[i].LLMKey = key.id, key.sealed
+		if seedlings[i].Plan != nil || seedlings[i].AutoApprove {
+			continue
+		}
+		ctx := llm.WithFixtureName(pipeline.WithLLM(r.Context(), key.provider.LLM), seedlings[i].Name)
+		plan, err := s.planSeedling(ctx, seedlings[i].Description)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to plan seedling")
+			s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to plan seedling",
+				map[string]int{"index": i})
+			return
+		}
+		seedlings[i].Plan = plan
+	}
+	builds := 0
+	for _, seedling := range seedlings {
+		if seedling.AutoApprove {
+			builds++
+		}
+	}
+	if !s.checkQueue(w, r, builds) {
+		return
+	}
+
+	if err := s.InTx(r.Context(), func(tx *sqlx.Tx) error {
+		for i := range seedlings {
+			if err := insertSeedling(r.Context(), tx, &seedlings[i]); err != nil {
+				return err
+			}
+			if vectors == nil {
+				continue
+			}
+			if err := s.storeEmbedding(r.Context(), tx, seedlings[i].ID, vectors[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); store.SQLiteUnique(err) {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "a seedling of the batch took a name since it was checked", nil)
+		return
+	} else if err != nil {
+		s.log.WithField("error", err).Error("failed to insert seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	// The seedlings exist now, so one whose repo can't be written is failed
+	// rather than failing the rest of the batch.
+	events := make([]<-chan pipeline.SeedlingEvent, len(seedlings))
+	for i, seedling := range seedlings {
+		s.Emit(r.Context(), seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventCreated, Step: seedling.Step})
+		ctx := s.BuildRegistry.Detach(r.Context(), seedling)
+		if err := s.WriteSeedlingToRepo(ctx, seedling); err != nil {
+			s.log.WithField("error", err).Error("failed to write seedling to repo")
+			s.FailSeedling(r.Context(), seedling, "failed to write seedling to repo: "+err.Error())
+			seedlings[i].Step = pipeline.SeedlingStepFailed
+			continue
+		}
+		if seedling.AutoApprove {
+			if wait {
+				var unsubscribe func()
+				events[i], unsubscribe = s.Events.Subscribe(seedling.ID)
+				defer unsubscribe()
+			}
+			s.SubmitBuild(ctx, seedling)
+		}
+	}
+
+	status := http.StatusAccepted
+	if wait {
+		status = s.awaitFirstSteps(r.Context(), events, seedlings)
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+	accepted := make([]acceptedSeedling, len(seedlings))
+	for i := range seedlings {
+		accepted[i] = newAcceptedSeedling(&seedlings[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(&accepted); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// awaitFirstSteps is awaitFirstStep for each seedling of a batch with
+// events, all waiting at once, updating the seedlings. It returns 200 if
+// none is still at its first step, 202 otherwise.
+func (s *Server) awaitFirstSteps(ctx context.Context, events []<-chan pipeline.SeedlingEvent, seedlings []store.Seedling) int {
+	var wg sync.WaitGroup
+	waiting := make([]bool, len(seedlings))
+	for i := range seedlings {
+		if events[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			current, done, err := s.awaitFirstStep(ctx, events[i], seedlings[i])
+			if err != nil && ctx.Err() == nil {
+				s.log.WithField("error", err).Error("failed to wait for seedling build")
+			}
+			seedlings[i], waiting[i] = current, !done
+		}(i)
+	}
+	wg.Wait()
+	for _, w := range waiting {
+		if w {
+			return http.StatusAccepted
+		}
+	}
+	return http.StatusOK
+}
+
+// SeedlingStatuses returns the status of each seedling in ?ids=, keyed by
+// the ids as given. Seedlings that don't exist are left out.
+func (s *Server) SeedlingStatuses(w http.ResponseWriter, r *http.Request) {
+	params := []string{}
+	for _, param := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if param = strings.TrimSpace(param); param != "" {
+			params = append(params, param)
+		}
+	}
+	if len(params) == 0 {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "ids is required", nil)
+		return
+	}
+	if len(params) > StatusMaxIDs {
+		s.respondError(w, http.StatusRequestEntityTooLarge, pipeline.ErrCodeTooLarge,
+			fmt.Sprintf("at most %d ids may be queried at once", StatusMaxIDs),
+			map[string]int{"max": StatusMaxIDs, "got": len(params)})
+		return
+	}
+	ids := []hide.Int64{}
+	requested := map[hide.Int64]string{}
+	for _, param := range params {
+		id, err := parseID(param)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), map[string]string{"id": param})
+			return
+		}
+		ids = append(ids, id)
+		requested[id] = param
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM seedlings WHERE id IN (?) AND deleted_at IS NULL", ids)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to build query")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	seedlings := []store.Seedling{}
+	if err := s.Reads.SelectContext(r.Context(), &seedlings, s.Reads.Rebind(query), args...); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	ptrs := make([]*store.Seedling, len(seedlings))
+	for i := range seedlings {
+		ptrs[i] = &seedlings[i]
+	}
+	if err := s.attachETAs(r.Context(), ptrs); err != nil {
+		s.log.WithField("error", err).Error("failed to compute seedling etas")
+	}
+
+	statuses := map[string]SeedlingStatus{}
+	for _, seedling := range seedlings {
+		statuses[requested[seedling.ID]] = SeedlingStatus{
+			Step:       seedling.Step,
+			LastError:  seedling.FailureReason,
+			ETASeconds: seedling.ETASeconds,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&statuses); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/blobs"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+func (s *Server) setupBlobs() {
+	if err := s.blobs.Ensure(context.Background()); err != nil {
+		s.log.WithField("error", err).Fatal("Failed to set up the blob store")
+	}
+}
+
+// seedlingOutputsPrefix is the prefix of the keys of a seedling's outputs.
+func seedlingOutputsPrefix(name string) string {
+	return "outputs/" + name + "/"
+}
+
+func seedlingArchiveKey(name string) string {
+	return "archive/" + name + ".tar.gz"
+}
+
+// publishOutputs puts the files the seedling's container wrote to /outputs
+// that are new or changed since they were last put into the blob store.
+// With the local store they're already in it.
+func (s *Server) publishOutputs(ctx context.Context, name string) error {
+	if s.Config.BlobStore == pipeline.BlobStoreLocal {
+		return nil
+	}
+	root := s.SeedlingOutputsDir(name)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		key := seedlingOutputsPrefix(name) + filepath.ToSlash(rel)
+		stored, err := s.blobs.Stat(ctx, key)
+		if err != nil && !errors.Is(err, blobs.ErrNotFound) {
+			return err
+		}
+		if err == nil && stored.Size == info.Size() && !info.ModTime().After(stored.ModifiedAt) {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return s.blobs.Put(ctx, key, f, info.Size())
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// publishAllOutputs publishes the outputs of every seedling that can still
+// be writing them.
+func (s *Server) publishAllOutputs(ctx context.Context) error {
+	if s.Config.BlobStore == pipeline.BlobStoreLocal {
+		return nil
+	}
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(ctx, &seedlings,
+		"SELECT * FROM seedlings WHERE NOT archived AND deleted_at IS NULL"); err != nil {
+		return err
+	}
+	for _, seedling := range seedlings {
+		if err := s.publishOutputs(ctx, seedling.ResourceName()); err != nil {
+			return fmt.Errorf("publishing outputs of %s: %w", seedling.Name, err)
+		}
+	}
+	return nil
+}
+
+// ServeOutput serves a file from /outputs/{name}/{path} out of the blob
+// store, redirecting to a signed URL when the store can sign them.
+func (s *Server) ServeOutput(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(r.URL.Path, "/outputs/")
+	name, _, _ := strings.Cut(rel, "/")
+	if name == "" || path.Clean("/"+rel) != "/"+rel {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "output not found", nil)
+		return
+	}
+	if err := s.publishOutputs(r.Context(), name); err != nil {
+		s.log.WithField("error", err).Error("failed to publish seedling outputs")
+	}
+	key := "outputs/" + rel
+
+	url, err := s.blobs.SignedURL(r.Context(), key, s.Config.S3URLExpiry)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to sign output URL")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if url != "" {
+		if _, err := s.blobs.Stat(r.Context(), key); errors.Is(err, blobs.ErrNotFound) {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "output not found", nil)
+			return
+		}
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+
+	blob, info, err := s.blobs.Get(r.Context(), key)
+	if errors.Is(err, blobs.ErrNotFound) {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "output not found", nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to open output")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	defer blob.Close()
+	http.ServeContent(w, r, path.Base(key), info.ModifiedAt, blob)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/blobs"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// signingStore is a blob store that signs URLs, like an S3 bucket, kept in
+// a directory apart from DATA_DIR.
+type signingStore struct {
+	blobs.BlobStore
+}
+
+func (s signingStore) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "https://bucket.example.com/" + key + "?expires=" + expiry.String(), nil
+}
+
+// writeOutput writes a file to the seedling's /outputs the way its
+// container does.
+func writeOutput(t *testing.T, s *Server, name, rel, data string) {
+	t.Helper()
+	file := filepath.Join(s.SeedlingOutputsDir(name), filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeOutputLocal(t *testing.T) {
+	s, _ := testServer(t)
+	h := s.Routes()
+	writeOutput(t, s, "echo", "reports/result.txt", "0123456789")
+
+	w := serve(h, "GET", "/outputs/echo/reports/result.txt", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	req := newRequest("GET", "/outputs/echo/reports/result.txt", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	if w := serveRequest(h, req); w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("range: %d %s", w.Code, w.Body)
+	}
+
+	for _, target := range []string{"/outputs/echo/missing.txt", "/outputs/echo/reports", "/outputs/"} {
+		w := serve(h, "GET", target, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: %d %s", target, w.Code, w.Body)
+		}
+	}
+	// Paths outside of the outputs aren't served, whatever the router
+	// would make of them.
+	w = httptest.NewRecorder()
+	s.ServeOutput(w, httptest.NewRequest("GET", "/outputs/echo/../../garden.db", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("escaping path: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestServeOutputSigned(t *testing.T) {
+	s, _ := testServer(t)
+	bucket := blobs.NewLocal(t.TempDir())
+	s.blobs = signingStore{bucket}
+	s.Config.BlobStore = pipeline.BlobStoreS3
+	s.Config.S3URLExpiry = 15 * time.Minute
+	h := s.Routes()
+	ctx := context.Background()
+	writeOutput(t, s, "echo", "result.txt", "first")
+
+	// The output is published first, then downloaded from the bucket.
+	w := serve(h, "GET", "/outputs/echo/result.txt", nil)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://bucket.example.com/outputs/echo/result.txt?expires=15m0s" {
+		t.Errorf("redirected to %s", loc)
+	}
+	if info, err := bucket.Stat(ctx, "outputs/echo/result.txt"); err != nil || info.Size != 5 {
+		t.Errorf("published %+v: %v", info, err)
+	}
+
+	// What the container changes is published again.
+	writeOutput(t, s, "echo", "result.txt", "second run")
+	serve(h, "GET", "/outputs/echo/result.txt", nil)
+	if info, err := bucket.Stat(ctx, "outputs/echo/result.txt"); err != nil || info.Size != 10 {
+		t.Errorf("republished %+v: %v", info, err)
+	}
+
+	if w := serve(h, "GET", "/outputs/echo/missing.txt", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing output: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestArchiveInBlobStore(t *testing.T) {
+	s, env := testServer(t)
+	bucket := blobs.NewLocal(t.TempDir())
+	s.blobs = signingStore{bucket}
+	s.Config.BlobStore = pipeline.BlobStoreS3
+	ctx := context.Background()
+	seedling := env.Seedling(t, "echo")
+	dir := s.RepoDir(seedling)
+	if err := ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("An echo service."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "secrets"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secrets", "TOKEN"), []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.writeArchive(ctx, seedling); err != nil {
+		t.Fatal(err)
+	}
+	key := seedlingArchiveKey(seedling.ResourceName())
+	if _, err := bucket.Stat(ctx, key); err != nil {
+		t.Fatalf("the archive isn't in the bucket: %v", err)
+	}
+	if _, err := os.Stat(s.Config.DataPath(pipeline.BlobRoot, filepath.FromSlash(key))); !os.IsNotExist(err) {
+		t.Errorf("the archive was written under DATA_DIR: %v", err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.extractArchive(ctx, seedling); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "README.md")); err != nil || string(data) != "An echo service." {
+		t.Errorf("README.md after unarchiving: %q %v", data, err)
+	}
+	if !pipeline.HasOwnRepo(dir) {
+		t.Error("the repo's history wasn't archived")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secrets")); !os.IsNotExist(err) {
+		t.Errorf("secrets were archived: %v", err)
+	}
+
+	if err := bucket.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.extractArchive(ctx, seedling); !errors.Is(err, blobs.ErrNotFound) {
+		t.Errorf("unarchiving without an archive: %v", err)
+	}
+}
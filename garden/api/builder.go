@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// setupBuilder creates the module cache and builds the default builder image
+// of GoToolchain if it isn't there yet. Images of the other versions are
+// built when a seedling first needs them.
+func (s *Server) setupBuilder() error {
+	cfg := s.Config
+	// docker would create a missing bind mount owned by root, which builds
+	// running as garden's user couldn't write to.
+	if err := os.MkdirAll(cfg.ModCacheDir, 0755); err != nil {
+		return err
+	}
+	switch cfg.BuildRunner {
+	case pipeline.BuildRunnerHost:
+		return nil
+	case pipeline.BuildRunnerDocker:
+	default:
+		return fmt.Errorf("unknown BUILD_RUNNER %q, want %q or %q", cfg.BuildRunner, pipeline.BuildRunnerDocker, pipeline.BuildRunnerHost)
+	}
+	return pipeline.NewBuilderImages(s.Docker).Ensure(context.Background(), cfg.BuilderImage, cfg.GoToolchain)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+type QueuedBuild struct {
+	SeedlingID hide.Int64 `json:"seedlingId"`
+	Name       string     `json:"name"`
+	Step       string     `json:"step"`
+	// Position is 1 for the next build a worker picks up.
+	Position int `json:"position"`
+}
+
+type BuildsReport struct {
+	Active []pipeline.ActiveBuild `json:"active"`
+	Queued []QueuedBuild          `json:"queued"`
+}
+
+// Builds lists the builds running in this process and those waiting for a
+// worker.
+func (s *Server) Builds(w http.ResponseWriter, r *http.Request) {
+	report := BuildsReport{Active: s.BuildRegistry.List(), Queued: []QueuedBuild{}}
+	for i, seedling := range s.Scheduler.Queued() {
+		report.Queued = append(report.Queued, QueuedBuild{
+			SeedlingID: seedling.ID,
+			Name:       seedling.Name,
+			Step:       seedling.Step,
+			Position:   i + 1,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&report); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// KillBuild stops the seedling's build and marks the seedling failed.
+func (s *Server) KillBuild(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.BuildRegistry.Kill(seedling.ID) {
+		lease, err := s.BuildRegistry.Lease(r.Context(), seedling.ID)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get build lease")
+		}
+		if lease != nil {
+			s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is being built by another process", lease)
+			return
+		}
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling isn't being built", nil)
+		return
+	}
+	s.FailSeedling(r.Context(), seedling, "killed by admin")
+	pipeline.LoggerFromContext(r.Context()).
+		WithField("name", seedling.Name).
+		WithField("api_key", pipeline.APIKeyFromContext(r.Context())).
+		Warn("Build killed")
+
+	w.WriteHeader(http.StatusNoContent)
+}
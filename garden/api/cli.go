@@ -0,0 +1,447 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+	"github.com/urfave/cli"
+)
+
+// clientFlags are shared by the seedling subcommands. Without --server they
+// use the local database and build in-process.
+var clientFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "server",
+		Usage:  "garden API to use, e.g. https://garden.example.com; the local database if empty",
+		EnvVar: "GARDEN_SERVER",
+	},
+	cli.StringFlag{
+		Name:   "api-key",
+		Usage:  "API key for --server",
+		EnvVar: "GARDEN_API_KEY",
+	},
+	cli.BoolFlag{
+		Name:  "json",
+		Usage: "print JSON instead of text",
+	},
+}
+
+// apiClient is how the seedling subcommands reach a garden API. Local mode
+// serves the same routes from the local database on a loopback port, so both
+// modes run the same handlers.
+type apiClient struct {
+	url    string
+	apiKey string
+	http   *http.Client
+	// local is set when the API, and so any build it starts, runs in this
+	// process. lock holds DATA_DIR while it builds.
+	local bool
+	lock  *os.File
+}
+
+// newAPIClient returns the client for --server, or starts the API locally.
+// build sets the local API up to run the pipeline as well as read seedlings.
+func newAPIClient(cliCtx *cli.Context, log *logrus.Entry, cfg pipeline.Config, build bool) (*apiClient, error) {
+	if server := cliCtx.String("server"); server != "" {
+		return &apiClient{
+			url:    strings.TrimSuffix(server, "/"),
+			apiKey: cliCtx.String("api-key"),
+			http:   &http.Client{},
+		}, nil
+	}
+
+	var lock *os.File
+	if build {
+		var err error
+		if lock, err = lockDataDir(cfg); err != nil {
+			return nil, err
+		}
+	}
+	db, err := store.OpenDB(store.SQLiteDSN(cfg.DBPath(), cfg.SQLiteBusyTimeout, false))
+	if err != nil {
+		return nil, err
+	}
+	reads, err := store.OpenReadDB(store.SQLiteDSN(cfg.DBPath(), cfg.SQLiteBusyTimeout, true), cfg.SQLiteReadConns)
+	if err != nil {
+		return nil, err
+	}
+	if build {
+		if err := setupRepos(context.Background(), cfg); err != nil {
+			return nil, err
+		}
+	}
+	// Whoever can run this can read the database, so API keys would only
+	// get in the way.
+	cfg.APIKeys = nil
+	provider, err := newLLM(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := NewServer(db, cfg, log, provider)
+	s.Reads = reads
+	if build {
+		s.setupDocker()
+		if err := s.setupBuilder(); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := http.Serve(ln, s.Routes()); err != nil {
+			log.WithField("error", err).Error("local API stopped")
+		}
+	}()
+	return &apiClient{url: "http://" + ln.Addr().String(), http: &http.Client{}, local: true, lock: lock}, nil
+}
+
+func (c *apiClient) request(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set(pipeline.APIKeyHeader, c.apiKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var envelope ErrorEnvelope
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || envelope.Error.Message == "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return nil, fmt.Errorf("%s (%s)", envelope.Error.Message, envelope.Error.Code)
+	}
+	return resp, nil
+}
+
+// do sends body as JSON, if any, and decodes the response into out.
+func (c *apiClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// seedlingByName finds a seedling by its exact name.
+func (c *apiClient) seedlingByName(ctx context.Context, name string) (store.Seedling, error) {
+	seedlings := []store.Seedling{}
+	if err := c.do(ctx, "GET", "/api/v1/seedlings?name="+url.QueryEscape(name), nil, &seedlings); err != nil {
+		return store.Seedling{}, err
+	}
+	if len(seedlings) == 0 {
+		return store.Seedling{}, fmt.Errorf("no seedling named %q", name)
+	}
+	return seedlings[0], nil
+}
+
+// events subscribes to the seedling's build events. The stream is
+// subscribed to by the time it's returned.
+func (c *apiClient) events(ctx context.Context, id hide.Int64) (io.ReadCloser, error) {
+	resp, err := c.request(ctx, "GET", pipeline.SeedlingPath(id)+"/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// readEvents calls onEvent with each event read from stream until it returns
+// false.
+func readEvents(stream io.Reader, onEvent func(pipeline.SeedlingEvent) bool) error {
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event pipeline.SeedlingEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			return err
+		}
+		if !onEvent(event) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("event stream ended before the build did")
+}
+
+// waitForBuild prints the seedling's step changes until its build completes
+// or fails, returning an error if it fails.
+func (c *apiClient) waitForBuild(ctx context.Context, seedling store.Seedling, asJSON bool) error {
+	stream, err := c.events(ctx, seedling.ID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	// The build may have finished before the subscription was made.
+	if err := c.do(ctx, "GET", pipeline.SeedlingPath(seedling.ID), nil, &seedling); err != nil {
+		return err
+	}
+	if !buildFinished(seedling.Step) {
+		if err := readEvents(stream, func(event pipeline.SeedlingEvent) bool {
+			switch event.Type {
+			case pipeline.EventCompletionChunk, pipeline.EventCompletionDone, pipeline.EventBuildOutput:
+				return true
+			}
+			printEvent(event, asJSON)
+			return event.Type != pipeline.EventCompleted && event.Type != pipeline.EventFailed &&
+				!(event.Type == pipeline.EventStepChanged &&
+					(event.Step == pipeline.SeedlingStepAwaitingHooks || event.Step == pipeline.SeedlingStepAwaitingApproval))
+		}); err != nil {
+			return err
+		}
+		if err := c.do(ctx, "GET", pipeline.SeedlingPath(seedling.ID), nil, &seedling); err != nil {
+			return err
+		}
+	}
+
+	if asJSON {
+		printJSON(seedling)
+	}
+	switch seedling.Step {
+	case pipeline.SeedlingStepFailed:
+		msg := "build failed"
+		if seedling.FailedStep != "" {
+			msg += " at " + seedling.FailedStep
+		}
+		if seedling.FailureReason != "" {
+			msg += ": " + seedling.FailureReason
+		}
+		return errors.New(msg)
+	case pipeline.SeedlingStepPlan:
+		return errors.New("seedling is waiting for its plan to be approved, create it with --auto-approve to build it straight away")
+	case pipeline.SeedlingStepAwaitingConfig:
+		missing := []string{}
+		for _, env := range seedling.Env {
+			if env.Required && !env.Provided {
+				missing = append(missing, env.Name)
+			}
+		}
+		return fmt.Errorf("seedling is built, set %s with PUT %s/env to start it", strings.Join(missing, ", "), pipeline.SeedlingPath(seedling.ID))
+	case pipeline.SeedlingStepAwaitingHooks:
+		return fmt.Errorf("seedling is running but a blocking hook failed, see GET %[1]s/hooks and retry them with POST %[1]s/hooks/retry", pipeline.SeedlingPath(seedling.ID))
+	case pipeline.SeedlingStepAwaitingApproval:
+		return fmt.Errorf("seedling is waiting for its %s to be approved, review GET %[2]s/files and POST %[2]s/approve or %[2]s/reject",
+			seedling.ApprovalStep, pipeline.SeedlingPath(seedling.ID))
+	}
+	if !asJSON {
+		fmt.Printf("%s is complete\n", seedling.Name)
+	}
+	return nil
+}
+
+// buildFinished reports whether a seedling at step has nothing left to build
+// until someone acts on it.
+func buildFinished(step string) bool {
+	return step == pipeline.SeedlingStepComplete || step == pipeline.SeedlingStepFailed || step == pipeline.SeedlingStepPlan ||
+		step == pipeline.SeedlingStepAwaitingConfig || step == pipeline.SeedlingStepAwaitingHooks || step == pipeline.SeedlingStepAwaitingApproval
+}
+
+func printEvent(event pipeline.SeedlingEvent, asJSON bool) {
+	if asJSON {
+		printJSON(event)
+		return
+	}
+	switch event.Type {
+	case pipeline.EventStepChanged:
+		fmt.Printf("%s  %s\n", time.Now().Format("15:04:05"), event.Step)
+	case pipeline.EventFailed:
+		fmt.Printf("%s  failed at %s\n", time.Now().Format("15:04:05"), event.Step)
+	case pipeline.EventCompleted:
+		fmt.Printf("%s  %s\n", time.Now().Format("15:04:05"), pipeline.SeedlingStepComplete)
+	}
+}
+
+func printJSON(v interface{}) {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		logrus.WithField("error", err).Error("failed to encode output")
+	}
+}
+
+func seedlingCreateCmd(cliCtx *cli.Context, log *logrus.Entry, cfg pipeline.Config) error {
+	if cliCtx.String("name") == "" || cliCtx.String("description") == "" {
+		return errors.New("--name and --description are required")
+	}
+	c, err := newAPIClient(cliCtx, log, cfg, true)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	seedling := store.Seedling{
+		Name:        cliCtx.String("name"),
+		Description: cliCtx.String("description"),
+		Tags:        cliCtx.StringSlice("tag"),
+		DependsOn:   cliCtx.StringSlice("depends-on"),
+		AutoApprove: cliCtx.Bool("auto-approve"),
+	}
+	if err := c.do(ctx, "POST", "/api/v1/seedlings", &seedling, &seedling); err != nil {
+		return err
+	}
+	// A local build runs in this process, so it's waited for either way.
+	if !cliCtx.Bool("wait") && !c.local {
+		if cliCtx.Bool("json") {
+			printJSON(seedling)
+		} else {
+			fmt.Printf("created %s at %s\n", seedling.Name, seedling.Step)
+		}
+		return nil
+	}
+	return c.waitForBuild(ctx, seedling, cliCtx.Bool("json"))
+}
+
+func seedlingListCmd(cliCtx *cli.Context, log *logrus.Entry, cfg pipeline.Config) error {
+	c, err := newAPIClient(cliCtx, log, cfg, false)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	if q := cliCtx.String("q"); q != "" {
+		query.Set("q", q)
+	}
+	for _, tag := range cliCtx.StringSlice("tag") {
+		query.Add("tag", tag)
+	}
+	seedlings := []store.Seedling{}
+	if err := c.do(context.Background(), "GET", "/api/v1/seedlings?"+query.Encode(), nil, &seedlings); err != nil {
+		return err
+	}
+	if cliCtx.Bool("json") {
+		printJSON(seedlings)
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTEP\tMODIFIED")
+	for _, seedling := range seedlings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", seedling.Name, seedling.Step, seedling.ModifiedAt.Local().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func seedlingLogsCmd(cliCtx *cli.Context, log *logrus.Entry, cfg pipeline.Config) error {
+	name := cliCtx.Args().First()
+	if name == "" {
+		return errors.New("usage: seedling logs <name>")
+	}
+	c, err := newAPIClient(cliCtx, log, cfg, false)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	seedling, err := c.seedlingByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("tail", cliCtx.String("tail"))
+	if cliCtx.Bool("follow") {
+		query.Set("follow", "true")
+	}
+	resp, err := c.request(ctx, "GET", pipeline.SeedlingPath(seedling.ID)+"/logs?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !cliCtx.Bool("json") {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	// Plain text lines are labeled "<stream>: ".
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		stream, text, _ := strings.Cut(scanner.Text(), ": ")
+		printJSON(map[string]string{"stream": stream, "text": text})
+	}
+	return scanner.Err()
+}
+
+// SeedlingCommand is the seedling subcommands, which do from a terminal or CI
+// what the HTTP API does.
+func SeedlingCommand(log *logrus.Entry, cfg pipeline.Config) cli.Command {
+	return cli.Command{
+		Name:  "seedling",
+		Usage: "Create, list and read the logs of seedlings",
+		Subcommands: []cli.Command{
+			{
+				Name:  "create",
+				Usage: "Create a seedling",
+				Flags: append([]cli.Flag{
+					cli.StringFlag{Name: "name", Usage: "seedling name"},
+					cli.StringFlag{Name: "description", Usage: "what the seedling's service does"},
+					cli.StringSliceFlag{Name: "tag", Usage: "tag the seedling, may be repeated"},
+					cli.StringSliceFlag{Name: "depends-on", Usage: "name of a complete seedling the seedling calls, may be repeated"},
+					cli.BoolFlag{Name: "auto-approve", Usage: "build without waiting for the plan to be approved"},
+					cli.BoolFlag{Name: "wait", Usage: "print step changes until the build ends, failing if it fails"},
+				}, clientFlags...),
+				Action: func(cliCtx *cli.Context) error {
+					return seedlingCreateCmd(cliCtx, log, cfg)
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List seedlings",
+				Flags: append([]cli.Flag{
+					cli.StringFlag{Name: "q", Usage: "full-text search over names and descriptions"},
+					cli.StringSliceFlag{Name: "tag", Usage: "only seedlings with the tag, may be repeated"},
+				}, clientFlags...),
+				Action: func(cliCtx *cli.Context) error {
+					return seedlingListCmd(cliCtx, log, cfg)
+				},
+			},
+			{
+				Name:      "logs",
+				Usage:     "Print a complete seedling's container logs",
+				ArgsUsage: "<name>",
+				Flags: append([]cli.Flag{
+					cli.BoolFlag{Name: "follow, f", Usage: "stream new lines as they're logged"},
+					cli.StringFlag{Name: "tail", Value: "100", Usage: `lines to print from the end, or "all"`},
+				}, clientFlags...),
+				Action: func(cliCtx *cli.Context) error {
+					return seedlingLogsCmd(cliCtx, log, cfg)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/dockerx"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// ContainerStateMissing is the state of a seedling without a container.
+	ContainerStateMissing = dockerx.StateMissing
+	// ContainerStatesTTL is how long container states are cached for, so
+	// list views don't run docker for every seedling on every poll.
+	ContainerStatesTTL = 5 * time.Second
+	// ContainerActionTimeout is how long an action waits for the container
+	// to reach the state it asked for.
+	ContainerActionTimeout = 30 * time.Second
+)
+
+var (
+	// containerActions maps each container action to the state it leaves
+	// the container in.
+	containerActions = map[string]string{
+		"stop":    "exited",
+		"start":   "running",
+		"restart": "running",
+	}
+	errNoContainer = errors.New("seedling has no container")
+)
+
+// ContainerStateCache holds the state of every container, by name, as of at.
+type ContainerStateCache struct {
+	mu     sync.Mutex
+	states map[string]string
+	at     time.Time
+}
+
+// get returns the state of every container, listing them again if the
+// cached states are older than ContainerStatesTTL.
+func (c *ContainerStateCache) get(ctx context.Context, docker dockerx.ContainerRuntime) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.states != nil && time.Since(c.at) < ContainerStatesTTL {
+		return c.states, nil
+	}
+	states, err := docker.States(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.states = states
+	c.at = time.Now()
+	return states, nil
+}
+
+// invalidate makes the next get list the containers again.
+func (c *ContainerStateCache) invalidate() {
+	c.mu.Lock()
+	c.states = nil
+	c.mu.Unlock()
+}
+
+// attachContainerStates sets ContainerState on each seedling.
+func (s *Server) attachContainerStates(ctx context.Context, seedlings []*store.Seedling) error {
+	states, err := s.containers.get(ctx, s.Docker)
+	if err != nil {
+		return err
+	}
+	for _, seedling := range seedlings {
+		seedling.ContainerState = ContainerStateMissing
+		if state, ok := states[s.ContainerName(*seedling)]; ok {
+			seedling.ContainerState = state
+		}
+	}
+	return nil
+}
+
+// containerAction runs the action on the seedling's container and waits for
+// it to reach the state the action leaves it in, which it returns. Starting a
+// seedling whose container was removed runs its image again.
+func (s *Server) containerAction(ctx context.Context, seedling *store.Seedling, action string) (string, error) {
+	defer s.containers.invalidate()
+	state, err := s.Docker.State(ctx, s.ContainerName(*seedling))
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case state == ContainerStateMissing && action == "stop":
+		return state, errNoContainer
+	case state == ContainerStateMissing:
+		_, err := s.StartSeedlingContainer(ctx, seedling, false)
+		if errors.Is(err, dockerx.ErrImageMissing) && seedling.Step == pipeline.SeedlingStepComplete {
+			err = s.rebuildMissingImage(ctx, seedling)
+		}
+		if err != nil {
+			return state, err
+		}
+	default:
+		if err := s.runContainerAction(ctx, action, s.ContainerName(*seedling)); err != nil {
+			return state, err
+		}
+	}
+
+	want := containerActions[action]
+	if state, err = dockerx.WaitForState(ctx, s.Docker, s.ContainerName(*seedling), want); err != nil {
+		return state, fmt.Errorf("container didn't become %s: %w", want, err)
+	}
+	// Ports are published anew each time the container starts.
+	if want == "running" {
+		if err := s.RefreshPorts(ctx, seedling); err != nil {
+			s.log.WithField("error", err).Error("failed to refresh seedling ports")
+		}
+	}
+	return state, nil
+}
+
+// runContainerAction runs the action on the named container.
+func (s *Server) runContainerAction(ctx context.Context, action, name string) error {
+	switch action {
+	case "stop":
+		return s.Docker.Stop(ctx, name)
+	case "start":
+		return s.Docker.Start(ctx, name)
+	case "restart":
+		return s.Docker.Restart(ctx, name)
+	}
+	return fmt.Errorf("unknown container action %q", action)
+}
+
+// respondContainerError responds with the error of a container operation
+// that failed for a reason the client can act on, returning false for other
+// errors.
+func (s *Server) respondContainerError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, errNoContainer), errors.Is(err, dockerx.ErrNoContainer):
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, errNoContainer.Error(), nil)
+	case errors.Is(err, dockerx.ErrNameConflict):
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeNameConflict,
+			"another container has the seedling's container's name, remove it and retry", nil)
+	case errors.Is(err, dockerx.ErrImageMissing):
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeImageMissing,
+			"the seedling's image is missing, rebuild the seedling", nil)
+	default:
+		return false
+	}
+	return true
+}
+
+// SeedlingContainerAction stops, starts or restarts a complete seedling's
+// container. The seedling's step is left as it is.
+func (s *Server) SeedlingContainerAction(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	action := mux.Vars(r)["action"]
+	if _, ok := containerActions[action]; !ok {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "action must be one of stop, start or restart",
+			map[string]string{"action": action})
+		return
+	}
+	if seedling.Archived {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is archived", nil)
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling isn't complete", map[string]string{"step": seedling.Step})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), ContainerActionTimeout)
+	defer cancel()
+	state, err := s.containerAction(ctx, &seedling, action)
+	if s.respondContainerError(w, err) {
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to " + action + " seedling container")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to "+action+" container",
+			map[string]string{"state": state, "error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	by := pipeline.APIKeyFromContext(ctx)
+	if _, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET container_action = $1, container_action_by = $2, container_action_at = $3
+	 WHERE id = $4
+	 `, action, by, now, seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to record container action")
+	}
+	pipeline.LoggerFromContext(ctx).WithField("name", seedling.Name).
+		WithField("action", action).
+		WithField("by", by).
+		Info("Ran seedling container action")
+	s.Emit(ctx, seedling.ID, pipeline.SeedlingEvent{
+		Type:    pipeline.EventContainerAction,
+		Step:    seedling.Step,
+		Payload: pipeline.EventPayload{"action": action, "state": state},
+	})
+
+	seedling.ContainerState = state
+	seedling.ContainerAction = action
+	seedling.ContainerActionBy = by
+	seedling.ContainerActionAt = &now
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
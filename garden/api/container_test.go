@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/pipelinetest"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// completeSeedling is a seedling built to completion with env's fakes, so
+// its container is running.
+func completeSeedling(t *testing.T, env *pipelinetest.Env, name string) store.Seedling {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	seedling, err := pipeline.Run(ctx, env.Deps, env.Seedling(t, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", seedling.Step, seedling.FailureReason)
+	}
+	return seedling
+}
+
+func TestSeedlingContainerAction(t *testing.T) {
+	s, env := testServer(t)
+	seedling := completeSeedling(t, env, "echo")
+	path := pipeline.SeedlingPath(seedling.ID)
+	h := s.Routes()
+
+	ports := map[int]bool{}
+	for _, tt := range []struct{ action, state string }{
+		{"stop", "exited"},
+		{"start", "running"},
+		{"restart", "running"},
+		{"stop", "exited"},
+	} {
+		w := serve(h, "POST", path+"/container/"+tt.action, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tt.action, w.Code, w.Body)
+		}
+		var got store.Seedling
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ContainerState != tt.state || got.ContainerAction != tt.action || got.ContainerActionAt == nil {
+			t.Errorf("%s: container %s after %s at %v", tt.action, got.ContainerState, got.ContainerAction, got.ContainerActionAt)
+		}
+
+		w = serve(h, "GET", path, nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Step != pipeline.SeedlingStepComplete {
+			t.Errorf("%s: step is %s", tt.action, got.Step)
+		}
+		if got.ContainerState != tt.state {
+			t.Errorf("%s: GetSeedling has container %s, want %s", tt.action, got.ContainerState, tt.state)
+		}
+		if got.ContainerAction != tt.action {
+			t.Errorf("%s: recorded action %q", tt.action, got.ContainerAction)
+		}
+		if tt.state == "running" {
+			if got.HTTPPort == 0 || ports[got.HTTPPort] {
+				t.Errorf("%s: HTTP port %d wasn't refreshed", tt.action, got.HTTPPort)
+			}
+			ports[got.HTTPPort] = true
+		}
+	}
+
+	w := serve(h, "POST", path+"/container/pause", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("pause: %d %s", w.Code, w.Body)
+	}
+
+	building := env.Seedling(t, "building")
+	w = serve(h, "POST", pipeline.SeedlingPath(building.ID)+"/container/stop", nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("stop of an incomplete seedling: %d %s", w.Code, w.Body)
+	}
+}
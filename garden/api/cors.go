@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// corsPath is whether browsers from CORSAllowedOrigins may call a path: the
+// management API and outputs. Seedlings answer for their own proxied
+// requests.
+func corsPath(path string) bool {
+	return requiresAPIKey(path) || strings.HasPrefix(path, "/outputs/")
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsOrigin is the Access-Control-Allow-Origin to answer origin with, empty
+// if it isn't allowed.
+func (s *Server) corsOrigin(origin string) string {
+	for _, allowed := range s.Config.CORSAllowedOrigins {
+		if allowed == pipeline.CORSAnyOrigin {
+			return pipeline.CORSAnyOrigin
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// WithCORS answers preflight requests from CORS_ALLOWED_ORIGINS without
+// calling the handler and adds the Access-Control headers to their other
+// requests. It runs before WithAPIKey, since browsers don't send
+// credentials with preflights and can only read the 401s they get with the
+// headers. Preflights from other origins are refused, and their other
+// requests served without the headers, which browsers don't let the page
+// read.
+func (s *Server) WithCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(s.Config.CORSAllowedOrigins) == 0 || origin == "" || !corsPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := s.corsOrigin(origin)
+		if allowed == "" {
+			if isPreflight(r) {
+				s.respondError(w, http.StatusForbidden, pipeline.ErrCodeForbidden, "origin not allowed", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", allowed)
+		if s.Config.CORSAllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !isPreflight(r) {
+			if len(s.Config.CORSExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(s.Config.CORSExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", strings.Join(s.Config.CORSAllowedMethods, ", "))
+		if len(s.Config.CORSAllowedHeaders) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(s.Config.CORSAllowedHeaders, ", "))
+		}
+		if s.Config.CORSMaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(s.Config.CORSMaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
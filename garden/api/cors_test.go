@@ -0,0 +1,182 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+const appOrigin = "https://app.example.com"
+
+// corsServer is a server allowing appOrigin, with an API key required.
+func corsServer(t *testing.T) *Server {
+	t.Helper()
+	s, _ := testServer(t)
+	s.Config.APIKeys = map[string]string{"frontend": "frontend-key"}
+	s.Config.CORSAllowedOrigins = []string{"https://other.example.com", appOrigin}
+	if err := pipeline.CheckCORS(s.Config); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// preflight is the response of h to a browser's preflight of a method on
+// target from origin.
+func preflight(h http.Handler, target, origin, method string) *http.Response {
+	req := newRequest("OPTIONS", target, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	req.Header.Set("Access-Control-Request-Headers", "content-type, if-match")
+	return serveRequest(h, req).Result()
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := corsServer(t)
+	writeOutput(t, s, "echo", "result.txt", "done")
+	h := s.Routes()
+
+	// Preflights are answered before the API key is checked, which they're
+	// sent without, on routes of any method and on outputs.
+	for _, target := range []string{"/api/v1/seedlings", pipeline.SeedlingPath(1), "/api/v1/admin/settings", "/outputs/echo/result.txt"} {
+		resp := preflight(h, target, appOrigin, "PATCH")
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("preflight of %s: %d", target, resp.StatusCode)
+			continue
+		}
+		for name, want := range map[string]string{
+			"Access-Control-Allow-Origin":  appOrigin,
+			"Access-Control-Allow-Methods": strings.Join(pipeline.DefaultCORSMethods, ", "),
+			"Access-Control-Allow-Headers": strings.Join(pipeline.DefaultCORSHeaders, ", "),
+			"Access-Control-Max-Age":       "600",
+		} {
+			if got := resp.Header.Get(name); got != want {
+				t.Errorf("preflight of %s: %s %q, want %q", target, name, got, want)
+			}
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Errorf("preflight of %s allows credentials", target)
+		}
+		if vary := strings.Join(resp.Header.Values("Vary"), ", "); !strings.Contains(vary, "Origin") || !strings.Contains(vary, "Access-Control-Request-Method") {
+			t.Errorf("preflight of %s varies on %q", target, vary)
+		}
+	}
+
+	// Origins are matched whatever their case.
+	if resp := preflight(h, "/api/v1/seedlings", "HTTPS://APP.example.com", "POST"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight from the upper cased origin: %d", resp.StatusCode)
+	}
+
+	// An OPTIONS request that isn't a preflight goes to the router, which
+	// has no handler for it.
+	req := newRequest("OPTIONS", "/api/v1/seedlings", nil)
+	req.Header.Set("Origin", appOrigin)
+	req.Header.Set(pipeline.APIKeyHeader, "frontend-key")
+	if w := serveRequest(h, req); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("OPTIONS without a requested method: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	s := corsServer(t)
+	h := s.Routes()
+
+	resp := preflight(h, "/api/v1/seedlings", "https://evil.example.com", "POST")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("preflight: %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight allows %q", got)
+	}
+
+	// Its other requests are served as they would be, but without the
+	// headers that would let the page read them.
+	req := newRequest("GET", "/api/v1/seedlings", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set(pipeline.APIKeyHeader, "frontend-key")
+	w := serveRequest(h, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET: %d %s", w.Code, w.Body)
+	}
+	for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Expose-Headers", "Access-Control-Allow-Credentials"} {
+		if got := w.Header().Get(name); got != "" {
+			t.Errorf("GET: %s %q", name, got)
+		}
+	}
+	if vary := w.Header().Get("Vary"); vary != "Origin" {
+		t.Errorf("GET varies on %q", vary)
+	}
+}
+
+func TestCORSWithAPIKey(t *testing.T) {
+	s := corsServer(t)
+	s.Config.CORSAllowCredentials = true
+	h := s.Routes()
+
+	// A request without a key is refused by WithAPIKey after WithCORS has
+	// added its headers, so the page can read why.
+	req := newRequest("GET", "/api/v1/seedlings", nil)
+	req.Header.Set("Origin", appOrigin)
+	w := serveRequest(h, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET without a key: %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != appOrigin {
+		t.Errorf("the 401 allows %q", got)
+	}
+	checkErrorEnvelope(t, w.Header(), w.Body.Bytes())
+
+	req = newRequest("GET", "/api/v1/seedlings", nil)
+	req.Header.Set("Origin", appOrigin)
+	req.Header.Set("Authorization", "Bearer frontend-key")
+	w = serveRequest(h, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET with a key: %d %s", w.Code, w.Body)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":      appOrigin,
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Expose-Headers":    strings.Join(pipeline.DefaultCORSExposedHeaders, ", "),
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("GET: %s %q, want %q", name, got, want)
+		}
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("GET has the preflight's methods %q", got)
+	}
+
+	// The router's 404s have the headers too.
+	req = newRequest("GET", "/api/v1/no/such/route", nil)
+	req.Header.Set("Origin", appOrigin)
+	req.Header.Set("Authorization", "Bearer frontend-key")
+	w = serveRequest(h, req)
+	if w.Code != http.StatusNotFound || w.Header().Get("Access-Control-Allow-Origin") != appOrigin {
+		t.Errorf("unknown route: %d, allows %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSOff(t *testing.T) {
+	s, _ := testServer(t)
+	h := s.Routes()
+	resp := preflight(h, "/api/v1/seedlings", appOrigin, "POST")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("preflight: %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight allows %q", got)
+	}
+
+	// With any origin allowed, which needs API keys off, the response says
+	// so rather than naming the origin.
+	s.Config.CORSAllowedOrigins = []string{pipeline.CORSAnyOrigin}
+	if err := pipeline.CheckCORS(s.Config); err != nil {
+		t.Fatal(err)
+	}
+	h = s.Routes()
+	resp = preflight(h, "/api/v1/seedlings", appOrigin, "POST")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("preflight: %d, allows %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
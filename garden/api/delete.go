@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// includeDeleted reports whether a GET asked for soft-deleted seedlings too.
+func includeDeleted(r *http.Request) bool {
+	include, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted"))
+	return include && r.Method == http.MethodGet
+}
+
+// purgeSeedling permanently removes the seedling: its rows, secrets, repo,
+// container and image.
+func (s *Server) purgeSeedling(ctx context.Context, seedling store.Seedling) error {
+	if err := s.InTx(ctx, func(tx *sqlx.Tx) error {
+		for _, table := range []string{"seedling_attempts", "seedling_tags", "seedling_checkpoints", "seedling_messages", "quality_checks", "seedling_events", "seedling_examples", "seedling_env_requirements", "seedling_step_statuses", "seedling_dependencies", "seedling_rebuilds", "seedling_embeddings", "seedling_hooks", "seedling_module_reports", "seedling_revisions"} {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE seedling_id = $1", seedling.ID); err != nil {
+				return fmt.Errorf("deleting %s: %w", table, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM seedling_dependencies WHERE depends_on_id = $1", seedling.ID); err != nil {
+			return fmt.Errorf("deleting seedling_dependencies: %w", err)
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM seedlings WHERE id = $1", seedling.ID)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := shredSecrets(s.RepoDir(seedling)); err != nil {
+		return fmt.Errorf("shredding secrets: %w", err)
+	}
+	if err := shredDir(pipeline.SeedlingEnvDir(s.RepoDir(seedling))); err != nil {
+		return fmt.Errorf("shredding env: %w", err)
+	}
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM seedling_secrets WHERE seedling_id = $1", seedling.ID); err != nil {
+		return fmt.Errorf("deleting seedling_secrets: %w", err)
+	}
+
+	// Archived seedlings were already removed from the repo, and seedlings
+	// with their own repo don't need a commit recording the removal.
+	if seedling.Archived {
+		if err := s.blobs.Delete(ctx, seedlingArchiveKey(seedling.ResourceName())); err != nil {
+			return err
+		}
+	} else if pipeline.HasOwnRepo(s.RepoDir(seedling)) {
+		if err := os.RemoveAll(s.RepoDir(seedling)); err != nil {
+			return err
+		}
+	} else {
+		for _, args := range [][]string{{"rm", "-r", seedling.Name}, {"commit", "-am", "delete seedling"}} {
+			cmd := exec.CommandContext(ctx, "git", args...)
+			cmd.Dir = s.Config.SharedRepoDir()
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("git %s: %w", args[0], err)
+			}
+		}
+	}
+
+	if err := s.Docker.Remove(ctx, s.ContainerName(seedling)); err != nil {
+		return fmt.Errorf("removing container: %w", err)
+	}
+	// Archived seedlings have no image left.
+	s.Docker.RemoveImage(ctx, s.ContainerName(seedling))
+	s.containers.invalidate()
+	return nil
+}
+
+var errStopContainer = errors.New("failed to stop container")
+
+// softDeleteSeedling stops the seedling's container if it's running and
+// marks it deleted, returning when.
+func (s *Server) softDeleteSeedling(ctx context.Context, seedling *store.Seedling) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, ContainerActionTimeout)
+	defer cancel()
+	state, err := s.Docker.State(ctx, s.ContainerName(*seedling))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("inspecting container: %w", err)
+	}
+	running := state == "running"
+	if running {
+		if _, err := s.containerAction(ctx, seedling, "stop"); err != nil {
+			return time.Time{}, fmt.Errorf("%w: %v", errStopContainer, err)
+		}
+	}
+
+	now := time.Now()
+	if _, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET deleted_at = $1, deleted_while_running = $2
+	 WHERE id = $3 AND deleted_at IS NULL
+	 `, now, running, seedling.ID); err != nil {
+		return time.Time{}, err
+	}
+	pipeline.LoggerFromContext(ctx).WithField("name", seedling.Name).
+		WithField("deleted_by", pipeline.APIKeyFromContext(ctx)).
+		Info("Soft deleted seedling")
+	s.Emit(ctx, seedling.ID, pipeline.SeedlingEvent{
+		Type:    pipeline.EventDeleted,
+		Step:    seedling.Step,
+		Payload: pipeline.EventPayload{"whileRunning": running},
+	})
+	return now, nil
+}
+
+// DeleteSeedling soft-deletes a seedling: it's hidden from every other
+// endpoint and its container is stopped, until it's restored or purged.
+// With ?hard=true the seedling is removed for good straight away. Seedlings
+// other seedlings depend on are only deleted with ?force=true.
+func (s *Server) DeleteSeedling(w http.ResponseWriter, r *http.Request) {
+	hard, _ := strconv.ParseBool(r.URL.Query().Get("hard"))
+	seedling, ok := s.findSeedling(w, r, hard)
+	if !ok {
+		return
+	}
+	if force, _ := strconv.ParseBool(r.URL.Query().Get("force")); !force {
+		dependents, err := s.dependents(r.Context(), seedling.ID)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get seedling dependents")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		if len(dependents) > 0 {
+			s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict,
+				"other seedlings depend on this one, delete it with ?force=true to delete it anyway",
+				map[string][]string{"dependents": dependents})
+			return
+		}
+	}
+
+	if hard {
+		if err := s.purgeSeedling(r.Context(), seedling); err != nil {
+			s.log.WithField("error", err).Error("failed to delete seedling")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		pipeline.LoggerFromContext(r.Context()).WithField("name", seedling.Name).
+			WithField("deleted_by", pipeline.APIKeyFromContext(r.Context())).
+			Info("Deleted seedling")
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]string{"message": "seedling deleted"}); err != nil {
+			s.log.WithField("error", err).Error("failed to encode response")
+		}
+		return
+	}
+
+	now, err := s.softDeleteSeedling(r.Context(), &seedling)
+	if errors.Is(err, errStopContainer) {
+		s.log.WithField("error", err).Error("failed to stop seedling container")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to stop container", nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to delete seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"message":      "seedling deleted",
+		"restoreUntil": now.Add(s.Config.DeletedRetention).Format(time.RFC3339),
+	}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// RestoreSeedling undeletes a soft-deleted seedling within DeletedRetention
+// of its deletion, starting its container again if it was running.
+func (s *Server) RestoreSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.findSeedling(w, r, true)
+	if !ok {
+		return
+	}
+	if seedling.DeletedAt == nil {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling isn't deleted", nil)
+		return
+	}
+	if time.Since(*seedling.DeletedAt) > s.Config.DeletedRetention {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling was deleted too long ago to restore",
+			map[string]string{"deletedAt": seedling.DeletedAt.Format(time.RFC3339)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), ContainerActionTimeout)
+	defer cancel()
+	result, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET deleted_at = NULL, deleted_while_running = FALSE
+	 WHERE id = $1 AND deleted_at IS NOT NULL
+	 `, seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to restore seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling was already restored", nil)
+		return
+	}
+	if seedling.DeletedWhileRunning {
+		state, err := s.containerAction(ctx, &seedling, "start")
+		if err != nil {
+			// The seedling is back either way; its container can be
+			// started through the container endpoint.
+			s.log.WithField("error", err).Error("failed to start restored seedling container")
+		}
+		seedling.ContainerState = state
+	}
+	pipeline.LoggerFromContext(ctx).WithField("name", seedling.Name).
+		WithField("restored_by", pipeline.APIKeyFromContext(ctx)).
+		Info("Restored seedling")
+	s.Emit(ctx, seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventRestored, Step: seedling.Step})
+
+	seedling.DeletedAt = nil
+	seedling.DeletedWhileRunning = false
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// expiredDeletions returns the soft-deleted seedlings past DeletedRetention,
+// which the GC purges.
+func (s *Server) expiredDeletions(ctx context.Context) ([]store.Seedling, error) {
+	seedlings := []store.Seedling{}
+	err := s.DB.SelectContext(ctx, &seedlings,
+		"SELECT * FROM seedlings WHERE deleted_at IS NOT NULL AND deleted_at < $1",
+		time.Now().Add(-s.Config.DeletedRetention))
+	return seedlings, err
+}
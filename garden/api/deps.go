@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// checkDependsOn checks the seedlings a new seedling depends on are complete
+// seedlings of its garden, removing repeats.
+func (s *Server) checkDependsOn(ctx context.Context, errs *fieldErrors, seedling *store.Seedling) error {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range seedling.DependsOn {
+		if name = strings.TrimSpace(name); name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	seedling.DependsOn = names
+	for _, name := range names {
+		if name == seedling.Name {
+			errs.add("dependsOn", FieldErrInvalid, "a seedling can't depend on itself")
+			continue
+		}
+		var dep store.Seedling
+		err := s.DB.GetContext(ctx, &dep,
+			"SELECT * FROM seedlings WHERE name = $1 AND garden = $2 AND deleted_at IS NULL", name, seedling.Garden)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		switch {
+		case err == sql.ErrNoRows:
+			errs.add("dependsOn", FieldErrNotFound, fmt.Sprintf("there's no seedling %q in garden %q", name, seedling.Garden))
+		case dep.Archived:
+			errs.add("dependsOn", FieldErrInvalid, fmt.Sprintf("seedling %q is archived", name))
+		case dep.Step != pipeline.SeedlingStepComplete:
+			errs.add("dependsOn", FieldErrInvalid, fmt.Sprintf("seedling %q isn't complete, it's at %s", name, dep.Step))
+		}
+	}
+	return nil
+}
+
+// setDependencies records the edges from a new seedling to the seedlings
+// it depends on, by name in its garden.
+func setDependencies(ctx context.Context, tx *sqlx.Tx, seedling *store.Seedling) error {
+	now := time.Now()
+	for _, name := range seedling.DependsOn {
+		if _, err := tx.ExecContext(ctx, `
+		 INSERT INTO seedling_dependencies (seedling_id, depends_on_id, created_at)
+		 SELECT $1, id, $2 FROM seedlings WHERE name = $3 AND garden = $4 AND deleted_at IS NULL
+		 `, seedling.ID, now, name, seedling.Garden); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependents is the names of the seedlings that depend on the seedling and
+// haven't been deleted.
+func (s *Server) dependents(ctx context.Context, seedlingID hide.Int64) ([]string, error) {
+	names := []string{}
+	err := s.Reads.SelectContext(ctx, &names, `
+	 SELECT seedlings.name FROM seedlings
+	 JOIN seedling_dependencies ON seedling_dependencies.seedling_id = seedlings.id
+	 WHERE seedling_dependencies.depends_on_id = $1 AND seedlings.deleted_at IS NULL
+	 ORDER BY seedlings.name
+	 `, seedlingID)
+	return names, err
+}
+
+// attachDependencies sets DependsOn and Dependents on the seedling.
+func (s *Server) attachDependencies(ctx context.Context, seedling *store.Seedling) error {
+	deps, err := s.Dependencies(ctx, seedling.ID)
+	if err != nil {
+		return err
+	}
+	seedling.DependsOn = make([]string, len(deps))
+	for i, dep := range deps {
+		seedling.DependsOn[i] = dep.Name
+	}
+	seedling.Dependents, err = s.dependents(ctx, seedling.ID)
+	return err
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/dockerx"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// prePullBaseImages pulls the base images used by generated Dockerfiles so
+// that the first build of each seedling doesn't download them.
+func (s *Server) prePullBaseImages(images []string) {
+	for _, image := range images {
+		start := time.Now()
+		if err := s.Docker.Pull(context.Background(), image); err != nil {
+			s.log.WithField("error", err).
+				WithField("image", image).
+				Error("failed to pre-pull base image")
+			continue
+		}
+		s.log.WithField("image", image).
+			WithField("duration", time.Since(start)).
+			Info("Pre-pulled base image")
+	}
+}
+
+// buildSeedlingImage builds the seedling's image from its repo at dir as a
+// command of its build, which the caller holds the lease of, and returns
+// the build's output.
+func (s *Server) buildSeedlingImage(ctx context.Context, seedling store.Seedling, dir string) (string, error) {
+	spec := pipeline.BuildSpec{Dir: dir, Name: "docker", Args: s.SeedlingImageBuildArgs(seedling, s.Config.BuildCache)}
+	if s.Config.BuildCache {
+		spec.Env = append(spec.Env, "DOCKER_BUILDKIT=1")
+	}
+	buildCmd, err := pipeline.HostRunner{Env: s.BuildEnv()}.Command(ctx, spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to set up build command: %w", err)
+	}
+	out := pipeline.NewBuildOutput(s.Config.BuildOutputMaxBytes, nil)
+	buildCmd.Stdout = out
+	buildCmd.Stderr = out
+	s.BuildRegistry.Running(seedling.ID, buildCmd)
+	err = buildCmd.Run()
+	out.Close()
+	s.BuildRegistry.Running(seedling.ID, nil)
+	return out.String(), err
+}
+
+// rebuildMissingImage builds the image of a complete seedling whose image
+// is gone, e.g. after its garden was restored from a backup on a new host,
+// and starts its container. It returns ErrImageMissing if the seedling is
+// being built, which builds the image anyway.
+func (s *Server) rebuildMissingImage(ctx context.Context, seedling *store.Seedling) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	acquired, err := s.BuildRegistry.TryAcquire(ctx, *seedling, cancel)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return fmt.Errorf("%w: seedling is being built", dockerx.ErrImageMissing)
+	}
+	defer s.BuildRegistry.Release(seedling.ID)
+
+	s.log.WithField("name", seedling.Name).Info("Rebuilding missing seedling image")
+	if out, err := s.buildSeedlingImage(ctx, *seedling, s.RepoDir(*seedling)); err != nil {
+		return fmt.Errorf("failed to rebuild image: %w: %s", err,
+			strings.TrimRight(pipeline.ErrorTail(out, s.Settings.Current().ErrorOutputLines), "\n"))
+	}
+	_, err = s.StartSeedlingContainer(ctx, seedling, false)
+	return err
+}
+
+// supportedPlatforms parses the platforms the default buildx builder can
+// target from `docker buildx inspect`.
+func supportedPlatforms(ctx context.Context) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "buildx", "inspect").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("docker buildx inspect: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	platforms := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "Platforms:") {
+			continue
+		}
+		for _, p := range strings.Split(strings.TrimPrefix(line, "Platforms:"), ",") {
+			// docker marks explicitly configured platforms with a trailing "*"
+			if p = strings.TrimSuffix(strings.TrimSpace(p), "*"); p != "" {
+				platforms = append(platforms, p)
+			}
+		}
+	}
+	return platforms, nil
+}
+
+func validatePlatform(ctx context.Context, platform string) error {
+	if platform == pipeline.HostPlatform() {
+		return nil
+	}
+	platforms, err := supportedPlatforms(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range platforms {
+		if p == platform {
+			return nil
+		}
+	}
+	return fmt.Errorf("platform %q is not supported by the docker buildx builder (supported: %s)",
+		platform, strings.Join(platforms, ", "))
+}
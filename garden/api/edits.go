@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// editWarning is the warning an update responds with when it changed what
+// the seedling's running build generates from, which the build only picks
+// up with restartOnEdit set.
+const editWarning = "the seedling is being built from what it was before this change, which the build won't pick up unless the restartOnEdit setting is on; refine the seedling once it's done"
+
+// editedWhileBuilding is the warning for an update to seedling, as it was
+// before the update, that changed the fields in edits: editWarning if its
+// build is running, without restartOnEdit, and "" otherwise.
+func (s *Server) editedWhileBuilding(ctx context.Context, seedling store.Seedling, edits []string) string {
+	if len(edits) == 0 || s.Settings.Current().RestartOnEdit != 0 {
+		return ""
+	}
+	lease, err := s.BuildRegistry.Lease(ctx, seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get build lease")
+	}
+	if lease == nil {
+		return ""
+	}
+	return editWarning
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/pipelinetest"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// pauseAtServer holds the first completion of the server, returning a
+// channel that's closed once it's held and a func that lets it go on.
+func pauseAtServer(env *pipelinetest.Env) (<-chan struct{}, func()) {
+	paused, resume := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	env.LLM.Before = func(ctx context.Context, prompt string) error {
+		if !strings.HasSuffix(prompt, "```go\n") {
+			return nil
+		}
+		held := false
+		once.Do(func() { held = true })
+		if !held {
+			return nil
+		}
+		close(paused)
+		select {
+		case <-resume:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return paused, func() { close(resume) }
+}
+
+// eventSteps are the steps of the seedling's stored events of the type.
+func eventSteps(t *testing.T, s *Server, id hide.Int64, eventType string) []string {
+	t.Helper()
+	steps := []string{}
+	if err := s.DB.Select(&steps, "SELECT step FROM seedling_events WHERE seedling_id = $1 AND type = $2 ORDER BY id",
+		id, eventType); err != nil {
+		t.Fatal(err)
+	}
+	return steps
+}
+
+// editDuringBuild changes the seedling's description while its build is
+// writing the server, returning the update's response.
+func editDuringBuild(t *testing.T, s *Server, env *pipelinetest.Env, seedling store.Seedling, description string) store.Seedling {
+	t.Helper()
+	h := s.Routes()
+	paused, resume := pauseAtServer(env)
+	s.SubmitBuild(context.Background(), seedling)
+	select {
+	case <-paused:
+	case <-time.After(30 * time.Second):
+		t.Fatal("the build didn't get to the server")
+	}
+	defer resume()
+
+	path := pipeline.SeedlingPath(seedling.ID)
+	w := serve(h, "GET", path, nil)
+	body, _ := json.Marshal(map[string]string{"name": seedling.Name, "description": description})
+	req := newRequest("PUT", path, strings.NewReader(string(body)))
+	req.Header.Set("If-Match", w.Header().Get("ETag"))
+	w = serveRequest(h, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	var updated store.Seedling
+	if err := json.Unmarshal(w.Body.Bytes(), &updated); err != nil {
+		t.Fatal(err)
+	}
+	return updated
+}
+
+func TestEditDuringBuild(t *testing.T) {
+	const description = "echoes what it's sent, in upper case"
+
+	t.Run("restartOnEdit off", func(t *testing.T) {
+		s, env := testServer(t)
+		seedling := env.Seedling(t, "echo")
+		updated := editDuringBuild(t, s, env, seedling, description)
+		if updated.Warning != editWarning {
+			t.Errorf("update warned %q", updated.Warning)
+		}
+
+		built := waitForStep(t, s, seedling.ID, pipeline.SeedlingStepComplete)
+		if !built.StaleDescription {
+			t.Error("seedling isn't marked stale")
+		}
+		if built.Description != description {
+			t.Errorf("description is %q", built.Description)
+		}
+		// Every step boundary after the update sees the change, but it's
+		// only reported at the first.
+		if steps := eventSteps(t, s, seedling.ID, pipeline.EventStaleDescription); len(steps) != 1 {
+			t.Errorf("%d %s events, want 1", len(steps), pipeline.EventStaleDescription)
+		}
+		for _, prompt := range env.LLM.Prompts() {
+			if strings.Contains(prompt, description) {
+				t.Errorf("the build was prompted with the new description: %q", prompt)
+				break
+			}
+		}
+		if n := env.Runner.Ran("protoc"); n != 1 {
+			t.Errorf("protoc ran %d times, want 1", n)
+		}
+	})
+
+	t.Run("restartOnEdit on", func(t *testing.T) {
+		s, env := testServer(t)
+		w := serve(s.Routes(), "PUT", "/api/v1/admin/settings", strings.NewReader(`{"restartOnEdit": 1}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("settings: %d %s", w.Code, w.Body)
+		}
+		// The proto generated from the new description is different.
+		env.LLM.Reply = func(prompt string) (string, bool) {
+			if strings.HasSuffix(prompt, "```protobuf\n") && strings.Contains(prompt, description) {
+				return strings.Replace(pipelinetest.Replies["protobuf"], "rpc Say(", "rpc Shout(", 1), true
+			}
+			return "", false
+		}
+		seedling := env.Seedling(t, "echo")
+		updated := editDuringBuild(t, s, env, seedling, description)
+		if updated.Warning != "" {
+			t.Errorf("update warned %q", updated.Warning)
+		}
+
+		built := waitForStep(t, s, seedling.ID, pipeline.SeedlingStepComplete)
+		if built.StaleDescription {
+			t.Error("seedling is marked stale")
+		}
+		steps := eventSteps(t, s, seedling.ID, pipeline.EventBuildRestarted)
+		if len(steps) != 1 || steps[0] != pipeline.SeedlingStepProtobufs {
+			t.Errorf("%s events at %q, want one at %s", pipeline.EventBuildRestarted, steps, pipeline.SeedlingStepProtobufs)
+		}
+		if steps := eventSteps(t, s, seedling.ID, pipeline.EventStaleDescription); len(steps) != 0 {
+			t.Errorf("%d %s events, want none", len(steps), pipeline.EventStaleDescription)
+		}
+		w = serve(s.Routes(), "GET", pipeline.SeedlingPath(seedling.ID)+"/files/protobufs/echo.proto", nil)
+		if !strings.Contains(w.Body.String(), "rpc Shout(") {
+			t.Errorf("the protobufs weren't regenerated from the new description: %s", w.Body)
+		}
+		if n := env.Runner.Ran("protoc"); n != 2 {
+			t.Errorf("protoc ran %d times, want 2", n)
+		}
+	})
+}
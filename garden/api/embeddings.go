@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// DEFAULT_SIMILAR_LIMIT is how many seedlings /seedlings/similar
+	// returns without ?limit=.
+	DEFAULT_SIMILAR_LIMIT = 10
+	// EMBED_BATCH_SIZE is how many descriptions are embedded per request to
+	// the embeddings API when backfilling.
+	EMBED_BATCH_SIZE = 100
+)
+
+// errNoEmbeddings is returned embedding when embeddings aren't available:
+// EMBEDDINGS is off or the provider has no embeddings API.
+var errNoEmbeddings = errors.New("embeddings aren't available")
+
+// SimilarSeedling is a seedling whose description is like another one, by
+// the cosine similarity of their embeddings.
+type SimilarSeedling struct {
+	ID          hide.Int64 `db:"id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Garden      string     `db:"garden" json:"garden"`
+	Description string     `db:"description" json:"description"`
+	Similarity  float64    `db:"-" json:"similarity"`
+}
+
+// encodeVector packs an embedding into a blob of little-endian float32s,
+// which is as precise as similarities need.
+func encodeVector(vector []float64) []byte {
+	b := make([]byte, 4*len(vector))
+	for i, x := range vector {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(x)))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float64 {
+	vector := make([]float64, len(b)/4)
+	for i := range vector {
+		vector[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return vector
+}
+
+// cosineSimilarity is the cosine of the angle between a and b, 0 if they
+// aren't the same length or either is zero.
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// embedder is the server's provider if EMBEDDINGS is on and it can embed.
+func (s *Server) embedder() (llm.Embedder, bool) {
+	embedder, ok := s.LLM.(llm.Embedder)
+	return embedder, ok && s.Config.Embeddings
+}
+
+// embed returns the embeddings of texts with EMBEDDING_MODEL, or
+// errNoEmbeddings if they aren't available.
+func (s *Server) embed(ctx context.Context, texts []string) ([][]float64, error) {
+	embedder, ok := s.embedder()
+	if !ok {
+		return nil, errNoEmbeddings
+	}
+	vectors, err := embedder.Embed(ctx, s.Config.EmbeddingModel, texts)
+	if errors.Is(err, llm.ErrNoEmbeddings) {
+		return nil, errNoEmbeddings
+	}
+	return vectors, err
+}
+
+// storeEmbedding replaces the seedling's embedding.
+func (s *Server) storeEmbedding(ctx context.Context, tx sqlx.ExecerContext, seedlingID hide.Int64, vector []float64) error {
+	_, err := tx.ExecContext(ctx, `
+	 INSERT INTO seedling_embeddings (seedling_id, model, vector, created_at) VALUES ($1, $2, $3, $4)
+	 ON CONFLICT (seedling_id) DO UPDATE SET model = excluded.model, vector = excluded.vector, created_at = excluded.created_at
+	 `, seedlingID, s.Config.EmbeddingModel, encodeVector(vector), time.Now())
+	return err
+}
+
+// embedSeedling embeds the seedling's description again after it changed.
+// Seedlings whose embedding fails keep their old one until a backfill.
+func (s *Server) embedSeedling(ctx context.Context, seedling store.Seedling) {
+	vectors, err := s.embed(ctx, []string{seedling.Description})
+	if errors.Is(err, errNoEmbeddings) {
+		return
+	}
+	if err == nil {
+		err = s.storeEmbedding(ctx, s.DB, seedling.ID, vectors[0])
+	}
+	if err != nil {
+		s.log.WithField("error", err).Warn("failed to embed seedling description")
+	}
+}
+
+// similarSeedlings returns the seedlings whose embeddings are at least min
+// similar to vector, most similar first, at most limit of them. garden ""
+// searches every garden.
+func (s *Server) similarSeedlings(ctx context.Context, vector []float64, garden string, min float64, limit int) ([]SimilarSeedling, error) {
+	query := `
+	 SELECT seedlings.id, seedlings.name, seedlings.garden, seedlings.description, seedling_embeddings.vector
+	 FROM seedling_embeddings JOIN seedlings ON seedlings.id = seedling_embeddings.seedling_id
+	 WHERE seedlings.deleted_at IS NULL AND seedling_embeddings.model = $1`
+	args := []interface{}{s.Config.EmbeddingModel}
+	if garden != "" {
+		query += " AND seedlings.garden = $2"
+		args = append(args, garden)
+	}
+	rows, err := s.Reads.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similar := []SimilarSeedling{}
+	for rows.Next() {
+		var row struct {
+			SimilarSeedling
+			Vector []byte `db:"vector"`
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return nil, err
+		}
+		row.Similarity = cosineSimilarity(vector, decodeVector(row.Vector))
+		if row.Similarity >= min {
+			similar = append(similar, row.SimilarSeedling)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Similarity > similar[j].Similarity })
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+	return similar, nil
+}
+
+// findDuplicates embeds the descriptions of seedlings about to be created
+// and finds the existing seedlings of their garden over
+// DUPLICATE_SIMILARITY to each. Without embeddings, or if embedding fails,
+// the seedlings are created without them and vectors is nil.
+func (s *Server) findDuplicates(ctx context.Context, seedlings []store.Seedling) (vectors [][]float64, duplicates [][]SimilarSeedling, err error) {
+	duplicates = make([][]SimilarSeedling, len(seedlings))
+	descriptions := make([]string, len(seedlings))
+	for i, seedling := range seedlings {
+		descriptions[i] = seedling.Description
+	}
+	vectors, err = s.embed(ctx, descriptions)
+	if err != nil {
+		if !errors.Is(err, errNoEmbeddings) {
+			s.log.WithField("error", err).Warn("failed to embed seedling descriptions")
+		}
+		return nil, duplicates, nil
+	}
+	if s.Config.DuplicateSimilarity <= 0 {
+		return vectors, duplicates, nil
+	}
+	for i, seedling := range seedlings {
+		if duplicates[i], err = s.similarSeedlings(ctx, vectors[i], seedling.Garden, s.Config.DuplicateSimilarity, DEFAULT_SIMILAR_LIMIT); err != nil {
+			return nil, nil, err
+		}
+	}
+	return vectors, duplicates, nil
+}
+
+// duplicateMessage is the error of a create refused for being like the
+// seedlings in duplicates.
+func duplicateMessage(duplicates []SimilarSeedling) string {
+	names := make([]string, len(duplicates))
+	for i, d := range duplicates {
+		names[i] = d.Name
+	}
+	return fmt.Sprintf("seedling looks like %s; set ignoreDuplicates to create it anyway", strings.Join(names, ", "))
+}
+
+// SimilarSeedlings returns the seedlings whose descriptions are most like
+// ?q=, in ?garden= if it's set, at most ?limit= of them.
+func (s *Server) SimilarSeedlings(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "q is required", nil)
+		return
+	}
+	limit := DEFAULT_SIMILAR_LIMIT
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > pipeline.MAX_LIST_LIMIT {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+				fmt.Sprintf("limit must be between 1 and %d", pipeline.MAX_LIST_LIMIT), nil)
+			return
+		}
+		limit = l
+	}
+
+	vectors, err := s.embed(r.Context(), []string{q})
+	if errors.Is(err, errNoEmbeddings) {
+		s.respondError(w, http.StatusServiceUnavailable, pipeline.ErrCodeUnavailable, errNoEmbeddings.Error(), nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to embed query")
+		s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to embed query", nil)
+		return
+	}
+	similar, err := s.similarSeedlings(r.Context(), vectors[0], r.URL.Query().Get("garden"), -1, limit)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to find similar seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(similar); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// BackfillEmbeddings embeds the descriptions of the seedlings without an
+// embedding from EMBEDDING_MODEL, EMBED_BATCH_SIZE at a time.
+func (s *Server) BackfillEmbeddings(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.embedder(); !ok {
+		s.respondError(w, http.StatusServiceUnavailable, pipeline.ErrCodeUnavailable, errNoEmbeddings.Error(), nil)
+		return
+	}
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(r.Context(), &seedlings, `
+	 SELECT * FROM seedlings WHERE deleted_at IS NULL AND id NOT IN (
+	   SELECT seedling_id FROM seedling_embeddings WHERE model = $1
+	 ) ORDER BY id`, s.Config.EmbeddingModel); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	embedded := 0
+	for start := 0; start < len(seedlings); start += EMBED_BATCH_SIZE {
+		batch := seedlings[start:]
+		if len(batch) > EMBED_BATCH_SIZE {
+			batch = batch[:EMBED_BATCH_SIZE]
+		}
+		descriptions := make([]string, len(batch))
+		for i, seedling := range batch {
+			descriptions[i] = seedling.Description
+		}
+		vectors, err := s.embed(r.Context(), descriptions)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to embed seedling descriptions")
+			s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to embed seedling descriptions",
+				map[string]int{"embedded": embedded, "remaining": len(seedlings) - embedded})
+			return
+		}
+		if err := s.InTx(r.Context(), func(tx *sqlx.Tx) error {
+			for i, seedling := range batch {
+				if err := s.storeEmbedding(r.Context(), tx, seedling.ID, vectors[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			s.log.WithField("error", err).Error("failed to store seedling embeddings")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		embedded += len(batch)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":    s.Config.EmbeddingModel,
+		"embedded": embedded,
+	}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
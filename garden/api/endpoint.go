@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// SeedlingEndpoint is how to reach a complete seedling's container.
+type SeedlingEndpoint struct {
+	GRPC         EndpointAddr            `json:"grpc"`
+	HTTP         EndpointHTTPAddr        `json:"http"`
+	ExampleCurl  string                  `json:"exampleCurl"`
+	ProtoPackage string                  `json:"protoPackage"`
+	Services     []store.EndpointService `json:"services"`
+}
+
+// EndpointAddr is a host port a server is published on, and the port it
+// listens on inside the container.
+type EndpointAddr struct {
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	ContainerPort int    `json:"containerPort"`
+}
+
+type EndpointHTTPAddr struct {
+	EndpointAddr
+	BaseURL string `json:"baseUrl"`
+}
+
+// SeedlingEndpoint returns how to reach a complete seedling: the host ports
+// its servers are published on, its services and an example call.
+func (s *Server) SeedlingEndpoint(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling isn't complete", map[string]string{"step": seedling.Step})
+		return
+	}
+
+	if err := s.RefreshPorts(r.Context(), &seedling); err != nil {
+		// The stored ports are still right unless the container restarted.
+		s.log.WithField("error", err).Warn("failed to refresh seedling ports")
+	}
+	if seedling.GRPCPort == 0 && seedling.HTTPPort == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling container isn't running", nil)
+		return
+	}
+
+	host := s.Config.SeedlingHost
+	pkg, services, err := pipeline.GRPCServices(filepath.Join(s.RepoDir(seedling), "protobufs", seedling.Name+"_grpc.pb.go"))
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to parse seedling services")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	curl, err := s.ExampleCurl(seedling, host)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to read example client call")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	ports := seedling.SeedlingPorts.WithDefaults()
+	endpoint := SeedlingEndpoint{
+		GRPC: EndpointAddr{Host: host, Port: seedling.GRPCPort, ContainerPort: ports.GRPCContainerPort},
+		HTTP: EndpointHTTPAddr{
+			EndpointAddr: EndpointAddr{Host: host, Port: seedling.HTTPPort, ContainerPort: ports.HTTPContainerPort},
+			BaseURL:      fmt.Sprintf("http://%s:%d", host, seedling.HTTPPort),
+		},
+		ExampleCurl:  curl,
+		ProtoPackage: pkg,
+		Services:     services,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&endpoint); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// SeedlingEnv is a seedling's environment variables and the step it's at.
+type SeedlingEnv struct {
+	Step string                 `json:"step"`
+	Env  []store.EnvRequirement `json:"env"`
+}
+
+func (s *Server) envRequirements(ctx context.Context, seedlingID hide.Int64) ([]store.EnvRequirement, error) {
+	reqs := []store.EnvRequirement{}
+	err := s.Reads.SelectContext(ctx, &reqs,
+		"SELECT * FROM seedling_env_requirements WHERE seedling_id = $1 ORDER BY required DESC, name", seedlingID)
+	return reqs, err
+}
+
+// GetSeedlingEnv lists the environment variables the seedling's server
+// reads and which of them are set.
+func (s *Server) GetSeedlingEnv(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	s.respondEnv(w, r, seedling, http.StatusOK)
+}
+
+// PutSeedlingEnv sets the values of the seedling's environment variables,
+// and removes the ones set to null. Like secrets, values are only ever
+// written to disk. Once every required one is set, a seedling waiting at
+// SeedlingStepAwaitingConfig has its container started; a running
+// container gets the new values when it's next started.
+func (s *Server) PutSeedlingEnv(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	req := map[string]*string{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	reqs, err := s.envRequirements(r.Context(), seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get env requirements")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	declared := map[string]bool{}
+	for _, env := range reqs {
+		declared[env.Name] = true
+	}
+	names := make([]string, 0, len(req))
+	for name := range req {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := fieldErrors{}
+	for _, name := range names {
+		switch {
+		case !envNameRegex.MatchString(name):
+			errs.add(name, FieldErrCharset, "environment variable names must only contain letters, digits and '_'")
+		case !declared[name]:
+			errs.add(name, FieldErrNotFound, "the seedling's server doesn't read this environment variable")
+		case req[name] != nil && strings.ContainsRune(*req[name], 0):
+			errs.add(name, FieldErrInvalid, "value must not contain NUL")
+		}
+	}
+	if invalid := errs.body("env is invalid"); invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+
+	if err := s.ensureIgnored(s.RepoDir(seedling), "env"); err != nil {
+		s.log.WithField("error", err).Error("failed to update .gitignore")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	dir := pipeline.SeedlingEnvDir(s.RepoDir(seedling))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		s.log.WithField("error", err).Error("failed to create env dir")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	now := time.Now()
+	for _, name := range names {
+		if value := req[name]; value != nil {
+			err = s.SafeWriteFile(s.RepoDir(seedling), filepath.Join("env", name), []byte(*value), 0600)
+		} else if err = os.Remove(filepath.Join(dir, name)); os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			s.respondWriteError(w, err, "failed to write env value")
+			return
+		}
+		if _, err := s.DB.ExecContext(r.Context(), `
+		 UPDATE seedling_env_requirements SET provided = $1, modified_at = $2
+		 WHERE seedling_id = $3 AND name = $4
+		 `, req[name] != nil, now, seedling.ID, name); err != nil {
+			s.log.WithField("error", err).Error("failed to record env value")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+	}
+
+	status := http.StatusOK
+	if seedling.Step == pipeline.SeedlingStepAwaitingConfig {
+		missing, err := s.MissingEnv(r.Context(), seedling.ID)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get missing env")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		if len(missing) == 0 {
+			// Only one of concurrent requests starts the container.
+			result, err := s.DB.ExecContext(r.Context(), `
+			 UPDATE seedlings SET step = $1, step_started_at = $2, modified_at = $2, version = version + 1
+			 WHERE id = $3 AND step = $4
+			 `, pipeline.SeedlingStepComplete, now, seedling.ID, pipeline.SeedlingStepAwaitingConfig)
+			if err != nil {
+				s.log.WithField("error", err).Error("failed to update seedling step")
+				s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+				return
+			}
+			if n, err := result.RowsAffected(); err == nil && n > 0 {
+				seedling.Step = pipeline.SeedlingStepComplete
+				seedling.StepStartedAt = &now
+				seedling.ModifiedAt = now
+				s.Notify(r.Context(), seedling, pipeline.EventStepChanged, pipeline.SeedlingStepComplete)
+				s.SubmitBuild(r.Context(), seedling)
+				status = http.StatusAccepted
+			}
+		}
+	}
+	s.respondEnv(w, r, seedling, status)
+}
+
+func (s *Server) respondEnv(w http.ResponseWriter, r *http.Request, seedling store.Seedling, status int) {
+	reqs, err := s.envRequirements(r.Context(), seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get env requirements")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(&SeedlingEnv{Step: seedling.Step, Env: reqs}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
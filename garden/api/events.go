@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// SeedlingEvents streams a seedling's build events, including completion
+// chunks as they arrive from the LLM and build output as it's printed, as
+// server-sent events.
+func (s *Server) SeedlingEvents(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "streaming unsupported", nil)
+		return
+	}
+
+	events, unsubscribe := s.Events.Subscribe(seedling.ID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(&event)
+			if err != nil {
+				s.log.WithField("error", err).Error("failed to encode event")
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+func TestSeedlingEvents(t *testing.T) {
+	s, env := testServer(t)
+	seedling := env.Seedling(t, "echo")
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+pipeline.SeedlingPath(seedling.ID)+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("%s, %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	// The handler subscribed before it sent the headers, so these reach it.
+	chunks := []string{"package main\n", "func main() {}\n"}
+	for _, chunk := range chunks {
+		s.Events.Publish(seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventCompletionChunk, Step: pipeline.SeedlingStepServer, Data: chunk})
+	}
+	s.Events.Publish(seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventCompletionDone, Step: pipeline.SeedlingStepServer, Data: strings.Join(chunks, "")})
+	// Another seedling's events aren't sent.
+	s.Events.Publish(seedling.ID+1, pipeline.SeedlingEvent{Type: pipeline.EventCompletionChunk, Data: "other"})
+
+	scanner := bufio.NewScanner(resp.Body)
+	var got []pipeline.SeedlingEvent
+	var eventType string
+	for len(got) < len(chunks)+1 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var event pipeline.SeedlingEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != eventType {
+				t.Errorf("event: %s with data of a %s event", eventType, event.Type)
+			}
+			got = append(got, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(chunks)+1 {
+		t.Fatalf("got %d events, want %d", len(got), len(chunks)+1)
+	}
+	for i, chunk := range chunks {
+		if got[i].Type != pipeline.EventCompletionChunk || got[i].Data != chunk {
+			t.Errorf("event %d is %s %q, want a chunk of %q", i, got[i].Type, got[i].Data, chunk)
+		}
+	}
+	if done := got[len(chunks)]; done.Type != pipeline.EventCompletionDone || done.Data != strings.Join(chunks, "") {
+		t.Errorf("last event is %s %q, want the completion", done.Type, done.Data)
+	}
+}
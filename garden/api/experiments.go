@@ -0,0 +1,601 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// ExperimentMaxVariants caps how many seedlings one experiment builds.
+const ExperimentMaxVariants = 10
+
+// Experiment builds the same description once per variant so the templates
+// and models of the variants can be compared.
+type Experiment struct {
+	ID          hide.Int64 `db:"id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Garden      string     `db:"garden" json:"garden"`
+	Description string     `db:"description" json:"description"`
+	CreatedAt   time.Time  `db:"created_at" json:"createdAt"`
+}
+
+// ExperimentVariant is how one of an experiment's seedlings is generated.
+// Unset fields are the defaults a seedling would be created with.
+type ExperimentVariant struct {
+	Template       string               `json:"template,omitempty"`
+	TemplateParams store.TemplateParams `json:"templateParams,omitempty"`
+	Model          string               `json:"model,omitempty"`
+	Temperature    *float32             `json:"temperature,omitempty"`
+}
+
+// experimentRequest is the body of POST /api/v1/experiments. Every variant is
+// built from the same plan, the one given or else one planned for the
+// description, so they only differ by their variant.
+type experimentRequest struct {
+	Name        string              `json:"name"`
+	Garden      string              `json:"garden"`
+	Description string              `json:"description"`
+	Plan        *store.SeedlingPlan `json:"plan"`
+	SkipTests   bool                `json:"skipTests"`
+	Tags        []string            `json:"tags"`
+	Variants    []ExperimentVariant `json:"variants"`
+}
+
+// variantFields are the fields of a variant's seedling, whose errors are
+// reported against the variant rather than the experiment.
+var variantFields = map[string]bool{"template": true, "templateParams": true, "model": true, "temperature": true}
+
+// VariantStep is how a variant's build went at one step.
+type VariantStep struct {
+	Step             string `json:"step"`
+	Attempts         int    `json:"attempts"`
+	Success          bool   `json:"success"`
+	DurationMS       int64  `json:"durationMs"`
+	BuildDurationMS  int64  `json:"buildDurationMs"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+}
+
+// VariantQualityCheck is a quality check verdict on a variant's server code.
+type VariantQualityCheck struct {
+	Quality   string    `db:"quality" json:"quality"`
+	Reason    string    `db:"reason" json:"reason"`
+	Accepted  bool      `db:"accepted" json:"accepted"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// VariantReport is one variant of an experiment and how its seedling's
+// build went so far. DiffURL diffs its server code against the first
+// variant's.
+type VariantReport struct {
+	Variant int `json:"variant"`
+	ExperimentVariant
+	SeedlingID       hide.Int64            `json:"seedlingId"`
+	Name             string                `json:"name"`
+	Step             string                `json:"step"`
+	Success          bool                  `json:"success"`
+	FailureReason    string                `json:"failureReason,omitempty"`
+	Kept             bool                  `json:"kept"`
+	Deleted          bool                  `json:"deleted,omitempty"`
+	Attempts         int                   `json:"attempts"`
+	PromptTokens     int                   `json:"promptTokens"`
+	CompletionTokens int                   `json:"completionTokens"`
+	DurationMS       int64                 `json:"durationMs"`
+	Steps            []VariantStep         `json:"steps"`
+	QualityChecks    []VariantQualityCheck `json:"qualityChecks"`
+	DiffURL          string                `json:"diffUrl,omitempty"`
+}
+
+type ExperimentReport struct {
+	Experiment
+	Variants []VariantReport `json:"variants"`
+}
+
+// experimentPath is the API path of the experiment, with its id obfuscated
+// the way it's returned.
+func experimentPath(id hide.Int64) string {
+	return "/api/v1/experiments/" + strconv.FormatInt(hide.Default.Int64Obfuscate(int64(id)), 10)
+}
+
+// experimentSeedlings returns the experiment's seedlings in variant order,
+// soft-deleted ones included.
+func (s *Server) experimentSeedlings(ctx context.Context, id hide.Int64) ([]store.Seedling, error) {
+	seedlings := []store.Seedling{}
+	err := s.Reads.SelectContext(ctx, &seedlings,
+		"SELECT * FROM seedlings WHERE experiment_id = $1 ORDER BY experiment_variant", id)
+	return seedlings, err
+}
+
+func (s *Server) experimentReport(ctx context.Context, id hide.Int64) (*ExperimentReport, error) {
+	report := &ExperimentReport{Variants: []VariantReport{}}
+	if err := s.Reads.GetContext(ctx, &report.Experiment, "SELECT * FROM experiments WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	seedlings, err := s.experimentSeedlings(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(seedlings) == 0 {
+		return report, nil
+	}
+	ids := make([]hide.Int64, len(seedlings))
+	for i, seedling := range seedlings {
+		ids[i] = seedling.ID
+	}
+
+	query, args, err := sqlx.In(`
+	 SELECT id, seedling_id, step, success, duration_ms, build_duration_ms, prompt_tokens, completion_tokens
+	 FROM seedling_attempts WHERE seedling_id IN (?) ORDER BY id
+	 `, ids)
+	if err != nil {
+		return nil, err
+	}
+	attempts := []store.Attempt{}
+	if err := s.Reads.SelectContext(ctx, &attempts, s.Reads.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	query, args, err = sqlx.In(`
+	 SELECT seedling_id, quality, reason, accepted, created_at
+	 FROM quality_checks WHERE seedling_id IN (?) ORDER BY id
+	 `, ids)
+	if err != nil {
+		return nil, err
+	}
+	checks := []struct {
+		SeedlingID hide.Int64 `db:"seedling_id"`
+		VariantQualityCheck
+	}{}
+	if err := s.Reads.SelectContext(ctx, &checks, s.Reads.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	for _, seedling := range seedlings {
+		v := VariantReport{
+			Variant: seedling.ExperimentVariant,
+			ExperimentVariant: ExperimentVariant{
+				Template:       seedling.Template,
+				TemplateParams: seedling.TemplateParams,
+				Model:          seedling.Model,
+				Temperature:    seedling.Temperature,
+			},
+			SeedlingID:    seedling.ID,
+			Name:          seedling.Name,
+			Step:          seedling.Step,
+			Success:       seedling.Step == pipeline.SeedlingStepComplete,
+			FailureReason: seedling.FailureReason,
+			Kept:          seedling.Kept,
+			Deleted:       seedling.DeletedAt != nil,
+			Steps:         []VariantStep{},
+			QualityChecks: []VariantQualityCheck{},
+		}
+		steps := map[string]int{}
+		for _, a := range attempts {
+			if a.SeedlingID != seedling.ID {
+				continue
+			}
+			i, ok := steps[a.Step]
+			if !ok {
+				i = len(v.Steps)
+				steps[a.Step] = i
+				v.Steps = append(v.Steps, VariantStep{Step: a.Step})
+			}
+			step := &v.Steps[i]
+			step.Attempts++
+			step.Success = step.Success || a.Success
+			step.DurationMS += a.DurationMS
+			step.BuildDurationMS += a.BuildDurationMS
+			step.PromptTokens += a.PromptTokens
+			step.CompletionTokens += a.CompletionTokens
+			v.Attempts++
+			v.DurationMS += a.DurationMS
+			v.PromptTokens += a.PromptTokens
+			v.CompletionTokens += a.CompletionTokens
+		}
+		for _, qc := range checks {
+			if qc.SeedlingID == seedling.ID {
+				v.QualityChecks = append(v.QualityChecks, qc.VariantQualityCheck)
+			}
+		}
+		if v.Variant != 1 {
+			v.DiffURL = fmt.Sprintf("%s/diff?variant=%d&against=1", experimentPath(id), v.Variant)
+		}
+		report.Variants = append(report.Variants, v)
+	}
+	return report, nil
+}
+
+// lookupExperiment finds the experiment of the {id} route variable,
+// responding with the error if it can't.
+func (s *Server) lookupExperiment(w http.ResponseWriter, r *http.Request) (*ExperimentReport, bool) {
+	id, err := parseID(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return nil, false
+	}
+	report, err := s.experimentReport(r.Context(), id)
+	if err == sql.ErrNoRows {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "experiment not found", nil)
+		return nil, false
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get experiment")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return nil, false
+	}
+	return report, true
+}
+
+// CreateExperiment creates a seedling for each variant, named after the
+// experiment with the variant's number, and builds them all without waiting
+// for their plan to be approved.
+func (s *Server) CreateExperiment(w http.ResponseWriter, r *http.Request) {
+	var req experimentRequest
+	invalid, err := decodeStrict(r.Body, &req)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+
+	errs := fieldErrors{}
+	switch {
+	case len(req.Variants) < 2:
+		errs.add("variants", FieldErrTooShort, "an experiment needs at least 2 variants")
+	case len(req.Variants) > ExperimentMaxVariants:
+		errs.add("variants", FieldErrTooLong, fmt.Sprintf("an experiment may have at most %d variants", ExperimentMaxVariants))
+	}
+	seen := map[FieldError]bool{}
+	seedlings := make([]store.Seedling, len(req.Variants))
+	names := []string{}
+	for i, variant := range req.Variants {
+		seedlings[i] = store.Seedling{
+			Name:        fmt.Sprintf("%s-%d", strings.TrimSpace(req.Name), i+1),
+			Garden:      req.Garden,
+			Description: req.Description,
+			SkipTests:   req.SkipTests,
+			Plan:        req.Plan,
+			Tags:        req.Tags,
+			SeedlingTemplate: store.SeedlingTemplate{
+				Template:       variant.Template,
+				TemplateParams: variant.TemplateParams,
+			},
+			SeedlingGeneration: store.SeedlingGeneration{
+				Model:       variant.Model,
+				Temperature: variant.Temperature,
+			},
+		}
+		invalid, err := s.prepareSeedling(r.Context(), &seedlings[i])
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to validate seedling")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		if invalid != nil {
+			// Every variant shares the rest of the experiment's fields, and
+			// their problems.
+			for _, fe := range invalid.Details.([]FieldError) {
+				if variantFields[fe.Field] {
+					fe.Field = fmt.Sprintf("variants[%d].%s", i, fe.Field)
+				}
+				if !seen[fe] {
+					seen[fe] = true
+					errs = append(errs, fe)
+				}
+			}
+			continue
+		}
+		names = append(names, seedlings[i].ResourceName())
+	}
+	if invalid := errs.body("experiment is invalid"); invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+	taken, err := s.TakenNames(r.Context(), names)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling names")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	for name, deleted := range taken {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, nameTakenMessage(deleted), map[string]string{"name": name})
+		return
+	}
+
+	key, ok := s.checkLLMKey(w, r)
+	if !ok {
+		return
+	}
+	plan := req.Plan
+	if plan == nil {
+		if plan, err = s.planSeedling(pipeline.WithLLM(r.Context(), key.provider.LLM), req.Description); err != nil {
+			s.log.WithField("error", err).Error("failed to plan seedling")
+			s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to plan seedling", nil)
+			return
+		}
+	}
+
+	experiment := Experiment{
+		Name:        strings.TrimSpace(req.Name),
+		Garden:      seedlings[0].Garden,
+		Description: req.Description,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.InTx(r.Context(), func(tx *sqlx.Tx) error {
+		result, err := tx.NamedExecContext(r.Context(), `
+		 INSERT INTO experiments (name, garden, description, created_at)
+		 VALUES (:name, :garden, :description, :created_at)
+		 `, &experiment)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		experiment.ID = hide.Int64(id)
+		for i := range seedlings {
+			seedlings[i].Plan = plan
+			seedlings[i].AutoApprove = true
+			seedlings[i].Step = pipeline.SeedlingStepProtobufs
+			seedlings[i].ExperimentID = &experiment.ID
+			seedlings[i].ExperimentVariant = i + 1
+			seedlings[i].LLMKeyID, seedlings[i].LLMKey = key.id, key.sealed
+			if err := insertSeedling(r.Context(), tx, &seedlings[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); store.SQLiteUnique(err) {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "a variant's name was taken since it was checked", nil)
+		return
+	} else if err != nil {
+		s.log.WithField("error", err).Error("failed to insert experiment")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	for _, seedling := range seedlings {
+		s.Emit(r.Context(), seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventCreated, Step: seedling.Step})
+		ctx := s.BuildRegistry.Detach(r.Context(), seedling)
+		if err := s.WriteSeedlingToRepo(ctx, seedling); err != nil {
+			s.log.WithField("error", err).Error("failed to write seedling to repo")
+			s.FailSeedling(r.Context(), seedling, "failed to write seedling to repo: "+err.Error())
+			continue
+		}
+		s.SubmitBuild(ctx, seedling)
+	}
+	pipeline.LoggerFromContext(r.Context()).WithField("experiment", experiment.Name).
+		WithField("variants", len(seedlings)).
+		Info("Created experiment")
+
+	report, err := s.experimentReport(r.Context(), experiment.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get experiment")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// ListExperiments returns every experiment, newest first.
+func (s *Server) ListExperiments(w http.ResponseWriter, r *http.Request) {
+	experiments := []Experiment{}
+	if err := s.Reads.SelectContext(r.Context(), &experiments, "SELECT * FROM experiments ORDER BY id DESC"); err != nil {
+		s.log.WithField("error", err).Error("failed to get experiments")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&experiments); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// GetExperiment reports how each variant's build went: its attempts,
+// tokens and time at each step, quality check verdicts and whether it
+// completed.
+func (s *Server) GetExperiment(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.lookupExperiment(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// variantAttempt returns the attempt of the variant's seedling at the step
+// to compare: its last successful one, or its last one if none succeeded.
+func (s *Server) variantAttempt(ctx context.Context, seedlingID hide.Int64, step string) (*store.Attempt, error) {
+	var a store.Attempt
+	if err := s.Reads.GetContext(ctx, &a, `
+	 SELECT * FROM seedling_attempts
+	 WHERE seedling_id = $1 AND step = $2
+	 ORDER BY success DESC, id DESC LIMIT 1
+	 `, seedlingID, step); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ExperimentDiff diffs the code variant ?variant= wrote at ?step=, the
+// server step by default, against what variant ?against= wrote, the first
+// variant by default.
+func (s *Server) ExperimentDiff(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.lookupExperiment(w, r)
+	if !ok {
+		return
+	}
+	step := r.URL.Query().Get("step")
+	if step == "" {
+		step = pipeline.SeedlingStepServer
+	}
+	attempts := []*store.Attempt{}
+	for _, param := range []string{"against", "variant"} {
+		n, err := strconv.Atoi(r.URL.Query().Get(param))
+		if param == "against" && r.URL.Query().Get(param) == "" {
+			n, err = 1, nil
+		}
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, param+" must be a variant number", nil)
+			return
+		}
+		var variant *VariantReport
+		for i := range report.Variants {
+			if report.Variants[i].Variant == n {
+				variant = &report.Variants[i]
+			}
+		}
+		if variant == nil {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, fmt.Sprintf("experiment has no variant %d", n), nil)
+			return
+		}
+		a, err := s.variantAttempt(r.Context(), variant.SeedlingID, step)
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound,
+				fmt.Sprintf("variant %d has no attempt at %s", n, step), nil)
+			return
+		}
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get attempt")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		attempts = append(attempts, a)
+	}
+
+	diff, err := pipeline.DiffAttempts(attempts[0], attempts[1])
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to diff attempts")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&diff); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// KeepVariant marks a variant's seedling as kept, so deleting its
+// experiment leaves it alone. DELETE undoes it.
+func (s *Server) KeepVariant(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.lookupExperiment(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(mux.Vars(r)["variant"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid variant", nil)
+		return
+	}
+	keep := r.Method != http.MethodDelete
+	for _, variant := range report.Variants {
+		if variant.Variant != n {
+			continue
+		}
+		if _, err := s.DB.ExecContext(r.Context(),
+			"UPDATE seedlings SET kept = $1 WHERE id = $2", keep, variant.SeedlingID); err != nil {
+			s.log.WithField("error", err).Error("failed to keep seedling")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		variant.Kept = keep
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&variant); err != nil {
+			s.log.WithField("error", err).Error("failed to encode response")
+		}
+		return
+	}
+	s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, fmt.Sprintf("experiment has no variant %d", n), nil)
+}
+
+// DeleteExperiment deletes the experiment and the seedlings of its variants
+// that weren't kept, soft-deleting them unless ?hard=true. Kept seedlings
+// stay as they are, no longer part of an experiment.
+func (s *Server) DeleteExperiment(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.lookupExperiment(w, r)
+	if !ok {
+		return
+	}
+	hard, _ := strconv.ParseBool(r.URL.Query().Get("hard"))
+	seedlings, err := s.experimentSeedlings(r.Context(), report.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get experiment seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	deleted, kept := []string{}, []string{}
+	for i := range seedlings {
+		seedling := &seedlings[i]
+		if seedling.Kept {
+			kept = append(kept, seedling.Name)
+			continue
+		}
+		if s.BuildRegistry.Kill(seedling.ID) {
+			s.FailSeedling(r.Context(), *seedling, "experiment deleted")
+		}
+		if hard {
+			err = s.purgeSeedling(r.Context(), *seedling)
+		} else if seedling.DeletedAt == nil {
+			_, err = s.softDeleteSeedling(r.Context(), seedling)
+		}
+		if err != nil {
+			s.log.WithField("error", err).WithField("name", seedling.Name).Error("failed to delete experiment seedling")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to delete seedling",
+				map[string]interface{}{"name": seedling.Name, "deleted": deleted})
+			return
+		}
+		deleted = append(deleted, seedling.Name)
+	}
+
+	if err := s.InTx(r.Context(), func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(r.Context(),
+			"UPDATE seedlings SET experiment_id = NULL, experiment_variant = 0 WHERE experiment_id = $1", report.ID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(r.Context(), "DELETE FROM experiments WHERE id = $1", report.ID)
+		return err
+	}); err != nil {
+		s.log.WithField("error", err).Error("failed to delete experiment")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	pipeline.LoggerFromContext(r.Context()).WithField("experiment", report.Name).
+		WithField("deleted", len(deleted)).
+		WithField("kept", len(kept)).
+		Info("Deleted experiment")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "experiment deleted",
+		"deleted": deleted,
+		"kept":    kept,
+	}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	// seedlingFieldColumns are the fields ?fields= may pick for listed
+	// seedlings, by json name, with the columns each is read from. The
+	// fields of git, resources and ports are picked together.
+	seedlingFieldColumns = seedlingFields()
+	// seedlingLargeColumns are the columns of text that grows with a
+	// seedling's builds, which lists only read for ?fields= asking for them.
+	// llm_key is never returned.
+	seedlingLargeColumns = map[string]bool{
+		"failure_reason":     true,
+		"plan":               true,
+		"grpc_smoke":         true,
+		"promotion":          true,
+		"refine_instruction": true,
+		"bitrot_reason":      true,
+		"git_push_error":     true,
+		"readme_error":       true,
+		"llm_key":            true,
+	}
+	// seedlingListColumns are the columns lists read without ?fields=.
+	seedlingListColumns = func() []string {
+		columns := []string{}
+		for _, c := range seedlingColumns(seedlingAllFields()) {
+			if !seedlingLargeColumns[c] {
+				columns = append(columns, c)
+			}
+		}
+		return columns
+	}()
+)
+
+// seedlingFields maps the json names of Seedling's stored fields to their
+// columns, and those of the fields lists compute to the columns they're
+// computed from.
+func seedlingFields() map[string][]string {
+	fields := map[string][]string{
+		"etaSeconds":     {"step", "step_started_at", "skip_tests"},
+		"tags":           {},
+		"containerState": {"name", "garden"},
+	}
+	addSeedlingFields(fields, reflect.TypeOf(store.Seedling{}), "")
+	return fields
+}
+
+func addSeedlingFields(fields map[string][]string, t reflect.Type, group string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if name != "" && group == "" {
+				addSeedlingFields(fields, f.Type, name)
+			} else {
+				addSeedlingFields(fields, f.Type, group)
+			}
+			continue
+		}
+		column := f.Tag.Get("db")
+		if column == "" || column == "-" || name == "" {
+			continue
+		}
+		if group != "" {
+			name = group
+		}
+		fields[name] = append(fields[name], column)
+	}
+}
+
+func seedlingAllFields() map[string]bool {
+	all := map[string]bool{}
+	for name := range seedlingFieldColumns {
+		all[name] = true
+	}
+	return all
+}
+
+// seedlingColumns returns the columns the fields are read from, with id,
+// sorted.
+func seedlingColumns(fields map[string]bool) []string {
+	seen := map[string]bool{"id": true}
+	columns := []string{"id"}
+	for name := range fields {
+		for _, c := range seedlingFieldColumns[name] {
+			if !seen[c] {
+				seen[c] = true
+				columns = append(columns, c)
+			}
+		}
+	}
+	sort.Strings(columns[1:])
+	return columns
+}
+
+// seedlingListFields parses ?fields=, a comma-separated list of the json
+// names of the fields to return for each seedling, which always has id. It
+// returns nil without ?fields=, for every field but the large ones.
+func seedlingListFields(r *http.Request) (map[string]bool, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+	fields := map[string]bool{"id": true}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := seedlingFieldColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields[name] = true
+	}
+	return fields, nil
+}
+
+// selectColumns is the SELECT list of the columns, qualified with table.
+func selectColumns(table string, columns []string) string {
+	qualified := make([]string, len(columns))
+	for i, c := range columns {
+		qualified[i] = table + "." + c
+	}
+	return strings.Join(qualified, ", ")
+}
+
+// sparseSeedlings is the seedlings as JSON objects with only the fields.
+func sparseSeedlings(ss []store.Seedling, fields map[string]bool) ([]map[string]json.RawMessage, error) {
+	sparse := make([]map[string]json.RawMessage, len(ss))
+	for i := range ss {
+		data, err := json.Marshal(&ss[i])
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &sparse[i]); err != nil {
+			return nil, err
+		}
+		for name := range sparse[i] {
+			if !fields[name] {
+				delete(sparse[i], name)
+			}
+		}
+	}
+	return sparse, nil
+}
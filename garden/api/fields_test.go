@@ -0,0 +1,272 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// seededSeedlings is how many seedlings the list tests seed, each with
+// large columns of largeColumnSize bytes.
+const (
+	seededSeedlings = 3000
+	largeColumnSize = 4 << 10
+)
+
+// seedSeedlings inserts n complete seedlings with a long failure reason and
+// plan, as builds that were retried and planned leave them.
+func seedSeedlings(t testing.TB, db *sqlx.DB, n int) {
+	t.Helper()
+	reason := strings.Repeat("failed to build the server. ", largeColumnSize/28)
+	plan := mustJSON(t, map[string]interface{}{
+		"summary": "An echo service.",
+		"rpcs": []map[string]string{{
+			"name": "Echo", "request": "EchoRequest", "response": "EchoResponse",
+			"description": strings.Repeat("echoes it ", largeColumnSize/10),
+		}},
+	})
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Preparex(`INSERT INTO seedlings (name, garden, description, created_at, modified_at, step, step_started_at, platform, failure_reason, plan)
+	 VALUES ($1, 'default', 'echoes what it''s sent', $2, $2, $3, $2, 'linux/amd64', $4, $5)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		if _, err := stmt.Exec(fmt.Sprintf("echo%d", i), now.Add(time.Duration(i)*time.Second),
+			pipeline.SeedlingStepComplete, reason, plan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listQuery is the query ListSeedlings makes for the columns and target.
+func listQuery(t testing.TB, target string, columns []string) (string, []interface{}) {
+	t.Helper()
+	r := httptest.NewRequest("GET", target, nil)
+	where, args, err := seedlingFilter(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := listParams(r, seedlingSortColumns, "seedlings.created_at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "SELECT " + selectColumns("seedlings", columns) + " FROM seedlings" + where + page, args
+}
+
+// readColumns returns the columns of seedlings the query's program loads.
+// EXPLAIN QUERY PLAN only has the tables and indexes a query uses, so the
+// program's Column instructions on the table's cursors are read instead.
+func readColumns(t *testing.T, db *sqlx.DB, query string, args []interface{}) map[string]bool {
+	t.Helper()
+	var rootPage int
+	if err := db.Get(&rootPage, "SELECT rootpage FROM sqlite_master WHERE type = 'table' AND name = 'seedlings'"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	if err := db.Select(&names, "SELECT name FROM pragma_table_info('seedlings') ORDER BY cid"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("EXPLAIN "+query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	cursors := map[int]bool{}
+	read := map[string]bool{}
+	for rows.Next() {
+		var addr, p1, p2, p3, p5 int
+		var opcode string
+		var p4, comment sql.NullString
+		if err := rows.Scan(&addr, &opcode, &p1, &p2, &p3, &p4, &p5, &comment); err != nil {
+			t.Fatal(err)
+		}
+		switch opcode {
+		case "OpenRead":
+			if p2 == rootPage {
+				cursors[p1] = true
+			}
+		case "Column":
+			if cursors[p1] {
+				read[names[p2]] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) == 0 {
+		t.Fatalf("the query doesn't read seedlings: %s", query)
+	}
+	return read
+}
+
+func TestSeedlingListFields(t *testing.T) {
+	tests := []struct {
+		param string
+		// want is the fields besides id, or nil for the default list.
+		want []string
+		err  bool
+	}{
+		{param: "", want: nil},
+		{param: "name,step,createdAt", want: []string{"createdAt", "name", "step"}},
+		{param: " name , ,step", want: []string{"name", "step"}},
+		{param: "git", want: []string{"git"}},
+		{param: "name,bogus", err: true},
+		{param: "llm_key", err: true},
+		{param: "name,created_at DESC", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/seedlings?fields="+strings.ReplaceAll(tt.param, " ", "%20"), nil)
+			fields, err := seedlingListFields(r)
+			if (err != nil) != tt.err {
+				t.Fatalf("error %v", err)
+			}
+			if tt.want == nil {
+				if fields != nil {
+					t.Errorf("fields %v, want the default list", fields)
+				}
+				return
+			}
+			got := []string{}
+			for name := range fields {
+				if name != "id" {
+					got = append(got, name)
+				}
+			}
+			sort.Strings(got)
+			if mustJSON(t, got) != mustJSON(t, tt.want) || !fields["id"] {
+				t.Errorf("fields %v, want id and %v", fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestListSeedlingsProjection(t *testing.T) {
+	s, _ := testServer(t)
+	seedSeedlings(t, s.DB, seededSeedlings)
+	h := s.Routes()
+
+	// The default list's query never loads the large columns, nor does one
+	// for ?fields= that doesn't ask for them.
+	query, args := listQuery(t, "/api/v1/seedlings?limit=100", seedlingListColumns)
+	for column := range readColumns(t, s.DB, query, args) {
+		if seedlingLargeColumns[column] {
+			t.Errorf("the default list reads %s", column)
+		}
+	}
+	fields := map[string]bool{"id": true, "name": true, "step": true, "createdAt": true}
+	query, args = listQuery(t, "/api/v1/seedlings?limit=100", seedlingColumns(fields))
+	read := readColumns(t, s.DB, query, args)
+	for column := range read {
+		if seedlingLargeColumns[column] {
+			t.Errorf("?fields=name,step,createdAt reads %s", column)
+		}
+	}
+	if !read["name"] || !read["step"] {
+		t.Errorf("?fields=name,step,createdAt reads %v", read)
+	}
+	fields["failureReason"] = true
+	query, args = listQuery(t, "/api/v1/seedlings?limit=100", seedlingColumns(fields))
+	if !readColumns(t, s.DB, query, args)["failure_reason"] {
+		t.Error("?fields=failureReason doesn't read failure_reason")
+	}
+
+	w := serve(h, "GET", "/api/v1/seedlings?limit=100", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body)
+	}
+	if total := w.Header().Get(pipeline.TotalCountHeader); total != fmt.Sprint(seededSeedlings) {
+		t.Errorf("total %s, want %d", total, seededSeedlings)
+	}
+	if body := w.Body.String(); strings.Contains(body, `"failureReason"`) || strings.Contains(body, `"plan"`) {
+		t.Error("the default list has the large fields")
+	}
+	if n := w.Body.Len(); n > 100*largeColumnSize {
+		t.Errorf("the default list of 100 is %d bytes", n)
+	}
+
+	w = serve(h, "GET", "/api/v1/seedlings?limit=100&fields=name,step,createdAt", nil)
+	var sparse []map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &sparse); err != nil {
+		t.Fatalf("%s: %s", err, w.Body)
+	}
+	if len(sparse) != 100 {
+		t.Fatalf("%d seedlings, want 100", len(sparse))
+	}
+	for _, seedling := range sparse {
+		keys := []string{}
+		for name := range seedling {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		if got := strings.Join(keys, ","); got != "createdAt,id,name,step" {
+			t.Fatalf("seedling has %s", got)
+		}
+	}
+
+	w = serve(h, "GET", "/api/v1/seedlings?limit=1&fields=name,failureReason", nil)
+	if !strings.Contains(w.Body.String(), "failed to build the server") {
+		t.Errorf("?fields=failureReason doesn't have it: %s", w.Body)
+	}
+
+	for _, param := range []string{"bogus", "name,bogus", "llm_key", "name)%20FROM%20seedlings--"} {
+		w = serve(h, "GET", "/api/v1/seedlings?fields="+param, nil)
+		var envelope ErrorEnvelope
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("?fields=%s: %s", param, err)
+		}
+		if w.Code != http.StatusBadRequest || envelope.Error.Code != pipeline.ErrCodeInvalidRequest {
+			t.Errorf("?fields=%s: %d %s", param, w.Code, w.Body)
+		}
+	}
+}
+
+// BenchmarkListSeedlings reads every seeded row with every column, with
+// the default list's and with those of ?fields=name,step,createdAt.
+func BenchmarkListSeedlings(b *testing.B) {
+	s, _ := testServer(b)
+	seedSeedlings(b, s.DB, seededSeedlings)
+	for _, bb := range []struct {
+		name    string
+		columns []string
+	}{
+		{"all", seedlingColumns(seedlingAllFields())},
+		{"default", seedlingListColumns},
+		{"fields", seedlingColumns(map[string]bool{"name": true, "step": true, "createdAt": true})},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			query, args := listQuery(b, "/api/v1/seedlings", bb.columns)
+			for i := 0; i < b.N; i++ {
+				rows, err := s.Reads.Queryx(query, args...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for rows.Next() {
+					if _, err := rows.SliceScan(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				rows.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// newLLM is the provider completions are made with, per LLM_PROVIDER.
+// Recording starts once the server can redact what's recorded.
+func newLLM(cfg pipeline.Config) (llm.LLM, error) {
+	switch cfg.LLMProvider {
+	case pipeline.LLMProviderOpenAI, pipeline.LLMProviderRecord:
+		return llm.NewOpenAI(cfg.OpenAIKey, pipeline.ObserveLLMTokens("")), nil
+	case pipeline.LLMProviderFixture:
+		return llm.NewFixtures(cfg.FixturesDir), nil
+	}
+	return nil, fmt.Errorf("LLM_PROVIDER must be %s, %s or %s, not %q",
+		pipeline.LLMProviderOpenAI, pipeline.LLMProviderFixture, pipeline.LLMProviderRecord, cfg.LLMProvider)
+}
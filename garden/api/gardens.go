@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	gardenNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+	// reservedGardenNames are directories under repos that aren't gardens.
+	reservedGardenNames = map[string]bool{"seedlings": true}
+)
+
+// Garden is a project hosted on the instance: its seedlings have their own
+// repos directory and docker network, and their names only have to be
+// unique within it. ApprovalRequired is the approval gates of its seedlings
+// that don't set their own.
+type Garden struct {
+	Name             string              `db:"name" json:"name"`
+	Description      string              `db:"description" json:"description"`
+	ApprovalRequired store.ApprovalSteps `db:"approval_required" json:"approvalRequired,omitempty"`
+	Dir              string              `db:"-" json:"dir"`
+	Network          string              `db:"-" json:"network"`
+	CreatedAt        time.Time           `db:"created_at" json:"createdAt"`
+}
+
+// patchGardenRequest is the fields of a garden PatchGarden changes, each
+// only if it's present.
+type patchGardenRequest struct {
+	Description      *string              `json:"description"`
+	ApprovalRequired *store.ApprovalSteps `json:"approvalRequired"`
+}
+
+// gardenVar is the garden addressed by the {garden} route variable or the
+// ?garden= query parameter, or DefaultGarden if neither is set.
+func gardenVar(r *http.Request) string {
+	if garden := mux.Vars(r)["garden"]; garden != "" {
+		return garden
+	}
+	if garden := r.URL.Query().Get("garden"); garden != "" {
+		return garden
+	}
+	return store.DefaultGarden
+}
+
+// provisionGarden creates the garden's repos directory and docker network.
+// Both are left alone if they exist.
+func (s *Server) provisionGarden(ctx context.Context, garden string) error {
+	if err := os.MkdirAll(s.GardenDir(garden), 0755); err != nil {
+		return err
+	}
+	return s.Docker.EnsureNetwork(ctx, s.GardenNetwork(garden))
+}
+
+// ListGardens returns every garden, the default one included.
+func (s *Server) ListGardens(w http.ResponseWriter, r *http.Request) {
+	gardens := []Garden{}
+	if err := s.Reads.SelectContext(r.Context(), &gardens, "SELECT * FROM gardens ORDER BY name"); err != nil {
+		s.log.WithField("error", err).Error("failed to get gardens")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	for i := range gardens {
+		gardens[i].Dir = s.GardenDir(gardens[i].Name)
+		gardens[i].Network = s.GardenNetwork(gardens[i].Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&gardens); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// CreateGarden adds a garden and provisions its directory and network.
+func (s *Server) CreateGarden(w http.ResponseWriter, r *http.Request) {
+	var g Garden
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if !gardenNameRegex.MatchString(g.Name) {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+			"name must be at most 32 lowercase letters, digits and dashes", nil)
+		return
+	}
+	if reservedGardenNames[g.Name] {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "that garden name is reserved", nil)
+		return
+	}
+	if reason := checkApprovalSteps(g.ApprovalRequired); reason != "" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, reason, nil)
+		return
+	}
+	g.CreatedAt = time.Now()
+	g.Dir = s.GardenDir(g.Name)
+	g.Network = s.GardenNetwork(g.Name)
+
+	result, err := s.DB.NamedExecContext(r.Context(), `
+	 INSERT INTO gardens (name, description, approval_required, created_at)
+	 VALUES (:name, :description, :approval_required, :created_at)
+	 ON CONFLICT (name) DO NOTHING
+	 `, &g)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to insert garden")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "garden already exists", nil)
+		return
+	}
+	if err := s.provisionGarden(r.Context(), g.Name); err != nil {
+		s.log.WithField("error", err).WithField("garden", g.Name).Error("failed to provision garden")
+		// Without the row the garden can be created again once whatever
+		// failed is fixed.
+		if _, err := s.DB.ExecContext(r.Context(), "DELETE FROM gardens WHERE name = $1", g.Name); err != nil {
+			s.log.WithField("error", err).Error("failed to remove unprovisioned garden")
+		}
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to provision garden", nil)
+		return
+	}
+	pipeline.LoggerFromContext(r.Context()).WithField("garden", g.Name).Info("Created garden")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(&g); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// PatchGarden changes the garden's description and the approval gates its
+// seedlings have unless they set their own.
+func (s *Server) PatchGarden(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["garden"]
+	var g Garden
+	if err := s.DB.GetContext(r.Context(), &g, "SELECT * FROM gardens WHERE name = $1", name); err == sql.ErrNoRows {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "garden not found", nil)
+		return
+	} else if err != nil {
+		s.log.WithField("error", err).Error("failed to get garden")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	var req patchGardenRequest
+	invalid, err := decodeStrict(r.Body, &req)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if invalid == nil && req.ApprovalRequired != nil {
+		errs := fieldErrors{}
+		if reason := checkApprovalSteps(*req.ApprovalRequired); reason != "" {
+			errs.add("approvalRequired", FieldErrInvalid, reason)
+		}
+		invalid = errs.body("garden is invalid")
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+	if req.Description != nil {
+		g.Description = *req.Description
+	}
+	if req.ApprovalRequired != nil {
+		g.ApprovalRequired = *req.ApprovalRequired
+	}
+	if _, err := s.DB.NamedExecContext(r.Context(), `
+	 UPDATE gardens SET description = :description, approval_required = :approval_required WHERE name = :name
+	 `, &g); err != nil {
+		s.log.WithField("error", err).Error("failed to update garden")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	g.Dir = s.GardenDir(g.Name)
+	g.Network = s.GardenNetwork(g.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&g); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
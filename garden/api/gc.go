@@ -0,0 +1,558 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	GCReasonOrphaned  = "orphaned"
+	GCReasonUntouched = "untouched"
+	GCReasonOverQuota = "over_quota"
+	// GCReasonDeleted is why soft-deleted seedlings past DeletedRetention
+	// are purged.
+	GCReasonDeleted = "deleted"
+)
+
+type DiskUsage struct {
+	ID           hide.Int64 `json:"id,omitempty"`
+	Name         string     `json:"name"`
+	Garden       string     `json:"garden"`
+	Archived     bool       `json:"archived"`
+	Orphaned     bool       `json:"orphaned"`
+	RepoBytes    int64      `json:"repoBytes"`
+	OutputsBytes int64      `json:"outputsBytes"`
+	ImageBytes   int64      `json:"imageBytes"`
+	ArchiveBytes int64      `json:"archiveBytes"`
+	TotalBytes   int64      `json:"totalBytes"`
+
+	modifiedAt time.Time
+	deleted    bool
+}
+
+type DiskUsageReport struct {
+	Seedlings  []*DiskUsage `json:"seedlings"`
+	TotalBytes int64        `json:"totalBytes"`
+}
+
+type GCCandidate struct {
+	ID     hide.Int64 `json:"id,omitempty"`
+	Name   string     `json:"name"`
+	Garden string     `json:"garden"`
+	Reason string     `json:"reason"`
+	Bytes  int64      `json:"bytes"`
+}
+
+type GCResult struct {
+	DryRun   bool          `json:"dryRun"`
+	Archived []GCCandidate `json:"archived"`
+	Purged   []GCCandidate `json:"purged"`
+}
+
+// seedling is the seedling the usage is of, enough of it to find its repo and
+// resources.
+func (u *DiskUsage) seedling() store.Seedling {
+	return store.Seedling{Name: u.Name, Garden: u.Garden}
+}
+
+// dirSize returns the total size of the regular files under path, or 0 if it
+// doesn't exist.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return size, err
+}
+
+// blobSize returns the size of the blob at key, or 0 if there's none.
+func (s *Server) blobSize(ctx context.Context, key string) int64 {
+	info, err := s.blobs.Stat(ctx, key)
+	if err != nil {
+		return 0
+	}
+	return info.Size
+}
+
+// imageSize returns the size of the seedling's docker image, or 0 if it has
+// none.
+func (s *Server) imageSize(ctx context.Context, name string) int64 {
+	size, err := s.Docker.ImageSize(ctx, name)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// diskUsage reports the disk used by every seedling, plus any seedling
+// directories left in the repo without a database row.
+func (s *Server) diskUsage(ctx context.Context) (*DiskUsageReport, error) {
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(ctx, &seedlings, "SELECT * FROM seedlings ORDER BY created_at DESC"); err != nil {
+		return nil, err
+	}
+
+	gardens := []string{}
+	if err := s.DB.SelectContext(ctx, &gardens, "SELECT name FROM gardens WHERE name != $1 ORDER BY name", store.DefaultGarden); err != nil {
+		return nil, err
+	}
+
+	report := &DiskUsageReport{Seedlings: []*DiskUsage{}}
+	// known is keyed by repo dir, which is unique across gardens.
+	known := map[string]bool{}
+	for _, seedling := range seedlings {
+		known[s.RepoDir(seedling)] = true
+		report.Seedlings = append(report.Seedlings, &DiskUsage{
+			ID:         seedling.ID,
+			Name:       seedling.Name,
+			Garden:     seedling.GardenName(),
+			Archived:   seedling.Archived,
+			modifiedAt: seedling.ModifiedAt,
+			deleted:    seedling.DeletedAt != nil,
+		})
+	}
+
+	roots := []struct{ garden, dir string }{
+		{store.DefaultGarden, s.Config.SharedRepoDir()},
+		{store.DefaultGarden, s.GardenDir(store.DefaultGarden)},
+	}
+	for _, garden := range gardens {
+		roots = append(roots, struct{ garden, dir string }{garden, s.GardenDir(garden)})
+	}
+	for _, root := range roots {
+		entries, err := ioutil.ReadDir(root.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			usage := &DiskUsage{
+				Name:       entry.Name(),
+				Garden:     root.garden,
+				Orphaned:   true,
+				modifiedAt: entry.ModTime(),
+			}
+			if dir := s.RepoDir(usage.seedling()); !known[dir] {
+				known[dir] = true
+				report.Seedlings = append(report.Seedlings, usage)
+			}
+		}
+	}
+
+	var err error
+
+	for _, usage := range report.Seedlings {
+		seedling := usage.seedling()
+		if usage.RepoBytes, err = dirSize(s.RepoDir(seedling)); err != nil {
+			return nil, err
+		}
+		if usage.OutputsBytes, err = dirSize(s.SeedlingOutputsDir(seedling.ResourceName())); err != nil {
+			return nil, err
+		}
+		usage.ImageBytes = s.imageSize(ctx, s.ContainerName(seedling))
+		usage.ArchiveBytes = s.blobSize(ctx, seedlingArchiveKey(seedling.ResourceName()))
+		usage.TotalBytes = usage.RepoBytes + usage.OutputsBytes + usage.ImageBytes + usage.ArchiveBytes
+		report.TotalBytes += usage.TotalBytes
+	}
+	return report, nil
+}
+
+// gcCandidates picks the seedlings the GC policy would archive: orphaned
+// directories, seedlings untouched for longer than GCMaxAge, and then the
+// least recently modified seedlings until the rest fit in GCMaxTotalBytes.
+// Seedlings with a build in flight are never picked.
+func (s *Server) gcCandidates(ctx context.Context) ([]GCCandidate, error) {
+	report, err := s.diskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	live := []*DiskUsage{}
+	for _, usage := range report.Seedlings {
+		// Deleted seedlings are purged once their retention is up.
+		if usage.Archived || usage.deleted {
+			continue
+		}
+		if !usage.Orphaned {
+			lease, err := s.BuildRegistry.Lease(ctx, usage.ID)
+			if err != nil {
+				return nil, err
+			}
+			if lease != nil {
+				continue
+			}
+		}
+		live = append(live, usage)
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].modifiedAt.Before(live[j].modifiedAt) })
+
+	var total int64
+	for _, usage := range live {
+		total += usage.RepoBytes + usage.OutputsBytes + usage.ImageBytes
+	}
+
+	candidates := []GCCandidate{}
+	for _, usage := range live {
+		reason := ""
+		switch {
+		case usage.Orphaned:
+			reason = GCReasonOrphaned
+		case s.Config.GCMaxAge > 0 && time.Since(usage.modifiedAt) > s.Config.GCMaxAge:
+			reason = GCReasonUntouched
+		case s.Config.GCMaxTotalBytes > 0 && total > s.Config.GCMaxTotalBytes:
+			reason = GCReasonOverQuota
+		default:
+			continue
+		}
+		bytes := usage.RepoBytes + usage.OutputsBytes + usage.ImageBytes
+		total -= bytes
+		candidates = append(candidates, GCCandidate{
+			ID:     usage.ID,
+			Name:   usage.Name,
+			Garden: usage.Garden,
+			Reason: reason,
+			Bytes:  bytes,
+		})
+	}
+	return candidates, nil
+}
+
+// runGC archives every GC candidate and purges soft-deleted seedlings past
+// their retention. With dryRun it only reports them.
+func (s *Server) runGC(ctx context.Context, dryRun bool) (*GCResult, error) {
+	candidates, err := s.gcCandidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &GCResult{DryRun: dryRun, Archived: []GCCandidate{}, Purged: []GCCandidate{}}
+	for _, candidate := range candidates {
+		if !dryRun {
+			if err := s.archiveSeedling(ctx, candidate); err != nil {
+				return result, fmt.Errorf("archiving %s: %w", candidate.Name, err)
+			}
+		}
+		result.Archived = append(result.Archived, candidate)
+	}
+
+	expired, err := s.expiredDeletions(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, seedling := range expired {
+		if !dryRun {
+			if err := s.purgeSeedling(ctx, seedling); err != nil {
+				return result, fmt.Errorf("purging %s: %w", seedling.Name, err)
+			}
+		}
+		result.Purged = append(result.Purged, GCCandidate{ID: seedling.ID, Name: seedling.Name, Garden: seedling.GardenName(), Reason: GCReasonDeleted})
+	}
+	return result, nil
+}
+
+// gcLoop runs the GC policy every GCInterval until ctx is done.
+func (s *Server) gcLoop(ctx context.Context) {
+	if s.Config.GCInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.Config.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := s.runGC(ctx, false)
+			if err != nil {
+				s.log.WithField("error", err).Error("failed to garbage collect seedlings")
+				continue
+			}
+			if len(result.Archived) > 0 || len(result.Purged) > 0 {
+				s.log.WithField("archived", len(result.Archived)).
+					WithField("purged", len(result.Purged)).
+					Info("Garbage collected seedlings")
+			}
+		}
+	}
+}
+
+// archiveSeedling writes the seedling's repo to a tarball in the blob store,
+// then removes the repo, its container and its image. Secrets and env values
+// are shredded rather than archived, so they have to be set again after
+// unarchiving.
+func (s *Server) archiveSeedling(ctx context.Context, candidate GCCandidate) error {
+	seedling := store.Seedling{Name: candidate.Name, Garden: candidate.Garden}
+	if err := shredSecrets(s.RepoDir(seedling)); err != nil {
+		return err
+	}
+	if err := shredDir(pipeline.SeedlingEnvDir(s.RepoDir(seedling))); err != nil {
+		return err
+	}
+	if !candidate.Orphaned() {
+		if _, err := s.DB.ExecContext(ctx,
+			"DELETE FROM seedling_secrets WHERE seedling_id = $1", candidate.ID); err != nil {
+			return err
+		}
+		if _, err := s.DB.ExecContext(ctx,
+			"UPDATE seedling_env_requirements SET provided = FALSE WHERE seedling_id = $1", candidate.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.writeArchive(ctx, seedling); err != nil {
+		return err
+	}
+
+	// An own repo goes into the tarball with its history. A directory in the
+	// shared repo is removed from it too, and gets its own repo when it's
+	// unarchived.
+	dir := s.RepoDir(seedling)
+	legacy := !pipeline.HasOwnRepo(dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if legacy {
+		pipeline.CommitRepo(ctx, s.Config.SharedRepoDir(), "archive "+candidate.Name)
+	}
+
+	// The image and container can be rebuilt from the repo, so failing to
+	// remove them isn't fatal.
+	s.Docker.Remove(ctx, s.ContainerName(seedling))
+	s.Docker.RemoveImage(ctx, s.ContainerName(seedling))
+
+	if candidate.Orphaned() {
+		return nil
+	}
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET archived = TRUE, modified_at = $1, version = version + 1 WHERE id = $2", time.Now(), candidate.ID)
+	return err
+}
+
+// Orphaned reports whether the candidate is a directory without a seedling
+// row.
+func (c GCCandidate) Orphaned() bool {
+	return c.Reason == GCReasonOrphaned
+}
+
+// writeArchive puts a tarball of the seedling's repo into the blob store,
+// streaming it as it's written.
+func (s *Server) writeArchive(ctx context.Context, seedling store.Seedling) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := s.blobs.Put(ctx, seedlingArchiveKey(seedling.ResourceName()), pr, -1)
+		// Unblocks the writer if the put gave up before reading it all.
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	if err := writeTarball(pw, s.RepoDir(seedling)); err != nil {
+		pw.CloseWithError(err)
+		<-done
+		return err
+	}
+	pw.Close()
+	return <-done
+}
+
+// writeTarball writes a gzipped tarball of the repo at root, without its
+// secrets, to w.
+func writeTarball(w io.Writer, root string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		if rel == "secrets" && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractArchive restores a seedling's repo from its tarball in the blob
+// store.
+func (s *Server) extractArchive(ctx context.Context, seedling store.Seedling) error {
+	f, _, err := s.blobs.Get(ctx, seedlingArchiveKey(seedling.ResourceName()))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	root := s.RepoDir(seedling)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, filepath.FromSlash(header.Name))
+		if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+			return fmt.Errorf("archive entry %q escapes the repo", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(dst, tr); err != nil {
+				dst.Close()
+				return err
+			}
+			if err := dst.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// DiskUsage reports the disk used by each seedling's repo, outputs, image and
+// archive.
+func (s *Server) DiskUsage(w http.ResponseWriter, r *http.Request) {
+	report, err := s.diskUsage(r.Context())
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to compute disk usage")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// GarbageCollect runs the GC policy now. With ?dry=true it only lists what
+// would be archived.
+func (s *Server) GarbageCollect(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry"))
+	result, err := s.runGC(r.Context(), dryRun)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to garbage collect seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", result)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// UnarchiveSeedling restores an archived seedling's repo from its tarball.
+func (s *Server) UnarchiveSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	if !seedling.Archived {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is not archived", nil)
+		return
+	}
+
+	if err := s.extractArchive(r.Context(), seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to extract seedling archive")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if !pipeline.HasOwnRepo(s.RepoDir(seedling)) {
+		dir := s.RepoDir(seedling)
+		if err := pipeline.InitSeedlingRepo(r.Context(), dir); err != nil {
+			s.log.WithField("error", err).Error("failed to init unarchived seedling repo")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		pipeline.CommitRepo(r.Context(), dir, "unarchive "+seedling.Name)
+	}
+
+	seedling.Archived = false
+	seedling.ModifiedAt = time.Now()
+	if _, err := s.DB.NamedExecContext(r.Context(),
+		"UPDATE seedlings SET archived = :archived, modified_at = :modified_at, version = version + 1 WHERE id = :id", &seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to update seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	seedling.Version++
+	if err := s.blobs.Delete(r.Context(), seedlingArchiveKey(seedling.ResourceName())); err != nil {
+		s.log.WithField("error", err).Error("failed to remove seedling archive")
+	}
+
+	w.Header().Set("ETag", seedlingETag(seedling.Version))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
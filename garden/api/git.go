@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// applyGitDefaults fills in the global remote configuration for anything the
+// create request left out. GitRemoteURL may contain "{name}", which is
+// replaced with the seedling's name.
+func (s *Server) applyGitDefaults(ctx context.Context, seedling *store.Seedling) error {
+	if seedling.GitRemoteURL == "" && s.Config.GitRemoteURL != "" {
+		seedling.GitRemoteURL = strings.ReplaceAll(s.Config.GitRemoteURL, "{name}", seedling.Name)
+		seedling.GitPushOnComplete = s.Config.GitPushOnComplete
+	}
+	if seedling.GitBranch == "" {
+		seedling.GitBranch = s.Config.GitBranch
+	}
+	if seedling.GitRemoteURL == "" {
+		seedling.GitPushOnComplete = false
+		return nil
+	}
+
+	u, err := url.Parse(seedling.GitRemoteURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("git remoteUrl must be an https URL")
+	}
+	if u.User != nil {
+		return fmt.Errorf("git remoteUrl must not contain credentials, set the %q secret instead", pipeline.GitTokenSecret)
+	}
+	if err := exec.CommandContext(ctx, "git", "check-ref-format", "--branch", seedling.GitBranch).Run(); err != nil {
+		return errors.New("git branch is not a valid branch name")
+	}
+	return nil
+}
+
+// PushSeedling pushes a seedling's repo to its remote again, e.g. after a
+// failed push on completion.
+func (s *Server) PushSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	if seedling.GitRemoteURL == "" {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling has no git remote configured", nil)
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is not complete", map[string]string{"step": seedling.Step})
+		return
+	}
+
+	if err := s.PushRemote(r.Context(), &seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to push seedling")
+		s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to push seedling", map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// SeedlingHistory returns every recorded event of the seedling, oldest
+// first. Completion chunks are only streamed, never recorded.
+func (s *Server) SeedlingHistory(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	events := []pipeline.SeedlingEvent{}
+	if err := s.Reads.SelectContext(r.Context(), &events, `
+	 SELECT id, type, step, actor, payload, created_at
+	 FROM seedling_events WHERE seedling_id = $1 ORDER BY id
+	 `, seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling events")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&events); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,317 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var hookKinds = []string{pipeline.HookKindExec, pipeline.HookKindHTTP}
+
+// hookRequest is the body of a hook's create or update. An update without
+// a secret keeps the hook's.
+type hookRequest struct {
+	Name           string   `json:"name"`
+	Garden         string   `json:"garden"`
+	Position       int      `json:"position"`
+	Kind           string   `json:"kind"`
+	Command        []string `json:"command"`
+	URL            string   `json:"url"`
+	Secret         string   `json:"secret"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+	Blocking       bool     `json:"blocking"`
+}
+
+// SeedlingHooks returns the last run of each hook for the seedling.
+func (s *Server) SeedlingHooks(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	s.respondSeedlingHooks(w, r, seedling, http.StatusOK)
+}
+
+// RetrySeedlingHooks runs the hooks that haven't succeeded for a complete
+// seedling again. A seedling held at SeedlingStepAwaitingHooks whose
+// blocking hooks all succeed this time is completed, with a 202.
+func (s *Server) RetrySeedlingHooks(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepComplete && seedling.Step != pipeline.SeedlingStepAwaitingHooks {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict,
+			fmt.Sprintf("seedling is at %s, hooks run once it's complete", seedling.Step), nil)
+		return
+	}
+	blocked, err := s.RunHooks(r.Context(), seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to run hooks")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	status := http.StatusOK
+	if seedling.Step == pipeline.SeedlingStepAwaitingHooks && blocked == nil {
+		// Only one of concurrent requests completes the seedling.
+		now := time.Now()
+		result, err := s.DB.ExecContext(r.Context(), `
+		 UPDATE seedlings SET step = $1, step_started_at = $2, modified_at = $2, version = version + 1
+		 WHERE id = $3 AND step = $4
+		 `, pipeline.SeedlingStepComplete, now, seedling.ID, pipeline.SeedlingStepAwaitingHooks)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to update seedling step")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			seedling.Step = pipeline.SeedlingStepComplete
+			seedling.StepStartedAt = &now
+			seedling.ModifiedAt = now
+			s.Notify(r.Context(), seedling, pipeline.EventStepChanged, pipeline.SeedlingStepComplete)
+			// The container is already running, only the rest of
+			// completing it is left.
+			go s.CompleteSeedling(s.BuildRegistry.Detach(r.Context(), seedling), seedling)
+			status = http.StatusAccepted
+		}
+	}
+	s.respondSeedlingHooks(w, r, seedling, status)
+}
+
+func (s *Server) respondSeedlingHooks(w http.ResponseWriter, r *http.Request, seedling store.Seedling, status int) {
+	runs, err := s.HooksFor(r.Context(), seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling hooks")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"step": seedling.Step, "hooks": runs}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+func (s *Server) lookupHook(w http.ResponseWriter, r *http.Request) (pipeline.Hook, bool) {
+	id, err := parseID(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return pipeline.Hook{}, false
+	}
+
+	var hook pipeline.Hook
+	if err := s.DB.GetContext(r.Context(), &hook, "SELECT * FROM hooks WHERE id = $1", id); err != nil {
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "hook not found", nil)
+			return pipeline.Hook{}, false
+		}
+		s.log.WithField("error", err).Error("failed to get hook")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return pipeline.Hook{}, false
+	}
+	hook.Decode()
+	return hook, true
+}
+
+// decodeHook reads a hook's definition from the request into hook,
+// responding with what's wrong with it if it's invalid.
+func (s *Server) decodeHook(w http.ResponseWriter, r *http.Request, hook *pipeline.Hook) bool {
+	var req hookRequest
+	invalid, err := decodeStrict(r.Body, &req)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return false
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return false
+	}
+
+	errs := fieldErrors{}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs.add("name", FieldErrRequired, "name is required")
+	}
+	if req.Garden != "" {
+		exists, err := s.GardenExists(r.Context(), req.Garden)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get garden")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return false
+		}
+		if !exists {
+			errs.add("garden", FieldErrNotFound, fmt.Sprintf("garden %q doesn't exist", req.Garden))
+		}
+	}
+	switch req.Kind {
+	case pipeline.HookKindExec:
+		if len(req.Command) == 0 || req.Command[0] == "" {
+			errs.add("command", FieldErrRequired, "exec hooks need a command")
+		}
+		if req.URL != "" {
+			errs.add("url", FieldErrInvalid, "exec hooks don't have a url")
+		}
+	case pipeline.HookKindHTTP:
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("url", FieldErrInvalid, "url must be an http or https URL")
+		}
+		if len(req.Command) > 0 {
+			errs.add("command", FieldErrInvalid, "http hooks don't have a command")
+		}
+	case "":
+		errs.add("kind", FieldErrRequired, fmt.Sprintf("kind is required, one of %s", strings.Join(hookKinds, ", ")))
+	default:
+		errs.add("kind", FieldErrInvalid, fmt.Sprintf("kind must be one of %s", strings.Join(hookKinds, ", ")))
+	}
+	if req.TimeoutSeconds < 0 || req.TimeoutSeconds > pipeline.MAX_HOOK_TIMEOUT_SECONDS {
+		errs.add("timeoutSeconds", FieldErrInvalid,
+			fmt.Sprintf("timeoutSeconds must be between 0 and %d", pipeline.MAX_HOOK_TIMEOUT_SECONDS))
+	}
+	if invalid := errs.body("hook is invalid"); invalid != nil {
+		s.respondInvalid(w, invalid)
+		return false
+	}
+
+	command, err := json.Marshal(req.Command)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to encode hook command")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return false
+	}
+	hook.Name = req.Name
+	hook.Garden = req.Garden
+	hook.Position = req.Position
+	hook.Kind = req.Kind
+	hook.CommandJSON = ""
+	if len(req.Command) > 0 {
+		hook.CommandJSON = string(command)
+	}
+	hook.Command = req.Command
+	hook.URL = req.URL
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	hook.TimeoutSeconds = req.TimeoutSeconds
+	hook.Blocking = req.Blocking
+	return true
+}
+
+func (s *Server) respondHook(w http.ResponseWriter, hook pipeline.Hook, status int) {
+	hook.Secret = ""
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(&hook); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// ListHooks returns every hook in the order they run, without secrets.
+func (s *Server) ListHooks(w http.ResponseWriter, r *http.Request) {
+	hooks := []pipeline.Hook{}
+	if err := s.DB.SelectContext(r.Context(), &hooks, "SELECT * FROM hooks ORDER BY position, id"); err != nil {
+		s.log.WithField("error", err).Error("failed to get hooks")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Decode()
+		hooks[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&hooks); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+func (s *Server) GetHook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.lookupHook(w, r)
+	if !ok {
+		return
+	}
+	s.respondHook(w, hook, http.StatusOK)
+}
+
+// CreateHook adds a hook run for every complete seedling of its garden, or
+// of every garden if it has none.
+func (s *Server) CreateHook(w http.ResponseWriter, r *http.Request) {
+	var hook pipeline.Hook
+	if !s.decodeHook(w, r, &hook) {
+		return
+	}
+	hook.CreatedAt = time.Now()
+	hook.ModifiedAt = hook.CreatedAt
+
+	result, err := s.DB.NamedExecContext(r.Context(), `
+	 INSERT INTO hooks (name, garden, position, kind, command, url, secret, timeout_seconds, blocking, created_at, modified_at)
+	 VALUES (:name, :garden, :position, :kind, :command, :url, :secret, :timeout_seconds, :blocking, :created_at, :modified_at)
+	 `, &hook)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to insert hook")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get last inserted id")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	hook.ID = hide.Int64(id)
+	s.respondHook(w, hook, http.StatusCreated)
+}
+
+// UpdateHook replaces a hook's definition. Seedlings whose hooks already ran
+// aren't affected until they're retried or rebuilt.
+func (s *Server) UpdateHook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.lookupHook(w, r)
+	if !ok {
+		return
+	}
+	if !s.decodeHook(w, r, &hook) {
+		return
+	}
+	hook.ModifiedAt = time.Now()
+
+	if _, err := s.DB.NamedExecContext(r.Context(), `
+	 UPDATE hooks SET name = :name, garden = :garden, position = :position, kind = :kind, command = :command,
+	   url = :url, secret = :secret, timeout_seconds = :timeout_seconds, blocking = :blocking, modified_at = :modified_at
+	 WHERE id = :id
+	 `, &hook); err != nil {
+		s.log.WithField("error", err).Error("failed to update hook")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	s.respondHook(w, hook, http.StatusOK)
+}
+
+// DeleteHook removes a hook and its runs. Seedlings it was holding at
+// SeedlingStepAwaitingHooks are completed by retrying their hooks.
+func (s *Server) DeleteHook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.lookupHook(w, r)
+	if !ok {
+		return
+	}
+	for _, query := range []string{"DELETE FROM seedling_hooks WHERE hook_id = $1", "DELETE FROM hooks WHERE id = $1"} {
+		if _, err := s.DB.ExecContext(r.Context(), query, hook.ID); err != nil {
+			s.log.WithField("error", err).Error("failed to delete hook")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": "hook deleted"}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
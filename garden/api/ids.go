@@ -0,0 +1,27 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/c2h5oh/hide"
+)
+
+// parseID decodes an id from a URL. IDs are obfuscated everywhere they leave
+// the server, and hide.Int64 marshals to JSON in the same form, so the ID
+// returned by a POST addresses the same row in every other request.
+func parseID(param string) (hide.Int64, error) {
+	if param == "" {
+		return 0, errors.New("id is required")
+	}
+	n, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid id")
+	}
+	return hide.Int64(hide.Default.Int64Deobfuscate(n)), nil
+}
+
+// parseSeedlingID decodes the {id} of a seedling route.
+func parseSeedlingID(vars map[string]string) (hide.Int64, error) {
+	return parseID(vars["id"])
+}
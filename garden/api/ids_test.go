@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+func TestParseID(t *testing.T) {
+	obfuscated := strconv.FormatInt(hide.Default.Int64Obfuscate(42), 10)
+	tests := []struct {
+		param string
+		want  hide.Int64
+		err   bool
+	}{
+		{param: obfuscated, want: 42},
+		{param: "", err: true},
+		{param: "echo", err: true},
+		{param: "12.5", err: true},
+		{param: obfuscated + "x", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			id, err := parseSeedlingID(map[string]string{"id": tt.param})
+			if (err != nil) != tt.err {
+				t.Fatalf("error %v", err)
+			}
+			if id != tt.want {
+				t.Errorf("id %d, want %d", id, tt.want)
+			}
+		})
+	}
+}
+
+// TestSeedlingIDs checks that the ID a create returns addresses the same
+// seedling for every verb.
+func TestSeedlingIDs(t *testing.T) {
+	s, _ := testServer(t)
+	h := s.Routes()
+
+	// Each create's plan is given, so the seedling waits for approval
+	// rather than being built.
+	create := func(name string) string {
+		t.Helper()
+		body := `{"name": "` + name + `", "description": "echoes what it's sent", "plan": {
+			"summary": "An echo service.",
+			"rpcs": [{"name": "Say", "request": "SayRequest", "response": "SayReply", "description": "returns the text it's sent"}]
+		}}`
+		w := serve(h, "POST", "/api/v1/seedlings", strings.NewReader(body))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("create %s: %d %s", name, w.Code, w.Body)
+		}
+		var created map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		return "/api/v1/seedlings/" + string(created["id"])
+	}
+	// Two seedlings, so that an ID used unobfuscated is likely to address
+	// the wrong one or none.
+	other := create("other")
+	path := create("echo")
+
+	get := func(path string) (store.Seedling, *httptest.ResponseRecorder) {
+		t.Helper()
+		w := serve(h, "GET", path, nil)
+		var got store.Seedling
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return got, w
+	}
+
+	got, w := get(path)
+	if w.Code != http.StatusOK || got.Name != "echo" {
+		t.Fatalf("get: %d %s", w.Code, w.Body)
+	}
+	var stored int64
+	if err := s.DB.Get(&stored, "SELECT id FROM seedlings WHERE name = 'echo'"); err != nil {
+		t.Fatal(err)
+	}
+	if path != pipeline.SeedlingPath(hide.Int64(stored)) {
+		t.Errorf("created at %s, want %s", path, pipeline.SeedlingPath(hide.Int64(stored)))
+	}
+
+	req := newRequest("PUT", path, strings.NewReader(`{"name": "echo", "description": "echoes what it's sent, loudly"}`))
+	req.Header.Set("If-Match", w.Header().Get("ETag"))
+	if w := serveRequest(h, req); w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	if got, _ := get(path); got.Description != "echoes what it's sent, loudly" {
+		t.Errorf("updated description is %q", got.Description)
+	}
+	if got, _ := get(other); got.Description != "echoes what it's sent" {
+		t.Errorf("the update changed the other seedling: %q", got.Description)
+	}
+
+	if w := serve(h, "DELETE", path, nil); w.Code >= 300 {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if _, w := get(path); w.Code != http.StatusNotFound {
+		t.Errorf("get after the delete: %d %s", w.Code, w.Body)
+	}
+	if got, w := get(other); w.Code != http.StatusOK || got.Name != "other" {
+		t.Errorf("the delete removed the other seedling: %d %s", w.Code, w.Body)
+	}
+
+	for _, method := range []string{"GET", "PUT", "PATCH", "DELETE"} {
+		w := serve(h, method, "/api/v1/seedlings/echo", strings.NewReader(`{}`))
+		var envelope ErrorEnvelope
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusBadRequest || envelope.Error.Code != pipeline.ErrCodeInvalidRequest {
+			t.Errorf("%s of a non-numeric ID: %d %s", method, w.Code, w.Body)
+		}
+	}
+}
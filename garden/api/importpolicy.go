@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+func (s *Server) GetImportPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := s.CurrentImportPolicy().ImportPolicy
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&policy); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// PutImportPolicy replaces the import policy until the next restart, which
+// goes back to the one configured in the environment.
+func (s *Server) PutImportPolicy(w http.ResponseWriter, r *http.Request) {
+	var p pipeline.ImportPolicy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	policy, err := pipeline.CompileImportPolicy(p)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	s.ImportPolicyMu.Lock()
+	s.ImportPolicy = policy
+	s.ImportPolicyMu.Unlock()
+	pipeline.LoggerFromContext(r.Context()).WithField("updated_by", pipeline.APIKeyFromContext(r.Context())).Info("Updated import policy")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&policy.ImportPolicy); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
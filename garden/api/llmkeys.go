@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// llmKey is the OpenAI key a request's seedlings are built with.
+type llmKey struct {
+	// id is the key's Seedling.LLMKeyID.
+	id string
+	// sealed is a key brought in X-OpenAI-Key, encrypted for storing.
+	sealed   string
+	provider *pipeline.LLMProvider
+	// source is where the key came from, for telling clients which one
+	// doesn't work.
+	source string
+}
+
+// llmKeyFingerprint identifies a key brought with a request without
+// revealing it.
+func llmKeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// requestLLMKey is the key the request's seedlings are built with: the one
+// in X-OpenAI-Key, the OPENAI_API_KEYS one of the request's API key, or the
+// server's.
+func (s *Server) requestLLMKey(r *http.Request) (llmKey, error) {
+	if key := strings.TrimSpace(r.Header.Get(pipeline.OpenAIKeyHeader)); key != "" {
+		id := pipeline.LLMKeyRequestPrefix + llmKeyFingerprint(key)
+		sealed, err := s.sealLLMKey(id, key)
+		if err != nil {
+			return llmKey{}, err
+		}
+		return llmKey{id: id, sealed: sealed, provider: s.LLMs.Get(id, key), source: pipeline.OpenAIKeyHeader}, nil
+	}
+	name := pipeline.APIKeyFromContext(r.Context())
+	if key, ok := s.Config.OpenAIKeys[name]; ok {
+		id := pipeline.LLMKeyAPIKeyPrefix + name
+		return llmKey{id: id, provider: s.LLMs.Get(id, key), source: "OPENAI_API_KEYS"}, nil
+	}
+	return llmKey{provider: s.LLMs.Get("", ""), source: "OPENAI_API_KEY"}, nil
+}
+
+// checkLLMKey returns the key the request's seedlings are built with once
+// it's been checked to work, or responds with why it can't be used. It's
+// called before anything is stored, so a seedling is never created that
+// can't be built.
+func (s *Server) checkLLMKey(w http.ResponseWriter, r *http.Request) (llmKey, bool) {
+	if r.Header.Get(pipeline.OpenAIKeyHeader) != "" && s.LLMKeyAEAD == nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+			pipeline.OpenAIKeyHeader+" isn't accepted, LLM_KEY_SECRET is not set", nil)
+		return llmKey{}, false
+	}
+	key, err := s.requestLLMKey(r)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to seal OpenAI key")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return llmKey{}, false
+	}
+	if err := key.provider.Check(r.Context()); err != nil {
+		pipeline.LoggerFromContext(r.Context()).WithField("error", err).WithField("key", key.source).Warn("LLM key check failed")
+		details := map[string]string{"key": key.source}
+		if key.source == pipeline.OpenAIKeyHeader {
+			// It's the client's own key, so they may see why.
+			details["error"] = err.Error()
+		}
+		s.respondError(w, http.StatusServiceUnavailable, pipeline.ErrCodeUnavailable, "LLM provider not configured", details)
+		return llmKey{}, false
+	}
+	return key, true
+}
+
+// checkServerLLMKey checks the server's key at startup, so a missing or bad
+// one shows up before the first seedling is created.
+func (s *Server) checkServerLLMKey(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := s.LLMs.Get("", "").Check(ctx); err != nil {
+		s.log.WithField("error", err).
+			Error("LLM provider not configured, seedlings can only be created with X-OpenAI-Key or an OPENAI_API_KEYS key")
+		return
+	}
+	s.log.Info("OpenAI key checked")
+}
+
+// sealLLMKey encrypts the key for storing. The id is authenticated with it,
+// so a sealed key only opens for the seedling row it was stored in.
+func (s *Server) sealLLMKey(id, key string) (string, error) {
+	nonce := make([]byte, s.LLMKeyAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(s.LLMKeyAEAD.Seal(nonce, nonce, []byte(key), []byte(id))), nil
+}
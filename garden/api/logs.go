@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tensorscale/garden/garden/dockerx"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+type logLine struct {
+	stream string
+	text   string
+}
+
+// acquireLogFollowSession reserves one of the configured concurrent follow
+// sessions. It returns false if all of them are in use.
+func (s *Server) acquireLogFollowSession() bool {
+	select {
+	case s.logFollowSessions <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseLogFollowSession() {
+	<-s.logFollowSessions
+}
+
+// scanLogStream sends every line read from r to lines, labeled with stream.
+func scanLogStream(r io.Reader, stream string, lines chan<- logLine, wg *sync.WaitGroup) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines <- logLine{stream: stream, text: scanner.Text()}
+	}
+	// A line too long to scan mustn't leave the logs blocked writing.
+	io.Copy(io.Discard, r)
+}
+
+// SeedlingLogs returns the logs of a seedling's running container, with each
+// line labeled by the stream (stdout/stderr) it came from. With
+// ?follow=true the logs are streamed, as server-sent events if the client
+// accepts text/event-stream and as chunked plain text otherwise.
+func (s *Server) SeedlingLogs(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is not complete",
+			map[string]string{"step": seedling.Step})
+		return
+	}
+	if state, err := s.Docker.State(r.Context(), s.ContainerName(seedling)); err != nil || state != "running" {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling container is not running", nil)
+		return
+	}
+
+	tail := r.URL.Query().Get("tail")
+	if tail == "" {
+		tail = "100"
+	}
+	if _, err := strconv.Atoi(tail); err != nil && tail != "all" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "tail must be a number or \"all\"", nil)
+		return
+	}
+	opts := dockerx.LogsOptions{Tail: tail, Since: r.URL.Query().Get("since")}
+
+	ctx := r.Context()
+	follow := r.URL.Query().Get("follow") == "true"
+	if follow {
+		if !s.acquireLogFollowSession() {
+			s.respondError(w, http.StatusTooManyRequests, pipeline.ErrCodeTooManyRequests, "too many log follow sessions", nil)
+			return
+		}
+		defer s.releaseLogFollowSession()
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Config.LogsFollowMaxDuration)
+		defer cancel()
+		opts.Follow = true
+	}
+
+	stdout, stdoutW := io.Pipe()
+	stderr, stderrW := io.Pipe()
+	logsErr := make(chan error, 1)
+	go func() {
+		err := s.Docker.Logs(ctx, s.ContainerName(seedling), opts, stdoutW, stderrW)
+		stdoutW.Close()
+		stderrW.Close()
+		logsErr <- err
+	}()
+
+	lines := make(chan logLine)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go scanLogStream(stdout, "stdout", lines, &wg)
+	go scanLogStream(stderr, "stderr", lines, &wg)
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	sse := follow && strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for line := range lines {
+		if sse {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", line.stream, line.text)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", line.stream, line.text)
+		}
+		if follow && flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if err := <-logsErr; err != nil {
+		s.log.WithField("error", err).Error("failed to read container logs")
+	}
+}
@@ -0,0 +1,679 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/dockerx"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+	"github.com/urfave/cli"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+func (s *Server) apiAccessHandler(w http.ResponseWriter, r *http.Request) {
+	s.log.Info("hi")
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Proxy to the host port the seedling's HTTP port is published on.
+	var seedling store.Seedling
+	if err := s.Reads.GetContext(r.Context(), &seedling,
+		"SELECT * FROM seedlings WHERE name = $1 AND garden = $2 AND deleted_at IS NULL", name, gardenVar(r)); err != nil {
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling not found", nil)
+			return
+		}
+		s.log.WithField("error", err).Error("failed to get seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	_, httpPort, err := s.PublishedPorts(r.Context(), seedling)
+	if err != nil && !errors.Is(err, dockerx.ErrNoContainer) {
+		s.log.WithField("error", err).Error("Failed to inspect seedling container")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to find seedling container", nil)
+		return
+	}
+	if httpPort == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling container isn't running", nil)
+		return
+	}
+	port := strconv.Itoa(httpPort)
+	r.URL.Path = "/" + vars["rest"]
+	if r.URL.Query().Get("exampleId") != "" && !s.applyExample(w, r, seedling) {
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   "localhost:" + port,
+	})
+
+	proxy.ServeHTTP(w, r)
+}
+
+func (s *Server) patchHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	garden := gardenVar(r)
+	if garden != store.DefaultGarden && !gardenNameRegex.MatchString(garden) {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "garden not found", nil)
+		return
+	}
+
+	cmd := exec.CommandContext(r.Context(),
+		"git",
+		"log",
+		"--pretty=format:'%h %s'",
+		"-p",
+		".",
+	)
+	cmd.Dir = s.RepoDir(store.Seedling{Name: name, Garden: garden})
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		s.log.WithField("error", err).Error("Failed to run git log")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to read seedling history", nil)
+		return
+	}
+	output = []byte(base64.StdEncoding.EncodeToString(output))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"history":"%s"}`, string(output))))
+}
+
+func ServeCmd(cliCtx *cli.Context, log *logrus.Entry, cfg pipeline.Config) error {
+	lock, err := lockDataDir(cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	db, err := store.OpenDB(store.SQLiteDSN(cfg.DBPath(), cfg.SQLiteBusyTimeout, false))
+	if err != nil {
+		return err
+	}
+	reads, err := store.OpenReadDB(store.SQLiteDSN(cfg.DBPath(), cfg.SQLiteBusyTimeout, true), cfg.SQLiteReadConns)
+	if err != nil {
+		return err
+	}
+	if err := setupRepos(context.Background(), cfg); err != nil {
+		return err
+	}
+
+	provider, err := newLLM(cfg)
+	if err != nil {
+		return err
+	}
+	s := NewServer(db, cfg, log, provider)
+	s.Reads = reads
+	s.setupDocker()
+	s.setupBlobs()
+	if err := s.setupBuilder(); err != nil {
+		return err
+	}
+	s.checkServerLLMKey(context.Background())
+	registerQueueDepth(s.Scheduler)
+	if cfg.Metrics && cfg.MetricsAddr != "" {
+		go func() {
+			log.WithField("addr", cfg.MetricsAddr).Info("Serving metrics")
+			if err := http.ListenAndServe(cfg.MetricsAddr, metricsHandler()); err != nil {
+				log.WithField("error", err).Error("metrics server stopped")
+			}
+		}()
+	}
+	if err := s.backfillTimestamps(context.Background()); err != nil {
+		return err
+	}
+	if err := s.resumeBuilds(); err != nil {
+		return err
+	}
+	// Dockerfiles build in the toolchain's golang image.
+	go s.prePullBaseImages(append([]string{pipeline.GoImage(cfg.GoToolchain)}, cfg.BaseImages...))
+	go s.Toolchain(context.Background(), false)
+	go s.gcLoop(context.Background())
+	go s.outputsLoop(context.Background())
+	go s.rebuildLoop(context.Background())
+	go s.approvalLoop(context.Background())
+	go s.walCheckpointLoop(context.Background())
+	go s.reapLoop(context.Background())
+	go s.reportLoop(context.Background())
+
+	log.WithField("service", "garden-api").WithField("addr", cfg.ListenAddr).Info("Listening")
+	if err := http.ListenAndServe(cfg.ListenAddr, otelhttp.NewHandler(s.Routes(), "garden-api")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// prepareName normalizes the seedling's name into one its container, image
+// and repo can be named after, keeping the one it was given in RequestedName
+// if that's different, and adds why it can't be to errs. Creates and
+// renames both go through it.
+func (s *Server) prepareName(errs *fieldErrors, seedling *store.Seedling) {
+	n := len(*errs)
+	checkSeedlingName(errs, seedling.Name)
+	requested := strings.TrimSpace(seedling.Name)
+	seedling.Name = normalizeSlug(pipeline.CleanFilePath(seedling.Name))
+	seedling.RequestedName = ""
+	if seedling.Name != requested {
+		seedling.RequestedName = requested
+	}
+	if len(*errs) == n {
+		if reason := s.checkResourceName(*seedling); reason != "" {
+			errs.add("name", FieldErrInvalid, reason)
+		}
+	}
+}
+
+// prepareSeedling validates a create request and fills in its defaults. It
+// returns every problem with the request as a 422 body, or an error if it
+// couldn't tell.
+func (s *Server) prepareSeedling(ctx context.Context, seedling *store.Seedling) (*ErrorBody, error) {
+	errs := fieldErrors{}
+	if seedling.Garden == "" {
+		seedling.Garden = store.DefaultGarden
+	}
+	s.prepareName(&errs, seedling)
+	exists, err := s.GardenExists(ctx, seedling.Garden)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		errs.add("garden", FieldErrNotFound, fmt.Sprintf("there's no garden %q", seedling.Garden))
+	}
+	checkDescription(&errs, seedling.Description)
+	if seedling.ExperimentID != nil {
+		errs.add("experimentId", FieldErrInvalid, "seedlings are added to experiments by POST /api/v1/experiments")
+	}
+	if seedling.Plan != nil {
+		// e.g. one previewed with /api/v1/plan and edited
+		if reason := seedling.Plan.Check(); reason != "" {
+			errs.add("plan", FieldErrInvalid, reason)
+		}
+	} else if seedling.AutoApprove && len(strings.Fields(seedling.Description)) < minDescriptionWords {
+		errs.add("description", FieldErrTooShort, fmt.Sprintf(
+			"description must be at least %d words to build without reviewing a plan, describe what the service does or create it without autoApprove",
+			minDescriptionWords))
+	}
+	seedling.Step = pipeline.SeedlingStepProtobufs
+	if !seedling.AutoApprove {
+		seedling.Step = pipeline.SeedlingStepPlan
+	}
+	now := time.Now()
+	seedling.StepStartedAt = &now
+	if seedling.Platform == "" {
+		seedling.Platform = pipeline.HostPlatform()
+	}
+	if err := validatePlatform(ctx, seedling.Platform); err != nil {
+		errs.add("platform", FieldErrInvalid, err.Error())
+	}
+	if seedling.Toolchain = strings.TrimPrefix(strings.TrimSpace(seedling.Toolchain), "go"); seedling.Toolchain == "" {
+		seedling.Toolchain = s.Config.GoToolchain
+	}
+	if reason := s.CheckGoToolchain(seedling.Toolchain); reason != "" {
+		errs.add("toolchain", FieldErrInvalid, reason)
+	}
+	seedling.SeedlingGit = store.SeedlingGit{
+		GitRemoteURL:      seedling.GitRemoteURL,
+		GitBranch:         seedling.GitBranch,
+		GitPushOnComplete: seedling.GitPushOnComplete,
+	}
+	if err := s.applyGitDefaults(ctx, seedling); err != nil {
+		errs.add("git", FieldErrInvalid, err.Error())
+	}
+	if tags, err := normalizeTags(seedling.Tags); err != nil {
+		errs.add("tags", FieldErrInvalid, err.Error())
+	} else {
+		seedling.Tags = tags
+	}
+	if seedling.Template != "" {
+		t, err := s.GetTemplate(ctx, seedling.Template)
+		if err == sql.ErrNoRows {
+			errs.add("template", FieldErrNotFound, fmt.Sprintf("there's no template %q", seedling.Template))
+		} else if err != nil {
+			return nil, err
+		} else if reason := t.CheckParams(seedling.TemplateParams); reason != "" {
+			errs.add("templateParams", FieldErrInvalid, reason)
+		}
+	} else if len(seedling.TemplateParams) > 0 {
+		errs.add("templateParams", FieldErrInvalid, "templateParams requires a template")
+	}
+	seedling.Model = strings.TrimSpace(seedling.Model)
+	// Each field is checked on its own so both can be reported.
+	if reason := (store.SeedlingGeneration{Temperature: seedling.Temperature}).Check(s.Config.MaxTokensLimit); reason != "" {
+		errs.add("temperature", FieldErrInvalid, reason)
+	}
+	if reason := (store.SeedlingGeneration{MaxTokens: seedling.MaxTokens}).Check(s.Config.MaxTokensLimit); reason != "" {
+		errs.add("maxTokens", FieldErrInvalid, reason)
+	}
+	if reason := checkResources(seedling.SeedlingResources, s.Config); reason != "" {
+		errs.add("resources", FieldErrInvalid, reason)
+	}
+	seedling.SeedlingResources = pipeline.ResourcesWithDefaults(seedling.SeedlingResources, s.Config)
+	if reason := seedling.SeedlingPorts.Check(); reason != "" {
+		errs.add("ports", FieldErrInvalid, reason)
+	}
+	seedling.SeedlingPorts = seedling.SeedlingPorts.WithDefaults()
+	if err := s.checkDependsOn(ctx, &errs, seedling); err != nil {
+		return nil, err
+	}
+	if err := seedling.SeedlingRebuildSchedule.SetSchedule(seedling.RebuildSchedule, now); err != nil {
+		errs.add("rebuildSchedule", FieldErrInvalid, err.Error())
+	}
+	seedling.Bitrot, seedling.BitrotReason = false, ""
+	seedling.SeedlingPromotion = store.SeedlingPromotion{}
+	if reason := checkApprovalSteps(seedling.ApprovalRequired); reason != "" {
+		errs.add("approvalRequired", FieldErrInvalid, reason)
+	}
+	seedling.SeedlingApproval = store.SeedlingApproval{ApprovalRequired: seedling.ApprovalRequired}
+	return errs.body("seedling is invalid"), nil
+}
+
+// insertSeedling inserts a prepared seedling, its tags and its dependencies,
+// setting its ID and timestamps. Later updates bump modified_at through the
+// seedlings_modified_at trigger unless they set it themselves.
+func insertSeedling(ctx context.Context, tx *sqlx.Tx, seedling *store.Seedling) error {
+	seedling.CreatedAt = time.Now()
+	seedling.ModifiedAt = seedling.CreatedAt
+	result, err := tx.NamedExecContext(ctx, `
+	 INSERT INTO seedlings
+	 (name, garden, description, created_at, modified_at, step, step_started_at, skip_tests, platform, toolchain, llm_key_id, llm_key,
+	  git_remote_url, git_branch, git_push_on_complete, template, template_params, plan, model, temperature, max_tokens,
+	  memory, cpus, pids_limit, restart_policy, grpc_container_port, http_container_port,
+	  experiment_id, experiment_variant, rebuild_schedule, rebuild_due_at, auto_heal, approval_required)
+	 VALUES (:name, :garden, :description, :created_at, :modified_at, :step, :step_started_at, :skip_tests, :platform, :toolchain, :llm_key_id, :llm_key,
+	  :git_remote_url, :git_branch, :git_push_on_complete, :template, :template_params, :plan, :model, :temperature, :max_tokens,
+	  :memory, :cpus, :pids_limit, :restart_policy, :grpc_container_port, :http_container_port,
+	  :experiment_id, :experiment_variant, :rebuild_schedule, :rebuild_due_at, :auto_heal, :approval_required)
+	 `, seedling)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	seedling.ID = hide.Int64(id)
+	if err := store.SetTags(ctx, tx, seedling.ID, seedling.Tags); err != nil {
+		return err
+	}
+	return setDependencies(ctx, tx, seedling)
+}
+
+// CreateSeedling creates a seedling and responds 202 with a Location while
+// it's built, or 429 if the build queue is full. With ?sync=true it waits,
+// for at most CreateSyncMaxWait, for a seedling that's built straight away
+// to get past protobufs: 200 if it did, 422 if it failed there and 202 if
+// it's still at it.
+func (s *Server) CreateSeedling(w http.ResponseWriter, r *http.Request) {
+	wait, ok := s.syncParam(w, r)
+	if !ok {
+		return
+	}
+	var seedling store.Seedling
+	invalid, err := decodeStrict(r.Body, &seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+	if garden := mux.Vars(r)["garden"]; garden != "" {
+		seedling.Garden = garden
+	}
+	invalid, err = s.prepareSeedling(r.Context(), &seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to validate seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+	taken, err := s.TakenNames(r.Context(), []string{seedling.ResourceName()})
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling names")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if deleted, ok := taken[seedling.ResourceName()]; ok {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, nameTakenMessage(deleted), map[string]string{"name": seedling.Name})
+		return
+	}
+	vectors, duplicates, err := s.findDuplicates(r.Context(), []store.Seedling{seedling})
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to find duplicate seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if len(duplicates[0]) > 0 && !seedling.IgnoreDuplicates {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodePossibleDuplicate, duplicateMessage(duplicates[0]),
+			map[string][]SimilarSeedling{"matches": duplicates[0]})
+		return
+	}
+	key, ok := s.checkLLMKey(w, r)
+	if !ok {
+		return
+	}
+	seedling.LLMKeyID, seedling.LLMKey = key.id, key.sealed
+	if seedling.Plan == nil && !seedling.AutoApprove {
+		ctx := llm.WithFixtureName(pipeline.WithLLM(r.Context(), key.provider.LLM), seedling.Name)
+		if seedling.Plan, err = s.planSeedling(ctx, seedling.Description); err != nil {
+			s.log.WithField("error", err).Error("failed to plan seedling")
+			s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to plan seedling", nil)
+			return
+		}
+	}
+	if seedling.AutoApprove && !s.checkQueue(w, r, 1) {
+		return
+	}
+
+	if err := s.InTx(r.Context(), func(tx *sqlx.Tx) error {
+		if err := insertSeedling(r.Context(), tx, &seedling); err != nil {
+			return err
+		}
+		if vectors == nil {
+			return nil
+		}
+		return s.storeEmbedding(r.Context(), tx, seedling.ID, vectors[0])
+	}); store.SQLiteUnique(err) {
+		// Another create took the name since it was checked
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, nameTakenMessage(false), map[string]string{"name": seedling.Name})
+		return
+	} else if err != nil {
+		s.log.WithField("error", err).Error("failed to insert seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	s.Emit(r.Context(), seedling.ID, pipeline.SeedlingEvent{Type: pipeline.EventCreated, Step: seedling.Step})
+
+	// The repo's written and the seedling built past the request.
+	ctx := s.BuildRegistry.Detach(r.Context(), seedling)
+	if err := s.WriteSeedlingToRepo(ctx, seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to write seedling to repo")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	// Seedlings with a plan are built once it's approved.
+	var events <-chan pipeline.SeedlingEvent
+	if seedling.AutoApprove {
+		if wait {
+			var unsubscribe func()
+			events, unsubscribe = s.Events.Subscribe(seedling.ID)
+			defer unsubscribe()
+		}
+		s.SubmitBuild(ctx, seedling)
+	}
+
+	w.Header().Set("Location", pipeline.SeedlingPath(seedling.ID))
+	status := http.StatusAccepted
+	if events != nil {
+		current, done, err := s.awaitFirstStep(r.Context(), events, seedling)
+		switch {
+		case r.Context().Err() != nil:
+			return
+		case err != nil:
+			s.log.WithField("error", err).Error("failed to wait for seedling build")
+		case done && current.Step == pipeline.SeedlingStepFailed:
+			s.respondError(w, http.StatusUnprocessableEntity, pipeline.ErrCodeBuildFailed,
+				"seedling failed at "+current.FailedStep+": "+current.FailureReason, newAcceptedSeedling(&current))
+			return
+		case done:
+			seedling, status = current, http.StatusOK
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(newAcceptedSeedling(&seedling)); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+func (s *Server) GetSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.attachETAs(r.Context(), []*store.Seedling{&seedling}); err != nil {
+		s.log.WithField("error", err).Error("failed to compute seedling eta")
+	}
+	lease, err := s.BuildRegistry.Lease(r.Context(), seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get build lease")
+	}
+	seedling.Lease = lease
+	seedling.TraceURL = s.TraceURL(seedling.TraceID)
+	seedling.SeedlingResources = pipeline.ResourcesWithDefaults(seedling.SeedlingResources, s.Config)
+	if err := s.AttachTags(r.Context(), []*store.Seedling{&seedling}); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling tags")
+	}
+	if err := s.attachContainerStates(r.Context(), []*store.Seedling{&seedling}); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling container state")
+	}
+	if seedling.Env, err = s.envRequirements(r.Context(), seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling env requirements")
+	}
+	if seedling.Steps, err = s.stepStatuses(r.Context(), seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling step statuses")
+	}
+	if err := s.attachDependencies(r.Context(), &seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling dependencies")
+	}
+	if wantsExpand(r.URL.Query().Get("expand"), ExpandProgress) {
+		seedling.Progress = s.seedlingProgress(r.Context(), seedling)
+	}
+
+	// Return the seedling as JSON
+	w.Header().Set("ETag", seedlingETag(seedling.Version))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+}
+
+// UpdateSeedling updates a seedling by its id with the given fields and returns it as JSON
+func (s *Server) UpdateSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok || !s.checkIfMatch(w, r, seedling) {
+		return
+	}
+
+	// Parse and validate the request body as a seedling struct
+	var req store.Seedling
+	invalid, err := decodeStrict(r.Body, &req)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	// A rename is normalized and checked like the name of a create.
+	renamed := seedling
+	renamed.Name = req.Name
+	if invalid == nil {
+		errs := fieldErrors{}
+		s.prepareName(&errs, &renamed)
+		checkDescription(&errs, req.Description)
+		if req.Tags != nil {
+			if _, err := normalizeTags(req.Tags); err != nil {
+				errs.add("tags", FieldErrInvalid, err.Error())
+			}
+		}
+		invalid = errs.body("seedling is invalid")
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+
+	if renamed.Name != seedling.Name {
+		taken, err := s.TakenNames(r.Context(), []string{renamed.ResourceName()})
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get seedling names")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		if deleted, ok := taken[renamed.ResourceName()]; ok {
+			s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, nameTakenMessage(deleted), map[string]string{"name": renamed.Name})
+			return
+		}
+	}
+
+	// The id in the URL is the seedling's, whatever the body says
+	before := seedling
+	described := seedling.Description != req.Description
+	seedling.Name, seedling.RequestedName = renamed.Name, renamed.RequestedName
+	seedling.Description = req.Description
+	seedling.Tags = req.Tags
+	seedling.ModifiedAt = time.Now()
+
+	// Update the seedling in the database with the given fields, as long
+	// as it's still at the version the client sent
+	result, err := s.DB.NamedExecContext(r.Context(), `
+	 UPDATE seedlings SET name = :name, description = :description, modified_at = :modified_at, version = version + 1
+	 WHERE id = :id AND version = :version
+	 `, &seedling)
+	if store.SQLiteUnique(err) {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, nameTakenMessage(false), map[string]string{"name": seedling.Name})
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to update seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if !s.updatedVersion(w, r, &seedling, result) {
+		return
+	}
+	if described {
+		s.embedSeedling(r.Context(), seedling)
+	}
+	seedling.Warning = s.editedWhileBuilding(r.Context(), before, pipeline.GenerationEdits(before, seedling))
+	if seedling.Tags != nil {
+		tags, err := normalizeTags(seedling.Tags)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+			return
+		}
+		seedling.Tags = tags
+		if err := s.ReplaceTags(r.Context(), seedling.ID, seedling.Tags); err != nil {
+			s.log.WithField("error", err).Error("failed to update seedling tags")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+	} else if err := s.AttachTags(r.Context(), []*store.Seedling{&seedling}); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling tags")
+	}
+
+	// Return the updated seedling as JSON
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+}
+
+// ListSeedlings retrieves seedlings from the database and returns them as
+// JSON, filtered by ?tag=, ?q= and ?bitrot= and paginated by ?sort=,
+// ?order=, ?limit= and ?offset=. The total number of matches is in
+// X-Total-Count. Only the fields in ?fields= are returned if it's set, and
+// otherwise the large ones in seedlingLargeColumns are left out.
+func (s *Server) ListSeedlings(w http.ResponseWriter, r *http.Request) {
+	where, args, err := seedlingFilter(r)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	fields, err := seedlingListFields(r)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	columns := seedlingListColumns
+	if fields != nil {
+		columns = seedlingColumns(fields)
+	}
+	page, err := listParams(r, seedlingSortColumns, "seedlings.created_at")
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	var total int
+	if err := s.Reads.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM seedlings"+where, args...); err != nil {
+		s.log.WithField("error", err).Error("failed to count seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	// Query the database for the page of seedlings
+	ss := []store.Seedling{}
+	if err := s.Reads.SelectContext(r.Context(), &ss,
+		"SELECT "+selectColumns("seedlings", columns)+" FROM seedlings"+where+page, args...); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedlings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	ptrs := make([]*store.Seedling, len(ss))
+	for i := range ss {
+		ptrs[i] = &ss[i]
+	}
+	if fields == nil || fields["etaSeconds"] {
+		if err := s.attachETAs(r.Context(), ptrs); err != nil {
+			s.log.WithField("error", err).Error("failed to compute seedling etas")
+		}
+	}
+	if fields == nil || fields["tags"] {
+		if err := s.AttachTags(r.Context(), ptrs); err != nil {
+			s.log.WithField("error", err).Error("failed to get seedling tags")
+		}
+	}
+	if fields == nil || fields["containerState"] {
+		if err := s.attachContainerStates(r.Context(), ptrs); err != nil {
+			s.log.WithField("error", err).Error("failed to get seedling container states")
+		}
+	}
+	w.Header().Set(pipeline.TotalCountHeader, strconv.Itoa(total))
+
+	// Return the seedlings as JSON
+	var body interface{} = &ss
+	if fields != nil {
+		sparse, err := sparseSeedlings(ss, fields)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to encode response")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		body = sparse
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"os"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// ProcessStartMarker marks the API starting on this host.
+func ProcessStartMarker() pipeline.Marker {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return pipeline.Marker{Message: "garden-api started on " + hostname, Type: pipeline.MarkerProcessStart}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// registerQueueDepth exports the scheduler's queue depth, read on each
+// scrape.
+func registerQueueDepth(sc *pipeline.Scheduler) {
+	pipeline.MetricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "garden",
+		Name:      "build_queue_depth",
+		Help:      "Seedlings waiting for a build worker.",
+	}, func() float64 {
+		return float64(sc.QueueDepth())
+	}))
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(pipeline.MetricsRegistry, promhttp.HandlerOpts{})
+}
+
+// routeLabel is the route template a request matched, so that seedling ids
+// don't each get their own series.
+func routeLabel(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unmatched"
+}
+
+func observeHTTPRequest(r *http.Request, status int, duration time.Duration) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	route := routeLabel(r)
+	pipeline.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+	pipeline.HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(duration.Seconds())
+}
+
+func observeReapedProcess() {
+	pipeline.ReapedProcesses.Inc()
+}
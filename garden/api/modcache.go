@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+func (s *Server) modCacheReport() (*pipeline.ModCacheReport, error) {
+	report := &pipeline.ModCacheReport{Dir: s.Config.ModCacheDir}
+	err := filepath.Walk(s.Config.ModCacheDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		report.Bytes += info.Size()
+		if strings.HasSuffix(path, ".zip") && strings.Contains(path, string(filepath.Separator)+"@v"+string(filepath.Separator)) {
+			report.Modules++
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	s.ModCacheStats.Fill(report)
+	return report, nil
+}
+
+// purgeModCache empties the shared module cache and the BuildKit cache mount
+// of image builds. The module cache is read-only, as go leaves it, so its
+// directories are made writable first; the directory itself stays, since
+// builder containers mount it.
+func (s *Server) purgeModCache(ctx context.Context) error {
+	dir := s.Config.ModCacheDir
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.Chmod(path, 0755)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "builder", "prune", "--force", "--filter", "type=exec.cachemount").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker builder prune: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ModCache reports the size of the shared module cache and its hit rate.
+func (s *Server) ModCache(w http.ResponseWriter, r *http.Request) {
+	report, err := s.modCacheReport()
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to compute module cache usage")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// PurgeModCache empties the module caches, e.g. after a module was
+// retracted, and reports the emptied cache. Builds running meanwhile may
+// fail and be retried by the fix loop.
+func (s *Server) PurgeModCache(w http.ResponseWriter, r *http.Request) {
+	if err := s.purgeModCache(r.Context()); err != nil {
+		s.log.WithField("error", err).Error("failed to purge module cache")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to purge module cache", nil)
+		return
+	}
+	pipeline.LoggerFromContext(r.Context()).WithField("dir", s.Config.ModCacheDir).Info("Purged module cache")
+	s.ModCache(w, r)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// ModuleChange is a module whose version differs between two revisions.
+type ModuleChange struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ModuleDiff is what changed in a seedling's modules since an earlier
+// revision.
+type ModuleDiff struct {
+	Since   int                  `json:"since"`
+	Added   []pipeline.ModuleDep `json:"added"`
+	Removed []pipeline.ModuleDep `json:"removed"`
+	Changed []ModuleChange       `json:"changed"`
+}
+
+// moduleReport returns the seedling's report for revision, or for the
+// latest revision with one if revision is -1.
+func (s *Server) moduleReport(ctx context.Context, seedlingID hide.Int64, revision int) (*pipeline.ModuleReport, error) {
+	query, args := "SELECT * FROM seedling_module_reports WHERE seedling_id = $1 AND revision = $2", []interface{}{seedlingID, revision}
+	if revision < 0 {
+		query, args = "SELECT * FROM seedling_module_reports WHERE seedling_id = $1 ORDER BY revision DESC LIMIT 1", args[:1]
+	}
+	var report pipeline.ModuleReport
+	if err := s.Reads.GetContext(ctx, &report, query, args...); err != nil {
+		return nil, err
+	}
+	return &report, report.Decode()
+}
+
+func diffModules(since int, from, to []pipeline.ModuleDep) *ModuleDiff {
+	diff := &ModuleDiff{Since: since, Added: []pipeline.ModuleDep{}, Removed: []pipeline.ModuleDep{}, Changed: []ModuleChange{}}
+	before := map[string]pipeline.ModuleDep{}
+	for _, m := range from {
+		before[m.Path] = m
+	}
+	for _, m := range to {
+		old, ok := before[m.Path]
+		delete(before, m.Path)
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, m)
+		case old.Version != m.Version || old.Replace != m.Replace:
+			diff.Changed = append(diff.Changed, ModuleChange{Path: m.Path, From: old.Resolved(), To: m.Resolved()})
+		}
+	}
+	for _, m := range before {
+		diff.Removed = append(diff.Removed, m)
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Path < diff.Removed[j].Path })
+	return diff
+}
+
+// SeedlingDependencies returns the modules the seedling was built with, at
+// ?revision= or its latest revision, and what changed in them since the
+// revision ?since=, or the previous revision with a report.
+func (s *Server) SeedlingDependencies(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	revisionParam := func(name string) (int, bool) {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			return -1, true
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, name+" must be a revision number", nil)
+			return 0, false
+		}
+		return n, true
+	}
+	revision, ok := revisionParam("revision")
+	if !ok {
+		return
+	}
+	since, ok := revisionParam("since")
+	if !ok {
+		return
+	}
+
+	report, err := s.moduleReport(r.Context(), seedling.ID, revision)
+	if err == sql.ErrNoRows {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling has no dependency report for that revision yet", nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get module report")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if since < 0 {
+		if err := s.Reads.GetContext(r.Context(), &since,
+			"SELECT COALESCE(MAX(revision), -1) FROM seedling_module_reports WHERE seedling_id = $1 AND revision < $2",
+			seedling.ID, report.Revision); err != nil {
+			s.log.WithField("error", err).Error("failed to get previous module report")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+	}
+	resp := struct {
+		*pipeline.ModuleReport
+		Diff *ModuleDiff `json:"diff,omitempty"`
+	}{ModuleReport: report}
+	if since >= 0 {
+		base, err := s.moduleReport(r.Context(), seedling.ID, since)
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling has no dependency report for revision "+strconv.Itoa(since), nil)
+			return
+		}
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get module report")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		resp.Diff = diffModules(since, base.Modules, report.Modules)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
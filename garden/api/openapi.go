@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// SeedlingOpenAPI returns the OpenAPI spec of the seedling's HTTP server, as
+// YAML, or as JSON if the client accepts application/json.
+func (s *Server) SeedlingOpenAPI(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.RepoDir(seedling), "openapi.yaml"))
+	if os.IsNotExist(err) {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling has no OpenAPI spec", map[string]string{"step": seedling.Step})
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to read OpenAPI spec")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+		return
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to load OpenAPI spec")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
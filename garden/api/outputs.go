@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// SeedlingOutput is a file a seedling's container wrote to /outputs, which
+// is served from /outputs/{name}/.
+type SeedlingOutput struct {
+	Path       string    `json:"path"`
+	Bytes      int64     `json:"bytes"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+	URL        string    `json:"url"`
+}
+
+type SeedlingOutputs struct {
+	Files      []SeedlingOutput `json:"files"`
+	TotalBytes int64            `json:"totalBytes"`
+	QuotaBytes int64            `json:"quotaBytes,omitempty"`
+}
+
+// listOutputs lists the seedling's outputs in the blob store, publishing
+// the ones its container wrote since they were last published first.
+func (s *Server) listOutputs(ctx context.Context, name string) (*SeedlingOutputs, error) {
+	if err := s.publishOutputs(ctx, name); err != nil {
+		return nil, err
+	}
+	prefix := seedlingOutputsPrefix(name)
+	infos, err := s.blobs.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	outputs := &SeedlingOutputs{Files: []SeedlingOutput{}}
+	for _, info := range infos {
+		rel := strings.TrimPrefix(info.Key, prefix)
+		outputs.Files = append(outputs.Files, SeedlingOutput{
+			Path:       rel,
+			Bytes:      info.Size,
+			ModifiedAt: info.ModifiedAt,
+			URL:        (&url.URL{Path: "/outputs/" + name + "/" + rel}).EscapedPath(),
+		})
+		outputs.TotalBytes += info.Size
+	}
+	return outputs, nil
+}
+
+// SeedlingOutputs lists the files the seedling's container has written to
+// /outputs.
+func (s *Server) SeedlingOutputs(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	outputs, err := s.listOutputs(r.Context(), seedling.ResourceName())
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to list seedling outputs")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	outputs.QuotaBytes = s.Config.OutputsMaxBytes
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(outputs); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// outputsLoop enforces OutputsMaxBytes every OutputsSweepInterval, and
+// publishes outputs to the blob store if it isn't the local one.
+func (s *Server) outputsLoop(ctx context.Context) {
+	if s.Config.OutputsSweepInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.Config.OutputsSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.Config.OutputsMaxBytes > 0 {
+				if err := s.sweepOutputs(ctx); err != nil {
+					s.log.WithField("error", err).Error("failed to sweep seedling outputs")
+				}
+			}
+			if err := s.publishAllOutputs(ctx); err != nil {
+				s.log.WithField("error", err).Error("failed to publish seedling outputs")
+			}
+		}
+	}
+}
+
+// sweepOutputs stops the container of every seedling whose outputs are over
+// quota and flags it. The files are kept so they can still be downloaded;
+// the flag is cleared the next time the container is launched.
+func (s *Server) sweepOutputs(ctx context.Context) error {
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(ctx, &seedlings,
+		"SELECT * FROM seedlings WHERE NOT archived AND NOT outputs_quota_exceeded AND deleted_at IS NULL"); err != nil {
+		return err
+	}
+	for _, seedling := range seedlings {
+		size, err := dirSize(s.SeedlingOutputsDir(seedling.ResourceName()))
+		if err != nil {
+			return err
+		}
+		if size <= s.Config.OutputsMaxBytes {
+			continue
+		}
+
+		if err := s.Docker.Stop(ctx, s.ContainerName(seedling)); err != nil {
+			s.log.WithField("error", err).Warn("failed to stop seedling container")
+		}
+		reason := fmt.Sprintf("outputs quota exceeded: %d bytes written, quota is %d", size, s.Config.OutputsMaxBytes)
+		if _, err := s.DB.ExecContext(ctx,
+			"UPDATE seedlings SET outputs_quota_exceeded = TRUE, error = $1 WHERE id = $2", reason, seedling.ID); err != nil {
+			return err
+		}
+		s.log.WithField("name", seedling.Name).
+			WithField("bytes", size).
+			Warn("Stopped seedling over outputs quota")
+	}
+	return nil
+}
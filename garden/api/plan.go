@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// minDescriptionWords is how short a description can be before it's too
+// vague to build from without reviewing a plan first.
+const minDescriptionWords = 3
+
+type planRequest struct {
+	Description string `json:"description"`
+}
+
+const planPrompt = `Someone wants a gRPC service that %s
+
+Plan what it should implement. Output exactly one JSON object like:
+
+` + "```" + `
+{
+  "summary": "one sentence describing what the service does",
+  "entities": [{"name": "Invoice", "fields": ["id string", "amount_cents int64"]}],
+  "rpcs": [{"name": "CreateInvoice", "request": "CreateInvoiceRequest", "response": "Invoice", "description": "what it does"}],
+  "notes": ["non-functional requirements, e.g. persistence, limits, idempotency"]
+}
+` + "```" + `
+
+Keep it to what the description asks for or clearly implies.
+` + "```json\n"
+
+// parsePlan reads the first valid plan out of a completion.
+func parsePlan(text string) (*store.SeedlingPlan, error) {
+	err := errors.New("no JSON object in response")
+	for i := strings.IndexByte(text, '{'); i != -1; i = pipeline.NextObject(text, i) {
+		var plan store.SeedlingPlan
+		if decodeErr := json.NewDecoder(strings.NewReader(text[i:])).Decode(&plan); decodeErr != nil {
+			err = fmt.Errorf("invalid JSON: %w", decodeErr)
+			continue
+		}
+		if reason := plan.Check(); reason != "" {
+			err = errors.New(reason)
+			continue
+		}
+		return &plan, nil
+	}
+	return nil, err
+}
+
+// planSeedling asks the model for a plan for a description. The model can
+// be set apart from the rest with MODELS=SeedlingStepPlan=..., since it's a
+// short completion.
+func (s *Server) planSeedling(ctx context.Context, description string) (*store.SeedlingPlan, error) {
+	prompt := fmt.Sprintf(planPrompt, description)
+	var err error
+	for tries := 0; tries < 3; tries++ {
+		var out string
+		out, err = s.CompleteText(ctx, pipeline.SeedlingStepPlan, prompt, 0.5)
+		if err != nil {
+			return nil, err
+		}
+		var plan *store.SeedlingPlan
+		if plan, err = parsePlan(out); err == nil {
+			return plan, nil
+		}
+		s.log.WithField("error", err).Warn("failed to parse plan")
+		prompt += out + "\n```\n\nThat wasn't a valid plan (" + err.Error() + "). Respond with exactly one JSON object in the format above.\n```json\n"
+	}
+	return nil, err
+}
+
+// PreviewPlan returns the plan a seedling with the description would be
+// created with, without creating anything.
+func (s *Server) PreviewPlan(w http.ResponseWriter, r *http.Request) {
+	var req planRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if strings.TrimSpace(req.Description) == "" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "description is required", nil)
+		return
+	}
+
+	key, ok := s.checkLLMKey(w, r)
+	if !ok {
+		return
+	}
+	plan, err := s.planSeedling(pipeline.WithLLM(r.Context(), key.provider.LLM), req.Description)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to plan seedling")
+		s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to plan seedling", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// ApprovePlan starts building a seedling waiting at SeedlingStepPlan. The
+// body may be an edited plan to build instead of the proposed one.
+func (s *Server) ApprovePlan(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to read request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	plan := seedling.Plan
+	if len(bytes.TrimSpace(body)) > 0 {
+		plan = &store.SeedlingPlan{}
+		if err := json.Unmarshal(body, plan); err != nil {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+			return
+		}
+	}
+	if seedling.Step != pipeline.SeedlingStepPlan {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling isn't waiting for its plan to be approved", map[string]string{"step": seedling.Step})
+		return
+	}
+	if plan == nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "seedling has no plan, send one to approve", nil)
+		return
+	}
+	if reason := plan.Check(); reason != "" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, reason, nil)
+		return
+	}
+
+	now := time.Now()
+	result, err := s.DB.ExecContext(r.Context(), `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, modified_at = $2, plan = $3, version = version + 1
+	 WHERE id = $4 AND step = $5
+	 `, pipeline.SeedlingStepProtobufs, now, plan, seedling.ID, pipeline.SeedlingStepPlan)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to approve plan")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "plan was already approved", nil)
+		return
+	}
+
+	seedling.Plan = plan
+	seedling.Step = pipeline.SeedlingStepProtobufs
+	seedling.StepStartedAt = &now
+	seedling.ModifiedAt = now
+	s.Notify(r.Context(), seedling, pipeline.EventStepChanged, pipeline.SeedlingStepProtobufs)
+	s.SubmitBuild(r.Context(), seedling)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
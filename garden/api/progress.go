@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// ProgressTTL is how long the attempts and files of a seedling's
+	// progress are cached for, so a UI polling it doesn't walk the repo on
+	// every request.
+	ProgressTTL = 2 * time.Second
+	// MAX_PROGRESS_FILES is how many of a seedling's files its progress
+	// lists.
+	MAX_PROGRESS_FILES = 200
+	// MAX_PROGRESS_ERROR_BYTES is the longest error summary of a step.
+	MAX_PROGRESS_ERROR_BYTES = 300
+
+	// ExpandProgress is the ?expand= value GetSeedling returns a seedling's
+	// Progress for.
+	ExpandProgress = "progress"
+)
+
+// errProgressFilesFull stops the walk of a seedling's repo once it has
+// MAX_PROGRESS_FILES files.
+var errProgressFilesFull = errors.New("too many files")
+
+// ProgressCache holds the expensive parts of seedlings' progress, by
+// seedling, for ProgressTTL.
+type ProgressCache struct {
+	mu      sync.Mutex
+	entries map[hide.Int64]*progressEntry
+}
+
+type progressEntry struct {
+	at        time.Time
+	attempts  map[string]store.LatestAttempt
+	files     []store.ProgressFile
+	truncated bool
+	degraded  []string
+}
+
+// get returns the cached entry of the seedling, or nil if it's expired.
+// Expired entries of other seedlings are dropped too.
+func (c *ProgressCache) get(id hide.Int64) *progressEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for other, entry := range c.entries {
+		if time.Since(entry.at) >= ProgressTTL {
+			delete(c.entries, other)
+		}
+	}
+	return c.entries[id]
+}
+
+func (c *ProgressCache) put(id hide.Int64, entry *progressEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[hide.Int64]*progressEntry{}
+	}
+	c.entries[id] = entry
+}
+
+// wantsExpand reports whether the request's comma separated ?expand= has
+// name in it.
+func wantsExpand(query, name string) bool {
+	for _, v := range strings.Split(query, ",") {
+		if strings.TrimSpace(v) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// seedlingProgress assembles the seedling's progress from its step
+// statuses, which it must already have, and its container state, with its
+// attempts and files read through the cache.
+func (s *Server) seedlingProgress(ctx context.Context, seedling store.Seedling) *store.SeedlingProgress {
+	entry := s.progress.get(seedling.ID)
+	if entry == nil {
+		entry = &progressEntry{at: time.Now()}
+		var err error
+		if entry.attempts, err = s.LatestAttempts(ctx, seedling); err != nil {
+			pipeline.LoggerFromContext(ctx).WithField("error", err).Error("failed to get seedling attempts")
+			entry.degraded = append(entry.degraded, "attempts")
+		}
+		if entry.files, entry.truncated, err = progressFiles(s.RepoDir(seedling)); err != nil {
+			pipeline.LoggerFromContext(ctx).WithField("error", err).Error("failed to list seedling files")
+			entry.degraded = append(entry.degraded, "files")
+		}
+		s.progress.put(seedling.ID, entry)
+	}
+
+	progress := &store.SeedlingProgress{
+		Steps:          []store.ProgressStep{},
+		Files:          entry.files,
+		FilesTruncated: entry.truncated,
+		Degraded:       entry.degraded,
+	}
+	if progress.Files == nil {
+		progress.Files = []store.ProgressFile{}
+	}
+	if seedling.ContainerState != ContainerStateMissing {
+		progress.ContainerState = seedling.ContainerState
+	}
+	statuses := map[string]store.SeedlingStepStatus{}
+	for _, status := range seedling.Steps {
+		statuses[status.Step] = status
+	}
+	for _, step := range pipeline.SeedlingSteps(seedling) {
+		status := statuses[step]
+		p := store.ProgressStep{
+			Step:       step,
+			Status:     progressStatus(status.Status),
+			StartedAt:  status.StartedAt,
+			FinishedAt: status.FinishedAt,
+		}
+		attempt, ok := entry.attempts[step]
+		if ok {
+			p.Attempt = attempt.Attempt
+		}
+		if p.Status == pipeline.StepStatusRunning || p.Status == pipeline.StepStatusFailed {
+			if ok && !attempt.Success {
+				p.Error = errorSummary(attempt.Output)
+			}
+			if p.Error == "" {
+				p.Error = errorSummary(status.Error)
+			}
+		}
+		progress.Steps = append(progress.Steps, p)
+	}
+	return progress
+}
+
+// progressStatus is a step status as one of the four progress reports.
+func progressStatus(status string) string {
+	switch status {
+	case pipeline.StepStatusFixing:
+		return pipeline.StepStatusRunning
+	case pipeline.StepStatusRunning, pipeline.StepStatusSucceeded, pipeline.StepStatusFailed:
+		return status
+	}
+	return pipeline.StepStatusPending
+}
+
+// errorSummary is the gist of a failed attempt's output: its first
+// diagnostic, else its first line, cut to MAX_PROGRESS_ERROR_BYTES.
+func errorSummary(output string) string {
+	summary := ""
+	if diags := pipeline.ParseDiagnostics(output); len(diags) > 0 {
+		summary = diags[0].String()
+	} else {
+		for _, line := range strings.Split(output, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				summary = line
+				break
+			}
+		}
+	}
+	if len(summary) > MAX_PROGRESS_ERROR_BYTES {
+		summary = summary[:MAX_PROGRESS_ERROR_BYTES]
+		for !utf8.ValidString(summary) {
+			summary = summary[:len(summary)-1]
+		}
+		summary += "…"
+	}
+	return summary
+}
+
+// progressFiles lists up to MAX_PROGRESS_FILES files in the repo at dir,
+// leaving out its git directory, secrets and env files, and whether there
+// were more. Files removed while it's walked, like the repo of a seedling
+// whose build hasn't started, are left out.
+func progressFiles(dir string) ([]store.ProgressFile, bool, error) {
+	files := []store.ProgressFile{}
+	skip := map[string]bool{
+		filepath.Join(dir, ".git"):       true,
+		pipeline.SeedlingSecretsDir(dir): true,
+		pipeline.SeedlingEnvDir(dir):     true,
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if skip[path] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if len(files) == MAX_PROGRESS_FILES {
+			return errProgressFilesFull
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, store.ProgressFile{Path: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+	if errors.Is(err, errProgressFilesFull) {
+		return files, true, nil
+	}
+	return files, false, err
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+type promoteRequest struct {
+	// Items are the ids of the checklist items to apply, PROMOTE_CHECKLIST
+	// if empty, and Custom more items verified by the model.
+	Items  []string              `json:"items"`
+	Custom []store.HardeningItem `json:"custom"`
+}
+
+// checklist is the items the request applies, or why it's invalid.
+func (req promoteRequest) checklist(defaults []string) ([]store.HardeningItem, string) {
+	ids := req.Items
+	if len(ids) == 0 && len(req.Custom) == 0 {
+		ids = defaults
+	}
+	items := []store.HardeningItem{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		item, ok := pipeline.HardeningItem(id)
+		if !ok {
+			return nil, fmt.Sprintf("unknown checklist item %q", id)
+		}
+		if !seen[id] {
+			items = append(items, item)
+			seen[id] = true
+		}
+	}
+	for _, item := range req.Custom {
+		item.ID, item.Instruction = strings.TrimSpace(item.ID), strings.TrimSpace(item.Instruction)
+		switch {
+		case item.ID == "" || item.Instruction == "":
+			return nil, "custom items need an id and an instruction"
+		case seen[item.ID]:
+			return nil, fmt.Sprintf("checklist item %q is listed twice", item.ID)
+		}
+		if _, ok := pipeline.HardeningItem(item.ID); ok {
+			return nil, fmt.Sprintf("custom item %q has the id of a built-in one", item.ID)
+		}
+		items = append(items, item)
+		seen[item.ID] = true
+	}
+	if len(items) == 0 {
+		return nil, "the checklist is empty"
+	}
+	return items, ""
+}
+
+// PromoteSeedling hardens a complete seedling for production: its repo is
+// branched to HardenedBranch and each item of the checklist is applied by a
+// refine of its own and verified, by a check where there is one and by the
+// model otherwise. The seedling is returned with its promotion as it
+// starts; the promoted event carries the results once it's done. Promoting
+// a complete seedling whose promotion stopped, e.g. because it was rolled
+// back after a refine failed, starts over.
+func (s *Server) PromoteSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional, without it PROMOTE_CHECKLIST is applied.
+	var req promoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	items, reason := req.checklist(s.Config.PromoteChecklist)
+	if reason != "" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, reason, nil)
+		return
+	}
+	if seedling.Archived {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is archived", nil)
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is still being built", map[string]string{"step": seedling.Step})
+		return
+	}
+	dir := s.RepoDir(seedling)
+	if !pipeline.HasOwnRepo(dir) {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is in the shared repo, which can't be branched; rebuild it first", nil)
+		return
+	}
+	lease, err := s.BuildRegistry.Lease(r.Context(), seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get build lease")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if lease != nil {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is being built", lease)
+		return
+	}
+	provider, err := s.SeedlingLLM(seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling's LLM provider")
+		s.respondError(w, http.StatusServiceUnavailable, pipeline.ErrCodeUnavailable, "LLM provider not configured", nil)
+		return
+	}
+
+	from, err := pipeline.GitOutput(r.Context(), dir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling branch")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	cmd := exec.CommandContext(r.Context(), "git", "checkout", "-q", "-B", pipeline.HardenedBranch)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		s.log.WithField("error", err).WithField("output", string(out)).Error("failed to create hardened branch")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to create hardened branch", nil)
+		return
+	}
+	seedling.Hardened = false
+	seedling.Promotion = &store.Promotion{
+		Items:     items,
+		Results:   []store.HardeningResult{},
+		From:      strings.TrimSpace(from),
+		StartedAt: time.Now(),
+	}
+	pipeline.LoggerFromContext(r.Context()).WithField("name", seedling.Name).
+		WithField("items", len(items)).
+		WithField("promoted_by", pipeline.APIKeyFromContext(r.Context())).
+		Info("Promoting seedling")
+	s.AdvancePromotion(pipeline.WithLLM(s.BuildRegistry.Detach(r.Context(), seedling), provider), &seedling)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// QualityChecks returns every quality check verdict on a seedling's server
+// code, most recent first.
+func (s *Server) QualityChecks(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	records := []pipeline.QualityCheckRecord{}
+	if err := s.DB.SelectContext(r.Context(), &records,
+		"SELECT * FROM quality_checks WHERE seedling_id = $1 ORDER BY id DESC", seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get quality checks")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&records); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// QualityOverride accepts the seedling's latest rejected server code. A
+// running build picks it up on its next server attempt; otherwise a build is
+// started from the server step, retrying the seedling if it failed there.
+func (s *Server) QualityOverride(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	failedOnServer := seedling.Step == pipeline.SeedlingStepFailed && seedling.FailedStep == pipeline.SeedlingStepServer
+	if seedling.Step != pipeline.SeedlingStepServer && !failedOnServer {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is not on the server step", map[string]string{"step": seedling.Step})
+		return
+	}
+
+	var record pipeline.QualityCheckRecord
+	if err := s.DB.GetContext(r.Context(), &record,
+		"SELECT * FROM quality_checks WHERE seedling_id = $1 ORDER BY id DESC LIMIT 1", seedling.ID); err != nil {
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling has no quality checks", nil)
+			return
+		}
+		s.log.WithField("error", err).Error("failed to get quality check")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if record.Accepted {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "latest quality check was not rejected", nil)
+		return
+	}
+
+	now := time.Now()
+	record.Accepted = true
+	record.OverriddenBy = pipeline.APIKeyFromContext(r.Context())
+	record.OverriddenAt = &now
+	if _, err := s.DB.NamedExecContext(r.Context(), `
+	 UPDATE quality_checks
+	 SET accepted = :accepted, overridden_by = :overridden_by, overridden_at = :overridden_at
+	 WHERE id = :id
+	 `, &record); err != nil {
+		s.log.WithField("error", err).Error("failed to override quality check")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	pipeline.LoggerFromContext(r.Context()).
+		WithField("seedling_id", seedling.ID).
+		WithField("quality_check_id", record.ID).
+		WithField("api_key", record.OverriddenBy).
+		Info("Quality check overridden")
+
+	if failedOnServer {
+		if _, err := s.retrySeedling(r.Context(), &seedling); err != nil {
+			s.log.WithField("error", err).Error("failed to retry seedling")
+		}
+	} else {
+		lease, err := s.BuildRegistry.Lease(r.Context(), seedling.ID)
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get build lease")
+		}
+		if lease == nil && err == nil {
+			s.SubmitBuild(r.Context(), seedling)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&record); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// SeedlingReadme returns the seedling's README as markdown.
+func (s *Server) SeedlingReadme(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.RepoDir(seedling), "README.md"))
+	if os.IsNotExist(err) {
+		details := map[string]string{"step": seedling.Step}
+		if seedling.ReadmeError != "" {
+			details["readmeError"] = seedling.ReadmeError
+		}
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling has no README", details)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to read README")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Write(data)
+}
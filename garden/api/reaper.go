@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// reapLoop reaps the processes builds left behind every ProcessReapInterval
+// until ctx is done.
+func (s *Server) reapLoop(ctx context.Context) {
+	if s.Config.ProcessReapInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.Config.ProcessReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reaped, err := s.BuildRegistry.Reap()
+			if err != nil {
+				s.log.WithField("error", err).Error("failed to reap build processes")
+			}
+			for _, proc := range reaped {
+				observeReapedProcess()
+				s.log.WithField("pid", proc.PID).
+					WithField("pgid", proc.PGID).
+					WithField("seedling_id", proc.SeedlingID).
+					WithField("command", proc.Command).
+					Warn("Killed process left behind by build")
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	// rebuildStepCommands are what each step's failures are called in fix
+	// prompts and bit-rot reasons.
+	rebuildStepCommands = map[string]string{
+		pipeline.SeedlingStepProtobufs:  "protoc",
+		pipeline.SeedlingStepServer:     "go build",
+		pipeline.SeedlingStepDockerfile: "docker build",
+	}
+)
+
+// SeedlingRebuild is the result of a scheduled rebuild, recorded in
+// seedling_rebuilds. Output is the tail of what the step it failed at
+// printed.
+type SeedlingRebuild struct {
+	ID         int64      `db:"id" json:"id"`
+	SeedlingID hide.Int64 `db:"seedling_id" json:"seedlingId"`
+	CommitSHA  string     `db:"commit_sha" json:"commitSha"`
+	Succeeded  bool       `db:"succeeded" json:"succeeded"`
+	FailedStep string     `db:"failed_step" json:"failedStep,omitempty"`
+	Output     string     `db:"output" json:"output,omitempty"`
+	StartedAt  time.Time  `db:"started_at" json:"startedAt"`
+	FinishedAt time.Time  `db:"finished_at" json:"finishedAt"`
+}
+
+// rebuildLoop queues the rebuilds that are due every RebuildCheckInterval
+// until ctx is done.
+func (s *Server) rebuildLoop(ctx context.Context) {
+	if s.Config.RebuildCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.Config.RebuildCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.queueRebuilds(ctx); err != nil {
+				s.log.WithField("error", err).Error("failed to queue seedling rebuilds")
+			}
+		}
+	}
+}
+
+// queueRebuilds queues every complete seedling whose rebuild is due to be
+// rebuilt on a worker no build is waiting for, and sets when its next one
+// is due.
+func (s *Server) queueRebuilds(ctx context.Context) error {
+	now := time.Now().UTC()
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(ctx, &seedlings, `
+	 SELECT * FROM seedlings
+	 WHERE rebuild_schedule != '' AND rebuild_due_at <= $1 AND step = $2 AND NOT archived AND deleted_at IS NULL
+	 `, now, pipeline.SeedlingStepComplete); err != nil {
+		return err
+	}
+	for _, seedling := range seedlings {
+		schedule := seedling.SeedlingRebuildSchedule
+		if err := schedule.SetSchedule(schedule.RebuildSchedule, now); err != nil {
+			s.log.WithField("error", err).WithField("name", seedling.Name).Warn("invalid rebuild schedule, not rebuilding seedling")
+			continue
+		}
+		if _, err := s.DB.ExecContext(ctx,
+			"UPDATE seedlings SET rebuild_due_at = $1 WHERE id = $2", schedule.RebuildDueAt, seedling.ID); err != nil {
+			return err
+		}
+		seedling, rebuildCtx := seedling, s.BuildRegistry.Detach(ctx, seedling)
+		s.Scheduler.SubmitBackground(func() {
+			s.rebuildSeedling(rebuildCtx, seedling)
+		})
+	}
+	return nil
+}
+
+// rebuildSeedling builds the seedling's committed code again and records
+// whether it still builds, flagging it as bit-rotted if it doesn't.
+func (s *Server) rebuildSeedling(ctx context.Context, seedling store.Seedling) {
+	ctx, span := pipeline.StartBuildSpan(ctx, "seedling.rebuild", seedling)
+	defer span.End()
+	rebuild, err := s.runRebuild(ctx, seedling)
+	if err != nil {
+		s.log.WithField("error", err).WithField("name", seedling.Name).Error("failed to rebuild seedling")
+		return
+	}
+	if _, err := s.DB.NamedExecContext(ctx, `
+	 INSERT INTO seedling_rebuilds (seedling_id, commit_sha, succeeded, failed_step, output, started_at, finished_at)
+	 VALUES (:seedling_id, :commit_sha, :succeeded, :failed_step, :output, :started_at, :finished_at)
+	 `, &rebuild); err != nil {
+		s.log.WithField("error", err).Error("failed to record seedling rebuild")
+	}
+	s.log.WithField("name", seedling.Name).
+		WithField("commit", rebuild.CommitSHA).
+		WithField("succeeded", rebuild.Succeeded).
+		WithField("failed_step", rebuild.FailedStep).
+		Info("Rebuilt seedling")
+	if rebuild.Succeeded {
+		if seedling.Bitrot {
+			s.ClearBitrot(ctx, seedling.ID)
+		}
+		return
+	}
+
+	// Seedlings that were already flagged, or are being refined since, are
+	// left as they are.
+	reason := "rebuild failed at " + rebuildStepCommands[rebuild.FailedStep]
+	result, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings SET bitrot = TRUE, bitrot_reason = $1, version = version + 1
+	 WHERE id = $2 AND step = $3 AND NOT bitrot
+	 `, reason, seedling.ID, pipeline.SeedlingStepComplete)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to flag seedling bitrot")
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return
+	}
+	seedling.Bitrot, seedling.BitrotReason = true, reason
+	seedling.Version++
+	s.Notify(ctx, seedling, pipeline.EventBitrot, pipeline.SeedlingStepComplete)
+	if seedling.AutoHeal {
+		s.healSeedling(ctx, seedling, rebuild)
+	}
+}
+
+// runRebuild runs the protoc, go build and docker build of the seedling's
+// build on a copy of its last commit, stopping at the first that fails.
+// The image is built without the cache and under its own tag, so neither
+// hides what changed nor replaces the one its container runs.
+func (s *Server) runRebuild(ctx context.Context, seedling store.Seedling) (SeedlingRebuild, error) {
+	rebuild := SeedlingRebuild{SeedlingID: seedling.ID, StartedAt: time.Now()}
+	dir, err := os.MkdirTemp("", "garden-rebuild-")
+	if err != nil {
+		return rebuild, err
+	}
+	defer os.RemoveAll(dir)
+	if rebuild.CommitSHA, err = exportHead(ctx, s.RepoDir(seedling), dir); err != nil {
+		return rebuild, fmt.Errorf("exporting repo: %w", err)
+	}
+
+	tag := s.ContainerName(seedling) + "-rebuild"
+	for _, stage := range []struct {
+		step string
+		spec pipeline.BuildSpec
+	}{
+		{pipeline.SeedlingStepProtobufs, pipeline.BuildSpec{Name: "protoc", Args: []string{
+			"-I=.", "--go_out=.", "--go-grpc_out=.", "protobufs/" + seedling.Name + ".proto",
+		}}},
+		{pipeline.SeedlingStepServer, pipeline.BuildSpec{Name: "sh", Args: []string{
+			"-c", "go get ./... && go build -o /tmp/server ./server",
+		}}},
+		{pipeline.SeedlingStepDockerfile, pipeline.BuildSpec{Name: "docker", Args: pipeline.ImageBuildArgs(seedling, tag, false)}},
+	} {
+		runner := s.Runner
+		if stage.spec.Name == "docker" {
+			runner = pipeline.HostRunner{Env: s.BuildEnv()}
+		}
+		stage.spec.Dir, stage.spec.Toolchain = dir, seedling.Toolchain
+		cmd, err := runner.Command(ctx, stage.spec)
+		if err != nil {
+			return rebuild, err
+		}
+		out := pipeline.NewBuildOutput(s.Config.BuildOutputMaxBytes, nil)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		err = cmd.Run()
+		out.Close()
+		if ctx.Err() != nil {
+			return rebuild, ctx.Err()
+		}
+		if err != nil {
+			rebuild.FailedStep = stage.step
+			rebuild.Output = pipeline.ErrorTail(out.String(), s.Settings.Current().ErrorOutputLines)
+			break
+		}
+	}
+	rebuild.Succeeded = rebuild.FailedStep == ""
+	rebuild.FinishedAt = time.Now()
+	if rebuild.Succeeded {
+		if err := s.Docker.RemoveImage(ctx, tag); err != nil {
+			s.log.WithField("error", err).Warn("failed to remove rebuilt image")
+		}
+	}
+	return rebuild, nil
+}
+
+// exportHead writes the files of the last commit of the repo at dir, only
+// those under dir in the shared repo, to dst, returning the commit.
+func exportHead(ctx context.Context, dir, dst string) (string, error) {
+	sha, err := pipeline.RepoHead(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	archive := exec.CommandContext(ctx, "git", "archive", "--format=tar", sha+":./")
+	archive.Dir = dir
+	extract := exec.CommandContext(ctx, "tar", "-x", "-C", dst)
+	pr, pw := io.Pipe()
+	archive.Stdout = pw
+	extract.Stdin = pr
+	var archiveErr, extractErr strings.Builder
+	archive.Stderr = &archiveErr
+	extract.Stderr = &extractErr
+	if err := extract.Start(); err != nil {
+		return "", err
+	}
+	err = archive.Run()
+	pw.CloseWithError(err)
+	if err != nil {
+		extract.Wait()
+		return "", fmt.Errorf("git archive: %w: %s", err, archiveErr.String())
+	}
+	if err := extract.Wait(); err != nil {
+		return "", fmt.Errorf("tar: %w: %s", err, extractErr.String())
+	}
+	return sha, nil
+}
+
+const healInstruction = "It no longer builds: building its committed code again failed at %s with:\n\n```\n%s```\n\nFix that so it builds again."
+
+// healSeedling refines a bit-rotted seedling with what its rebuild failed
+// with, from the protobufs if protoc failed and the server otherwise.
+// Seedlings that are being built or whose LLM provider isn't configured are
+// left flagged.
+func (s *Server) healSeedling(ctx context.Context, seedling store.Seedling, rebuild SeedlingRebuild) {
+	if _, err := s.SeedlingLLM(seedling); err != nil {
+		s.log.WithField("error", err).WithField("name", seedling.Name).Warn("not healing seedling, its LLM provider isn't configured")
+		return
+	}
+	lease, err := s.BuildRegistry.Lease(ctx, seedling.ID)
+	if err != nil || lease != nil {
+		s.log.WithField("error", err).WithField("name", seedling.Name).Warn("not healing seedling, it's being built")
+		return
+	}
+	step := pipeline.SeedlingStepServer
+	if rebuild.FailedStep == pipeline.SeedlingStepProtobufs {
+		step = pipeline.SeedlingStepProtobufs
+	}
+	instruction := fmt.Sprintf(healInstruction, rebuildStepCommands[rebuild.FailedStep], rebuild.Output)
+	started, err := s.StartRefine(ctx, &seedling, step, pipeline.RefineRequest{Instruction: instruction}, seedling.Toolchain)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to start healing refine")
+		return
+	}
+	if started {
+		s.log.WithField("name", seedling.Name).WithField("step", step).Info("Healing bit-rotted seedling")
+	}
+}
+
+// SeedlingRebuilds lists the seedling's scheduled rebuilds, latest first.
+func (s *Server) SeedlingRebuilds(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	rebuilds := []SeedlingRebuild{}
+	if err := s.Reads.SelectContext(r.Context(), &rebuilds,
+		"SELECT * FROM seedling_rebuilds WHERE seedling_id = $1 ORDER BY id DESC LIMIT 100", seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling rebuilds")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]SeedlingRebuild{"rebuilds": rebuilds}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
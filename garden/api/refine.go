@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const refineClassificationPrompt = `Here is the gRPC API of a service that %s:
+
+` + "```protobuf\n%s```" + `
+
+Someone asked for this change to the service: %s
+
+Does the change require changing the API, i.e. adding, removing or changing
+RPCs, messages or fields? Answer only "yes" or "no".
+
+Answer:`
+
+// refineStartStep asks the model whether the instruction changes the API,
+// in which case the refine has to start from the protobufs.
+func (s *Server) refineStartStep(ctx context.Context, seedling store.Seedling, instruction string) (string, error) {
+	proto, err := ioutil.ReadFile(filepath.Join(s.RepoDir(seedling), "protobufs", seedling.Name+".proto"))
+	if err != nil {
+		return "", err
+	}
+	ctx = llm.WithFixtureName(ctx, seedling.Name)
+	answer, err := s.CompleteText(ctx, "", fmt.Sprintf(refineClassificationPrompt, seedling.Brief(), proto, instruction), 0)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "yes") {
+		return pipeline.SeedlingStepProtobufs, nil
+	}
+	return pipeline.SeedlingStepServer, nil
+}
+
+// RefineSeedling rebuilds a completed seedling with one more change. Only
+// one refine or build may run at a time.
+func (s *Server) RefineSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	var req pipeline.RefineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	req.Instruction = strings.TrimSpace(req.Instruction)
+	toolchain := seedling.Toolchain
+	if req.Toolchain = strings.TrimPrefix(strings.TrimSpace(req.Toolchain), "go"); req.Toolchain != "" {
+		if reason := s.CheckGoToolchain(req.Toolchain); reason != "" {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, reason, nil)
+			return
+		}
+		toolchain = req.Toolchain
+	}
+	toolchainChanged := toolchain != seedling.Toolchain
+	if req.Instruction == "" && !req.Readme && !toolchainChanged {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "instruction is required", nil)
+		return
+	}
+	if seedling.Archived {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is archived", nil)
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is still being built", map[string]string{"step": seedling.Step})
+		return
+	}
+	lease, err := s.BuildRegistry.Lease(r.Context(), seedling.ID)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get build lease")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if lease != nil {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is being built", lease)
+		return
+	}
+	provider, err := s.SeedlingLLM(seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling's LLM provider")
+		s.respondError(w, http.StatusServiceUnavailable, pipeline.ErrCodeUnavailable, "LLM provider not configured", nil)
+		return
+	}
+	ctx := pipeline.WithLLM(r.Context(), provider)
+	if req.Instruction == "" && !toolchainChanged {
+		// A full refine writes the README again as it completes.
+		s.GenerateReadme(ctx, &seedling)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+			s.log.WithField("error", err).Error("failed to encode response")
+		}
+		return
+	}
+
+	// The server is rewritten for the new version, the proto can stay.
+	step := pipeline.SeedlingStepServer
+	if req.Instruction == "" {
+		req.Instruction = "Build it with Go " + toolchain + "."
+	} else if step, err = s.refineStartStep(ctx, seedling, req.Instruction); err != nil {
+		s.log.WithField("error", err).Error("failed to classify refine")
+		s.respondError(w, http.StatusBadGateway, pipeline.ErrCodeBadGateway, "failed to classify refine", nil)
+		return
+	}
+	started, err := s.StartRefine(r.Context(), &seedling, step, req, toolchain)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to start refine")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if !started {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is already being refined", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// SeedlingFiles is what GET /api/v1/seedlings/{id}/files lists: the files
+// in the seedling's repo, as progressFiles has them.
+type SeedlingFiles struct {
+	Files          []store.ProgressFile `json:"files"`
+	FilesTruncated bool                 `json:"filesTruncated,omitempty"`
+}
+
+// ListSeedlingFiles lists the files in the seedling's repo, without its git
+// directory, secrets and env files.
+func (s *Server) ListSeedlingFiles(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	var files SeedlingFiles
+	var err error
+	if files.Files, files.FilesTruncated, err = progressFiles(s.RepoDir(seedling)); err != nil {
+		s.log.WithField("error", err).Error("failed to list seedling files")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&files); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// SeedlingFile returns a file in the seedling's repo as it is now, e.g. the
+// artifact of a step waiting for approval, as plain text. Paths leading out
+// of the repo or into its .git, secrets or env are refused.
+func (s *Server) SeedlingFile(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	rel := mux.Vars(r)["path"]
+	realRoot, err := filepath.EvalSymlinks(s.RepoDir(seedling))
+	if os.IsNotExist(err) {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "file not found", nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to resolve seedling repo")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	path, err := pipeline.ResolveRepoPath(realRoot, rel, false)
+	var unsafeErr *pipeline.UnsafeWriteError
+	if errors.As(err, &unsafeErr) || err == nil &&
+		(pipeline.WithinDir(pipeline.SeedlingSecretsDir(realRoot), path) || pipeline.WithinDir(pipeline.SeedlingEnvDir(realRoot), path)) {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "path isn't a file of the seedling's code", nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to resolve seedling file")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "file not found", nil)
+		return
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to open seedling file")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "file not found", nil)
+		return
+	}
+
+	// Never served as HTML, whatever the model wrote.
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,488 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// ReportPeriod is what a garden did in a day: the seedlings created,
+// completed and failed, the tokens its attempts used and what they cost by
+// model, the steps slowest first and the disk it used by then.
+type ReportPeriod struct {
+	Date     string    `json:"date"`
+	TimeZone string    `json:"timeZone"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	// Partial is set for today's report, whose day isn't over.
+	Partial bool `json:"partial,omitempty"`
+
+	Created   int `json:"created"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+
+	Models           []ModelUsage `json:"models"`
+	PromptTokens     int64        `json:"promptTokens"`
+	CompletionTokens int64        `json:"completionTokens"`
+	EstimatedCostUSD float64      `json:"estimatedCostUsd"`
+	// UnpricedModels used tokens but have no price in MODEL_PRICES, so
+	// aren't in EstimatedCostUSD.
+	UnpricedModels []string `json:"unpricedModels,omitempty"`
+
+	Steps []StepUsage `json:"steps"`
+
+	// Disk is nil for days before reports were measured.
+	Disk *ReportDisk `json:"disk,omitempty"`
+}
+
+// ModelUsage is the tokens a model's attempts used. Their cost is nil if
+// the model has no price.
+type ModelUsage struct {
+	Model            string   `db:"model" json:"model"`
+	Attempts         int      `db:"attempts" json:"attempts"`
+	PromptTokens     int64    `db:"prompt_tokens" json:"promptTokens"`
+	CompletionTokens int64    `db:"completion_tokens" json:"completionTokens"`
+	EstimatedCostUSD *float64 `db:"-" json:"estimatedCostUsd"`
+}
+
+// StepUsage is how long a step took the seedlings that ran it: each one's
+// attempts at it in all, on average and at most.
+type StepUsage struct {
+	Step        string  `json:"step"`
+	Seedlings   int     `json:"seedlings"`
+	Attempts    int     `json:"attempts"`
+	MeanSeconds float64 `json:"meanSeconds"`
+	MaxSeconds  float64 `json:"maxSeconds"`
+}
+
+// DiskSizes are the disk used by every seedling's repo, outputs, image and
+// archive in all, as /api/v1/admin/disk-usage reports them.
+type DiskSizes struct {
+	RepoBytes    int64 `db:"repo_bytes" json:"repoBytes"`
+	OutputsBytes int64 `db:"outputs_bytes" json:"outputsBytes"`
+	ImageBytes   int64 `db:"image_bytes" json:"imageBytes"`
+	ArchiveBytes int64 `db:"archive_bytes" json:"archiveBytes"`
+}
+
+func (d DiskSizes) total() int64 {
+	return d.RepoBytes + d.OutputsBytes + d.ImageBytes + d.ArchiveBytes
+}
+
+func (d DiskSizes) minus(o DiskSizes) DiskSizes {
+	return DiskSizes{
+		RepoBytes:    d.RepoBytes - o.RepoBytes,
+		OutputsBytes: d.OutputsBytes - o.OutputsBytes,
+		ImageBytes:   d.ImageBytes - o.ImageBytes,
+		ArchiveBytes: d.ArchiveBytes - o.ArchiveBytes,
+	}
+}
+
+// ReportDisk is the disk used when a day's report was measured, at its
+// REPORT_TIME the day after or now for today's, and its growth since the
+// day before's, nil if that wasn't measured.
+type ReportDisk struct {
+	MeasuredAt time.Time `json:"measuredAt"`
+	DiskSizes
+	Growth *DiskSizes `json:"growth,omitempty"`
+}
+
+// dailyReport is the row of a day whose report was measured, and whether
+// it's been posted to REPORT_WEBHOOK_URL.
+type dailyReport struct {
+	Date string `db:"date"`
+	DiskSizes
+	MeasuredAt    time.Time  `db:"measured_at"`
+	DeliveredAt   *time.Time `db:"delivered_at"`
+	DeliveryError string     `db:"delivery_error"`
+}
+
+// measuredDisk is the disk the day at date's row measured, nil if it has
+// none.
+func (s *Server) measuredDisk(ctx context.Context, date string) (*dailyReport, error) {
+	var row dailyReport
+	err := s.Reads.GetContext(ctx, &row, "SELECT * FROM daily_reports WHERE date = $1", date)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// measureDisk adds up the disk every seedling uses.
+func (s *Server) measureDisk(ctx context.Context) (DiskSizes, error) {
+	var sizes DiskSizes
+	usage, err := s.diskUsage(ctx)
+	if err != nil {
+		return sizes, err
+	}
+	for _, u := range usage.Seedlings {
+		sizes.RepoBytes += u.RepoBytes
+		sizes.OutputsBytes += u.OutputsBytes
+		sizes.ImageBytes += u.ImageBytes
+		sizes.ArchiveBytes += u.ArchiveBytes
+	}
+	return sizes, nil
+}
+
+// dailyReport aggregates the report of the day at date, which has to have
+// started by now.
+func (s *Server) dailyReport(ctx context.Context, rs pipeline.ReportSchedule, date string, now time.Time) (*ReportPeriod, error) {
+	start, end, err := rs.Day(date)
+	if err != nil {
+		return nil, err
+	}
+	p := &ReportPeriod{
+		Date:     date,
+		TimeZone: rs.Location.String(),
+		Start:    start,
+		End:      end,
+		Partial:  now.Before(end),
+		Models:   []ModelUsage{},
+		Steps:    []StepUsage{},
+	}
+	// Timestamps are compared in UTC, as they're stored.
+	from, to := start.UTC(), end.UTC()
+
+	if err := s.Reads.GetContext(ctx, &p.Created,
+		"SELECT COUNT(*) FROM seedlings WHERE created_at >= $1 AND created_at < $2", from, to); err != nil {
+		return nil, err
+	}
+	outcomes := []struct {
+		Type  string `db:"type"`
+		Count int    `db:"count"`
+	}{}
+	if err := s.Reads.SelectContext(ctx, &outcomes, `
+	 SELECT type, COUNT(DISTINCT seedling_id) AS count FROM seedling_events
+	 WHERE type IN ($1, $2) AND created_at >= $3 AND created_at < $4
+	 GROUP BY type
+	 `, pipeline.EventCompleted, pipeline.EventFailed, from, to); err != nil {
+		return nil, err
+	}
+	for _, o := range outcomes {
+		if o.Type == pipeline.EventCompleted {
+			p.Completed = o.Count
+		} else {
+			p.Failed = o.Count
+		}
+	}
+
+	if err := s.Reads.SelectContext(ctx, &p.Models, `
+	 SELECT model, COUNT(*) AS attempts, SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens
+	 FROM seedling_attempts
+	 WHERE created_at >= $1 AND created_at < $2
+	 GROUP BY model
+	 ORDER BY SUM(prompt_tokens) + SUM(completion_tokens) DESC, model
+	 `, from, to); err != nil {
+		return nil, err
+	}
+	for i := range p.Models {
+		m := &p.Models[i]
+		p.PromptTokens += m.PromptTokens
+		p.CompletionTokens += m.CompletionTokens
+		if cost, ok := rs.Cost(m.Model, m.PromptTokens, m.CompletionTokens); ok {
+			m.EstimatedCostUSD = &cost
+			p.EstimatedCostUSD += cost
+		} else if m.PromptTokens+m.CompletionTokens > 0 {
+			p.UnpricedModels = append(p.UnpricedModels, m.Model)
+		}
+	}
+
+	steps := []struct {
+		Step      string `db:"step"`
+		Seedlings int    `db:"seedlings"`
+		Attempts  int    `db:"attempts"`
+		TotalMS   int64  `db:"total_ms"`
+		MaxMS     int64  `db:"max_ms"`
+	}{}
+	if err := s.Reads.SelectContext(ctx, &steps, `
+	 SELECT step, COUNT(*) AS seedlings, SUM(attempts) AS attempts, SUM(duration_ms) AS total_ms, MAX(duration_ms) AS max_ms
+	 FROM (
+	  SELECT seedling_id, step, COUNT(*) AS attempts, SUM(duration_ms) AS duration_ms
+	  FROM seedling_attempts
+	  WHERE created_at >= $1 AND created_at < $2
+	  GROUP BY seedling_id, step
+	 )
+	 GROUP BY step
+	 `, from, to); err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		p.Steps = append(p.Steps, StepUsage{
+			Step:        step.Step,
+			Seedlings:   step.Seedlings,
+			Attempts:    step.Attempts,
+			MeanSeconds: float64(step.TotalMS) / float64(step.Seedlings) / 1000,
+			MaxSeconds:  float64(step.MaxMS) / 1000,
+		})
+	}
+	sort.SliceStable(p.Steps, func(i, j int) bool { return p.Steps[i].MeanSeconds > p.Steps[j].MeanSeconds })
+
+	if p.Partial {
+		sizes, err := s.measureDisk(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.Disk = &ReportDisk{MeasuredAt: now, DiskSizes: sizes}
+	} else if row, err := s.measuredDisk(ctx, date); err != nil {
+		return nil, err
+	} else if row != nil {
+		p.Disk = &ReportDisk{MeasuredAt: row.MeasuredAt, DiskSizes: row.DiskSizes}
+	}
+	if p.Disk != nil {
+		before, err := s.measuredDisk(ctx, start.AddDate(0, 0, -1).Format(pipeline.ReportDateLayout))
+		if err != nil {
+			return nil, err
+		}
+		if before != nil {
+			growth := p.Disk.DiskSizes.minus(before.DiskSizes)
+			p.Disk.Growth = &growth
+		}
+	}
+	return p, nil
+}
+
+// formatBytes is n in binary units, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < 1024 {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	v, units := float64(n)/1024, "KMGTP"
+	i := 0
+	for ; v >= 1024 && i < len(units)-1; i++ {
+		v /= 1024
+	}
+	return fmt.Sprintf("%s%.1f %ciB", sign, v, units[i])
+}
+
+// formatGrowth is formatBytes with a + for growth.
+func formatGrowth(n int64) string {
+	if n > 0 {
+		return "+" + formatBytes(n)
+	}
+	return formatBytes(n)
+}
+
+func formatSeconds(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// renderReport is the report as markdown, for humans and REPORT_WEBHOOK_URL.
+func renderReport(p *ReportPeriod) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Garden report for %s (%s)", p.Date, p.TimeZone)
+	if p.Partial {
+		b.WriteString(", so far")
+	}
+	fmt.Fprintf(&b, "\n\n- Seedlings created: %d\n- Completed: %d\n- Failed: %d\n", p.Created, p.Completed, p.Failed)
+	fmt.Fprintf(&b, "- Tokens: %d prompt, %d completion\n- Estimated cost: $%.2f", p.PromptTokens, p.CompletionTokens, p.EstimatedCostUSD)
+	if len(p.UnpricedModels) > 0 {
+		fmt.Fprintf(&b, ", not counting %s", strings.Join(p.UnpricedModels, ", "))
+	}
+	b.WriteString("\n")
+
+	if len(p.Models) > 0 {
+		b.WriteString("\n## Tokens by model\n\n| Model | Attempts | Prompt tokens | Completion tokens | Estimated cost |\n|---|---:|---:|---:|---:|\n")
+		for _, m := range p.Models {
+			model, cost := m.Model, "unpriced"
+			if model == "" {
+				model = "none"
+			}
+			if m.EstimatedCostUSD != nil {
+				cost = fmt.Sprintf("$%.2f", *m.EstimatedCostUSD)
+			}
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %s |\n", model, m.Attempts, m.PromptTokens, m.CompletionTokens, cost)
+		}
+	}
+
+	if len(p.Steps) > 0 {
+		b.WriteString("\n## Slowest steps\n\n| Step | Seedlings | Attempts | Mean | Max |\n|---|---:|---:|---:|---:|\n")
+		for _, step := range p.Steps {
+			fmt.Fprintf(&b, "| %s | %d | %d | %s | %s |\n", step.Step, step.Seedlings, step.Attempts,
+				formatSeconds(step.MeanSeconds), formatSeconds(step.MaxSeconds))
+		}
+	}
+
+	if p.Disk != nil {
+		fmt.Fprintf(&b, "\n## Disk, as of %s\n\n| | Size | Growth |\n|---|---:|---:|\n", p.Disk.MeasuredAt.In(p.Start.Location()).Format("2006-01-02 15:04"))
+		rows := []struct {
+			name   string
+			size   int64
+			growth func(DiskSizes) int64
+		}{
+			{"Repos", p.Disk.RepoBytes, func(d DiskSizes) int64 { return d.RepoBytes }},
+			{"Outputs", p.Disk.OutputsBytes, func(d DiskSizes) int64 { return d.OutputsBytes }},
+			{"Images", p.Disk.ImageBytes, func(d DiskSizes) int64 { return d.ImageBytes }},
+			{"Archives", p.Disk.ArchiveBytes, func(d DiskSizes) int64 { return d.ArchiveBytes }},
+			{"Total", p.Disk.total(), DiskSizes.total},
+		}
+		for _, row := range rows {
+			growth := "unknown"
+			if p.Disk.Growth != nil {
+				growth = formatGrowth(row.growth(*p.Disk.Growth))
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", row.name, formatBytes(row.size), growth)
+		}
+	}
+	return b.String()
+}
+
+// DailyReport returns the report of ?date=, yesterday's in REPORT_TIME_ZONE
+// if it isn't set, as JSON or with ?format=markdown as markdown. Today's
+// is the day so far.
+func (s *Server) DailyReport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "markdown" && format != "json" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "format must be json or markdown", nil)
+		return
+	}
+	now := time.Now()
+	today := now.In(s.reports.Location)
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = today.AddDate(0, 0, -1).Format(pipeline.ReportDateLayout)
+	}
+	start, _, err := s.reports.Day(date)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "date must be YYYY-MM-DD", nil)
+		return
+	}
+	if start.After(now) {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "date is in the future", nil)
+		return
+	}
+
+	report, err := s.dailyReport(r.Context(), s.reports, date, now)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to aggregate daily report")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	if format == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(renderReport(report)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// reportLoop measures the disk for the last day's report once it's due at
+// REPORT_TIME, and posts the report to REPORT_WEBHOOK_URL if it's set,
+// until ctx is done. A day missed while garden was down is caught up on
+// when it's next up, but only the last one.
+func (s *Server) reportLoop(ctx context.Context) {
+	ticker := time.NewTicker(pipeline.ReportCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.sendDueReport(ctx, time.Now()); err != nil {
+				s.log.WithField("error", err).Error("failed to send daily report")
+			}
+		}
+	}
+}
+
+// sendDueReport measures and posts the report due at now, unless it
+// already has been.
+func (s *Server) sendDueReport(ctx context.Context, now time.Time) error {
+	date := s.reports.Due(now)
+	if date == "" {
+		return nil
+	}
+	row, err := s.measuredDisk(ctx, date)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		sizes, err := s.measureDisk(ctx)
+		if err != nil {
+			return err
+		}
+		row = &dailyReport{Date: date, DiskSizes: sizes, MeasuredAt: now.UTC()}
+		if _, err := s.DB.NamedExecContext(ctx, `
+		 INSERT INTO daily_reports (date, repo_bytes, outputs_bytes, image_bytes, archive_bytes, measured_at)
+		 VALUES (:date, :repo_bytes, :outputs_bytes, :image_bytes, :archive_bytes, :measured_at)
+		 `, row); err != nil {
+			return err
+		}
+	}
+	if s.Config.ReportWebhookURL == "" || row.DeliveredAt != nil {
+		return nil
+	}
+
+	report, err := s.dailyReport(ctx, s.reports, date, now)
+	if err != nil {
+		return err
+	}
+	deliveryErr := s.postReport(ctx, renderReport(report))
+	deliveryError := ""
+	if deliveryErr != nil {
+		deliveryError = deliveryErr.Error()
+		s.log.WithField("error", deliveryErr).WithField("date", date).Warn("giving up on daily report delivery")
+	}
+	_, err = s.DB.ExecContext(ctx,
+		"UPDATE daily_reports SET delivered_at = $1, delivery_error = $2 WHERE date = $3",
+		time.Now().UTC(), deliveryError, date)
+	return err
+}
+
+// postReport posts the markdown to REPORT_WEBHOOK_URL as the text of a
+// Slack message, retrying network errors and 5xx responses.
+func (s *Server) postReport(ctx context.Context, markdown string) error {
+	body, err := json.Marshal(map[string]string{"text": markdown})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: http.DefaultClient.Transport, Timeout: pipeline.ReportTimeout}
+	backoff := pipeline.ReportBackoff
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Config.ReportWebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("webhook returned %s", resp.Status)
+			if resp.StatusCode < 500 {
+				return err
+			}
+		}
+		if attempt == pipeline.MAX_REPORT_ATTEMPTS {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// MinContainerMemory is the least memory docker lets a container be limited
+// to.
+const MinContainerMemory = 6 << 20
+
+var (
+	memoryRegex        = regexp.MustCompile(`^(?i)([0-9]+)([bkmg]?)$`)
+	restartPolicyRegex = regexp.MustCompile(`^(no|always|unless-stopped|on-failure(:[0-9]+)?)$`)
+	memoryUnits        = map[string]int64{"": 1, "b": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30}
+)
+
+// parseMemory parses a docker memory size, such as 512m or 2g, into bytes.
+func parseMemory(size string) (int64, error) {
+	m := memoryRegex.FindStringSubmatch(size)
+	if m == nil {
+		return 0, fmt.Errorf("invalid memory %q, want a number of bytes with an optional unit b, k, m or g, e.g. 512m", size)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory %q", size)
+	}
+	return n * memoryUnits[strings.ToLower(m[2])], nil
+}
+
+// checkResources returns why the limits are invalid or over the config's
+// maxima, or "" if they aren't.
+func checkResources(r store.SeedlingResources, config pipeline.Config) string {
+	if r.Memory != "" {
+		bytes, err := parseMemory(r.Memory)
+		if err != nil {
+			return err.Error()
+		}
+		max, err := parseMemory(config.ContainerMaxMemory)
+		if err == nil && bytes > max {
+			return fmt.Sprintf("memory must be at most %s", config.ContainerMaxMemory)
+		}
+		if bytes < MinContainerMemory {
+			return "memory must be at least 6m"
+		}
+	}
+	if r.CPUs != "" {
+		cpus, err := strconv.ParseFloat(r.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return fmt.Sprintf("invalid cpus %q, want a positive number such as 0.5 or 2", r.CPUs)
+		}
+		if cpus > config.ContainerMaxCPUs {
+			return fmt.Sprintf("cpus must be at most %g", config.ContainerMaxCPUs)
+		}
+	}
+	if r.PidsLimit < 0 || r.PidsLimit > config.ContainerMaxPidsLimit {
+		return fmt.Sprintf("pidsLimit must be between 1 and %d", config.ContainerMaxPidsLimit)
+	}
+	if r.RestartPolicy != "" && !restartPolicyRegex.MatchString(r.RestartPolicy) {
+		return fmt.Sprintf("invalid restartPolicy %q, want no, always, unless-stopped or on-failure[:max retries]", r.RestartPolicy)
+	}
+	return ""
+}
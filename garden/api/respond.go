@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+var (
+	// requestIDRegex limits which incoming X-Request-ID values are honored, so
+	// that clients can't inject arbitrary text into logs and headers.
+	requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+)
+
+type ErrorBody struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+type ErrorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// respondError writes a JSON error envelope. The request ID is read back from
+// the response headers, where the logging middleware sets it before calling
+// the handler.
+func (s *Server) respondError(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	body, err := json.Marshal(ErrorEnvelope{Error: ErrorBody{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: w.Header().Get(pipeline.RequestIDHeader),
+	}})
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to marshal error response")
+		body = []byte(`{"error":{"code":"internal","message":"internal server error"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
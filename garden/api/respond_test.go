@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/pipelinetest"
+)
+
+// checkErrorEnvelope fails t unless the response is an error envelope and
+// nothing else, with the request ID it was given.
+func checkErrorEnvelope(t *testing.T, header http.Header, body []byte) ErrorEnvelope {
+	t.Helper()
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	var envelope ErrorEnvelope
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&envelope); err != nil {
+		t.Fatalf("not an error envelope: %v: %s", err, body)
+	}
+	if dec.More() {
+		t.Errorf("more after the envelope: %s", body)
+	}
+	if envelope.Error.Code == "" || envelope.Error.Message == "" {
+		t.Errorf("no code or message: %s", body)
+	}
+	if id := header.Get(pipeline.RequestIDHeader); id == "" || envelope.Error.RequestID != id {
+		t.Errorf("request ID %q, header %q", envelope.Error.RequestID, id)
+	}
+	return envelope
+}
+
+func TestErrorResponses(t *testing.T) {
+	env := pipelinetest.New(t)
+	logger, hook := logtest.NewNullLogger()
+	s := newServer(env.Pipeline(t), logrus.NewEntry(logger))
+	seedling := env.Seedling(t, "echo")
+	h := s.Routes()
+
+	tests := []struct {
+		method, target, body string
+		status               int
+		code                 string
+	}{
+		{"GET", "/no/such/route", "", http.StatusNotFound, pipeline.ErrCodeNotFound},
+		{"GET", pipeline.SeedlingPath(999), "", http.StatusNotFound, pipeline.ErrCodeNotFound},
+		{"PATCH", "/api/v1/seedlings", "", http.StatusMethodNotAllowed, pipeline.ErrCodeInvalidRequest},
+		{"POST", "/api/v1/seedlings", `{"name": `, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest},
+		{"POST", "/api/v1/seedlings", `{"name": "", "description": ""}`, http.StatusUnprocessableEntity, pipeline.ErrCodeValidation},
+		{"POST", "/api/v1/seedlings", `{"name": "echo", "description": "echoes what it's sent"}`, http.StatusConflict, pipeline.ErrCodeConflict},
+		// The message quotes what it was sent.
+		{"GET", `/api/v1/seedlings?sort=a%22b%5C`, "", http.StatusBadRequest, pipeline.ErrCodeInvalidRequest},
+		{"GET", "/api/v1/seedlings?limit=0", "", http.StatusBadRequest, pipeline.ErrCodeInvalidRequest},
+		{"POST", pipeline.SeedlingPath(seedling.ID) + "/container/pause", "", http.StatusBadRequest, pipeline.ErrCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			hook.Reset()
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			w := serve(h, tt.method, tt.target, body)
+			if w.Code != tt.status {
+				t.Errorf("%d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			envelope := checkErrorEnvelope(t, w.Header(), w.Body.Bytes())
+			if envelope.Error.Code != tt.code {
+				t.Errorf("code %q, want %q", envelope.Error.Code, tt.code)
+			}
+			checkAccessLog(t, hook, w.Header().Get(pipeline.RequestIDHeader), tt.status)
+		})
+	}
+
+	t.Run("panic", func(t *testing.T) {
+		hook.Reset()
+		panics := s.WithLogging(s.WithRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(`a "quoted" panic`)
+		})))
+		w := serve(panics, "GET", "/panics", nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%d: %s", w.Code, w.Body)
+		}
+		if envelope := checkErrorEnvelope(t, w.Header(), w.Body.Bytes()); strings.Contains(envelope.Error.Message, "quoted") {
+			t.Errorf("the panic is in the response: %s", w.Body)
+		}
+		checkAccessLog(t, hook, w.Header().Get(pipeline.RequestIDHeader), http.StatusInternalServerError)
+	})
+
+	t.Run("request ID", func(t *testing.T) {
+		for id, honored := range map[string]bool{"build-42.a_b": true, "has space": false, strings.Repeat("a", 129): false} {
+			req := newRequest("GET", "/no/such/route", nil)
+			req.Header.Set(pipeline.RequestIDHeader, id)
+			w := serveRequest(h, req)
+			if got := w.Header().Get(pipeline.RequestIDHeader); (got == id) != honored || got == "" {
+				t.Errorf("sent %q, got %q", id, got)
+			}
+			checkErrorEnvelope(t, w.Header(), w.Body.Bytes())
+		}
+	})
+}
+
+// checkAccessLog fails t unless the last request's access log line has its
+// request ID and status.
+func checkAccessLog(t *testing.T, hook *logtest.Hook, requestID string, status int) {
+	t.Helper()
+	for _, entry := range hook.AllEntries() {
+		if entry.Message != "Finished request" {
+			continue
+		}
+		if entry.Data["request_id"] != requestID || entry.Data["status"] != status {
+			t.Errorf("access log has request %v, status %v", entry.Data["request_id"], entry.Data["status"])
+		}
+		return
+	}
+	t.Error("no access log line")
+}
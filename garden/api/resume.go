@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// resumeSummary is what resumeBuilds did with each seedling, logged once the
+// containers have been reconciled.
+type resumeSummary struct {
+	resumed   int
+	deferred  int
+	running   int
+	restarted int
+	failed    int
+	skipped   map[string]int
+}
+
+// resumeBuilds picks up after a restart. Incomplete builds are queued on the
+// scheduler, so they're resumed BuildWorkers at a time from their checkpoint
+// and attempt history. One still leased, most likely by the process before
+// this one, is queued once the lease would have expired; if its holder is
+// alive it keeps the lease and the build isn't started twice. Complete
+// seedlings whose container is gone or died are started again in the
+// background, unless they were stopped on purpose.
+func (s *Server) resumeBuilds() error {
+	ctx := context.Background()
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(ctx, &seedlings,
+		"SELECT * FROM seedlings WHERE deleted_at IS NULL AND archived = FALSE ORDER BY id"); err != nil {
+		return err
+	}
+
+	summary := &resumeSummary{skipped: map[string]int{}}
+	complete := []store.Seedling{}
+	for _, seedling := range seedlings {
+		switch seedling.Step {
+		case pipeline.SeedlingStepComplete:
+			complete = append(complete, seedling)
+			continue
+		case pipeline.SeedlingStepFailed:
+			summary.skipped["failed"]++
+			continue
+		case pipeline.SeedlingStepPlan:
+			summary.skipped["awaiting plan approval"]++
+			continue
+		case pipeline.SeedlingStepAwaitingConfig:
+			summary.skipped["awaiting env"]++
+			continue
+		case pipeline.SeedlingStepAwaitingHooks:
+			summary.skipped["awaiting hooks"]++
+			continue
+		case pipeline.SeedlingStepAwaitingApproval:
+			summary.skipped["awaiting approval"]++
+			continue
+		}
+		lease, err := s.BuildRegistry.Lease(ctx, seedling.ID)
+		if err != nil {
+			return err
+		}
+		if lease != nil {
+			if wait := time.Until(lease.HeartbeatAt.Add(pipeline.BuildLeaseTTL)); wait > 0 {
+				seedling := seedling
+				time.AfterFunc(wait, func() { s.SubmitBuild(ctx, seedling) })
+				summary.deferred++
+				continue
+			}
+		}
+		s.SubmitBuild(ctx, seedling)
+		summary.resumed++
+	}
+
+	go s.reconcileContainers(ctx, complete, summary)
+	return nil
+}
+
+// reconcileContainers starts the containers of complete seedlings that
+// aren't running, one at a time, and logs the summary of the resumption.
+func (s *Server) reconcileContainers(ctx context.Context, seedlings []store.Seedling, summary *resumeSummary) {
+	defer summary.log(s.log)
+	states, err := s.containers.get(ctx, s.Docker)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to list containers, not restarting any")
+		summary.skipped["containers unknown"] += len(seedlings)
+		return
+	}
+	for _, seedling := range seedlings {
+		state, ok := states[s.ContainerName(seedling)]
+		switch {
+		case ok && state == "running":
+			summary.running++
+			continue
+		case seedling.ContainerAction == "stop":
+			summary.skipped["stopped through the API"]++
+			continue
+		case seedling.OutputsQuotaExceeded:
+			summary.skipped["over outputs quota"]++
+			continue
+		}
+
+		actionCtx, cancel := context.WithTimeout(ctx, ContainerActionTimeout)
+		state, err := s.containerAction(actionCtx, &seedling, "start")
+		cancel()
+		if err != nil {
+			s.log.WithField("error", err).
+				WithField("name", seedling.Name).
+				Error("failed to restart seedling container")
+			summary.failed++
+			continue
+		}
+		s.log.WithField("name", seedling.Name).Info("Restarted seedling container")
+		s.Emit(ctx, seedling.ID, pipeline.SeedlingEvent{
+			Type:    pipeline.EventContainerAction,
+			Step:    seedling.Step,
+			Payload: pipeline.EventPayload{"action": "start", "state": state, "reason": "restart"},
+		})
+		summary.restarted++
+	}
+}
+
+func (summary *resumeSummary) log(log *logrus.Entry) {
+	log.WithField("resumed", summary.resumed).
+		WithField("deferred", summary.deferred).
+		WithField("running", summary.running).
+		WithField("restarted", summary.restarted).
+		WithField("restart_failed", summary.failed).
+		WithField("skipped", summary.skipped).
+		Info("Resumed seedlings after restart")
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// retrySeedling moves a failed seedling back to the step it failed at and
+// queues its build. It returns false if the seedling isn't failed, e.g.
+// because another retry got to it first.
+func (s *Server) retrySeedling(ctx context.Context, seedling *store.Seedling) (bool, error) {
+	step := seedling.FailedStep
+	if step == "" {
+		// failed before the step was recorded
+		step = pipeline.SeedlingStepProtobufs
+	}
+	now := time.Now()
+	result, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, modified_at = $2, version = version + 1,
+	   failure_reason = '', failed_step = '', failed_at = NULL
+	 WHERE id = $3 AND step = $4
+	 `, step, now, seedling.ID, pipeline.SeedlingStepFailed)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	seedling.Step = step
+	seedling.StepStartedAt = &now
+	seedling.ModifiedAt = now
+	seedling.FailureReason = ""
+	seedling.FailedStep = ""
+	seedling.FailedAt = nil
+	s.Notify(ctx, *seedling, pipeline.EventStepChanged, step)
+	s.SubmitBuild(ctx, *seedling)
+	return true, nil
+}
+
+// RetrySeedling rebuilds a failed seedling from the step it failed at.
+func (s *Server) RetrySeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	if seedling.Archived {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is archived", nil)
+		return
+	}
+	if seedling.Step != pipeline.SeedlingStepFailed {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling hasn't failed", map[string]string{"step": seedling.Step})
+		return
+	}
+
+	retried, err := s.retrySeedling(r.Context(), &seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to retry seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if !retried {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is already being retried", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
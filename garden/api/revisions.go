@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// emptyTreeSHA is git's empty tree, which a seedling's first revision is
+// diffed against.
+const emptyTreeSHA = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// RevisionDiff is a unified diff of a revision's code against an earlier
+// revision's, or against nothing for the first.
+type RevisionDiff struct {
+	Revision int `json:"revision"`
+	Against  int `json:"against,omitempty"`
+	// Diff is empty when Unavailable is set.
+	Diff        string             `json:"diff"`
+	Stat        *pipeline.DiffStat `json:"stat,omitempty"`
+	Unavailable string             `json:"unavailable,omitempty"`
+}
+
+// getRevision returns the seedling's revision numbered param, or
+// sql.ErrNoRows if it has none.
+func (s *Server) getRevision(ctx context.Context, seedling store.Seedling, param string) (*pipeline.SeedlingRevision, error) {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+	var rev pipeline.SeedlingRevision
+	if err := s.Reads.GetContext(ctx, &rev,
+		"SELECT * FROM seedling_revisions WHERE seedling_id = $1 AND number = $2", seedling.ID, n); err != nil {
+		return nil, err
+	}
+	return &rev, rev.Decode(seedling)
+}
+
+// revisionAt returns the seedling's latest revision at the commit sha, nil
+// if there's none.
+func (s *Server) revisionAt(ctx context.Context, seedling store.Seedling, sha string) (*pipeline.SeedlingRevision, error) {
+	var rev pipeline.SeedlingRevision
+	if err := s.Reads.GetContext(ctx, &rev,
+		"SELECT * FROM seedling_revisions WHERE seedling_id = $1 AND commit_sha = $2 ORDER BY number DESC LIMIT 1",
+		seedling.ID, sha); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rev, rev.Decode(seedling)
+}
+
+// lookupRevision gets the revision {n} of the request's seedling,
+// responding with an error if it can't.
+func (s *Server) lookupRevision(w http.ResponseWriter, r *http.Request) (store.Seedling, *pipeline.SeedlingRevision, bool) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return seedling, nil, false
+	}
+	rev, err := s.getRevision(r.Context(), seedling, mux.Vars(r)["n"])
+	if err == sql.ErrNoRows {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "revision not found", nil)
+		return seedling, nil, false
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get revision")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return seedling, nil, false
+	}
+	return seedling, rev, true
+}
+
+// ListRevisions lists the seedling's revisions, latest first.
+func (s *Server) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	revisions := []pipeline.SeedlingRevision{}
+	if err := s.Reads.SelectContext(r.Context(), &revisions,
+		"SELECT * FROM seedling_revisions WHERE seedling_id = $1 ORDER BY number DESC", seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling revisions")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	for i := range revisions {
+		if err := revisions[i].Decode(seedling); err != nil {
+			s.log.WithField("error", err).Error("failed to decode seedling revision")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"current":   seedling.CurrentRevision,
+		"revisions": revisions,
+	}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// GetRevision returns revision {n} of the seedling, with links to its diff,
+// its code and deploying it.
+func (s *Server) GetRevision(w http.ResponseWriter, r *http.Request) {
+	_, rev, ok := s.lookupRevision(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rev); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// RevisionDiff returns a unified diff of the seedling's code at revision
+// {n} against revision ?against=, which defaults to the previous revision.
+func (s *Server) RevisionDiff(w http.ResponseWriter, r *http.Request) {
+	seedling, rev, ok := s.lookupRevision(w, r)
+	if !ok {
+		return
+	}
+	result := RevisionDiff{Revision: rev.Number}
+	from := emptyTreeSHA
+	var against *pipeline.SeedlingRevision
+	var err error
+	if param := r.URL.Query().Get("against"); param != "" {
+		against, err = s.getRevision(r.Context(), seedling, param)
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "revision to diff against not found", nil)
+			return
+		}
+	} else if rev.Number > 1 {
+		against, err = s.getRevision(r.Context(), seedling, strconv.Itoa(rev.Number-1))
+		if err == sql.ErrNoRows {
+			against, err = nil, nil
+		}
+	}
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get revision")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if against != nil {
+		from = against.CommitSHA
+		result.Against = against.Number
+	}
+
+	diff, err := pipeline.GitOutput(r.Context(), s.RepoDir(seedling), "diff", "--relative", from, rev.CommitSHA, "--", ".")
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to diff revisions")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if len(diff) > pipeline.MAX_DIFF_BYTES {
+		result.Unavailable = "diff unavailable: content too large"
+	} else {
+		result.Diff = diff
+		result.Stat = revisionDiffStat(diff)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&result); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// revisionDiffStat is ParseDiffStat for a git diff, which may change many
+// files.
+func revisionDiffStat(diff string) *pipeline.DiffStat {
+	stat := pipeline.ParseDiffStat(diff)
+	stat.FilesChanged = 0
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			stat.FilesChanged++
+		}
+	}
+	return stat
+}
+
+// ExportRevision streams a gzipped tarball of the seedling's code at
+// revision {n}.
+func (s *Server) ExportRevision(w http.ResponseWriter, r *http.Request) {
+	seedling, rev, ok := s.lookupRevision(w, r)
+	if !ok {
+		return
+	}
+	prefix := fmt.Sprintf("%s-r%d", seedling.Name, rev.Number)
+	cmd := exec.CommandContext(r.Context(), "git", "archive", "--format=tar.gz", "--prefix="+prefix+"/", rev.CommitSHA+":./")
+	cmd.Dir = s.RepoDir(seedling)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, prefix))
+	var stderr strings.Builder
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	// The status has been sent by the time git fails, so the truncated
+	// stream is all the client gets.
+	if err := cmd.Run(); err != nil {
+		s.log.WithField("error", err).WithField("output", stderr.String()).Error("failed to export revision")
+	}
+}
+
+// DeployRevision deploys revision {n} of the seedling again, as a rollback
+// to it does.
+func (s *Server) DeployRevision(w http.ResponseWriter, r *http.Request) {
+	seedling, rev, ok := s.lookupRevision(w, r)
+	if !ok {
+		return
+	}
+	s.deployCommit(w, r, seedling, rev.CommitSHA, rev)
+}
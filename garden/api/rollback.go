@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	commitSHARegex = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+)
+
+// rollbackRequest names what to roll back to: one of the seedling's
+// revisions, the commit an attempt made, by the ids ListAttempts returns,
+// or a commit in the seedling's repo.
+type rollbackRequest struct {
+	ToRevision int    `json:"toRevision"`
+	ToAttempt  int64  `json:"toAttempt"`
+	ToSHA      string `json:"toSha"`
+}
+
+// resolveCommit returns the full SHA of a commit in the repo at dir.
+func resolveCommit(ctx context.Context, dir, sha string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", sha+"^{commit}")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// restoreCommit replaces the tracked files under dir with their contents at
+// sha and commits the result, so the history the rollback undoes is kept.
+func restoreCommit(ctx context.Context, seedling store.Seedling, dir, sha string) error {
+	for _, args := range [][]string{
+		{"rm", "-r", "-q", "--ignore-unmatch", "--", "."},
+		{"checkout", sha, "--", "."},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %w: %s", args[0], err, out)
+		}
+	}
+	pipeline.CommitRepo(ctx, dir, fmt.Sprintf("seedling %s: rollback to %s", seedling.Name, sha))
+	return nil
+}
+
+func (s *Server) isQueued(seedling store.Seedling) bool {
+	for _, queued := range s.Scheduler.Queued() {
+		if queued.ID == seedling.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// RollbackSeedling deploys an earlier revision of the seedling, or the
+// code of an earlier commit. See deployCommit.
+func (s *Server) RollbackSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	var req rollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	given := 0
+	for _, set := range []bool{req.ToRevision != 0, req.ToAttempt != 0, req.ToSHA != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "one of toRevision, toAttempt or toSha is required", nil)
+		return
+	}
+	if req.ToRevision != 0 {
+		rev, err := s.getRevision(r.Context(), seedling, strconv.Itoa(req.ToRevision))
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "revision not found", nil)
+			return
+		}
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get revision")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		s.deployCommit(w, r, seedling, rev.CommitSHA, rev)
+		return
+	}
+
+	sha := strings.ToLower(strings.TrimSpace(req.ToSHA))
+	if req.ToAttempt != 0 {
+		a, err := s.GetAttempt(r.Context(), seedling.ID, fmt.Sprint(req.ToAttempt))
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "attempt not found", nil)
+			return
+		}
+		if err != nil {
+			s.log.WithField("error", err).Error("failed to get attempt")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+		if a.CommitSHA == "" {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "attempt has no commit to roll back to", nil)
+			return
+		}
+		sha = a.CommitSHA
+	} else if !commitSHARegex.MatchString(sha) {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "toSha must be a hex commit SHA", nil)
+		return
+	}
+	sha, err := resolveCommit(r.Context(), s.RepoDir(seedling), sha)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "commit not found in the seedling's repo", nil)
+		return
+	}
+	// A commit one of its revisions was built from is that revision.
+	rev, err := s.revisionAt(r.Context(), seedling, sha)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get revision")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	s.deployCommit(w, r, seedling, sha, rev)
+}
+
+// deployCommit restores the seedling's code to the commit sha, rebuilds its
+// image and replaces its container with one running it. It's rejected while
+// the seedling is being built, and leaves it complete with rev, the
+// revision sha is of, as its current revision, or a new revision if it's
+// nil. The revision is switched in the update that completes the seedling,
+// once the new container runs.
+func (s *Server) deployCommit(w http.ResponseWriter, r *http.Request, seedling store.Seedling, sha string, rev *pipeline.SeedlingRevision) {
+	if seedling.Archived {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is archived", nil)
+		return
+	}
+	dir := s.RepoDir(seedling)
+	if s.isQueued(seedling) {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is queued to be built", nil)
+		return
+	}
+	// Holding the build lease keeps builds from starting until the
+	// rollback is done.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	acquired, err := s.BuildRegistry.TryAcquire(ctx, seedling, cancel)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to acquire build lease")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if !acquired {
+		lease, _ := s.BuildRegistry.Lease(ctx, seedling.ID)
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "seedling is being built", lease)
+		return
+	}
+	defer s.BuildRegistry.Release(seedling.ID)
+
+	if err := restoreCommit(ctx, seedling, dir, sha); err != nil {
+		s.log.WithField("error", err).Error("failed to restore seedling commit")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to restore commit", nil)
+		return
+	}
+
+	if out, err := s.buildSeedlingImage(ctx, seedling, dir); err != nil {
+		s.log.WithField("error", err).Error("failed to rebuild seedling image")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to rebuild image",
+			map[string]string{"output": strings.TrimRight(pipeline.ErrorTail(out, s.Settings.Current().ErrorOutputLines), "\n")})
+		return
+	}
+	if _, err := s.StartSeedlingContainer(ctx, &seedling, true); err != nil {
+		s.log.WithField("error", err).Error("failed to restart seedling container")
+		if s.respondContainerError(w, err) {
+			return
+		}
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "failed to restart container", nil)
+		return
+	}
+
+	now := time.Now()
+	current := seedling.CurrentRevision
+	if rev != nil {
+		current = rev.Number
+	}
+	if _, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, modified_at = $2, revision = revision + 1, version = version + 1,
+	   refine_instruction = '', refine_base = '', failure_reason = '', failed_step = '', failed_at = NULL,
+	   current_revision = $3
+	 WHERE id = $4
+	 `, pipeline.SeedlingStepComplete, now, current, seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to update seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if err := s.ClearCheckpoint(ctx, seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to clear checkpoint")
+	}
+	pipeline.LoggerFromContext(ctx).WithField("name", seedling.Name).
+		WithField("sha", sha).
+		WithField("revision", current).
+		WithField("rolled_back_by", pipeline.APIKeyFromContext(ctx)).
+		Info("Rolled back seedling")
+
+	if seedling.Step != pipeline.SeedlingStepComplete {
+		s.Notify(ctx, seedling, pipeline.EventStepChanged, pipeline.SeedlingStepComplete)
+	}
+	seedling.Step = pipeline.SeedlingStepComplete
+	seedling.StepStartedAt = &now
+	seedling.ModifiedAt = now
+	seedling.Revision++
+	seedling.RefineInstruction = ""
+	seedling.RefineBase = ""
+	seedling.FailureReason = ""
+	seedling.FailedStep = ""
+	seedling.FailedAt = nil
+	seedling.CurrentRevision = current
+	seedling.Version++
+	if rev != nil {
+		s.EmitRevisionDeployed(ctx, seedling, *rev, false)
+	} else if err := s.RecordRevision(ctx, &seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to record seedling revision")
+	}
+	// The rolled back code is a revision of its own.
+	go s.RecordModuleReport(s.BuildRegistry.Detach(ctx, seedling), seedling)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestInvokeRoutes checks that the invoke and history routes take requests
+// for seedlings named like the segments of the /api/v1/seedlings/{id}/...
+// routes, which would otherwise match first.
+func TestInvokeRoutes(t *testing.T) {
+	s, env := testServer(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Docker.HostPorts = map[int]int{8001: port}
+	for _, name := range []string{"events", "history", "container", "files"} {
+		env.Seedling(t, name)
+	}
+	h := s.Routes()
+
+	tests := []struct {
+		method, target string
+		// proxied is what the seedling's service was sent, or "" if the
+		// request shouldn't reach it.
+		proxied string
+	}{
+		{"GET", "/api/v1/seedlings/invoke/events/ping", "GET /ping"},
+		{"GET", "/api/v1/gardens/default/invoke/events/ping", "GET /ping"},
+		{"POST", "/api/v1/seedlings/invoke/history/v1/echo", "POST /v1/echo"},
+		{"POST", "/api/v1/seedlings/invoke/container/stop", "POST /stop"},
+		{"POST", "/api/v1/gardens/default/invoke/container/restart", "POST /restart"},
+		{"GET", "/api/v1/seedlings/invoke/files/server/main.go", "GET /server/main.go"},
+		{"GET", "/api/v1/seedlings/history/events", ""},
+		{"GET", "/api/v1/seedlings/history/history", ""},
+		{"GET", "/api/v1/gardens/default/history/events", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			// A route shadowed by an event stream would never return.
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req := httptest.NewRequest(tt.method, tt.target, nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%d %s", w.Code, w.Body)
+			}
+			body, _ := ioutil.ReadAll(w.Body)
+			if tt.proxied != "" && string(body) != tt.proxied {
+				t.Errorf("proxied %q, want %q", body, tt.proxied)
+			}
+			if tt.proxied == "" && !strings.HasPrefix(string(body), `{"history":`) {
+				t.Errorf("not the seedling's history: %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// SeedlingExamples returns the example call of each of the seedling's rpcs.
+func (s *Server) SeedlingExamples(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	examples := []pipeline.RPCExample{}
+	if err := s.Reads.SelectContext(r.Context(), &examples,
+		"SELECT * FROM seedling_examples WHERE seedling_id = $1 ORDER BY id", seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get examples")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&examples); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// applyExample turns an invoke request with ?exampleId= into the example's
+// call: its request as the body, to its HTTP operation if it has one. It
+// writes an error response and returns false if it can't.
+func (s *Server) applyExample(w http.ResponseWriter, r *http.Request, seedling store.Seedling) bool {
+	query := r.URL.Query()
+	id, err := parseID(query.Get("exampleId"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "exampleId: "+err.Error(), nil)
+		return false
+	}
+	var example pipeline.RPCExample
+	if err := s.Reads.GetContext(r.Context(), &example,
+		"SELECT * FROM seedling_examples WHERE id = $1 AND seedling_id = $2", id, seedling.ID); err != nil {
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "example not found", nil)
+			return false
+		}
+		s.log.WithField("error", err).Error("failed to get example")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return false
+	}
+	if !example.Valid {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "example is invalid", map[string]string{"error": example.Error})
+		return false
+	}
+
+	query.Del("exampleId")
+	r.URL.RawQuery = query.Encode()
+	if example.HTTPPath != "" {
+		r.URL.Path = example.HTTPPath
+		r.Method = example.HTTPMethod
+	} else if r.Method == http.MethodGet {
+		r.Method = http.MethodPost
+	}
+	r.Body = ioutil.NopCloser(strings.NewReader(string(example.Request)))
+	r.ContentLength = int64(len(example.Request))
+	r.Header.Set("Content-Type", "application/json")
+	return true
+}
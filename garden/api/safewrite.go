@@ -0,0 +1,23 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// respondWriteError responds to a write to a seedling's repo that failed:
+// 413 or 400 if it was refused, 500 otherwise.
+func (s *Server) respondWriteError(w http.ResponseWriter, err error, msg string) {
+	var unsafeErr *pipeline.UnsafeWriteError
+	switch {
+	case errors.As(err, &unsafeErr) && unsafeErr.TooLarge:
+		s.respondError(w, http.StatusRequestEntityTooLarge, pipeline.ErrCodeTooLarge, unsafeErr.Error(), nil)
+	case errors.As(err, &unsafeErr):
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, unsafeErr.Error(), nil)
+	default:
+		s.log.WithField("error", err).Error(msg)
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+	}
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	tagRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+	// seedlingSortColumns are the columns ?sort= accepts.
+	seedlingSortColumns = map[string]string{
+		"created_at":  "seedlings.created_at",
+		"createdAt":   "seedlings.created_at",
+		"modified_at": "seedlings.modified_at",
+		"modifiedAt":  "seedlings.modified_at",
+		"name":        "seedlings.name",
+		"step":        "seedlings.step",
+	}
+)
+
+type TagCount struct {
+	Tag   string `db:"tag" json:"tag"`
+	Count int    `db:"count" json:"count"`
+}
+
+// normalizeTags lowercases and dedupes tags, rejecting any that aren't
+// simple identifiers.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: tags must be letters, digits, '_', '.' and '-'", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// ftsQuery turns free text into an FTS5 query matching every term as a
+// prefix, so user input can't produce FTS syntax errors.
+func ftsQuery(q string) string {
+	terms := []string{}
+	for _, term := range strings.Fields(q) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// seedlingFilter is the WHERE clause for ?tag= (all must match), ?q=
+// (full-text over name and description) and ?name= (exact).
+func seedlingFilter(r *http.Request) (string, []interface{}, error) {
+	where := []string{}
+	if !includeDeleted(r) {
+		where = append(where, "seedlings.deleted_at IS NULL")
+	}
+	args := []interface{}{}
+	tags, err := normalizeTags(r.URL.Query()["tag"])
+	if err != nil {
+		return "", nil, err
+	}
+	for _, tag := range tags {
+		args = append(args, tag)
+		where = append(where, fmt.Sprintf("seedlings.id IN (SELECT seedling_id FROM seedling_tags WHERE tag = $%d)", len(args)))
+	}
+	if q := ftsQuery(r.URL.Query().Get("q")); q != "" {
+		args = append(args, q)
+		where = append(where, fmt.Sprintf("seedlings.id IN (SELECT rowid FROM seedlings_fts WHERE seedlings_fts MATCH $%d)", len(args)))
+	}
+	if v := r.URL.Query().Get("bitrot"); v != "" {
+		bitrot, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("bitrot must be true or false")
+		}
+		args = append(args, bitrot)
+		where = append(where, fmt.Sprintf("seedlings.bitrot = $%d", len(args)))
+	}
+	if name := r.URL.Query().Get("name"); name != "" {
+		args = append(args, name)
+		where = append(where, fmt.Sprintf("seedlings.name = $%d", len(args)))
+	}
+	// Without a garden every garden's seedlings are listed.
+	garden := mux.Vars(r)["garden"]
+	if garden == "" {
+		garden = r.URL.Query().Get("garden")
+	}
+	if garden != "" {
+		args = append(args, garden)
+		where = append(where, fmt.Sprintf("seedlings.garden = $%d", len(args)))
+	}
+	if len(where) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args, nil
+}
+
+// listParams parses ?sort=, ?order=, ?limit= and ?offset= into an ORDER BY
+// and LIMIT clause. Without ?limit= everything is returned.
+func listParams(r *http.Request, sortColumns map[string]string, defaultSort string) (string, error) {
+	query := r.URL.Query()
+	column := defaultSort
+	if sort := query.Get("sort"); sort != "" {
+		c, ok := sortColumns[sort]
+		if !ok {
+			return "", fmt.Errorf("can't sort by %q", sort)
+		}
+		column = c
+	}
+	direction := "DESC"
+	switch strings.ToLower(query.Get("order")) {
+	case "", "desc":
+	case "asc":
+		direction = "ASC"
+	default:
+		return "", fmt.Errorf("order must be asc or desc")
+	}
+	clause := fmt.Sprintf(" ORDER BY %s %s", column, direction)
+
+	limit, offset := -1, 0
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > pipeline.MAX_LIST_LIMIT {
+			return "", fmt.Errorf("limit must be between 1 and %d", pipeline.MAX_LIST_LIMIT)
+		}
+		limit = l
+	}
+	if v := query.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return "", fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = o
+	}
+	return clause + fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset), nil
+}
+
+type patchSeedlingRequest struct {
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+	// RebuildSchedule "" stops scheduled rebuilds.
+	RebuildSchedule *string `json:"rebuildSchedule"`
+	AutoHeal        *bool   `json:"autoHeal"`
+	// ApprovalRequired null makes the seedling's approval gates its
+	// garden's again.
+	ApprovalRequired json.RawMessage `json:"approvalRequired"`
+}
+
+// PatchSeedling updates only the fields present in the request body.
+func (s *Server) PatchSeedling(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok || !s.checkIfMatch(w, r, seedling) {
+		return
+	}
+
+	var req patchSeedlingRequest
+	invalid, err := decodeStrict(r.Body, &req)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	schedule := seedling.SeedlingRebuildSchedule
+	if invalid == nil {
+		errs := fieldErrors{}
+		if req.Description != nil {
+			checkDescription(&errs, *req.Description)
+		}
+		if req.Tags != nil {
+			if _, err := normalizeTags(*req.Tags); err != nil {
+				errs.add("tags", FieldErrInvalid, err.Error())
+			}
+		}
+		if req.RebuildSchedule != nil {
+			if err := schedule.SetSchedule(*req.RebuildSchedule, time.Now()); err != nil {
+				errs.add("rebuildSchedule", FieldErrInvalid, err.Error())
+			}
+		}
+		if len(req.ApprovalRequired) > 0 {
+			var gates store.ApprovalSteps
+			if err := json.Unmarshal(req.ApprovalRequired, &gates); err != nil {
+				errs.add("approvalRequired", FieldErrType, "approvalRequired must be an array of steps or null")
+			} else if reason := checkApprovalSteps(gates); reason != "" {
+				errs.add("approvalRequired", FieldErrInvalid, reason)
+			} else {
+				seedling.ApprovalRequired = gates
+			}
+		}
+		invalid = errs.body("seedling is invalid")
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+
+	before := seedling
+	described := req.Description != nil && *req.Description != seedling.Description
+	if req.Description != nil {
+		seedling.Description = *req.Description
+	}
+	if req.AutoHeal != nil {
+		schedule.AutoHeal = *req.AutoHeal
+	}
+	seedling.SeedlingRebuildSchedule = schedule
+	seedling.ModifiedAt = time.Now()
+	result, err := s.DB.NamedExecContext(r.Context(), `
+	 UPDATE seedlings
+	 SET description = :description, rebuild_schedule = :rebuild_schedule, rebuild_due_at = :rebuild_due_at,
+	   auto_heal = :auto_heal, approval_required = :approval_required, modified_at = :modified_at, version = version + 1
+	 WHERE id = :id AND version = :version
+	 `, &seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to update seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if !s.updatedVersion(w, r, &seedling, result) {
+		return
+	}
+	if described {
+		s.embedSeedling(r.Context(), seedling)
+	}
+	seedling.Warning = s.editedWhileBuilding(r.Context(), before, pipeline.GenerationEdits(before, seedling))
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+			return
+		}
+		if err := s.ReplaceTags(r.Context(), seedling.ID, tags); err != nil {
+			s.log.WithField("error", err).Error("failed to update seedling tags")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return
+		}
+	}
+	if err := s.AttachTags(r.Context(), []*store.Seedling{&seedling}); err != nil {
+		s.log.WithField("error", err).Error("failed to get seedling tags")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&seedling); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// ListTags returns every tag in use with the number of seedlings that have
+// it.
+func (s *Server) ListTags(w http.ResponseWriter, r *http.Request) {
+	tags := []TagCount{}
+	if err := s.Reads.SelectContext(r.Context(), &tags,
+		"SELECT tag, COUNT(*) AS count FROM seedling_tags GROUP BY tag ORDER BY count DESC, tag"); err != nil {
+		s.log.WithField("error", err).Error("failed to get tags")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&tags); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
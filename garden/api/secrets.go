@@ -0,0 +1,240 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	secretNameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+type Secret struct {
+	SeedlingID hide.Int64 `db:"seedling_id" json:"-"`
+	Name       string     `db:"name" json:"name"`
+	CreatedAt  time.Time  `db:"created_at" json:"createdAt"`
+	ModifiedAt time.Time  `db:"modified_at" json:"modifiedAt"`
+}
+
+type secretRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// lookupSeedling loads the seedling addressed by the {id} route variable,
+// writing an error response and returning false if it can't.
+func (s *Server) lookupSeedling(w http.ResponseWriter, r *http.Request) (store.Seedling, bool) {
+	return s.findSeedling(w, r, includeDeleted(r))
+}
+
+// findSeedling is lookupSeedling, finding soft-deleted seedlings only if
+// withDeleted is set.
+func (s *Server) findSeedling(w http.ResponseWriter, r *http.Request, withDeleted bool) (store.Seedling, bool) {
+	id, err := parseSeedlingID(mux.Vars(r))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return store.Seedling{}, false
+	}
+
+	query := "SELECT * FROM seedlings WHERE id = $1"
+	if !withDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	var seedling store.Seedling
+	if err := s.Reads.GetContext(r.Context(), &seedling, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			s.log.WithField("id", mux.Vars(r)["id"]).Error("seedling not found")
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "seedling not found", nil)
+			return store.Seedling{}, false
+		}
+		s.log.WithField("error", err).Error("failed to get seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return store.Seedling{}, false
+	}
+	return seedling, true
+}
+
+// ensureIgnored keeps a directory of the seedling's repo, such as its
+// secrets, out of its git history, including for repos created before the
+// directory was ignored.
+func (s *Server) ensureIgnored(repoDir, dir string) error {
+	contents, err := ioutil.ReadFile(filepath.Join(repoDir, ".gitignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.TrimSpace(line) == dir || strings.TrimSpace(line) == dir+"/" {
+			return nil
+		}
+	}
+	return s.SafeWriteFile(repoDir, ".gitignore", append(contents, []byte("\n"+dir+"/\n")...), 0644)
+}
+
+// shredSecrets overwrites every secret file with random bytes before
+// removing the secrets directory.
+func shredSecrets(repoDir string) error {
+	return shredDir(pipeline.SeedlingSecretsDir(repoDir))
+}
+
+// shredDir overwrites every file in dir with random bytes before removing
+// it.
+func shredDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		junk := make([]byte, entry.Size())
+		if _, err := rand.Read(junk); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(junk); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		f.Close()
+	}
+	return os.RemoveAll(dir)
+}
+
+// PutSecret writes a secret for a seedling. The value is only ever written to
+// disk; it is never returned by the API.
+func (s *Server) PutSecret(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	var req secretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if !secretNameRegex.MatchString(req.Name) {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+			"secret name must only contain letters, digits, '_', '.' and '-'", nil)
+		return
+	}
+
+	if err := s.ensureIgnored(s.RepoDir(seedling), "secrets"); err != nil {
+		s.log.WithField("error", err).Error("failed to update .gitignore")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	dir := pipeline.SeedlingSecretsDir(s.RepoDir(seedling))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		s.log.WithField("error", err).Error("failed to create secrets dir")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if err := s.SafeWriteFile(s.RepoDir(seedling), filepath.Join("secrets", req.Name), []byte(req.Value), 0600); err != nil {
+		s.respondWriteError(w, err, "failed to write secret")
+		return
+	}
+
+	now := time.Now()
+	secret := Secret{SeedlingID: seedling.ID, Name: req.Name, CreatedAt: now, ModifiedAt: now}
+	if _, err := s.DB.NamedExecContext(r.Context(), `
+	 INSERT INTO seedling_secrets (seedling_id, name, created_at, modified_at)
+	 VALUES (:seedling_id, :name, :created_at, :modified_at)
+	 ON CONFLICT (seedling_id, name) DO UPDATE SET modified_at = excluded.modified_at
+	 `, &secret); err != nil {
+		s.log.WithField("error", err).Error("failed to record secret")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(&secret); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// ListSecrets returns the names and timestamps of a seedling's secrets.
+func (s *Server) ListSecrets(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	secrets := []Secret{}
+	if err := s.DB.SelectContext(r.Context(), &secrets,
+		"SELECT * FROM seedling_secrets WHERE seedling_id = $1 ORDER BY name", seedling.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get secrets")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&secrets); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+}
+
+// DeleteSecret removes a single secret from a seedling.
+func (s *Server) DeleteSecret(w http.ResponseWriter, r *http.Request) {
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if !secretNameRegex.MatchString(name) {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid secret name", nil)
+		return
+	}
+
+	result, err := s.DB.ExecContext(r.Context(),
+		"DELETE FROM seedling_secrets WHERE seedling_id = $1 AND name = $2", seedling.ID, name)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to delete secret")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "secret not found", nil)
+		return
+	}
+	if err := os.Remove(filepath.Join(pipeline.SeedlingSecretsDir(s.RepoDir(seedling)), name)); err != nil && !os.IsNotExist(err) {
+		s.log.WithField("error", err).Error("failed to remove secret file")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": "secret deleted"}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
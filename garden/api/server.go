@@ -0,0 +1,388 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/blobs"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	responseData struct {
+		status int
+		size   int
+	}
+
+	loggingResponseWriter struct {
+		http.ResponseWriter
+		responseData *responseData
+	}
+
+	// Server is the HTTP API in front of a Pipeline, built so that it can
+	// run against a test database and fake providers.
+	Server struct {
+		*pipeline.Pipeline
+		log *logrus.Entry
+		// blobs keeps archives and outputs, see BLOB_STORE.
+		blobs blobs.BlobStore
+		// reports is when daily reports are due and what they price tokens at.
+		reports pipeline.ReportSchedule
+
+		containers ContainerStateCache
+		progress   ProgressCache
+
+		logFollowSessions chan struct{}
+	}
+)
+
+func NewServer(db *sqlx.DB, config pipeline.Config, log *logrus.Entry, provider llm.LLM) *Server {
+	p, err := pipeline.New(pipeline.Deps{DB: db, Config: config, LLM: provider})
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid configuration")
+	}
+	return newServer(p, log)
+}
+
+// newServer is the API of p.
+func newServer(p *pipeline.Pipeline, log *logrus.Entry) *Server {
+	config := p.Config
+	s := &Server{
+		Pipeline:          p,
+		log:               log,
+		logFollowSessions: make(chan struct{}, config.LogsFollowMaxSessions),
+	}
+	var err error
+	if s.blobs, err = pipeline.NewBlobStore(config); err != nil {
+		log.WithField("error", err).Fatal("Invalid BLOB_STORE")
+	}
+	if s.reports, err = pipeline.NewReportSchedule(config); err != nil {
+		log.WithField("error", err).Fatal("Invalid daily report configuration")
+	}
+	if err := pipeline.CheckCORS(config); err != nil {
+		log.WithField("error", err).Fatal("Invalid CORS_ALLOWED_ORIGINS")
+	}
+	return s
+}
+
+// walCheckpointLoop checkpoints the WAL into the database and truncates it
+// every WALCheckpointInterval, so it doesn't grow for as long as builds keep
+// readers open.
+func (s *Server) walCheckpointLoop(ctx context.Context) {
+	if s.Config.WALCheckpointInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.Config.WALCheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var result struct {
+				Busy         int `db:"busy"`
+				Log          int `db:"log"`
+				Checkpointed int `db:"checkpointed"`
+			}
+			if err := s.DB.GetContext(ctx, &result, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+				s.log.WithField("error", err).Error("failed to checkpoint WAL")
+				continue
+			}
+			s.log.WithField("busy", result.Busy == 1).
+				WithField("log_pages", result.Log).
+				WithField("checkpointed_pages", result.Checkpointed).
+				Debug("Checkpointed WAL")
+		}
+	}
+}
+
+// lockDataDir takes the lock file in DATA_DIR, creating the directory, so
+// that two instances can't share it by accident. The lock is held until the
+// returned file is closed or the process exits, whichever way it does.
+func lockDataDir(cfg pipeline.Config) (*os.File, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return nil, err
+	}
+	path := cfg.DataPath(pipeline.LockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid, _ := ioutil.ReadAll(f)
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is in use by another garden instance (pid %s); set DATA_DIR to run a second one", cfg.DataDir, strings.TrimSpace(string(pid)))
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+	return f, nil
+}
+
+// setupRepos creates the git repository seedlings are written into.
+func setupRepos(ctx context.Context, cfg pipeline.Config) error {
+	if err := os.MkdirAll(filepath.Join(cfg.ReposDir(), "seedlings"), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(cfg.SharedRepoDir()); !os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.SharedRepoDir(), 0755); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "init")
+	cmd.Dir = cfg.SharedRepoDir()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// setupDocker checks the docker daemon is reachable and creates the network
+// the default garden's seedling containers are attached to. Other gardens'
+// networks are created with the garden.
+func (s *Server) setupDocker() {
+	if err := s.Docker.Ping(context.Background()); err != nil {
+		s.log.WithField("error", err).Fatal("Docker must be running")
+	}
+
+	if err := s.Docker.EnsureNetwork(context.Background(), s.GardenNetwork(store.DefaultGarden)); err != nil {
+		s.log.WithField("error", err).Fatal("Failed to create docker network")
+	}
+}
+
+func (s *Server) Routes() *mux.Router {
+	r := mux.NewRouter()
+	// Middleware only runs for matched routes, so the fallback handlers are
+	// wrapped by hand.
+	r.Use(s.WithLogging, s.WithRecovery, s.WithCORS, s.WithAPIKey)
+	r.NotFoundHandler = s.WithLogging(s.WithRecovery(s.WithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "route not found", nil)
+	}))))
+	methodNotAllowed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.respondError(w, http.StatusMethodNotAllowed, pipeline.ErrCodeInvalidRequest, "method not allowed", nil)
+	})
+	r.MethodNotAllowedHandler = s.WithLogging(s.WithRecovery(s.WithCORS(methodNotAllowed)))
+	if s.Config.Metrics && s.Config.MetricsAddr == "" {
+		r.Handle("/metrics", metricsHandler()).Methods("GET")
+	}
+	r.PathPrefix("/outputs/").HandlerFunc(s.ServeOutput).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/seedlings", s.ListSeedlings).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings", s.CreateSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/batch", s.CreateSeedlings).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/status", s.SeedlingStatuses).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/similar", s.SimilarSeedlings).Methods("GET")
+	// mux routes to the first match, so these come before the
+	// /api/v1/seedlings/{id}/... routes, which would otherwise take requests
+	// for seedlings named like their last segment, e.g. files or events.
+	r.HandleFunc("/api/v1/seedlings/history/{name}", s.patchHandler).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/invoke/{name}/{rest:.*}", s.apiAccessHandler)
+	r.HandleFunc("/api/v1/gardens/{garden}/history/{name}", s.patchHandler).Methods("GET")
+	r.HandleFunc("/api/v1/gardens/{garden}/invoke/{name}/{rest:.*}", s.apiAccessHandler)
+	r.HandleFunc("/api/v1/seedlings/{id}", s.GetSeedling).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}", s.DeleteSeedling).Methods("DELETE")
+	r.HandleFunc("/api/v1/seedlings/{id}", s.UpdateSeedling).Methods("PUT")
+	r.HandleFunc("/api/v1/seedlings/{id}", s.PatchSeedling).Methods("PATCH")
+	r.HandleFunc("/api/v1/seedlings/{id}/logs", s.SeedlingLogs).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/approve-plan", s.ApprovePlan).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/approve", s.ApproveSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/reject", s.RejectSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/files", s.ListSeedlingFiles).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/files/{path:.+}", s.SeedlingFile).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/seedlings/{id}/attempts", s.ListAttempts).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/dependencies", s.SeedlingDependencies).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/transcript", s.SeedlingTranscript).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/examples", s.SeedlingExamples).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/endpoint", s.SeedlingEndpoint).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/container/{action}", s.SeedlingContainerAction).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/attempts/{n}/diff", s.AttemptDiff).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/events", s.SeedlingEvents).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/history", s.SeedlingHistory).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/openapi", s.SeedlingOpenAPI).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/readme", s.SeedlingReadme).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/rebuilds", s.SeedlingRebuilds).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/outputs", s.SeedlingOutputs).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/env", s.GetSeedlingEnv).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/env", s.PutSeedlingEnv).Methods("PUT")
+	r.HandleFunc("/api/v1/seedlings/{id}/hooks", s.SeedlingHooks).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/hooks/retry", s.RetrySeedlingHooks).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/secrets", s.ListSecrets).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/secrets", s.PutSecret).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/secrets/{name}", s.DeleteSecret).Methods("DELETE")
+	r.HandleFunc("/api/v1/seedlings/{id}/unarchive", s.UnarchiveSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/push", s.PushSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/promote", s.PromoteSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/refine", s.RefineSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/restore", s.RestoreSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/retry", s.RetrySeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/rollback", s.RollbackSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/revisions", s.ListRevisions).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/revisions/{n}", s.GetRevision).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/revisions/{n}/diff", s.RevisionDiff).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/revisions/{n}/export", s.ExportRevision).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/revisions/{n}/deploy", s.DeployRevision).Methods("POST")
+	r.HandleFunc("/api/v1/seedlings/{id}/quality-checks", s.QualityChecks).Methods("GET")
+	r.HandleFunc("/api/v1/seedlings/{id}/quality-override", s.QualityOverride).Methods("POST")
+	r.HandleFunc("/api/v1/gardens", s.ListGardens).Methods("GET")
+	r.HandleFunc("/api/v1/gardens", s.CreateGarden).Methods("POST")
+	r.HandleFunc("/api/v1/gardens/{garden}", s.PatchGarden).Methods("PATCH")
+	r.HandleFunc("/api/v1/gardens/{garden}/seedlings", s.ListSeedlings).Methods("GET")
+	r.HandleFunc("/api/v1/gardens/{garden}/seedlings", s.CreateSeedling).Methods("POST")
+	r.HandleFunc("/api/v1/gardens/{garden}/seedlings/batch", s.CreateSeedlings).Methods("POST")
+	r.HandleFunc("/api/v1/plan", s.PreviewPlan).Methods("POST")
+	r.HandleFunc("/api/v1/reports/daily", s.DailyReport).Methods("GET")
+	r.HandleFunc("/api/v1/schema/seedling", s.SeedlingSchema).Methods("GET")
+	r.HandleFunc("/api/v1/stats/steps", s.StepStats).Methods("GET")
+	r.HandleFunc("/api/v1/tags", s.ListTags).Methods("GET")
+	r.HandleFunc("/api/v1/templates", s.ListTemplates).Methods("GET")
+	r.HandleFunc("/api/v1/templates", s.CreateTemplate).Methods("POST")
+	r.HandleFunc("/api/v1/webhooks", s.ListWebhooks).Methods("GET")
+	r.HandleFunc("/api/v1/webhooks", s.CreateWebhook).Methods("POST")
+	r.HandleFunc("/api/v1/webhooks/{id}", s.DeleteWebhook).Methods("DELETE")
+	r.HandleFunc("/api/v1/webhooks/{id}/deliveries", s.WebhookDeliveries).Methods("GET")
+	r.HandleFunc("/api/v1/experiments", s.ListExperiments).Methods("GET")
+	r.HandleFunc("/api/v1/experiments", s.CreateExperiment).Methods("POST")
+	r.HandleFunc("/api/v1/experiments/{id}", s.GetExperiment).Methods("GET")
+	r.HandleFunc("/api/v1/experiments/{id}", s.DeleteExperiment).Methods("DELETE")
+	r.HandleFunc("/api/v1/experiments/{id}/diff", s.ExperimentDiff).Methods("GET")
+	r.HandleFunc("/api/v1/experiments/{id}/variants/{variant}/keep", s.KeepVariant).Methods("POST", "DELETE")
+	r.HandleFunc("/api/v1/admin/backup", s.Backup).Methods("POST")
+	r.HandleFunc("/api/v1/admin/builds", s.Builds).Methods("GET")
+	r.HandleFunc("/api/v1/admin/builds/{id}", s.KillBuild).Methods("DELETE")
+	r.HandleFunc("/api/v1/admin/cache", s.ModCache).Methods("GET")
+	r.HandleFunc("/api/v1/admin/cache", s.PurgeModCache).Methods("DELETE")
+	r.HandleFunc("/api/v1/admin/disk-usage", s.DiskUsage).Methods("GET")
+	r.HandleFunc("/api/v1/admin/embeddings/backfill", s.BackfillEmbeddings).Methods("POST")
+	r.HandleFunc("/api/v1/admin/env", s.Env).Methods("GET")
+	r.HandleFunc("/api/v1/admin/gc", s.GarbageCollect).Methods("POST")
+	r.HandleFunc("/api/v1/admin/hooks", s.ListHooks).Methods("GET")
+	r.HandleFunc("/api/v1/admin/hooks", s.CreateHook).Methods("POST")
+	r.HandleFunc("/api/v1/admin/hooks/{id}", s.GetHook).Methods("GET")
+	r.HandleFunc("/api/v1/admin/hooks/{id}", s.UpdateHook).Methods("PUT")
+	r.HandleFunc("/api/v1/admin/hooks/{id}", s.DeleteHook).Methods("DELETE")
+	r.HandleFunc("/api/v1/admin/import-policy", s.GetImportPolicy).Methods("GET")
+	r.HandleFunc("/api/v1/admin/import-policy", s.PutImportPolicy).Methods("PUT")
+	r.HandleFunc("/api/v1/admin/invalid-names", s.InvalidNames).Methods("GET")
+	r.HandleFunc("/api/v1/admin/settings", s.GetSettings).Methods("GET")
+	r.HandleFunc("/api/v1/admin/settings", s.PutSettings).Methods("PUT")
+	r.HandleFunc("/api/v1/admin/settings/changes", s.GetSettingChanges).Methods("GET")
+	// Routes only match their methods, so preflights need a route of their
+	// own for WithCORS to answer them. It's last, so OPTIONS requests
+	// proxied to seedlings still reach them, and matches with a func since
+	// a method matcher would make unknown paths 405s rather than 404s.
+	if len(s.Config.CORSAllowedOrigins) > 0 {
+		r.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+			return r.Method == http.MethodOptions
+		}).Handler(methodNotAllowed)
+	}
+	return r
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(statusCode int) {
+	if lrw.responseData.status == 0 {
+		lrw.responseData.status = statusCode
+	}
+	lrw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lrw.responseData.status == 0 {
+		lrw.responseData.status = http.StatusOK
+	}
+	size, err := lrw.ResponseWriter.Write(b)
+	lrw.responseData.size += size
+	return size, err
+}
+
+// Flush lets streaming handlers flush through the logging middleware.
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *Server) WithLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(pipeline.RequestIDHeader)
+		if !requestIDRegex.MatchString(requestID) {
+			requestID = pipeline.NewRequestID()
+		}
+		log := s.log.WithField("request_id", requestID)
+		w.Header().Set(pipeline.RequestIDHeader, requestID)
+		r = r.WithContext(pipeline.WithLogger(pipeline.WithRequestID(r.Context(), requestID), log))
+
+		responseData := &responseData{
+			status: 0,
+			size:   0,
+		}
+		lrw := &loggingResponseWriter{
+			ResponseWriter: w, // compose original http.ResponseWriter
+			responseData:   responseData,
+		}
+		next.ServeHTTP(lrw, r)
+		duration := time.Since(start)
+		observeHTTPRequest(r, responseData.status, duration)
+
+		log.WithFields(logrus.Fields{
+			"uri":      r.RequestURI,
+			"method":   r.Method,
+			"status":   responseData.status,
+			"duration": duration,
+			"size":     responseData.size,
+		}).Info("Finished request")
+	})
+}
+
+// WithRecovery turns a panicking handler into a 500 response instead of a
+// dropped connection, logging the stack and marking the request's span as
+// errored.
+func (s *Server) WithRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			err := fmt.Errorf("panic: %v", rec)
+			pipeline.LoggerFromContext(r.Context()).WithFields(logrus.Fields{
+				"error": err,
+				"stack": string(debug.Stack()),
+			}).Error("handler panicked")
+			span := trace.SpanFromContext(r.Context())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+
+			if lrw, ok := w.(*loggingResponseWriter); ok && lrw.responseData.status != 0 {
+				// Too late for an error response, the handler already
+				// started writing one.
+				return
+			}
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
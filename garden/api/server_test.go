@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/pipelinetest"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// testServer is a Server of a pipeline with faked dependencies, and a
+// database, under t's temp dir.
+func testServer(t testing.TB) (*Server, *pipelinetest.Env) {
+	t.Helper()
+	env := pipelinetest.New(t)
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	return newServer(env.Pipeline(t), logrus.NewEntry(logger)), env
+}
+
+// newRequest is a request to the API with body, which is JSON unless it's
+// nil.
+func newRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+// serveRequest is the response of h to req.
+func serveRequest(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// serve is the response of h to a new request.
+func serve(h http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	return serveRequest(h, newRequest(method, target, body))
+}
+
+func TestCreateAndListSeedlings(t *testing.T) {
+	s, _ := testServer(t)
+	h := s.Routes()
+
+	// The plan is given, so the seedling waits for approval rather than
+	// being planned or built.
+	create := `{"name": "echo", "description": "echoes what it's sent", "plan": {
+		"summary": "An echo service.",
+		"rpcs": [{"name": "Say", "request": "SayRequest", "response": "SayReply", "description": "returns the text it's sent"}]
+	}}`
+	w := serve(h, "POST", "/api/v1/seedlings", strings.NewReader(create))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var created store.Seedling
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if loc := w.Header().Get("Location"); loc == "" {
+		t.Error("create: no Location")
+	}
+
+	w = serve(h, "POST", "/api/v1/seedlings", strings.NewReader(create))
+	if w.Code != http.StatusConflict {
+		t.Errorf("create of a taken name: %d %s", w.Code, w.Body)
+	}
+
+	w = serve(h, "GET", "/api/v1/seedlings", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body)
+	}
+	var list []store.Seedling
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("list: %v: %s", err, w.Body)
+	}
+	if len(list) != 1 || list[0].Name != "echo" || list[0].ID != created.ID || list[0].Step != pipeline.SeedlingStepPlan {
+		t.Errorf("list: %s", w.Body)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s, _ := testServer(t)
+	h := s.Routes()
+	for _, target := range []string{pipeline.SeedlingPath(999), "/no/such/route"} {
+		w := serve(h, "GET", target, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: %d %s", target, w.Code, w.Body)
+		}
+		var envelope ErrorEnvelope
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error.Code == "" {
+			t.Errorf("GET %s: not an error envelope: %s", target, w.Body)
+		}
+		if envelope.Error.RequestID == "" || envelope.Error.RequestID != w.Header().Get("X-Request-ID") {
+			t.Errorf("GET %s: request ID %q, header %q", target, envelope.Error.RequestID, w.Header().Get("X-Request-ID"))
+		}
+	}
+}
+
+// TestConcurrentBuildsAndReads builds seedlings and records more attempts
+// while the API is read, as a busy server does, none of which should fail
+// for the database being locked.
+func TestConcurrentBuildsAndReads(t *testing.T) {
+	const builds, readers = 4, 4
+	s, env := testServer(t)
+	var path string
+	if err := s.DB.Get(&path, "SELECT file FROM pragma_database_list WHERE name = 'main'"); err != nil {
+		t.Fatal(err)
+	}
+	reads, err := store.OpenReadDB(store.SQLiteDSN(path, 5*time.Second, true), readers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reads.Close()
+	s.Reads = reads
+	s.Config.WALCheckpointInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.walCheckpointLoop(ctx)
+	h := s.Routes()
+
+	seedlings := make([]store.Seedling, builds)
+	for i := range seedlings {
+		seedlings[i] = env.Seedling(t, fmt.Sprintf("echo%d", i))
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := map[string]int{}
+	requests := 0
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			targets := []string{
+				"/api/v1/seedlings",
+				"/api/v1/seedlings?fields=name,step,createdAt",
+				pipeline.SeedlingPath(seedlings[i%builds].ID),
+				pipeline.SeedlingPath(seedlings[i%builds].ID) + "/attempts",
+			}
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				target := targets[n%len(targets)]
+				w := serve(h, "GET", target, nil)
+				mu.Lock()
+				requests++
+				if w.Code >= 500 {
+					failures[fmt.Sprintf("GET %s: %d %s", target, w.Code, w.Body)]++
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	// Attempts written outside of the builds' steps, e.g. by branches.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; ; n++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			a := store.Attempt{SeedlingID: seedlings[n%builds].ID, Step: pipeline.SeedlingStepServer, Attempt: 100 + n, Output: strings.Repeat("failed ", 1000)}
+			if err := s.RecordBranchAttempt(ctx, a); err != nil {
+				mu.Lock()
+				failures["RecordBranchAttempt: "+err.Error()]++
+				mu.Unlock()
+			}
+		}
+	}()
+
+	for _, seedling := range seedlings {
+		s.SubmitBuild(ctx, seedling)
+	}
+	for _, seedling := range seedlings {
+		waitForStep(t, s, seedling.ID, pipeline.SeedlingStepComplete)
+	}
+	close(done)
+	wg.Wait()
+	for failure, n := range failures {
+		t.Errorf("%d times: %s", n, failure)
+	}
+	if requests == 0 {
+		t.Error("the API wasn't read during the builds")
+	}
+
+	// With the readers gone, the next checkpoint truncates the WAL.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Stat(path + "-wal")
+		if err != nil || info.Size() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the WAL is still %d bytes", info.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
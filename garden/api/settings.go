@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+// SettingChange is a change to a setting, recorded in setting_changes.
+type SettingChange struct {
+	ID        int64     `db:"id" json:"id"`
+	Key       string    `db:"key" json:"key"`
+	OldValue  float64   `db:"old_value" json:"oldValue"`
+	NewValue  float64   `db:"new_value" json:"newValue"`
+	ChangedBy string    `db:"changed_by" json:"changedBy"`
+	ChangedAt time.Time `db:"changed_at" json:"changedAt"`
+}
+
+// updateSettings validates and stores the values by key, recording each
+// change and who made it, and returns the settings with them.
+func (s *Server) updateSettings(ctx context.Context, values map[string]float64, by string) (pipeline.PipelineSettings, *ErrorBody, error) {
+	errs := fieldErrors{}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r, ok := pipeline.SettingRanges[key]
+		if !ok {
+			errs.add(key, FieldErrUnknown, "there's no setting "+key)
+		} else if reason := r.Check(values[key]); reason != "" {
+			errs.add(key, FieldErrInvalid, key+" "+reason)
+		}
+	}
+	if invalid := errs.body("settings are invalid"); invalid != nil {
+		return pipeline.PipelineSettings{}, invalid, nil
+	}
+
+	s.Settings.Updates.Lock()
+	defer s.Settings.Updates.Unlock()
+	old := s.Settings.Current().Values()
+	now := time.Now()
+	if err := s.InTx(ctx, func(tx *sqlx.Tx) error {
+		for _, key := range keys {
+			if values[key] == old[key] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `
+			 INSERT INTO settings (key, value, updated_by, updated_at) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (key) DO UPDATE
+			 SET value = excluded.value, updated_by = excluded.updated_by, updated_at = excluded.updated_at
+			 `, key, values[key], by, now); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+			 INSERT INTO setting_changes (key, old_value, new_value, changed_by, changed_at)
+			 VALUES ($1, $2, $3, $4, $5)
+			 `, key, old[key], values[key], by, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return pipeline.PipelineSettings{}, nil, err
+	}
+	return s.Settings.Set(values), nil, nil
+}
+
+// SettingsResponse is the settings, the values each may be set to and
+// their defaults.
+type SettingsResponse struct {
+	Settings pipeline.PipelineSettings        `json:"settings"`
+	Defaults pipeline.PipelineSettings        `json:"defaults"`
+	Ranges   map[string]pipeline.SettingRange `json:"ranges"`
+}
+
+func (s *Server) settingsResponse(w http.ResponseWriter, settings pipeline.PipelineSettings) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&SettingsResponse{
+		Settings: settings,
+		Defaults: s.Settings.Defaults,
+		Ranges:   pipeline.SettingRanges,
+	}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+func (s *Server) GetSettings(w http.ResponseWriter, r *http.Request) {
+	s.settingsResponse(w, s.Settings.Current())
+}
+
+// PutSettings changes the settings in the body, leaving the rest as they
+// are. Builds pick the new values up from their next attempt.
+func (s *Server) PutSettings(w http.ResponseWriter, r *http.Request) {
+	values := map[string]float64{}
+	invalid, err := decodeStrict(r.Body, &values)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+	by := pipeline.APIKeyFromContext(r.Context())
+	settings, invalid, err := s.updateSettings(r.Context(), values, by)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to update settings")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if invalid != nil {
+		s.respondInvalid(w, invalid)
+		return
+	}
+	pipeline.LoggerFromContext(r.Context()).WithField("updated_by", by).
+		WithField("settings", values).
+		Info("Updated settings")
+	s.settingsResponse(w, settings)
+}
+
+// GetSettingChanges lists the changes made to the settings, latest first.
+func (s *Server) GetSettingChanges(w http.ResponseWriter, r *http.Request) {
+	changes := []SettingChange{}
+	if err := s.Reads.SelectContext(r.Context(), &changes,
+		"SELECT * FROM setting_changes ORDER BY id DESC LIMIT 500"); err != nil {
+		s.log.WithField("error", err).Error("failed to get setting changes")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]SettingChange{"changes": changes}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
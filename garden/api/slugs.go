@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	// imageComponentRegex is a path component of an image name in Docker's
+	// reference grammar: lowercase letters and digits, separated by one
+	// dot, one or two underscores or any number of dashes.
+	imageComponentRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	// containerNameRegex is what docker allows container names to be.
+	containerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+	slugSeparatorRegex = regexp.MustCompile(`[._-]+`)
+)
+
+// normalizeSlug turns a cleaned name into one that's a valid image name
+// component, and so a valid git path, which can't then be "." or ".." or
+// start with ".git": separators are stripped from its ends, and runs of them
+// the grammar doesn't allow collapsed, dots to one, underscores to two and
+// mixed ones to a dash. It may return "" for a name with no letters or
+// digits.
+func normalizeSlug(slug string) string {
+	slug = strings.Trim(strings.ToLower(slug), "._-")
+	return slugSeparatorRegex.ReplaceAllStringFunc(slug, func(sep string) string {
+		switch {
+		case sep == "." || sep == "_" || sep == "__" || strings.Trim(sep, "-") == "":
+			return sep
+		case strings.Trim(sep, ".") == "":
+			return "."
+		case strings.Trim(sep, "_") == "":
+			return "__"
+		}
+		return "-"
+	})
+}
+
+// checkResourceName returns why the seedling's container and image can't be
+// named after it, if they can't.
+func (s *Server) checkResourceName(seedling store.Seedling) string {
+	name := s.ContainerName(seedling)
+	switch {
+	case seedling.Name == "":
+		return "name must have a letter or digit"
+	case !imageComponentRegex.MatchString(seedling.Name):
+		return fmt.Sprintf("name %q isn't a valid docker image name", seedling.Name)
+	case !imageComponentRegex.MatchString(name):
+		return fmt.Sprintf("image name %q isn't valid, check CONTAINER_PREFIX", name)
+	case !containerNameRegex.MatchString(name):
+		return fmt.Sprintf("container name %q must be at least 2 characters", name)
+	}
+	return ""
+}
+
+// InvalidName is a seedling created before names were normalized whose name
+// its container, image or repo can't be named after, with the name it
+// would be created with now if that's different.
+type InvalidName struct {
+	ID            hide.Int64 `json:"id"`
+	Name          string     `json:"name"`
+	Garden        string     `json:"garden"`
+	Reason        string     `json:"reason"`
+	SuggestedName string     `json:"suggestedName,omitempty"`
+}
+
+type InvalidNamesReport struct {
+	Seedlings []InvalidName `json:"seedlings"`
+}
+
+func (s *Server) invalidNames(ctx context.Context) (*InvalidNamesReport, error) {
+	seedlings := []store.Seedling{}
+	if err := s.Reads.SelectContext(ctx, &seedlings,
+		"SELECT id, name, garden FROM seedlings WHERE deleted_at IS NULL ORDER BY id"); err != nil {
+		return nil, err
+	}
+	report := &InvalidNamesReport{Seedlings: []InvalidName{}}
+	for _, seedling := range seedlings {
+		reason := s.checkResourceName(seedling)
+		if reason == "" {
+			continue
+		}
+		invalid := InvalidName{ID: seedling.ID, Name: seedling.Name, Garden: seedling.Garden, Reason: reason}
+		if suggested := normalizeSlug(pipeline.CleanFilePath(seedling.Name)); suggested != seedling.Name {
+			invalid.SuggestedName = suggested
+		}
+		report.Seedlings = append(report.Seedlings, invalid)
+	}
+	return report, nil
+}
+
+// InvalidNames lists the seedlings whose names their container, image or
+// repo can't be named after, so they can be recreated under valid ones.
+func (s *Server) InvalidNames(w http.ResponseWriter, r *http.Request) {
+	report, err := s.invalidNames(r.Context())
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to list invalid names")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
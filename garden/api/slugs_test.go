@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+func TestPrepareName(t *testing.T) {
+	tests := []struct {
+		name string
+		// want is the normalized name, and err the code of the error with
+		// it, if any.
+		want, err string
+	}{
+		{name: "echo", want: "echo"},
+		{name: "  echo  ", want: "echo"},
+		{name: "My Service", want: "my_service"},
+		{name: "Echo.V2", want: "echo.v2"},
+		{name: "foo..bar", want: "foo.bar"},
+		{name: "foo___bar", want: "foo__bar"},
+		{name: "foo--bar", want: "foo--bar"},
+		{name: "foo._-bar", want: "foo-bar"},
+		{name: "a - b", want: "a-b"},
+		{name: "echo-", want: "echo"},
+		{name: "9._", want: "9"},
+		{name: "", err: FieldErrRequired},
+		{name: "-echo", want: "echo", err: FieldErrCharset},
+		{name: "...", err: FieldErrCharset},
+		{name: "echo!", want: "echo", err: FieldErrCharset},
+		{name: strings.Repeat("a", SeedlingNameMaxLength+1), want: strings.Repeat("a", SeedlingNameMaxLength+1), err: FieldErrTooLong},
+	}
+	s := &Server{Pipeline: &pipeline.Pipeline{Config: pipeline.Config{ContainerPrefix: "garden-"}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A create's name and a rename are prepared the same way.
+			errs := fieldErrors{}
+			seedling := store.Seedling{Name: tt.name}
+			s.prepareName(&errs, &seedling)
+			if seedling.Name != tt.want {
+				t.Errorf("normalized to %q, want %q", seedling.Name, tt.want)
+			}
+			if requested := strings.TrimSpace(tt.name); seedling.Name != requested && seedling.RequestedName != requested {
+				t.Errorf("RequestedName is %q, want %q", seedling.RequestedName, requested)
+			}
+			code := ""
+			if len(errs) > 0 {
+				code = errs[0].Code
+			}
+			if code != tt.err || len(errs) > 1 {
+				t.Errorf("errors %+v, want one %q", errs, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckResourceName(t *testing.T) {
+	tests := []struct {
+		name, garden, prefix string
+		// want is in the reason the name is refused, or "" if it isn't.
+		want string
+	}{
+		{name: "echo", prefix: "garden-"},
+		{name: "echo", garden: "team", prefix: "garden-"},
+		{name: "x", prefix: "garden-"},
+		{name: "x", want: "at least 2 characters"},
+		{name: "", prefix: "garden-", want: "letter or digit"},
+		{name: "Echo", prefix: "garden-", want: "valid docker image name"},
+		{name: "my service", prefix: "garden-", want: "valid docker image name"},
+		{name: "echo", prefix: "Garden-", want: "CONTAINER_PREFIX"},
+		{name: "echo", prefix: "garden--.", want: "CONTAINER_PREFIX"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix+tt.name, func(t *testing.T) {
+			s := &Server{Pipeline: &pipeline.Pipeline{Config: pipeline.Config{ContainerPrefix: tt.prefix}}}
+			reason := s.checkResourceName(store.Seedling{Name: tt.name, Garden: tt.garden})
+			if tt.want == "" && reason != "" || !strings.Contains(reason, tt.want) {
+				t.Errorf("refused for %q, want %q", reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameSeedling(t *testing.T) {
+	s, env := testServer(t)
+	seedling := env.Seedling(t, "echo")
+	env.Seedling(t, "other")
+	path := pipeline.SeedlingPath(seedling.ID)
+	h := s.Routes()
+
+	rename := func(name string) (store.Seedling, *ErrorEnvelope, int) {
+		t.Helper()
+		w := serve(h, "GET", path, nil)
+		body, _ := json.Marshal(map[string]string{"name": name, "description": "echoes what it's sent back"})
+		req := newRequest("PUT", path, strings.NewReader(string(body)))
+		req.Header.Set("If-Match", w.Header().Get("ETag"))
+		w = serveRequest(h, req)
+		var got store.Seedling
+		var envelope *ErrorEnvelope
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			envelope = &ErrorEnvelope{}
+			if err := json.Unmarshal(w.Body.Bytes(), envelope); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return got, envelope, w.Code
+	}
+
+	got, _, code := rename("My Echo")
+	if code != http.StatusOK {
+		t.Fatalf("rename: %d", code)
+	}
+	if got.Name != "my_echo" || got.RequestedName != "My Echo" {
+		t.Errorf("renamed to %q, requested %q", got.Name, got.RequestedName)
+	}
+	var stored string
+	if err := s.DB.Get(&stored, "SELECT name FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+		t.Fatal(err)
+	}
+	if stored != "my_echo" {
+		t.Errorf("stored name is %q", stored)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		status int
+		code   string
+	}{
+		{"Other", http.StatusConflict, pipeline.ErrCodeConflict},
+		{"-echo", http.StatusUnprocessableEntity, pipeline.ErrCodeValidation},
+		{"", http.StatusUnprocessableEntity, pipeline.ErrCodeValidation},
+	} {
+		_, envelope, code := rename(tt.name)
+		if code != tt.status || envelope == nil || envelope.Error.Code != tt.code {
+			t.Errorf("rename to %q: %d %+v, want %d %s", tt.name, code, envelope, tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// MAX_STEP_SAMPLES is how many of the most recent completions of a step
+	// the rolling median is computed over.
+	MAX_STEP_SAMPLES = 50
+)
+
+var (
+	// defaultStepDurations are conservative estimates used until a step has
+	// completed at least once.
+	defaultStepDurations = map[string]time.Duration{
+		pipeline.SeedlingStepProtobufs:         3 * time.Minute,
+		pipeline.SeedlingStepServer:            15 * time.Minute,
+		pipeline.SeedlingStepServerTests:       8 * time.Minute,
+		pipeline.SeedlingStepOpenAPI:           3 * time.Minute,
+		pipeline.SeedlingStepDockerfile:        15 * time.Minute,
+		pipeline.SeedlingStepExampleClientCall: 3 * time.Minute,
+	}
+)
+
+type StepStat struct {
+	Step           string  `json:"step"`
+	Samples        int     `json:"samples"`
+	MedianSeconds  float64 `json:"medianSeconds"`
+	DefaultSeconds float64 `json:"defaultSeconds"`
+	// AutoFixedAttempts counts successful attempts that pre-build hooks
+	// fixed, each of which would otherwise have cost another attempt.
+	AutoFixedAttempts int `json:"autoFixedAttempts"`
+}
+
+// stepDurations returns the total time each seedling spent in each step it
+// completed, most recent first, grouped by step.
+func (s *Server) stepDurations(ctx context.Context) (map[string][]time.Duration, error) {
+	rows := []struct {
+		Step       string `db:"step"`
+		DurationMS int64  `db:"duration_ms"`
+	}{}
+	if err := s.Reads.SelectContext(ctx, &rows, `
+	 SELECT step, SUM(duration_ms) AS duration_ms
+	 FROM seedling_attempts
+	 GROUP BY seedling_id, step
+	 HAVING MAX(success) = 1
+	 ORDER BY MAX(created_at) DESC
+	 `); err != nil {
+		return nil, err
+	}
+
+	durations := map[string][]time.Duration{}
+	for _, row := range rows {
+		if len(durations[row.Step]) >= MAX_STEP_SAMPLES {
+			continue
+		}
+		durations[row.Step] = append(durations[row.Step], time.Duration(row.DurationMS)*time.Millisecond)
+	}
+	return durations, nil
+}
+
+func median(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// stepEstimates returns the expected duration of each step, falling back to
+// defaultStepDurations for steps without history.
+func (s *Server) stepEstimates(ctx context.Context) (map[string]time.Duration, error) {
+	durations, err := s.stepDurations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	estimates := map[string]time.Duration{}
+	for step, def := range defaultStepDurations {
+		estimates[step] = def
+		if m := median(durations[step]); m > 0 {
+			estimates[step] = m
+		}
+	}
+	return estimates, nil
+}
+
+// attachETAs sets ETASeconds on each seedling: the expected time left in the
+// current step plus the expected duration of every step after it.
+func (s *Server) attachETAs(ctx context.Context, seedlings []*store.Seedling) error {
+	estimates, err := s.stepEstimates(ctx)
+	if err != nil {
+		return err
+	}
+	for _, seedling := range seedlings {
+		var eta time.Duration
+		current := false
+		for _, step := range pipeline.SeedlingSteps(*seedling) {
+			if step == seedling.Step {
+				current = true
+				remaining := estimates[step]
+				if seedling.StepStartedAt != nil {
+					remaining -= time.Since(*seedling.StepStartedAt)
+				}
+				if remaining > 0 {
+					eta += remaining
+				}
+				continue
+			}
+			if current {
+				eta += estimates[step]
+			}
+		}
+		seedling.ETASeconds = int64(eta.Seconds())
+	}
+	return nil
+}
+
+// StepStats returns the historical median duration of each pipeline step.
+func (s *Server) StepStats(w http.ResponseWriter, r *http.Request) {
+	durations, err := s.stepDurations(r.Context())
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get step durations")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	autoFixed := []struct {
+		Step  string `db:"step"`
+		Count int    `db:"count"`
+	}{}
+	if err := s.Reads.SelectContext(r.Context(), &autoFixed, `
+	 SELECT step, COUNT(*) AS count FROM seedling_attempts
+	 WHERE success AND auto_fixes != ''
+	 GROUP BY step
+	 `); err != nil {
+		s.log.WithField("error", err).Error("failed to count auto-fixed attempts")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	autoFixedByStep := map[string]int{}
+	for _, row := range autoFixed {
+		autoFixedByStep[row.Step] = row.Count
+	}
+
+	stats := []StepStat{}
+	for _, step := range pipeline.SeedlingSteps(store.Seedling{}) {
+		def, ok := defaultStepDurations[step]
+		if !ok {
+			continue
+		}
+		stats = append(stats, StepStat{
+			Step:           step,
+			Samples:        len(durations[step]),
+			MedianSeconds:  median(durations[step]).Seconds(),
+			DefaultSeconds: def.Seconds(),
+
+			AutoFixedAttempts: autoFixedByStep[step],
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&stats); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// stepStatuses returns the statuses of the seedling's steps in pipeline
+// order, with the pending ones waiting on a step that's being fixed or
+// failed reported as blocked.
+func (s *Server) stepStatuses(ctx context.Context, seedling store.Seedling) ([]store.SeedlingStepStatus, error) {
+	rows := []store.SeedlingStepStatus{}
+	if err := s.Reads.SelectContext(ctx, &rows,
+		"SELECT * FROM seedling_step_statuses WHERE seedling_id = $1", seedling.ID); err != nil {
+		return nil, err
+	}
+	byStep := map[string]store.SeedlingStepStatus{}
+	for _, row := range rows {
+		byStep[row.Step] = row
+	}
+	steps := pipeline.SeedlingSteps(seedling)
+	deps := pipeline.StepDeps(steps)
+	statuses := []store.SeedlingStepStatus{}
+	status := map[string]string{}
+	for _, step := range steps {
+		row, ok := byStep[step]
+		if !ok {
+			continue
+		}
+		if row.Status == pipeline.StepStatusPending {
+			for _, dep := range deps[step] {
+				switch status[dep] {
+				case pipeline.StepStatusFixing, pipeline.StepStatusFailed, pipeline.StepStatusBlocked:
+					row.Status = pipeline.StepStatusBlocked
+				}
+			}
+		}
+		status[step] = row.Status
+		statuses = append(statuses, row)
+	}
+	return statuses, nil
+}
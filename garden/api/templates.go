@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+	templateNameRegex  = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	templateParamRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// ListTemplates returns the built-in templates followed by custom ones.
+func (s *Server) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	custom := []pipeline.Template{}
+	if err := s.DB.SelectContext(r.Context(), &custom, "SELECT * FROM templates ORDER BY name"); err != nil {
+		s.log.WithField("error", err).Error("failed to get templates")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	templates := []pipeline.Template{}
+	for _, t := range pipeline.BuiltinTemplates {
+		t.Builtin = true
+		templates = append(templates, t)
+	}
+	for _, t := range custom {
+		t.Params = pipeline.SplitParams(t.ParamList)
+		templates = append(templates, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&templates); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// CreateTemplate registers a custom template.
+func (s *Server) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var t pipeline.Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if !templateNameRegex.MatchString(t.Name) {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+			"name must be lowercase letters, digits and dashes", nil)
+		return
+	}
+	if t.ProtoSkeleton == "" && t.ServerHints == "" && t.QualityRules == "" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+			"one of protoSkeleton, serverHints or qualityRules is required", nil)
+		return
+	}
+	if t.Params == nil {
+		t.Params = []string{}
+	}
+	for _, param := range t.Params {
+		if !templateParamRegex.MatchString(param) || param == "name" || param == "description" {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, fmt.Sprintf("invalid param %q", param), nil)
+			return
+		}
+	}
+	// Rendering with placeholder params catches syntax errors and
+	// references to params that aren't declared.
+	placeholders := store.TemplateParams{}
+	for _, param := range t.Params {
+		placeholders[param] = param
+	}
+	if _, err := t.Render(store.Seedling{SeedlingTemplate: store.SeedlingTemplate{TemplateParams: placeholders}}); err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid template: "+err.Error(), nil)
+		return
+	}
+	if pipeline.BuiltinTemplate(t.Name) != nil {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "a built-in template has that name", nil)
+		return
+	}
+	t.ParamList = strings.Join(t.Params, ",")
+	t.Builtin = false
+	t.CreatedAt = time.Now()
+
+	result, err := s.DB.NamedExecContext(r.Context(), `
+	 INSERT INTO templates (name, description, params, proto_skeleton, server_hints, quality_rules, created_at)
+	 VALUES (:name, :description, :params, :proto_skeleton, :server_hints, :quality_rules, :created_at)
+	 ON CONFLICT (name) DO NOTHING
+	 `, &t)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to insert template")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		s.respondError(w, http.StatusConflict, pipeline.ErrCodeConflict, "template already exists", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(&t); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
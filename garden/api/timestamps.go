@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// bogusTimestamp is before any seedling existed. Seedlings created before
+// insertSeedling set their timestamps were stored with zero ones.
+var bogusTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// backfillTimestamps gives seedlings stored with zero timestamps the best
+// ones their history has: the first and last commits to their repo,
+// else its mtime, else their first attempt or event.
+func (s *Server) backfillTimestamps(ctx context.Context) error {
+	seedlings := []store.Seedling{}
+	if err := s.DB.SelectContext(ctx, &seedlings,
+		"SELECT * FROM seedlings WHERE created_at < $1 OR modified_at < $1", bogusTimestamp); err != nil {
+		return err
+	}
+	fixed := 0
+	for _, seedling := range seedlings {
+		created, modified := seedling.CreatedAt, seedling.ModifiedAt
+		first, last := repoCommitTimes(ctx, s.RepoDir(seedling))
+		if created.Before(bogusTimestamp) {
+			created = first
+			if created.IsZero() {
+				if fi, err := os.Stat(s.RepoDir(seedling)); err == nil {
+					created = fi.ModTime()
+				}
+			}
+			if created.IsZero() {
+				t, err := s.earliestActivity(ctx, seedling.ID)
+				if err != nil {
+					return err
+				}
+				created = t
+			}
+		}
+		if modified.Before(bogusTimestamp) {
+			modified = last
+			if modified.Before(created) {
+				modified = created
+			}
+		}
+		if created.Before(bogusTimestamp) {
+			s.log.WithField("name", seedling.Name).Warn("no history to backfill seedling timestamps from")
+			continue
+		}
+		if _, err := s.DB.ExecContext(ctx,
+			"UPDATE seedlings SET created_at = $1, modified_at = $2 WHERE id = $3",
+			created, modified, seedling.ID); err != nil {
+			return err
+		}
+		fixed++
+	}
+	if fixed > 0 {
+		s.log.WithField("seedlings", fixed).Info("Backfilled seedling timestamps")
+	}
+	return nil
+}
+
+// repoCommitTimes returns the times of the first and last commits touching
+// dir, zero if it has none. Seedlings in the shared repo only count the
+// commits to their own directory.
+func repoCommitTimes(ctx context.Context, dir string) (first, last time.Time) {
+	cmd := exec.CommandContext(ctx, "git", "log", "--format=%cI", "--", ".")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return time.Time{}, time.Time{}
+	}
+	lines := strings.Fields(string(out))
+	if len(lines) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	// git log lists the newest commit first.
+	last, _ = time.Parse(time.RFC3339, lines[0])
+	first, _ = time.Parse(time.RFC3339, lines[len(lines)-1])
+	return first, last
+}
+
+// earliestActivity is when the seedling's first attempt or event was
+// recorded, zero if it has neither.
+func (s *Server) earliestActivity(ctx context.Context, id hide.Int64) (time.Time, error) {
+	var earliest time.Time
+	for _, query := range []string{
+		"SELECT created_at FROM seedling_attempts WHERE seedling_id = $1 ORDER BY created_at LIMIT 1",
+		"SELECT created_at FROM seedling_events WHERE seedling_id = $1 ORDER BY created_at LIMIT 1",
+	} {
+		var t time.Time
+		err := s.DB.GetContext(ctx, &t, query, id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest, nil
+}
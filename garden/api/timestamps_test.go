@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// createSeedling creates a seedling waiting for approval of its plan, so
+// nothing builds it.
+func createSeedling(t *testing.T, h http.Handler, name string) store.Seedling {
+	t.Helper()
+	body := fmt.Sprintf(`{"name": %q, "description": "echoes what it's sent", "plan": {
+		"summary": "An echo service.",
+		"rpcs": [{"name": "Say", "request": "SayRequest", "response": "SayReply", "description": "returns the text it's sent"}]
+	}}`, name)
+	w := serve(h, "POST", "/api/v1/seedlings", strings.NewReader(body))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("create %s: %d %s", name, w.Code, w.Body)
+	}
+	var created store.Seedling
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+// listNames is the names of the seedlings the list at target has, in order.
+func listNames(t *testing.T, h http.Handler, target string) string {
+	t.Helper()
+	w := serve(h, "GET", target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", target, w.Code, w.Body)
+	}
+	var list []store.Seedling
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, seedling := range list {
+		names = append(names, seedling.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestSeedlingTimestamps(t *testing.T) {
+	s, _ := testServer(t)
+	h := s.Routes()
+	ctx := context.Background()
+
+	start := time.Now()
+	var created []store.Seedling
+	for _, name := range []string{"first", "second", "third"} {
+		created = append(created, createSeedling(t, h, name))
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, seedling := range created {
+		if seedling.CreatedAt.Before(start) || !seedling.ModifiedAt.Equal(seedling.CreatedAt) {
+			t.Errorf("%s created at %s, modified at %s", seedling.Name, seedling.CreatedAt, seedling.ModifiedAt)
+		}
+		// What's stored, and what's read back and encoded for the API
+		// under the seedling's hidden ID, are the times the create had.
+		var stored store.Seedling
+		if err := s.DB.GetContext(ctx, &stored, "SELECT * FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+			t.Fatal(err)
+		}
+		w := serve(h, "GET", pipeline.SeedlingPath(seedling.ID), nil)
+		var got store.Seedling
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != seedling.ID || !got.CreatedAt.Equal(seedling.CreatedAt) || !got.ModifiedAt.Equal(seedling.ModifiedAt) {
+			t.Errorf("GET %s: %+v, created %+v", seedling.Name, got.DBRow, seedling.DBRow)
+		}
+		if !stored.CreatedAt.Equal(seedling.CreatedAt) {
+			t.Errorf("%s stored created at %s, want %s", seedling.Name, stored.CreatedAt, seedling.CreatedAt)
+		}
+	}
+	if got := listNames(t, h, "/api/v1/seedlings"); got != "third second first" {
+		t.Errorf("newest first: %s", got)
+	}
+	if got := listNames(t, h, "/api/v1/seedlings?order=asc"); got != "first second third" {
+		t.Errorf("oldest first: %s", got)
+	}
+
+	// A step transition, which doesn't set modified_at, bumps it, to the
+	// millisecond the trigger has.
+	before := time.Now().Truncate(time.Millisecond)
+	if _, err := s.DB.ExecContext(ctx, "UPDATE seedlings SET step = $1 WHERE id = $2",
+		pipeline.SeedlingStepProtobufs, created[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	var moved store.Seedling
+	if err := s.DB.GetContext(ctx, &moved, "SELECT * FROM seedlings WHERE id = $1", created[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if moved.ModifiedAt.Before(before) || !moved.CreatedAt.Equal(created[0].CreatedAt) {
+		t.Errorf("moved to protobufs: created at %s, modified at %s", moved.CreatedAt, moved.ModifiedAt)
+	}
+	if got := listNames(t, h, "/api/v1/seedlings?sort=modifiedAt"); got != "first third second" {
+		t.Errorf("most recently modified first: %s", got)
+	}
+
+	// An update setting modified_at itself keeps what it set.
+	set := time.Date(2023, 4, 22, 10, 0, 0, 0, time.UTC)
+	if _, err := s.DB.ExecContext(ctx, "UPDATE seedlings SET step = $1, modified_at = $2 WHERE id = $3",
+		pipeline.SeedlingStepServer, set, created[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.GetContext(ctx, &moved, "SELECT * FROM seedlings WHERE id = $1", created[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if !moved.ModifiedAt.Equal(set) {
+		t.Errorf("modified at %s, want %s", moved.ModifiedAt, set)
+	}
+
+	// So does a PATCH, through the API.
+	w := serve(h, "GET", pipeline.SeedlingPath(created[2].ID), nil)
+	req := newRequest("PATCH", pipeline.SeedlingPath(created[2].ID), strings.NewReader(`{"description": "echoes what it's sent, loudly"}`))
+	req.Header.Set("If-Match", w.Header().Get("ETag"))
+	before = time.Now()
+	if w := serveRequest(h, req); w.Code != http.StatusOK {
+		t.Fatalf("patch: %d %s", w.Code, w.Body)
+	}
+	w = serve(h, "GET", pipeline.SeedlingPath(created[2].ID), nil)
+	var patched store.Seedling
+	if err := json.Unmarshal(w.Body.Bytes(), &patched); err != nil {
+		t.Fatal(err)
+	}
+	if patched.ModifiedAt.Before(before) || !patched.CreatedAt.Equal(created[2].CreatedAt) {
+		t.Errorf("patched: created at %s, modified at %s", patched.CreatedAt, patched.ModifiedAt)
+	}
+}
+
+// commitAt commits a file named for the message to dir as of when.
+func commitAt(t *testing.T, dir string, when time.Time, message string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, message+".txt"), []byte(message), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", "git add -A && git commit -qm \"$0\"", message)
+	cmd.Dir = dir
+	date := when.Format(time.RFC3339)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git commit: %v: %s", err, out)
+	}
+}
+
+func TestBackfillTimestamps(t *testing.T) {
+	s, env := testServer(t)
+	ctx := context.Background()
+	zero := func(seedling store.Seedling) {
+		t.Helper()
+		if _, err := s.DB.ExecContext(ctx, "UPDATE seedlings SET created_at = $1, modified_at = $1 WHERE id = $2",
+			time.Time{}, seedling.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A seedling with a repo of its own gets its first and last commits.
+	committed := env.Seedling(t, "committed")
+	dir := s.RepoDir(committed)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	first := time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)
+	last := time.Date(2023, 3, 2, 17, 30, 0, 0, time.UTC)
+	commitAt(t, dir, first, "first")
+	commitAt(t, dir, last, "last")
+	zero(committed)
+
+	// One whose directory has no commits gets its mtime.
+	uncommitted := env.Seedling(t, "uncommitted")
+	dir = s.RepoDir(uncommitted)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2023, 1, 15, 8, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	zero(uncommitted)
+
+	// One without a repo gets its first attempt or event.
+	attempted := env.Seedling(t, "attempted")
+	if err := os.RemoveAll(s.RepoDir(attempted)); err != nil {
+		t.Fatal(err)
+	}
+	attemptAt := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := s.DB.ExecContext(ctx,
+		"INSERT INTO seedling_attempts (seedling_id, step, attempt, success, output, created_at) VALUES ($1, $2, 1, FALSE, '', $3), ($1, $2, 2, TRUE, '', $4)",
+		attempted.ID, pipeline.SeedlingStepProtobufs, attemptAt, attemptAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	zero(attempted)
+
+	// One with neither is left as it is.
+	lost := env.Seedling(t, "lost")
+	if err := os.RemoveAll(s.RepoDir(lost)); err != nil {
+		t.Fatal(err)
+	}
+	zero(lost)
+
+	// One that was stored right isn't touched.
+	fine := env.Seedling(t, "fine")
+
+	if err := s.backfillTimestamps(ctx); err != nil {
+		t.Fatal(err)
+	}
+	get := func(seedling store.Seedling) store.Seedling {
+		t.Helper()
+		var got store.Seedling
+		if err := s.DB.GetContext(ctx, &got, "SELECT * FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := get(committed); !got.CreatedAt.Equal(first) || !got.ModifiedAt.Equal(last) {
+		t.Errorf("committed: created at %s, modified at %s, want %s and %s", got.CreatedAt, got.ModifiedAt, first, last)
+	}
+	if got := get(uncommitted); !got.CreatedAt.Equal(mtime) || !got.ModifiedAt.Equal(mtime) {
+		t.Errorf("uncommitted: created at %s, modified at %s, want %s", got.CreatedAt, got.ModifiedAt, mtime)
+	}
+	if got := get(attempted); !got.CreatedAt.Equal(attemptAt) || !got.ModifiedAt.Equal(attemptAt) {
+		t.Errorf("attempted: created at %s, modified at %s, want %s", got.CreatedAt, got.ModifiedAt, attemptAt)
+	}
+	if got := get(lost); !got.CreatedAt.Before(bogusTimestamp) {
+		t.Errorf("lost: created at %s", got.CreatedAt)
+	}
+	if got := get(fine); !got.CreatedAt.Equal(fine.CreatedAt) || !got.ModifiedAt.Equal(fine.ModifiedAt) {
+		t.Errorf("fine: created at %s, modified at %s, want %s and %s", got.CreatedAt, got.ModifiedAt, fine.CreatedAt, fine.ModifiedAt)
+	}
+	// Running it again changes nothing.
+	if err := s.backfillTimestamps(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(committed); !got.ModifiedAt.Equal(last) {
+		t.Errorf("committed: modified at %s after a second backfill", got.ModifiedAt)
+	}
+}
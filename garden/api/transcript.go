@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// The kinds of TranscriptEntry.
+const (
+	TranscriptMessage = "message"
+	TranscriptAttempt = "attempt"
+)
+
+var backtickRunRegex = regexp.MustCompile("`{3,}")
+
+// TranscriptEntry is one turn of a seedling's build conversation, or the
+// build output of one attempt.
+type TranscriptEntry struct {
+	Kind string `json:"kind"`
+	Step string `json:"step"`
+	// Role is a message's.
+	Role string `json:"role,omitempty"`
+	// Attempt, Success, File and Model are an attempt's.
+	Attempt   int       `json:"attempt,omitempty"`
+	Success   *bool     `json:"success,omitempty"`
+	File      string    `json:"file,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Transcript is everything a seedling was prompted with and answered, and
+// what its attempts built with, with secrets redacted.
+type Transcript struct {
+	ID            hide.Int64        `json:"id"`
+	Name          string            `json:"name"`
+	Garden        string            `json:"garden"`
+	Description   string            `json:"description"`
+	Step          string            `json:"step"`
+	FailureReason string            `json:"failureReason,omitempty"`
+	Entries       []TranscriptEntry `json:"entries"`
+	// TraceID and TraceURL are the seedling's last build's.
+	TraceID  string `json:"traceId,omitempty"`
+	TraceURL string `json:"traceUrl,omitempty"`
+	// Redactions is how many secrets were masked.
+	Redactions  int       `json:"redactions"`
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+// transcript assembles the seedling's messages and attempts in the order
+// they happened and redacts them.
+func (s *Server) transcript(ctx context.Context, seedling store.Seedling) (*Transcript, error) {
+	messages := []pipeline.SeedlingMessage{}
+	if err := s.Reads.SelectContext(ctx, &messages,
+		"SELECT * FROM seedling_messages WHERE seedling_id = $1 ORDER BY id", seedling.ID); err != nil {
+		return nil, err
+	}
+	attempts := []store.Attempt{}
+	if err := s.Reads.SelectContext(ctx, &attempts,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 ORDER BY id", seedling.ID); err != nil {
+		return nil, err
+	}
+	names, err := s.SecretNames(ctx, seedling.ID)
+	if err != nil {
+		return nil, err
+	}
+	red, err := s.SeedlingRedactor(seedling, names)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TranscriptEntry, 0, len(messages)+len(attempts))
+	for _, m := range messages {
+		entries = append(entries, TranscriptEntry{
+			Kind:      TranscriptMessage,
+			Step:      m.Step,
+			Role:      m.Role,
+			Content:   red.Redact(m.Content),
+			CreatedAt: m.CreatedAt,
+		})
+	}
+	for _, a := range attempts {
+		success := a.Success
+		entries = append(entries, TranscriptEntry{
+			Kind:      TranscriptAttempt,
+			Step:      a.Step,
+			Attempt:   a.Attempt,
+			Success:   &success,
+			File:      a.File,
+			Model:     a.Model,
+			Content:   red.Redact(a.Output),
+			CreatedAt: a.CreatedAt,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	t := &Transcript{
+		ID:            seedling.ID,
+		Name:          seedling.Name,
+		Garden:        seedling.GardenName(),
+		Description:   red.Redact(seedling.Description),
+		Step:          seedling.Step,
+		FailureReason: red.Redact(seedling.FailureReason),
+		TraceID:       seedling.TraceID,
+		TraceURL:      s.TraceURL(seedling.TraceID),
+		Entries:       entries,
+		GeneratedAt:   time.Now(),
+	}
+	t.Redactions = red.Count
+	return t, nil
+}
+
+// fence is a code fence longer than any run of backticks in content, so
+// content can't close it.
+func fence(content string) string {
+	n := 3
+	for _, run := range backtickRunRegex.FindAllString(content, -1) {
+		if len(run) >= n {
+			n = len(run) + 1
+		}
+	}
+	return strings.Repeat("`", n)
+}
+
+func renderTranscript(t *Transcript) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n%s\n\n", t.Name, t.Description)
+	fmt.Fprintf(&b, "- Garden: %s\n- Step: %s\n", t.Garden, t.Step)
+	if t.FailureReason != "" {
+		fmt.Fprintf(&b, "- Failure: %s\n", t.FailureReason)
+	}
+	if t.TraceURL != "" {
+		fmt.Fprintf(&b, "- Trace: [%s](%s)\n", t.TraceID, t.TraceURL)
+	} else if t.TraceID != "" {
+		fmt.Fprintf(&b, "- Trace: %s\n", t.TraceID)
+	}
+	fmt.Fprintf(&b, "- Redactions: %d\n- Generated: %s\n", t.Redactions, t.GeneratedAt.UTC().Format(time.RFC3339))
+
+	step := ""
+	for _, e := range t.Entries {
+		if e.Step != step {
+			step = e.Step
+			fmt.Fprintf(&b, "\n## %s\n", step)
+		}
+		switch e.Kind {
+		case TranscriptMessage:
+			fmt.Fprintf(&b, "\n### %s\n\n", e.Role)
+		default:
+			result := "failed"
+			if e.Success != nil && *e.Success {
+				result = "succeeded"
+			}
+			fmt.Fprintf(&b, "\n### Attempt %d %s", e.Attempt, result)
+			if e.File != "" {
+				fmt.Fprintf(&b, ": `%s`", e.File)
+			}
+			if e.Model != "" {
+				fmt.Fprintf(&b, " (%s)", e.Model)
+			}
+			b.WriteString("\n\n")
+		}
+		content := strings.TrimRight(e.Content, "\n")
+		f := fence(content)
+		fmt.Fprintf(&b, "%s\n%s\n%s\n", f, content, f)
+	}
+	return b.String()
+}
+
+// SeedlingTranscript returns the seedling's build conversation and attempt
+// outputs as one document, ?format=markdown (the default) or json, with
+// the seedling's secrets, garden's keys and anything matching the redact
+// patterns masked.
+func (s *Server) SeedlingTranscript(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "markdown"
+	}
+	if format != "markdown" && format != "json" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "format must be markdown or json", nil)
+		return
+	}
+	seedling, ok := s.lookupSeedling(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := s.transcript(r.Context(), seedling)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to assemble transcript")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", seedling.ResourceName()+"-transcript.json"))
+		if err := json.NewEncoder(w).Encode(t); err != nil {
+			s.log.WithField("error", err).Error("failed to encode response")
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", seedling.ResourceName()+"-transcript.md"))
+	w.Write([]byte(renderTranscript(t)))
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// SeedlingNameMaxLength keeps names, and the container and image names
+	// made from them, within docker's limits.
+	SeedlingNameMaxLength = 63
+	// MinDescriptionLength is the shortest description a seedling can be
+	// created or updated with, in characters.
+	MinDescriptionLength = 10
+)
+
+// The codes of FieldErrors.
+const (
+	FieldErrRequired = "required"
+	FieldErrType     = "type"
+	FieldErrUnknown  = "unknown_field"
+	FieldErrTooShort = "too_short"
+	FieldErrTooLong  = "too_long"
+	FieldErrCharset  = "charset"
+	FieldErrNotFound = "not_found"
+	FieldErrInvalid  = "invalid"
+)
+
+// seedlingNameRegex is what a name may be before it's cleaned: spaces become
+// underscores and the rest is lowercased.
+var seedlingNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _.-]*$`)
+
+// FieldError is one problem with one field of a request body. Request bodies
+// with any are rejected with a 422 listing all of them.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, code, message string) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: message})
+}
+
+// body is the errors as the body of a 422, or nil if there are none.
+func (e fieldErrors) body(message string) *ErrorBody {
+	if len(e) == 0 {
+		return nil
+	}
+	return &ErrorBody{Code: pipeline.ErrCodeValidation, Message: message, Details: []FieldError(e)}
+}
+
+// respondInvalid writes a 422 with the field errors in invalid.
+func (s *Server) respondInvalid(w http.ResponseWriter, invalid *ErrorBody) {
+	s.respondError(w, http.StatusUnprocessableEntity, invalid.Code, invalid.Message, invalid.Details)
+}
+
+// decodeStrict decodes a JSON request body into v, rejecting fields v doesn't
+// have. A field of the wrong type or an unknown one is returned as a 422
+// body; a body that isn't JSON at all as an error.
+func decodeStrict(r io.Reader, v interface{}) (*ErrorBody, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		return nil, nil
+	}
+	errs := fieldErrors{}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		errs.add(field, FieldErrType, fmt.Sprintf("%s must be %s, not %s", field, jsonTypeName(typeErr.Type), typeErr.Value))
+		return errs.body("request body is invalid"), nil
+	}
+	// encoding/json has no type for these.
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field, uerr := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		if uerr != nil {
+			field = strings.TrimPrefix(err.Error(), "json: unknown field ")
+		}
+		errs.add(field, FieldErrUnknown, fmt.Sprintf("unknown field %q", field))
+		return errs.body("request body is invalid"), nil
+	}
+	return nil, err
+}
+
+// jsonTypeName is how a Go type appears in JSON, for type errors.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// checkSeedlingName adds the problems with a name as it was sent, before
+// it's cleaned.
+func checkSeedlingName(errs *fieldErrors, name string) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		errs.add("name", FieldErrRequired, "name is required")
+	case len(name) > SeedlingNameMaxLength:
+		errs.add("name", FieldErrTooLong, fmt.Sprintf("name must be at most %d characters", SeedlingNameMaxLength))
+	case !seedlingNameRegex.MatchString(name):
+		errs.add("name", FieldErrCharset,
+			"name must start with a letter or digit and have only letters, digits, spaces, '_', '.' and '-'")
+	}
+}
+
+// checkDescription adds the problems with a description.
+func checkDescription(errs *fieldErrors, description string) {
+	description = strings.TrimSpace(description)
+	switch {
+	case description == "":
+		errs.add("description", FieldErrRequired, "description is required")
+	case len(description) < MinDescriptionLength:
+		errs.add("description", FieldErrTooShort,
+			fmt.Sprintf("description must be at least %d characters", MinDescriptionLength))
+	}
+}
+
+// seedlingSchema is the JSON Schema of the body CreateSeedling accepts, with
+// the limits it's validated against.
+func (s *Server) seedlingSchema() map[string]interface{} {
+	str := func(description string) map[string]interface{} {
+		return map[string]interface{}{"type": "string", "description": description}
+	}
+	port := map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 65535}
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  "/api/v1/schema/seedling",
+		"title":                "Seedling",
+		"type":                 "object",
+		"required":             []string{"name", "description"},
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"minLength":   1,
+				"maxLength":   SeedlingNameMaxLength,
+				"pattern":     seedlingNameRegex.String(),
+				"description": "Spaces become underscores, letters are lowercased and separators are trimmed from the ends and collapsed; creates return the original as requestedName. Unique within the garden.",
+			},
+			"garden": map[string]interface{}{
+				"type":    "string",
+				"pattern": gardenNameRegex.String(),
+				"default": store.DefaultGarden,
+			},
+			"description": map[string]interface{}{
+				"type":        "string",
+				"minLength":   MinDescriptionLength,
+				"description": fmt.Sprintf("What the service does. At least %d words to build without reviewing a plan.", minDescriptionWords),
+			},
+			"autoApprove": map[string]interface{}{"type": "boolean", "default": false},
+			"skipTests":   map[string]interface{}{"type": "boolean", "default": false},
+			"platform":    str("os/arch to build for, the host's by default"),
+			"tags": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string", "pattern": tagRegex.String()},
+			},
+			"template": str("name of a built-in or custom template, see /api/v1/templates"),
+			"templateParams": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+			"plan": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"summary", "rpcs"},
+				"properties": map[string]interface{}{
+					"summary": map[string]interface{}{"type": "string", "minLength": 1},
+					"entities": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"name":   map[string]interface{}{"type": "string"},
+								"fields": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+							},
+						},
+					},
+					"rpcs": map[string]interface{}{
+						"type":     "array",
+						"minItems": 1,
+						"items": map[string]interface{}{
+							"type":     "object",
+							"required": []string{"name"},
+							"properties": map[string]interface{}{
+								"name":        map[string]interface{}{"type": "string", "minLength": 1},
+								"request":     map[string]interface{}{"type": "string"},
+								"response":    map[string]interface{}{"type": "string"},
+								"description": map[string]interface{}{"type": "string"},
+							},
+						},
+					},
+					"notes": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				},
+			},
+			"git": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"remoteUrl":      str("an https URL, GIT_REMOTE_URL's if unset"),
+					"branch":         str("the configured default branch if unset"),
+					"pushOnComplete": map[string]interface{}{"type": "boolean"},
+				},
+			},
+			"toolchain": map[string]interface{}{
+				"type":        "string",
+				"enum":        s.Config.GoToolchains,
+				"default":     s.Config.GoToolchain,
+				"description": "Go version the seedling is built with",
+			},
+			"model":       str("the model every step is written with, instead of each step's configured one"),
+			"temperature": map[string]interface{}{"type": "number", "minimum": 0, "maximum": store.MaxTemperature},
+			"maxTokens":   map[string]interface{}{"type": "integer", "minimum": 1, "maximum": s.Config.MaxTokensLimit},
+			"resources": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"memory":        map[string]interface{}{"type": "string", "pattern": "^[0-9]+[bkmgBKMG]?$"},
+					"cpus":          str("a positive number such as 0.5 or 2"),
+					"pidsLimit":     map[string]interface{}{"type": "integer", "minimum": 1, "maximum": s.Config.ContainerMaxPidsLimit},
+					"restartPolicy": map[string]interface{}{"type": "string", "pattern": restartPolicyRegex.String()},
+				},
+			},
+			"ports": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"grpc": port,
+					"http": port,
+				},
+			},
+			"rebuildSchedule": str("cron schedule in UTC, @hourly, @daily, @weekly, @monthly or @every <duration>, to rebuild the complete seedling's code on"),
+			"autoHeal":        map[string]interface{}{"type": "boolean", "default": false, "description": "refine the seedling when a scheduled rebuild fails"},
+			"approvalRequired": map[string]interface{}{
+				"type":        []string{"array", "null"},
+				"items":       map[string]interface{}{"type": "string", "enum": approvalSteps},
+				"description": "steps whose generated code must be approved before the build goes on, the garden's if unset",
+			},
+		},
+	}
+}
+
+// SeedlingSchema returns the JSON Schema of a seedling create request, for
+// frontends to generate forms from.
+func (s *Server) SeedlingSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	if err := json.NewEncoder(w).Encode(s.seedlingSchema()); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// SeedlingVersion is the details of a precondition error: the seedling's
+// current version, to refetch it at and retry the update with.
+type SeedlingVersion struct {
+	Version int64  `json:"version"`
+	ETag    string `json:"etag"`
+}
+
+// seedlingETag is the ETag of the seedling at the version.
+func seedlingETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatches reports whether the If-Match header lists the ETag, or is "*".
+// Weak ETags never match, as If-Match compares them strongly.
+func ifMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns whether the update is to the seedling's current
+// version, or responds that it isn't. The update itself must still only be
+// made at that version, since the seedling may change in between.
+func (s *Server) checkIfMatch(w http.ResponseWriter, r *http.Request, seedling store.Seedling) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		s.respondVersion(w, http.StatusPreconditionRequired, pipeline.ErrCodePreconditionRequired,
+			"If-Match with the seedling's ETag is required", seedling.Version)
+		return false
+	}
+	if !ifMatches(header, seedlingETag(seedling.Version)) {
+		s.respondVersionMismatch(w, seedling.Version)
+		return false
+	}
+	return true
+}
+
+// respondVersionMismatch responds that the seedling changed since the
+// version the client updated.
+func (s *Server) respondVersionMismatch(w http.ResponseWriter, version int64) {
+	s.respondVersion(w, http.StatusPreconditionFailed, pipeline.ErrCodePreconditionFailed,
+		"seedling was modified, refetch it and retry", version)
+}
+
+func (s *Server) respondVersion(w http.ResponseWriter, status int, code, message string, version int64) {
+	etag := seedlingETag(version)
+	w.Header().Set("ETag", etag)
+	s.respondError(w, status, code, message, SeedlingVersion{Version: version, ETag: etag})
+}
+
+// updatedVersion returns whether an update made only at the seedling's
+// version was, moving the seedling to the next version and setting its
+// ETag. Otherwise the seedling changed after checkIfMatch, and it responds
+// with the version it's at now.
+func (s *Server) updatedVersion(w http.ResponseWriter, r *http.Request, seedling *store.Seedling, result sql.Result) bool {
+	n, err := result.RowsAffected()
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to update seedling")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return false
+	}
+	if n == 0 {
+		var version int64
+		if err := s.DB.GetContext(r.Context(), &version,
+			"SELECT version FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+			s.log.WithField("error", err).Error("failed to get seedling version")
+			s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+			return false
+		}
+		s.respondVersionMismatch(w, version)
+		return false
+	}
+	seedling.Version++
+	w.Header().Set("ETag", seedlingETag(seedling.Version))
+	return true
+}
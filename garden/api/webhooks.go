@@ -0,0 +1,152 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/gorilla/mux"
+	"github.com/tensorscale/garden/garden/pipeline"
+)
+
+var (
+	webhookEvents = []string{pipeline.EventStepChanged, pipeline.EventCompleted, pipeline.EventFailed, pipeline.EventBitrot}
+)
+
+func (s *Server) lookupWebhook(w http.ResponseWriter, r *http.Request) (pipeline.Webhook, bool) {
+	id, err := parseID(mux.Vars(r)["id"])
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, err.Error(), nil)
+		return pipeline.Webhook{}, false
+	}
+
+	var hook pipeline.Webhook
+	if err := s.DB.GetContext(r.Context(), &hook, "SELECT * FROM webhooks WHERE id = $1", id); err != nil {
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, pipeline.ErrCodeNotFound, "webhook not found", nil)
+			return pipeline.Webhook{}, false
+		}
+		s.log.WithField("error", err).Error("failed to get webhook")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return pipeline.Webhook{}, false
+	}
+	return hook, true
+}
+
+// CreateWebhook registers a URL to be notified of seedling events.
+func (s *Server) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var hook pipeline.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		s.log.WithField("error", err).Error("failed to decode request body")
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "invalid request body", nil)
+		return
+	}
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest, "url must be an http or https URL", nil)
+		return
+	}
+	if len(hook.Events) == 0 {
+		hook.Events = webhookEvents
+	}
+	for _, event := range hook.Events {
+		known := false
+		for _, e := range webhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			s.respondError(w, http.StatusBadRequest, pipeline.ErrCodeInvalidRequest,
+				fmt.Sprintf("unknown event %q", event), map[string][]string{"events": webhookEvents})
+			return
+		}
+	}
+	hook.EventList = strings.Join(hook.Events, ",")
+	hook.CreatedAt = time.Now()
+	hook.ModifiedAt = hook.CreatedAt
+
+	result, err := s.DB.NamedExecContext(r.Context(), `
+	 INSERT INTO webhooks (url, events, secret, created_at, modified_at)
+	 VALUES (:url, :events, :secret, :created_at, :modified_at)
+	 `, &hook)
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to insert webhook")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		s.log.WithField("error", err).Error("failed to get last inserted id")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	hook.ID = hide.Int64(id)
+	hook.Secret = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(&hook); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// ListWebhooks returns every registered webhook, without secrets.
+func (s *Server) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks := []pipeline.Webhook{}
+	if err := s.DB.SelectContext(r.Context(), &hooks, "SELECT * FROM webhooks ORDER BY created_at DESC"); err != nil {
+		s.log.WithField("error", err).Error("failed to get webhooks")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Events = strings.Split(hooks[i].EventList, ",")
+		hooks[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&hooks); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+func (s *Server) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.lookupWebhook(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.DB.ExecContext(r.Context(), "DELETE FROM webhooks WHERE id = $1", hook.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to delete webhook")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": "webhook deleted"}); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
+
+// WebhookDeliveries returns the delivery history of a webhook, most recent
+// first.
+func (s *Server) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.lookupWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries := []pipeline.WebhookDelivery{}
+	if err := s.DB.SelectContext(r.Context(), &deliveries,
+		"SELECT * FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT 100", hook.ID); err != nil {
+		s.log.WithField("error", err).Error("failed to get webhook deliveries")
+		s.respondError(w, http.StatusInternalServerError, pipeline.ErrCodeInternal, "internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&deliveries); err != nil {
+		s.log.WithField("error", err).Error("failed to encode response")
+	}
+}
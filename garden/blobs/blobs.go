@@ -0,0 +1,45 @@
+// Package blobs stores the artifacts garden keeps outside of seedlings'
+// repos, such as archives and outputs, on the local filesystem or in an
+// S3-compatible bucket.
+package blobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned getting a key that isn't stored.
+var ErrNotFound = errors.New("blob not found")
+
+// Info is a stored blob. Keys are slash-separated paths, e.g.
+// "archive/name.tar.gz".
+type Info struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// BlobStore is where blobs are put and got, streaming them rather than
+// holding them in memory.
+type BlobStore interface {
+	// Ensure creates the store if it doesn't exist yet.
+	Ensure(ctx context.Context) error
+	// Put stores what r reads under key, replacing what's there. size is
+	// -1 if it isn't known. Readers of key see the old blob or the new one,
+	// never part of it.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob at key, wrapping ErrNotFound if there's none.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error)
+	// Stat returns the blob at key, wrapping ErrNotFound if there's none.
+	Stat(ctx context.Context, key string) (Info, error)
+	// List returns the blobs whose keys start with prefix, by key.
+	List(ctx context.Context, prefix string) ([]Info, error)
+	// Delete removes the blob at key. A key that isn't stored is already
+	// deleted.
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL the blob can be downloaded from without
+	// credentials until expiry passes, or "" if the store can't sign them.
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
@@ -0,0 +1,199 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testBlobStore checks what every BlobStore does, with s empty.
+func testBlobStore(t *testing.T, s BlobStore) {
+	ctx := context.Background()
+	if err := s.Ensure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Ensuring a store that exists is fine too.
+	if err := s.Ensure(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		// Larger than one read, and of an unknown size.
+		data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+		if err := s.Put(ctx, "archive/echo.tar.gz", struct{ io.Reader }{bytes.NewReader(data)}, -1); err != nil {
+			t.Fatal(err)
+		}
+		blob, info, err := s.Get(ctx, "archive/echo.tar.gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blob.Close()
+		got, err := ioutil.ReadAll(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("got %d bytes, want %d", len(got), len(data))
+		}
+		if info.Key != "archive/echo.tar.gz" || info.Size != int64(len(data)) || info.ModifiedAt.IsZero() {
+			t.Errorf("info %+v", info)
+		}
+		// Outputs are served in ranges.
+		if _, err := blob.Seek(16, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(blob, head); err != nil || string(head) != "0123" {
+			t.Errorf("read %q after seeking: %v", head, err)
+		}
+
+		if err := s.Put(ctx, "archive/echo.tar.gz", strings.NewReader("replaced"), 8); err != nil {
+			t.Fatal(err)
+		}
+		stat, err := s.Stat(ctx, "archive/echo.tar.gz")
+		if err != nil || stat.Size != 8 {
+			t.Errorf("stat of the replaced blob %+v: %v", stat, err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, _, err := s.Get(ctx, "archive/missing.tar.gz"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get: %v, want %v", err, ErrNotFound)
+		}
+		if _, err := s.Stat(ctx, "archive/missing.tar.gz"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("stat: %v, want %v", err, ErrNotFound)
+		}
+		if err := s.Delete(ctx, "archive/missing.tar.gz"); err != nil {
+			t.Errorf("delete: %v", err)
+		}
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		for _, key := range []string{"outputs/echo/b.txt", "outputs/echo/a/report.json", "outputs/echo2/c.txt", "outputs/other/d.txt"} {
+			if err := s.Put(ctx, key, strings.NewReader(key), int64(len(key))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for prefix, want := range map[string]string{
+			"outputs/echo/":  "outputs/echo/a/report.json outputs/echo/b.txt",
+			"outputs/echo":   "outputs/echo/a/report.json outputs/echo/b.txt outputs/echo2/c.txt",
+			"outputs/none/":  "",
+			"outputs/other/": "outputs/other/d.txt",
+		} {
+			infos, err := s.List(ctx, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, info := range infos {
+				keys = append(keys, info.Key)
+				if info.Size != int64(len(info.Key)) {
+					t.Errorf("%s is %d bytes", info.Key, info.Size)
+				}
+			}
+			if got := strings.Join(keys, " "); got != want {
+				t.Errorf("list %s: %s, want %s", prefix, got, want)
+			}
+		}
+		if err := s.Delete(ctx, "outputs/echo/b.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Stat(ctx, "outputs/echo/b.txt"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("stat of a deleted blob: %v", err)
+		}
+	})
+
+	t.Run("concurrent puts", func(t *testing.T) {
+		// Readers see one whole blob or the other, never a mix.
+		a, b := strings.Repeat("a", 1<<20), strings.Repeat("b", 1<<20)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(data string) {
+				defer wg.Done()
+				if err := s.Put(ctx, "outputs/echo/race.txt", strings.NewReader(data), int64(len(data))); err != nil {
+					t.Error(err)
+				}
+			}([]string{a, b}[i%2])
+		}
+		wg.Wait()
+		blob, _, err := s.Get(ctx, "outputs/echo/race.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blob.Close()
+		got, err := ioutil.ReadAll(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != a && string(got) != b {
+			t.Errorf("got a mix of %d bytes", len(got))
+		}
+	})
+}
+
+func TestLocal(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "bucket")
+	s := NewLocal(root)
+	testBlobStore(t, s)
+	ctx := context.Background()
+
+	// Blobs stay where garden always kept them.
+	if _, err := os.Stat(filepath.Join(root, "archive", "echo.tar.gz")); err != nil {
+		t.Error(err)
+	}
+	if url, err := s.SignedURL(ctx, "archive/echo.tar.gz", 0); url != "" || err != nil {
+		t.Errorf("signed %q: %v", url, err)
+	}
+
+	// What containers write under root is listed, but puts in progress
+	// aren't.
+	if err := ioutil.WriteFile(filepath.Join(root, "outputs", "echo", "written.txt"), []byte("by the container"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "outputs", "echo", putPrefix+"half.txt.123"), []byte("half"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := s.List(ctx, "outputs/echo/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	if got := strings.Join(keys, " "); got != "outputs/echo/a/report.json outputs/echo/race.txt outputs/echo/written.txt" {
+		t.Errorf("listed %s", got)
+	}
+
+	// A directory isn't a blob.
+	if _, _, err := s.Get(ctx, "outputs/echo/a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get of a directory: %v", err)
+	}
+	for _, key := range []string{"", "/archive/echo.tar.gz", "../escape", "outputs/../../escape", "outputs//echo", "outputs/echo/"} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), 1); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("put %q: %v", key, err)
+		}
+		if _, _, err := s.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("get %q: %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escape")); !os.IsNotExist(err) {
+		t.Errorf("a key escaped the root: %v", err)
+	}
+}
+
+func TestNewS3(t *testing.T) {
+	for _, opts := range []S3Options{{Bucket: "garden"}, {Endpoint: "localhost:9000"}} {
+		if _, err := NewS3(opts); err == nil {
+			t.Errorf("no error for %+v", opts)
+		}
+	}
+}
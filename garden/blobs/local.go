@@ -0,0 +1,157 @@
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// putPrefix starts the names of the files blobs are written to before
+// they're renamed into place, which aren't blobs yet.
+const putPrefix = ".blobs-put-"
+
+// localStore keeps blobs as files under root, each at its key.
+type localStore struct {
+	root string
+}
+
+// NewLocal returns a BlobStore keeping blobs in files under root. Files put
+// there other ways, by containers writing outputs and such, are blobs too.
+func NewLocal(root string) BlobStore {
+	return localStore{root: root}
+}
+
+// path is where the blob at key is, refusing keys outside of root.
+func (s localStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean[1:])), nil
+}
+
+func (s localStore) Ensure(ctx context.Context) error {
+	return os.MkdirAll(s.root, 0755)
+}
+
+func (s localStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Written aside and renamed into place, so nothing reads half of it.
+	tmp, err := ioutil.TempFile(filepath.Dir(p), putPrefix+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s localStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, Info{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, Info{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return f, Info{Key: key, Size: fi.Size(), ModifiedAt: fi.ModTime()}, nil
+}
+
+func (s localStore) Stat(ctx context.Context, key string) (Info, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return Info{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Key: key, Size: fi.Size(), ModifiedAt: fi.ModTime()}, nil
+}
+
+func (s localStore) List(ctx context.Context, prefix string) ([]Info, error) {
+	// Walk from the deepest directory the prefix names.
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		p, err := s.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		dir = p
+	}
+	var infos []Info
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), putPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			infos = append(infos, Info{Key: key, Size: fi.Size(), ModifiedAt: fi.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+func (s localStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s localStore) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,138 @@
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options is the S3-compatible bucket to keep blobs in. Endpoint is a
+// host[:port], without a scheme.
+type S3Options struct {
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+}
+
+// s3Store keeps blobs as objects in a bucket, each at its key.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	region string
+}
+
+// NewS3 returns a BlobStore keeping blobs in an S3-compatible bucket. It
+// doesn't connect until it's used.
+func NewS3(opts S3Options) (BlobStore, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("an S3 endpoint and bucket are required")
+	}
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3Store{client: client, bucket: opts.Bucket, region: opts.Region}, nil
+}
+
+// objectError is err, wrapping ErrNotFound if the object or its bucket
+// doesn't exist.
+func objectError(err error, key string) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return err
+}
+
+func objectInfo(info minio.ObjectInfo) Info {
+	return Info{Key: info.Key, Size: info.Size, ModifiedAt: info.LastModified}
+}
+
+func (s s3Store) Ensure(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("checking bucket %s: %w", s.bucket, err)
+	}
+	if exists {
+		return nil
+	}
+	if err := s.client.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{Region: s.region}); err != nil {
+		return fmt.Errorf("creating bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+func (s s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s s3Store) Get(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Info{}, objectError(err, key)
+	}
+	// GetObject doesn't request anything until the object is read.
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, Info{}, objectError(err, key)
+	}
+	return obj, objectInfo(info), nil
+}
+
+func (s s3Store) Stat(ctx context.Context, key string) (Info, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, objectError(err, key)
+	}
+	return objectInfo(info), nil
+}
+
+func (s s3Store) List(ctx context.Context, prefix string) ([]Info, error) {
+	// Cancelled so the listing stops if it fails part way.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var infos []Info
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		infos = append(infos, objectInfo(obj))
+	}
+	return infos, nil
+}
+
+func (s s3Store) Delete(ctx context.Context, key string) error {
+	// Removing an object that doesn't exist succeeds.
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s s3Store) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
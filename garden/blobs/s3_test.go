@@ -0,0 +1,111 @@
+//go:build minio
+
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	minioAccessKey = "garden-test"
+	minioSecretKey = "garden-test-secret"
+)
+
+// minioEndpoint is the host:port of a minio with the test credentials:
+// MINIO_TEST_ENDPOINT, or a container of minio/minio started for t.
+func minioEndpoint(t *testing.T) string {
+	t.Helper()
+	if endpoint := os.Getenv("MINIO_TEST_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-p", "127.0.0.1::9000",
+		"-e", "MINIO_ROOT_USER="+minioAccessKey, "-e", "MINIO_ROOT_PASSWORD="+minioSecretKey,
+		"minio/minio", "server", "/data").CombinedOutput()
+	if err != nil {
+		t.Fatalf("starting minio: %v: %s", err, out)
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() { exec.Command("docker", "rm", "-f", id).Run() })
+	out, err = exec.Command("docker", "port", id, "9000/tcp").CombinedOutput()
+	if err != nil {
+		t.Fatalf("finding minio's port: %v: %s", err, out)
+	}
+	endpoint := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		resp, err := http.Get("http://" + endpoint + "/minio/health/ready")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return endpoint
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("minio at %s isn't ready: %v", endpoint, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestS3(t *testing.T) {
+	endpoint := minioEndpoint(t)
+	// Each run gets its own bucket, which Ensure creates.
+	bucket := fmt.Sprintf("garden-test-%d", time.Now().UnixNano())
+	s, err := NewS3(S3Options{Endpoint: endpoint, Bucket: bucket, AccessKey: minioAccessKey, SecretKey: minioSecretKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, s)
+	ctx := context.Background()
+
+	// The signed URL downloads the blob without credentials, until it
+	// expires.
+	url, err := s.SignedURL(ctx, "outputs/other/d.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "outputs/other/d.txt" {
+		t.Errorf("signed URL: %d %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	url, err = s.SignedURL(ctx, "outputs/other/d.txt", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expired signed URL: %d", resp.StatusCode)
+	}
+
+	// A bucket that doesn't exist has no blobs.
+	missing, err := NewS3(S3Options{Endpoint: endpoint, Bucket: bucket + "-missing", AccessKey: minioAccessKey, SecretKey: minioSecretKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := missing.Stat(ctx, "archive/echo.tar.gz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("stat in a missing bucket: %v", err)
+	}
+}
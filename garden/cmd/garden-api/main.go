@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	_ "github.com/honeycombio/honeycomb-opentelemetry-go"
+	"github.com/honeycombio/otel-launcher-go/launcher"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/api"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/urfave/cli"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+	mathrand.Seed(time.Now().UnixNano())
+	logrus.SetReportCaller(true)
+	logrus.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp: true,
+		CallerPrettyfier: func(f *runtime.Frame) (second string, first string) {
+			_, b, _, _ := runtime.Caller(0)
+			// files are logged relative to the module's garden dir
+			basepath := filepath.Join(filepath.Dir(b), "..", "..")
+			rel, err := filepath.Rel(basepath, f.File)
+			if err != nil {
+				logrus.Error("Couldn't determine file path\n", err)
+			}
+			return "", fmt.Sprintf("%-40s", fmt.Sprintf(" garden-api %s:%d", rel, f.Line))
+		},
+	})
+
+	log := logrus.WithField("service_name", "garden-api")
+	cfg := pipeline.LoadConfig()
+
+	os.Setenv("OTEL_SERVICE_NAME", "garden-api-prod")
+	otelShutdown, err := launcher.ConfigureOpenTelemetry()
+	if err != nil {
+		log.Fatalf("error setting up OTel SDK - %e", err)
+	}
+	defer otelShutdown()
+
+	pipeline.NewMarkers(cfg).Send(api.ProcessStartMarker())
+
+	http.DefaultClient = &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+
+	app := &cli.App{
+		Name:  "garden-api",
+		Usage: "Backend API for garden.ai",
+		Commands: []cli.Command{
+			{
+				Name:  "serve",
+				Usage: "Run business logic API (HTTP)",
+				Action: func(cliCtx *cli.Context) error {
+					return api.ServeCmd(cliCtx, log, cfg)
+				},
+			},
+			api.SeedlingCommand(log, cfg),
+			api.BackupCommand(log, cfg),
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
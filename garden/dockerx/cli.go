@@ -0,0 +1,261 @@
+package dockerx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cliRuntime runs the docker CLI, for daemons the API client can't talk to.
+// Its errors are classified from what docker prints.
+type cliRuntime struct{}
+
+// NewCLI returns a ContainerRuntime running the docker binary on PATH.
+func NewCLI() ContainerRuntime {
+	return cliRuntime{}
+}
+
+type inspectPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// docker runs docker with args, returning its stdout.
+func (cliRuntime) docker(ctx context.Context, args ...string) (string, error) {
+	return runDocker(exec.CommandContext(ctx, "docker", args...))
+}
+
+func runDocker(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), cliError(err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// cliError wraps the error docker exited with in what it printed, and the
+// error of its cause if that's recognized.
+func cliError(err error, out string) error {
+	out = strings.TrimSpace(out)
+	switch {
+	case strings.Contains(out, "is already in use"):
+		return fmt.Errorf("%w: %s", ErrNameConflict, out)
+	case strings.Contains(out, "No such image") || strings.Contains(out, "Unable to find image") ||
+		strings.Contains(out, "pull access denied"):
+		return fmt.Errorf("%w: %s", ErrImageMissing, out)
+	case strings.Contains(out, "No such container"):
+		return fmt.Errorf("%w: %s", ErrNoContainer, out)
+	}
+	return fmt.Errorf("%w: %s", err, out)
+}
+
+func (r cliRuntime) Ping(ctx context.Context) error {
+	_, err := r.docker(ctx, "version", "--format", "{{.Server.Version}}")
+	return err
+}
+
+func (r cliRuntime) States(ctx context.Context) (map[string]string, error) {
+	out, err := r.docker(ctx, "ps", "-a", "--format", "{{.Names}}\t{{.State}}")
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		if fields := strings.SplitN(scanner.Text(), "\t", 2); len(fields) == 2 {
+			states[fields[0]] = fields[1]
+		}
+	}
+	return states, nil
+}
+
+func (r cliRuntime) State(ctx context.Context, name string) (string, error) {
+	out, err := r.docker(ctx, "inspect", "--type", "container", "-f", "{{ .State.Status }}", name)
+	if errors.Is(err, ErrNoContainer) {
+		return StateMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (r cliRuntime) Ports(ctx context.Context, name string) (map[int]int, error) {
+	out, err := r.docker(ctx, "inspect", "--type", "container", "-f", "{{ json .NetworkSettings.Ports }}", name)
+	if err != nil {
+		return nil, err
+	}
+	bindings := map[string][]inspectPortBinding{}
+	if err := json.Unmarshal([]byte(out), &bindings); err != nil {
+		return nil, fmt.Errorf("invalid docker inspect output: %w", err)
+	}
+	ports := map[int]int{}
+	for port, bs := range bindings {
+		containerPort, err := strconv.Atoi(strings.TrimSuffix(port, "/tcp"))
+		if err != nil {
+			continue
+		}
+		for _, b := range bs {
+			if n, err := strconv.Atoi(b.HostPort); err == nil {
+				ports[containerPort] = n
+				break
+			}
+		}
+	}
+	return ports, nil
+}
+
+func (r cliRuntime) Run(ctx context.Context, opts RunOptions) (string, error) {
+	args := []string{"run", "--init", "-d", "--name", opts.Name}
+	if opts.Network != "" {
+		args = append(args, "--network", opts.Network)
+	}
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+	for _, bind := range opts.Binds {
+		args = append(args, "-v", bind)
+	}
+	for _, port := range opts.Ports {
+		args = append(args, "-p", strconv.Itoa(port))
+	}
+	if opts.Memory != "" {
+		args = append(args, "--memory", opts.Memory, "--memory-swap", opts.Memory)
+	}
+	if opts.CPUs != "" {
+		args = append(args, "--cpus", opts.CPUs)
+	}
+	if opts.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(opts.PidsLimit))
+	}
+	if opts.RestartPolicy != "" {
+		args = append(args, "--restart", opts.RestartPolicy)
+	}
+	names := make([]string, 0, len(opts.Env))
+	for name := range opts.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Values are passed through docker's own environment, so they aren't
+	// in its arguments for anyone listing processes to see.
+	env := os.Environ()
+	for _, name := range names {
+		args = append(args, "-e", name)
+		env = append(env, name+"="+opts.Env[name])
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, opts.Image)...)
+	cmd.Env = env
+	out, err := runDocker(cmd)
+	return strings.TrimSpace(out), err
+}
+
+func (r cliRuntime) Start(ctx context.Context, name string) error {
+	_, err := r.docker(ctx, "start", name)
+	return err
+}
+
+func (r cliRuntime) Stop(ctx context.Context, name string) error {
+	_, err := r.docker(ctx, "stop", name)
+	return err
+}
+
+func (r cliRuntime) Restart(ctx context.Context, name string) error {
+	_, err := r.docker(ctx, "restart", name)
+	return err
+}
+
+func (r cliRuntime) Remove(ctx context.Context, name string) error {
+	if _, err := r.docker(ctx, "rm", "-f", name); err != nil && !errors.Is(err, ErrNoContainer) {
+		return err
+	}
+	return nil
+}
+
+func (r cliRuntime) RemoveImage(ctx context.Context, name string) error {
+	_, err := r.docker(ctx, "rmi", name)
+	return err
+}
+
+func (r cliRuntime) ImageSize(ctx context.Context, name string) (int64, error) {
+	out, err := r.docker(ctx, "image", "inspect", "--format", "{{.Size}}", name)
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid docker image inspect output: %w", err)
+	}
+	return size, nil
+}
+
+func (r cliRuntime) ImageID(ctx context.Context, name string) (string, error) {
+	out, err := r.docker(ctx, "image", "inspect", "--format", "{{.Id}}", name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (r cliRuntime) Pull(ctx context.Context, image string) error {
+	_, err := r.docker(ctx, "pull", image)
+	return err
+}
+
+func (r cliRuntime) Build(ctx context.Context, opts BuildOptions) error {
+	args := []string{"build", "-t", opts.Tag}
+	names := make([]string, 0, len(opts.BuildArgs))
+	for name := range opts.BuildArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--build-arg", name+"="+opts.BuildArgs[name])
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, "-")...)
+	cmd.Stdin = bytes.NewReader(opts.Dockerfile)
+	_, err := runDocker(cmd)
+	return err
+}
+
+func (r cliRuntime) Logs(ctx context.Context, name string, opts LogsOptions, stdout, stderr io.Writer) error {
+	args := []string{"logs"}
+	if opts.Tail != "" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	// docker logs writes the container's stdout and stderr to its own
+	// stdout and stderr, so reading them separately demuxes the streams.
+	cmd := exec.CommandContext(ctx, "docker", append(args, name)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func (r cliRuntime) EnsureNetwork(ctx context.Context, name string) error {
+	if _, err := r.docker(ctx, "network", "inspect", name); err == nil {
+		return nil
+	}
+	if _, err := r.docker(ctx, "network", "create", name); err != nil {
+		return fmt.Errorf("docker network create: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package dockerx
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	units "github.com/docker/go-units"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// clientRuntime talks to the docker API, at DOCKER_HOST if it's set, with
+// the API version the daemon supports.
+type clientRuntime struct {
+	client *client.Client
+}
+
+// NewClient returns a ContainerRuntime using the docker API, configured by
+// the DOCKER_HOST, DOCKER_API_VERSION, DOCKER_CERT_PATH and
+// DOCKER_TLS_VERIFY environment variables like the docker CLI. It doesn't
+// connect until it's used.
+func NewClient() (ContainerRuntime, error) {
+	c, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return clientRuntime{client: c}, nil
+}
+
+// containerError is err, wrapping ErrNoContainer if the container wasn't
+// found.
+func containerError(err error) error {
+	if errdefs.IsNotFound(err) {
+		return fmt.Errorf("%w: %v", ErrNoContainer, err)
+	}
+	return err
+}
+
+func imageError(err error) error {
+	if errdefs.IsNotFound(err) {
+		return fmt.Errorf("%w: %v", ErrImageMissing, err)
+	}
+	return err
+}
+
+func (r clientRuntime) Ping(ctx context.Context) error {
+	_, err := r.client.Ping(ctx)
+	return err
+}
+
+func (r clientRuntime) States(ctx context.Context) (map[string]string, error) {
+	containers, err := r.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]string{}
+	for _, c := range containers {
+		for _, name := range c.Names {
+			states[strings.TrimPrefix(name, "/")] = c.State
+		}
+	}
+	return states, nil
+}
+
+func (r clientRuntime) State(ctx context.Context, name string) (string, error) {
+	info, err := r.client.ContainerInspect(ctx, name)
+	if errdefs.IsNotFound(err) {
+		return StateMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return info.State.Status, nil
+}
+
+func (r clientRuntime) Ports(ctx context.Context, name string) (map[int]int, error) {
+	info, err := r.client.ContainerInspect(ctx, name)
+	if err != nil {
+		return nil, containerError(err)
+	}
+	ports := map[int]int{}
+	if info.NetworkSettings == nil {
+		return ports, nil
+	}
+	for port, bindings := range info.NetworkSettings.Ports {
+		if port.Proto() != "tcp" {
+			continue
+		}
+		for _, b := range bindings {
+			if n, err := strconv.Atoi(b.HostPort); err == nil {
+				ports[port.Int()] = n
+				break
+			}
+		}
+	}
+	return ports, nil
+}
+
+// hostConfig is the container config and host config of opts, parsing its
+// limits the way docker run does.
+func (opts RunOptions) hostConfig() (*container.Config, *container.HostConfig, error) {
+	init := true
+	config := &container.Config{Image: opts.Image, ExposedPorts: nat.PortSet{}}
+	host := &container.HostConfig{
+		Binds:        opts.Binds,
+		NetworkMode:  container.NetworkMode(opts.Network),
+		PortBindings: nat.PortMap{},
+		Init:         &init,
+	}
+	for name, value := range opts.Env {
+		config.Env = append(config.Env, name+"="+value)
+	}
+	for _, port := range opts.Ports {
+		p := nat.Port(strconv.Itoa(port) + "/tcp")
+		config.ExposedPorts[p] = struct{}{}
+		host.PortBindings[p] = []nat.PortBinding{{}}
+	}
+	if opts.Memory != "" {
+		memory, err := units.RAMInBytes(opts.Memory)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid memory %q: %w", opts.Memory, err)
+		}
+		host.Memory, host.MemorySwap = memory, memory
+	}
+	if opts.CPUs != "" {
+		cpus, err := strconv.ParseFloat(opts.CPUs, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid cpus %q: %w", opts.CPUs, err)
+		}
+		host.NanoCPUs = int64(math.Round(cpus * 1e9))
+	}
+	if opts.PidsLimit > 0 {
+		limit := int64(opts.PidsLimit)
+		host.PidsLimit = &limit
+	}
+	if opts.RestartPolicy != "" {
+		name, retries, _ := strings.Cut(opts.RestartPolicy, ":")
+		host.RestartPolicy = container.RestartPolicy{Name: name}
+		if retries != "" {
+			n, err := strconv.Atoi(retries)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid restart policy %q", opts.RestartPolicy)
+			}
+			host.RestartPolicy.MaximumRetryCount = n
+		}
+	}
+	return config, host, nil
+}
+
+// platform parses an os/arch[/variant] platform, nil for none.
+func platform(p string) *ocispec.Platform {
+	if p == "" {
+		return nil
+	}
+	parts := strings.SplitN(p, "/", 3)
+	platform := &ocispec.Platform{OS: parts[0]}
+	if len(parts) > 1 {
+		platform.Architecture = parts[1]
+	}
+	if len(parts) > 2 {
+		platform.Variant = parts[2]
+	}
+	return platform
+}
+
+func (r clientRuntime) Run(ctx context.Context, opts RunOptions) (string, error) {
+	config, host, err := opts.hostConfig()
+	if err != nil {
+		return "", err
+	}
+	created, err := r.client.ContainerCreate(ctx, config, host, nil, platform(opts.Platform), opts.Name)
+	switch {
+	case errdefs.IsConflict(err):
+		return "", fmt.Errorf("%w: %v", ErrNameConflict, err)
+	case err != nil:
+		return "", imageError(err)
+	}
+	if err := r.client.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return created.ID, err
+	}
+	return created.ID, nil
+}
+
+func (r clientRuntime) Start(ctx context.Context, name string) error {
+	return containerError(r.client.ContainerStart(ctx, name, types.ContainerStartOptions{}))
+}
+
+func (r clientRuntime) Stop(ctx context.Context, name string) error {
+	return containerError(r.client.ContainerStop(ctx, name, container.StopOptions{}))
+}
+
+func (r clientRuntime) Restart(ctx context.Context, name string) error {
+	return containerError(r.client.ContainerRestart(ctx, name, container.StopOptions{}))
+}
+
+func (r clientRuntime) Remove(ctx context.Context, name string) error {
+	err := r.client.ContainerRemove(ctx, name, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (r clientRuntime) RemoveImage(ctx context.Context, name string) error {
+	_, err := r.client.ImageRemove(ctx, name, types.ImageRemoveOptions{})
+	return imageError(err)
+}
+
+func (r clientRuntime) ImageSize(ctx context.Context, name string) (int64, error) {
+	info, _, err := r.client.ImageInspectWithRaw(ctx, name)
+	if err != nil {
+		return 0, imageError(err)
+	}
+	return info.Size, nil
+}
+
+func (r clientRuntime) ImageID(ctx context.Context, name string) (string, error) {
+	info, _, err := r.client.ImageInspectWithRaw(ctx, name)
+	if err != nil {
+		return "", imageError(err)
+	}
+	return info.ID, nil
+}
+
+// streamMessage is a message of the progress the daemon streams while it
+// pulls or builds an image.
+type streamMessage struct {
+	Stream      string `json:"stream"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// readStream reads progress messages to the end, returning the error the
+// last one reported if any did.
+func readStream(body io.Reader) error {
+	dec := json.NewDecoder(body)
+	for {
+		var msg streamMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+			return errors.New(msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+func (r clientRuntime) Pull(ctx context.Context, image string) error {
+	body, err := r.client.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return imageError(err)
+	}
+	defer body.Close()
+	if err := readStream(body); err != nil {
+		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "pull access denied") {
+			return fmt.Errorf("%w: %v", ErrImageMissing, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r clientRuntime) Build(ctx context.Context, opts BuildOptions) error {
+	var buildContext bytes.Buffer
+	tw := tar.NewWriter(&buildContext)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    "Dockerfile",
+		Mode:    0644,
+		Size:    int64(len(opts.Dockerfile)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(opts.Dockerfile); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	args := map[string]*string{}
+	for name, value := range opts.BuildArgs {
+		value := value
+		args[name] = &value
+	}
+	resp, err := r.client.ImageBuild(ctx, &buildContext, types.ImageBuildOptions{
+		Tags:        []string{opts.Tag},
+		BuildArgs:   args,
+		Remove:      true,
+		ForceRemove: true,
+		// BuildKit needs a session the API client doesn't hold.
+		Version: types.BuilderV1,
+	})
+	if err != nil {
+		return imageError(err)
+	}
+	defer resp.Body.Close()
+	return readStream(resp.Body)
+}
+
+func (r clientRuntime) Logs(ctx context.Context, name string, opts LogsOptions, stdout, stderr io.Writer) error {
+	body, err := r.client.ContainerLogs(ctx, name, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+		Follow:     opts.Follow,
+	})
+	if err != nil {
+		return containerError(err)
+	}
+	defer body.Close()
+	// Containers run without a TTY, so their logs are multiplexed.
+	if _, err := stdcopy.StdCopy(stdout, stderr, body); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func (r clientRuntime) EnsureNetwork(ctx context.Context, name string) error {
+	networks, err := r.client.NetworkList(ctx, types.NetworkListOptions{
+		Filters: filters.NewArgs(filters.Arg("name", name)),
+	})
+	if err != nil {
+		return err
+	}
+	// The name filter also matches names it's part of.
+	for _, n := range networks {
+		if n.Name == name {
+			return nil
+		}
+	}
+	if _, err := r.client.NetworkCreate(ctx, name, types.NetworkCreate{CheckDuplicate: true}); err != nil {
+		return fmt.Errorf("docker network create: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Package dockerx runs, inspects and waits on docker containers, through the
+// docker API or the docker CLI.
+package dockerx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// StateMissing is the state of a container that doesn't exist. Other states
+// are docker's: running, exited, restarting and so on.
+const StateMissing = "missing"
+
+var (
+	// ErrNameConflict is returned running a container whose name is taken.
+	ErrNameConflict = errors.New("container name is already in use")
+	// ErrImageMissing is returned when the image something needs isn't
+	// there and can't be pulled.
+	ErrImageMissing = errors.New("image not found")
+	// ErrNoContainer is returned acting on a container that doesn't exist.
+	ErrNoContainer = errors.New("no such container")
+)
+
+// ContainerRuntime is what seedling containers are run and managed with.
+// Errors it knows the cause of wrap ErrNameConflict, ErrImageMissing or
+// ErrNoContainer.
+type ContainerRuntime interface {
+	// Ping checks the daemon is reachable.
+	Ping(ctx context.Context) error
+	// States returns the state of every container, by name.
+	States(ctx context.Context) (map[string]string, error)
+	// State returns the current state of the named container, StateMissing
+	// if there's none.
+	State(ctx context.Context, name string) (string, error)
+	// Ports returns the host ports the container's TCP ports are published
+	// on, by container port. They change when the container restarts.
+	Ports(ctx context.Context, name string) (map[int]int, error)
+	// Run creates and starts a container in the background, returning its
+	// id.
+	Run(ctx context.Context, opts RunOptions) (string, error)
+	Start(ctx context.Context, name string) error
+	Stop(ctx context.Context, name string) error
+	Restart(ctx context.Context, name string) error
+	// Remove removes the container, stopping it first. A container that
+	// doesn't exist is already removed.
+	Remove(ctx context.Context, name string) error
+	RemoveImage(ctx context.Context, name string) error
+	// ImageSize returns the size of the image.
+	ImageSize(ctx context.Context, name string) (int64, error)
+	// ImageID returns the id of the image, which changes when it's rebuilt.
+	ImageID(ctx context.Context, name string) (string, error)
+	Pull(ctx context.Context, image string) error
+	// Build builds an image from a Dockerfile with nothing else in its
+	// context.
+	Build(ctx context.Context, opts BuildOptions) error
+	// Logs writes the container's logs to stdout and stderr, by the stream
+	// they were written to, until they end or ctx is done if following.
+	Logs(ctx context.Context, name string, opts LogsOptions, stdout, stderr io.Writer) error
+	// EnsureNetwork creates the network if it doesn't exist.
+	EnsureNetwork(ctx context.Context, name string) error
+}
+
+// RunOptions is a container to run. Memory and CPUs are in docker run's
+// --memory and --cpus formats, e.g. "512m" and "1.5". Swap is limited to
+// Memory so a container over it is killed rather than swapping.
+type RunOptions struct {
+	Name     string
+	Image    string
+	Network  string
+	Platform string
+	// Binds are host:container[:ro] mounts.
+	Binds []string
+	// Ports are container TCP ports, each published on a free host port.
+	Ports         []int
+	Env           map[string]string
+	Memory        string
+	CPUs          string
+	PidsLimit     int
+	RestartPolicy string
+}
+
+// BuildOptions is an image to build.
+type BuildOptions struct {
+	Tag        string
+	Dockerfile []byte
+	BuildArgs  map[string]string
+}
+
+// LogsOptions is which of a container's logs to read. Tail is a number of
+// lines or "all", and Since anything docker logs --since takes.
+type LogsOptions struct {
+	Tail   string
+	Since  string
+	Follow bool
+}
+
+// WaitForState polls the container until it's in state, returning the last
+// state it saw if ctx is done first.
+func WaitForState(ctx context.Context, rt ContainerRuntime, name, state string) (string, error) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		current, err := rt.State(ctx, name)
+		if err != nil || current == state {
+			return current, err
+		}
+		select {
+		case <-ctx.Done():
+			return current, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	gogpt "github.com/sashabaranov/go-gpt3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+
+	OpenAIChatURL = "https://api.openai.com/v1/chat/completions"
+)
+
+// Message is one turn of a chat conversation.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatLLM is implemented by providers with a chat API, which are prompted
+// with a conversation instead of one prompt string.
+type ChatLLM interface {
+	Chat(ctx context.Context, messages []Message, opts CompletionOptions) (string, error)
+}
+
+// contextTokens are the context sizes of the models whose size is known.
+var contextTokens = map[string]int{
+	"text-curie-001":   2049,
+	"text-davinci-002": 4097,
+	"text-davinci-003": 4097,
+	"code-davinci-002": 8001,
+	"gpt-3.5-turbo":    4096,
+	"gpt-4":            8192,
+	"gpt-4-32k":        32768,
+}
+
+// ContextTokens is how many tokens the model's prompt and completion can
+// take up together, or 0 if that isn't known.
+func ContextTokens(model string) int {
+	return contextTokens[model]
+}
+
+// EstimateTokens is a rough count of the tokens text takes up, about four
+// characters each for English and code.
+func EstimateTokens(text string) int {
+	return len(text)/4 + 1
+}
+
+// Chat prompts the chat completions API with the conversation.
+func (o *OpenAI) Chat(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	<-o.ticker.C
+	logrus.WithField("messages", len(messages)).
+		WithField("model", opts.Model).
+		WithField("temperature", opts.Temperature).
+		Warn("====== PROMPTING GPT CHAT ======")
+
+	req := gogpt.ChatCompletionRequest{
+		Model:       opts.Model,
+		MaxTokens:   opts.MaxTokens,
+		Temperature: opts.Temperature,
+	}
+	for _, m := range messages {
+		req.Messages = append(req.Messages, gogpt.ChatCompletionMessage{Role: m.Role, Content: m.Content})
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, OpenAIChatURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	resp, err := o.http.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// Errors are returned as the client's, so FallbackError sees them the
+	// same way as completion errors.
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errResp gogpt.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == nil {
+			return "", &gogpt.RequestError{StatusCode: resp.StatusCode, Err: err}
+		}
+		errResp.Error.StatusCode = resp.StatusCode
+		return "", fmt.Errorf("chat completion failed: %w", errResp.Error)
+	}
+	var chat gogpt.ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
+		return "", err
+	}
+	if len(chat.Choices) == 0 {
+		return "", fmt.Errorf("chat completion returned no choices")
+	}
+
+	o.usage(opts.Model, chat.Usage.PromptTokens, chat.Usage.CompletionTokens)
+	logrus.WithField("finishReason", chat.Choices[0].FinishReason).Warn("====== GPT CHAT RESPONSE ======")
+	if chat.Choices[0].FinishReason == "length" {
+		return chat.Choices[0].Message.Content, ErrLength
+	}
+	return chat.Choices[0].Message.Content, nil
+}
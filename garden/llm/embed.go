@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	gogpt "github.com/sashabaranov/go-gpt3"
+)
+
+// ErrNoEmbeddings is returned embedding with a provider that has no
+// embeddings API.
+var ErrNoEmbeddings = errors.New("provider has no embeddings API")
+
+// Embedder is implemented by providers with an embeddings API. Embed returns
+// the vector of each text, in order.
+type Embedder interface {
+	Embed(ctx context.Context, model string, texts []string) ([][]float64, error)
+}
+
+// Embed embeds the texts with the embeddings API. It isn't rate limited
+// like completions are.
+func (o *OpenAI) Embed(ctx context.Context, model string, texts []string) ([][]float64, error) {
+	var m gogpt.EmbeddingModel
+	if err := m.UnmarshalText([]byte(model)); err != nil || m == gogpt.Unknown {
+		return nil, fmt.Errorf("unknown embedding model %q", model)
+	}
+	// Newlines embed worse, OpenAI says.
+	input := make([]string, len(texts))
+	for i, text := range texts {
+		input[i] = strings.ReplaceAll(text, "\n", " ")
+	}
+	resp, err := o.client.CreateEmbeddings(ctx, gogpt.EmbeddingRequest{Input: input, Model: m})
+	if err != nil {
+		return nil, err
+	}
+	o.usage(model, resp.Usage.PromptTokens, 0)
+	vectors := make([][]float64, len(texts))
+	for _, e := range resp.Data {
+		if e.Index < 0 || e.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding of input %d of %d", e.Index, len(texts))
+		}
+		vectors[e.Index] = e.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("no embedding of input %d", i)
+		}
+	}
+	return vectors, nil
+}
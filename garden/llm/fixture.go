@@ -0,0 +1,225 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultFixtureName is what completions made with a context without a
+	// fixture name are filed under.
+	DefaultFixtureName = "default"
+	// DefaultFixtureStep is what completions made with a context without a
+	// fixture step are filed under.
+	DefaultFixtureStep = "completion"
+)
+
+// ErrNoFixture is returned by Fixtures for a completion nothing was recorded
+// for.
+var ErrNoFixture = errors.New("no fixture")
+
+// Fixture is a recorded completion. Fixtures are stored one per file, at
+// FixturePath.
+type Fixture struct {
+	Name       string `yaml:"name"`
+	Step       string `yaml:"step"`
+	Attempt    int    `yaml:"attempt"`
+	Model      string `yaml:"model,omitempty"`
+	Completion string `yaml:"completion"`
+}
+
+type fixtureNameKey struct{}
+type fixtureStepKey struct{}
+
+// WithFixtureName files the completions made with ctx under name, the
+// seedling they're made for.
+func WithFixtureName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, fixtureNameKey{}, name)
+}
+
+// WithFixtureStep files the completions made with ctx under step.
+func WithFixtureStep(ctx context.Context, step string) context.Context {
+	return context.WithValue(ctx, fixtureStepKey{}, step)
+}
+
+func fixtureScope(ctx context.Context) (string, string) {
+	name, _ := ctx.Value(fixtureNameKey{}).(string)
+	if name == "" {
+		name = DefaultFixtureName
+	}
+	step, _ := ctx.Value(fixtureStepKey{}).(string)
+	if step == "" {
+		step = DefaultFixtureStep
+	}
+	return name, step
+}
+
+// FixturePath is the file the fixture of the attempt'th completion of a
+// step of name is stored in.
+func FixturePath(dir, name, step string, attempt int) string {
+	return filepath.Join(dir, name, fmt.Sprintf("%s-%d.yaml", step, attempt))
+}
+
+// fixtureAttempts numbers the completions of each name and step from 1, in
+// the order they're made.
+type fixtureAttempts struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func (a *fixtureAttempts) take(name, step string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.next == nil {
+		a.next = map[string]int{}
+	}
+	a.next[name+"/"+step]++
+	return a.next[name+"/"+step]
+}
+
+// Fixtures is the LLM that replays completions recorded by a Recorder, for
+// running without a network or an OpenAI key. The same builds have to be run
+// in the same order as when they were recorded.
+type Fixtures struct {
+	dir      string
+	attempts fixtureAttempts
+}
+
+// NewFixtures returns the provider replaying the fixtures in dir.
+func NewFixtures(dir string) *Fixtures {
+	return &Fixtures{dir: dir}
+}
+
+func (f *Fixtures) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, error) {
+	return f.replay(ctx)
+}
+
+func (f *Fixtures) Chat(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	return f.replay(ctx)
+}
+
+// CheckKey checks the fixtures directory exists, there being no key.
+func (f *Fixtures) CheckKey(ctx context.Context) error {
+	info, err := os.Stat(f.dir)
+	if err != nil {
+		return fmt.Errorf("fixtures dir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("fixtures dir %s is not a directory", f.dir)
+	}
+	return nil
+}
+
+func (f *Fixtures) replay(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	name, step := fixtureScope(ctx)
+	attempt := f.attempts.take(name, step)
+	path := FixturePath(f.dir, name, step, attempt)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w for attempt %d at %s of %s, expected %s", ErrNoFixture, attempt, step, name, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	var fixture Fixture
+	if err := yaml.Unmarshal(data, &fixture); err != nil {
+		return "", fmt.Errorf("fixture %s: %w", path, err)
+	}
+	return fixture.Completion, nil
+}
+
+// Recorder is an LLM that writes the completions of another to fixtures
+// Fixtures can replay. Only completions that succeed are recorded and
+// numbered, since those are all Fixtures can replay.
+type Recorder struct {
+	llm LLM
+	dir string
+	// redact masks the secrets in a completion made for name before it's
+	// written.
+	redact   func(name, text string) string
+	attempts fixtureAttempts
+}
+
+// NewRecorder returns the provider recording provider's completions to dir.
+// redact may be nil.
+func NewRecorder(provider LLM, dir string, redact func(name, text string) string) *Recorder {
+	if redact == nil {
+		redact = func(name, text string) string { return text }
+	}
+	return &Recorder{llm: provider, dir: dir, redact: redact}
+}
+
+func (r *Recorder) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, error) {
+	text, err := r.llm.Complete(ctx, prompt, opts)
+	return text, r.record(ctx, opts, text, err)
+}
+
+func (r *Recorder) Chat(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	chat, ok := r.llm.(ChatLLM)
+	if !ok {
+		return "", fmt.Errorf("%T can't chat", r.llm)
+	}
+	text, err := chat.Chat(ctx, messages, opts)
+	return text, r.record(ctx, opts, text, err)
+}
+
+func (r *Recorder) CompleteStream(ctx context.Context, prompt string, opts CompletionOptions, onChunk func(string) bool) (string, error) {
+	streamer, ok := r.llm.(StreamingLLM)
+	if !ok {
+		return r.Complete(ctx, prompt, opts)
+	}
+	text, err := streamer.CompleteStream(ctx, prompt, opts, onChunk)
+	return text, r.record(ctx, opts, text, err)
+}
+
+// Embed embeds with the recorded provider. Embeddings aren't recorded, so
+// Fixtures can't replay them.
+func (r *Recorder) Embed(ctx context.Context, model string, texts []string) ([][]float64, error) {
+	embedder, ok := r.llm.(Embedder)
+	if !ok {
+		return nil, ErrNoEmbeddings
+	}
+	return embedder.Embed(ctx, model, texts)
+}
+
+func (r *Recorder) CheckKey(ctx context.Context) error {
+	if checker, ok := r.llm.(KeyChecker); ok {
+		return checker.CheckKey(ctx)
+	}
+	return nil
+}
+
+// record writes the completion's fixture, unless it failed, in which case
+// its error is returned as is.
+func (r *Recorder) record(ctx context.Context, opts CompletionOptions, text string, err error) error {
+	if err != nil {
+		return err
+	}
+	name, step := fixtureScope(ctx)
+	attempt := r.attempts.take(name, step)
+	data, err := yaml.Marshal(&Fixture{
+		Name:       name,
+		Step:       step,
+		Attempt:    attempt,
+		Model:      opts.Model,
+		Completion: r.redact(name, text),
+	})
+	if err != nil {
+		return err
+	}
+	path := FixturePath(r.dir, name, step, attempt)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
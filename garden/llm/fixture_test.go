@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scripted answers each completion with the next of its replies, failing
+// those that are empty.
+type scripted struct {
+	replies []string
+	n       int
+}
+
+func (s *scripted) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, error) {
+	reply := s.replies[s.n%len(s.replies)]
+	s.n++
+	if reply == "" {
+		return "", errors.New("rate limited")
+	}
+	return reply, nil
+}
+
+func (s *scripted) Chat(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	return s.Complete(ctx, "", opts)
+}
+
+func scope(name, step string) context.Context {
+	return WithFixtureStep(WithFixtureName(context.Background(), name), step)
+}
+
+func TestRecordAndReplayFixtures(t *testing.T) {
+	dir := t.TempDir()
+	provider := &scripted{replies: []string{"syntax = \"proto3\";", "", "package main // sk-secret", "package main", "FROM golang"}}
+	r := NewRecorder(provider, dir, func(name, text string) string {
+		return strings.ReplaceAll(text, "sk-secret", "[REDACTED:"+name+"]")
+	})
+	opts := CompletionOptions{Model: "gpt-4"}
+	calls := []struct {
+		ctx  context.Context
+		chat bool
+	}{
+		{scope("echo", "protobufs"), false},
+		{scope("echo", "server"), true},
+		{scope("echo", "server"), true},
+		{scope("echo", "server"), false},
+		{scope("echo", "dockerfile"), false},
+	}
+	var recorded []string
+	for _, c := range calls {
+		var text string
+		var err error
+		if c.chat {
+			text, err = r.Chat(c.ctx, []Message{{Role: "user", Content: "write it"}}, opts)
+		} else {
+			text, err = r.Complete(c.ctx, "write it", opts)
+		}
+		if err != nil {
+			// What failed is returned but not recorded.
+			if err.Error() != "rate limited" {
+				t.Fatal(err)
+			}
+			continue
+		}
+		recorded = append(recorded, text)
+	}
+
+	// The failed completion isn't numbered, so the server's are 1 and 2.
+	for _, path := range []string{
+		FixturePath(dir, "echo", "protobufs", 1),
+		FixturePath(dir, "echo", "server", 1),
+		FixturePath(dir, "echo", "server", 2),
+		FixturePath(dir, "echo", "dockerfile", 1),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(FixturePath(dir, "echo", "server", 3)); !os.IsNotExist(err) {
+		t.Errorf("a third server fixture was recorded: %v", err)
+	}
+	data, err := ioutil.ReadFile(FixturePath(dir, "echo", "server", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "sk-secret") || !strings.Contains(string(data), "[REDACTED:echo]") {
+		t.Errorf("the fixture isn't redacted:\n%s", data)
+	}
+	if !strings.Contains(string(data), "model: gpt-4") {
+		t.Errorf("the fixture doesn't have its model:\n%s", data)
+	}
+
+	// The same completions in the same order replay what was recorded,
+	// redacted, whether they're chats or not.
+	f := NewFixtures(dir)
+	if err := f.CheckKey(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"syntax = \"proto3\";", "package main // [REDACTED:echo]", "package main", "FROM golang"}
+	var got []string
+	for i, c := range []context.Context{scope("echo", "protobufs"), scope("echo", "server"), scope("echo", "server"), scope("echo", "dockerfile")} {
+		var text string
+		if i == 1 {
+			text, err = f.Chat(c, nil, opts)
+		} else {
+			text, err = f.Complete(c, "anything", opts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, text)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+	if len(recorded) != len(want) || recorded[1] != "package main // sk-secret" {
+		t.Errorf("the recorder returned %q, not what was completed", recorded)
+	}
+}
+
+func TestMissingFixture(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRecorder(&scripted{replies: []string{"package main"}}, dir, nil)
+	if _, err := r.Complete(scope("echo", "server"), "", CompletionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFixtures(dir)
+	if _, err := f.Complete(scope("echo", "server"), "", CompletionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := f.Complete(scope("echo", "server"), "", CompletionOptions{})
+	if !errors.Is(err, ErrNoFixture) {
+		t.Fatalf("error %v, want %v", err, ErrNoFixture)
+	}
+	if want := FixturePath(dir, "echo", "server", 2); !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q doesn't name %s", err, want)
+	}
+
+	// Completions without a seedling or step are filed under the defaults.
+	_, err = f.Complete(context.Background(), "", CompletionOptions{})
+	if want := FixturePath(dir, DefaultFixtureName, DefaultFixtureStep, 1); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %v doesn't name %s", err, want)
+	}
+
+	ctx, cancel := context.WithCancel(scope("echo", "dockerfile"))
+	cancel()
+	if _, err := f.Complete(ctx, "", CompletionOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v for a canceled completion", err)
+	}
+}
+
+func TestFixturesCheckKey(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewFixtures(filepath.Join(dir, "missing")).CheckKey(context.Background()); err == nil {
+		t.Error("no error for a missing fixtures dir")
+	}
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewFixtures(file).CheckKey(context.Background()); err == nil {
+		t.Error("no error for a fixtures dir that's a file")
+	}
+}
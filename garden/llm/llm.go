@@ -0,0 +1,208 @@
+// Package llm is the interface the seedling pipeline prompts models through,
+// and the OpenAI provider behind it.
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	gogpt "github.com/sashabaranov/go-gpt3"
+	"github.com/sirupsen/logrus"
+)
+
+// CompletionOptions are the per-request parameters of a completion.
+type CompletionOptions struct {
+	Model       string
+	MaxTokens   int
+	Temperature float32
+}
+
+// ErrLength is returned with the text of a completion that was cut off at
+// its MaxTokens.
+var ErrLength = errors.New("completion hit the token limit")
+
+// LLM is the provider the pipeline prompts for code. Completions cut off at
+// MaxTokens are returned along with ErrLength.
+type LLM interface {
+	Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, error)
+}
+
+// StreamingLLM is implemented by providers that can stream a completion.
+// onChunk is called with each piece of text as it arrives and returns false
+// to stop the stream early, e.g. once the code block has been closed.
+type StreamingLLM interface {
+	LLM
+	CompleteStream(ctx context.Context, prompt string, opts CompletionOptions, onChunk func(string) bool) (string, error)
+}
+
+// KeyChecker is implemented by providers that can tell whether their API key
+// works without spending tokens on a completion.
+type KeyChecker interface {
+	CheckKey(ctx context.Context) error
+}
+
+// ErrNoAPIKey is returned by CheckKey when the provider has no key at all.
+var ErrNoAPIKey = errors.New("no API key")
+
+// UsageFunc is told how many prompt and completion tokens each request to a
+// model used.
+type UsageFunc func(model string, prompt, completion int)
+
+// OpenAI is the LLM backed by the OpenAI completions API, rate limited to one
+// request per tick.
+type OpenAI struct {
+	client *gogpt.Client
+	ticker *time.Ticker
+	usage  UsageFunc
+
+	// apiKey and http are for the chat API, which the client only allows
+	// a couple of models to be used with.
+	apiKey string
+	http   *http.Client
+}
+
+// NewOpenAI returns the OpenAI provider for apiKey. usage may be nil.
+func NewOpenAI(apiKey string, usage UsageFunc) *OpenAI {
+	if usage == nil {
+		usage = func(string, int, int) {}
+	}
+	return &OpenAI{
+		client: gogpt.NewClient(apiKey),
+		ticker: time.NewTicker(10 * time.Second),
+		usage:  usage,
+		apiKey: apiKey,
+		http:   &http.Client{},
+	}
+}
+
+// CheckKey lists the models the key can use, the cheapest request that's
+// refused for a bad key. It isn't rate limited like completions are.
+func (o *OpenAI) CheckKey(ctx context.Context) error {
+	if o.apiKey == "" {
+		return ErrNoAPIKey
+	}
+	if _, err := o.client.ListModels(ctx); err != nil {
+		return fmt.Errorf("list models: %w", err)
+	}
+	return nil
+}
+
+// Complete stops at the first "```", so it's only used for short answers and
+// when streaming isn't available.
+func (o *OpenAI) Complete(ctx context.Context, prompt string, opts CompletionOptions) (string, error) {
+	<-o.ticker.C
+	// temp := rand.Float32()*(1.5-0.2) + 0.2
+
+	logrus.WithField("prompt_len", len(prompt)).
+		WithField("prompt", truncate(prompt)).
+		WithField("model", opts.Model).
+		WithField("temperature", opts.Temperature).
+		Debug("Prompting GPT")
+
+	req := gogpt.CompletionRequest{
+		Model:       opts.Model,
+		MaxTokens:   opts.MaxTokens,
+		Prompt:      prompt,
+		Stop:        []string{"```"},
+		Temperature: opts.Temperature,
+	}
+	resp, err := o.client.CreateCompletion(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	o.usage(opts.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+
+	logrus.WithField("finishReason", resp.Choices[0].FinishReason).
+		WithField("completion", truncate(resp.Choices[0].Text)).
+		Debug("GPT responded")
+	if resp.Choices[0].FinishReason == "length" {
+		return resp.Choices[0].Text, ErrLength
+	}
+	return resp.Choices[0].Text, nil
+}
+
+// CompleteStream streams a completion without a stop sequence; callers find
+// the end of the code block themselves, see closingFence.
+func (o *OpenAI) CompleteStream(
+	ctx context.Context,
+	prompt string,
+	opts CompletionOptions,
+	onChunk func(string) bool,
+) (string, error) {
+	<-o.ticker.C
+	logrus.WithField("prompt_len", len(prompt)).
+		WithField("model", opts.Model).
+		WithField("temperature", opts.Temperature).
+		Warn("====== STREAMING GPT ======")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := o.client.CreateCompletionStream(ctx, gogpt.CompletionRequest{
+		Model:       opts.Model,
+		MaxTokens:   opts.MaxTokens,
+		Prompt:      prompt,
+		Temperature: opts.Temperature,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	// Streamed responses don't report usage, but each chunk is a token.
+	var text strings.Builder
+	chunks := 0
+	finishReason := ""
+	defer func() { o.usage(opts.Model, 0, chunks) }()
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return text.String(), err
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+		chunks++
+		text.WriteString(resp.Choices[0].Text)
+		finishReason = resp.Choices[0].FinishReason
+		if !onChunk(resp.Choices[0].Text) {
+			break
+		}
+	}
+	if finishReason == "length" {
+		return text.String(), ErrLength
+	}
+	return text.String(), nil
+}
+
+// FallbackError reports whether another model might succeed where this one
+// failed: the prompt didn't fit its context or the model doesn't exist.
+func FallbackError(err error) bool {
+	var apiErr *gogpt.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code != nil && (*apiErr.Code == "context_length_exceeded" || *apiErr.Code == "model_not_found") {
+		return true
+	}
+	return apiErr.StatusCode == 404 || strings.Contains(apiErr.Message, "maximum context length")
+}
+
+// DebugTextLimit is how much of a prompt or completion is logged, at debug
+// level.
+const DebugTextLimit = 200
+
+func truncate(text string) string {
+	if len(text) <= DebugTextLimit {
+		return text
+	}
+	return text[:DebugTextLimit] + "..."
+}
@@ -0,0 +1,3 @@
+ALTER TABLE seedlings ADD COLUMN skip_tests BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE seedlings ADD COLUMN tests_passed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE seedlings ADD COLUMN tests_failed INTEGER NOT NULL DEFAULT 0;
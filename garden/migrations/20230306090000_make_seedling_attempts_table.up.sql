@@ -0,0 +1,12 @@
+CREATE TABLE seedling_attempts (
+  id INTEGER PRIMARY KEY,
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  step TEXT NOT NULL,
+  attempt INTEGER NOT NULL,
+  success BOOLEAN NOT NULL DEFAULT FALSE,
+  output TEXT NOT NULL DEFAULT "",
+  build_duration_ms INTEGER NOT NULL DEFAULT 0,
+  build_cache BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX seedling_attempts_seedling_id ON seedling_attempts(seedling_id);
@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN platform TEXT NOT NULL DEFAULT "";
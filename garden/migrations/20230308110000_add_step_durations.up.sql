@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN step_started_at TIMESTAMP;
+ALTER TABLE seedling_attempts ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
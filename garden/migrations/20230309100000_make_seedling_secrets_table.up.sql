@@ -0,0 +1,7 @@
+CREATE TABLE seedling_secrets (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  modified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (seedling_id, name)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE build_leases (
+  seedling_id INTEGER PRIMARY KEY REFERENCES seedlings(id) ON DELETE CASCADE,
+  holder TEXT NOT NULL,
+  acquired_at TIMESTAMP NOT NULL,
+  heartbeat_at TIMESTAMP NOT NULL
+);
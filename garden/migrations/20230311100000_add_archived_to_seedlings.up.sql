@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,7 @@
+ALTER TABLE seedlings ADD COLUMN git_remote_url TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN git_branch TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN git_push_on_complete BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE seedlings ADD COLUMN git_pushed_url TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN git_pushed_sha TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN git_pushed_at TIMESTAMP;
+ALTER TABLE seedlings ADD COLUMN git_push_error TEXT NOT NULL DEFAULT "";
@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN error TEXT NOT NULL DEFAULT "";
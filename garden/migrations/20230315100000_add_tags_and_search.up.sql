@@ -0,0 +1,25 @@
+CREATE TABLE seedling_tags (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  tag TEXT NOT NULL,
+  UNIQUE (seedling_id, tag)
+);
+CREATE INDEX seedling_tags_tag ON seedling_tags(tag);
+
+CREATE VIRTUAL TABLE seedlings_fts USING fts5(
+  name,
+  description,
+  content='seedlings',
+  content_rowid='id'
+);
+INSERT INTO seedlings_fts(seedlings_fts) VALUES ('rebuild');
+
+CREATE TRIGGER seedlings_fts_insert AFTER INSERT ON seedlings BEGIN
+  INSERT INTO seedlings_fts(rowid, name, description) VALUES (new.id, new.name, new.description);
+END;
+CREATE TRIGGER seedlings_fts_delete AFTER DELETE ON seedlings BEGIN
+  INSERT INTO seedlings_fts(seedlings_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+END;
+CREATE TRIGGER seedlings_fts_update AFTER UPDATE OF name, description ON seedlings BEGIN
+  INSERT INTO seedlings_fts(seedlings_fts, rowid, name, description) VALUES ('delete', old.id, old.name, old.description);
+  INSERT INTO seedlings_fts(rowid, name, description) VALUES (new.id, new.name, new.description);
+END;
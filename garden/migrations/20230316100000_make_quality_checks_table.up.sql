@@ -0,0 +1,15 @@
+CREATE TABLE quality_checks (
+  id INTEGER PRIMARY KEY,
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  quality TEXT NOT NULL,
+  reason TEXT NOT NULL DEFAULT "",
+  suggestions TEXT NOT NULL DEFAULT "",
+  code_hash TEXT NOT NULL,
+  code TEXT NOT NULL,
+  accepted BOOLEAN NOT NULL DEFAULT FALSE,
+  overridden_by TEXT NOT NULL DEFAULT "",
+  overridden_at TIMESTAMP NULL,
+  consumed BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX quality_checks_seedling_id ON quality_checks(seedling_id);
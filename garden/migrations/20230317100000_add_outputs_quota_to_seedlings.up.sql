@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN outputs_quota_exceeded BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+ALTER TABLE seedlings ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE seedlings ADD COLUMN refine_instruction TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN refine_base TEXT NOT NULL DEFAULT "";
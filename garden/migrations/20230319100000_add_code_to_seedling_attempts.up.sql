@@ -0,0 +1,2 @@
+ALTER TABLE seedling_attempts ADD COLUMN file TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedling_attempts ADD COLUMN code TEXT NOT NULL DEFAULT "";
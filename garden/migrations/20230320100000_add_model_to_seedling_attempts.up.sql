@@ -0,0 +1 @@
+ALTER TABLE seedling_attempts ADD COLUMN model TEXT NOT NULL DEFAULT "";
@@ -0,0 +1 @@
+ALTER TABLE seedling_attempts ADD COLUMN auto_fixes TEXT NOT NULL DEFAULT "";
@@ -0,0 +1,11 @@
+CREATE TABLE seedling_checkpoints (
+  seedling_id INTEGER PRIMARY KEY REFERENCES seedlings(id) ON DELETE CASCADE,
+  step TEXT NOT NULL,
+  attempt INTEGER NOT NULL DEFAULT 0,
+  errs INTEGER NOT NULL DEFAULT 0,
+  err_mode BOOLEAN NOT NULL DEFAULT FALSE,
+  dumped_mod_docs BOOLEAN NOT NULL DEFAULT FALSE,
+  prompt TEXT NOT NULL DEFAULT "",
+  last_output TEXT NOT NULL DEFAULT "",
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,12 @@
+CREATE TABLE templates (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT "",
+  params TEXT NOT NULL DEFAULT "",
+  proto_skeleton TEXT NOT NULL DEFAULT "",
+  server_hints TEXT NOT NULL DEFAULT "",
+  quality_rules TEXT NOT NULL DEFAULT "",
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE seedlings ADD COLUMN template TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN template_params TEXT NOT NULL DEFAULT "";
@@ -0,0 +1 @@
+ALTER TABLE seedling_attempts ADD COLUMN rejected_modules TEXT NOT NULL DEFAULT "";
@@ -0,0 +1 @@
+ALTER TABLE seedling_attempts ADD COLUMN commit_sha TEXT NOT NULL DEFAULT "";
@@ -0,0 +1,6 @@
+ALTER TABLE seedlings RENAME COLUMN error TO failure_reason;
+ALTER TABLE seedlings ADD COLUMN failed_step TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN failed_at TIMESTAMP;
+UPDATE seedlings
+SET failed_step = step, step = "SeedlingStepFailed", failed_at = modified_at
+WHERE failure_reason != "" AND step != "SeedlingStepComplete";
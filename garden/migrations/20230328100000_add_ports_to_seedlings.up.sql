@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN grpc_port INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE seedlings ADD COLUMN http_port INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE seedlings ADD COLUMN container_action TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN container_action_by TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN container_action_at TIMESTAMP;
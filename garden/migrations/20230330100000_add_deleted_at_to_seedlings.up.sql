@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE seedlings ADD COLUMN deleted_while_running BOOLEAN NOT NULL DEFAULT FALSE;
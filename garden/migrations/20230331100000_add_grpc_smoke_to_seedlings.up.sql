@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN grpc_smoke TEXT;
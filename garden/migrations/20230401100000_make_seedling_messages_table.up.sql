@@ -0,0 +1,10 @@
+CREATE TABLE seedling_messages (
+  id INTEGER PRIMARY KEY,
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  step TEXT NOT NULL,
+  role TEXT NOT NULL,
+  content TEXT NOT NULL DEFAULT "",
+  accepted BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX seedling_messages_seedling_id ON seedling_messages(seedling_id);
@@ -0,0 +1,4 @@
+ALTER TABLE seedlings ADD COLUMN temperature REAL;
+ALTER TABLE seedlings ADD COLUMN max_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE seedling_attempts ADD COLUMN temperature REAL NOT NULL DEFAULT 0;
+ALTER TABLE seedling_attempts ADD COLUMN max_tokens INTEGER NOT NULL DEFAULT 0;
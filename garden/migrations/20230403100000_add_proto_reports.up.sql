@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN refine_allow_breaking BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE seedling_attempts ADD COLUMN proto_report TEXT;
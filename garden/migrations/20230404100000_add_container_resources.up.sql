@@ -0,0 +1,4 @@
+ALTER TABLE seedlings ADD COLUMN memory TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN cpus TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN pids_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE seedlings ADD COLUMN restart_policy TEXT NOT NULL DEFAULT "";
@@ -0,0 +1,10 @@
+CREATE TABLE seedling_events (
+  id INTEGER PRIMARY KEY,
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  type TEXT NOT NULL,
+  step TEXT NOT NULL DEFAULT "",
+  actor TEXT NOT NULL DEFAULT "",
+  payload TEXT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX seedling_events_seedling_id ON seedling_events(seedling_id);
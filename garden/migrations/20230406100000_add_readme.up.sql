@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN readme_generated_at TIMESTAMP;
+ALTER TABLE seedlings ADD COLUMN readme_error TEXT NOT NULL DEFAULT "";
@@ -0,0 +1 @@
+ALTER TABLE seedling_attempts ADD COLUMN duplicate BOOLEAN NOT NULL DEFAULT FALSE;
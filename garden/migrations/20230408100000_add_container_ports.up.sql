@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN grpc_container_port INTEGER NOT NULL DEFAULT 8000;
+ALTER TABLE seedlings ADD COLUMN http_container_port INTEGER NOT NULL DEFAULT 8001;
@@ -0,0 +1,10 @@
+CREATE TABLE gardens (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT "",
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO gardens (name) VALUES ("default");
+
+ALTER TABLE seedlings ADD COLUMN garden TEXT NOT NULL DEFAULT "default";
+CREATE INDEX seedlings_garden ON seedlings(garden);
@@ -0,0 +1,16 @@
+CREATE TABLE experiments (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  garden TEXT NOT NULL DEFAULT "default",
+  description TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE seedlings ADD COLUMN model TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN experiment_id INTEGER REFERENCES experiments(id);
+ALTER TABLE seedlings ADD COLUMN experiment_variant INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE seedlings ADD COLUMN kept BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX seedlings_experiment_id ON seedlings(experiment_id);
+
+ALTER TABLE seedling_attempts ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE seedling_attempts ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;
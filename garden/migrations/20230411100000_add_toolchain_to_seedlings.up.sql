@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN toolchain TEXT NOT NULL DEFAULT "1.19";
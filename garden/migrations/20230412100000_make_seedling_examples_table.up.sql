@@ -0,0 +1,17 @@
+CREATE TABLE seedling_examples (
+  id INTEGER PRIMARY KEY,
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id),
+  revision INTEGER NOT NULL DEFAULT 0,
+  service TEXT NOT NULL,
+  rpc TEXT NOT NULL,
+  request TEXT NOT NULL DEFAULT "",
+  response TEXT NOT NULL DEFAULT "",
+  http_method TEXT NOT NULL DEFAULT "",
+  http_path TEXT NOT NULL DEFAULT "",
+  valid BOOLEAN NOT NULL DEFAULT FALSE,
+  error TEXT NOT NULL DEFAULT "",
+  attempts INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX seedling_examples_seedling_id ON seedling_examples(seedling_id);
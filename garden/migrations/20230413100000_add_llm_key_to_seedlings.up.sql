@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN llm_key_id TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN llm_key TEXT NOT NULL DEFAULT "";
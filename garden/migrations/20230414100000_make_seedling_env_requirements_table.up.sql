@@ -0,0 +1,10 @@
+CREATE TABLE seedling_env_requirements (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id),
+  name TEXT NOT NULL,
+  required BOOLEAN NOT NULL DEFAULT TRUE,
+  description TEXT NOT NULL DEFAULT "",
+  provided BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  modified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (seedling_id, name)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE seedling_step_statuses (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id),
+  step TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT "pending",
+  error TEXT NOT NULL DEFAULT "",
+  started_at TIMESTAMP,
+  finished_at TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (seedling_id, step)
+);
@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
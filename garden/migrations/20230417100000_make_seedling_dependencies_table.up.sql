@@ -0,0 +1,7 @@
+CREATE TABLE seedling_dependencies (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id),
+  depends_on_id INTEGER NOT NULL REFERENCES seedlings(id),
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE (seedling_id, depends_on_id)
+);
+CREATE INDEX seedling_dependencies_depends_on_id ON seedling_dependencies(depends_on_id);
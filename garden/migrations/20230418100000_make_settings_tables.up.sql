@@ -0,0 +1,14 @@
+CREATE TABLE settings (
+  key TEXT PRIMARY KEY,
+  value REAL NOT NULL,
+  updated_by TEXT NOT NULL DEFAULT "",
+  updated_at TIMESTAMP NOT NULL
+);
+CREATE TABLE setting_changes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  key TEXT NOT NULL,
+  old_value REAL NOT NULL,
+  new_value REAL NOT NULL,
+  changed_by TEXT NOT NULL DEFAULT "",
+  changed_at TIMESTAMP NOT NULL
+);
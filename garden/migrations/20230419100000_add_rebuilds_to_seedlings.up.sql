@@ -0,0 +1,17 @@
+ALTER TABLE seedlings ADD COLUMN rebuild_schedule TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN rebuild_due_at TIMESTAMP;
+ALTER TABLE seedlings ADD COLUMN auto_heal BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE seedlings ADD COLUMN bitrot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE seedlings ADD COLUMN bitrot_reason TEXT NOT NULL DEFAULT "";
+CREATE INDEX seedlings_rebuild_due_at ON seedlings(rebuild_due_at);
+CREATE TABLE seedling_rebuilds (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  commit_sha TEXT NOT NULL DEFAULT "",
+  succeeded BOOLEAN NOT NULL,
+  failed_step TEXT NOT NULL DEFAULT "",
+  output TEXT NOT NULL DEFAULT "",
+  started_at TIMESTAMP NOT NULL,
+  finished_at TIMESTAMP NOT NULL
+);
+CREATE INDEX seedling_rebuilds_seedling_id ON seedling_rebuilds(seedling_id);
@@ -0,0 +1,6 @@
+CREATE TABLE seedling_embeddings (
+  seedling_id INTEGER PRIMARY KEY REFERENCES seedlings(id) ON DELETE CASCADE,
+  model TEXT NOT NULL,
+  vector BLOB NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
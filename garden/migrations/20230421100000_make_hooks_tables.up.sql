@@ -0,0 +1,27 @@
+CREATE TABLE hooks (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  garden TEXT NOT NULL DEFAULT "",
+  position INTEGER NOT NULL DEFAULT 0,
+  kind TEXT NOT NULL,
+  command TEXT NOT NULL DEFAULT "",
+  url TEXT NOT NULL DEFAULT "",
+  secret TEXT NOT NULL DEFAULT "",
+  timeout_seconds INTEGER NOT NULL DEFAULT 0,
+  blocking BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  modified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE seedling_hooks (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  hook_id INTEGER NOT NULL REFERENCES hooks(id) ON DELETE CASCADE,
+  commit_sha TEXT NOT NULL DEFAULT "",
+  status TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  output TEXT NOT NULL DEFAULT "",
+  error TEXT NOT NULL DEFAULT "",
+  started_at TIMESTAMP NOT NULL,
+  finished_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (seedling_id, hook_id)
+);
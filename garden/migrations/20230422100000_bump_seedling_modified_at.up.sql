@@ -0,0 +1,4 @@
+CREATE TRIGGER seedlings_modified_at AFTER UPDATE ON seedlings
+WHEN new.modified_at IS old.modified_at BEGIN
+  UPDATE seedlings SET modified_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE id = new.id;
+END;
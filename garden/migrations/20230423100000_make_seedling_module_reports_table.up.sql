@@ -0,0 +1,9 @@
+CREATE TABLE seedling_module_reports (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  revision INTEGER NOT NULL,
+  modules TEXT NOT NULL,
+  vulns TEXT NOT NULL DEFAULT "",
+  vulncheck_error TEXT NOT NULL DEFAULT "",
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (seedling_id, revision)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN trace_id TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedling_attempts ADD COLUMN trace_id TEXT NOT NULL DEFAULT "";
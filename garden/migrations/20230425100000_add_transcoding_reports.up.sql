@@ -0,0 +1 @@
+ALTER TABLE seedling_attempts ADD COLUMN transcoding_report TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE seedlings ADD COLUMN hardened BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE seedlings ADD COLUMN promotion TEXT;
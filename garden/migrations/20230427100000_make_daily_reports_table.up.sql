@@ -0,0 +1,13 @@
+CREATE INDEX seedlings_created_at ON seedlings(created_at);
+CREATE INDEX seedling_attempts_created_at ON seedling_attempts(created_at);
+CREATE INDEX seedling_events_type_created_at ON seedling_events(type, created_at);
+CREATE TABLE daily_reports (
+  date TEXT PRIMARY KEY,
+  repo_bytes INTEGER NOT NULL DEFAULT 0,
+  outputs_bytes INTEGER NOT NULL DEFAULT 0,
+  image_bytes INTEGER NOT NULL DEFAULT 0,
+  archive_bytes INTEGER NOT NULL DEFAULT 0,
+  measured_at TIMESTAMP NOT NULL,
+  delivered_at TIMESTAMP,
+  delivery_error TEXT NOT NULL DEFAULT ""
+);
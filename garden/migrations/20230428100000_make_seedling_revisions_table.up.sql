@@ -0,0 +1,17 @@
+CREATE TABLE seedling_revisions (
+  seedling_id INTEGER NOT NULL REFERENCES seedlings(id) ON DELETE CASCADE,
+  number INTEGER NOT NULL,
+  commit_sha TEXT NOT NULL,
+  image_id TEXT NOT NULL DEFAULT "",
+  grpc_port INTEGER NOT NULL DEFAULT 0,
+  http_port INTEGER NOT NULL DEFAULT 0,
+  models TEXT NOT NULL DEFAULT "",
+  template TEXT NOT NULL DEFAULT "",
+  template_hash TEXT NOT NULL DEFAULT "",
+  toolchain TEXT NOT NULL DEFAULT "",
+  platform TEXT NOT NULL DEFAULT "",
+  committed_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (seedling_id, number)
+);
+ALTER TABLE seedlings ADD COLUMN current_revision INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE seedlings ADD COLUMN stale_description BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+ALTER TABLE seedlings ADD COLUMN approval_required TEXT;
+ALTER TABLE seedlings ADD COLUMN approval_step TEXT NOT NULL DEFAULT "";
+ALTER TABLE seedlings ADD COLUMN approval_requested_at TIMESTAMP;
+ALTER TABLE seedlings ADD COLUMN approval_comment TEXT NOT NULL DEFAULT "";
+ALTER TABLE gardens ADD COLUMN approval_required TEXT;
@@ -0,0 +1 @@
+DROP INDEX seedlings_garden_name;
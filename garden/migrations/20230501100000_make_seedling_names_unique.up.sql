@@ -0,0 +1,3 @@
+UPDATE seedlings SET name = name || '-' || id
+WHERE id NOT IN (SELECT MIN(id) FROM seedlings GROUP BY garden, name);
+CREATE UNIQUE INDEX seedlings_garden_name ON seedlings(garden, name);
@@ -1,3 +1,3 @@
 #!/bin/bash
 
-migrate -path ./migrations -database sqlite3://garden.sqlite3 up
+migrate -path ./migrations -database "sqlite3://${DATA_DIR:-.}/garden.sqlite3" up
@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// approvalGates returns the steps the seedling's build has to stop after to
+// be approved: its own, or its garden's.
+func (s *Pipeline) approvalGates(ctx context.Context, seedling store.Seedling) (map[string]bool, error) {
+	steps := seedling.ApprovalRequired
+	if steps == nil {
+		if err := s.DB.GetContext(ctx, &steps,
+			"SELECT approval_required FROM gardens WHERE name = $1", seedling.GardenName()); err != nil {
+			return nil, err
+		}
+	}
+	gates := map[string]bool{}
+	for _, step := range steps {
+		gates[step] = true
+	}
+	return gates, nil
+}
+
+// approvalFiles are the files of the seedling's repo a step generates, for
+// its approval to be reviewed from.
+func (s *Pipeline) approvalFiles(seedling store.Seedling, step string) []string {
+	switch step {
+	case SeedlingStepProtobufs:
+		return []string{"protobufs/" + seedling.Name + ".proto"}
+	case SeedlingStepServer:
+		// A server written file by file has more than main.go.
+		files := []string{}
+		paths, _ := filepath.Glob(filepath.Join(s.RepoDir(seedling), "server", "*.go"))
+		for _, path := range paths {
+			if !strings.HasSuffix(path, "_test.go") {
+				files = append(files, "server/"+filepath.Base(path))
+			}
+		}
+		sort.Strings(files)
+		return files
+	case SeedlingStepServerTests:
+		return []string{"server/main_test.go"}
+	case SeedlingStepDockerfile:
+		return []string{"Dockerfile"}
+	}
+	return []string{}
+}
+
+// awaitApproval parks a seedling whose build just got through step at
+// SeedlingStepAwaitingApproval until what the step generated is approved or
+// rejected. The rejection comment it was regenerated with, if any, is
+// cleared.
+func (s *Pipeline) awaitApproval(ctx context.Context, seedling store.Seedling, step string) error {
+	now := time.Now()
+	if _, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, approval_step = $3, approval_requested_at = $2, approval_comment = '',
+	   version = version + 1
+	 WHERE id = $4
+	 `, SeedlingStepAwaitingApproval, now, step, seedling.ID); err != nil {
+		return err
+	}
+	files := []string{}
+	for _, path := range s.approvalFiles(seedling, step) {
+		files = append(files, SeedlingPath(seedling.ID)+"/files/"+path)
+	}
+	logrus.WithField("name", seedling.Name).WithField("step", step).Info("Seedling is waiting for approval")
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventApprovalRequested, Step: step, Payload: EventPayload{"files": files}})
+	s.Notify(ctx, seedling, EventStepChanged, SeedlingStepAwaitingApproval)
+	return nil
+}
+
+// clearApproval forgets the step the seedling was rejected at, and the
+// comment it was rejected with, once the step got past a build that didn't
+// stop for it to be approved.
+func (s *Pipeline) clearApproval(ctx context.Context, seedling *store.Seedling) {
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET approval_step = '', approval_comment = '', version = version + 1 WHERE id = $1",
+		seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to clear seedling approval")
+		return
+	}
+	seedling.ApprovalStep, seedling.ApprovalComment = "", ""
+}
+
+// withRejection adds the comment the step's last artifact was rejected
+// with to its prompt, before the fence the model writes the code after.
+func withRejection(prompt, comment string) string {
+	hint := "\nA reviewer rejected the last version of this with this comment, address it:\n\n" + comment + "\n\n"
+	if i := strings.LastIndex(prompt, "```"); i != -1 {
+		return prompt[:i] + hint + prompt[i:]
+	}
+	return prompt + hint
+}
@@ -0,0 +1,154 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// MAX_DIFF_BYTES is the largest attempt content that's diffed.
+	MAX_DIFF_BYTES = 1 << 20
+)
+
+type DiffStat struct {
+	FilesChanged int `json:"filesChanged"`
+	Additions    int `json:"additions"`
+	Deletions    int `json:"deletions"`
+}
+
+type AttemptDiff struct {
+	Attempt *store.Attempt `json:"attempt"`
+	Against *store.Attempt `json:"against,omitempty"`
+	// Diff is a unified diff from Against to Attempt, empty when Unavailable
+	// is set.
+	Diff        string    `json:"diff"`
+	Stat        *DiffStat `json:"stat,omitempty"`
+	Unavailable string    `json:"unavailable,omitempty"`
+}
+
+// attemptCode is what an attempt wrote to its file: the code extracted from
+// the completion, or the completion itself if there wasn't any.
+func attemptCode(completion, lang string) string {
+	if code, _ := extractCode(completion, lang); code != "" {
+		return code
+	}
+	return completion
+}
+
+// diffable returns why content can't be diffed, or "" if it can.
+func diffable(content string) string {
+	if len(content) > MAX_DIFF_BYTES {
+		return "diff unavailable: content too large"
+	}
+	if !utf8.ValidString(content) || strings.IndexByte(content, 0) != -1 {
+		return "diff unavailable: binary content"
+	}
+	return ""
+}
+
+// DiffAttempts diffs the code of two attempts. from may be nil, in which
+// case everything in to was added.
+func DiffAttempts(from, to *store.Attempt) (AttemptDiff, error) {
+	result := AttemptDiff{Attempt: to, Against: from}
+	fromFile, fromCode := "/dev/null", ""
+	if from != nil {
+		fromFile, fromCode = from.File, from.Code
+	}
+	for _, code := range []string{fromCode, to.Code} {
+		if reason := diffable(code); reason != "" {
+			result.Unavailable = reason
+			return result, nil
+		}
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(fromCode),
+		B:        splitLines(to.Code),
+		FromFile: fromFile,
+		ToFile:   to.File,
+		Context:  3,
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Diff = diff
+	result.Stat = ParseDiffStat(diff)
+	return result, nil
+}
+
+// splitLines splits text after each newline. Unlike difflib.SplitLines it
+// doesn't add an empty last line when text ends in a newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func ParseDiffStat(diff string) *DiffStat {
+	stat := &DiffStat{}
+	if diff == "" {
+		return stat
+	}
+	stat.FilesChanged = 1
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			stat.Additions++
+		case strings.HasPrefix(line, "-"):
+			stat.Deletions++
+		}
+	}
+	return stat
+}
+
+// publishAttemptDiff records the last successful attempt at the step in the
+// seedling's history with its diff stat against the previous attempt at it.
+func (s *Pipeline) publishAttemptDiff(ctx context.Context, seedling store.Seedling, step string) {
+	var a store.Attempt
+	if err := s.DB.GetContext(ctx, &a,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 AND step = $2 ORDER BY id DESC LIMIT 1", seedling.ID, step); err != nil {
+		logrus.WithField("error", err).Error("failed to get attempt")
+		return
+	}
+	prev, err := s.PreviousAttempt(ctx, &a)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to get previous attempt")
+		return
+	}
+	diff, err := DiffAttempts(prev, &a)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to diff attempts")
+		return
+	}
+	s.Emit(ctx, seedling.ID, SeedlingEvent{
+		Type:     EventAttemptSucceeded,
+		Step:     a.Step,
+		DiffStat: diff.Stat,
+		Payload:  EventPayload{"attempt": a.Attempt, "commitSha": a.CommitSHA},
+	})
+}
+
+// emitAttemptFailed records why an attempt failed in the seedling's history.
+// Only the first line of the error is kept; the output is in the attempt.
+func (s *Pipeline) emitAttemptFailed(ctx context.Context, seedling store.Seedling, a store.Attempt, err error) {
+	reason := strings.TrimSpace(err.Error())
+	if i := strings.Index(reason, "\n"); i >= 0 {
+		reason = reason[:i]
+	}
+	payload := EventPayload{"attempt": a.Attempt, "reason": EventText(reason)}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		payload["exitCode"] = exitErr.ExitCode()
+	}
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventAttemptFailed, Step: a.Step, Payload: payload})
+}
@@ -0,0 +1,29 @@
+package pipeline
+
+import (
+	"context"
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+
+	// AnonymousKey is who actions are attributed to when API keys aren't
+	// configured.
+	AnonymousKey = "anonymous"
+)
+
+type apiKeyKey struct{}
+
+// APIKeyFromContext returns the name of the API key the request was made
+// with.
+func APIKeyFromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(apiKeyKey{}).(string); ok {
+		return name
+	}
+	return AnonymousKey
+}
+
+// WithAPIKey is ctx of a request made with the API key called name.
+func WithAPIKey(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, name)
+}
@@ -0,0 +1,35 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/tensorscale/garden/garden/blobs"
+)
+
+const (
+	BlobStoreLocal = "local"
+	BlobStoreS3    = "s3"
+
+	// BlobRoot is where the local blob store keeps blobs, under DATA_DIR.
+	// Seedlings' outputs are written under it by their containers whichever
+	// store is used.
+	BlobRoot = "bucket"
+)
+
+// NewBlobStore returns the store BLOB_STORE selects.
+func NewBlobStore(config Config) (blobs.BlobStore, error) {
+	switch config.BlobStore {
+	case BlobStoreLocal:
+		return blobs.NewLocal(config.DataPath(BlobRoot)), nil
+	case BlobStoreS3:
+		return blobs.NewS3(blobs.S3Options{
+			Endpoint:  config.S3Endpoint,
+			Bucket:    config.S3Bucket,
+			AccessKey: config.S3AccessKey,
+			SecretKey: config.S3SecretKey,
+			Region:    config.S3Region,
+			UseSSL:    config.S3UseSSL,
+		})
+	}
+	return nil, fmt.Errorf("unknown BLOB_STORE %q, want %q or %q", config.BlobStore, BlobStoreLocal, BlobStoreS3)
+}
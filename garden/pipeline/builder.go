@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	BuildRunnerHost   = "host"
+	BuildRunnerDocker = "docker"
+
+	DefaultBuilderImage = "garden-builder"
+	// BuilderBuildCacheVolume is the Go build cache shared by builder
+	// containers. Their module cache is ModCacheDir.
+	BuilderBuildCacheVolume = "garden-builder-gocache"
+)
+
+var (
+	//go:embed builder/Dockerfile
+	builderDockerfile []byte
+)
+
+// BuildSpec is a command to run against a seedling's repo. Env is added to
+// the runner's own environment. Toolchain is the seedling's Go version,
+// the runner's default if empty.
+type BuildSpec struct {
+	Dir       string
+	Env       []string
+	Name      string
+	Args      []string
+	Toolchain string
+}
+
+// BuildRunner decides where the commands that build generated code run. The
+// command it returns is run like any other, so output and errors reach the
+// fix loop the same way whichever runner made it.
+type BuildRunner interface {
+	Command(ctx context.Context, spec BuildSpec) (*exec.Cmd, error)
+}
+
+// HostRunner runs commands directly on the host with whatever toolchain is
+// installed there, so only the go directive pins the seedling's version.
+type HostRunner struct {
+	Env []string
+}
+
+func (r HostRunner) Command(ctx context.Context, spec BuildSpec) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, spec.Name, spec.Args...)
+	cmd.Dir = spec.Dir
+	cmd.Env = append(append([]string{}, r.Env...), spec.Env...)
+	return cmd, nil
+}
+
+// dockerRunner runs each command in a disposable builder container with the
+// repo mounted at /src. Modules can only be fetched from the module proxy,
+// since GOPROXY has no direct fallback; Network can restrict everything
+// else, e.g. to a docker network whose only egress is the proxy.
+type dockerRunner struct {
+	image   string
+	network string
+	cpus    string
+	memory  string
+	// timeout bounds each command, in the container so it also stops
+	// builds whose docker client was killed.
+	timeoutSeconds int
+	goProxy        string
+	// ModCacheStats is the host's GOMODCACHE, mounted at /gomod.
+	modCache string
+	// toolchain is the Go version of specs without one, whose builder image
+	// image is tagged with unless it has a tag.
+	toolchain string
+	images    *builderImages
+}
+
+func (r dockerRunner) Command(ctx context.Context, spec BuildSpec) (*exec.Cmd, error) {
+	dir, err := filepath.Abs(spec.Dir)
+	if err != nil {
+		return nil, err
+	}
+	version := spec.Toolchain
+	if version == "" {
+		version = r.toolchain
+	}
+	if err := r.images.Ensure(ctx, r.image, version); err != nil {
+		return nil, err
+	}
+	args := []string{"run", "--rm", "--init",
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-v", dir + ":/src",
+		"-w", "/src",
+		"-v", r.modCache + ":/gomod",
+		"-v", BuilderBuildCacheVolume + ":/gocache",
+		"-e", "GOPROXY=" + r.goProxy,
+	}
+	if r.network != "" {
+		args = append(args, "--network", r.network)
+	}
+	if r.cpus != "" {
+		args = append(args, "--cpus", r.cpus)
+	}
+	if r.memory != "" {
+		args = append(args, "--memory", r.memory)
+	}
+	for _, kv := range spec.Env {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, builderImageFor(r.image, version))
+	if r.timeoutSeconds > 0 {
+		// timeout exits 124 without saying why, which the model couldn't
+		// do anything with.
+		args = append(args, "sh", "-c", fmt.Sprintf(
+			`timeout --kill-after=10s %d "$@"; rc=$?; [ $rc -eq 124 ] && echo "build timed out after %ds" >&2; exit $rc`,
+			r.timeoutSeconds, r.timeoutSeconds), "sh")
+	}
+	args = append(args, spec.Name)
+	args = append(args, spec.Args...)
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	// Dir is also where the results are committed from.
+	cmd.Dir = spec.Dir
+	return cmd, nil
+}
+
+// NewBuildRunner returns the runner BUILD_RUNNER selects.
+func (s *Pipeline) NewBuildRunner() BuildRunner {
+	if s.Config.BuildRunner == BuildRunnerHost {
+		return HostRunner{Env: s.BuildEnv()}
+	}
+	return dockerRunner{
+		image:          s.Config.BuilderImage,
+		network:        s.Config.BuilderNetwork,
+		cpus:           s.Config.BuilderCPUs,
+		memory:         s.Config.BuilderMemory,
+		timeoutSeconds: int(s.Config.BuilderTimeout.Seconds()),
+		goProxy:        s.Config.BuilderGoProxy,
+		modCache:       s.Config.ModCacheDir,
+		toolchain:      s.Config.GoToolchain,
+		images:         NewBuilderImages(s.Docker),
+	}
+}
@@ -0,0 +1,177 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MAX_BUILD_OUTPUT_LINE is how much of a single line of build output is
+// kept, so a build printing one endless line can't hold it all either.
+const MAX_BUILD_OUTPUT_LINE = 4096
+
+// firstErrorRegex matches the line of a build's output its first error is
+// most likely reported on.
+var firstErrorRegex = regexp.MustCompile(`Error:|ERROR:|error:|error\[`)
+
+// lineRing keeps the last n non-empty lines written to it, and the first
+// line that looks like an error: the error a build's later failures follow
+// from is often long scrolled out of its tail.
+type lineRing struct {
+	lines []string
+	// added is how many lines have been added, the last len(lines) of
+	// which are kept.
+	added int
+	// firstErr is the first error line, and firstErrAt the number of lines
+	// added before it.
+	firstErr   string
+	firstErrAt int
+}
+
+func newLineRing(n int) *lineRing {
+	if n < 1 {
+		n = 1
+	}
+	return &lineRing{lines: make([]string, n)}
+}
+
+func (lr *lineRing) add(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if lr.firstErr == "" && firstErrorRegex.MatchString(line) {
+		lr.firstErr, lr.firstErrAt = line, lr.added
+	}
+	lr.lines[lr.added%len(lr.lines)] = line
+	lr.added++
+}
+
+// String is the kept lines, after the first error line if it's scrolled out
+// of them, each ending with a newline.
+func (lr *lineRing) String() string {
+	var b strings.Builder
+	first := lr.added - len(lr.lines)
+	if first < 0 {
+		first = 0
+	}
+	if lr.firstErr != "" && lr.firstErrAt < first {
+		b.WriteString(lr.firstErr + "\n...\n")
+	}
+	for i := first; i < lr.added; i++ {
+		b.WriteString(lr.lines[i%len(lr.lines)] + "\n")
+	}
+	return b.String()
+}
+
+// ErrorTail is the part of a failed build's output a fix prompt quotes: its
+// last n non-empty lines, and its first error line.
+func ErrorTail(output string, n int) string {
+	ring := newLineRing(n)
+	for _, line := range strings.Split(output, "\n") {
+		ring.add(line)
+	}
+	return ring.String()
+}
+
+// buildOutput is the writer a build command's output is streamed to instead
+// of buffered whole. It hands each line to onLine as it's printed, and keeps
+// the last maxBytes of the output for the attempt along with its first
+// error line, which ErrorTail then finds in what's kept. A maxBytes of zero
+// keeps all of it.
+type buildOutput struct {
+	maxBytes int
+	onLine   func(line string)
+
+	// kept is the tail of the output, which may start mid-line once more
+	// than maxBytes have been written.
+	kept    []byte
+	elided  int64
+	partial []byte
+	// firstErr is the first complete error line, kept whatever's elided.
+	firstErr string
+}
+
+func NewBuildOutput(maxBytes int, onLine func(line string)) *buildOutput {
+	return &buildOutput{maxBytes: maxBytes, onLine: onLine}
+}
+
+// Write keeps p and hands on the lines it completes. Build commands write
+// their stdout and stderr to the same buildOutput, which exec.Cmd then
+// never calls concurrently.
+func (o *buildOutput) Write(p []byte) (int, error) {
+	o.kept = append(o.kept, p...)
+	// Trimming only once twice the cap is exceeded keeps the copying
+	// proportional to what's written.
+	if o.maxBytes > 0 && len(o.kept) > 2*o.maxBytes {
+		drop := len(o.kept) - o.maxBytes
+		o.elided += int64(drop)
+		o.kept = append(o.kept[:0], o.kept[drop:]...)
+	}
+
+	rest := p
+	for len(rest) > 0 {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			o.appendPartial(rest)
+			break
+		}
+		o.appendPartial(rest[:i])
+		o.line(string(o.partial))
+		o.partial = o.partial[:0]
+		rest = rest[i+1:]
+	}
+	return len(p), nil
+}
+
+func (o *buildOutput) appendPartial(p []byte) {
+	if room := MAX_BUILD_OUTPUT_LINE - len(o.partial); room < len(p) {
+		if room < 0 {
+			room = 0
+		}
+		p = p[:room]
+	}
+	o.partial = append(o.partial, p...)
+}
+
+func (o *buildOutput) line(line string) {
+	if o.firstErr == "" && firstErrorRegex.MatchString(line) {
+		o.firstErr = line
+	}
+	if o.onLine != nil {
+		o.onLine(line)
+	}
+}
+
+// Close hands on the output's last line if it didn't end with a newline.
+func (o *buildOutput) Close() {
+	if len(o.partial) > 0 {
+		o.line(string(o.partial))
+		o.partial = o.partial[:0]
+	}
+}
+
+// String is the kept output. When some of it was elided that's noted in its
+// place, after the first error line if it was among what was elided.
+func (o *buildOutput) String() string {
+	kept, elided := o.kept, o.elided
+	if o.maxBytes > 0 && len(kept) > o.maxBytes {
+		elided += int64(len(kept) - o.maxBytes)
+		kept = kept[len(kept)-o.maxBytes:]
+	}
+	if elided == 0 {
+		return string(kept)
+	}
+	// The first line kept is likely cut short.
+	if i := bytes.IndexByte(kept, '\n'); i >= 0 {
+		elided += int64(i + 1)
+		kept = kept[i+1:]
+	}
+	var b strings.Builder
+	if o.firstErr != "" && !bytes.Contains(kept, []byte(o.firstErr)) {
+		b.WriteString(o.firstErr + "\n")
+	}
+	fmt.Fprintf(&b, "... %d bytes of output elided ...\n", elided)
+	b.Write(kept)
+	return b.String()
+}
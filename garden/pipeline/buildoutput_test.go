@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestErrorTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		n      int
+		want   string
+	}{
+		{
+			name:   "shorter than the tail",
+			output: "step 1/3\nstep 2/3\n",
+			n:      5,
+			want:   "step 1/3\nstep 2/3\n",
+		},
+		{
+			name:   "empty lines skipped",
+			output: "a\n\n  \nb\n\n\nc\n\n",
+			n:      2,
+			want:   "b\nc\n",
+		},
+		{
+			name:   "first error scrolled out",
+			output: "go: downloading x\n./main.go:3:2: error: undefined: foo\nnote 1\nnote 2\nnote 3\n",
+			n:      2,
+			want:   "./main.go:3:2: error: undefined: foo\n...\nnote 2\nnote 3\n",
+		},
+		{
+			name:   "first error in the tail",
+			output: "ok\nError: exit status 1\ndone\n",
+			n:      2,
+			want:   "Error: exit status 1\ndone\n",
+		},
+		{
+			name:   "only the first error",
+			output: "error[E0425]: first\nERROR: second\nlast\n",
+			n:      1,
+			want:   "error[E0425]: first\n...\nlast\n",
+		},
+		{
+			name:   "no error",
+			output: "1\n2\n3\n4\n",
+			n:      2,
+			want:   "3\n4\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorTail(tt.output, tt.n); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeChunks writes output to o in chunks of size, which split its lines
+// wherever they fall, as a pipe does.
+func writeChunks(o *buildOutput, output string, size int) {
+	for len(output) > 0 {
+		n := size
+		if n > len(output) {
+			n = len(output)
+		}
+		o.Write([]byte(output[:n]))
+		output = output[n:]
+	}
+	o.Close()
+}
+
+func TestBuildOutputLarge(t *testing.T) {
+	const maxBytes = 64 << 10
+	const firstErr = "#12 0.523 go: example.com/broken@v1.0.0: Error: module not found"
+	var b strings.Builder
+	b.WriteString("#1 [internal] load build definition\n" + firstErr + "\n")
+	for i := 0; b.Len() < 8<<20; i++ {
+		fmt.Fprintf(&b, "#12 %d.000 go: downloading example.com/dep%d v1.%d.0\n", i, i, i)
+	}
+	b.WriteString("#12 ERROR: process did not complete successfully: exit code: 1")
+	output := b.String()
+
+	lines, longest := 0, 0
+	var last string
+	o := NewBuildOutput(maxBytes, func(line string) {
+		lines++
+		if len(line) > longest {
+			longest = len(line)
+		}
+		last = line
+	})
+	for rest := output; len(rest) > 0; {
+		n := 32 << 10
+		if n > len(rest) {
+			n = len(rest)
+		}
+		o.Write([]byte(rest[:n]))
+		rest = rest[n:]
+		// Only a bounded amount of the output is held as it's written.
+		if len(o.kept) > 2*maxBytes+n {
+			t.Fatalf("%d bytes held", len(o.kept))
+		}
+	}
+	o.Close()
+
+	// Every line is streamed, including the last without a newline.
+	if want := strings.Count(output, "\n") + 1; lines != want {
+		t.Errorf("%d lines streamed, want %d", lines, want)
+	}
+	if !strings.HasPrefix(last, "#12 ERROR: process did not complete") {
+		t.Errorf("last line streamed %q", last)
+	}
+
+	kept := o.String()
+	if len(kept) > maxBytes+len(firstErr)+100 {
+		t.Errorf("%d bytes kept of %d, with a cap of %d", len(kept), len(output), maxBytes)
+	}
+	if !strings.HasPrefix(kept, firstErr+"\n... ") || !strings.Contains(kept, "bytes of output elided ...\n") {
+		t.Errorf("kept output doesn't start with the first error and what's elided: %q", kept[:200])
+	}
+	if !strings.HasSuffix(output, kept[strings.Index(kept, "elided ...\n")+len("elided ...\n"):]) {
+		t.Error("what's kept after the note isn't the tail of the output")
+	}
+	// The note is followed by whole lines.
+	if after := kept[strings.Index(kept, "elided ...\n")+len("elided ...\n"):]; !strings.HasPrefix(after, "#12 ") {
+		t.Errorf("kept output starts mid-line: %q", after[:40])
+	}
+
+	// The fix prompt's tail is still led by the first error.
+	tail := ErrorTail(kept, 25)
+	if !strings.HasPrefix(tail, firstErr+"\n...\n") || !strings.HasSuffix(tail, "exit code: 1\n") {
+		t.Errorf("error tail:\n%s", tail)
+	}
+	if n := strings.Count(tail, "\n"); n != 27 {
+		t.Errorf("error tail has %d lines, want 27", n)
+	}
+}
+
+func TestBuildOutputLongLine(t *testing.T) {
+	var lines []string
+	o := NewBuildOutput(1<<20, func(line string) { lines = append(lines, line) })
+	writeChunks(o, strings.Repeat("x", 5<<20)+"\nafter\n", 1<<16)
+	if len(lines) != 2 || len(lines[0]) != MAX_BUILD_OUTPUT_LINE || lines[1] != "after" {
+		t.Errorf("%d lines streamed, the first of %d bytes", len(lines), len(lines[0]))
+	}
+	if n := len(o.String()); n > 1<<20 {
+		t.Errorf("%d bytes kept", n)
+	}
+}
+
+func TestBuildOutputUncapped(t *testing.T) {
+	output := strings.Repeat("go: downloading example.com/dep v1.0.0\n", 100<<10)
+	o := NewBuildOutput(0, nil)
+	writeChunks(o, output, 4096)
+	if got := o.String(); got != output {
+		t.Errorf("kept %d bytes of %d", len(got), len(output))
+	}
+
+	// Under the cap, the output is kept as is.
+	o = NewBuildOutput(len(output), nil)
+	writeChunks(o, output, 4096)
+	if got := o.String(); got != output {
+		t.Errorf("kept %d bytes of %d under the cap", len(got), len(output))
+	}
+}
@@ -0,0 +1,391 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// BuildLeaseTTL is how long a lease survives without a heartbeat before
+	// another holder may take it over.
+	BuildLeaseTTL = 2 * time.Minute
+	// BuildLeaseHeartbeat is how often an active build renews its lease.
+	BuildLeaseHeartbeat = 30 * time.Second
+)
+
+// BuildRegistry makes sure only one build runs per seedling, both within this
+// process and across processes sharing the database, by holding a lease row
+// for every active build.
+type BuildRegistry struct {
+	db     *sqlx.DB
+	holder string
+	// root is what the contexts of builds and of other work that outlives
+	// a request are derived from, see detach.
+	root context.Context
+
+	mu     sync.Mutex
+	active map[hide.Int64]*activeBuild
+	// orphans are the process groups of builds that have been released,
+	// by the seedling whose build started them, until reap finds them
+	// empty.
+	orphans map[int]hide.Int64
+}
+
+// ActiveBuild is the progress of a build running in this process.
+type ActiveBuild struct {
+	SeedlingID     hide.Int64 `json:"seedlingId"`
+	Name           string     `json:"name"`
+	Step           string     `json:"step"`
+	Attempt        int        `json:"attempt"`
+	StartedAt      time.Time  `json:"startedAt"`
+	CurrentCommand string     `json:"currentCommand,omitempty"`
+	LastGPTCallAt  *time.Time `json:"lastGptCallAt,omitempty"`
+}
+
+type activeBuild struct {
+	ActiveBuild
+	stop   chan struct{}
+	cancel context.CancelFunc
+	cmd    *exec.Cmd
+	// cmds are the commands the build is running, the current one and
+	// those of branch steps, and groups the process groups of those it's
+	// run, which children they left behind may still be in.
+	cmds   map[*exec.Cmd]bool
+	groups map[int]bool
+	// repo is held while committing to the seedling's repo, which steps
+	// running at the same time all commit to.
+	repo sync.Mutex
+}
+
+func NewBuildRegistry(db *sqlx.DB) *BuildRegistry {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &BuildRegistry{
+		db:      db,
+		holder:  fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), NewRequestID()[:8]),
+		root:    context.Background(),
+		active:  map[hide.Int64]*activeBuild{},
+		orphans: map[int]hide.Int64{},
+	}
+}
+
+type buildLinksKey struct{}
+
+// Detach returns a context for work on the seedling that outlives the
+// request in ctx, such as writing its repo and building it. It's derived
+// from the registry's root rather than ctx, so it isn't cancelled when the
+// request ends, and carries ctx's baggage with the seedling's ID and a link
+// to ctx's span, which the build's span starts with.
+func (br *BuildRegistry) Detach(ctx context.Context, seedling store.Seedling) context.Context {
+	links, _ := ctx.Value(buildLinksKey{}).([]trace.Link)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		links = append(append([]trace.Link{}, links...), trace.Link{SpanContext: sc})
+	}
+	detached := context.WithValue(br.root, buildLinksKey{}, links)
+	bag := baggage.FromContext(ctx)
+	if member, err := baggage.NewMember("seedling.id", PublicID(seedling.ID)); err == nil {
+		if bag, err = bag.SetMember(member); err != nil {
+			logrus.WithField("error", err).Warn("failed to add seedling id to baggage")
+		}
+	}
+	return baggage.ContextWithBaggage(detached, bag)
+}
+
+// StartBuildSpan starts a span of work on the seedling in a context from
+// detach, linked to the span of the request it was detached from.
+func StartBuildSpan(ctx context.Context, name string, seedling store.Seedling) (context.Context, trace.Span) {
+	links, _ := ctx.Value(buildLinksKey{}).([]trace.Link)
+	return otel.Tracer("garden").Start(ctx, name, trace.WithLinks(links...), trace.WithAttributes(
+		attribute.String("seedling.id", PublicID(seedling.ID)),
+		attribute.String("seedling.name", seedling.Name),
+		attribute.String("seedling.garden", seedling.GardenName()),
+		attribute.String("seedling.step", seedling.Step),
+	))
+}
+
+// traceID is the trace ctx's span is in, "" if it isn't sampled, e.g.
+// with OTel off.
+func traceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// TraceURL is where the trace id can be seen, from TRACE_URL_TEMPLATE, ""
+// if either isn't set.
+func (s *Pipeline) TraceURL(id string) string {
+	if id == "" || s.Config.TraceURLTemplate == "" {
+		return ""
+	}
+	return strings.ReplaceAll(s.Config.TraceURLTemplate, "{traceId}", id)
+}
+
+// SubmitBuild queues the seedling's build, detached from the request in
+// ctx.
+func (s *Pipeline) SubmitBuild(ctx context.Context, seedling store.Seedling) {
+	s.Scheduler.Submit(s.BuildRegistry.Detach(ctx, seedling), seedling)
+}
+
+// TryAcquire takes the build lease for a seedling. It returns false if the
+// seedling is already being built here, or another holder has a live lease.
+// cancel stops the build if it's killed.
+func (br *BuildRegistry) TryAcquire(ctx context.Context, seedling store.Seedling, cancel context.CancelFunc) (bool, error) {
+	id := seedling.ID
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if _, ok := br.active[id]; ok {
+		return false, nil
+	}
+
+	now := time.Now()
+	result, err := br.db.ExecContext(ctx, `
+	 INSERT INTO build_leases (seedling_id, holder, acquired_at, heartbeat_at)
+	 VALUES ($1, $2, $3, $3)
+	 ON CONFLICT (seedling_id) DO UPDATE SET
+	   holder = excluded.holder,
+	   acquired_at = excluded.acquired_at,
+	   heartbeat_at = excluded.heartbeat_at
+	 WHERE build_leases.heartbeat_at < $4
+	 `, id, br.holder, now, now.Add(-BuildLeaseTTL))
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	stop := make(chan struct{})
+	br.active[id] = &activeBuild{
+		ActiveBuild: ActiveBuild{SeedlingID: id, Name: seedling.Name, Step: seedling.Step, StartedAt: now},
+		stop:        stop,
+		cancel:      cancel,
+		cmds:        map[*exec.Cmd]bool{},
+		groups:      map[int]bool{},
+	}
+	go br.heartbeat(id, stop)
+	return true, nil
+}
+
+// progress records the step and attempt a build is on.
+func (br *BuildRegistry) progress(id hide.Int64, step string, attempt int) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if b, ok := br.active[id]; ok {
+		b.Step = step
+		b.Attempt = attempt
+	}
+}
+
+// prompting records that a build is waiting on a completion.
+func (br *BuildRegistry) prompting(id hide.Int64) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if b, ok := br.active[id]; ok {
+		now := time.Now()
+		b.LastGPTCallAt = &now
+	}
+}
+
+// Running records the command a build is waiting on, or nil once it's done.
+// It's started like spawn's.
+func (br *BuildRegistry) Running(id hide.Int64, cmd *exec.Cmd) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	b, ok := br.active[id]
+	if !ok {
+		return
+	}
+	if b.cmd != nil {
+		b.exited(b.cmd)
+	}
+	b.cmd = cmd
+	b.CurrentCommand = ""
+	if cmd != nil {
+		b.spawn(cmd)
+		b.CurrentCommand = strings.Join(cmd.Args, " ")
+	}
+}
+
+// spawn records a command the build is about to run other than its current
+// one, until exited. It's started in its own process group so killing the
+// build kills everything it spawned.
+func (br *BuildRegistry) spawn(id hide.Int64, cmd *exec.Cmd) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if b, ok := br.active[id]; ok {
+		b.spawn(cmd)
+	}
+}
+
+// exited records that a command from spawn is done. Its process group is
+// still killed with the build.
+func (br *BuildRegistry) exited(id hide.Int64, cmd *exec.Cmd) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if b, ok := br.active[id]; ok {
+		b.exited(cmd)
+	}
+}
+
+func (b *activeBuild) spawn(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	b.cmds[cmd] = true
+}
+
+func (b *activeBuild) exited(cmd *exec.Cmd) {
+	delete(b.cmds, cmd)
+	if cmd.Process != nil {
+		b.groups[cmd.Process.Pid] = true
+	}
+}
+
+// killGroups kills the process groups of the commands the build is running
+// and has run, and returns them. Groups that are already gone are skipped,
+// as are those in running, whose leader's pid has been reused by a command
+// of another build.
+func (b *activeBuild) killGroups(running map[int]bool) []int {
+	for cmd := range b.cmds {
+		b.exited(cmd)
+	}
+	groups := []int{}
+	for pgid := range b.groups {
+		if running[pgid] {
+			continue
+		}
+		groups = append(groups, pgid)
+		if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			logrus.WithField("error", err).WithField("seedling_id", b.SeedlingID).WithField("pgid", pgid).Warn("failed to kill build process group")
+		}
+	}
+	return groups
+}
+
+// repoLock is the lock commits to the repo of the seedling being built are
+// made under.
+func (br *BuildRegistry) repoLock(id hide.Int64) *sync.Mutex {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if b, ok := br.active[id]; ok {
+		return &b.repo
+	}
+	return &sync.Mutex{}
+}
+
+// List returns the builds running in this process, oldest first.
+func (br *BuildRegistry) List() []ActiveBuild {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	builds := []ActiveBuild{}
+	for _, b := range br.active {
+		builds = append(builds, b.ActiveBuild)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].StartedAt.Before(builds[j].StartedAt) })
+	return builds
+}
+
+// Kill cancels a build running in this process and kills the commands it's
+// running. It returns false if there's no such build.
+func (br *BuildRegistry) Kill(id hide.Int64) bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	b, ok := br.active[id]
+	if !ok {
+		return false
+	}
+	b.cancel()
+	b.killGroups(br.runningGroups(id))
+	return true
+}
+
+// runningGroups returns the process groups of the commands running in builds
+// other than the seedling's. Callers hold mu.
+func (br *BuildRegistry) runningGroups(id hide.Int64) map[int]bool {
+	groups := map[int]bool{}
+	for other, b := range br.active {
+		if other == id {
+			continue
+		}
+		for cmd := range b.cmds {
+			if cmd.Process != nil {
+				groups[cmd.Process.Pid] = true
+			}
+		}
+	}
+	return groups
+}
+
+func (br *BuildRegistry) heartbeat(id hide.Int64, stop chan struct{}) {
+	ticker := time.NewTicker(BuildLeaseHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := br.db.Exec(
+				"UPDATE build_leases SET heartbeat_at = $1 WHERE seedling_id = $2 AND holder = $3",
+				time.Now(), id, br.holder,
+			); err != nil {
+				logrus.WithField("error", err).WithField("seedling_id", id).Error("failed to renew build lease")
+			}
+		}
+	}
+}
+
+// Release gives up the build lease for a seedling, if this registry holds it.
+// However the build ended, whatever its commands left running is killed,
+// and their process groups are left to reap.
+func (br *BuildRegistry) Release(id hide.Int64) {
+	br.mu.Lock()
+	b, ok := br.active[id]
+	delete(br.active, id)
+	if ok {
+		for _, pgid := range b.killGroups(br.runningGroups(id)) {
+			br.orphans[pgid] = id
+		}
+	}
+	br.mu.Unlock()
+	if !ok {
+		return
+	}
+	close(b.stop)
+
+	if _, err := br.db.Exec(
+		"DELETE FROM build_leases WHERE seedling_id = $1 AND holder = $2", id, br.holder,
+	); err != nil {
+		logrus.WithField("error", err).WithField("seedling_id", id).Error("failed to release build lease")
+	}
+}
+
+// Lease returns the current lease on a seedling, or nil if it has none.
+func (br *BuildRegistry) Lease(ctx context.Context, id hide.Int64) (*store.BuildLease, error) {
+	var lease store.BuildLease
+	if err := br.db.GetContext(ctx, &lease, "SELECT * FROM build_leases WHERE seedling_id = $1", id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &lease, nil
+}
@@ -0,0 +1,13 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"github.com/c2h5oh/hide"
+)
+
+// SeedlingPath is the API path of a seedling, with its ID obfuscated the way
+// the API expects.
+func SeedlingPath(id hide.Int64) string {
+	return "/api/v1/seedlings/" + strconv.FormatInt(hide.Default.Int64Obfuscate(int64(id)), 10)
+}
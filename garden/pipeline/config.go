@@ -0,0 +1,571 @@
+package pipeline
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	// DataDir holds everything the instance keeps on disk: the seedling
+	// repos under repos/, the local blob store under bucket/ and the
+	// garden.sqlite3 database. It's locked while the API runs so that a
+	// second instance can't share it.
+	DataDir string
+	// ListenAddr is where the API listens.
+	ListenAddr string
+	// NetworkName is the docker network of the default garden's seedlings;
+	// other gardens' are NetworkName-<garden>. ContainerPrefix is prepended
+	// to the names of seedling containers and images, so that instances
+	// sharing a docker daemon don't collide and their containers can be
+	// told apart.
+	NetworkName     string
+	ContainerPrefix string
+	// SQLiteBusyTimeout is how long a connection waits for another's lock
+	// before failing with "database is locked". API reads go through a pool
+	// of SQLiteReadConns read-only connections, so they aren't queued
+	// behind writes. The WAL is checkpointed and truncated every
+	// WALCheckpointInterval; 0 disables it.
+	SQLiteBusyTimeout     time.Duration
+	SQLiteReadConns       int
+	WALCheckpointInterval time.Duration
+	// BuildCache enables BuildKit inline caching, --cache-from and a shared Go
+	// module cache mount for seedling Docker builds. Disable it for Docker
+	// daemons without BuildKit.
+	BuildCache bool
+	// BaseImages are pulled at startup so the first Dockerfile attempt
+	// doesn't pay for the download.
+	BaseImages []string
+	// LogsFollowMaxDuration caps how long a single ?follow=true logs request
+	// may stay open, and LogsFollowMaxSessions how many may be open at once.
+	LogsFollowMaxDuration time.Duration
+	LogsFollowMaxSessions int
+	// BuildWorkers is how many seedlings are built concurrently.
+	BuildWorkers int
+	// ProcessReapInterval is how often processes left behind by builds
+	// that have ended are looked for and killed; 0 disables it.
+	ProcessReapInterval time.Duration
+	// BuildQueueMaxDepth is how many builds may wait for a worker before
+	// creates are refused with 429; 0 doesn't limit it. CreateSyncMaxWait is
+	// the longest a ?sync=true create waits for the first step.
+	BuildQueueMaxDepth int
+	CreateSyncMaxWait  time.Duration
+	// ContainerRuntime is how seedling containers are managed: "sdk" with
+	// the docker API at DOCKER_HOST, "cli" by running the docker binary for
+	// daemons the API client can't talk to. Image builds and build
+	// commands run the docker CLI either way.
+	ContainerRuntime string
+	// BlobStore is where archives and outputs are kept: "local" under
+	// bucket/, "s3" in S3Bucket at S3Endpoint, with outputs published there
+	// from bucket/outputs and downloaded by URLs signed for S3URLExpiry.
+	BlobStore   string
+	S3Endpoint  string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3Region    string
+	S3UseSSL    bool
+	S3URLExpiry time.Duration
+	// BuildRunner is where generated code is built: "docker" runs each
+	// build command in a BuilderImage container limited to BuilderCPUs,
+	// BuilderMemory and BuilderTimeout, fetching modules only from
+	// BuilderGoProxy; "host" runs them directly on the host. Docker builds of
+	// seedling images run on the host either way.
+	BuildRunner    string
+	BuilderImage   string
+	BuilderNetwork string
+	BuilderCPUs    string
+	BuilderMemory  string
+	BuilderTimeout time.Duration
+	BuilderGoProxy string
+	// GoToolchain is the Go version seedlings are built with unless they
+	// ask for another of GoToolchains. It's the go directive of their
+	// go.mod, the golang image their Dockerfile builds in and, with the
+	// docker runner, the tag of the default builder image their commands
+	// run in.
+	GoToolchain  string
+	GoToolchains []string
+	// ModCacheDir is the GOMODCACHE shared by every build command, on the
+	// host and mounted into builder containers, so each module is only
+	// downloaded once. Docker builds of seedling images share a BuildKit
+	// cache mount instead.
+	ModCacheDir string
+	// GCInterval is how often the GC policy runs; 0 disables it. Seedlings
+	// untouched for GCMaxAge are archived, then the least recently modified
+	// ones until the rest fit in GCMaxTotalBytes. A zero age or budget
+	// disables that part of the policy.
+	GCInterval      time.Duration
+	GCMaxAge        time.Duration
+	GCMaxTotalBytes int64
+	// DeletedRetention is how long soft-deleted seedlings can be restored
+	// for before the GC purges them.
+	DeletedRetention time.Duration
+	// GitRemoteURL is the remote seedling repos are pushed to when the create
+	// request doesn't name one; "{name}" is replaced with the seedling name.
+	// GitToken authenticates pushes for seedlings without a git-token secret.
+	GitRemoteURL      string
+	GitBranch         string
+	GitPushOnComplete bool
+	GitToken          string
+	// ToolsBin is the GOBIN protoc plugins and goimports are installed into
+	// when ToolsAutoInstall is set. It's put first on build commands' PATH.
+	ToolsBin         string
+	ToolsAutoInstall bool
+	// OutputsMaxBytes is how much a seedling's container may write to
+	// /outputs before it's stopped, checked every OutputsSweepInterval. Zero
+	// disables either.
+	OutputsMaxBytes      int64
+	OutputsSweepInterval time.Duration
+	// RepoFileMaxBytes caps each file garden writes to a seedling's repo and
+	// RepoMaxBytes the repo's files in all, without .git; writes over them
+	// are refused. Zero doesn't cap.
+	RepoFileMaxBytes int64
+	RepoMaxBytes     int64
+	// RebuildCheckInterval is how often seedlings with a rebuild schedule
+	// are checked for rebuilds that are due; 0 disables scheduled rebuilds.
+	RebuildCheckInterval time.Duration
+	// ApprovalTimeout is how long a seedling waits at
+	// SeedlingStepAwaitingApproval before it's failed, checked every
+	// ApprovalCheckInterval. Zero waits until it's reviewed.
+	ApprovalTimeout       time.Duration
+	ApprovalCheckInterval time.Duration
+	// BuildOutputMaxBytes is how much of a build command's output is kept
+	// for its attempt, the rest streamed past. Zero keeps all of it.
+	BuildOutputMaxBytes int
+	// Model is the model completions use unless Models names one for the
+	// step. ModelFallbacks are tried in order when a model's context is too
+	// small for the prompt or it doesn't exist.
+	Model          string
+	Models         map[string]string
+	ModelFallbacks []string
+	// ReadmeModel writes READMEs unless Models names one for
+	// SeedlingStepReadme; it only writes prose, so it can be a cheap one.
+	ReadmeModel string
+	// Embeddings embeds seedlings' descriptions with EmbeddingModel, when
+	// the provider can, to find similar seedlings. Creates of seedlings at
+	// least DuplicateSimilarity similar to an existing one of their garden
+	// are refused unless they ignore duplicates; 0 doesn't check.
+	Embeddings          bool
+	EmbeddingModel      string
+	DuplicateSimilarity float64
+	MaxTokens           int
+	// MaxTokensLimit is the most a completion cut off at its token limit is
+	// retried with, and the most a seedling can ask for.
+	MaxTokensLimit int
+	// Servers with ServerMultiFileRPCs methods or more are written file by
+	// file, in at most ServerMaxFiles files of ServerMaxBytes in all, each
+	// in its own completion; 0 always writes server/main.go alone.
+	ServerMultiFileRPCs int
+	ServerMaxFiles      int
+	ServerMaxBytes      int
+	// ChatModels are prompted through the chat API with the build's
+	// conversation, packed into ChatContextTokens, rather than through the
+	// completions API with one prompt.
+	ChatModels        []string
+	ChatContextTokens int
+	// APIKeys maps key names to keys. When set, management endpoints require
+	// one of the keys and actions such as quality overrides are attributed to
+	// its name.
+	APIKeys map[string]string
+	// AdminKeys are the names of the API keys allowed to use admin endpoints.
+	AdminKeys []string
+	// PromoteChecklist are the ids of the hardening checklist items
+	// promotions apply unless they list their own. An item whose check
+	// still fails after its refine is refined again, up to
+	// PromoteItemRefines times in all.
+	PromoteChecklist   []string
+	PromoteItemRefines int
+	// ReportTimeZone is the time zone days start in for daily reports. Each
+	// day's is due at ReportTime the day after, when the disk is measured
+	// for it and it's posted to ReportWebhookURL as a Slack message, if
+	// that's set. ModelPrices override DefaultModelPrices, in US dollars
+	// per 1,000 tokens.
+	ReportTimeZone   string
+	ReportTime       string
+	ReportWebhookURL string
+	ModelPrices      map[string]string
+	// CORSAllowedOrigins are the origins browsers may call the API and
+	// outputs from, "*" for any when APIKeys isn't set; none if it's
+	// empty. Preflights are answered with CORSAllowedMethods and
+	// CORSAllowedHeaders, cached for CORSMaxAge, and other responses let
+	// the page read CORSExposedHeaders and, with CORSAllowCredentials,
+	// send cookies and auth.
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSMaxAge           time.Duration
+	CORSAllowCredentials bool
+	// OpenAIKey is the key seedlings are built with, unless their API key
+	// has one in OpenAIKeys, which maps API key names to OpenAI keys, or
+	// they were created with one in X-OpenAI-Key. Those are stored
+	// encrypted with LLMKeySecret, 32 base64 encoded bytes, and refused
+	// without it.
+	OpenAIKey    string
+	OpenAIKeys   map[string]string
+	LLMKeySecret string
+	// LLMProvider is what completions are made with: "openai", "fixture"
+	// to replay the fixtures in FixturesDir, which are filed by seedling
+	// name, step and attempt, or "record" to call OpenAI and record them.
+	LLMProvider string
+	FixturesDir string
+	// RedactPatterns are regexps masked in transcripts on top of the
+	// built-in token patterns, separated by whitespace. A pattern with a
+	// "secret" group only has that group masked.
+	RedactPatterns []string
+	// ImportAllow and ImportDeny are patterns for the modules generated Go
+	// code may import, and ImportSuggestions maps patterns to alternatives
+	// the model is told to use instead. See ImportPolicy. They can be
+	// changed at runtime through /api/v1/admin/import-policy.
+	ImportAllow          []string
+	ImportDeny           []string
+	ImportSuggestions    map[string]string
+	ImportDeniedLicenses []string
+	// DependencyAdvisories flags modules in seedlings' dependency reports,
+	// keyed by module path, path@version or path@<version. Govulncheck
+	// checks the reports with govulncheck if it's installed.
+	DependencyAdvisories map[string]string
+	Govulncheck          bool
+	// DockerfileTemplate renders seedlings' Dockerfiles from a template
+	// with the apt packages the model lists, instead of having it write
+	// the whole file. Packages must match DockerfilePackageAllow if it's
+	// set, and with DockerfileAptCheck be known to apt-cache in the image
+	// installing them.
+	DockerfileTemplate     bool
+	DockerfilePackageAllow string
+	DockerfileAptCheck     bool
+	// TranscodingCheck checks that a server's HTTP handlers decode each
+	// rpc's request by the proto3 JSON names of its fields, failing the
+	// attempt if they don't unless TranscodingCheckAdvisory is set, when
+	// the mismatches are only reported.
+	TranscodingCheck         bool
+	TranscodingCheckAdvisory bool
+	// SymbolDocs quotes the declarations of the imported identifiers a
+	// server's build errors are about in the prompt to fix it, rather than
+	// the go doc of every package it imports.
+	SymbolDocs bool
+	// TraceURLTemplate is the URL of a build's trace, with {traceId} in
+	// it replaced by the trace's id. Without it only the ids are returned.
+	TraceURLTemplate string
+	// Metrics serves Prometheus metrics at /metrics, on the API's port
+	// unless MetricsAddr is set.
+	Metrics     bool
+	MetricsAddr string
+	// ContainerMemory, ContainerCPUs, ContainerPidsLimit and
+	// ContainerRestartPolicy are the limits seedling containers run with
+	// unless the create request sets them, up to ContainerMaxMemory,
+	// ContainerMaxCPUs and ContainerMaxPidsLimit.
+	ContainerMemory        string
+	ContainerCPUs          string
+	ContainerPidsLimit     int
+	ContainerRestartPolicy string
+	ContainerMaxMemory     string
+	ContainerMaxCPUs       float64
+	ContainerMaxPidsLimit  int
+	// SeedlingHost is the host seedling containers are reached on, as
+	// given in their endpoint documents.
+	SeedlingHost string
+	// GRPCSmokeTest checks with gRPC reflection that a complete seedling's
+	// container serves every rpc in its proto, and asks the server prompt to
+	// register reflection.
+	GRPCSmokeTest bool
+	// ExamplesMaxAttempts is how many times the example call of each of a
+	// complete seedling's rpcs is written before it's kept as invalid. 0
+	// doesn't write examples.
+	ExamplesMaxAttempts int
+	// ProvenanceStamping stamps generated files with a header comment saying
+	// which seedling, model and template they were written for, and records
+	// the model, attempt and hash of each in the repo's provenance.json.
+	ProvenanceStamping bool
+	// APIURL is where this API is reached, for links back to it.
+	APIURL string
+	// MarkersDataset is the Honeycomb dataset startup and build markers are
+	// sent to with MarkersAPIKey. Markers aren't sent without both.
+	MarkersDataset string
+	MarkersAPIKey  string
+}
+
+func LoadConfig() Config {
+	dataDir := envString("DATA_DIR", ".")
+	listenAddr := envString("LISTEN_ADDR", ":7777")
+	return Config{
+		DataDir:         dataDir,
+		ListenAddr:      listenAddr,
+		NetworkName:     envString("NETWORK_NAME", "seedlings"),
+		ContainerPrefix: os.Getenv("CONTAINER_PREFIX"),
+
+		SQLiteBusyTimeout:     envDuration("SQLITE_BUSY_TIMEOUT", 5*time.Second),
+		SQLiteReadConns:       envInt("SQLITE_READ_CONNS", 4),
+		WALCheckpointInterval: envDuration("WAL_CHECKPOINT_INTERVAL", 5*time.Minute),
+
+		BuildCache: envBool("BUILD_CACHE", true),
+		BaseImages: envList("BASE_IMAGES", []string{"debian:bookworm-slim"}),
+
+		LogsFollowMaxDuration: envDuration("LOGS_FOLLOW_MAX_DURATION", 10*time.Minute),
+		LogsFollowMaxSessions: envInt("LOGS_FOLLOW_MAX_SESSIONS", 10),
+
+		BuildWorkers:       envInt("BUILD_WORKERS", 4),
+		BuildQueueMaxDepth: envInt("BUILD_QUEUE_MAX_DEPTH", 100),
+		CreateSyncMaxWait:  envDuration("CREATE_SYNC_MAX_WAIT", 5*time.Minute),
+		ContainerRuntime:   envString("CONTAINER_RUNTIME", ContainerRuntimeSDK),
+
+		ProcessReapInterval: envDuration("PROCESS_REAP_INTERVAL", time.Minute),
+
+		BlobStore:   envString("BLOB_STORE", BlobStoreLocal),
+		S3Endpoint:  os.Getenv("S3_ENDPOINT"),
+		S3Bucket:    envString("S3_BUCKET", "garden"),
+		S3AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey: os.Getenv("S3_SECRET_KEY"),
+		S3Region:    os.Getenv("S3_REGION"),
+		S3UseSSL:    envBool("S3_USE_SSL", true),
+		S3URLExpiry: envDuration("S3_URL_EXPIRY", 15*time.Minute),
+
+		BuildRunner:    envString("BUILD_RUNNER", BuildRunnerDocker),
+		BuilderImage:   envString("BUILDER_IMAGE", DefaultBuilderImage),
+		BuilderNetwork: os.Getenv("BUILDER_NETWORK"),
+		BuilderCPUs:    envString("BUILDER_CPUS", "2"),
+		BuilderMemory:  envString("BUILDER_MEMORY", "2g"),
+		BuilderTimeout: envDuration("BUILDER_TIMEOUT", 10*time.Minute),
+		BuilderGoProxy: envString("BUILDER_GOPROXY", "https://proxy.golang.org"),
+
+		GoToolchain:  envString("GO_TOOLCHAIN", DefaultGoToolchain),
+		GoToolchains: envList("GO_TOOLCHAINS", DefaultGoToolchains),
+
+		ModCacheDir: envPath("MOD_CACHE_DIR", filepath.Join(dataDir, "bucket", "gomod")),
+
+		GCInterval:      envDuration("GC_INTERVAL", time.Hour),
+		GCMaxAge:        envDuration("GC_MAX_AGE", 30*24*time.Hour),
+		GCMaxTotalBytes: int64(envInt("GC_MAX_TOTAL_BYTES", 0)),
+
+		DeletedRetention: envDuration("DELETED_RETENTION", 7*24*time.Hour),
+
+		GitRemoteURL:      os.Getenv("GIT_REMOTE_URL"),
+		GitBranch:         envString("GIT_BRANCH", "main"),
+		GitPushOnComplete: envBool("GIT_PUSH_ON_COMPLETE", false),
+		GitToken:          os.Getenv("GIT_TOKEN"),
+
+		ToolsBin:         envPath("TOOLS_BIN", "bin"),
+		ToolsAutoInstall: envBool("TOOLS_AUTO_INSTALL", false),
+
+		OutputsMaxBytes:      int64(envInt("OUTPUTS_MAX_BYTES", 1<<30)),
+		OutputsSweepInterval: envDuration("OUTPUTS_SWEEP_INTERVAL", time.Minute),
+		RepoFileMaxBytes:     int64(envInt("REPO_FILE_MAX_BYTES", 1<<20)),
+		RepoMaxBytes:         int64(envInt("REPO_MAX_BYTES", 64<<20)),
+		BuildOutputMaxBytes:  envInt("BUILD_OUTPUT_MAX_BYTES", 1<<20),
+		RebuildCheckInterval: envDuration("REBUILD_CHECK_INTERVAL", time.Minute),
+
+		ApprovalTimeout:       envDuration("APPROVAL_TIMEOUT", 72*time.Hour),
+		ApprovalCheckInterval: envDuration("APPROVAL_CHECK_INTERVAL", time.Minute),
+
+		Model:          envString("MODEL", "text-alpha-002-longcontext-0818"),
+		Models:         envPairs("MODELS", "="),
+		ModelFallbacks: envList("MODEL_FALLBACKS", nil),
+		ReadmeModel:    envString("README_MODEL", "text-curie-001"),
+
+		Embeddings:          envBool("EMBEDDINGS", true),
+		EmbeddingModel:      envString("EMBEDDING_MODEL", "text-embedding-ada-002"),
+		DuplicateSimilarity: envFloat("DUPLICATE_SIMILARITY", 0.92),
+
+		MaxTokens:      envInt("MAX_TOKENS", 2048),
+		MaxTokensLimit: envInt("MAX_TOKENS_LIMIT", 8192),
+
+		ServerMultiFileRPCs: envInt("SERVER_MULTI_FILE_RPCS", 6),
+		ServerMaxFiles:      envInt("SERVER_MAX_FILES", 8),
+		ServerMaxBytes:      envInt("SERVER_MAX_BYTES", 256<<10),
+
+		ChatModels:        envList("CHAT_MODELS", nil),
+		ChatContextTokens: envInt("CHAT_CONTEXT_TOKENS", 8192),
+
+		APIKeys:   envPairs("API_KEYS", ":"),
+		AdminKeys: envList("ADMIN_API_KEYS", nil),
+
+		PromoteChecklist:   envList("PROMOTE_CHECKLIST", defaultPromoteChecklist()),
+		PromoteItemRefines: envInt("PROMOTE_ITEM_REFINES", 2),
+
+		ReportTimeZone:   envString("REPORT_TIME_ZONE", "UTC"),
+		ReportTime:       envString("REPORT_TIME", "08:00"),
+		ReportWebhookURL: os.Getenv("REPORT_WEBHOOK_URL"),
+		ModelPrices:      envPairs("MODEL_PRICES", "="),
+
+		CORSAllowedOrigins:   envList("CORS_ALLOWED_ORIGINS", nil),
+		CORSAllowedMethods:   envList("CORS_ALLOWED_METHODS", DefaultCORSMethods),
+		CORSAllowedHeaders:   envList("CORS_ALLOWED_HEADERS", DefaultCORSHeaders),
+		CORSExposedHeaders:   envList("CORS_EXPOSED_HEADERS", DefaultCORSExposedHeaders),
+		CORSMaxAge:           envDuration("CORS_MAX_AGE", 10*time.Minute),
+		CORSAllowCredentials: envBool("CORS_ALLOW_CREDENTIALS", false),
+
+		OpenAIKey:    os.Getenv("OPENAI_API_KEY"),
+		OpenAIKeys:   envPairs("OPENAI_API_KEYS", ":"),
+		LLMKeySecret: os.Getenv("LLM_KEY_SECRET"),
+		LLMProvider:  envString("LLM_PROVIDER", LLMProviderOpenAI),
+		FixturesDir:  envString("FIXTURES_DIR", "fixtures"),
+
+		RedactPatterns: strings.Fields(os.Getenv("REDACT_PATTERNS")),
+
+		ImportAllow:          envList("IMPORT_ALLOW", nil),
+		ImportDeny:           envList("IMPORT_DENY", nil),
+		ImportSuggestions:    envPairs("IMPORT_SUGGESTIONS", "="),
+		ImportDeniedLicenses: envList("IMPORT_DENIED_LICENSES", []string{"AGPL-3.0"}),
+		DependencyAdvisories: envPairs("DEPENDENCY_ADVISORIES", "="),
+		Govulncheck:          envBool("GOVULNCHECK", true),
+
+		DockerfileTemplate:     envBool("DOCKERFILE_TEMPLATE", true),
+		DockerfilePackageAllow: os.Getenv("DOCKERFILE_PACKAGE_ALLOW"),
+		DockerfileAptCheck:     envBool("DOCKERFILE_APT_CHECK", false),
+
+		TranscodingCheck:         envBool("TRANSCODING_CHECK", true),
+		TranscodingCheckAdvisory: envBool("TRANSCODING_CHECK_ADVISORY", false),
+
+		SymbolDocs: envBool("SYMBOL_DOCS", true),
+
+		TraceURLTemplate: os.Getenv("TRACE_URL_TEMPLATE"),
+
+		Metrics:     envBool("METRICS", true),
+		MetricsAddr: os.Getenv("METRICS_ADDR"),
+
+		ContainerMemory:        envString("CONTAINER_MEMORY", "1g"),
+		ContainerCPUs:          envString("CONTAINER_CPUS", "1"),
+		ContainerPidsLimit:     envInt("CONTAINER_PIDS_LIMIT", 512),
+		ContainerRestartPolicy: envString("CONTAINER_RESTART_POLICY", "no"),
+		ContainerMaxMemory:     envString("CONTAINER_MAX_MEMORY", "8g"),
+		ContainerMaxCPUs:       envFloat("CONTAINER_MAX_CPUS", 4),
+		ContainerMaxPidsLimit:  envInt("CONTAINER_MAX_PIDS_LIMIT", 4096),
+
+		SeedlingHost:  envString("SEEDLING_HOST", "localhost"),
+		GRPCSmokeTest: envBool("GRPC_SMOKE_TEST", true),
+		APIURL:        envString("API_URL", "http://"+localAddr(listenAddr)),
+
+		ExamplesMaxAttempts: envInt("EXAMPLES_MAX_ATTEMPTS", 3),
+		ProvenanceStamping:  envBool("PROVENANCE_STAMPING", true),
+
+		MarkersDataset: envString("HONEYCOMB_MARKERS_DATASET", "garden-api-prod"),
+		MarkersAPIKey:  os.Getenv("HONEYCOMB_API_KEY"),
+	}
+}
+
+// DataPath is elem joined under DataDir.
+func (c Config) DataPath(elem ...string) string {
+	return filepath.Join(append([]string{c.DataDir}, elem...)...)
+}
+
+// ReposDir is where seedling repos are created, SharedRepoDir the repo
+// seedlings created before per-seedling repos share, and DBPath the
+// database.
+func (c Config) ReposDir() string      { return c.DataPath("repos") }
+func (c Config) SharedRepoDir() string { return c.DataPath("repos", "default") }
+func (c Config) DBPath() string        { return c.DataPath(DBFile) }
+
+// localAddr is where this host reaches addr, a listen address that may
+// leave the host out.
+func localAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// envPath is envString made absolute, for paths commands run elsewhere use.
+func envPath(key, def string) string {
+	path, err := filepath.Abs(envString(key, def))
+	if err != nil {
+		logrus.WithField("key", key).WithField("error", err).Warn("invalid path in environment, using default")
+		return def
+	}
+	return path
+}
+
+func envBool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logrus.WithField("key", key).WithField("value", v).Warn("invalid boolean in environment, using default")
+		return def
+	}
+	return b
+}
+
+func envInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		logrus.WithField("key", key).WithField("value", v).Warn("invalid integer in environment, using default")
+		return def
+	}
+	return i
+}
+
+func envFloat(key string, def float64) float64 {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		logrus.WithField("key", key).WithField("value", v).Warn("invalid number in environment, using default")
+		return def
+	}
+	return f
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logrus.WithField("key", key).WithField("value", v).Warn("invalid duration in environment, using default")
+		return def
+	}
+	return d
+}
+
+// envPairs parses a comma separated list of name/value pairs joined by sep.
+func envPairs(key, sep string) map[string]string {
+	pairs := map[string]string{}
+	for _, item := range envList(key, nil) {
+		name, value, ok := strings.Cut(item, sep)
+		if !ok || name == "" || value == "" {
+			logrus.WithField("key", key).Warn("invalid pair in environment, ignoring it")
+			continue
+		}
+		pairs[name] = value
+	}
+	return pairs
+}
+
+func envList(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
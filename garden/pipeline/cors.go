@@ -0,0 +1,31 @@
+package pipeline
+
+import (
+	"errors"
+)
+
+// CORSAnyOrigin allows every origin. It's refused with API keys set, since
+// any page could then make requests with a key a user pasted into it.
+const CORSAnyOrigin = "*"
+
+var (
+	DefaultCORSMethods        = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	DefaultCORSHeaders        = []string{"Authorization", "Content-Type", "If-Match", APIKeyHeader, OpenAIKeyHeader, RequestIDHeader}
+	DefaultCORSExposedHeaders = []string{"ETag", "Location", "Retry-After", RequestIDHeader, TotalCountHeader}
+)
+
+// CheckCORS returns why the CORS configuration is invalid, if it is.
+func CheckCORS(config Config) error {
+	for _, origin := range config.CORSAllowedOrigins {
+		if origin != CORSAnyOrigin {
+			continue
+		}
+		if len(config.APIKeys) > 0 {
+			return errors.New("the * origin is only allowed when API_KEYS isn't set")
+		}
+		if config.CORSAllowCredentials {
+			return errors.New("the * origin can't be allowed with CORS_ALLOW_CREDENTIALS")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package pipeline
+
+import "testing"
+
+func TestCheckCORS(t *testing.T) {
+	keys := map[string]string{"ci": "ci-key"}
+	tests := []struct {
+		name   string
+		config Config
+		ok     bool
+	}{
+		{"off", Config{}, true},
+		{"origins with keys", Config{CORSAllowedOrigins: []string{"https://app.example.com"}, APIKeys: keys, CORSAllowCredentials: true}, true},
+		{"any origin without keys", Config{CORSAllowedOrigins: []string{CORSAnyOrigin}}, true},
+		{"any origin with keys", Config{CORSAllowedOrigins: []string{"https://app.example.com", CORSAnyOrigin}, APIKeys: keys}, false},
+		{"any origin with credentials", Config{CORSAllowedOrigins: []string{CORSAnyOrigin}, CORSAllowCredentials: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckCORS(tt.config); (err == nil) != tt.ok {
+				t.Errorf("error %v", err)
+			}
+		})
+	}
+}
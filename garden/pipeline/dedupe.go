@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ERROR_OUTPUT_LINES is how much of a failed build's output the fix
+	// prompt quotes by default, from the end, see the errorOutputLines
+	// setting. Each repeat of code that already failed
+	// quotes that much more, up to MAX_ERROR_OUTPUT_LINES.
+	ERROR_OUTPUT_LINES     = 25
+	MAX_ERROR_OUTPUT_LINES = 100
+	// ERROR_SOURCE_CONTEXT is how many lines around each line an error
+	// points at are quoted with it.
+	ERROR_SOURCE_CONTEXT = 2
+)
+
+// errDuplicateOutput is the error of an attempt that wrote code which
+// already failed at its step. It isn't built again: it fails with the
+// output it failed with before.
+var errDuplicateOutput = errors.New("wrote code that already failed")
+
+// errorLineRegex matches the file:line[:column] compilers, protoc and go vet
+// point at errors with.
+var errorLineRegex = regexp.MustCompile(`([\w./-]+):(\d+)(?::\d+)?`)
+
+// outputHash identifies the code an attempt wrote, so code that already
+// failed at a step is recognised when the model writes it again.
+func outputHash(step, code string) string {
+	sum := sha256.Sum256([]byte(step + "\x00" + strings.TrimSpace(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// errorSource quotes the lines of code that errors in the output point at in
+// file, numbered, with ERROR_SOURCE_CONTEXT lines around each.
+func errorSource(code, file, output string) string {
+	lines := strings.Split(code, "\n")
+	quoted := map[int]bool{}
+	for _, m := range errorLineRegex.FindAllStringSubmatch(output, -1) {
+		if !strings.HasSuffix(m[1], file) {
+			continue
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil || n < 1 || n > len(lines) {
+			continue
+		}
+		for i := n - ERROR_SOURCE_CONTEXT; i <= n+ERROR_SOURCE_CONTEXT; i++ {
+			if i >= 1 && i <= len(lines) {
+				quoted[i] = true
+			}
+		}
+	}
+	numbers := make([]int, 0, len(quoted))
+	for n := range quoted {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	var b strings.Builder
+	for i, n := range numbers {
+		if i > 0 && numbers[i-1] != n-1 {
+			b.WriteString("...\n")
+		}
+		fmt.Fprintf(&b, "%4d  %s\n", n, lines[n-1])
+	}
+	return b.String()
+}
+
+// duplicateFix is the prompt for another version after the model wrote code
+// that already failed with output. It quotes more of the output the more
+// times in a row that's happened, starting from lines, along with the lines
+// the errors point at.
+func duplicateFix(code, file, output string, lines, repeats int) string {
+	n := lines * (repeats + 1)
+	if n > MAX_ERROR_OUTPUT_LINES {
+		n = MAX_ERROR_OUTPUT_LINES
+	}
+	fix := "That's exactly the code you wrote before, and it failed the same way. " +
+		"Don't write it again: work out what the error means and change the code so it can't happen.\n\n" +
+		"It got this error:\n\n```\n" + strings.TrimRight(ErrorTail(output, n), "\n") + "\n```\n"
+	if source := errorSource(code, file, output); source != "" {
+		fix += "\nThe error is at these lines of " + file + ":\n\n```\n" + source + "```\n"
+	}
+	return fix + "\nWrite a different version that fixes that error.\n"
+}
@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var envNameInvalidRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// dependencyEnvPrefix is what the environment variables a seedling's
+// dependency's addresses are passed in start with, e.g. OTHER_SEEDLING for
+// other-seedling.
+func dependencyEnvPrefix(name string) string {
+	prefix := strings.Trim(envNameInvalidRegex.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+	if prefix == "" || (prefix[0] >= '0' && prefix[0] <= '9') {
+		prefix = "SEEDLING_" + prefix
+	}
+	return prefix
+}
+
+// dependencyEnv is the addresses the seedling's dependencies are reached at
+// on their garden's network, by environment variable: their container's
+// name, which docker resolves there, and the ports they listen on inside it.
+func (s *Pipeline) dependencyEnv(deps []store.Seedling) map[string]string {
+	env := map[string]string{}
+	for _, dep := range deps {
+		prefix := dependencyEnvPrefix(dep.Name)
+		ports := dep.SeedlingPorts.WithDefaults()
+		env[prefix+"_GRPC_ADDR"] = s.ContainerName(dep) + ":" + strconv.Itoa(ports.GRPCContainerPort)
+		env[prefix+"_HTTP_ADDR"] = s.ContainerName(dep) + ":" + strconv.Itoa(ports.HTTPContainerPort)
+	}
+	return env
+}
+
+// dependencyHint is the server prompt's instruction n, telling the model the
+// seedlings it can call and where, or "" if it has no dependencies.
+func dependencyHint(n int, deps []store.Seedling) string {
+	if len(deps) == 0 {
+		return ""
+	}
+	hint := fmt.Sprintf("\n%d. The service can call these other services, which run alongside it. Each\n"+
+		"    serves gRPC with insecure connection settings at the address in its _GRPC_ADDR\n"+
+		"    environment variable, and the same calls as JSON over HTTP at its _HTTP_ADDR.\n"+
+		"    Read the addresses with os.Getenv, they're always set:\n", n)
+	for _, dep := range deps {
+		prefix := dependencyEnvPrefix(dep.Name)
+		hint += fmt.Sprintf("    - %s_GRPC_ADDR and %s_HTTP_ADDR: %s, a service that %s\n",
+			prefix, prefix, dep.Name, dep.Brief())
+	}
+	return hint
+}
+
+// Dependencies is the seedlings the seedling depends on that haven't been
+// deleted since.
+func (s *Pipeline) Dependencies(ctx context.Context, seedlingID hide.Int64) ([]store.Seedling, error) {
+	deps := []store.Seedling{}
+	err := s.DB.SelectContext(ctx, &deps, `
+	 SELECT seedlings.* FROM seedlings
+	 JOIN seedling_dependencies ON seedling_dependencies.depends_on_id = seedlings.id
+	 WHERE seedling_dependencies.seedling_id = $1 AND seedlings.deleted_at IS NULL
+	 ORDER BY seedlings.name
+	 `, seedlingID)
+	return deps, err
+}
+
+// checkDependenciesRunning warns about the seedling's dependencies whose
+// containers aren't running, which it won't be able to reach by name. It
+// still starts, as they may be started after it.
+func (s *Pipeline) checkDependenciesRunning(ctx context.Context, seedling store.Seedling) {
+	deps, err := s.Dependencies(ctx, seedling.ID)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to get seedling dependencies")
+		return
+	}
+	for _, dep := range deps {
+		state, err := s.Docker.State(ctx, s.ContainerName(dep))
+		if err != nil {
+			logrus.WithField("error", err).Error("failed to inspect dependency container")
+			continue
+		}
+		if state != "running" {
+			logrus.WithField("name", seedling.Name).
+				WithField("dependency", dep.Name).
+				WithField("state", state).
+				Warn("seedling's dependency isn't running, it can't be reached by name")
+		}
+	}
+}
+
+// dependencyEnvNames is the environment variables garden sets itself for
+// the seedling's dependencies, sorted.
+func (s *Pipeline) dependencyEnvNames(ctx context.Context, seedlingID hide.Int64) ([]string, error) {
+	deps, err := s.Dependencies(ctx, seedlingID)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range s.dependencyEnv(deps) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
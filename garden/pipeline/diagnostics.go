@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MAX_DIAGNOSTICS is how many of a build's diagnostics the fix prompt
+	// lists, in the order they were reported.
+	MAX_DIAGNOSTICS = 10
+
+	// The kinds of fix prompt, by what they were made from.
+	FixPromptDiagnostics = "diagnostics"
+	FixPromptDocker      = "docker"
+	FixPromptTail        = "tail"
+	// FixPromptPackages only asks for a templated Dockerfile's packages.
+	FixPromptPackages = "packages"
+	// FixPromptTranscoding lists the fields a server's HTTP handlers
+	// disagree with its proto's JSON mapping on.
+	FixPromptTranscoding = "transcoding"
+)
+
+var (
+	// buildkitPrefixRegex matches what BuildKit starts the lines of a
+	// step's output with: the step number and the seconds into it.
+	buildkitPrefixRegex = regexp.MustCompile(`^(?:#\d+ )?(?:\d+\.\d+ )?`)
+	// diagnosticRegex matches the file:line[:column]: message the Go
+	// compiler, go vet and protoc report errors with.
+	diagnosticRegex = regexp.MustCompile(`^(?:vet: )?([\w./-]+\.(?:go|proto)):(\d+)(?::(\d+))?:\s*(.+)$`)
+	// buildkitFailedRegex matches the header of the output BuildKit repeats
+	// of the instruction that failed, e.g. " > [builder 4/6] RUN go build:".
+	buildkitFailedRegex = regexp.MustCompile(`^ > \[[^\]]+\] (.+):$`)
+	// dockerStepRegex and dockerFailedRegex match the legacy builder's
+	// "Step 4/6 : RUN go build" and the line it reports a step failing on.
+	dockerStepRegex   = regexp.MustCompile(`^Step \d+/\d+ : (.+)$`)
+	dockerFailedRegex = regexp.MustCompile(`returned a non-zero code|did not complete successfully`)
+	importBlockRegex  = regexp.MustCompile(`(?ms)^import\s*\(.*?^\)|^import\s+(?:\w+\s+)?"[^"]*"$`)
+)
+
+// Diagnostic is an error the compiler or protoc reported at a line of a
+// file, and the column if it said.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// ParseDiagnostics finds the diagnostics in a build's output, including
+// those of a go build or protoc run by docker build, each once.
+func ParseDiagnostics(output string) []Diagnostic {
+	diags := []Diagnostic{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(buildkitPrefixRegex.ReplaceAllString(strings.TrimRight(line, "\r"), ""))
+		m := diagnosticRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{File: strings.TrimPrefix(m[1], "./"), Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		if d.Line < 1 || seen[d.String()] {
+			continue
+		}
+		seen[d.String()] = true
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// sameFile reports whether a path a diagnostic names and the path of a file
+// relative to the repo are the same file, the compiler reporting paths
+// relative to wherever it was run.
+func sameFile(diagFile, file string) bool {
+	diagFile, file = path.Clean(diagFile), path.Clean(file)
+	return diagFile == file || strings.HasSuffix(file, "/"+diagFile) || strings.HasSuffix(diagFile, "/"+file)
+}
+
+// sourceExcerpt quotes the lines of code around line, numbered, with the
+// line itself marked.
+func sourceExcerpt(code string, line int) string {
+	lines := strings.Split(code, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	var b strings.Builder
+	for i := line - ERROR_SOURCE_CONTEXT; i <= line+ERROR_SOURCE_CONTEXT; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s%4d  %s\n", marker, i, lines[i-1])
+	}
+	return b.String()
+}
+
+// importBlock is the imports of Go code, or "" if it has none.
+func importBlock(code string) string {
+	return strings.Join(importBlockRegex.FindAllString(code, -1), "\n")
+}
+
+// dockerFailure finds the instruction a docker build failed at and what it
+// printed, from BuildKit's or the legacy builder's output.
+func dockerFailure(output string) (string, string, bool) {
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		m := buildkitFailedRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		var out strings.Builder
+		for _, line := range lines[i+1:] {
+			if strings.HasPrefix(line, "------") {
+				break
+			}
+			out.WriteString(buildkitPrefixRegex.ReplaceAllString(line, "") + "\n")
+		}
+		return m[1], out.String(), true
+	}
+
+	instruction, start := "", 0
+	for i, line := range lines {
+		if m := dockerStepRegex.FindStringSubmatch(strings.TrimRight(line, "\r")); m != nil {
+			instruction, start = m[1], i+1
+			continue
+		}
+		if instruction != "" && dockerFailedRegex.MatchString(line) {
+			return instruction, strings.Join(lines[start:i+1], "\n") + "\n", true
+		}
+	}
+	return "", "", false
+}
+
+// errorReport is the part of a fix prompt saying how code written to file
+// failed, from its build's output: each diagnostic with the lines it points
+// at and the file's imports if it has any, else the instruction a docker
+// build failed at and its output, else the output's last lines. file is
+// relative to the repo. It returns which of those it is, see FixPrompt*.
+func errorReport(code, file, output string, lines int) (string, string) {
+	if diags := ParseDiagnostics(output); len(diags) > 0 {
+		if len(diags) > MAX_DIAGNOSTICS {
+			diags = diags[:MAX_DIAGNOSTICS]
+		}
+		var b strings.Builder
+		b.WriteString("It got these errors:\n\n")
+		for _, d := range diags {
+			b.WriteString(d.String() + "\n")
+			if !sameFile(d.File, file) {
+				continue
+			}
+			if excerpt := sourceExcerpt(code, d.Line); excerpt != "" {
+				b.WriteString("```\n" + excerpt + "```\n")
+			}
+		}
+		if strings.HasSuffix(file, ".go") {
+			if imports := importBlock(code); imports != "" {
+				b.WriteString("\nThe file's imports are:\n\n```go\n" + imports + "\n```\n")
+			}
+		}
+		return b.String(), FixPromptDiagnostics
+	}
+	if instruction, out, ok := dockerFailure(output); ok {
+		return "The docker build failed at `" + instruction + "`, which printed:\n\n```\n" +
+			ErrorTail(out, lines) + "```\n", FixPromptDocker
+	}
+	return "It got an error:\n\n```\n" + ErrorTail(output, lines) + "```\n", FixPromptTail
+}
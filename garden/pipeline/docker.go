@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/dockerx"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	GoModCacheMountID = "garden-gomod"
+
+	ContainerRuntimeSDK = "sdk"
+	ContainerRuntimeCLI = "cli"
+)
+
+// NewContainerRuntime returns the runtime CONTAINER_RUNTIME selects.
+func NewContainerRuntime(name string) (dockerx.ContainerRuntime, error) {
+	switch name {
+	case ContainerRuntimeSDK:
+		return dockerx.NewClient()
+	case ContainerRuntimeCLI:
+		return dockerx.NewCLI(), nil
+	}
+	return nil, fmt.Errorf("unknown CONTAINER_RUNTIME %q, want %q or %q", name, ContainerRuntimeSDK, ContainerRuntimeCLI)
+}
+
+// dockerBuildArgs returns the arguments for `docker build` of a seedling
+// image. With the build cache enabled, the previous image for the seedling is
+// used as a cache source and inline cache metadata is written to the new one.
+func dockerBuildArgs(name string, cache bool) []string {
+	if !cache {
+		return []string{"--no-cache", "-t", name}
+	}
+	return []string{
+		"--build-arg", "BUILDKIT_INLINE_CACHE=1",
+		"--cache-from", name,
+		"-t", name,
+	}
+}
+
+// SeedlingImageBuildArgs returns the docker arguments that build a
+// seedling's image from its repo, cross-building with buildx for platforms
+// other than the host's.
+func (s *Pipeline) SeedlingImageBuildArgs(seedling store.Seedling, cache bool) []string {
+	return ImageBuildArgs(seedling, s.ContainerName(seedling), cache)
+}
+
+// ImageBuildArgs is SeedlingImageBuildArgs tagging the image tag.
+func ImageBuildArgs(seedling store.Seedling, tag string, cache bool) []string {
+	args := []string{"build"}
+	if seedling.Platform != HostPlatform() {
+		args = []string{"buildx", "build", "--platform", seedling.Platform, "--load"}
+	}
+	args = append(args, dockerBuildArgs(tag, cache)...)
+	return append(args, ".")
+}
+
+// StartSeedlingContainer runs the seedling's image with its secrets and
+// outputs mounted and its env set, removing any container already running
+// it if replace is set, and stores the ports it's published on. It returns
+// the new container's id.
+func (s *Pipeline) StartSeedlingContainer(ctx context.Context, seedling *store.Seedling, replace bool) (string, error) {
+	secretsDir, err := filepath.Abs(SeedlingSecretsDir(s.RepoDir(*seedling)))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(secretsDir, 0700); err != nil {
+		return "", err
+	}
+	outputsDir, err := filepath.Abs(s.SeedlingOutputsDir(seedling.ResourceName()))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(outputsDir, 0755); err != nil {
+		return "", err
+	}
+	if replace {
+		if err := s.Docker.Remove(ctx, s.ContainerName(*seedling)); err != nil {
+			logrus.WithField("error", err).Warn("failed to remove seedling container")
+		}
+	}
+	env, err := s.seedlingEnv(ctx, *seedling)
+	if err != nil {
+		return "", err
+	}
+	s.checkDependenciesRunning(ctx, *seedling)
+	opts := dockerx.RunOptions{
+		Name:     s.ContainerName(*seedling),
+		Image:    s.ContainerName(*seedling),
+		Network:  s.network(*seedling),
+		Platform: seedling.Platform,
+		Binds:    []string{secretsDir + ":/secrets:ro", outputsDir + ":/outputs"},
+		Ports:    seedling.SeedlingPorts.RunPorts(),
+		Env:      env,
+	}
+	// Restarts and starts of a stopped container keep the limits it was
+	// run with.
+	ResourcesWithDefaults(seedling.SeedlingResources, s.Config).RunOptions(&opts)
+	id, err := s.Docker.Run(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET outputs_quota_exceeded = FALSE WHERE id = $1", seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to clear outputs quota flag")
+	}
+	if err := s.RefreshPorts(ctx, seedling); err != nil {
+		logrus.WithField("error", err).Error("failed to store seedling ports")
+	}
+	short := id
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	s.Emit(ctx, seedling.ID, SeedlingEvent{
+		Type: EventContainerLaunched,
+		Step: seedling.Step,
+		Payload: EventPayload{
+			"containerId": short,
+			"grpcPort":    seedling.GRPCPort,
+			"httpPort":    seedling.HTTPPort,
+		},
+	})
+	return id, nil
+}
+
+// goModCacheMount is the BuildKit cache mount shared by all seedling builds
+// so `go get` doesn't re-download modules on every attempt.
+func goModCacheMount() string {
+	return "--mount=type=cache,id=" + GoModCacheMountID + ",target=/root/go/pkg/mod"
+}
+
+// HostPlatform is the platform images are built for when a seedling doesn't
+// ask for one. Docker Desktop runs a Linux VM of the host architecture.
+func HostPlatform() string {
+	return "linux/" + runtime.GOARCH
+}
+
+// platformArch returns the architecture part of an os/arch[/variant] platform.
+func platformArch(platform string) string {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 {
+		return platform
+	}
+	return strings.Join(parts[1:], "/")
+}
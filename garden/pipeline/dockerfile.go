@@ -0,0 +1,257 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// DockerfileRuntimeImage is the final stage of generated Dockerfiles.
+	DockerfileRuntimeImage = "debian:bookworm-slim"
+
+	PackageRejectedSyntax  = "syntax"
+	PackageRejectedAllow   = "allowlist"
+	PackageRejectedUnknown = "unknown"
+)
+
+var (
+	// debianPackageRegex is Debian policy's package name syntax. Names are
+	// spliced into RUN lines, so anything else is refused however
+	// DOCKERFILE_PACKAGE_ALLOW is set.
+	debianPackageRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+
+	// dockerfileTemplate is the Dockerfile every seedling gets with
+	// DOCKERFILE_TEMPLATE on. Only the packages come from the model.
+	dockerfileTemplate = template.Must(template.New("Dockerfile").Funcs(template.FuncMap{
+		"packages": func(pkgs []string) string { return strings.Join(pkgs, " \\\n  ") },
+	}).Parse(`{{if .CacheMount}}# syntax=docker/dockerfile:1
+{{end}}FROM {{.BuilderImage}} AS builder
+{{if .AptPackages}}
+RUN apt-get update && apt-get install -y --no-install-recommends \
+  {{packages .AptPackages}} \
+  && rm -rf /var/lib/apt/lists/*
+{{end}}
+COPY . /app
+WORKDIR /app
+RUN {{.CacheMount}}go get ./...
+RUN {{.CacheMount}}go build -o /tmp/svc ./server
+
+FROM {{.RuntimeImage}}
+
+RUN apt-get update && apt-get install -y --no-install-recommends \
+  {{packages .RuntimePackages}} \
+  && rm -rf /var/lib/apt/lists/*
+RUN groupadd -r appuser && useradd -r -g appuser appuser
+COPY --from=builder /tmp/svc /bin/svc
+RUN chown appuser:appuser /bin/svc
+USER appuser
+EXPOSE {{.GRPCPort}}
+EXPOSE {{.HTTPPort}}
+CMD ["/bin/svc"]
+`))
+)
+
+// DockerfilePackages is the model's answer at SeedlingStepDockerfile with
+// DOCKERFILE_TEMPLATE on: the apt packages each stage of the Dockerfile
+// needs.
+type DockerfilePackages struct {
+	AptPackages     []string `json:"aptPackages"`
+	RuntimePackages []string `json:"runtimePackages"`
+}
+
+// PackageError is a package list with packages that can't be installed.
+// Rejected maps each one to why, one of the PackageRejected constants.
+type PackageError struct {
+	Rejected map[string]string
+}
+
+func (e *PackageError) Error() string {
+	names := make([]string, 0, len(e.Rejected))
+	for name := range e.Rejected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reasons := make([]string, len(names))
+	for i, name := range names {
+		reasons[i] = fmt.Sprintf("%q (%s)", name, packageRejectedReason(e.Rejected[name]))
+	}
+	return "can't install " + strings.Join(reasons, ", ")
+}
+
+func packageRejectedReason(reject string) string {
+	switch reject {
+	case PackageRejectedSyntax:
+		return "not a Debian package name"
+	case PackageRejectedAllow:
+		return "not allowed"
+	}
+	return "no such package in Debian bookworm"
+}
+
+// parseDockerfilePackages reads the first JSON package list in text. The
+// runtime stage always gets ca-certificates, which services calling HTTPS
+// APIs need and models forget.
+func parseDockerfilePackages(text string) (DockerfilePackages, error) {
+	err := errors.New("no JSON object in response")
+	for i := strings.IndexByte(text, '{'); i != -1; i = NextObject(text, i) {
+		var pkgs DockerfilePackages
+		if decodeErr := json.NewDecoder(strings.NewReader(text[i:])).Decode(&pkgs); decodeErr != nil {
+			err = fmt.Errorf("invalid JSON: %w", decodeErr)
+			continue
+		}
+		pkgs.AptPackages = cleanPackages(pkgs.AptPackages)
+		pkgs.RuntimePackages = cleanPackages(append(pkgs.RuntimePackages, "ca-certificates"))
+		return pkgs, nil
+	}
+	return DockerfilePackages{}, err
+}
+
+// cleanPackages trims and dedupes pkgs, keeping their order.
+func cleanPackages(pkgs []string) []string {
+	seen := map[string]bool{}
+	cleaned := []string{}
+	for _, pkg := range pkgs {
+		pkg = strings.TrimSpace(pkg)
+		if pkg == "" || seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		cleaned = append(cleaned, pkg)
+	}
+	return cleaned
+}
+
+// checkPackages returns a *PackageError if any of pkgs isn't a Debian
+// package name, isn't allowed by DOCKERFILE_PACKAGE_ALLOW or, with
+// DOCKERFILE_APT_CHECK on, isn't known to apt-cache in the image of the
+// stage that installs it.
+func (s *Pipeline) checkPackages(ctx context.Context, seedling store.Seedling, pkgs DockerfilePackages) error {
+	rejected := map[string]string{}
+	for _, pkg := range append(append([]string{}, pkgs.AptPackages...), pkgs.RuntimePackages...) {
+		if !debianPackageRegex.MatchString(pkg) {
+			rejected[pkg] = PackageRejectedSyntax
+		} else if s.PackageAllow != nil && !s.PackageAllow.MatchString(pkg) {
+			rejected[pkg] = PackageRejectedAllow
+		}
+	}
+	if len(rejected) == 0 && s.Config.DockerfileAptCheck {
+		for image, names := range map[string][]string{
+			GoImage(seedling.Toolchain): pkgs.AptPackages,
+			DockerfileRuntimeImage:      pkgs.RuntimePackages,
+		} {
+			unknown, err := s.unknownPackages(ctx, image, names)
+			if err != nil {
+				return err
+			}
+			for _, pkg := range unknown {
+				rejected[pkg] = PackageRejectedUnknown
+			}
+		}
+	}
+	for _, reject := range rejected {
+		observeRejectedPackage(reject)
+	}
+	if len(rejected) > 0 {
+		return &PackageError{Rejected: rejected}
+	}
+	return nil
+}
+
+// unknownPackages returns the pkgs apt-cache doesn't know in image. The
+// names have already been checked against debianPackageRegex, so they're
+// safe to pass through sh.
+func (s *Pipeline) unknownPackages(ctx context.Context, image string, pkgs []string) ([]string, error) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	script := `apt-get update -qq >/dev/null && for p in "$@"; do apt-cache show --no-all-versions "$p" >/dev/null 2>&1 || echo "$p"; done`
+	args := append([]string{"run", "--rm", image, "sh", "-c", script, "sh"}, pkgs...)
+	cmd, err := HostRunner{Env: s.BuildEnv()}.Command(ctx, BuildSpec{Name: "docker", Args: args})
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check packages in %s: %w", image, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// templateDockerfile renders the seedling's Dockerfile from the package
+// list in completion.
+func (s *Pipeline) templateDockerfile(ctx context.Context, seedling store.Seedling, completion string) (string, error) {
+	pkgs, err := parseDockerfilePackages(completion)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkPackages(ctx, seedling, pkgs); err != nil {
+		return "", err
+	}
+	ports := seedling.SeedlingPorts.WithDefaults()
+	data := struct {
+		DockerfilePackages
+		BuilderImage, RuntimeImage, CacheMount string
+		GRPCPort, HTTPPort                     int
+	}{
+		DockerfilePackages: pkgs,
+		BuilderImage:       GoImage(seedling.Toolchain),
+		RuntimeImage:       DockerfileRuntimeImage,
+		GRPCPort:           ports.GRPCContainerPort,
+		HTTPPort:           ports.HTTPContainerPort,
+	}
+	if s.Config.BuildCache {
+		data.CacheMount = goModCacheMount() + " "
+	}
+	var b strings.Builder
+	if err := dockerfileTemplate.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// dockerfilePackagesPrompt asks for the package list templateDockerfile
+// renders the Dockerfile from.
+func dockerfilePackagesPrompt(prompt string, seedling store.Seedling) string {
+	return fmt.Sprintf(`%s
+Now we need to build and run your server in Docker. The Dockerfile is
+generated for you: the server is built with the %s image, which has Go %s,
+and runs in %s. List the apt packages each stage needs, e.g. C libraries
+for cgo in the builder or binaries the server runs at runtime, as exactly
+one JSON object like this one:
+
+`+"```"+`json
+{"aptPackages": ["libvips-dev"], "runtimePackages": ["libvips42", "ffmpeg"]}
+`+"```"+`
+
+Use Debian bookworm package names. Don't list Go or golang packages. Use
+empty lists if the server only needs the Go standard library and modules.
+
+Write only the JSON.
+`, prompt, GoImage(seedling.Toolchain), seedling.Toolchain, DockerfileRuntimeImage)
+}
+
+// packagesFix is the reprompt after a templated Dockerfile failed. Only the
+// package list can fix it, so that's all the model is asked for.
+func packagesFix(dockerfile, output string, err error, lines int) (fix, kind string) {
+	var pkgErr *PackageError
+	switch {
+	case errors.As(err, &pkgErr):
+		fix, kind = "That package list didn't work: "+pkgErr.Error()+".\n", FixPromptPackages
+	case dockerfile == "":
+		fix, kind = "That wasn't a valid package list ("+err.Error()+").\n", FixPromptPackages
+	default:
+		var report string
+		report, kind = errorReport(dockerfile, "Dockerfile", output, lines)
+		fix = "The Dockerfile generated from that package list didn't work.\n\n" + report +
+			"\nThe Dockerfile was:\n\n```dockerfile\n" + dockerfile + "```\n"
+	}
+	return fix + "\nWrite a corrected package list, as exactly one JSON object in the same format.\n", kind
+}
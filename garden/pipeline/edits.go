@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// GenerationEdits returns the json names of the fields the prompts are made
+// from that current has different from seedling.
+func GenerationEdits(seedling, current store.Seedling) []string {
+	edits := []string{}
+	if current.Description != seedling.Description {
+		edits = append(edits, "description")
+	}
+	if current.Model != seedling.Model {
+		edits = append(edits, "model")
+	}
+	if !reflect.DeepEqual(current.Temperature, seedling.Temperature) {
+		edits = append(edits, "temperature")
+	}
+	if current.MaxTokens != seedling.MaxTokens {
+		edits = append(edits, "maxTokens")
+	}
+	if current.Template != seedling.Template {
+		edits = append(edits, "template")
+	}
+	if len(current.TemplateParams)+len(seedling.TemplateParams) > 0 && !reflect.DeepEqual(current.TemplateParams, seedling.TemplateParams) {
+		edits = append(edits, "templateParams")
+	}
+	return edits
+}
+
+// applyGenerationEdits sets the fields GenerationEdits compares to
+// current's.
+func applyGenerationEdits(seedling *store.Seedling, current store.Seedling) {
+	seedling.Description = current.Description
+	seedling.SeedlingGeneration = current.SeedlingGeneration
+	seedling.SeedlingTemplate = current.SeedlingTemplate
+}
+
+// seedlingEdits re-reads the seedling's row, returning it and the fields a
+// build of seedling generates from that have been changed since.
+func (s *Pipeline) seedlingEdits(ctx context.Context, seedling store.Seedling) (store.Seedling, []string, error) {
+	var current store.Seedling
+	if err := s.DB.GetContext(ctx, &current, "SELECT * FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+		return current, nil, err
+	}
+	return current, GenerationEdits(seedling, current), nil
+}
+
+// buildRestart is a build to restart with the seedling as it is now, for
+// the fields that were changed while it ran.
+type buildRestart struct {
+	seedling store.Seedling
+	edits    []string
+}
+
+// checkEdits is run by the seedling's build between its steps, next being
+// the one it's moving on to. If the seedling has been changed since the
+// build read it, the build is restarted with restartOnEdit set, which
+// checkEdits returns the restart for; otherwise the seedling is marked
+// StaleDescription, once, and the build carries on.
+func (s *Pipeline) checkEdits(ctx context.Context, seedling *store.Seedling, next string) *buildRestart {
+	current, edits, err := s.seedlingEdits(ctx, *seedling)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to get seedling")
+		return nil
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	log := logrus.WithField("name", seedling.Name).WithField("step", next).WithField("fields", edits)
+	if s.pipelineSettings(ctx).RestartOnEdit != 0 {
+		log.Info("Seedling changed while it was being built, restarting the build")
+		return &buildRestart{seedling: current, edits: edits}
+	}
+	if seedling.StaleDescription {
+		return nil
+	}
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET stale_description = TRUE, version = version + 1 WHERE id = $1", seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to mark seedling stale")
+		return nil
+	}
+	seedling.StaleDescription = true
+	log.Warn("Seedling changed while it was being built, the build carries on without the changes")
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventStaleDescription, Step: next, Payload: EventPayload{"fields": edits}})
+	return nil
+}
+
+// restartBuild moves the seedling back to step, where its build started,
+// and queues a build of it from there, which generates from its current
+// fields. The caller has released the build's lease.
+func (s *Pipeline) restartBuild(ctx context.Context, restart buildRestart, step string) {
+	seedling := restart.seedling
+	if err := s.ClearCheckpoint(ctx, seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to clear checkpoint")
+	}
+	now := time.Now()
+	if _, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, stale_description = FALSE, version = version + 1
+	 WHERE id = $3
+	 `, step, now, seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to restart seedling build")
+		return
+	}
+	seedling.Step = step
+	seedling.StepStartedAt = &now
+	seedling.StaleDescription = false
+	seedling.Version++
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventBuildRestarted, Step: step, Payload: EventPayload{"fields": restart.edits}})
+	s.Notify(ctx, seedling, EventStepChanged, step)
+	s.SubmitBuild(ctx, seedling)
+}
@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// ExampleCallFile is the example client call script. It reaches the HTTP
+	// server at ExampleCallHostEnv, localhost if that's unset, on
+	// ExampleCallPortEnv.
+	ExampleCallFile    = "example-client-call.sh"
+	ExampleCallHostEnv = "GARDEN_HTTP_HOST"
+	ExampleCallPortEnv = "GARDEN_HTTP_PORT"
+)
+
+var (
+	// dockerInspectPortRegex matches the command substitution example
+	// client call scripts written before ExampleCallPortEnv found the
+	// seedling's HTTP port with.
+	dockerInspectPortRegex = regexp.MustCompile(`\$\(\s*docker inspect\b.*?\}\}'?\s+\S+?\)`)
+	// dockerCommandRegex matches the other docker commands scripts look the
+	// port up with.
+	dockerCommandRegex = regexp.MustCompile(`(?m)(^|[;&|(\x60\s])docker\s+(inspect|port|ps|container)\b`)
+)
+
+// RefreshPorts stores the ports the seedling's container is published on if
+// they've changed.
+func (s *Pipeline) RefreshPorts(ctx context.Context, seedling *store.Seedling) error {
+	grpcPort, httpPort, err := s.PublishedPorts(ctx, *seedling)
+	if err != nil {
+		return err
+	}
+	if grpcPort == seedling.GRPCPort && httpPort == seedling.HTTPPort {
+		return nil
+	}
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET grpc_port = $1, http_port = $2 WHERE id = $3",
+		grpcPort, httpPort, seedling.ID); err != nil {
+		return err
+	}
+	seedling.GRPCPort = grpcPort
+	seedling.HTTPPort = httpPort
+	return nil
+}
+
+// GRPCServices reads the services a generated _grpc.pb.go file registers
+// from its grpc.ServiceDesc variables. The package is the proto package the
+// services are declared in.
+func GRPCServices(file string) (string, []store.EndpointService, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	pkg := ""
+	services := []store.EndpointService{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		sel, ok := lit.Type.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "ServiceDesc" {
+			return true
+		}
+		service := store.EndpointService{Methods: []string{}}
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			switch key.Name {
+			case "ServiceName":
+				service.Name = stringValue(kv.Value)
+			case "Methods", "Streams":
+				service.Methods = append(service.Methods, descNames(kv.Value)...)
+			}
+		}
+		if service.Name == "" {
+			return false
+		}
+		if i := strings.LastIndexByte(service.Name, '.'); i != -1 {
+			pkg = service.Name[:i]
+		}
+		services = append(services, service)
+		return false
+	})
+	return pkg, services, nil
+}
+
+// descNames returns the MethodName or StreamName of each grpc.MethodDesc or
+// grpc.StreamDesc in a slice literal.
+func descNames(expr ast.Expr) []string {
+	names := []string{}
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return names
+	}
+	for _, elt := range lit.Elts {
+		desc, ok := elt.(*ast.CompositeLit)
+		if !ok {
+			continue
+		}
+		for _, field := range desc.Elts {
+			kv, ok := field.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			if key, ok := kv.Key.(*ast.Ident); ok && (key.Name == "MethodName" || key.Name == "StreamName") {
+				names = append(names, stringValue(kv.Value))
+			}
+		}
+	}
+	return names
+}
+
+func stringValue(expr ast.Expr) string {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	value, _ := strconv.Unquote(lit.Value)
+	return value
+}
+
+// ExampleCurl returns the seedling's example client call script with the
+// address it's reached at as the defaults of ExampleCallHostEnv and
+// ExampleCallPortEnv, so it can be run from anywhere as it is. Older
+// scripts have the docker inspect they find the HTTP port with replaced by
+// the address.
+func (s *Pipeline) ExampleCurl(seedling store.Seedling, host string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.RepoDir(seedling), ExampleCallFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	port := strconv.Itoa(seedling.HTTPPort)
+	script := string(data)
+	if !dockerInspectPortRegex.MatchString(script) {
+		return injectExampleAddr(script, host, port), nil
+	}
+	script = dockerInspectPortRegex.ReplaceAllLiteralString(script, port)
+	return strings.ReplaceAll(script, "localhost:"+port, host+":"+port), nil
+}
+
+// exampleCallPortHook replaces the docker inspect an example client call
+// script finds the HTTP port with by ExampleCallPortEnv, and refuses
+// scripts that otherwise ask docker, which isn't there where the script is
+// run.
+func exampleCallPortHook(ctx context.Context, target HookTarget) (string, error) {
+	if filepath.Base(target.File) != ExampleCallFile {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(target.File)
+	if err != nil {
+		return "", err
+	}
+	script := dockerInspectPortRegex.ReplaceAllLiteralString(string(data), "${"+ExampleCallPortEnv+"}")
+	if dockerCommandRegex.MatchString(script) {
+		return "", fmt.Errorf("the script runs docker, which it can't where it's run: read the port from $%s", ExampleCallPortEnv)
+	}
+	if script == string(data) {
+		return "", nil
+	}
+	if err := ioutil.WriteFile(target.File, []byte(script), 0755); err != nil {
+		return "", err
+	}
+	return "replaced docker inspect with $" + ExampleCallPortEnv, nil
+}
+
+// injectExampleAddr sets the defaults of the script's address variables
+// after its shebang and set line, where it reads them.
+func injectExampleAddr(script, host, port string) string {
+	lines := strings.SplitAfter(script, "\n")
+	i := 0
+	for i < len(lines) && (strings.HasPrefix(lines[i], "#!") || strings.HasPrefix(strings.TrimSpace(lines[i]), "set -")) {
+		i++
+	}
+	defaults := fmt.Sprintf("%s=\"${%s:-%s}\"\n%s=\"${%s:-%s}\"\n",
+		ExampleCallHostEnv, ExampleCallHostEnv, host, ExampleCallPortEnv, ExampleCallPortEnv, port)
+	return strings.Join(lines[:i], "") + defaults + strings.Join(lines[i:], "")
+}
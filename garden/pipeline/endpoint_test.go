@@ -0,0 +1,210 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// exampleCall copies the example client call fixture to dir as the
+// seedling's script.
+func exampleCall(t *testing.T, dir, fixture string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "examplecall", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, ExampleCallFile)
+	if err := ioutil.WriteFile(file, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// checkScript runs the branch step's check of the script in dir.
+func checkScript(dir string) ([]byte, error) {
+	name, args := scriptCheck(ExampleCallFile)
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
+
+// runScript runs script with a curl that prints the URL it's sent to, and
+// with the address variables in env rather than any the test was run with.
+func runScript(t *testing.T, script string, env ...string) (string, error) {
+	t.Helper()
+	bin := t.TempDir()
+	curl := "#!/bin/sh\nfor arg; do url=$arg; done\necho \"$url\"\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "curl"), []byte(curl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("bash", "-c", script, ExampleCallFile, "hello")
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, ExampleCallHostEnv+"=") && !strings.HasPrefix(kv, ExampleCallPortEnv+"=") && !strings.HasPrefix(kv, "PATH=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, append(env, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return stderr.String(), err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func TestExampleCallPortHook(t *testing.T) {
+	tests := []struct {
+		fixture string
+		fixed   bool
+		refused bool
+		// invalid is whether the script fails its check.
+		invalid bool
+	}{
+		{fixture: "bashisms.sh"},
+		{fixture: "docker-inspect.sh", fixed: true},
+		{fixture: "docker-port.sh", refused: true},
+		{fixture: "unclosed-if.sh", invalid: true},
+		{fixture: "missing-do.sh", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			dir := t.TempDir()
+			file := exampleCall(t, dir, tt.fixture)
+			fix, err := exampleCallPortHook(context.Background(), HookTarget{Dir: dir, File: file})
+			if tt.refused {
+				if err == nil || !strings.Contains(err.Error(), "$"+ExampleCallPortEnv) {
+					t.Fatalf("not refused: %q, %v", fix, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (fix != "") != tt.fixed {
+				t.Errorf("fixed %q", fix)
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), "docker") {
+				t.Errorf("the script still runs docker:\n%s", data)
+			}
+			if tt.fixed && !strings.Contains(string(data), "localhost:${"+ExampleCallPortEnv+"}/v1/say") {
+				t.Errorf("the port isn't read from %s:\n%s", ExampleCallPortEnv, data)
+			}
+
+			out, err := checkScript(dir)
+			if (err != nil) != tt.invalid {
+				t.Errorf("checked with %v: %s", err, out)
+			}
+			if tt.invalid && !strings.Contains(string(out), ExampleCallFile) {
+				t.Errorf("the check's output doesn't say where the error is: %s", out)
+			}
+		})
+	}
+
+	// Other bash files are left as they are.
+	dir := t.TempDir()
+	file := filepath.Join(dir, "setup.sh")
+	if err := ioutil.WriteFile(file, []byte("docker port seedling-echo 8000\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if fix, err := exampleCallPortHook(context.Background(), HookTarget{Dir: dir, File: file}); fix != "" || err != nil {
+		t.Errorf("setup.sh: %q, %v", fix, err)
+	}
+}
+
+func TestExampleCallPrompt(t *testing.T) {
+	p := testPipeline(t, nil)
+	seedling := store.Seedling{Name: "echo"}
+	server := filepath.Join(p.RepoDir(seedling), "server")
+	if err := os.MkdirAll(server, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(server, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	step, err := p.branchStep(seedling, SeedlingStepExampleClientCall, "An echo service.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(step.prompt, "docker inspect") {
+		t.Error("the prompt still has the port looked up with docker inspect")
+	}
+	// The lines the script is told to read its address with run as they
+	// are, and fail without the port.
+	_, rest, _ := strings.Cut(step.prompt, "must fail without:\n\n```\n")
+	lines, _, ok := strings.Cut(rest, "```")
+	if !ok || !strings.Contains(lines, ExampleCallPortEnv) {
+		t.Fatalf("no address lines in the prompt:\n%s", step.prompt)
+	}
+	script := "set -eu\n" + lines + "echo \"$host:$port\"\n"
+	if out, err := runScript(t, script); err == nil || !strings.Contains(out, "set "+ExampleCallPortEnv) {
+		t.Errorf("ran without the port: %q, %v", out, err)
+	}
+	if out, err := runScript(t, script, ExampleCallPortEnv+"=32000"); err != nil || out != "localhost:32000" {
+		t.Errorf("ran with the port: %q, %v", out, err)
+	}
+}
+
+func TestExampleCurl(t *testing.T) {
+	p := testPipeline(t, nil)
+	seedling := store.Seedling{Name: "echo", HTTPPort: 32000}
+	if script, err := p.ExampleCurl(seedling, "seedlings.example.com"); script != "" || err != nil {
+		t.Errorf("without a script: %q, %v", script, err)
+	}
+
+	// The script as it's stored runs anywhere with the address it's shown
+	// with, which can still be overridden.
+	exampleCall(t, p.RepoDir(seedling), "bashisms.sh")
+	script, err := p.ExampleCurl(seedling, "seedlings.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(script, "#!/bin/bash\nset -euxo pipefail\n"+ExampleCallHostEnv+`="${`+ExampleCallHostEnv+`:-seedlings.example.com}"`) {
+		t.Errorf("the address isn't set after the set line:\n%s", script)
+	}
+	if out, err := runScript(t, script); err != nil || out != "http://seedlings.example.com:32000/v1/say" {
+		t.Errorf("ran with %q, %v", out, err)
+	}
+	if out, err := runScript(t, script, ExampleCallHostEnv+"=localhost", ExampleCallPortEnv+"=8000"); err != nil || out != "http://localhost:8000/v1/say" {
+		t.Errorf("ran overridden with %q, %v", out, err)
+	}
+
+	// As it's stored, it runs as an exec hook with the port it's given.
+	stored, err := ioutil.ReadFile(filepath.Join(p.RepoDir(seedling), ExampleCallFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Config.SeedlingHost = "10.0.0.7"
+	if out, err := runScript(t, string(stored), p.hookEnv(context.Background(), seedling, p.RepoDir(seedling))...); err != nil || out != "http://10.0.0.7:32000/v1/say" {
+		t.Errorf("ran as a hook with %q, %v", out, err)
+	}
+	if out, err := runScript(t, string(stored)); err == nil {
+		t.Errorf("ran without the port with %q", out)
+	}
+
+	// A script from before has its docker inspect replaced by the port.
+	exampleCall(t, p.RepoDir(seedling), "docker-inspect.sh")
+	if script, err = p.ExampleCurl(seedling, "seedlings.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "docker") {
+		t.Errorf("the old script still runs docker:\n%s", script)
+	}
+	if out, err := runScript(t, script); err != nil || out != "http://seedlings.example.com:32000/v1/say" {
+		t.Errorf("the old script ran with %q, %v", out, err)
+	}
+}
@@ -0,0 +1,222 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+
+	// envBlockHeaderRegex and envBlockEntryRegex match the comment block
+	// at the top of server/main.go the server prompt asks for:
+	//
+	//	// Environment:
+	//	//   SLACK_CHANNEL (required): the channel to post to
+	//	//   LOG_LEVEL (optional): debug, info or warn, info if unset
+	envBlockHeaderRegex = regexp.MustCompile(`(?i)^//\s*environment(?: variables)?:?\s*$`)
+	envBlockEntryRegex  = regexp.MustCompile(`^//\s*(?:[-*]\s*)?([A-Z][A-Z0-9_]*)(?:\s*\((required|optional)[^)]*\))?(?:\s*[:-]\s*|\s+|$)(.*)$`)
+	getenvRegex         = regexp.MustCompile(`os\.(?:Getenv|LookupEnv)\("([A-Za-z_][A-Za-z0-9_]*)"\)`)
+)
+
+// SeedlingEnvDir is where the values of a seedling's environment variables
+// are written, one file each, to be passed to its container.
+func SeedlingEnvDir(repoDir string) string {
+	return filepath.Join(repoDir, "env")
+}
+
+// parseEnvContract reads the environment variables server code declares in
+// the comment block before package main. Ones it reads with os.Getenv or
+// os.LookupEnv without declaring them are taken to be optional.
+func parseEnvContract(code string) []store.EnvRequirement {
+	reqs := []store.EnvRequirement{}
+	seen := map[string]bool{}
+	inBlock := false
+	for _, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if !strings.HasPrefix(line, "//") {
+			inBlock = false
+			continue
+		}
+		if envBlockHeaderRegex.MatchString(line) {
+			inBlock = true
+			continue
+		}
+		if !inBlock {
+			continue
+		}
+		m := envBlockEntryRegex.FindStringSubmatch(line)
+		if m == nil {
+			// A description carried on to the next line.
+			if n := len(reqs); n > 0 {
+				if more := strings.TrimSpace(strings.TrimPrefix(line, "//")); more != "" {
+					reqs[n-1].Description = strings.TrimSpace(reqs[n-1].Description + " " + more)
+				}
+			}
+			continue
+		}
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		reqs = append(reqs, store.EnvRequirement{Name: m[1], Required: m[2] != "optional", Description: strings.TrimSpace(m[3])})
+	}
+	for _, m := range getenvRegex.FindAllStringSubmatch(code, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			reqs = append(reqs, store.EnvRequirement{Name: m[1]})
+		}
+	}
+	return reqs
+}
+
+// recordEnvRequirements stores the environment variables the seedling's
+// server code reads, keeping the values of ones it still reads and
+// removing the rest, and passes them through in its compose file.
+func (s *Pipeline) recordEnvRequirements(ctx context.Context, seedling store.Seedling, code string) error {
+	reqs := parseEnvContract(code)
+	declared := map[string]bool{}
+	names := []string{}
+	for _, req := range reqs {
+		declared[req.Name] = true
+		names = append(names, req.Name)
+	}
+	dropped := []string{}
+	if err := s.InTx(ctx, func(tx *sqlx.Tx) error {
+		existing := []string{}
+		if err := tx.SelectContext(ctx, &existing,
+			"SELECT name FROM seedling_env_requirements WHERE seedling_id = $1", seedling.ID); err != nil {
+			return err
+		}
+		for _, name := range existing {
+			if declared[name] {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx,
+				"DELETE FROM seedling_env_requirements WHERE seedling_id = $1 AND name = $2", seedling.ID, name); err != nil {
+				return err
+			}
+			dropped = append(dropped, name)
+		}
+		now := time.Now()
+		for _, req := range reqs {
+			req.SeedlingID = seedling.ID
+			req.CreatedAt = now
+			req.ModifiedAt = now
+			if _, err := tx.NamedExecContext(ctx, `
+			 INSERT INTO seedling_env_requirements (seedling_id, name, required, description, created_at, modified_at)
+			 VALUES (:seedling_id, :name, :required, :description, :created_at, :modified_at)
+			 ON CONFLICT (seedling_id, name) DO UPDATE
+			 SET required = excluded.required, description = excluded.description, modified_at = excluded.modified_at
+			 `, &req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, name := range dropped {
+		if err := os.Remove(filepath.Join(SeedlingEnvDir(s.RepoDir(seedling)), name)); err != nil && !os.IsNotExist(err) {
+			logrus.WithField("error", err).Error("failed to remove env value")
+		}
+	}
+	// The addresses of its dependencies are the same wherever it's run, so
+	// the compose file sets them rather than passing them through.
+	deps, err := s.Dependencies(ctx, seedling.ID)
+	if err != nil {
+		return err
+	}
+	depEnv := s.dependencyEnv(deps)
+	for i, name := range names {
+		if value, ok := depEnv[name]; ok {
+			names[i] = name + "=" + value
+			delete(depEnv, name)
+		}
+	}
+	for name, value := range depEnv {
+		names = append(names, name+"="+value)
+	}
+	sort.Strings(names)
+	return s.writeComposeFile(seedling, names)
+}
+
+// MissingEnv is the required environment variables of the seedling that
+// aren't set.
+func (s *Pipeline) MissingEnv(ctx context.Context, seedlingID hide.Int64) ([]string, error) {
+	names := []string{}
+	if err := s.DB.SelectContext(ctx, &names, `
+	 SELECT name FROM seedling_env_requirements
+	 WHERE seedling_id = $1 AND required = TRUE AND provided = FALSE
+	 ORDER BY name
+	 `, seedlingID); err != nil {
+		return nil, err
+	}
+	// The addresses of its dependencies are always set.
+	deps, err := s.dependencyEnvNames(ctx, seedlingID)
+	if err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, name := range names {
+		if i := sort.SearchStrings(deps, name); i == len(deps) || deps[i] != name {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// seedlingEnv is the values of the seedling's environment variables that
+// are set, by name, and the addresses of its dependencies unless they're
+// set to something else.
+func (s *Pipeline) seedlingEnv(ctx context.Context, seedling store.Seedling) (map[string]string, error) {
+	names := []string{}
+	if err := s.DB.SelectContext(ctx, &names,
+		"SELECT name FROM seedling_env_requirements WHERE seedling_id = $1 AND provided = TRUE", seedling.ID); err != nil {
+		return nil, err
+	}
+	deps, err := s.Dependencies(ctx, seedling.ID)
+	if err != nil {
+		return nil, err
+	}
+	env := s.dependencyEnv(deps)
+	for _, name := range names {
+		value, err := ioutil.ReadFile(filepath.Join(SeedlingEnvDir(s.RepoDir(seedling)), name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		env[name] = string(value)
+	}
+	return env, nil
+}
+
+// awaitConfig parks a built seedling at SeedlingStepAwaitingConfig until
+// its missing environment variables are set.
+func (s *Pipeline) awaitConfig(ctx context.Context, seedling store.Seedling, missing []string) error {
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET step = $1, step_started_at = $2, version = version + 1 WHERE id = $3",
+		SeedlingStepAwaitingConfig, time.Now(), seedling.ID); err != nil {
+		return err
+	}
+	logrus.WithField("name", seedling.Name).
+		WithField("missing", strings.Join(missing, ",")).
+		Info("seedling is built, waiting for its environment variables to be set")
+	s.Notify(ctx, seedling, EventStepChanged, SeedlingStepAwaitingConfig)
+	return nil
+}
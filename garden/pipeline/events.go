@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/c2h5oh/hide"
+)
+
+const (
+	EventStepChanged     = "step_changed"
+	EventCompleted       = "completed"
+	EventFailed          = "failed"
+	EventCompletionChunk = "completion_chunk"
+	EventCompletionDone  = "completion_done"
+	// EventBuildStarted is a build's first event, carrying the settings it
+	// started with.
+	EventBuildStarted = "build_started"
+	// EventBuildOutput carries a line of a build command's output as it's
+	// printed.
+	EventBuildOutput = "build_output"
+	// EventAttemptSucceeded carries the diff stat of the attempt against the
+	// previous one at its step.
+	EventAttemptSucceeded = "attempt_succeeded"
+	// EventAttemptFailed carries the attempt and what went wrong with it.
+	EventAttemptFailed      = "attempt_failed"
+	EventQualityCheckFailed = "quality_check_failed"
+	EventCreated            = "created"
+	EventContainerLaunched  = "container_launched"
+	EventContainerAction    = "container_action"
+	EventRefined            = "refined"
+	EventDeleted            = "deleted"
+	EventRestored           = "restored"
+	// EventBitrot is sent when a scheduled rebuild of a complete seedling
+	// fails, carrying why.
+	EventBitrot = "bitrot"
+	// EventHookSucceeded and EventHookFailed are sent as each hook runs for a
+	// complete seedling, carrying the hook and, if it failed, why.
+	EventHookSucceeded = "hook_succeeded"
+	EventHookFailed    = "hook_failed"
+	// EventPromoted is sent when a seedling's promotion finishes, carrying
+	// whether it's hardened and each checklist item's result.
+	EventPromoted = "promoted"
+	// EventRevisionDeployed is sent when a seedling's container starts
+	// running one of its revisions, carrying the revision, its commit and
+	// whether the build that completed just created it.
+	EventRevisionDeployed = "revision_deployed"
+	// EventStaleDescription is sent when a build finds the fields it
+	// generates from were changed while it ran and carries on without them,
+	// and EventBuildRestarted when it's restarted with them instead. Both
+	// carry the fields.
+	EventStaleDescription = "stale_description"
+	EventBuildRestarted   = "build_restarted"
+	// EventApprovalRequested is sent when a build stops for a step's
+	// artifact to be reviewed, carrying the step and the files to review,
+	// and EventApproved and EventRejected when it's reviewed, by the API key
+	// that reviewed it. Rejections carry their comment.
+	EventApprovalRequested = "approval_requested"
+	EventApproved          = "approved"
+	EventRejected          = "rejected"
+
+	// EVENT_BUFFER is how many events a subscriber may fall behind by before
+	// further events are dropped for it.
+	EVENT_BUFFER = 256
+)
+
+// SeedlingEvent is something that happened to a seedling. Every event but
+// completion chunks and build output is also recorded in its history, see
+// emit.
+type SeedlingEvent struct {
+	ID   int64  `db:"id" json:"id,omitempty"`
+	Type string `db:"type" json:"type"`
+	Step string `db:"step" json:"step"`
+	Data string `db:"-" json:"data,omitempty"`
+	// DiffStat is set on attempt_succeeded events.
+	DiffStat *DiffStat `db:"-" json:"diffStat,omitempty"`
+	// Actor is the API key the event was caused by, or ActorSystem.
+	Actor   string       `db:"actor" json:"actor,omitempty"`
+	Payload EventPayload `db:"payload" json:"payload,omitempty"`
+	At      time.Time    `db:"created_at" json:"at"`
+}
+
+// EventBroker fans out events from a seedling's build to everyone watching
+// it. Publishing never blocks the build: a subscriber that can't keep up
+// misses events.
+type EventBroker struct {
+	mu   sync.Mutex
+	subs map[hide.Int64]map[chan SeedlingEvent]struct{}
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subs: map[hide.Int64]map[chan SeedlingEvent]struct{}{}}
+}
+
+// Subscribe returns a channel of the seedling's events and a function that
+// unsubscribes and closes it.
+func (eb *EventBroker) Subscribe(id hide.Int64) (<-chan SeedlingEvent, func()) {
+	ch := make(chan SeedlingEvent, EVENT_BUFFER)
+	eb.mu.Lock()
+	if eb.subs[id] == nil {
+		eb.subs[id] = map[chan SeedlingEvent]struct{}{}
+	}
+	eb.subs[id][ch] = struct{}{}
+	eb.mu.Unlock()
+
+	return ch, func() {
+		eb.mu.Lock()
+		delete(eb.subs[id], ch)
+		if len(eb.subs[id]) == 0 {
+			delete(eb.subs, id)
+		}
+		eb.mu.Unlock()
+		close(ch)
+	}
+}
+
+func (eb *EventBroker) Publish(id hide.Int64, event SeedlingEvent) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	for ch := range eb.subs[id] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
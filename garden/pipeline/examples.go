@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	MAX_EXAMPLES_BYTES = 4096
+)
+
+var (
+	examplesCacheDir = filepath.Join("cache", "examples")
+)
+
+type moduleDownload struct {
+	Path    string `json:"Path"`
+	Version string `json:"Version"`
+	Dir     string `json:"Dir"`
+	Error   string `json:"Error"`
+}
+
+// findModule resolves the module that provides importPath by asking `go mod
+// download` about successively shorter prefixes of the import path. It is run
+// from RepoDir so the versions in the seedling's go.mod are respected.
+func findModule(ctx context.Context, repoDir, importPath string) (moduleDownload, error) {
+	parts := strings.Split(importPath, "/")
+	for i := len(parts); i > 0; i-- {
+		candidate := strings.Join(parts[:i], "/")
+		cmd := exec.CommandContext(ctx, "go", "mod", "download", "-json", candidate)
+		cmd.Dir = repoDir
+		out, _ := cmd.Output()
+		if len(out) == 0 {
+			continue
+		}
+		var mod moduleDownload
+		if err := json.Unmarshal(out, &mod); err != nil {
+			continue
+		}
+		if mod.Error != "" || mod.Dir == "" {
+			continue
+		}
+		return mod, nil
+	}
+	return moduleDownload{}, fmt.Errorf("no module found for %s", importPath)
+}
+
+func examplesCachePath(importPath, version string) string {
+	key := CleanFilePath(strings.ReplaceAll(importPath, "/", "_")) + "@" + CleanFilePath(version)
+	return filepath.Join(examplesCacheDir, key+".txt")
+}
+
+// GetExamples returns the package synopsis, exported function synopses and
+// Example functions for importPath, formatted for inclusion in a prompt. The
+// module source is located in the module cache, and results are cached on
+// disk per module version.
+func GetExamples(ctx context.Context, repoDir, importPath string) (string, error) {
+	mod, err := findModule(ctx, repoDir, importPath)
+	if err != nil {
+		return "", err
+	}
+
+	cachePath := examplesCachePath(importPath, mod.Version)
+	if cached, err := ioutil.ReadFile(cachePath); err == nil {
+		return string(cached), nil
+	}
+
+	pkgDir := filepath.Join(mod.Dir, strings.TrimPrefix(importPath, mod.Path))
+	out, err := examplesFromDir(pkgDir, importPath)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(examplesCacheDir, 0755); err != nil {
+		logrus.WithField("error", err).Error("failed to create examples cache dir")
+		return out, nil
+	}
+	if err := ioutil.WriteFile(cachePath, []byte(out), 0644); err != nil {
+		logrus.WithField("error", err).Error("failed to write examples cache")
+	}
+
+	return out, nil
+}
+
+func examplesFromDir(pkgDir, importPath string) (string, error) {
+	set := token.NewFileSet()
+	pkgs, err := parser.ParseDir(set, pkgDir, nil, parser.ParseComments)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		synopses []string
+		examples []*doc.Example
+	)
+	for name, pkg := range pkgs {
+		files := []*ast.File{}
+		for _, f := range pkg.Files {
+			files = append(files, f)
+		}
+		examples = append(examples, doc.Examples(files...)...)
+
+		if strings.HasSuffix(name, "_test") {
+			continue
+		}
+		p := doc.New(pkg, importPath, 0)
+		if s := p.Synopsis(p.Doc); s != "" {
+			synopses = append(synopses, "package "+p.Name+": "+s)
+		}
+		for _, fn := range p.Funcs {
+			if s := p.Synopsis(fn.Doc); s != "" {
+				synopses = append(synopses, fn.Name+": "+s)
+			}
+		}
+		for _, t := range p.Types {
+			if s := p.Synopsis(t.Doc); s != "" {
+				synopses = append(synopses, t.Name+": "+s)
+			}
+			for _, fn := range t.Funcs {
+				if s := p.Synopsis(fn.Doc); s != "" {
+					synopses = append(synopses, fn.Name+": "+s)
+				}
+			}
+		}
+	}
+	if len(synopses) == 0 && len(examples) == 0 {
+		return "", errors.New("no docs or examples found in " + pkgDir)
+	}
+	sort.Slice(examples, func(i, j int) bool { return examples[i].Name < examples[j].Name })
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\nExamples and docs for %s:\n\n", importPath)
+	for _, s := range synopses {
+		if buf.Len()+len(s) > MAX_EXAMPLES_BYTES {
+			break
+		}
+		buf.WriteString(s + "\n")
+	}
+	for _, ex := range examples {
+		var code bytes.Buffer
+		if err := printer.Fprint(&code, set, ex.Code); err != nil {
+			continue
+		}
+		formatted := fmt.Sprintf("\nfunc Example%s() %s\n", ex.Name, code.String())
+		if _, ok := ex.Code.(*ast.File); ok {
+			formatted = "\n" + code.String() + "\n"
+		}
+		if ex.Output != "" {
+			formatted += "// Output:\n// " + strings.ReplaceAll(strings.TrimSpace(ex.Output), "\n", "\n// ") + "\n"
+		}
+		if buf.Len()+len(formatted) > MAX_EXAMPLES_BYTES {
+			break
+		}
+		buf.WriteString(formatted)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"regexp"
+	"strings"
+)
+
+var (
+	// errNoCode means a completion didn't contain any code at all, which is
+	// worth a reprompt rather than a build.
+	errNoCode = errors.New("no code in completion")
+
+	// proseRegex matches the chatter models put around code, e.g. "Sure!
+	// Here's the file:" or "This server listens on port 8000."
+	proseRegex = regexp.MustCompile(`(?i)^(sure|certainly|ok(ay)?|here|below|this|the (above|following)|note|i('ve| have)?)\b`)
+
+	langAliases = map[string][]string{
+		"go":         {"go", "golang"},
+		"proto":      {"proto", "protobuf", "proto3"},
+		"dockerfile": {"dockerfile", "docker"},
+		"bash":       {"bash", "sh", "shell", "zsh"},
+		"yaml":       {"yaml", "yml"},
+	}
+
+	// codeStarts are the prefixes the first line of a file of each language
+	// can start with, used to find where leading prose ends in unfenced
+	// completions.
+	codeStarts = map[string][]string{
+		"go":         {"package ", "//", "/*"},
+		"proto":      {"syntax", "package ", "//", "/*", "option ", "import "},
+		"dockerfile": {"FROM ", "ARG ", "#"},
+		"bash":       {"#!", "#", "set ", "curl ", "grpcurl "},
+		"yaml":       {"openapi:", "#", "---"},
+	}
+)
+
+type fencedBlock struct {
+	tag  string
+	code string
+}
+
+// fencedBlocks returns the fenced code blocks in text and the offset of the
+// first fence, or -1 if there are none. An unterminated block runs to the end
+// of the text. Fences inside Go raw strings outside any block don't count.
+func fencedBlocks(text, lang string) ([]fencedBlock, int) {
+	blocks := []fencedBlock{}
+	first := -1
+	inRaw := false
+	offset := 0
+	for offset < len(text) {
+		end := strings.IndexByte(text[offset:], '\n')
+		if end == -1 {
+			end = len(text) - offset
+		}
+		line := text[offset : offset+end]
+		trimmed := strings.TrimSpace(line)
+		if inRaw || !strings.HasPrefix(trimmed, "```") {
+			if strings.EqualFold(lang, "go") {
+				inRaw = scanGoLine(line, inRaw)
+			}
+			offset += end + 1
+			continue
+		}
+
+		if first == -1 {
+			first = offset
+		}
+		block := fencedBlock{tag: strings.ToLower(strings.Trim(trimmed, "` "))}
+		start := offset + end + 1
+		if start > len(text) {
+			start = len(text)
+		}
+		body := text[start:]
+		closing := closingFence(body, block.tag, true)
+		if closing == -1 {
+			block.code = body
+			offset = len(text)
+		} else {
+			block.code = body[:closing]
+			next := strings.IndexByte(body[closing:], '\n')
+			if next == -1 {
+				offset = len(text)
+			} else {
+				offset = start + closing + next + 1
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, first
+}
+
+func matchesLang(tag, lang string) bool {
+	for _, alias := range langAliases[lang] {
+		if tag == alias {
+			return true
+		}
+	}
+	return tag == strings.ToLower(lang)
+}
+
+// stripProse removes the prose a model wrote before and after unfenced code.
+func stripProse(text, lang string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+
+	start := 0
+	for start < len(lines) && isProse(lines[start], lang) {
+		start++
+	}
+	lines = lines[start:]
+
+	// Trailing chatter is a paragraph after the code that starts like prose.
+	for i := len(lines) - 1; i > 0; i-- {
+		if strings.TrimSpace(lines[i-1]) == "" && proseRegex.MatchString(strings.TrimSpace(lines[i])) {
+			lines = lines[:i]
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func isProse(line, lang string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return true
+	}
+	for _, prefix := range codeStarts[lang] {
+		if strings.HasPrefix(trimmed, prefix) {
+			return false
+		}
+	}
+	return proseRegex.MatchString(trimmed) || strings.HasSuffix(trimmed, ":")
+}
+
+// extractCode pulls the file contents out of a completion: the first fenced
+// block of the expected language (or the first untagged one) if there are
+// any fences, otherwise the completion minus any prose around it. Go code
+// must also parse, which is much cheaper to find out here than from
+// `go build`.
+func extractCode(text, lang string) (string, error) {
+	code := ""
+	if blocks, first := fencedBlocks(text, lang); len(blocks) > 0 {
+		// The prompt opens a block, so text before the first fence may be
+		// the code itself, e.g. when the model added a second example.
+		code = stripProse(text[:first], lang)
+		for _, block := range blocks {
+			if code != "" {
+				break
+			}
+			if matchesLang(block.tag, lang) {
+				code = block.code
+			}
+		}
+		for _, block := range blocks {
+			if code != "" {
+				break
+			}
+			if block.tag == "" {
+				code = block.code
+			}
+		}
+	} else {
+		code = stripProse(text, lang)
+	}
+
+	// Code the model was shown still has the header it was stamped with.
+	code = strings.TrimSpace(stripProvenance(code))
+	if code == "" {
+		return "", errNoCode
+	}
+	if lang == "go" {
+		formatted, err := format.Source([]byte(code))
+		if err != nil {
+			return code, fmt.Errorf("syntax error: %w", err)
+		}
+		code = strings.TrimSpace(string(formatted))
+	}
+	return code + "\n", nil
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtractCode(t *testing.T) {
+	const main = "package main\n\nfunc main() {}\n"
+	tests := []struct {
+		name, lang, completion string
+		// want is the code, and err the error if there isn't any.
+		want string
+		err  error
+	}{
+		{
+			name:       "prose around the block",
+			lang:       "go",
+			completion: "Sure! Here's the file:\n\n```go\npackage main\n\nfunc main() {}\n```\n\nThis server listens on port 8000.",
+			want:       main,
+		},
+		{
+			name:       "tag casing",
+			lang:       "go",
+			completion: "```Go\npackage main\n\nfunc main() {}\n```",
+			want:       main,
+		},
+		{
+			name:       "tag alias",
+			lang:       "go",
+			completion: "```golang\npackage main\n\nfunc main() {}\n```",
+			want:       main,
+		},
+		{
+			name:       "untagged block",
+			lang:       "go",
+			completion: "Here you go:\n```\npackage main\n\nfunc main() {}\n```\n",
+			want:       main,
+		},
+		{
+			name:       "block of another language first",
+			lang:       "go",
+			completion: "Run it with:\n```bash\ngo run .\n```\nThe code:\n```go\npackage main\n\nfunc main() {}\n```\n",
+			want:       main,
+		},
+		{
+			name:       "the prompt's block, then an example",
+			lang:       "go",
+			completion: "package main\n\nfunc main() {}\n```\n\nYou can call it with:\n```bash\ncurl localhost:8000\n```\n",
+			want:       main,
+		},
+		{
+			name:       "unterminated block",
+			lang:       "go",
+			completion: "```go\npackage main\n\nfunc main() {}\n",
+			want:       main,
+		},
+		{
+			name:       "unfenced with chatter",
+			lang:       "go",
+			completion: "Here is the updated code:\npackage main\n\nfunc main() {}\n\nNote that the server needs port 8000.",
+			want:       main,
+		},
+		{
+			name:       "formatted",
+			lang:       "go",
+			completion: "```go\npackage main\nfunc main()   {\n}\n```",
+			want:       "package main\n\nfunc main() {\n}\n",
+		},
+		{
+			name:       "fence in a string",
+			lang:       "go",
+			completion: "```go\npackage main\n\nvar fence = \"```\"\n```\n",
+			want:       "package main\n\nvar fence = \"```\"\n",
+		},
+		{
+			name:       "syntax error",
+			lang:       "go",
+			completion: "```go\npackage main\n\nfunc main() {\n```",
+			err:        errors.New("syntax error"),
+		},
+		{
+			name:       "refusal",
+			lang:       "go",
+			completion: "I'm not able to write that server.",
+			err:        errNoCode,
+		},
+		{
+			name:       "empty block",
+			lang:       "go",
+			completion: "```go\n```",
+			err:        errNoCode,
+		},
+		{
+			name:       "proto alias",
+			lang:       "proto",
+			completion: "Certainly!\n```protobuf\nsyntax = \"proto3\";\n\npackage echo;\n```\nThis defines the service.",
+			want:       "syntax = \"proto3\";\n\npackage echo;\n",
+		},
+		{
+			name:       "unfenced dockerfile",
+			lang:       "dockerfile",
+			completion: "Here's a Dockerfile:\n\nFROM golang:1.20\nRUN go build -o /server ./server\n\nThis builds the server.",
+			want:       "FROM golang:1.20\nRUN go build -o /server ./server\n",
+		},
+		{
+			name:       "shell alias",
+			lang:       "bash",
+			completion: "```sh\n#!/bin/bash\ngrpcurl -plaintext localhost:8001 list\n```",
+			want:       "#!/bin/bash\ngrpcurl -plaintext localhost:8001 list\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractCode(tt.completion, tt.lang)
+			switch {
+			case tt.err == errNoCode:
+				if !errors.Is(err, errNoCode) {
+					t.Fatalf("error %v, want %v", err, errNoCode)
+				}
+			case tt.err != nil:
+				if err == nil || errors.Is(err, errNoCode) || !strings.Contains(err.Error(), tt.err.Error()) {
+					t.Fatalf("error %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case got != tt.want:
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"strings"
+)
+
+// closingFence finds the end of the code block a completion is written in.
+// Prompts open the block (e.g. "```go\n"), so the completion starts inside
+// it, and this returns the offset of the line that closes it, or -1 if it
+// hasn't been closed yet.
+//
+// Only complete lines are considered unless final is set, since a streamed
+// line may still grow. A fence with a language tag opens a nested block that
+// needs its own closing fence, and for Go, backticks inside raw string
+// literals are ignored so a "```" embedded in one doesn't end the file.
+func closingFence(text, lang string, final bool) int {
+	depth := 0
+	inRaw := false
+	offset := 0
+	for offset < len(text) {
+		end := strings.IndexByte(text[offset:], '\n')
+		if end == -1 {
+			if !final {
+				return -1
+			}
+			end = len(text) - offset
+		}
+		line := text[offset : offset+end]
+
+		if !inRaw {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, "```") {
+				tag := strings.Trim(trimmed, "`")
+				if tag != "" {
+					depth++
+				} else if depth > 0 {
+					depth--
+				} else {
+					return offset
+				}
+				offset += end + 1
+				continue
+			}
+		}
+		if strings.EqualFold(lang, "go") {
+			inRaw = scanGoLine(line, inRaw)
+		}
+		offset += end + 1
+	}
+	return -1
+}
+
+// scanGoLine reports whether a raw string literal is still open at the end
+// of a line of Go, given whether one was open at its start. Comments and
+// interpreted string and rune literals are skipped so their backticks don't
+// count. Multi-line block comments aren't tracked; generated code rarely has
+// backticks in them.
+func scanGoLine(line string, inRaw bool) bool {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if inRaw {
+			if c == '`' {
+				inRaw = false
+			}
+			continue
+		}
+		switch c {
+		case '/':
+			if i+1 < len(line) && line[i+1] == '/' {
+				return false
+			}
+		case '"', '\'':
+			for i++; i < len(line) && line[i] != c; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case '`':
+			inRaw = true
+		}
+	}
+	return inRaw
+}
@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+	"github.com/tensorscale/garden/garden/storetest"
+)
+
+func TestClosingFence(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		lang  string
+		final bool
+		want  string // the text before the closing fence, or "-" if none
+	}{
+		{"closed", "package main\n```\nafter", "go", false, "package main\n"},
+		{"not closed", "package main\n", "go", false, "-"},
+		{"fence still streaming", "package main\n``", "go", false, "-"},
+		{"fence at the end", "package main\n```", "go", true, "package main\n"},
+		{"fence with spaces", "package main\n  ```  \n", "go", false, "package main\n"},
+		{"fence in a string", "fmt.Println(\"```\")\n```\n", "go", false, "fmt.Println(\"```\")\n"},
+		{"fences in raw strings", "const usage = `Run:\n` + \"```\" + `\ngo run .\n` + \"```\"\n```\n", "go", false, "const usage = `Run:\n` + \"```\" + `\ngo run .\n` + \"```\"\n"},
+		{"backtick in a string", "var s = \"`\"\nvar r = '`'\n```\n", "go", false, "var s = \"`\"\nvar r = '`'\n"},
+		{"backtick in a comment", "// use `go run`\n```\n", "go", false, "// use `go run`\n"},
+		{"raw strings only in go", "echo `date`\n```\n", "bash", false, "echo `date`\n"},
+		{"nested block", "# Usage\n```bash\ncurl localhost\n```\n```\n", "markdown", false, "# Usage\n```bash\ncurl localhost\n```\n"},
+		{"nested block not closed", "# Usage\n```bash\ncurl localhost\n```\n", "markdown", false, "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := "-"
+			if end := closingFence(tt.text, tt.lang, tt.final); end != -1 {
+				got = tt.text[:end]
+			}
+			if got != tt.want {
+				t.Errorf("closingFence(%q) cut at %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// streamLLM streams its chunks, until the caller stops it, or fails with err
+// without streaming anything.
+type streamLLM struct {
+	chunks   []string
+	err      error
+	streamed int
+}
+
+func (f *streamLLM) Complete(ctx context.Context, prompt string, opts llm.CompletionOptions) (string, error) {
+	return strings.Join(f.chunks, ""), nil
+}
+
+func (f *streamLLM) CompleteStream(ctx context.Context, prompt string, opts llm.CompletionOptions, onChunk func(string) bool) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	var text strings.Builder
+	for _, chunk := range f.chunks {
+		f.streamed++
+		text.WriteString(chunk)
+		if !onChunk(chunk) {
+			break
+		}
+	}
+	return text.String(), nil
+}
+
+// testPipeline is a pipeline with a database and data dir under t's temp
+// dir, which completes with provider.
+func testPipeline(t *testing.T, provider llm.LLM) *Pipeline {
+	t.Helper()
+	t.Setenv("DATA_DIR", t.TempDir())
+	config := LoadConfig()
+	config.BuildRunner = BuildRunnerDocker
+	p, err := New(Deps{DB: storetest.Open(t), Config: config, LLM: provider})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCompleteStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+		// streamed is how many chunks are read before the fence is found.
+		streamed int
+	}{
+		{
+			name:     "fence in its own chunk",
+			chunks:   []string{"package main\n", "```", "\n", "trailing prose\n"},
+			want:     "package main\n",
+			streamed: 3,
+		},
+		{
+			name:     "fence split across chunks",
+			chunks:   []string{"package main\n`", "``\nmore", "\n"},
+			want:     "package main\n",
+			streamed: 2,
+		},
+		{
+			name:     "fence in a string",
+			chunks:   []string{"fmt.Println(\"", "```", "\")\n", "```\n", "ignored\n"},
+			want:     "fmt.Println(\"```\")\n",
+			streamed: 4,
+		},
+		{
+			name:     "fences in raw strings",
+			chunks:   []string{"const usage = `Run:\n", "` + \"```\" + `\n", "go run .\n", "` + \"```\"\n", "```\n", "ignored\n"},
+			want:     "const usage = `Run:\n` + \"```\" + `\ngo run .\n` + \"```\"\n",
+			streamed: 5,
+		},
+		{
+			name:     "never closed",
+			chunks:   []string{"package main\n", "func main() {}"},
+			want:     "package main\nfunc main() {}",
+			streamed: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &streamLLM{chunks: tt.chunks}
+			p := testPipeline(t, provider)
+			seedling := store.Seedling{DBRow: store.DBRow{ID: 1}, Name: "echo"}
+			events, unsubscribe := p.Events.Subscribe(seedling.ID)
+			defer unsubscribe()
+
+			got, _, err := p.complete(context.Background(), seedling, SeedlingStepServer, "go", "```go\n", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("completed %q, want %q", got, tt.want)
+			}
+			if provider.streamed != tt.streamed {
+				t.Errorf("read %d chunks, want %d", provider.streamed, tt.streamed)
+			}
+
+			var chunks []string
+			for {
+				select {
+				case event := <-events:
+					switch event.Type {
+					case EventCompletionChunk:
+						chunks = append(chunks, event.Data)
+						continue
+					case EventCompletionDone:
+						if event.Data != tt.want {
+							t.Errorf("%s event of %q, want %q", event.Type, event.Data, tt.want)
+						}
+					default:
+						t.Fatalf("unexpected %s event", event.Type)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("no completion_done event")
+				}
+				break
+			}
+			if want := tt.chunks[:tt.streamed]; strings.Join(chunks, "|") != strings.Join(want, "|") {
+				t.Errorf("published chunks %q, want %q", chunks, want)
+			}
+		})
+	}
+}
+
+func TestCompleteStreamFallback(t *testing.T) {
+	provider := &streamLLM{chunks: []string{"package main\n```\n"}, err: errors.New("model does not support streaming")}
+	p := testPipeline(t, provider)
+	got, _, err := p.complete(context.Background(), store.Seedling{DBRow: store.DBRow{ID: 1}}, SeedlingStepServer, "go", "```go\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "package main\n" {
+		t.Errorf("completed %q, want the plain completion cut at its fence", got)
+	}
+}
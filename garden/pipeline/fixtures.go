@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	LLMProviderOpenAI = "openai"
+	// LLMProviderFixture replays completions recorded in FixturesDir
+	// instead of calling OpenAI.
+	LLMProviderFixture = "fixture"
+	// LLMProviderRecord calls OpenAI and records every completion to
+	// FixturesDir.
+	LLMProviderRecord = "record"
+)
+
+// KeyLLM is the provider of the OpenAI key with the id. Fixtures are
+// replayed whichever key a seedling was created with.
+func (s *Pipeline) KeyLLM(id, key string) llm.LLM {
+	switch s.Config.LLMProvider {
+	case LLMProviderFixture:
+		return s.LLM
+	case LLMProviderRecord:
+		return llm.NewRecorder(llm.NewOpenAI(key, ObserveLLMTokens(id)), s.Config.FixturesDir, s.RedactFixture)
+	}
+	return llm.NewOpenAI(key, ObserveLLMTokens(id))
+}
+
+// RedactFixture masks what the seedling with the name mustn't have recorded:
+// its secrets and env values, and garden's keys.
+func (s *Pipeline) RedactFixture(name, text string) string {
+	ctx := context.Background()
+	seedling := store.Seedling{Name: name}
+	names := []string{}
+	if err := s.DB.GetContext(ctx, &seedling,
+		"SELECT * FROM seedlings WHERE name = $1 ORDER BY id DESC LIMIT 1", name); err == nil {
+		if names, err = s.SecretNames(ctx, seedling.ID); err != nil {
+			logrus.WithField("error", err).Error("failed to get secret names")
+		}
+	}
+	red, err := s.SeedlingRedactor(seedling, names)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to read seedling secrets, recording only the patterns redacted")
+		red = &redactor{patterns: s.RedactPatterns}
+	}
+	return red.Redact(text)
+}
@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"path/filepath"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// GardenDir is the directory the garden's seedling repos are created in.
+func (s *Pipeline) GardenDir(garden string) string {
+	if garden == store.DefaultGarden {
+		return filepath.Join(s.Config.ReposDir(), "seedlings")
+	}
+	return filepath.Join(s.Config.ReposDir(), garden)
+}
+
+// GardenNetwork is the docker network the garden's containers are attached
+// to.
+func (s *Pipeline) GardenNetwork(garden string) string {
+	if garden == store.DefaultGarden {
+		return s.Config.NetworkName
+	}
+	return s.Config.NetworkName + "-" + garden
+}
+
+// RepoDir is the seedling's repo. Seedlings in the default garden may still
+// have a directory in the shared legacy repo.
+func (s *Pipeline) RepoDir(seedling store.Seedling) string {
+	if seedling.GardenName() == store.DefaultGarden {
+		return s.seedlingRepoDir(seedling.Name)
+	}
+	return filepath.Join(s.GardenDir(seedling.GardenName()), seedling.Name)
+}
+
+// ContainerName names the seedling's container and image: its resource name
+// behind CONTAINER_PREFIX, so that it's unique on a docker daemon shared by
+// several instances.
+func (s *Pipeline) ContainerName(seedling store.Seedling) string {
+	return s.Config.ContainerPrefix + seedling.ResourceName()
+}
+
+// network is the docker network of the seedling's garden.
+func (s *Pipeline) network(seedling store.Seedling) string {
+	return s.GardenNetwork(seedling.GardenName())
+}
@@ -0,0 +1,7 @@
+package pipeline
+
+// SeedlingOutputsDir is where the seedling's container writes /outputs,
+// which is where the local blob store keeps them too.
+func (s *Pipeline) SeedlingOutputsDir(name string) string {
+	return s.Config.DataPath(BlobRoot, "outputs", name)
+}
@@ -0,0 +1,164 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// GitTokenSecret is the name of the seedling secret holding the token
+	// used to push the seedling's repo. It takes precedence over GIT_TOKEN.
+	GitTokenSecret = "git-token"
+)
+
+// legacyRepoDir is where seedlings created before per-seedling repos live,
+// as subdirectories of one shared repo.
+func (s *Pipeline) legacyRepoDir(name string) string {
+	return filepath.Join(s.Config.SharedRepoDir(), name)
+}
+
+// seedlingRepoDir is the seedling's own git repo, or its directory in the
+// shared repo if it predates per-seedling repos.
+func (s *Pipeline) seedlingRepoDir(name string) string {
+	if _, err := os.Stat(s.legacyRepoDir(name)); err == nil {
+		return s.legacyRepoDir(name)
+	}
+	return filepath.Join(s.GardenDir(store.DefaultGarden), name)
+}
+
+// HasOwnRepo reports whether the seedling repo dir is its own git repo rather
+// than a directory in the shared one.
+func HasOwnRepo(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// InitSeedlingRepo creates the seedling's own git repo in dir.
+func InitSeedlingRepo(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, "git", "init", "-q")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git init: %w: %s", err, out)
+	}
+	return nil
+}
+
+// CommitRepo stages and commits everything under dir. There may be nothing
+// to commit, e.g. for an untracked orphaned directory, so failures are only
+// logged.
+func CommitRepo(ctx context.Context, dir, message string) {
+	cmd := exec.CommandContext(ctx, "git", "add", "-A", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		logrus.WithField("error", err).WithField("output", string(out)).Debug("failed to stage changes")
+		return
+	}
+	cmd = exec.CommandContext(ctx, "git", "commit", "-q", "-m", message)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		logrus.WithField("error", err).WithField("output", string(out)).Debug("nothing to commit")
+	}
+}
+
+// commitMessage is the message of the commit a successful attempt makes, so
+// the repo's history can be read back to the seedling's attempts.
+func commitMessage(seedling store.Seedling, step string, attempt int) string {
+	return fmt.Sprintf("seedling %s: %s attempt %d", seedling.Name, step, attempt)
+}
+
+// gitToken returns the token to push the seedling's repo with, or "" to push
+// without one.
+func (s *Pipeline) gitToken(seedling store.Seedling) (string, error) {
+	token, err := ioutil.ReadFile(filepath.Join(SeedlingSecretsDir(s.RepoDir(seedling)), GitTokenSecret))
+	if os.IsNotExist(err) {
+		return s.Config.GitToken, nil
+	}
+	return strings.TrimSpace(string(token)), err
+}
+
+// PushRemote pushes the seedling's repo to its remote branch, creating the
+// branch if needed, and records the outcome on the seedling. The token is
+// passed to git through the environment so it never appears in arguments,
+// logs or the recorded error.
+func (s *Pipeline) PushRemote(ctx context.Context, seedling *store.Seedling) error {
+	err := s.push(ctx, seedling)
+	if err != nil {
+		seedling.GitPushError = err.Error()
+	} else {
+		now := time.Now()
+		seedling.GitPushError = ""
+		seedling.GitPushedURL = seedling.GitRemoteURL
+		seedling.GitPushedAt = &now
+	}
+	if _, dbErr := s.DB.NamedExecContext(ctx, `
+	 UPDATE seedlings SET
+	   git_pushed_url = :git_pushed_url,
+	   git_pushed_sha = :git_pushed_sha,
+	   git_pushed_at = :git_pushed_at,
+	   git_push_error = :git_push_error
+	 WHERE id = :id
+	 `, seedling); dbErr != nil {
+		logrus.WithField("error", dbErr).Error("failed to record seedling push")
+	}
+	return err
+}
+
+func (s *Pipeline) push(ctx context.Context, seedling *store.Seedling) error {
+	if seedling.GitRemoteURL == "" {
+		return errors.New("seedling has no git remote configured")
+	}
+	if !HasOwnRepo(s.RepoDir(*seedling)) {
+		return errors.New("seedling predates per-seedling repos and can't be pushed")
+	}
+	token, err := s.gitToken(*seedling)
+	if err != nil {
+		return err
+	}
+
+	dir := s.RepoDir(*seedling)
+	revParse := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	revParse.Dir = dir
+	sha, err := revParse.Output()
+	if err != nil {
+		return fmt.Errorf("git rev-parse: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "push", "--porcelain",
+		seedling.GitRemoteURL, "HEAD:refs/heads/"+seedling.GitBranch)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if token != "" {
+		header := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:"+token))
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0="+header,
+		)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		output := string(out)
+		if token != "" {
+			output = strings.ReplaceAll(output, token, "[redacted]")
+		}
+		return fmt.Errorf("git push: %w: %s", err, strings.TrimSpace(output))
+	}
+
+	seedling.GitPushedSHA = strings.TrimSpace(string(sha))
+	logrus.WithField("name", seedling.Name).
+		WithField("branch", seedling.GitBranch).
+		WithField("sha", seedling.GitPushedSHA).
+		Info("Pushed seedling repo")
+	return nil
+}
@@ -0,0 +1,64 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+)
+
+type goTestEvent struct {
+	Action  string `json:"Action"`
+	Package string `json:"Package"`
+	Test    string `json:"Test"`
+	Output  string `json:"Output"`
+}
+
+// parseGoTestJSON counts passed and failed tests in `go test -json` output and
+// returns a human readable summary of the failures (including any non-JSON
+// lines, e.g. build errors) suitable for feeding back into the fix loop.
+func parseGoTestJSON(output string) (int, int, string) {
+	passed, failed := 0, 0
+	testOutput := map[string][]string{}
+	failures := []string{}
+	other := []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var ev goTestEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil || ev.Action == "" {
+			if strings.TrimSpace(line) != "" {
+				other = append(other, line)
+			}
+			continue
+		}
+
+		switch ev.Action {
+		case "output":
+			if ev.Test != "" {
+				testOutput[ev.Test] = append(testOutput[ev.Test], strings.TrimRight(ev.Output, "\n"))
+			} else if strings.Contains(ev.Output, "FAIL") || strings.Contains(ev.Output, ".go:") {
+				other = append(other, strings.TrimRight(ev.Output, "\n"))
+			}
+		case "pass":
+			if ev.Test != "" {
+				passed++
+			}
+		case "fail":
+			if ev.Test != "" {
+				failed++
+				failures = append(failures, ev.Test)
+			}
+		}
+	}
+
+	summary := []string{}
+	for _, test := range failures {
+		summary = append(summary, "--- FAIL: "+test)
+		summary = append(summary, testOutput[test]...)
+	}
+	summary = append(summary, other...)
+
+	return passed, failed, strings.Join(summary, "\n")
+}
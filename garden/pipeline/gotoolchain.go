@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/dockerx"
+)
+
+// DefaultGoToolchain is the Go version seedlings are built with unless
+// GO_TOOLCHAIN or the seedling sets one. It's the builder Dockerfile's
+// default GO_VERSION.
+const DefaultGoToolchain = "1.21"
+
+var (
+	// DefaultGoToolchains are the versions seedlings may be built with
+	// unless GO_TOOLCHAINS lists others. Each needs a golang:<version>-bookworm
+	// image.
+	DefaultGoToolchains = []string{"1.19", "1.20", "1.21", "1.22"}
+
+	goDirectiveRegex = regexp.MustCompile(`(?m)^go [0-9.]+[ \t]*$`)
+	// goToolchainLineRegex matches the toolchain line go 1.21 and later
+	// add to go.mod, which would override the go directive.
+	goToolchainLineRegex = regexp.MustCompile(`(?m)^toolchain [^\n]*\n?`)
+)
+
+// CheckGoToolchain returns why the version can't be built with, or "" if it
+// can.
+func (s *Pipeline) CheckGoToolchain(version string) string {
+	for _, v := range s.Config.GoToolchains {
+		if v == version {
+			return ""
+		}
+	}
+	return fmt.Sprintf("toolchain must be one of %s", strings.Join(s.Config.GoToolchains, ", "))
+}
+
+// GoImage is the image seedling Dockerfiles build the server in.
+func GoImage(version string) string {
+	return "golang:" + version + "-bookworm"
+}
+
+// builderImageFor is the builder image commands of seedlings built with the
+// version run in: image tagged go<version>, unless image already has a tag,
+// in which case every version runs in it.
+func builderImageFor(image, version string) string {
+	if strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		return image
+	}
+	return image + ":go" + version
+}
+
+// setGoDirective makes the go.mod in dir require the version.
+func setGoDirective(dir, version string) error {
+	path := filepath.Join(dir, "go.mod")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data = goToolchainLineRegex.ReplaceAll(data, nil)
+	if goDirectiveRegex.Match(data) {
+		data = goDirectiveRegex.ReplaceAll(data, []byte("go "+version))
+	} else {
+		data = append(bytes.TrimRight(data, "\n"), []byte("\n\ngo "+version+"\n")...)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// builderImages remembers which builder images are present, building the
+// default one for a version the first time a build needs it.
+type builderImages struct {
+	docker dockerx.ContainerRuntime
+	mu     sync.Mutex
+	ready  map[string]bool
+}
+
+func NewBuilderImages(docker dockerx.ContainerRuntime) *builderImages {
+	return &builderImages{docker: docker, ready: map[string]bool{}}
+}
+
+// Ensure makes sure the builder image for the version is present. Images
+// other than DefaultBuilderImage are expected to have been pulled or built
+// already.
+func (b *builderImages) Ensure(ctx context.Context, image, version string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tagged := builderImageFor(image, version)
+	if b.ready[tagged] {
+		return nil
+	}
+	if _, err := b.docker.ImageSize(ctx, tagged); errors.Is(err, dockerx.ErrImageMissing) {
+		if image != DefaultBuilderImage {
+			return fmt.Errorf("builder image %s not found", tagged)
+		}
+		logrus.WithField("image", tagged).Info("Building builder image")
+		if err := b.docker.Build(ctx, dockerx.BuildOptions{
+			Tag:        tagged,
+			Dockerfile: builderDockerfile,
+			BuildArgs:  map[string]string{"GO_VERSION": version},
+		}); err != nil {
+			return fmt.Errorf("docker build builder image: %w", err)
+		}
+	} else if err != nil {
+		return err
+	}
+	b.ready[tagged] = true
+	return nil
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// GRPCSmokeTimeout is how long the smoke test waits for a newly started
+// container to accept gRPC connections and answer reflection requests.
+const GRPCSmokeTimeout = 30 * time.Second
+
+// reflectionHint asks the model to register reflection for the smoke test,
+// as instruction n of the server prompt.
+func (s *Pipeline) reflectionHint(n int) string {
+	if !s.Config.GRPCSmokeTest {
+		return ""
+	}
+	return fmt.Sprintf("\n%d. Register gRPC server reflection on the gRPC server with reflection.Register\n"+
+		"    from google.golang.org/grpc/reflection.\n", n)
+}
+
+// reflectServices lists the services the gRPC server at addr exposes and
+// the methods of each, by server reflection.
+func reflectServices(ctx context.Context, addr string) (map[string][]string, error) {
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("gRPC server isn't accepting connections: %w", err)
+	}
+	defer conn.Close()
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+	ask := func(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if status.Code(err) == codes.Unimplemented {
+			return nil, errors.New("gRPC server doesn't register server reflection, register it with reflection.Register")
+		}
+		return resp, err
+	}
+
+	resp, err := ask(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	services := map[string][]string{}
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		name := service.GetName()
+		if strings.HasPrefix(name, "grpc.reflection.") {
+			continue
+		}
+		resp, err := ask(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: name},
+		})
+		if err != nil {
+			return nil, err
+		}
+		services[name] = []string{}
+		for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			var fd descriptorpb.FileDescriptorProto
+			if err := proto.Unmarshal(data, &fd); err != nil {
+				return nil, fmt.Errorf("invalid file descriptor for %s: %w", name, err)
+			}
+			for _, sd := range fd.GetService() {
+				full := sd.GetName()
+				if fd.GetPackage() != "" {
+					full = fd.GetPackage() + "." + full
+				}
+				if full != name {
+					continue
+				}
+				for _, method := range sd.GetMethod() {
+					services[name] = append(services[name], method.GetName())
+				}
+			}
+		}
+	}
+	return services, nil
+}
+
+// grpcSmokeTest checks that the seedling's running container serves every
+// rpc its proto declares on its gRPC port, storing what it found on the
+// seedling. The error is for the model to fix if the server didn't serve
+// them all, unless the test couldn't be run and no result is returned.
+func (s *Pipeline) grpcSmokeTest(ctx context.Context, seedling store.Seedling) (*store.GRPCSmoke, error) {
+	_, declared, err := GRPCServices(filepath.Join(s.RepoDir(seedling), "protobufs", seedling.Name+"_grpc.pb.go"))
+	if err != nil {
+		return nil, err
+	}
+	smoke := &store.GRPCSmoke{Services: []store.EndpointService{}, Missing: []string{}, CheckedAt: time.Now()}
+	if seedling.GRPCPort == 0 {
+		err = fmt.Errorf("container doesn't publish the gRPC port %d", seedling.SeedlingPorts.WithDefaults().GRPCContainerPort)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, GRPCSmokeTimeout)
+		defer cancel()
+		var exposed map[string][]string
+		if exposed, err = reflectServices(ctx, fmt.Sprintf("localhost:%d", seedling.GRPCPort)); err == nil {
+			for name, methods := range exposed {
+				smoke.Services = append(smoke.Services, store.EndpointService{Name: name, Methods: methods})
+			}
+			sort.Slice(smoke.Services, func(i, j int) bool { return smoke.Services[i].Name < smoke.Services[j].Name })
+			smoke.Missing = missingRPCs(declared, exposed)
+		}
+	}
+	if err == nil && len(smoke.Missing) > 0 {
+		err = errors.New(strings.Join(smoke.Missing, "\n"))
+	}
+	if err != nil {
+		smoke.Error = err.Error()
+	}
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET grpc_smoke = $1 WHERE id = $2", smoke, seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to store gRPC smoke test")
+	}
+	return smoke, err
+}
+
+// missingRPCs describes each declared service and rpc that isn't exposed.
+func missingRPCs(declared []store.EndpointService, exposed map[string][]string) []string {
+	missing := []string{}
+	for _, service := range declared {
+		methods, ok := exposed[service.Name]
+		if !ok {
+			missing = append(missing, "gRPC server does not expose service "+service.Name)
+			continue
+		}
+		has := map[string]bool{}
+		for _, method := range methods {
+			has[method] = true
+		}
+		for _, method := range service.Methods {
+			if !has[method] {
+				missing = append(missing, "gRPC server does not expose rpc "+method)
+			}
+		}
+	}
+	return missing
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ActorSystem is who events raised by builds and background loops,
+	// rather than API requests, are attributed to.
+	ActorSystem = "system"
+
+	// MAX_EVENT_TEXT is how much of an error or instruction an event's
+	// payload keeps. Build output stays in the attempts.
+	MAX_EVENT_TEXT = 500
+)
+
+// EventPayload is the small JSON object of details an event carries.
+type EventPayload map[string]interface{}
+
+func (p EventPayload) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *EventPayload) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into EventPayload", src)
+	}
+	return json.Unmarshal(data, p)
+}
+
+// EventText shortens text to MAX_EVENT_TEXT for an event's payload.
+func EventText(text string) string {
+	if len(text) <= MAX_EVENT_TEXT {
+		return text
+	}
+	return text[:MAX_EVENT_TEXT] + "…"
+}
+
+// actorFromContext is who an event is attributed to: the API key of the
+// request it happened in, or ActorSystem outside of one.
+func actorFromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(apiKeyKey{}).(string); ok {
+		return name
+	}
+	if RequestIDFromContext(ctx) != "" {
+		return AnonymousKey
+	}
+	return ActorSystem
+}
+
+// Emit appends the event to the seedling's history and publishes it to
+// everyone watching the seedling, so the live and historical views see the
+// same events. Failing to record it is logged, not returned.
+func (s *Pipeline) Emit(ctx context.Context, seedlingID hide.Int64, event SeedlingEvent) {
+	event.At = time.Now()
+	event.Actor = actorFromContext(ctx)
+	result, err := s.DB.ExecContext(ctx, `
+	 INSERT INTO seedling_events (seedling_id, type, step, actor, payload, created_at)
+	 VALUES ($1, $2, $3, $4, $5, $6)
+	 `, seedlingID, event.Type, event.Step, event.Actor, event.Payload, event.At)
+	if err != nil {
+		logrus.WithField("error", err).WithField("type", event.Type).Error("failed to record seedling event")
+	} else {
+		event.ID, _ = result.LastInsertId()
+	}
+	s.Events.Publish(seedlingID, event)
+}
@@ -0,0 +1,291 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	HookKindExec = "exec"
+	HookKindHTTP = "http"
+
+	HookStatusSucceeded = "succeeded"
+	HookStatusFailed    = "failed"
+
+	// DefaultHookTimeout is how long a hook may run without a timeout of
+	// its own, MAX_HOOK_TIMEOUT_SECONDS the longest it may set.
+	DefaultHookTimeout       = time.Minute
+	MAX_HOOK_TIMEOUT_SECONDS = 3600
+	// MAX_HOOK_OUTPUT_BYTES is how much of an exec hook's output, or an
+	// HTTP hook's response, is kept with its run.
+	MAX_HOOK_OUTPUT_BYTES = 16 * 1024
+)
+
+// Hook is run for every seedling of its garden, or of every garden, once
+// it's built and its container is running. Exec hooks run Command in the
+// seedling's repo with its metadata in GARDEN_SEEDLING_* env vars; HTTP
+// hooks POST the seedling to URL, signed like webhooks.
+type Hook struct {
+	store.DBRow
+	Name string `db:"name" json:"name"`
+	// Garden is "" for hooks run for every garden's seedlings.
+	Garden string `db:"garden" json:"garden"`
+	// Position orders the hooks, lowest first.
+	Position       int      `db:"position" json:"position"`
+	Kind           string   `db:"kind" json:"kind"`
+	CommandJSON    string   `db:"command" json:"-"`
+	Command        []string `db:"-" json:"command,omitempty"`
+	URL            string   `db:"url" json:"url,omitempty"`
+	Secret         string   `db:"secret" json:"secret,omitempty"`
+	TimeoutSeconds int      `db:"timeout_seconds" json:"timeoutSeconds,omitempty"`
+	// Blocking hooks hold the seedling at SeedlingStepAwaitingHooks until
+	// they succeed.
+	Blocking bool `db:"blocking" json:"blocking"`
+}
+
+// SeedlingHook is the last run of a hook for a seedling.
+type SeedlingHook struct {
+	SeedlingID hide.Int64 `db:"seedling_id" json:"-"`
+	HookID     hide.Int64 `db:"hook_id" json:"hookId"`
+	Name       string     `db:"name" json:"name"`
+	Blocking   bool       `db:"blocking" json:"blocking"`
+	// CommitSHA is the commit of the seedling's repo the hook ran at. A
+	// hook that succeeded runs again once the seedling is rebuilt.
+	CommitSHA  string    `db:"commit_sha" json:"commitSha"`
+	Status     string    `db:"status" json:"status"`
+	Attempts   int       `db:"attempts" json:"attempts"`
+	Output     string    `db:"output" json:"output,omitempty"`
+	Error      string    `db:"error" json:"error,omitempty"`
+	StartedAt  time.Time `db:"started_at" json:"startedAt"`
+	FinishedAt time.Time `db:"finished_at" json:"finishedAt"`
+}
+
+func (h *Hook) Decode() {
+	h.Command = nil
+	if h.CommandJSON != "" {
+		if err := json.Unmarshal([]byte(h.CommandJSON), &h.Command); err != nil {
+			logrus.WithField("error", err).WithField("hook", h.Name).Warn("failed to decode hook command")
+		}
+	}
+}
+
+func (h Hook) timeout() time.Duration {
+	if h.TimeoutSeconds > 0 {
+		return time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	return DefaultHookTimeout
+}
+
+// gardenHooks returns the hooks run for the garden's seedlings, in the order
+// they run.
+func (s *Pipeline) gardenHooks(ctx context.Context, garden string) ([]Hook, error) {
+	hooks := []Hook{}
+	if err := s.DB.SelectContext(ctx, &hooks,
+		"SELECT * FROM hooks WHERE garden = '' OR garden = $1 ORDER BY position, id", garden); err != nil {
+		return nil, err
+	}
+	for i := range hooks {
+		hooks[i].Decode()
+	}
+	return hooks, nil
+}
+
+// HooksFor returns the last run of each hook that's run for the
+// seedling.
+func (s *Pipeline) HooksFor(ctx context.Context, seedlingID hide.Int64) ([]SeedlingHook, error) {
+	runs := []SeedlingHook{}
+	err := s.DB.SelectContext(ctx, &runs, `
+	 SELECT seedling_hooks.*, hooks.name, hooks.blocking
+	 FROM seedling_hooks JOIN hooks ON hooks.id = seedling_hooks.hook_id
+	 WHERE seedling_hooks.seedling_id = $1 ORDER BY hooks.position, hooks.id
+	 `, seedlingID)
+	return runs, err
+}
+
+// RunHooks runs the seedling's hooks in order, skipping those that already
+// succeeded at its repo's commit, and records each run. It stops at the
+// first blocking hook that fails and returns it; hooks that aren't blocking
+// only have their failures recorded.
+func (s *Pipeline) RunHooks(ctx context.Context, seedling store.Seedling) (*Hook, error) {
+	hooks, err := s.gardenHooks(ctx, seedling.Garden)
+	if err != nil || len(hooks) == 0 {
+		return nil, err
+	}
+	runs, err := s.HooksFor(ctx, seedling.ID)
+	if err != nil {
+		return nil, err
+	}
+	last := map[hide.Int64]SeedlingHook{}
+	for _, run := range runs {
+		last[run.HookID] = run
+	}
+	// Seedlings that were never committed run their hooks every time.
+	commit, _ := RepoHead(ctx, s.RepoDir(seedling))
+
+	for i := range hooks {
+		hook := hooks[i]
+		if run, ok := last[hook.ID]; ok && commit != "" && run.Status == HookStatusSucceeded && run.CommitSHA == commit {
+			continue
+		}
+		started := time.Now()
+		output, runErr := s.runHook(ctx, hook, seedling)
+		if err := s.recordHookRun(ctx, seedling, hook, commit, output, runErr, started); err != nil {
+			logrus.WithField("error", err).Error("failed to record hook run")
+		}
+		log := logrus.WithField("name", seedling.Name).WithField("hook", hook.Name)
+		if runErr != nil {
+			log.WithField("error", runErr).Warn("hook failed")
+			s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventHookFailed, Step: SeedlingStepComplete,
+				Payload: EventPayload{"hook": hook.Name, "blocking": hook.Blocking, "error": EventText(runErr.Error())}})
+			if hook.Blocking {
+				return &hook, nil
+			}
+			continue
+		}
+		log.Info("hook succeeded")
+		s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventHookSucceeded, Step: SeedlingStepComplete,
+			Payload: EventPayload{"hook": hook.Name, "blocking": hook.Blocking}})
+	}
+	return nil, nil
+}
+
+// runHook runs the hook for the seedling within its timeout, returning what
+// it printed or responded with.
+func (s *Pipeline) runHook(ctx context.Context, hook Hook, seedling store.Seedling) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.timeout())
+	defer cancel()
+	var output string
+	var err error
+	switch hook.Kind {
+	case HookKindExec:
+		output, err = s.runExecHook(ctx, hook, seedling)
+	case HookKindHTTP:
+		output, err = s.runHTTPHook(ctx, hook, seedling)
+	default:
+		err = fmt.Errorf("unknown hook kind %q", hook.Kind)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", hook.timeout())
+	}
+	return output, err
+}
+
+func (s *Pipeline) runExecHook(ctx context.Context, hook Hook, seedling store.Seedling) (string, error) {
+	if len(hook.Command) == 0 {
+		return "", errors.New("hook has no command")
+	}
+	dir, err := filepath.Abs(s.RepoDir(seedling))
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), s.hookEnv(ctx, seedling, dir)...)
+	out := NewBuildOutput(MAX_HOOK_OUTPUT_BYTES, nil)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	out.Close()
+	return out.String(), err
+}
+
+// hookEnv is the seedling's metadata as exec hooks see it.
+func (s *Pipeline) hookEnv(ctx context.Context, seedling store.Seedling, dir string) []string {
+	commit, _ := RepoHead(ctx, dir)
+	return []string{
+		"GARDEN_SEEDLING_ID=" + PublicID(seedling.ID),
+		"GARDEN_SEEDLING_NAME=" + seedling.Name,
+		"GARDEN_SEEDLING_GARDEN=" + seedling.Garden,
+		"GARDEN_SEEDLING_DESCRIPTION=" + seedling.Description,
+		"GARDEN_SEEDLING_REPO=" + dir,
+		"GARDEN_SEEDLING_COMMIT=" + commit,
+		"GARDEN_SEEDLING_IMAGE=" + s.ContainerName(seedling),
+		"GARDEN_SEEDLING_GRPC_PORT=" + strconv.Itoa(seedling.GRPCPort),
+		"GARDEN_SEEDLING_HTTP_PORT=" + strconv.Itoa(seedling.HTTPPort),
+		"GARDEN_SEEDLING_URL=" + s.Config.APIURL + SeedlingPath(seedling.ID),
+		// What the example client call script reaches the seedling at.
+		ExampleCallHostEnv + "=" + s.Config.SeedlingHost,
+		ExampleCallPortEnv + "=" + strconv.Itoa(seedling.HTTPPort),
+	}
+}
+
+func (s *Pipeline) runHTTPHook(ctx context.Context, hook Hook, seedling store.Seedling) (string, error) {
+	body, err := json.Marshal(&seedling)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, EventCompleted)
+	if hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhook(hook.Secret, body))
+	}
+	// The hook's own timeout applies, not webhooks'.
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	out := NewBuildOutput(MAX_HOOK_OUTPUT_BYTES, nil)
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return out.String(), err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return out.String(), fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+	}
+	return out.String(), nil
+}
+
+// recordHookRun replaces the hook's last run for the seedling. Attempts
+// count the runs at the same commit.
+func (s *Pipeline) recordHookRun(ctx context.Context, seedling store.Seedling, hook Hook, commit, output string, runErr error, started time.Time) error {
+	status, errText := HookStatusSucceeded, ""
+	if runErr != nil {
+		status, errText = HookStatusFailed, runErr.Error()
+	}
+	_, err := s.DB.ExecContext(ctx, `
+	 INSERT INTO seedling_hooks (seedling_id, hook_id, commit_sha, status, attempts, output, error, started_at, finished_at)
+	 VALUES ($1, $2, $3, $4, 1, $5, $6, $7, $8)
+	 ON CONFLICT (seedling_id, hook_id) DO UPDATE SET
+	   attempts = CASE WHEN seedling_hooks.commit_sha = excluded.commit_sha THEN seedling_hooks.attempts + 1 ELSE 1 END,
+	   commit_sha = excluded.commit_sha,
+	   status = excluded.status,
+	   output = excluded.output,
+	   error = excluded.error,
+	   started_at = excluded.started_at,
+	   finished_at = excluded.finished_at
+	 `, seedling.ID, hook.ID, commit, status, output, errText, started, time.Now())
+	return err
+}
+
+// awaitHooks parks a built and running seedling at
+// SeedlingStepAwaitingHooks until the blocking hook that failed succeeds.
+func (s *Pipeline) awaitHooks(ctx context.Context, seedling store.Seedling, blocked Hook) error {
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET step = $1, step_started_at = $2, version = version + 1 WHERE id = $3",
+		SeedlingStepAwaitingHooks, time.Now(), seedling.ID); err != nil {
+		return err
+	}
+	logrus.WithField("name", seedling.Name).
+		WithField("hook", blocked.Name).
+		Info("seedling is built, waiting for a blocking hook to succeed")
+	s.Notify(ctx, seedling, EventStepChanged, SeedlingStepAwaitingHooks)
+	return nil
+}
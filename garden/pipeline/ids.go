@@ -0,0 +1,12 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"github.com/c2h5oh/hide"
+)
+
+// PublicID is the obfuscated form of id, as it leaves the server.
+func PublicID(id hide.Int64) string {
+	return strconv.FormatInt(hide.Default.Int64Obfuscate(int64(id)), 10)
+}
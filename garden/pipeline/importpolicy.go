@@ -0,0 +1,263 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/modfile"
+)
+
+// ImportPolicy decides which modules generated Go code may import. A module
+// is rejected if it matches any of Deny, or if Allow is set and it matches
+// none of it. Suggestions map patterns to what the model should use
+// instead of modules matching them. Modules whose license go-licenses
+// reports as one of DeniedLicenses are rejected too.
+type ImportPolicy struct {
+	Allow          []string          `json:"allow"`
+	Deny           []string          `json:"deny"`
+	Suggestions    map[string]string `json:"suggestions"`
+	DeniedLicenses []string          `json:"deniedLicenses"`
+}
+
+// compiledImportPolicy is an ImportPolicy with its patterns compiled.
+type compiledImportPolicy struct {
+	ImportPolicy
+	allow       []*regexp.Regexp
+	deny        []*regexp.Regexp
+	suggestions map[*regexp.Regexp]string
+}
+
+// ImportPolicyError lists the modules generated code imported against the
+// policy. Its message is what the model is reprompted with.
+type ImportPolicyError struct {
+	Modules     []string
+	Suggestions []string
+}
+
+func (e *ImportPolicyError) Error() string {
+	msg := "do not use these modules: " + strings.Join(e.Modules, ", ")
+	if len(e.Suggestions) > 0 {
+		msg += "; " + strings.Join(e.Suggestions, "; ")
+	}
+	return msg
+}
+
+func ImportPolicyFromConfig(config Config) ImportPolicy {
+	return ImportPolicy{
+		Allow:          config.ImportAllow,
+		Deny:           config.ImportDeny,
+		Suggestions:    config.ImportSuggestions,
+		DeniedLicenses: config.ImportDeniedLicenses,
+	}
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func CompileImportPolicy(p ImportPolicy) (*compiledImportPolicy, error) {
+	if p.Allow == nil {
+		p.Allow = []string{}
+	}
+	if p.Deny == nil {
+		p.Deny = []string{}
+	}
+	if p.Suggestions == nil {
+		p.Suggestions = map[string]string{}
+	}
+	if p.DeniedLicenses == nil {
+		p.DeniedLicenses = []string{}
+	}
+	c := &compiledImportPolicy{ImportPolicy: p, suggestions: map[*regexp.Regexp]string{}}
+	var err error
+	if c.allow, err = compilePatterns(p.Allow); err != nil {
+		return nil, err
+	}
+	if c.deny, err = compilePatterns(p.Deny); err != nil {
+		return nil, err
+	}
+	for pattern, suggestion := range p.Suggestions {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		c.suggestions[re] = suggestion
+	}
+	return c, nil
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledImportPolicy) allows(module string) bool {
+	if matchesAny(c.deny, module) {
+		return false
+	}
+	return len(c.allow) == 0 || matchesAny(c.allow, module)
+}
+
+func (c *compiledImportPolicy) deniesLicense(license string) bool {
+	for _, denied := range c.DeniedLicenses {
+		if strings.EqualFold(denied, license) {
+			return true
+		}
+	}
+	return false
+}
+
+// violation builds the error for the rejected modules, or returns nil if
+// there aren't any.
+func (c *compiledImportPolicy) violation(rejected map[string]bool) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+	e := &ImportPolicyError{}
+	for module := range rejected {
+		e.Modules = append(e.Modules, module)
+		for re, suggestion := range c.suggestions {
+			if re.MatchString(module) {
+				e.Suggestions = append(e.Suggestions, fmt.Sprintf("use %s instead of %s", suggestion, module))
+			}
+		}
+	}
+	sort.Strings(e.Modules)
+	sort.Strings(e.Suggestions)
+	return e
+}
+
+func (s *Pipeline) CurrentImportPolicy() *compiledImportPolicy {
+	s.ImportPolicyMu.RLock()
+	defer s.ImportPolicyMu.RUnlock()
+	return s.ImportPolicy
+}
+
+// repoModules resolves import paths in a seedling repo to the modules
+// providing them, going by the longest matching requirement in its go.mod.
+// Paths it doesn't require yet are their own module.
+type repoModules struct {
+	main     string
+	requires []string
+}
+
+func loadRepoModules(dir string) (*repoModules, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	mf, err := modfile.ParseLax("go.mod", data, nil)
+	if err != nil {
+		return nil, err
+	}
+	rm := &repoModules{}
+	if mf.Module != nil {
+		rm.main = mf.Module.Mod.Path
+	}
+	for _, req := range mf.Require {
+		rm.requires = append(rm.requires, req.Mod.Path)
+	}
+	return rm, nil
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func (rm *repoModules) module(path string) string {
+	best := ""
+	for _, req := range rm.requires {
+		if hasPathPrefix(path, req) && len(req) > len(best) {
+			best = req
+		}
+	}
+	if best == "" {
+		return path
+	}
+	return best
+}
+
+// checkImports enforces the import policy on a generated Go file before
+// it's built. The license check needs go-licenses on the PATH and the
+// modules in the host's module cache, so it only runs with the host build
+// runner.
+func (s *Pipeline) checkImports(ctx context.Context, dir, file string) error {
+	policy := s.CurrentImportPolicy()
+	rm, err := loadRepoModules(dir)
+	if err != nil {
+		return err
+	}
+	imports := getNonStdImports(file)
+	rejected := map[string]bool{}
+	for _, imp := range imports {
+		if module := rm.module(imp); !policy.allows(module) {
+			rejected[module] = true
+		}
+	}
+
+	if len(policy.DeniedLicenses) > 0 && len(imports) > 0 && s.Config.BuildRunner == BuildRunnerHost {
+		if _, err := exec.LookPath("go-licenses"); err == nil {
+			licenses, err := s.packageLicenses(ctx, dir, file)
+			if err != nil {
+				// go-licenses fails on anything it can't classify,
+				// which the build shouldn't.
+				logrus.WithField("error", err).Warn("failed to check licenses")
+			}
+			for pkg, license := range licenses {
+				if policy.deniesLicense(license) && !hasPathPrefix(pkg, rm.main) {
+					rejected[rm.module(pkg)] = true
+				}
+			}
+		}
+	}
+	return policy.violation(rejected)
+}
+
+// packageLicenses returns the license go-licenses finds for each package the
+// generated file's package depends on.
+func (s *Pipeline) packageLicenses(ctx context.Context, dir, file string) (map[string]string, error) {
+	rel, err := filepath.Rel(dir, filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := s.Runner.Command(ctx, BuildSpec{
+		Dir:  dir,
+		Name: "go-licenses",
+		Args: []string{"csv", "./" + filepath.ToSlash(rel)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.Output()
+	licenses := map[string]string{}
+	// Rows are package path, license URL and license name, and are still
+	// written for the packages that could be classified when it fails.
+	rows, _ := csv.NewReader(strings.NewReader(string(out))).ReadAll()
+	for _, row := range rows {
+		if len(row) < 3 {
+			continue
+		}
+		licenses[row[0]] = row[2]
+	}
+	return licenses, err
+}
@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// RepeatTemperatureBump is how much the temperature goes up each time
+	// an attempt writes the same failing code as the attempt before it.
+	RepeatTemperatureBump = 0.2
+)
+
+// modelChain is the models a step's completions are tried with, in order:
+// model if it's set, else the step's configured model or the default, then
+// the fallbacks.
+func (s *Pipeline) modelChain(step, model string) []string {
+	first := model
+	if first == "" {
+		first = s.Config.Models[step]
+	}
+	if first == "" && step == SeedlingStepReadme {
+		first = s.Config.ReadmeModel
+	}
+	if first == "" {
+		first = s.Config.Model
+	}
+	chain := []string{first}
+	for _, model := range s.Config.ModelFallbacks {
+		if model != first {
+			chain = append(chain, model)
+		}
+	}
+	return chain
+}
+
+// withFallback calls complete with each model in the step's chain until one
+// succeeds or fails for a reason another model wouldn't fix. A completion cut
+// off at its token limit is retried with twice the limit, up to
+// MaxTokensLimit, and returned as it is once it can't be raised further.
+// model is the seedling's, if any, and maxTokens is the config's when 0. It
+// returns the options that produced the text.
+func (s *Pipeline) withFallback(
+	ctx context.Context,
+	step string,
+	model string,
+	temperature float32,
+	maxTokens int,
+	complete func(ctx context.Context, opts llm.CompletionOptions) (string, error),
+) (string, llm.CompletionOptions, error) {
+	if maxTokens == 0 {
+		maxTokens = s.Config.MaxTokens
+	}
+	ctx = llm.WithFixtureStep(ctx, step)
+	var err error
+	for _, model := range s.modelChain(step, model) {
+		opts := llm.CompletionOptions{
+			Model:       model,
+			MaxTokens:   maxTokens,
+			Temperature: temperature,
+		}
+		var text string
+		for {
+			spanCtx, span := otel.Tracer("garden").Start(ctx, "llm.complete", trace.WithAttributes(
+				attribute.String("llm.model", model),
+				attribute.String("seedling.step", step),
+				attribute.Float64("llm.temperature", float64(temperature)),
+				attribute.Int("llm.max_tokens", opts.MaxTokens),
+			))
+			start := time.Now()
+			text, err = complete(spanCtx, opts)
+			observeLLMCall(model, step, err, time.Since(start))
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+			if !errors.Is(err, llm.ErrLength) || opts.MaxTokens >= s.Config.MaxTokensLimit {
+				break
+			}
+			opts.MaxTokens *= 2
+			if opts.MaxTokens > s.Config.MaxTokensLimit {
+				opts.MaxTokens = s.Config.MaxTokensLimit
+			}
+			logrus.WithField("model", model).
+				WithField("max_tokens", opts.MaxTokens).
+				Warn("completion hit the token limit, retrying with a higher one")
+		}
+		if errors.Is(err, llm.ErrLength) {
+			// Whatever fails about the cut off code counts as the
+			// attempt's error.
+			err = nil
+		}
+		if err == nil {
+			return text, opts, nil
+		}
+		if !llm.FallbackError(err) {
+			return "", opts, err
+		}
+		logrus.WithField("error", err).WithField("model", model).Warn("completion failed, trying next model")
+	}
+	return "", llm.CompletionOptions{}, err
+}
+
+// CompleteText prompts for a short plain completion, such as a quality
+// check verdict.
+func (s *Pipeline) CompleteText(ctx context.Context, step, prompt string, temperature float32) (string, error) {
+	text, _, err := s.withFallback(ctx, step, "", temperature, 0, func(ctx context.Context, opts llm.CompletionOptions) (string, error) {
+		provider := s.llmFor(ctx)
+		if chat, ok := provider.(llm.ChatLLM); ok && s.chatModel(opts.Model) {
+			return chat.Chat(ctx, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+		}
+		return provider.Complete(ctx, prompt, opts)
+	})
+	return text, err
+}
+
+// completeSeedlingText is CompleteText with the seedling's model and max
+// tokens, for code written outside the step's conversation.
+func (s *Pipeline) completeSeedlingText(ctx context.Context, seedling store.Seedling, step, prompt string, temperature float32) (string, llm.CompletionOptions, error) {
+	return s.withFallback(ctx, step, seedling.Model, temperature, seedling.MaxTokens, func(ctx context.Context, opts llm.CompletionOptions) (string, error) {
+		provider := s.llmFor(ctx)
+		if chat, ok := provider.(llm.ChatLLM); ok && s.chatModel(opts.Model) {
+			return chat.Chat(ctx, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+		}
+		return provider.Complete(ctx, prompt, opts)
+	})
+}
+
+// errStreamUnsupported means the provider can't stream, and the caller
+// should fall back to a plain completion.
+var errStreamUnsupported = errors.New("llm does not support streaming")
+
+// complete prompts the LLM for the contents of a code block of the given
+// language, streaming the completion to the seedling's event subscribers
+// when the provider supports it. The result is cut at the fence that closes
+// the block. It also returns the options it was written with. Chat models
+// are prompted with the seedling's stored conversation instead of the prompt.
+func (s *Pipeline) complete(
+	ctx context.Context,
+	seedling store.Seedling,
+	step, lang, prompt string,
+	temperature float32,
+) (string, llm.CompletionOptions, error) {
+	text, opts, err := s.withFallback(ctx, step, seedling.Model, temperature, seedling.MaxTokens, func(ctx context.Context, opts llm.CompletionOptions) (string, error) {
+		provider := s.llmFor(ctx)
+		if chat, ok := provider.(llm.ChatLLM); ok && s.chatModel(opts.Model) {
+			messages, err := s.conversation(ctx, seedling.ID, step, lang, opts)
+			if err != nil {
+				return "", err
+			}
+			return chat.Chat(ctx, messages, opts)
+		}
+		text, err := s.completeStream(ctx, seedling, step, lang, prompt, opts)
+		if err == errStreamUnsupported {
+			return provider.Complete(ctx, prompt, opts)
+		}
+		return text, err
+	})
+	if err != nil {
+		return "", opts, err
+	}
+
+	// Chat replies open their own block, which extractCode finds.
+	if end := closingFence(text, lang, true); end != -1 && !s.chatModel(opts.Model) {
+		text = text[:end]
+	}
+	s.Events.Publish(seedling.ID, SeedlingEvent{Type: EventCompletionDone, Step: step, Data: text})
+	return text, opts, nil
+}
+
+func (s *Pipeline) completeStream(ctx context.Context, seedling store.Seedling, step, lang, prompt string, opts llm.CompletionOptions) (string, error) {
+	streamer, ok := s.llmFor(ctx).(llm.StreamingLLM)
+	if !ok {
+		return "", errStreamUnsupported
+	}
+
+	var acc strings.Builder
+	chunks := 0
+	text, err := streamer.CompleteStream(ctx, prompt, opts, func(chunk string) bool {
+		chunks++
+		acc.WriteString(chunk)
+		s.Events.Publish(seedling.ID, SeedlingEvent{Type: EventCompletionChunk, Step: step, Data: chunk})
+		return closingFence(acc.String(), lang, false) == -1
+	})
+	if err != nil && chunks == 0 && !llm.FallbackError(err) {
+		// Nothing arrived, so the provider most likely doesn't support
+		// streaming for this model.
+		logrus.WithField("error", err).Warn("streaming completion failed, falling back")
+		return "", errStreamUnsupported
+	}
+	return text, err
+}
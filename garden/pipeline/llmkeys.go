@@ -0,0 +1,167 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// OpenAIKeyHeader brings the OpenAI key a request's seedlings are built
+	// with instead of the server's.
+	OpenAIKeyHeader = "X-OpenAI-Key"
+
+	// DefaultLLMKey is what usage of the server's OpenAI key is labelled
+	// with.
+	DefaultLLMKey = "default"
+
+	// llmKeyCheckTTL is how long a key check is trusted before a request
+	// needing the key checks it again.
+	llmKeyCheckTTL = time.Minute
+)
+
+// The prefixes of Seedling.LLMKeyID.
+const (
+	LLMKeyAPIKeyPrefix  = "apikey:"
+	LLMKeyRequestPrefix = "request:"
+)
+
+var errNoLLM = errors.New("no LLM provider")
+
+type llmCtxKey struct{}
+
+// WithLLM has completions made with ctx prompt provider instead of the
+// server's.
+func WithLLM(ctx context.Context, provider llm.LLM) context.Context {
+	return context.WithValue(ctx, llmCtxKey{}, provider)
+}
+
+// llmFor is the provider completions made with ctx prompt.
+func (s *Pipeline) llmFor(ctx context.Context) llm.LLM {
+	if provider, ok := ctx.Value(llmCtxKey{}).(llm.LLM); ok {
+		return provider
+	}
+	return s.LLM
+}
+
+// LLMProvider is the provider of one OpenAI key, and the result of the last
+// check of the key.
+type LLMProvider struct {
+	LLM llm.LLM
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// Check checks the key unless it was checked in the last llmKeyCheckTTL.
+// Providers that can't check their key are assumed to work.
+func (p *LLMProvider) Check(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.checkedAt.IsZero() && time.Since(p.checkedAt) < llmKeyCheckTTL {
+		return p.err
+	}
+	p.err = nil
+	if p.LLM == nil {
+		p.err = errNoLLM
+	} else if checker, ok := p.LLM.(llm.KeyChecker); ok {
+		p.err = checker.CheckKey(ctx)
+	}
+	p.checkedAt = time.Now()
+	return p.err
+}
+
+// llmProviders are the providers of the keys seedlings are built with, by
+// Seedling.LLMKeyID. Each is rate limited on its own.
+type llmProviders struct {
+	mu   sync.Mutex
+	byID map[string]*LLMProvider
+	// newLLM returns the provider of a key the first time it's used.
+	newLLM func(id, key string) llm.LLM
+}
+
+func NewLLMProviders(server llm.LLM, newLLM func(id, key string) llm.LLM) *llmProviders {
+	return &llmProviders{byID: map[string]*LLMProvider{"": {LLM: server}}, newLLM: newLLM}
+}
+
+// Get returns the provider of the key with the id, creating it the first
+// time the key is used.
+func (p *llmProviders) Get(id, key string) *LLMProvider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if provider, ok := p.byID[id]; ok {
+		return provider
+	}
+	provider := &LLMProvider{LLM: p.newLLM(id, key)}
+	p.byID[id] = provider
+	return provider
+}
+
+// SeedlingLLM is the provider the seedling is built with.
+func (s *Pipeline) SeedlingLLM(seedling store.Seedling) (llm.LLM, error) {
+	switch {
+	case seedling.LLMKey != "":
+		key, err := s.openLLMKey(seedling.LLMKeyID, seedling.LLMKey)
+		if err != nil {
+			return nil, err
+		}
+		return s.LLMs.Get(seedling.LLMKeyID, key).LLM, nil
+	case strings.HasPrefix(seedling.LLMKeyID, LLMKeyAPIKeyPrefix):
+		name := strings.TrimPrefix(seedling.LLMKeyID, LLMKeyAPIKeyPrefix)
+		key, ok := s.Config.OpenAIKeys[name]
+		if !ok {
+			return nil, fmt.Errorf("OPENAI_API_KEYS no longer has a key for %s", name)
+		}
+		return s.LLMs.Get(seedling.LLMKeyID, key).LLM, nil
+	}
+	if s.LLM == nil {
+		return nil, errNoLLM
+	}
+	return s.LLM, nil
+}
+
+// NewLLMKeyAEAD is the cipher keys brought in X-OpenAI-Key are stored
+// encrypted with: AES-256-GCM keyed with the base64 secret.
+func NewLLMKeyAEAD(secret string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret is %d bytes, not 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *Pipeline) openLLMKey(id, sealed string) (string, error) {
+	if s.LLMKeyAEAD == nil {
+		return "", errors.New("LLM_KEY_SECRET is not set, stored OpenAI keys can't be decrypted")
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	n := s.LLMKeyAEAD.NonceSize()
+	if len(data) < n {
+		return "", errors.New("sealed OpenAI key is too short")
+	}
+	key, err := s.LLMKeyAEAD.Open(nil, data[:n], data[n:], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("decrypt OpenAI key: %w", err)
+	}
+	return string(key), nil
+}
@@ -0,0 +1,1715 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	// SeedlingStepPlan is where a seedling waits for its plan to be
+	// approved before it's built.
+	SeedlingStepPlan               = "SeedlingStepPlan"
+	SeedlingStepProtobufs          = "SeedlingStepProtobufs"
+	SeedlingStepServer             = "SeedlingStepServer"
+	SeedlingStepServerQualityCheck = "SeedlingStepServerQualityCheck"
+	// SeedlingStepServerManifest and SeedlingStepServerFile are the
+	// completions of a server written file by file.
+	SeedlingStepServerManifest    = "SeedlingStepServerManifest"
+	SeedlingStepServerFile        = "SeedlingStepServerFile"
+	SeedlingStepServerTests       = "SeedlingStepServerTests"
+	SeedlingStepOpenAPI           = "SeedlingStepOpenAPI"
+	SeedlingStepDockerfile        = "SeedlingStepDockerfile"
+	SeedlingStepDockerCompose     = "SeedlingStepDockerCompose"
+	SeedlingStepClient            = "SeedlingStepClient"
+	SeedlingStepExampleClientCall = "SeedlingStepExampleClientCall"
+	SeedlingStepComplete          = "SeedlingStepComplete"
+	// SeedlingStepFailed is where a build that gave up leaves the seedling
+	// until it's retried.
+	SeedlingStepFailed = "SeedlingStepFailed"
+	// SeedlingStepAwaitingConfig is where a built seedling waits for the
+	// required environment variables its server reads to be set, before
+	// its container is started and it's complete.
+	SeedlingStepAwaitingConfig = "SeedlingStepAwaitingConfig"
+	// SeedlingStepAwaitingHooks is where a built and running seedling waits
+	// for a blocking hook that failed to succeed before it's complete.
+	SeedlingStepAwaitingHooks = "SeedlingStepAwaitingHooks"
+	// SeedlingStepAwaitingApproval is where a seedling waits, after a step
+	// in its approvalRequired, for what the step generated to be approved
+	// or rejected before the build goes on.
+	SeedlingStepAwaitingApproval = "SeedlingStepAwaitingApproval"
+)
+
+var (
+	protoPrompt = `
+Write me a protobufs file for a gRPC method that %s
+
+Make sure to start it with lines like:
+
+syntax = "proto3";
+option go_package = "./protobufs";
+
+The file will be called %s.proto. Do not override any of my file names.
+
+My directory layout is:
+
+$ ls .
+Dockerfile          client              docker-compose.yaml go.mod              protobufs           server
+
+My go.mod is:
+
+module %s
+
+go %s
+
+There are some arguments and variations that a user will be likely to request.
+Make sure to include them. Think about it like a product manager for a developer experience
+-- what are people likely to want from a service that does %s?
+`
+)
+
+type QualityCheck struct {
+	Quality     string `json:"quality"`
+	Reason      string `json:"reason"`
+	Suggestions string `json:"suggestions"`
+}
+
+func (qc QualityCheck) Error() error {
+	if qc.Quality == QualityGood {
+		return nil
+	}
+	return errors.New("Quality check failed for this reason: " + qc.Reason + ". To improve the quality, we suggest you: " + qc.Suggestions)
+}
+
+func CleanFilePath(file string) string {
+	invalidCharsRegex := regexp.MustCompile(`[^\w-.]`)
+	cleanedPath := strings.ReplaceAll(strings.TrimSpace(file), " ", "_")
+	cleanedPath = invalidCharsRegex.ReplaceAllString(cleanedPath, "")
+	return strings.ToLower(cleanedPath)
+}
+
+// writeComposeFile writes the seedling's docker-compose.yaml, passing
+// through the environment variables in env from wherever compose is run.
+func (s *Pipeline) writeComposeFile(seedling store.Seedling, env []string) error {
+	outputsDir, err := filepath.Abs(s.SeedlingOutputsDir(seedling.ResourceName()))
+	if err != nil {
+		return err
+	}
+	environment := ""
+	if len(env) > 0 {
+		environment = "    environment:\n"
+		for _, name := range env {
+			environment += "    - " + name + "\n"
+		}
+	}
+	composeContents := fmt.Sprintf(`version: "3.9"
+services:
+  %s:
+    image: %s
+%s%s%s    networks:
+    - %s
+    volumes:
+    - ./secrets:/secrets:ro
+    - %s:/outputs
+
+networks:
+  %s:
+    external: true
+`, CleanFilePath(seedling.Name), s.ContainerName(seedling), seedling.SeedlingPorts.ComposeService(), seedling.SeedlingResources.ComposeService(),
+		environment, s.network(seedling), outputsDir, s.network(seedling))
+	return s.SafeWriteFile(s.RepoDir(seedling), "docker-compose.yaml", []byte(composeContents), 0644)
+}
+
+func (s *Pipeline) initGoRepo(ctx context.Context, seedling store.Seedling) error {
+	dirpath := CleanFilePath(seedling.Name)
+	basePath := s.RepoDir(seedling)
+	if err := os.MkdirAll(filepath.Join(basePath, "protobufs"), 0755); err != nil {
+		return err
+	}
+	if basePath != s.legacyRepoDir(dirpath) {
+		if err := InitSeedlingRepo(ctx, basePath); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(basePath, "server"), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(basePath, "client"), 0755); err != nil {
+		return err
+	}
+
+	defaultModContents := fmt.Sprintf(`module %s
+
+go %s
+`, dirpath, seedling.Toolchain)
+	if err := s.SafeWriteFile(basePath, "go.mod", []byte(defaultModContents), 0644); err != nil {
+		logrus.WithField("error", err).Error("failed to write to go.mod")
+	}
+
+	protoContents := `syntax = "proto3";
+
+option go_package = ".";`
+	if err := s.SafeWriteFile(basePath, filepath.Join("protobufs", dirpath+".proto"), []byte(protoContents), 0644); err != nil {
+		logrus.WithField("error", err).Error("failed to write to protobufs")
+	}
+
+	serverContents := `package main
+
+func main() {
+	fmt.Println("Welcome to seedling")
+}`
+	if err := s.SafeWriteFile(basePath, filepath.Join("server", "main.go"), []byte(serverContents), 0644); err != nil {
+		logrus.WithField("error", err).Error("failed to write to server")
+	}
+
+	clientContents := `package main
+
+func main() {
+	fmt.Println("Welcome to seedling")
+}`
+	if err := s.SafeWriteFile(basePath, filepath.Join("client", "main.go"), []byte(clientContents), 0644); err != nil {
+		logrus.WithField("error", err).Error("failed to write to client")
+	}
+
+	dockerfileContents := `FROM debian:bookworm-slim
+COPY . /app
+`
+	if err := s.SafeWriteFile(basePath, "Dockerfile", []byte(dockerfileContents), 0644); err != nil {
+		logrus.WithField("error", err).Error("failed to write to client")
+	}
+
+	if err := s.writeComposeFile(seedling, nil); err != nil {
+		return err
+	}
+
+	gitignoreContents := `
+logs
+secrets/
+env/
+`
+	if err := s.SafeWriteFile(basePath, ".gitignore", []byte(gitignoreContents), 0644); err != nil {
+		logrus.WithField("error", err).Error("failed to write to client")
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "add", ".")
+	cmd.Dir = filepath.Join(basePath)
+	if err := cmd.Run(); err != nil {
+		logrus.WithField("error", err).Error("failed to init git repo")
+		return err
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "commit", "-m", ".")
+	cmd.Dir = filepath.Join(basePath)
+	if err := cmd.Run(); err != nil {
+		logrus.WithField("error", err).Error("failed to init git repo")
+		return err
+	}
+
+	return nil
+}
+
+func (s *Pipeline) WriteSeedlingToRepo(ctx context.Context, seedling store.Seedling) error {
+	// TODO: more languages etc
+	if err := s.initGoRepo(ctx, seedling); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CompleteSeedling does what's left once a seedling is built, running and
+// past its blocking hooks, and announces it's complete.
+func (s *Pipeline) CompleteSeedling(ctx context.Context, seedling store.Seedling) {
+	if err := s.ClearCheckpoint(ctx, seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to clear checkpoint")
+	}
+	if seedling.RefineInstruction != "" {
+		s.finishRefine(ctx, seedling)
+	}
+	// After the refine's commits are squashed, so the revision is of the
+	// commit that's kept.
+	if err := s.RecordRevision(ctx, &seedling); err != nil {
+		logrus.WithField("error", err).Error("failed to record seedling revision")
+	}
+	// It built again, so it's no longer bit-rotted.
+	if seedling.Bitrot {
+		s.ClearBitrot(ctx, seedling.ID)
+	}
+	s.GenerateReadme(ctx, &seedling)
+	s.generateExamples(ctx, seedling)
+	// Push failures are recorded on the seedling and can be retried, they
+	// don't fail the build.
+	if seedling.GitPushOnComplete {
+		if err := s.PushRemote(ctx, &seedling); err != nil {
+			logrus.WithField("error", err).Error("failed to push seedling")
+		}
+	}
+	s.Notify(ctx, seedling, EventCompleted, SeedlingStepComplete)
+}
+
+// Build builds the seedling from its step to complete. ctx is from
+// BuildRegistry.detach, and the build's span is linked to the request that
+// queued it.
+func (s *Pipeline) Build(ctx context.Context, seedling store.Seedling) {
+	ctx, span := StartBuildSpan(ctx, "seedling.build", seedling)
+	defer span.End()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	acquired, err := s.BuildRegistry.TryAcquire(ctx, seedling, cancel)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to acquire build lease")
+		return
+	}
+	if !acquired {
+		logrus.WithField("name", seedling.Name).Info("seedling is already being built, not starting another build")
+		return
+	}
+	// promoting is set when the build completed a refine of the seedling's
+	// promotion, whose next item can only be refined once the lease is
+	// released.
+	promoting := false
+	// restart is set when the seedling was changed while it was built with
+	// restartOnEdit on, to build it again once the lease is released.
+	var restart *buildRestart
+	restartStep := seedling.Step
+	defer func() {
+		if promoting {
+			s.continuePromotion(ctx, seedling.ID)
+		}
+		if restart != nil {
+			s.restartBuild(ctx, *restart, restartStep)
+		}
+	}()
+	defer s.BuildRegistry.Release(seedling.ID)
+	if seedling.Step == SeedlingStepFailed || seedling.Step == SeedlingStepPlan ||
+		seedling.Step == SeedlingStepAwaitingConfig || seedling.Step == SeedlingStepAwaitingHooks ||
+		seedling.Step == SeedlingStepAwaitingApproval {
+		logrus.WithField("name", seedling.Name).
+			WithField("step", seedling.Step).
+			Info("seedling is waiting to be retried, have its plan or a step approved, its env set or its hooks retried, not building it")
+		return
+	}
+	// The seedling may have been changed since its build was queued. A
+	// build from the first step, or a refine, is of it as it is now.
+	if current, edits, err := s.seedlingEdits(ctx, seedling); err != nil {
+		logrus.WithField("error", err).Error("failed to get seedling")
+	} else {
+		applyGenerationEdits(&seedling, current)
+		seedling.StaleDescription = current.StaleDescription
+		seedling.SeedlingApproval = current.SeedlingApproval
+		if len(edits) > 0 {
+			logrus.WithField("name", seedling.Name).WithField("fields", edits).Info("Building seedling as it was changed while queued")
+		}
+	}
+	if seedling.StaleDescription && (seedling.Step == SeedlingStepProtobufs || seedling.RefineInstruction != "") {
+		if _, err := s.DB.ExecContext(ctx,
+			"UPDATE seedlings SET stale_description = FALSE, version = version + 1 WHERE id = $1", seedling.ID); err != nil {
+			logrus.WithField("error", err).Error("failed to clear seedling stale description")
+		} else {
+			seedling.StaleDescription = false
+		}
+	}
+	s.Markers.Send(s.seedlingMarker(seedling, MarkerBuildStarted, "build started at "+seedling.Step))
+	if id := traceID(ctx); id != seedling.TraceID {
+		seedling.TraceID = id
+		if _, err := s.DB.ExecContext(ctx,
+			"UPDATE seedlings SET trace_id = $1, version = version + 1 WHERE id = $2", id, seedling.ID); err != nil {
+			logrus.WithField("error", err).Error("failed to store seedling trace id")
+		}
+	}
+	// Each attempt reads the settings as it starts, the first the ones
+	// recorded with the build's start.
+	set := s.Settings.Current()
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventBuildStarted, Step: seedling.Step,
+		Payload: EventPayload{"settings": set.Values()}})
+	all := SeedlingSteps(seedling)
+	first := 0
+	for i := range all {
+		if all[i] == seedling.Step {
+			first = i
+			break
+		}
+	}
+	// The loop below runs the steps that aren't branch steps, the graph
+	// runs those alongside it.
+	steps := []string{}
+	startStep := -1
+	for i, name := range all {
+		if branchSteps[name] {
+			continue
+		}
+		if i >= first && startStep == -1 {
+			startStep = len(steps)
+		}
+		steps = append(steps, name)
+	}
+	step := startStep
+	var graph *stepGraph
+	completed := false
+	// awaitingConfig is set when the build stops to wait for the seedling's
+	// env, which isn't a failure.
+	awaitingConfig := false
+	// awaitingHooks is set when a blocking hook failed, which leaves the
+	// seedling built and running but not complete.
+	awaitingHooks := false
+	// awaitingApproval is set when the build stops after a step in the
+	// seedling's approval gates.
+	awaitingApproval := false
+	// reason is why the build is giving up, for every return that isn't
+	// completing it. Killed builds are failed by whoever killed them.
+	reason := ""
+	defer func() {
+		if graph != nil {
+			graph.stop()
+		}
+		if restart != nil {
+			observeSeedlingBuild(BuildOutcomeRestarted)
+			return
+		}
+		if completed {
+			observeSeedlingBuild(BuildOutcomeCompleted)
+			return
+		}
+		if awaitingConfig {
+			observeSeedlingBuild(BuildOutcomeAwaitingConfig)
+			return
+		}
+		if awaitingHooks {
+			observeSeedlingBuild(BuildOutcomeAwaitingHooks)
+			return
+		}
+		if awaitingApproval {
+			observeSeedlingBuild(BuildOutcomeAwaitingApproval)
+			return
+		}
+		if ctx.Err() != nil {
+			observeSeedlingBuild(BuildOutcomeCancelled)
+			return
+		}
+		observeSeedlingBuild(BuildOutcomeFailed)
+		if reason == "" {
+			reason = "build stopped at " + steps[step]
+		}
+		if graph != nil && steps[step] != SeedlingStepComplete {
+			if err := graph.set(steps[step], StepStatusFailed, reason); err != nil {
+				logrus.WithField("error", err).Error("failed to update step status")
+			}
+		}
+		span.SetStatus(codes.Error, reason)
+		s.FailSeedling(ctx, seedling, reason)
+	}()
+
+	provider, err := s.SeedlingLLM(seedling)
+	if err != nil {
+		reason = "LLM provider not configured: " + err.Error()
+		return
+	}
+	ctx = llm.WithFixtureName(WithLLM(ctx, provider), seedling.Name)
+
+	// GPT can't fix a missing protoc, so don't spend retries on it. The
+	// builder image brings its own tools.
+	if s.Config.BuildRunner == BuildRunnerHost {
+		if env := s.Toolchain(ctx, false); !env.OK {
+			reason = "missing build tools: " + env.missingTools()
+			return
+		}
+	}
+	if seedling.Platform == "" {
+		// rows created before platforms were recorded
+		seedling.Platform = HostPlatform()
+	}
+	// The ports the prompts and Dockerfile have the servers listen on.
+	ports := seedling.SeedlingPorts.WithDefaults()
+
+	errs := 0
+	// repeats is how many attempts in a row wrote code that already failed.
+	// failedOutputs is the output each code that failed at the current step
+	// failed with, by outputHash, so that code isn't built again.
+	repeats := 0
+	failedOutputs := map[string]string{}
+	// splitServer is the files of a server written file by file, and
+	// serverContext the prompt each of them is written from.
+	var splitServer *serverFiles
+	serverContext := ""
+	smokeErrs := 0
+	nudges := 0
+	attempt := 0
+	prompt := ""
+	errMode := false
+	dumpedModDocs := false
+	// lastOutput is what the last attempt's build printed, which the
+	// server's fix prompt quotes the declarations its errors are about
+	// from.
+	lastOutput := ""
+	// A refine's first step is prompted with the current code and the
+	// change to make to it.
+	refine := ""
+	if seedling.RefineInstruction != "" {
+		refine = s.refinePrompt(seedling)
+		if steps[startStep] == SeedlingStepServer {
+			prompt = refine
+		}
+	}
+	gates, err := s.approvalGates(ctx, seedling)
+	if err != nil {
+		reason = "failed to get approval gates: " + err.Error()
+		return
+	}
+	// The template is rendered once per build, so a custom template that's
+	// broken fails it up front.
+	tmpl, err := s.seedlingTemplate(ctx, seedling)
+	if err != nil {
+		reason = "failed to render template: " + err.Error()
+		return
+	}
+	// A checkpoint for another step is from before the last step change was
+	// saved, so that step is simply redone, as are the rest with it.
+	cp, err := s.LoadCheckpoint(ctx, seedling.ID)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to load checkpoint")
+	}
+	if cp != nil && cp.ErrMode && cp.Prompt == "" {
+		// A fix prompt with no conversation before it only regresses the
+		// code, so the step starts over instead.
+		logrus.WithField("name", seedling.Name).Warn("checkpoint has no conversation, restarting the step")
+		cp = nil
+	}
+	if cp == nil || cp.Step != steps[step] {
+		s.resetMessages(ctx, seedling.ID, steps[step:])
+	} else {
+		attempt = cp.Attempt
+		errs = cp.Errs
+		errMode = cp.ErrMode
+		dumpedModDocs = cp.DumpedModDocs
+		lastOutput = cp.LastOutput
+		prompt = cp.Prompt
+		logrus.WithField("name", seedling.Name).
+			WithField("step", cp.Step).
+			WithField("attempt", cp.Attempt).
+			Info("Resuming build from checkpoint")
+	}
+	// Code that already failed since the step started isn't built again,
+	// and attempts are numbered on from the last one, even when the step
+	// starts over.
+	history, err := s.StepAttempts(ctx, seedling, steps[step])
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to get step attempts")
+	}
+	for _, a := range history {
+		if !a.Success && !a.Duplicate {
+			failedOutputs[outputHash(a.Step, a.Code)] = a.Output
+		}
+		if a.Attempt > attempt {
+			attempt = a.Attempt
+		}
+	}
+	if graph, err = s.newStepGraph(ctx, seedling, all, first); err != nil {
+		logrus.WithField("error", err).Error("failed to reset step statuses")
+		return
+	}
+	// Branches whose steps succeeded in an earlier build start right away.
+	graph.startBranches(prompt)
+
+	for runs := 0; ; runs++ {
+		if runs+1 >= set.MaxRuns {
+			logrus.Error("max runs reached")
+			reason = fmt.Sprintf("exceeded %d errors in each of %d runs", set.MaxErrs, set.MaxRuns)
+			return
+		}
+		for {
+			if ctx.Err() != nil {
+				logrus.WithField("name", seedling.Name).Warn("build cancelled")
+				return
+			}
+			if steps[step] == SeedlingStepComplete {
+				if err := graph.wait(); err != nil {
+					reason = err.Error()
+					return
+				}
+				missing, err := s.MissingEnv(ctx, seedling.ID)
+				if err != nil {
+					reason = "failed to get env requirements: " + err.Error()
+					return
+				}
+				if len(missing) > 0 {
+					if err := s.awaitConfig(ctx, seedling, missing); err != nil {
+						reason = "failed to wait for env: " + err.Error()
+						return
+					}
+					awaitingConfig = true
+					return
+				}
+				// A refine replaces the container running the previous
+				// revision.
+				cid, err := s.StartSeedlingContainer(ctx, &seedling, seedling.RefineInstruction != "")
+				if err != nil {
+					logrus.WithField("error", err).Error("failed to run docker container")
+					reason = "failed to run docker container: " + err.Error()
+					return
+				}
+
+				logrus.WithField("n_errs", errs).
+					WithField("container_id", cid).
+					WithField("grpc_port", seedling.GRPCPort).
+					WithField("http_port", seedling.HTTPPort).
+					Info("Seedling build complete. Launching Docker container for seedling")
+
+				if s.Config.GRPCSmokeTest {
+					smoke, err := s.grpcSmokeTest(ctx, seedling)
+					if smoke == nil {
+						// The seedling's own code wasn't at fault.
+						logrus.WithField("error", err).Error("failed to run gRPC smoke test")
+					} else if err != nil {
+						smokeErrs++
+						server := -1
+						for i := range steps {
+							if steps[i] == SeedlingStepServer {
+								server = i
+							}
+						}
+						if smokeErrs > set.MaxErrs || server == -1 {
+							reason = "gRPC smoke test failed: " + err.Error()
+							return
+						}
+						logrus.WithField("error", err).
+							WithField("name", seedling.Name).
+							Warn("gRPC smoke test failed, fixing the server")
+						step = server
+						attempt = 0
+						if err := graph.rerun(steps[step]); err != nil {
+							logrus.WithField("error", err).Error("failed to update seedling step")
+							return
+						}
+						report := fmt.Sprintf("The server built and started, but a gRPC smoke test of it on port %d failed:\n\n```\n", ports.GRPCContainerPort) +
+							err.Error() + "\n```\n"
+						if splitServer != nil {
+							splitServer.fixAll(report)
+						}
+						fix := report + "\nWrite a version of server/main.go that fixes that.\n"
+						prompt += fix
+						s.appendMessage(ctx, seedling.ID, steps[step], llm.RoleUser, fix)
+						errMode = true
+						continue
+					}
+				}
+
+				if err := graph.set(SeedlingStepComplete, StepStatusSucceeded, ""); err != nil {
+					logrus.WithField("error", err).Error("failed to update step status")
+				}
+				blocked, err := s.RunHooks(ctx, seedling)
+				if err != nil {
+					logrus.WithField("error", err).Error("failed to run hooks")
+				}
+				if blocked != nil {
+					if err := s.awaitHooks(ctx, seedling, *blocked); err != nil {
+						reason = "failed to wait for hooks: " + err.Error()
+						return
+					}
+					awaitingHooks = true
+					return
+				}
+				completed = true
+				s.CompleteSeedling(ctx, seedling)
+				promoting = seedling.Promotion.InProgress()
+				return
+			}
+
+			repoPath := ""
+			codeType := ""
+			cmdCmd := ""
+			cmdArgs := []string{}
+			// templated is set when the model only lists the packages the
+			// step's Dockerfile is rendered with.
+			templated := false
+			// multi is set when the server is written file by file.
+			multi := false
+			logrus.Warn("step: ", steps[step])
+			// What the step adds to the prompt is also recorded for chat
+			// models: its instructions when it starts over, or more context
+			// for a fix.
+			before := prompt
+			fresh := !errMode
+
+			switch steps[step] {
+			case SeedlingStepProtobufs:
+				if !errMode {
+					prompt = fmt.Sprintf("%s\n", fmt.Sprintf(
+						protoPrompt,
+						seedling.Brief(),
+						seedling.Name,
+						seedling.Name,
+						seedling.Toolchain,
+						seedling.Brief(),
+					)) + seedling.Plan.PlanHint() + tmpl.protoHint() + refine
+				} else {
+					errMode = false
+				}
+				prompt += "```protobuf\n"
+				repoPath = filepath.Join("protobufs", seedling.Name+".proto")
+				codeType = "proto"
+				cmdCmd = "protoc"
+				cmdArgs = []string{
+					"-I=.",
+					"--go_out=.",
+					"--go-grpc_out=.",
+					repoPath,
+				}
+
+			case SeedlingStepServer:
+				protoFile := filepath.Join(
+					s.RepoDir(seedling),
+					"protobufs",
+					seedling.Name+".pb.go",
+				)
+				grpcFile := filepath.Join(
+					s.RepoDir(seedling),
+					"protobufs",
+					seedling.Name+"_grpc.pb.go",
+				)
+				protoBufDefs, grpcDefs, err := packServerDefs(protoFile, grpcFile, s.serverDefsBudget(seedling))
+				if err != nil {
+					logrus.WithError(err).Error("failed to read protobuf definitions")
+					return
+				}
+
+				serverFile := filepath.Join(
+					s.RepoDir(seedling),
+					"server",
+					"main.go",
+				)
+
+				if !errMode {
+					secretNames, err := s.SecretNames(ctx, seedling.ID)
+					if err != nil {
+						logrus.WithField("error", err).Error("failed to get secret names")
+						return
+					}
+					deps, err := s.Dependencies(ctx, seedling.ID)
+					if err != nil {
+						logrus.WithField("error", err).Error("failed to get seedling dependencies")
+						return
+					}
+					secretsHint := ""
+					hint := 12
+					if len(secretNames) > 0 {
+						hint++
+						secretsHint = "\n12. The following secrets are available as files, read each one at startup\n" +
+							"    from /secrets/<name> instead of hardcoding or reading them from elsewhere:\n"
+						for _, name := range secretNames {
+							secretsHint += "    - /secrets/" + name + "\n"
+						}
+					}
+					hints := secretsHint + s.reflectionHint(hint)
+					if s.Config.GRPCSmokeTest {
+						hint++
+					}
+					hints += dependencyHint(hint, deps)
+					/*
+						// TODO: I like this idea, but GPT hallucinates too many repos that don't exist.
+						// Maybe we can use the description to find some real repos? On Github, sourcegraph etc
+									moduleSuggestions, err := gpt(ctx, c, fmt.Sprintf(`
+							Give me three real Go modules that might be useful for a service with this description: %s.
+
+							Include them in the format:
+
+							- github.com/user/repo - description
+							...
+							`+"```", seedling.Description))
+											if err != nil {
+												logrus.Error(err)
+												return
+											}
+					*/
+					// get from go.pkg.dev
+					prompt = fmt.Sprintf(`%s
+Now write a server implementation for the service method(s).
+
+It should be package main.
+
+Here are some instructions:
+
+1. Make sure it's an actual production implementation of the service. Implement
+   everything. Don't leave anything out.
+2. Use external libraries, packages, and binaries if needed.
+3. Assume you are running in a Docker container (Linux). This will 
+   run on Debian, so make sure it's compatible with Debian (Bookworm).
+   This should be oriented at processor architecture %s.
+4. Make the gRPC service listen on port %d, with insecure connection
+   settings. In the same file, also include an HTTP server that will listen on
+   port %d, take in JSON equivalent to the gRPC call, and call the equivalent
+   gRPC server method. If the HTTP server method receives file(s), use FormFile.
+5. Log information about each request to the HTTP server with logrus. Use logrus.WithField
+   to include information about the request, including relevant args, method name, duration etc.
+6. Make sure to go the gRPC Serve() in a goroutine, and then block on the HTTP server.
+7. In addition to exposing the gRPC service, also expose an HTTP health check endpoint at /healthz.
+8. Add an additional HTTP endpoint /schema that will return the schema for the
+   service, i.e., a JSON representation of the request/response and their JSON
+   fields, with some extra "labels": [], that describe things like file_type if
+   there is an arg of []byte. Like, "labels": [["file_type", "image/png"]]. This
+   will be used to generate frontend code automatically.
+9. Don't worry about importing protoimpl, github.com/golang/protobuf stuff. You
+   don't need that.
+10. If the service generates files, also write each one under /outputs (e.g.
+   /outputs/<request id>/result.png) and include its path in the response.
+11. If the service needs configuration, such as keys for other APIs or which
+   Slack channel to post to, read each setting from an environment variable.
+   List every one at the very top of the file, before package main, in a
+   comment block like this one, or leave the block out if there are none:
+
+   // Environment:
+   //   SLACK_WEBHOOK_URL (required): the webhook messages are posted to
+   //   SLACK_CHANNEL (optional): the channel to post to, #general if unset%s%s
+
+Here are example responses from the /schema endpoint:
+
+1.
+
+{
+  "title": "A registration form",
+  "description": "A simple form example.",
+  "type": "object",
+  "required": [
+    "firstName",
+    "lastName"
+  ],
+  "properties": {
+    "firstName": {
+      "type": "string",
+      "title": "First name",
+      "default": "Chuck"
+    },
+    "lastName": {
+      "type": "string",
+      "title": "Last name"
+    },
+    "age": {
+      "type": "integer",
+      "title": "Age"
+    },
+    "bio": {
+      "type": "string",
+      "title": "Bio"
+    },
+    "password": {
+      "type": "string",
+      "title": "Password",
+      "minLength": 3
+    },
+    "telephone": {
+      "type": "string",
+      "title": "Telephone",
+      "minLength": 10
+    }
+  }
+}
+
+2.
+
+{
+  "title": "Files",
+  "type": "object",
+  "properties": {
+    "file": {
+      "type": "string",
+      "format": "data-url",
+      "title": "Single file"
+    },
+    "files": {
+      "type": "array",
+      "title": "Multiple files",
+      "items": {
+        "type": "string",
+        "format": "data-url"
+      }
+    },
+    "filesAccept": {
+      "type": "string",
+      "format": "data-url",
+      "title": "Single File with Accept attribute"
+    }
+  }
+}
+
+Think step by step. If you want to provide commentary, do it in comments.
+
+Actually implement everything as if it were production ready. Make sure to
+terminate all string literals.
+
+Some of the generated protobuf code looks like this:
+
+%s
+
+And the gRPC:
+
+%s
+
+Now let's write the code. Write only the code.
+`, prompt, platformArch(seedling.Platform), ports.GRPCContainerPort, ports.HTTPContainerPort, hints, tmpl.serverHint(), protoBufDefs,
+						grpcDefs)
+					splitServer, serverContext = nil, prompt
+				} else {
+					errMode = false
+					if !dumpedModDocs {
+						// dumpedModDocs = true
+						docs := ""
+						if s.Config.SymbolDocs {
+							docs = s.symbolDocs(ctx, seedling, lastOutput)
+						}
+						if docs != "" {
+							observeFixDocs(FixDocsSymbols)
+						} else if docs = s.goDocs(ctx, seedling, serverFile); docs != "" {
+							observeFixDocs(FixDocsGoDoc)
+						}
+						prompt += docs
+					}
+				}
+
+				if serverContext == "" {
+					// resumed from a checkpoint
+					serverContext = prompt
+				}
+				prompt += "```go\n"
+				codeType = "go"
+				cmdCmd = "sh"
+				multi = splitServer != nil || s.multiFileServer(seedling)
+				if multi {
+					repoPath = "server"
+					cmdArgs = []string{
+						"-c",
+						"go get ./... && goimports -w ./server && go build -o /tmp/server ./server",
+					}
+					break
+				}
+				repoPath = filepath.Join("server", "main.go")
+				cmdArgs = []string{
+					"-c",
+					"go get ./... && goimports -w ./server/main.go && go build -o /tmp/server ./server",
+				}
+			case SeedlingStepServerTests:
+				if !errMode {
+					dir := filepath.Join(s.RepoDir(seedling), "protobufs")
+					protoBufDefs, _, err := packServerDefs(
+						filepath.Join(dir, seedling.Name+".pb.go"),
+						filepath.Join(dir, seedling.Name+"_grpc.pb.go"),
+						s.serverDefsBudget(seedling),
+					)
+					if err != nil {
+						logrus.WithError(err).Error("failed to read protobuf definitions")
+						return
+					}
+					prompt = fmt.Sprintf(`%s
+Now write tests for the server in server/main_test.go.
+
+It should be package main, in the same package as the server.
+
+Here are some instructions:
+
+1. Write table-driven tests (using t.Run for each case) for every RPC handler
+   on the gRPC server implementation. Call the handler methods directly
+   rather than dialing the network.
+2. Cover the success path and the obvious invalid-argument cases for each RPC.
+3. Don't depend on the network, external services, or files that don't exist.
+4. Use only the standard library testing package and the generated protobuf types.
+
+The generated protobuf types look like this:
+
+%s
+
+Now let's write the code. Write only the code.
+`, prompt, protoBufDefs)
+				} else {
+					errMode = false
+				}
+				prompt += "```go\n"
+				repoPath = filepath.Join("server", "main_test.go")
+				codeType = "go"
+				cmdCmd = "sh"
+				cmdArgs = []string{
+					"-c",
+					"go get ./... && goimports -w ./server/main_test.go && go test -json ./server/...",
+				}
+			case SeedlingStepDockerfile:
+				templated = s.Config.DockerfileTemplate
+				if templated {
+					if !errMode {
+						prompt = dockerfilePackagesPrompt(prompt, seedling)
+					} else {
+						errMode = false
+					}
+					prompt += "```json\n"
+					repoPath = "Dockerfile"
+					codeType = "json"
+					cmdCmd = "docker"
+					cmdArgs = s.SeedlingImageBuildArgs(seedling, s.Config.BuildCache)
+					break
+				}
+				if !errMode {
+					syntaxLine := ""
+					goGetLine := "RUN go get ./..."
+					goBuildLine := "RUN go build -o /tmp/svc ./server"
+					if s.Config.BuildCache {
+						syntaxLine = "# syntax=docker/dockerfile:1\n"
+						goGetLine = "RUN " + goModCacheMount() + " go get ./..."
+						goBuildLine = "RUN " + goModCacheMount() + " go build -o /tmp/svc ./server"
+					}
+					prompt = fmt.Sprintf(`%s
+Now write a Dockerfile (multi-stage build) to build and run your server.
+
+Here is an example:
+
+%sFROM %s AS builder
+
+RUN apt-get update && apt-get install -y --no-install-recommends \
+  <other_pkgs>
+COPY . /app
+WORKDIR /app
+%s
+%s
+
+FROM debian:bookworm-slim
+
+RUN apt-get update && apt-get install -y --no-install-recommends \
+  <package_1> \
+  <package_2>
+RUN groupadd -r appuser && useradd -r -g appuser appuser
+COPY --from=builder /tmp/svc /bin/svc
+RUN chown appuser:appuser /bin/svc
+USER appuser
+EXPOSE %d
+EXPOSE %d
+CMD ["/bin/svc"]
+
+Make sure to install any external libraries, packages, and binaries you need.
+Build with the %s image, which has Go %s; don't install Go from apt.
+
+Make sure to include this line:
+
+%s
+
+Think step by step -- what's the best way to build the file?
+
+Write the code. Write only the code.
+`, prompt, syntaxLine, GoImage(seedling.Toolchain), goGetLine, goBuildLine, ports.GRPCContainerPort, ports.HTTPContainerPort,
+						GoImage(seedling.Toolchain), seedling.Toolchain, goGetLine)
+				} else {
+					errMode = false
+				}
+				prompt += "```dockerfile\n"
+				repoPath = filepath.Join("Dockerfile")
+				codeType = "dockerfile"
+				cmdCmd = "docker"
+				cmdArgs = s.SeedlingImageBuildArgs(seedling, s.Config.BuildCache)
+			default:
+				logrus.WithField("step", steps[step]).Error("unknown step")
+				return
+			}
+
+			// A step regenerated because its artifact was rejected is told
+			// why.
+			if fresh && steps[step] == seedling.ApprovalStep && seedling.ApprovalComment != "" {
+				prompt = withRejection(prompt, seedling.ApprovalComment)
+				if steps[step] == SeedlingStepServer {
+					serverContext = withRejection(serverContext, seedling.ApprovalComment)
+				}
+			}
+			if added := stepMessage(before, prompt); fresh {
+				s.appendMessage(ctx, seedling.ID, steps[step], llm.RoleSystem, added)
+			} else if added != "" {
+				s.appendMessage(ctx, seedling.ID, steps[step], llm.RoleUser, added)
+			}
+
+			file := filepath.Join(s.RepoDir(seedling), repoPath)
+			// Image builds are already isolated by docker, and builder
+			// containers can't run docker.
+			runner := s.Runner
+			if cmdCmd == "docker" || cmdCmd == "true" {
+				runner = HostRunner{Env: s.BuildEnv()}
+			}
+			spec := BuildSpec{Dir: s.RepoDir(seedling), Name: cmdCmd, Args: cmdArgs, Toolchain: seedling.Toolchain}
+			if cmdCmd == "docker" && s.Config.BuildCache {
+				spec.Env = append(spec.Env, "DOCKER_BUILDKIT=1")
+			}
+			buildCmd, err := runner.Command(ctx, spec)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to set up build command")
+				return
+			}
+
+			s.BuildRegistry.progress(seedling.ID, steps[step], attempt+1)
+			graph.start(steps[step])
+			attemptStart := time.Now()
+			set = s.Settings.Current()
+			temperature := set.temperature(errs)
+			if seedling.Temperature != nil {
+				temperature = *seedling.Temperature
+			}
+			temperature += float32(repeats) * RepeatTemperatureBump
+			if temperature > store.MaxTemperature {
+				temperature = store.MaxTemperature
+			}
+			// Code a human accepted after the quality check rejected it is
+			// built as-is instead of asking for another version.
+			var override *QualityCheckRecord
+			if steps[step] == SeedlingStepServer {
+				override, err = s.takeQualityOverride(ctx, seedling.ID)
+				if err != nil {
+					logrus.WithField("error", err).Error("failed to get quality override")
+				}
+			}
+			var gptOutput string
+			var opts llm.CompletionOptions
+			if override != nil {
+				logrus.WithField("quality_check_id", override.ID).
+					WithField("overridden_by", override.OverriddenBy).
+					Info("Building overridden server code")
+				gptOutput = override.Code
+				if multi {
+					if splitServer == nil {
+						splitServer = &serverFiles{}
+					}
+					splitServer.setCode(gptOutput)
+				}
+			} else {
+				s.BuildRegistry.prompting(seedling.ID)
+				if multi {
+					gptOutput, opts, err = s.completeServerFiles(ctx, seedling, serverContext, &splitServer, temperature)
+				} else {
+					gptOutput, opts, err = s.complete(ctx, seedling, steps[step], codeType, prompt, temperature)
+				}
+				if err != nil {
+					logrus.WithField("error", err).Error("failed to get gpt output")
+					reason = "completion failed: " + err.Error()
+					return
+				}
+			}
+
+			reply := s.appendMessage(ctx, seedling.ID, steps[step], llm.RoleAssistant, gptOutput)
+
+			attempt++
+			code := attemptCode(gptOutput, codeType)
+			// Templated Dockerfiles are built and recorded as rendered.
+			// invalidErr is why code can't be built at all.
+			written, writtenType := gptOutput, codeType
+			var invalidErr error
+			switch {
+			case templated:
+				writtenType = "dockerfile"
+				if written, invalidErr = s.templateDockerfile(ctx, seedling, gptOutput); invalidErr == nil {
+					code = written
+				}
+			case multi:
+				code = gptOutput
+				if len(code) > s.Config.ServerMaxBytes {
+					invalidErr = fmt.Errorf("the server's files have %d bytes of code, more than the %d allowed: make them more concise",
+						len(code), s.Config.ServerMaxBytes)
+				}
+			}
+			hash := outputHash(steps[step], code)
+			var output string
+			var fixes []string
+			var reports *attemptReports
+			var buildDuration time.Duration
+			previous, duplicate := failedOutputs[hash]
+			if duplicate && override == nil {
+				logrus.WithField("name", seedling.Name).
+					WithField("step", steps[step]).
+					WithField("repeats", repeats+1).
+					Warn("model wrote code that already failed, not building it again")
+				observeDuplicateOutput(steps[step])
+				output, err = previous, errDuplicateOutput
+			} else if invalidErr != nil {
+				duplicate = false
+				output, err = invalidErr.Error()+"\n", invalidErr
+			} else if multi {
+				duplicate = false
+				output, fixes, reports, buildDuration, err = s.runSeedlingFiles(
+					withSettings(ctx, set),
+					splitServer.sources(),
+					codeType,
+					buildCmd,
+					steps[step],
+					attempt,
+					prompt,
+					seedling,
+					opts.Model,
+					override != nil,
+				)
+			} else {
+				duplicate = false
+				output, fixes, reports, buildDuration, err = s.runSeedling(
+					withSettings(ctx, set),
+					file,
+					writtenType,
+					buildCmd,
+					written,
+					steps[step],
+					attempt,
+					prompt,
+					seedling,
+					opts.Model,
+					override != nil,
+				)
+			}
+			a := store.Attempt{
+				SeedlingID:      seedling.ID,
+				Step:            steps[step],
+				Attempt:         attempt,
+				Success:         err == nil,
+				Output:          output,
+				BuildDurationMS: buildDuration.Milliseconds(),
+				DurationMS:      time.Since(attemptStart).Milliseconds(),
+				BuildCache:      cmdCmd == "docker" && s.Config.BuildCache,
+				File:            repoPath,
+				Model:           opts.Model,
+				Temperature:     opts.Temperature,
+				MaxTokens:       opts.MaxTokens,
+				Code:            code,
+				AutoFixes:       strings.Join(fixes, "; "),
+				Duplicate:       duplicate,
+				TraceID:         seedling.TraceID,
+			}
+			if reports != nil {
+				a.ProtoReport, a.TranscodingReport = reports.proto, reports.transcoding
+			}
+			var policyErr *ImportPolicyError
+			if errors.As(err, &policyErr) {
+				a.RejectedModules = strings.Join(policyErr.Modules, ",")
+			}
+			if override == nil {
+				// Estimated, since only some providers report usage.
+				a.PromptTokens = llm.EstimateTokens(prompt)
+				a.CompletionTokens = llm.EstimateTokens(gptOutput)
+			}
+			if err == nil {
+				// What to roll back to if a later change breaks the
+				// seedling.
+				if a.CommitSHA, err = RepoHead(ctx, s.RepoDir(seedling)); err != nil {
+					logrus.WithField("error", err).Warn("failed to get seedling repo HEAD")
+					err = nil
+				}
+			}
+			attemptErr := err
+			lastOutput = output
+			// record is called once the state has been updated for the
+			// next attempt, so that's what the checkpoint resumes from.
+			record := func() {
+				if err := s.RecordAttempt(ctx, a, store.SeedlingCheckpoint{
+					Step:          steps[step],
+					Attempt:       attempt,
+					Errs:          errs,
+					ErrMode:       errMode,
+					DumpedModDocs: dumpedModDocs,
+					Prompt:        prompt,
+					LastOutput:    output,
+				}); err != nil {
+					logrus.WithField("error", err).Error("failed to record attempt")
+				} else if a.Success {
+					s.publishAttemptDiff(ctx, seedling, a.Step)
+				}
+				if attemptErr != nil {
+					s.emitAttemptFailed(ctx, seedling, a, attemptErr)
+				}
+			}
+			if errors.Is(err, errNoCode) && nudges < set.MaxNudges {
+				// Not a build failure, the model just didn't write any code.
+				nudges++
+				nudge := "That wasn't code. Output only the code, with no explanation.\n"
+				prompt += gptOutput + "```\n\n" + nudge
+				s.appendMessage(ctx, seedling.ID, steps[step], llm.RoleUser, nudge)
+				errMode = true
+				record()
+				continue
+			}
+			nudges = 0
+			if duplicate {
+				repeats++
+			} else {
+				repeats = 0
+				if err != nil && override == nil {
+					failedOutputs[hash] = output
+				}
+			}
+			if err != nil {
+				if steps[step] == SeedlingStepServerTests {
+					output = s.recordTestResults(ctx, seedling, output)
+				}
+				logrus.WithField("error", err).Error("failed to run seedling")
+				errs++
+				if errs > set.MaxErrs {
+					logrus.Error("hit max errs, trying new run")
+					errs = 0
+					step = startStep
+					if err := graph.rerun(steps[step]); err != nil {
+						logrus.WithField("error", err).Error("failed to update seedling step")
+						return
+					}
+					record()
+					break
+				}
+				if err := graph.set(steps[step], StepStatusFixing, err.Error()); err != nil {
+					logrus.WithField("error", err).Error("failed to update step status")
+				}
+
+				var fix string
+				var transcodingErr *TranscodingError
+				if duplicate {
+					fix = duplicateFix(code, filepath.Base(repoPath), output, set.ErrorOutputLines, repeats)
+				} else if templated {
+					dockerfile := ""
+					if invalidErr == nil {
+						dockerfile = code
+					}
+					var kind string
+					fix, kind = packagesFix(dockerfile, output, err, set.ErrorOutputLines)
+					observeFixPrompt(steps[step], kind)
+				} else if errors.As(err, &transcodingErr) && !multi {
+					observeFixPrompt(steps[step], FixPromptTranscoding)
+					fix = fixesNote(fixes) + transcodingErr.fix(s.asWritten(code, codeType), repoPath)
+				} else if multi {
+					if strings.TrimSpace(output) == "" {
+						output = err.Error() + "\n"
+					}
+					report, kind := splitServer.implicate(output, set.ErrorOutputLines)
+					observeFixPrompt(steps[step], kind)
+					fix = fixesNote(fixes) + report
+				} else {
+					if strings.TrimSpace(output) == "" {
+						output = err.Error() + "\n"
+					}
+					report, kind := errorReport(s.asWritten(code, codeType), repoPath, output, set.ErrorOutputLines)
+					observeFixPrompt(steps[step], kind)
+					fix = fixesNote(fixes) + "That code didn't work.\n\n" + report +
+						"\nWrite a version that fixes that error.\n"
+				}
+				prompt += gptOutput + "```\n\n" + fix
+				s.appendMessage(ctx, seedling.ID, steps[step], llm.RoleUser, fix)
+				errMode = true
+				record()
+			} else {
+				if steps[step] == SeedlingStepServerTests {
+					s.recordTestResults(ctx, seedling, output)
+				}
+				s.acceptMessage(ctx, reply)
+				if steps[step] == SeedlingStepServer {
+					if err := s.recordEnvRequirements(ctx, seedling, code); err != nil {
+						logrus.WithField("error", err).Error("failed to record env requirements")
+					}
+					s.RecordModuleReport(ctx, seedling)
+				}
+
+				if err := graph.set(steps[step], StepStatusSucceeded, ""); err != nil {
+					logrus.WithField("error", err).Error("failed to update seedling step")
+					return
+				}
+				observeStepAttempts(steps[step], attempt)
+				if steps[step] == SeedlingStepDockerfile {
+					observeDockerfileAttempts(templated, attempt)
+				}
+				prompt += "\n\n" + gptOutput + "\n\n"
+				prompt += "```\n\n" + fixesNote(fixes) + "Great. That worked. Let's move on to the next step.\n\n"
+				step += 1
+				attempt = 0
+				failedOutputs = map[string]string{}
+				record()
+				if restart = s.checkEdits(ctx, &seedling, steps[step]); restart != nil {
+					return
+				}
+				if gates[steps[step-1]] {
+					if err := s.awaitApproval(ctx, seedling, steps[step-1]); err != nil {
+						reason = "failed to wait for approval: " + err.Error()
+						return
+					}
+					awaitingApproval = true
+					return
+				}
+				if seedling.ApprovalStep == steps[step-1] {
+					// It's no longer a gate, so it wasn't approved.
+					s.clearApproval(ctx, &seedling)
+				}
+				graph.startBranches(prompt)
+			}
+		}
+	}
+}
+
+// FailSeedling moves a seedling to SeedlingStepFailed, recording why its
+// build stopped and the step it stopped at.
+func (s *Pipeline) FailSeedling(ctx context.Context, seedling store.Seedling, reason string) {
+	logrus.WithField("name", seedling.Name).WithField("reason", reason).Error("seedling build failed")
+	now := time.Now()
+	if _, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET failed_step = CASE WHEN step = $1 THEN failed_step ELSE step END,
+	   step = $1, failure_reason = $2, failed_at = $3, version = version + 1
+	 WHERE id = $4
+	 `, SeedlingStepFailed, reason, now, seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to record seedling failure")
+		return
+	}
+	if err := s.DB.GetContext(ctx, &seedling.FailedStep,
+		"SELECT failed_step FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to get seedling failed step")
+	}
+	seedling.FailureReason = reason
+	seedling.FailedAt = &now
+	s.Notify(ctx, seedling, EventFailed, seedling.FailedStep)
+}
+
+// recordTestResults stores the pass/fail counts from `go test -json` output on
+// the seedling and returns a readable summary of the failures.
+func (s *Pipeline) recordTestResults(ctx context.Context, seedling store.Seedling, output string) string {
+	passed, failed, summary := parseGoTestJSON(output)
+	if _, err := s.DB.ExecContext(
+		ctx,
+		"UPDATE seedlings SET tests_passed = $1, tests_failed = $2 WHERE id = $3",
+		passed,
+		failed,
+		seedling.ID,
+	); err != nil {
+		logrus.WithField("error", err).Error("failed to update seedling test results")
+	}
+	logrus.WithField("passed", passed).
+		WithField("failed", failed).
+		Info("Recorded seedling test results")
+	if summary == "" {
+		return output
+	}
+	return summary
+}
+
+// attemptReports are the checks of an attempt's generated code that are
+// stored with it.
+type attemptReports struct {
+	proto       *store.ProtoReport
+	transcoding *store.TranscodingReport
+}
+
+func (s *Pipeline) runSeedling(
+	ctx context.Context,
+	file string,
+	codeType string,
+	buildCmd *exec.Cmd,
+	gptOut string,
+	step string,
+	attempt int,
+	prompt string,
+	seedling store.Seedling,
+	model string,
+	accepted bool,
+) (string, []string, *attemptReports, time.Duration, error) {
+	gptOut, err := extractCode(gptOut, codeType)
+	if err != nil {
+		return err.Error() + "\n", []string{}, nil, 0, err
+	}
+	rel, err := filepath.Rel(buildCmd.Dir, file)
+	if err != nil {
+		return "", []string{}, nil, 0, err
+	}
+	return s.runSeedlingFiles(ctx, []sourceFile{{path: filepath.ToSlash(rel), code: gptOut}}, codeType, buildCmd, step, attempt, prompt, seedling, model, accepted)
+}
+
+// runSeedlingFiles is runSeedling for a step that writes several files,
+// with the code already extracted.
+func (s *Pipeline) runSeedlingFiles(
+	ctx context.Context,
+	files []sourceFile,
+	codeType string,
+	buildCmd *exec.Cmd,
+	step string,
+	attempt int,
+	prompt string,
+	seedling store.Seedling,
+	model string,
+	accepted bool,
+) (string, []string, *attemptReports, time.Duration, error) {
+	fixes := []string{}
+	gptOut := joinSourceFiles(files)
+	if step == SeedlingStepServer && !accepted {
+		tmpl, err := s.seedlingTemplate(ctx, seedling)
+		if err != nil {
+			return err.Error() + "\n", fixes, nil, 0, err
+		}
+		maxErrs := s.pipelineSettings(ctx).QualityCheckMaxErrs
+		errs := 0
+		qualityPrompt := fmt.Sprintf("```\n%s```"+`
+In the above code, based on how well it seems to implement the desired functionality of a service that %s, output exactly one JSON object in one of these formats:
+
+`+"```"+`
+{"quality": "good", "reason": "would definitely pass a code review", "suggestions": "none"}
+`+"```"+`
+
+`+"```"+`
+{"quality": "bad", "reason": "unimplemented method", "suggestions": "actually implement the functionality"}
+`+"```"+`
+
+The quality check should return "bad" if there are TODOs, stubs, methods,
+examples, simulations etc. that just return nil or true without doing anything,
+etc. For instance, "we'll do this later" is a strong indication that the code
+quality is "bad".
+%s%s`+"```json\n", gptOut, seedling.Brief(), seedling.Plan.PlanHint(), tmpl.qualityHint())
+		for {
+			if maxErrs == errs {
+				return "", fixes, nil, 0, errors.New("max errors exceeded")
+			}
+			qualityCheckOut, err := s.CompleteText(ctx, SeedlingStepServerQualityCheck, qualityPrompt, 1.0)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to get gpt output")
+				return "", fixes, nil, 0, err
+			}
+			qualityCheckOut = strings.TrimSpace(qualityCheckOut)
+
+			qualityCheck, err := parseQualityCheck(qualityCheckOut)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to parse quality check")
+				errs++
+				qualityPrompt += qualityCheckOut + "\n```\n\nThat wasn't a valid quality check (" + err.Error() + ")." +
+					` Respond with exactly one JSON object with "quality" set to "good" or "bad", and nothing else.` + "\n```json\n"
+				continue
+			}
+			if err := s.recordQualityCheck(ctx, seedling.ID, qualityCheck, gptOut); err != nil {
+				logrus.WithField("error", err).Error("failed to record quality check")
+			}
+
+			if qualityCheck.Quality != QualityGood {
+				s.Emit(ctx, seedling.ID, SeedlingEvent{
+					Type:    EventQualityCheckFailed,
+					Step:    step,
+					Payload: EventPayload{"attempt": attempt, "reason": EventText(qualityCheck.Reason)},
+				})
+				prompt += "```" + fmt.Sprintf(`
+You didn't pass the quality check. Here's the output from the quality check:
+%s`, qualityCheckOut)
+				prompt += "\n\nWrite a version that fixes that error.\n"
+				return "", fixes, nil, 0, qualityCheck.Error()
+			}
+
+			break
+		}
+	}
+	var header provenanceHeader
+	if s.Config.ProvenanceStamping {
+		var err error
+		if header, err = s.provenanceHeader(ctx, seedling, model); err != nil {
+			return err.Error() + "\n", fixes, nil, 0, err
+		}
+	}
+	writes := make([]repoWrite, len(files))
+	for i, f := range files {
+		written := f.code
+		if s.Config.ProvenanceStamping {
+			written = stampProvenance(f.code, codeType, header)
+		}
+		writes[i] = repoWrite{path: f.path, data: []byte(written), perm: 0644}
+		if codeType == "bash" {
+			writes[i].perm = 0755
+		}
+	}
+	// Paths out of the repo and code over the size caps fail the attempt
+	// before any of its files are written.
+	var unsafeErr *UnsafeWriteError
+	if err := writeRepoFiles(buildCmd.Dir, writes, s.repoLimits()); errors.As(err, &unsafeErr) {
+		return err.Error() + "\n", fixes, nil, 0, err
+	} else if err != nil {
+		return "", fixes, nil, 0, err
+	}
+	// A server's files are all rewritten, so ones no longer in its
+	// manifest don't break the build.
+	if step == SeedlingStepServer {
+		if err := removeStaleServerFiles(buildCmd.Dir, files); err != nil {
+			return "", fixes, nil, 0, err
+		}
+	}
+	for _, w := range writes {
+		// The mode of a file that was already there isn't changed by
+		// writing it.
+		if w.perm != 0644 {
+			if err := os.Chmod(filepath.Join(buildCmd.Dir, filepath.FromSlash(w.path)), w.perm); err != nil {
+				return "", fixes, nil, 0, err
+			}
+		}
+	}
+
+	for _, f := range files {
+		file := filepath.Join(buildCmd.Dir, filepath.FromSlash(f.path))
+		fixed, err := runPreBuildHooks(ctx, codeType, HookTarget{Dir: buildCmd.Dir, File: file, Runner: s.Runner, Toolchain: seedling.Toolchain})
+		fixes = append(fixes, fixed...)
+		if err != nil {
+			return err.Error() + "\n", fixes, nil, 0, err
+		}
+		if codeType == "go" {
+			if err := s.checkImports(ctx, buildCmd.Dir, file); err != nil {
+				return err.Error() + "\n", fixes, nil, 0, err
+			}
+		}
+	}
+	if len(fixes) > 0 {
+		logrus.WithField("step", step).WithField("fixes", fixes).Info("Pre-build hooks fixed generated code")
+	}
+
+	// A build's current command is that of the step it isn't running on a
+	// branch, the others are only tracked so they're killed with it.
+	branch := branchSteps[step]
+	if branch {
+		s.BuildRegistry.spawn(seedling.ID, buildCmd)
+	} else {
+		s.BuildRegistry.Running(seedling.ID, buildCmd)
+	}
+	out := NewBuildOutput(s.Config.BuildOutputMaxBytes, func(line string) {
+		s.Events.Publish(seedling.ID, SeedlingEvent{Type: EventBuildOutput, Step: step, Data: line})
+	})
+	buildCmd.Stdout = out
+	buildCmd.Stderr = out
+	start := time.Now()
+	err := buildCmd.Run()
+	out.Close()
+	buildDuration := time.Since(start)
+	if branch {
+		s.BuildRegistry.exited(seedling.ID, buildCmd)
+	} else {
+		s.BuildRegistry.Running(seedling.ID, nil)
+	}
+	output := out.String()
+	observeBuildCommand(step, err, buildDuration)
+	if codeType == "go" || codeType == "dockerfile" {
+		s.ModCacheStats.observe(step, output)
+	}
+	logrus.WithField("step", step).
+		WithField("duration", buildDuration).
+		WithField("success", err == nil).
+		Info("Ran seedling build command")
+	if err != nil {
+		return output, fixes, nil, buildDuration, err
+	}
+
+	// Generated code is read back for the proto's descriptor. A check that
+	// can't run doesn't fail the attempt.
+	reports := &attemptReports{}
+	if step == SeedlingStepProtobufs {
+		if reports.proto, err = s.checkProto(ctx, seedling, buildCmd.Dir); err != nil {
+			logrus.WithField("error", err).Error("failed to check protobufs")
+		} else if err := protoCheckErr(reports.proto); err != nil {
+			return err.Error(), fixes, reports, buildDuration, err
+		}
+	}
+	if step == SeedlingStepServer && s.Config.TranscodingCheck {
+		advisory := s.Config.TranscodingCheckAdvisory || accepted
+		if reports.transcoding, err = s.checkTranscoding(seedling, buildCmd.Dir, advisory); err != nil {
+			logrus.WithField("error", err).Error("failed to check transcoding")
+		} else if err := transcodingErr(reports.transcoding); err != nil {
+			return err.Error(), fixes, reports, buildDuration, err
+		}
+	}
+
+	// Steps on a branch commit only their own file, not whatever the
+	// steps running alongside them have written so far.
+	lock := s.BuildRegistry.repoLock(seedling.ID)
+	lock.Lock()
+	defer lock.Unlock()
+	rels := []string{}
+	for _, f := range files {
+		rel := filepath.FromSlash(f.path)
+		if s.Config.ProvenanceStamping {
+			if err := s.recordProvenance(seedling, rel, model, attempt); err != nil {
+				return "", fixes, reports, buildDuration, fmt.Errorf("failed to record provenance: %w", err)
+			}
+		}
+		rels = append(rels, rel)
+	}
+	gitAddCmd := exec.CommandContext(ctx, "git", "add", ".")
+	if branch {
+		gitAddCmd = exec.CommandContext(ctx, "git", append(append([]string{"add", "--"}, rels...), ProvenanceFile)...)
+	}
+	gitAddCmd.Stdout = os.Stdout
+	gitAddCmd.Stderr = os.Stderr
+	gitAddCmd.Dir = buildCmd.Dir
+	if err := gitAddCmd.Run(); err != nil {
+		return "", fixes, reports, buildDuration, err
+	}
+
+	// A restarted build can write the same code again, which still gets
+	// its attempt's commit.
+	gitCmd := exec.CommandContext(ctx, "git", "commit", "--allow-empty", "-m", commitMessage(seedling, step, attempt))
+	gitCmd.Stdout = os.Stdout
+	gitCmd.Stderr = os.Stderr
+	gitCmd.Dir = buildCmd.Dir
+	if err := gitCmd.Run(); err != nil {
+		return "", fixes, reports, buildDuration, err
+	}
+
+	return output, fixes, reports, buildDuration, nil
+}
+
+// goDocs is the go doc of every package the server file imports, with
+// examples, or "" if go doc fails for any of them.
+func (s *Pipeline) goDocs(ctx context.Context, seedling store.Seedling, serverFile string) string {
+	nonStdImports := getNonStdImports(serverFile)
+	allDocs := "\nHere is some documentation that might be useful:\n"
+	goDocErr := false
+	mods := 0
+	for _, imp := range nonStdImports {
+		if strings.Contains(imp, "protobuf") ||
+			strings.Contains(imp, "logrus") ||
+			strings.Contains(imp, "grpc") ||
+			strings.Contains(imp, "spew") {
+			// skip for now, too spammy
+			continue
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", "go get ./... && go doc -short "+imp)
+		cmd.Dir = s.RepoDir(seedling)
+		cmd.Env = s.BuildEnv()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			logrus.WithField("error", err).WithField("import", imp).Error("failed to run go doc")
+			logrus.WithField("args", cmd.Args).WithField("output", string(out)).Debug("go doc output")
+			goDocErr = true
+		}
+		mods++
+		allDocs += string(out)
+
+		examples, err := GetExamples(ctx, cmd.Dir, imp)
+		if err != nil {
+			logrus.WithField("error", err).WithField("import", imp).Warn("failed to get examples")
+			continue
+		}
+		allDocs += examples
+	}
+	if goDocErr || mods == 0 {
+		return ""
+	}
+	return allDocs
+}
+
+func getNonStdImports(filepath string) []string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filepath, nil, parser.ImportsOnly)
+	if err != nil {
+		logrus.Error(err)
+		return nil
+	}
+
+	nonStdImports := []string{}
+	for _, i := range file.Imports {
+		path := strings.Trim(i.Path.Value, `"`)
+
+		if !strings.Contains(path, ".") || strings.HasPrefix(path, ".") {
+			continue
+		}
+
+		nonStdImports = append(nonStdImports, path)
+	}
+
+	return nonStdImports
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	HoneycombAPIHost = "https://api.honeycomb.io"
+
+	MarkerProcessStart = "process-start"
+	MarkerBuildStarted = "seedling-build-started"
+	MarkerCompleted    = "seedling-build-completed"
+	MarkerFailed       = "seedling-build-failed"
+
+	// MAX_MARKER_ATTEMPTS is how many times a marker is sent before it's
+	// dropped, backing off exponentially from MarkerBackoff. After
+	// MarkerBreakerFailures markers in a row are dropped, no more are sent
+	// for MarkerBreakerCooldown.
+	MAX_MARKER_ATTEMPTS   = 3
+	MarkerBackoff         = time.Second
+	MarkerTimeout         = 5 * time.Second
+	MarkerBreakerFailures = 3
+	MarkerBreakerCooldown = 5 * time.Minute
+)
+
+// Marker is a Honeycomb marker, which annotates the dataset's graphs.
+type Marker struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Markers sends markers to a Honeycomb dataset. It does nothing without a
+// dataset and API key, and sending never blocks or fails the caller.
+type Markers struct {
+	host    string
+	dataset string
+	apiKey  string
+	client  *http.Client
+	backoff time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func NewMarkers(config Config) *Markers {
+	return &Markers{
+		host:    HoneycombAPIHost,
+		dataset: config.MarkersDataset,
+		apiKey:  config.MarkersAPIKey,
+		client:  &http.Client{Timeout: MarkerTimeout},
+		backoff: MarkerBackoff,
+	}
+}
+
+func (m *Markers) enabled() bool {
+	return m != nil && m.dataset != "" && m.apiKey != ""
+}
+
+// Send sends the marker in the background.
+func (m *Markers) Send(marker Marker) {
+	if !m.enabled() {
+		return
+	}
+	go m.send(marker)
+}
+
+// newRequest builds the request creating the marker.
+func (m *Markers) newRequest(marker Marker) (*http.Request, error) {
+	body, err := json.Marshal(&marker)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, m.host+"/1/markers/"+m.dataset, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Honeycomb-Team", m.apiKey)
+	return req, nil
+}
+
+func (m *Markers) post(marker Marker) error {
+	req, err := m.newRequest(marker)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("honeycomb returned %s", resp.Status)
+	}
+	return nil
+}
+
+// allow reports whether the breaker lets a marker through. Once the cooldown
+// is over one marker is let through, and the breaker closes if it's sent.
+func (m *Markers) allow() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Now().After(m.openUntil)
+}
+
+// record counts a send's outcome, opening the breaker after too many
+// failures. Failures are logged once per opening rather than per marker.
+func (m *Markers) record(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.failures = 0
+		return
+	}
+	m.failures++
+	if m.failures < MarkerBreakerFailures {
+		logrus.WithField("error", err).Debug("failed to send Honeycomb marker")
+		return
+	}
+	m.openUntil = time.Now().Add(MarkerBreakerCooldown)
+	logrus.WithField("error", err).
+		WithField("failures", m.failures).
+		Warn("failed to send Honeycomb markers, pausing them for " + MarkerBreakerCooldown.String())
+}
+
+func (m *Markers) send(marker Marker) {
+	if !m.allow() {
+		return
+	}
+	backoff := m.backoff
+	var err error
+	for attempt := 1; attempt <= MAX_MARKER_ATTEMPTS; attempt++ {
+		if err = m.post(marker); err == nil {
+			break
+		}
+		if attempt < MAX_MARKER_ATTEMPTS {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	m.record(err)
+}
+
+// seedlingMarker marks a build event for the seedling, linking to it in the
+// API.
+func (s *Pipeline) seedlingMarker(seedling store.Seedling, markerType, message string) Marker {
+	return Marker{
+		Message: fmt.Sprintf("seedling %s %s", seedling.Name, message),
+		Type:    markerType,
+		URL:     s.Config.APIURL + "/api/v1/seedlings/" + PublicID(seedling.ID),
+	}
+}
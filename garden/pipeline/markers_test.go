@@ -0,0 +1,141 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// honeycomb is a fake Honeycomb API that fails the first fail markers it's
+// sent, or every one if fail is negative.
+type honeycomb struct {
+	fail int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	markers  []Marker
+}
+
+func (h *honeycomb) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, r)
+	body, _ := ioutil.ReadAll(r.Body)
+	var marker Marker
+	if err := json.Unmarshal(body, &marker); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.markers = append(h.markers, marker)
+	if h.fail < 0 || len(h.requests) <= h.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte(`{"id": "m1"}`))
+}
+
+func (h *honeycomb) sent() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.requests)
+}
+
+// testMarkers is a Markers client of h that doesn't wait between retries.
+func testMarkers(t *testing.T, h *honeycomb) *Markers {
+	t.Helper()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	m := NewMarkers(Config{MarkersDataset: "garden-dev", MarkersAPIKey: "hc-key"})
+	m.host = server.URL
+	m.backoff = time.Millisecond
+	return m
+}
+
+func TestMarkersPayload(t *testing.T) {
+	h := &honeycomb{}
+	m := testMarkers(t, h)
+	s := &Pipeline{Config: Config{APIURL: "https://garden.example.com"}}
+	seedling := store.Seedling{DBRow: store.DBRow{ID: 7}, Name: "echo"}
+	want := s.seedlingMarker(seedling, MarkerCompleted, "build completed")
+	m.send(want)
+
+	if len(h.requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(h.requests))
+	}
+	req := h.requests[0]
+	if req.Method != http.MethodPost || req.URL.Path != "/1/markers/garden-dev" {
+		t.Errorf("%s %s, want POST /1/markers/garden-dev", req.Method, req.URL.Path)
+	}
+	if got := req.Header.Get("X-Honeycomb-Team"); got != "hc-key" {
+		t.Errorf("API key %q", got)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q", got)
+	}
+	got := h.markers[0]
+	if got != want {
+		t.Errorf("marker %+v, want %+v", got, want)
+	}
+	if got.Message != "seedling echo build completed" || got.Type != MarkerCompleted {
+		t.Errorf("marker %+v", got)
+	}
+	if got.URL != "https://garden.example.com"+SeedlingPath(seedling.ID) {
+		t.Errorf("marker links to %s", got.URL)
+	}
+}
+
+func TestMarkersDisabled(t *testing.T) {
+	for _, config := range []Config{{}, {MarkersDataset: "garden-dev"}, {MarkersAPIKey: "hc-key"}} {
+		if NewMarkers(config).enabled() {
+			t.Errorf("markers are sent with %+v", config)
+		}
+	}
+	var m *Markers
+	m.Send(Marker{Message: "nil clients don't send"})
+}
+
+func TestMarkersRetry(t *testing.T) {
+	h := &honeycomb{fail: MAX_MARKER_ATTEMPTS - 1}
+	m := testMarkers(t, h)
+	m.send(Marker{Message: "retried", Type: MarkerProcessStart})
+	if n := h.sent(); n != MAX_MARKER_ATTEMPTS {
+		t.Errorf("sent %d times, want %d", n, MAX_MARKER_ATTEMPTS)
+	}
+	if m.failures != 0 {
+		t.Errorf("%d failures counted for a marker that was sent", m.failures)
+	}
+}
+
+func TestMarkersBreaker(t *testing.T) {
+	h := &honeycomb{fail: -1}
+	m := testMarkers(t, h)
+	for i := 0; i < MarkerBreakerFailures; i++ {
+		m.send(Marker{Message: "dropped", Type: MarkerFailed})
+	}
+	if n, want := h.sent(), MarkerBreakerFailures*MAX_MARKER_ATTEMPTS; n != want {
+		t.Fatalf("sent %d times, want %d", n, want)
+	}
+	m.send(Marker{Message: "not sent", Type: MarkerFailed})
+	if n := h.sent(); n != MarkerBreakerFailures*MAX_MARKER_ATTEMPTS {
+		t.Errorf("sent %d times with the breaker open", n)
+	}
+
+	// After the cooldown a marker is let through, and closes the breaker.
+	h.mu.Lock()
+	h.fail = 0
+	h.mu.Unlock()
+	m.openUntil = time.Now().Add(-time.Second)
+	m.send(Marker{Message: "sent", Type: MarkerCompleted})
+	if n := h.sent(); n != MarkerBreakerFailures*MAX_MARKER_ATTEMPTS+1 {
+		t.Errorf("sent %d times after the cooldown", n)
+	}
+	if !m.allow() || m.failures != 0 {
+		t.Errorf("breaker is still open after a marker was sent")
+	}
+}
@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+)
+
+var (
+	// openingFenceRegex matches the fence prompts end with to start the
+	// completion inside a code block, which chat models don't need.
+	openingFenceRegex = regexp.MustCompile("```[\\w-]*\n$")
+)
+
+// SeedlingMessage is one turn of a seedling's build conversation. Chat
+// models are prompted with the stored conversation rather than the prompt
+// string the completions API is, so it's kept for every build either way.
+type SeedlingMessage struct {
+	ID         int64      `db:"id"`
+	SeedlingID hide.Int64 `db:"seedling_id"`
+	Step       string     `db:"step"`
+	Role       string     `db:"role"`
+	Content    string     `db:"content"`
+	// Accepted is set on assistant messages whose code worked.
+	Accepted  bool      `db:"accepted"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// chatModel reports whether the model is prompted through the chat API.
+func (s *Pipeline) chatModel(model string) bool {
+	for _, m := range s.Config.ChatModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMessage adds a turn to the seedling's conversation and returns its
+// id. Failures are logged, not returned, since only chat models need it.
+func (s *Pipeline) appendMessage(ctx context.Context, seedlingID hide.Int64, step, role, content string) int64 {
+	result, err := s.DB.ExecContext(ctx, `
+	 INSERT INTO seedling_messages (seedling_id, step, role, content, created_at)
+	 VALUES ($1, $2, $3, $4, $5)
+	 `, seedlingID, step, role, content, time.Now())
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to record seedling message")
+		return 0
+	}
+	id, _ := result.LastInsertId()
+	return id
+}
+
+// acceptMessage marks an assistant message as code that worked.
+func (s *Pipeline) acceptMessage(ctx context.Context, id int64) {
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedling_messages SET accepted = TRUE WHERE id = $1", id); err != nil {
+		logrus.WithField("error", err).Error("failed to accept seedling message")
+	}
+}
+
+// resetMessages forgets the conversation for the steps a build is about to
+// redo from scratch.
+func (s *Pipeline) resetMessages(ctx context.Context, seedlingID hide.Int64, steps []string) {
+	for _, step := range steps {
+		if _, err := s.DB.ExecContext(ctx,
+			"DELETE FROM seedling_messages WHERE seedling_id = $1 AND step = $2", seedlingID, step); err != nil {
+			logrus.WithField("error", err).Error("failed to reset seedling messages")
+		}
+	}
+}
+
+// stepMessage is what a step added to the prompt, without the opening fence
+// at the end, as recorded in the conversation.
+func stepMessage(before, prompt string) string {
+	added := strings.TrimPrefix(prompt, before)
+	return strings.TrimSpace(openingFenceRegex.ReplaceAllString(added, ""))
+}
+
+// conversation is the seedling's stored conversation at the step packed into
+// the tokens the model has left after its completion, for prompting a chat
+// model with code of the given language. Only the step's own messages and
+// those of the steps it depends on are in it, not those of steps running
+// alongside it.
+func (s *Pipeline) conversation(ctx context.Context, seedlingID hide.Int64, step, lang string, opts llm.CompletionOptions) ([]llm.Message, error) {
+	rows := []SeedlingMessage{}
+	if err := s.DB.SelectContext(ctx, &rows,
+		"SELECT * FROM seedling_messages WHERE seedling_id = $1 ORDER BY id", seedlingID); err != nil {
+		return nil, err
+	}
+	ancestors := stepAncestors(step)
+	n := 0
+	for _, m := range rows {
+		if m.Step == step || ancestors[m.Step] {
+			rows[n] = m
+			n++
+		}
+	}
+	messages := packMessages(rows[:n], s.Config.ChatContextTokens-opts.MaxTokens)
+	if len(messages) > 0 && messages[0].Role == llm.RoleSystem {
+		messages[0].Content += "\n\nReply with the whole file in a single ```" + lang + " code block."
+	}
+	if len(messages) == 0 || messages[len(messages)-1].Role != llm.RoleUser {
+		messages = append(messages, llm.Message{Role: llm.RoleUser, Content: "Write the code."})
+	}
+	return messages, nil
+}
+
+// packMessages fits the conversation into budget tokens. The current step's
+// instructions, the last code that worked and the exchange being answered
+// are always kept. Older error exchanges, a rejected attempt and the
+// replies to it, go first, then whatever's oldest. Earlier steps'
+// instructions are superseded by the current step's and always dropped.
+func packMessages(rows []SeedlingMessage, budget int) []llm.Message {
+	system, accepted, last := -1, -1, -1
+	for i, m := range rows {
+		switch {
+		case m.Role == llm.RoleSystem:
+			system = i
+		case m.Role == llm.RoleAssistant:
+			last = i
+			if m.Accepted {
+				accepted = i
+			}
+		}
+	}
+	// The exchange being answered is the last attempt, unless the step
+	// hasn't had one yet.
+	if last < system {
+		last = len(rows)
+	}
+	pinned := func(i int) bool {
+		return i == system || i == accepted || i >= last
+	}
+
+	keep := make([]bool, len(rows))
+	tokens := 0
+	for i, m := range rows {
+		if m.Role == llm.RoleSystem && i != system {
+			continue
+		}
+		keep[i] = true
+		tokens += llm.EstimateTokens(m.Content)
+	}
+	drop := func(i int) {
+		keep[i] = false
+		tokens -= llm.EstimateTokens(rows[i].Content)
+	}
+	for i := 0; i < len(rows) && tokens > budget; i++ {
+		if !keep[i] || pinned(i) || rows[i].Role != llm.RoleAssistant || rows[i].Accepted {
+			continue
+		}
+		drop(i)
+		for j := i + 1; j < len(rows) && rows[j].Role == llm.RoleUser && !pinned(j); j++ {
+			drop(j)
+		}
+	}
+	for i := 0; i < len(rows) && tokens > budget; i++ {
+		if keep[i] && !pinned(i) {
+			drop(i)
+		}
+	}
+
+	messages := []llm.Message{}
+	if system != -1 {
+		messages = append(messages, llm.Message{Role: llm.RoleSystem, Content: rows[system].Content})
+	}
+	for i, m := range rows {
+		if keep[i] && i != system {
+			messages = append(messages, llm.Message{Role: m.Role, Content: m.Content})
+		}
+	}
+	return messages
+}
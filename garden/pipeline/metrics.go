@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/tensorscale/garden/garden/llm"
+)
+
+const (
+	BuildOutcomeCompleted = "completed"
+	BuildOutcomeFailed    = "failed"
+	BuildOutcomeCancelled = "cancelled"
+	// BuildOutcomeRestarted builds stopped to be built again with changes
+	// made to the seedling while they ran.
+	BuildOutcomeRestarted = "restarted"
+	// BuildOutcomeAwaitingConfig builds stopped with the seedling built,
+	// waiting for its env to be set.
+	BuildOutcomeAwaitingConfig = "awaiting_config"
+	// BuildOutcomeAwaitingHooks builds stopped with the seedling built and
+	// running, waiting for a blocking hook to succeed.
+	BuildOutcomeAwaitingHooks = "awaiting_hooks"
+	// BuildOutcomeAwaitingApproval builds stopped after a step whose artifact
+	// has to be approved before the rest is built.
+	BuildOutcomeAwaitingApproval = "awaiting_approval"
+)
+
+// All Prometheus metrics are registered here. Code that's already timed for
+// a log line or span reports the same duration through the observe
+// functions below rather than timing it again.
+var (
+	MetricsRegistry = prometheus.NewRegistry()
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "garden",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request durations by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	seedlingBuilds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "seedling_builds_total",
+		Help:      "Seedling builds by outcome.",
+	}, []string{"outcome"})
+	buildCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "garden",
+		Name:      "build_command_duration_seconds",
+		Help:      "Durations of the command verifying each step's code, e.g. docker build for SeedlingStepDockerfile.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"step", "success"})
+	duplicateOutputs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "duplicate_outputs_total",
+		Help:      "Attempts by step that wrote code an earlier attempt at the step already failed with.",
+	}, []string{"step"})
+	stepAttempts = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "garden",
+		Name:      "step_attempts",
+		Help:      "Attempts steps took to succeed, by step.",
+		Buckets:   prometheus.LinearBuckets(1, 1, 10),
+	}, []string{"step"})
+	fixPrompts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "fix_prompts_total",
+		Help:      "Prompts to fix a failed build by step and what they quote: diagnostics, the failed docker instruction or the output's tail.",
+	}, []string{"step", "kind"})
+	fixDocs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "fix_docs_total",
+		Help:      "Prompts to fix a failed server build by the docs of its imports they quote: symbols or godoc.",
+	}, []string{"source"})
+	dockerfileAttempts = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "garden",
+		Name:      "dockerfile_attempts",
+		Help:      "Attempts SeedlingStepDockerfile took to succeed, by mode: template or freeform.",
+		Buckets:   prometheus.LinearBuckets(1, 1, 10),
+	}, []string{"mode"})
+	rejectedPackages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "dockerfile_rejected_packages_total",
+		Help:      "Packages refused from templated Dockerfiles' package lists, by why: syntax, allowlist or unknown.",
+	}, []string{"reason"})
+	ReapedProcesses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "reaped_processes_total",
+		Help:      "Processes killed because the build that started them had already ended.",
+	})
+	modCacheCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "modcache_commands_total",
+		Help:      "Go build commands by step and whether every module they needed was already in the cache.",
+	}, []string{"step", "hit"})
+	modCacheDownloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "modcache_downloads_total",
+		Help:      "Modules Go build commands downloaded, by step.",
+	}, []string{"step"})
+
+	llmCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "llm_calls_total",
+		Help:      "Completion requests by model, step and whether they succeeded.",
+	}, []string{"model", "step", "success"})
+	llmCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "garden",
+		Name:      "llm_call_duration_seconds",
+		Help:      "Completion request durations by model.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"model"})
+	llmTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "garden",
+		Name:      "llm_tokens_total",
+		Help:      "Tokens by model, kind (prompt or completion) and the OpenAI key that paid for them. Streamed completions only count completion tokens.",
+	}, []string{"model", "kind", "key"})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		seedlingBuilds,
+		buildCommandDuration,
+		duplicateOutputs,
+		stepAttempts,
+		fixPrompts,
+		fixDocs,
+		dockerfileAttempts,
+		rejectedPackages,
+		ReapedProcesses,
+		modCacheCommands,
+		modCacheDownloads,
+		llmCalls,
+		llmCallDuration,
+		llmTokens,
+	)
+}
+
+func observeSeedlingBuild(outcome string) {
+	seedlingBuilds.WithLabelValues(outcome).Inc()
+}
+
+func observeBuildCommand(step string, err error, duration time.Duration) {
+	buildCommandDuration.WithLabelValues(step, strconv.FormatBool(err == nil)).Observe(duration.Seconds())
+}
+
+func observeDuplicateOutput(step string) {
+	duplicateOutputs.WithLabelValues(step).Inc()
+}
+
+func observeStepAttempts(step string, attempts int) {
+	stepAttempts.WithLabelValues(step).Observe(float64(attempts))
+}
+
+func observeDockerfileAttempts(templated bool, attempts int) {
+	mode := "freeform"
+	if templated {
+		mode = "template"
+	}
+	dockerfileAttempts.WithLabelValues(mode).Observe(float64(attempts))
+}
+
+func observeRejectedPackage(reason string) {
+	rejectedPackages.WithLabelValues(reason).Inc()
+}
+
+func observeFixPrompt(step, kind string) {
+	fixPrompts.WithLabelValues(step, kind).Inc()
+}
+
+func observeFixDocs(source string) {
+	fixDocs.WithLabelValues(source).Inc()
+}
+
+func observeModCache(step string, downloads int) {
+	modCacheCommands.WithLabelValues(step, strconv.FormatBool(downloads == 0)).Inc()
+	modCacheDownloads.WithLabelValues(step).Add(float64(downloads))
+}
+
+func observeLLMCall(model, step string, err error, duration time.Duration) {
+	llmCalls.WithLabelValues(model, step, strconv.FormatBool(err == nil)).Inc()
+	llmCallDuration.WithLabelValues(model).Observe(duration.Seconds())
+}
+
+// ObserveLLMTokens counts the tokens of the key with the Seedling.LLMKeyID.
+func ObserveLLMTokens(key string) llm.UsageFunc {
+	if key == "" {
+		key = DefaultLLMKey
+	}
+	return func(model string, prompt, completion int) {
+		llmTokens.WithLabelValues(model, "prompt", key).Add(float64(prompt))
+		llmTokens.WithLabelValues(model, "completion", key).Add(float64(completion))
+	}
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// modDownloadLine is what go prints for each module it fetches into the
+// cache, also in the output of RUN lines of docker builds.
+const modDownloadLine = "go: downloading "
+
+// modCacheStats counts how often build commands found every module they
+// needed in the cache, since garden started.
+type modCacheStats struct {
+	mu        sync.Mutex
+	commands  int64
+	hits      int64
+	downloads int64
+	since     time.Time
+}
+
+// Fill sets the counts of report.
+func (c *modCacheStats) Fill(report *ModCacheReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report.Commands, report.Hits, report.Downloads = c.commands, c.hits, c.downloads
+	if c.commands > 0 {
+		report.HitRate = float64(c.hits) / float64(c.commands)
+		since := c.since
+		report.Since = &since
+	}
+}
+
+// observe counts a build command from its output: a hit if it downloaded
+// nothing.
+func (c *modCacheStats) observe(step, output string) {
+	downloads := strings.Count(output, modDownloadLine)
+	observeModCache(step, downloads)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.since.IsZero() {
+		c.since = time.Now()
+	}
+	c.commands++
+	if downloads == 0 {
+		c.hits++
+	}
+	c.downloads += int64(downloads)
+}
+
+// ModCacheReport is the size of the shared module cache and how well it's
+// been doing.
+type ModCacheReport struct {
+	Dir   string `json:"dir"`
+	Bytes int64  `json:"bytes"`
+	// Modules are the module versions downloaded into the cache.
+	Modules   int        `json:"modules"`
+	Commands  int64      `json:"commands"`
+	Hits      int64      `json:"hits"`
+	HitRate   float64    `json:"hitRate"`
+	Downloads int64      `json:"downloads"`
+	Since     *time.Time `json:"since,omitempty"`
+}
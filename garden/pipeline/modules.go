@@ -0,0 +1,263 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+	"golang.org/x/mod/semver"
+)
+
+// ModuleReportTimeout is how long go list and govulncheck may take for a
+// seedling's module report.
+const ModuleReportTimeout = 2 * time.Minute
+
+// ModuleDep is a module a seedling's build requires, directly or not, as
+// go list -m reports it.
+type ModuleDep struct {
+	Path     string `json:"path"`
+	Version  string `json:"version"`
+	Indirect bool   `json:"indirect,omitempty"`
+	// Replace is the path@version the module is replaced with, if it is.
+	Replace string `json:"replace,omitempty"`
+	// Advisory is why DEPENDENCY_ADVISORIES flags the module.
+	Advisory string `json:"advisory,omitempty"`
+}
+
+// VulnFinding is a vulnerability govulncheck found in one of the
+// seedling's modules. Called is set if the seedling's code reaches the
+// vulnerable function, not just the module.
+type VulnFinding struct {
+	ID           string `json:"id"`
+	Summary      string `json:"summary,omitempty"`
+	Module       string `json:"module"`
+	Version      string `json:"version"`
+	FixedVersion string `json:"fixedVersion,omitempty"`
+	Called       bool   `json:"called"`
+}
+
+// ModuleReport is every module a revision of a seedling was built with.
+type ModuleReport struct {
+	SeedlingID  hide.Int64    `db:"seedling_id" json:"-"`
+	Revision    int           `db:"revision" json:"revision"`
+	ModulesJSON string        `db:"modules" json:"-"`
+	Modules     []ModuleDep   `db:"-" json:"modules"`
+	VulnsJSON   string        `db:"vulns" json:"-"`
+	Vulns       []VulnFinding `db:"-" json:"vulns,omitempty"`
+	// VulncheckError is why govulncheck couldn't check the revision.
+	VulncheckError string    `db:"vulncheck_error" json:"vulncheckError,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+	// Flagged counts the modules with an advisory.
+	Flagged int `db:"-" json:"flagged"`
+}
+
+func (r *ModuleReport) Decode() error {
+	if err := json.Unmarshal([]byte(r.ModulesJSON), &r.Modules); err != nil {
+		return err
+	}
+	if r.VulnsJSON != "" {
+		if err := json.Unmarshal([]byte(r.VulnsJSON), &r.Vulns); err != nil {
+			return err
+		}
+	}
+	for _, m := range r.Modules {
+		if m.Advisory != "" {
+			r.Flagged++
+		}
+	}
+	return nil
+}
+
+// moduleAdvisory returns the advisory DEPENDENCY_ADVISORIES has for the
+// module at version. Advisories are keyed by module path, path@version or
+// path@<version for the versions before it.
+func moduleAdvisory(advisories map[string]string, path, version string) string {
+	if a, ok := advisories[path+"@"+version]; ok {
+		return a
+	}
+	for key, a := range advisories {
+		p, v, ok := strings.Cut(key, "@<")
+		if ok && p == path && semver.IsValid(v) && semver.Compare(version, v) < 0 {
+			return a
+		}
+	}
+	return advisories[path]
+}
+
+// listModules runs go list -m all in the seedling's repo.
+func (s *Pipeline) listModules(ctx context.Context, seedling store.Seedling) ([]ModuleDep, error) {
+	cmd, err := s.Runner.Command(ctx, BuildSpec{
+		Dir:       s.RepoDir(seedling),
+		Name:      "go",
+		Args:      []string{"list", "-m", "-json", "all"},
+		Toolchain: seedling.Toolchain,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -m all: %w", err)
+	}
+	modules := []ModuleDep{}
+	dec := json.NewDecoder(strings.NewReader(string(out)))
+	for {
+		var m struct {
+			Path     string
+			Version  string
+			Main     bool
+			Indirect bool
+			Replace  *struct {
+				Path    string
+				Version string
+			}
+		}
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if m.Main {
+			continue
+		}
+		dep := ModuleDep{Path: m.Path, Version: m.Version, Indirect: m.Indirect,
+			Advisory: moduleAdvisory(s.Config.DependencyAdvisories, m.Path, m.Version)}
+		if m.Replace != nil {
+			dep.Replace = m.Replace.Path
+			if m.Replace.Version != "" {
+				dep.Replace += "@" + m.Replace.Version
+			}
+		}
+		modules = append(modules, dep)
+	}
+	return modules, nil
+}
+
+// vulncheck runs govulncheck on the seedling's repo. Like the build's
+// tools it's looked for on TOOLS_BIN and the PATH, and runs on the host
+// with the module cache builds share.
+func (s *Pipeline) vulncheck(ctx context.Context, seedling store.Seedling) ([]VulnFinding, error) {
+	path, err := s.lookTool("govulncheck")
+	if err != nil {
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, path, "-json", "./...")
+	cmd.Dir = s.RepoDir(seedling)
+	cmd.Env = s.BuildEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("govulncheck: %s", strings.TrimSpace(ErrorTail(string(exitErr.Stderr), 5)))
+		}
+		return nil, err
+	}
+
+	summaries := map[string]string{}
+	found := map[string]*VulnFinding{}
+	order := []string{}
+	dec := json.NewDecoder(strings.NewReader(string(out)))
+	for {
+		var msg struct {
+			OSV *struct {
+				ID      string `json:"id"`
+				Summary string `json:"summary"`
+			} `json:"osv"`
+			Finding *struct {
+				OSV          string `json:"osv"`
+				FixedVersion string `json:"fixed_version"`
+				Trace        []struct {
+					Module   string `json:"module"`
+					Version  string `json:"version"`
+					Function string `json:"function"`
+				} `json:"trace"`
+			} `json:"finding"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading govulncheck output: %w", err)
+		}
+		if msg.OSV != nil {
+			summaries[msg.OSV.ID] = msg.OSV.Summary
+		}
+		if f := msg.Finding; f != nil && len(f.Trace) > 0 {
+			key := f.OSV + " " + f.Trace[0].Module
+			v, ok := found[key]
+			if !ok {
+				v = &VulnFinding{ID: f.OSV, Module: f.Trace[0].Module, Version: f.Trace[0].Version, FixedVersion: f.FixedVersion}
+				found[key] = v
+				order = append(order, key)
+			}
+			// Findings are reported at module, package and then function
+			// level as far as the code reaches.
+			v.Called = v.Called || f.Trace[0].Function != ""
+		}
+	}
+	findings := []VulnFinding{}
+	for _, key := range order {
+		v := *found[key]
+		v.Summary = summaries[v.ID]
+		findings = append(findings, v)
+	}
+	return findings, nil
+}
+
+// RecordModuleReport stores the modules the seedling's revision was just
+// built with, and what govulncheck finds in them, replacing any report the
+// revision had. Failures are logged, they don't fail the build.
+func (s *Pipeline) RecordModuleReport(ctx context.Context, seedling store.Seedling) {
+	ctx, cancel := context.WithTimeout(ctx, ModuleReportTimeout)
+	defer cancel()
+	log := logrus.WithField("name", seedling.Name).WithField("revision", seedling.Revision)
+	modules, err := s.listModules(ctx, seedling)
+	if err != nil {
+		log.WithField("error", err).Warn("failed to list seedling modules")
+		return
+	}
+	report := ModuleReport{SeedlingID: seedling.ID, Revision: seedling.Revision, CreatedAt: time.Now()}
+	b, err := json.Marshal(modules)
+	if err != nil {
+		log.WithField("error", err).Error("failed to encode seedling modules")
+		return
+	}
+	report.ModulesJSON = string(b)
+	if s.Config.Govulncheck {
+		vulns, err := s.vulncheck(ctx, seedling)
+		if err != nil {
+			log.WithField("error", err).Warn("failed to check seedling modules for vulnerabilities")
+			report.VulncheckError = err.Error()
+		} else if vulns != nil {
+			b, err := json.Marshal(vulns)
+			if err != nil {
+				log.WithField("error", err).Error("failed to encode vulnerabilities")
+				return
+			}
+			report.VulnsJSON = string(b)
+		}
+	}
+	if _, err := s.DB.NamedExecContext(ctx, `
+	 INSERT INTO seedling_module_reports (seedling_id, revision, modules, vulns, vulncheck_error, created_at)
+	 VALUES (:seedling_id, :revision, :modules, :vulns, :vulncheck_error, :created_at)
+	 ON CONFLICT (seedling_id, revision) DO UPDATE SET
+	   modules = excluded.modules, vulns = excluded.vulns, vulncheck_error = excluded.vulncheck_error, created_at = excluded.created_at
+	 `, &report); err != nil {
+		log.WithField("error", err).Error("failed to store seedling module report")
+	}
+}
+
+// Resolved is the module's version, and what it's replaced with if it is.
+func (m ModuleDep) Resolved() string {
+	if m.Replace == "" {
+		return m.Version
+	}
+	return m.Version + " => " + m.Replace
+}
@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const openAPIPrompt = `%s
+Now write an OpenAPI 3.0 spec in YAML for the HTTP server on port %d.
+
+Here are some instructions:
+
+1. Describe every endpoint the HTTP server handles, including /healthz and
+   /schema, with its method, request body and response.
+2. Use exactly the paths the server registers, e.g. "/healthz", not
+   "/api/healthz".
+3. Describe request and response bodies with JSON schemas matching the JSON
+   the server takes and returns. Use multipart/form-data for endpoints that
+   receive files.
+4. Set servers to [{"url": "http://localhost:%d"}].
+
+Remember, the server code is:
+` + "```go\n%s```" + `
+
+Now write the spec. Write only the spec.
+`
+
+// openAPIHook validates a generated OpenAPI spec and checks that each of
+// its paths is one the server serves. It doesn't parse the routing, a path
+// only has to appear as a string literal in the server's code, but that's
+// enough to catch a spec describing some other service.
+func openAPIHook(ctx context.Context, target HookTarget) (string, error) {
+	data, err := ioutil.ReadFile(target.File)
+	if err != nil {
+		return "", err
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid spec: %w", err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		return "", fmt.Errorf("invalid spec: %w", err)
+	}
+	if len(doc.Paths) == 0 {
+		return "", errors.New("spec has no paths")
+	}
+
+	files, err := serverFilePaths(target.Dir)
+	if err != nil {
+		return "", err
+	}
+	literals, err := stringLiterals(files...)
+	if err != nil {
+		return "", err
+	}
+	missing := []string{}
+	for path := range doc.Paths {
+		if !servesPath(literals, path) {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("paths in the spec that the server doesn't serve: %s", strings.Join(missing, ", "))
+	}
+	return "", nil
+}
+
+// stringLiterals returns the values of the string literals in Go files.
+func stringLiterals(files ...string) (map[string]bool, error) {
+	literals := map[string]bool{}
+	for _, file := range files {
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				if value, err := strconv.Unquote(lit.Value); err == nil {
+					literals[value] = true
+				}
+			}
+			return true
+		})
+	}
+	return literals, nil
+}
+
+// servesPath reports whether path appears in literals. Servers match
+// templated paths like /items/{id} by prefix, so only the part before the
+// first parameter has to appear in a literal.
+func servesPath(literals map[string]bool, path string) bool {
+	if literals[path] {
+		return true
+	}
+	i := strings.IndexByte(path, '{')
+	if i == -1 {
+		return false
+	}
+	prefix := path[:i]
+	for literal := range literals {
+		if strings.HasPrefix(literal, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/cipher"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/dockerx"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// Pipeline builds seedlings: it runs their steps with an LLM and a
+// BuildRunner, and keeps their attempts in its Store.
+type Pipeline struct {
+	*store.Store
+	Config        Config
+	Scheduler     *Scheduler
+	Settings      *Settings
+	LLM           llm.LLM
+	BuildRegistry *BuildRegistry
+	Events        *EventBroker
+	Runner        BuildRunner
+	Markers       *Markers
+	// Docker runs seedling containers, see CONTAINER_RUNTIME.
+	Docker dockerx.ContainerRuntime
+
+	// LLMs are the providers of every key seedlings are built with,
+	// including the pipeline's LLM.
+	LLMs *llmProviders
+
+	WebhookClient *http.Client
+
+	envMu sync.Mutex
+	env   *EnvReport
+
+	ImportPolicyMu sync.RWMutex
+	ImportPolicy   *compiledImportPolicy
+
+	// RedactPatterns are what transcripts are redacted with besides known
+	// secret values.
+	RedactPatterns []*regexp.Regexp
+	// PackageAllow is DOCKERFILE_PACKAGE_ALLOW, nil if it isn't set.
+	PackageAllow *regexp.Regexp
+
+	// LLMKeyAEAD encrypts keys brought in X-OpenAI-Key. It's nil when
+	// LLM_KEY_SECRET isn't set, and the header is refused.
+	LLMKeyAEAD cipher.AEAD
+
+	symbols symbolCache
+
+	ModCacheStats modCacheStats
+}
+
+// Deps are what a Pipeline is made of besides its Config. Docker and Runner
+// are made from the Config when they're nil.
+type Deps struct {
+	DB     *sqlx.DB
+	Config Config
+	LLM    llm.LLM
+	Docker dockerx.ContainerRuntime
+	Runner BuildRunner
+}
+
+// New is a Pipeline of deps. It fails if the Config is invalid.
+func New(deps Deps) (*Pipeline, error) {
+	config := deps.Config
+	s := &Pipeline{
+		Store:         store.New(deps.DB),
+		Config:        config,
+		LLM:           deps.LLM,
+		BuildRegistry: NewBuildRegistry(deps.DB),
+		Events:        NewEventBroker(),
+		Markers:       NewMarkers(config),
+		Docker:        deps.Docker,
+		Runner:        deps.Runner,
+		WebhookClient: &http.Client{
+			Transport: http.DefaultClient.Transport,
+			Timeout:   WebhookTimeout,
+		},
+	}
+	if config.LLMProvider == LLMProviderRecord {
+		s.LLM = llm.NewRecorder(deps.LLM, config.FixturesDir, s.RedactFixture)
+	}
+	s.LLMs = NewLLMProviders(s.LLM, s.KeyLLM)
+	var err error
+	if s.Docker == nil {
+		if s.Docker, err = NewContainerRuntime(config.ContainerRuntime); err != nil {
+			return nil, fmt.Errorf("invalid CONTAINER_RUNTIME: %w", err)
+		}
+	}
+	if s.Runner == nil {
+		s.Runner = s.NewBuildRunner()
+	}
+	if s.ImportPolicy, err = CompileImportPolicy(ImportPolicyFromConfig(config)); err != nil {
+		return nil, fmt.Errorf("invalid import policy: %w", err)
+	}
+	if reason := s.CheckGoToolchain(config.GoToolchain); reason != "" {
+		return nil, fmt.Errorf("invalid GO_TOOLCHAIN: %s", reason)
+	}
+	if err := CheckPromoteChecklist(config.PromoteChecklist); err != nil {
+		return nil, fmt.Errorf("invalid PROMOTE_CHECKLIST: %w", err)
+	}
+	if s.RedactPatterns, err = CompileRedactPatterns(config.RedactPatterns); err != nil {
+		return nil, fmt.Errorf("invalid redact pattern: %w", err)
+	}
+	if config.DockerfilePackageAllow != "" {
+		if s.PackageAllow, err = regexp.Compile("^(?:" + config.DockerfilePackageAllow + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid DOCKERFILE_PACKAGE_ALLOW: %w", err)
+		}
+	}
+	if config.LLMKeySecret != "" {
+		if s.LLMKeyAEAD, err = NewLLMKeyAEAD(config.LLMKeySecret); err != nil {
+			return nil, fmt.Errorf("invalid LLM_KEY_SECRET: %w", err)
+		}
+	}
+	s.Settings = NewSettings(DefaultPipelineSettings(config))
+	if err := s.LoadSettings(context.Background()); err != nil {
+		logrus.WithField("error", err).Error("failed to load settings")
+	}
+	s.Scheduler = NewScheduler(s.Settings.Current().BuildWorkers, s.Build)
+	s.Settings.OnChange(func(settings PipelineSettings) {
+		s.Scheduler.Resize(settings.BuildWorkers)
+	})
+	return s, nil
+}
+
+// Run builds seedling with a Pipeline of deps from its current step for as
+// far as it gets, and returns it as it's left.
+func Run(ctx context.Context, deps Deps, seedling store.Seedling) (store.Seedling, error) {
+	p, err := New(deps)
+	if err != nil {
+		return seedling, err
+	}
+	p.Build(ctx, seedling)
+	var built store.Seedling
+	if err := p.DB.GetContext(ctx, &built, "SELECT * FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+		return seedling, err
+	}
+	return built, nil
+}
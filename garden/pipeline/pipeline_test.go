@@ -0,0 +1,602 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/pipelinetest"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+func TestRun(t *testing.T) {
+	env := pipelinetest.New(t)
+	seedling := env.Seedling(t, "echo")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, env.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+
+	if n := env.Runner.Ran("protoc"); n != 1 {
+		t.Errorf("protoc ran %d times, want 1", n)
+	}
+	if n := env.Runner.Ran("sh"); n != 1 {
+		t.Errorf("the server was built %d times, want 1", n)
+	}
+	runs := env.Docker.Runs()
+	if len(runs) != 1 {
+		t.Fatalf("%d containers run, want 1", len(runs))
+	}
+	if got, want := runs[0].Image, env.Deps.Config.ContainerPrefix+"echo"; got != want {
+		t.Errorf("container run from %q, want %q", got, want)
+	}
+	if prompts := env.LLM.Prompts(); len(prompts) == 0 || !strings.Contains(prompts[0], seedling.Description) {
+		t.Errorf("first prompt doesn't describe the seedling: %q", prompts)
+	}
+
+	var attempts []store.Attempt
+	if err := env.Deps.DB.SelectContext(ctx, &attempts,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 ORDER BY id", seedling.ID); err != nil {
+		t.Fatal(err)
+	}
+	steps := map[string]bool{}
+	for _, a := range attempts {
+		if !a.Success {
+			t.Errorf("attempt %d at %s failed: %s", a.Attempt, a.Step, a.Output)
+		}
+		steps[a.Step] = true
+	}
+	for _, step := range pipeline.SeedlingSteps(seedling) {
+		if step != pipeline.SeedlingStepComplete && !steps[step] {
+			t.Errorf("no attempt at %s", step)
+		}
+	}
+	main, err := ioutil.ReadFile(filepath.Join(env.Pipeline(t).RepoDir(built), "server", "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(main), `"/healthz"`) {
+		t.Errorf("server/main.go isn't the generated server:\n%s", main)
+	}
+}
+
+func TestRunNudgesForCode(t *testing.T) {
+	env := pipelinetest.New(t)
+	refused := false
+	env.LLM.Reply = func(prompt string) (string, bool) {
+		switch {
+		case strings.HasSuffix(prompt, "Output only the code, with no explanation.\n"):
+			return pipelinetest.Replies["go"], true
+		case strings.HasSuffix(prompt, "```go\n") && !refused:
+			refused = true
+			return "I'm not sure which port the server should listen on.", true
+		}
+		return "", false
+	}
+	seedling := env.Seedling(t, "echo")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, env.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+	if !refused {
+		t.Fatal("the server was never asked for")
+	}
+	// The reply without code is reprompted for rather than built, so the
+	// server only fails the attempt it was sent at.
+	var attempts []store.Attempt
+	if err := env.Deps.DB.SelectContext(ctx, &attempts,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 AND step = $2 ORDER BY id",
+		seedling.ID, pipeline.SeedlingStepServer); err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[0].Success || !attempts[1].Success {
+		t.Fatalf("server attempts %+v, want the nudged one and a success", attempts)
+	}
+	if !strings.Contains(attempts[0].Output, "no code") {
+		t.Errorf("the nudged attempt failed with %q", attempts[0].Output)
+	}
+}
+
+func TestRunRepromptsQualityCheck(t *testing.T) {
+	env := pipelinetest.New(t)
+	const invalid = "The code looks complete.\n{\"quality\": \"fine\"}"
+	env.LLM.Reply = func(prompt string) (string, bool) {
+		if strings.Contains(prompt, "In the above code") && !strings.Contains(prompt, "wasn't a valid quality check") {
+			return invalid, true
+		}
+		return "", false
+	}
+	seedling := env.Seedling(t, "echo")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, env.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+
+	checks := []string{}
+	for _, prompt := range env.LLM.Prompts() {
+		if strings.Contains(prompt, "In the above code") {
+			checks = append(checks, prompt)
+		}
+	}
+	if len(checks) != 2 {
+		t.Fatalf("%d quality check prompts, want the first and one reprompt", len(checks))
+	}
+	if strings.ContainsRune(checks[0], '\b') {
+		t.Error("the quality check prompt has a backspace")
+	}
+	reprompt := strings.TrimPrefix(checks[1], checks[0])
+	if reprompt == checks[1] || !strings.HasPrefix(reprompt, invalid) ||
+		!strings.Contains(reprompt, `"quality" must be`) || !strings.Contains(reprompt, "exactly one JSON object") {
+		t.Errorf("the reprompt doesn't follow up on the invalid check: %q", reprompt)
+	}
+}
+
+func TestRunResumesFromCheckpoint(t *testing.T) {
+	env := pipelinetest.New(t)
+	seedling := env.Seedling(t, "echo")
+
+	// The first build writes the server wrong twice and is killed while
+	// it's asked for the third.
+	ctx, kill := context.WithCancel(context.Background())
+	defer kill()
+	servers := 0
+	env.LLM.Before = func(ctx context.Context, prompt string) error {
+		if strings.HasSuffix(prompt, "```go\n") {
+			if servers++; servers == 3 {
+				kill()
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	env.LLM.Reply = func(prompt string) (string, bool) {
+		if strings.HasSuffix(prompt, "```go\n") {
+			return fmt.Sprintf("package main\n\n// attempt %d\nfunc main() {\n```\n", servers), true
+		}
+		return "", false
+	}
+	pipeline.Run(ctx, env.Deps, seedling)
+	prompts := env.LLM.Prompts()
+	interrupted := prompts[len(prompts)-1]
+	completed := map[string]bool{}
+	for _, prompt := range prompts[:len(prompts)-1] {
+		completed[prompt] = true
+	}
+	var killed store.Seedling
+	if err := env.Deps.DB.Get(&killed, "SELECT * FROM seedlings WHERE id = $1", seedling.ID); err != nil {
+		t.Fatal(err)
+	}
+	if killed.Step != pipeline.SeedlingStepServer {
+		t.Fatalf("killed at %s, want %s", killed.Step, pipeline.SeedlingStepServer)
+	}
+	if !strings.Contains(interrupted, "// attempt 1") || !strings.Contains(interrupted, "// attempt 2") {
+		t.Fatalf("the interrupted prompt doesn't have the failed attempts: %q", interrupted)
+	}
+
+	// The resumed build's model gets the server right.
+	resumed := &pipelinetest.LLM{}
+	deps := env.Deps
+	deps.LLM = resumed
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, deps, killed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+	// The resumed build carries on with the conversation the killed one
+	// was having, rather than starting the server or the build over.
+	again := resumed.Prompts()
+	if len(again) == 0 || again[0] != interrupted {
+		t.Errorf("the resumed build didn't start with the interrupted prompt")
+	}
+	for _, prompt := range again {
+		if completed[prompt] {
+			t.Errorf("prompt repeated after the resume: %q", prompt)
+		}
+	}
+	if n := env.Runner.Ran("protoc"); n != 1 {
+		t.Errorf("protoc ran %d times, want 1", n)
+	}
+	var attempt int
+	if err := env.Deps.DB.GetContext(ctx, &attempt,
+		"SELECT attempt FROM seedling_attempts WHERE seedling_id = $1 AND step = $2 AND success",
+		seedling.ID, pipeline.SeedlingStepServer); err != nil {
+		t.Fatal(err)
+	}
+	if attempt != 3 {
+		t.Errorf("the server was written at attempt %d, want 3", attempt)
+	}
+}
+
+// TestRunFromFixtures records a build's completions and replays them to
+// build the seedling again, with no model to ask.
+func TestRunFromFixtures(t *testing.T) {
+	const key = "gk-0123456789abcdef"
+	dir := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// The README the model writes quotes one of garden's keys.
+	recording := pipelinetest.New(t)
+	recording.Deps.Config.APIKeys = map[string]string{"ci": key}
+	recording.LLM.Reply = func(prompt string) (string, bool) {
+		for lang := range pipelinetest.Replies {
+			if strings.HasSuffix(prompt, "```"+lang+"\n") {
+				return "", false
+			}
+		}
+		return "An echo service. Call it with the key " + key + ".", true
+	}
+	var redact func(name, text string) string
+	recording.Deps.LLM = llm.NewRecorder(recording.LLM, dir, func(name, text string) string {
+		return redact(name, text)
+	})
+	redact = recording.Pipeline(t).RedactFixture
+	seedling := recording.Seedling(t, "echo")
+	recorded, err := pipeline.Run(ctx, recording.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("recorded seedling stopped at %s: %s", recorded.Step, recorded.FailureReason)
+	}
+	for _, step := range []string{pipeline.SeedlingStepProtobufs, pipeline.SeedlingStepServer, pipeline.SeedlingStepDockerfile} {
+		if _, err := os.Stat(llm.FixturePath(dir, "echo", step, 1)); err != nil {
+			t.Errorf("no fixture for %s: %v", step, err)
+		}
+	}
+	redacted := 0
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if strings.Contains(string(data), key) {
+			t.Errorf("%s has the key:\n%s", path, data)
+		}
+		if strings.Contains(string(data), "[REDACTED:API_KEYS]") {
+			redacted++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redacted == 0 {
+		t.Error("no fixture has the key redacted")
+	}
+
+	replaying := pipelinetest.New(t)
+	replaying.Deps.Config.LLMProvider = pipeline.LLMProviderFixture
+	replaying.Deps.LLM = llm.NewFixtures(dir)
+	seedling = replaying.Seedling(t, "echo")
+	replayed, err := pipeline.Run(ctx, replaying.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("replayed seedling stopped at %s: %s", replayed.Step, replayed.FailureReason)
+	}
+	for _, file := range []string{filepath.Join("protobufs", "echo.proto"), filepath.Join("server", "main.go"), "Dockerfile"} {
+		want, err := ioutil.ReadFile(filepath.Join(recording.Pipeline(t).RepoDir(recorded), file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(replaying.Pipeline(t).RepoDir(replayed), file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The provenance header has when each was generated, to the
+		// second.
+		if withoutProvenance(got) != withoutProvenance(want) {
+			t.Errorf("replayed %s:\n%s\nwant:\n%s", file, got, want)
+		}
+	}
+	if n := replaying.Runner.Ran("protoc"); n != 1 {
+		t.Errorf("protoc ran %d times replaying, want 1", n)
+	}
+
+	// A build the fixtures weren't recorded for fails naming the fixture.
+	other := replaying.Seedling(t, "other")
+	failed, err := pipeline.Run(ctx, replaying.Deps, other)
+	want := llm.FixturePath(dir, "other", pipeline.SeedlingStepProtobufs, 1)
+	if reason := fmt.Sprint(err) + failed.FailureReason; !strings.Contains(reason, want) {
+		t.Errorf("the build without fixtures failed with %q, not naming %s", reason, want)
+	}
+}
+
+// withoutProvenance is code without its provenance header line.
+func withoutProvenance(code []byte) string {
+	lines := strings.SplitAfter(string(code), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.Contains(line, "garden-provenance: ") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// failingRunner is a pipelinetest.Runner whose first server build prints
+// so much output it crowds out its first error, and fails.
+type failingRunner struct {
+	*pipelinetest.Runner
+	output string
+	failed bool
+}
+
+func (r *failingRunner) Command(ctx context.Context, spec pipeline.BuildSpec) (*exec.Cmd, error) {
+	cmd, err := r.Runner.Command(ctx, spec)
+	if err != nil || spec.Name != "sh" || r.failed {
+		return cmd, err
+	}
+	r.failed = true
+	failing := exec.CommandContext(ctx, "sh", "-c", `cat; exit 1`)
+	failing.Dir = cmd.Dir
+	failing.Stdin = strings.NewReader(r.output)
+	return failing, nil
+}
+
+func TestRunCapsBuildOutput(t *testing.T) {
+	const maxBytes = 64 << 10
+	const firstErr = "./main.go:9:2: error: undefined: sayHandler"
+	var b strings.Builder
+	b.WriteString("# echo/server\n" + firstErr + "\n")
+	for i := 0; b.Len() < 4<<20; i++ {
+		fmt.Fprintf(&b, "./main.go:%d:2: note: while checking fmt.Println\n", 10+i)
+	}
+	b.WriteString("go: build failed\n")
+
+	env := pipelinetest.New(t)
+	runner := &failingRunner{Runner: env.Runner, output: b.String()}
+	env.Deps.Runner = runner
+	env.Deps.Config.BuildOutputMaxBytes = maxBytes
+	seedling := env.Seedling(t, "echo")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, env.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+
+	var output string
+	if err := env.Deps.DB.GetContext(ctx, &output,
+		"SELECT output FROM seedling_attempts WHERE seedling_id = $1 AND step = $2 AND NOT success",
+		seedling.ID, pipeline.SeedlingStepServer); err != nil {
+		t.Fatal(err)
+	}
+	if len(output) > maxBytes+len(firstErr)+100 {
+		t.Errorf("the failed attempt kept %d bytes, with a cap of %d", len(output), maxBytes)
+	}
+	if !strings.HasPrefix(output, firstErr+"\n") || !strings.HasSuffix(output, "go: build failed\n") {
+		t.Errorf("the failed attempt's output lost its first error or its end")
+	}
+
+	// The fix prompt quotes the first error along with the tail.
+	var fix string
+	for _, prompt := range env.LLM.Prompts() {
+		if strings.Contains(prompt, "go: build failed") {
+			fix = prompt
+			break
+		}
+	}
+	if !strings.Contains(fix, firstErr+"\n...\n") {
+		t.Errorf("the fix prompt doesn't quote the first error: %q", fix)
+	}
+	if strings.Count(fix, "note: while checking") > 100 {
+		t.Error("the fix prompt quotes all of the output")
+	}
+}
+
+// lingeringRunner is a pipelinetest.Runner whose protoc leaves a process
+// running in the background once it's done, as docker buildx can.
+type lingeringRunner struct {
+	*pipelinetest.Runner
+}
+
+func (r lingeringRunner) Command(ctx context.Context, spec pipeline.BuildSpec) (*exec.Cmd, error) {
+	cmd, err := r.Runner.Command(ctx, spec)
+	if err != nil || spec.Name != "protoc" {
+		return cmd, err
+	}
+	lingering := exec.CommandContext(ctx, "sh", "-c", "sleep 602 > /dev/null 2>&1 &")
+	lingering.Dir = cmd.Dir
+	return lingering, nil
+}
+
+// lingering is how many processes protoc of lingeringRunner left running.
+func lingering(t *testing.T) int {
+	t.Helper()
+	out, err := exec.Command("ps", "-eo", "stat=,args=").Output()
+	if err != nil {
+		t.Skipf("can't list processes: %v", err)
+	}
+	n := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && !strings.HasPrefix(fields[0], "Z") && strings.Contains(line, "sleep 602") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRunKillsWhatBuildsLeaveBehind(t *testing.T) {
+	env := pipelinetest.New(t)
+	env.Deps.Runner = lingeringRunner{env.Runner}
+	// The build bails out at the server, with protoc's process running.
+	sleeping := 0
+	env.LLM.Before = func(ctx context.Context, prompt string) error {
+		if strings.HasSuffix(prompt, "```go\n") {
+			sleeping = lingering(t)
+			return errors.New("provider unavailable")
+		}
+		return nil
+	}
+	seedling := env.Seedling(t, "echo")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, env.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(built.FailureReason, "provider unavailable") {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+	if sleeping != 1 {
+		t.Fatalf("%d processes left behind by protoc while the build ran, want 1", sleeping)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for lingering(t) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("protoc's process is still running after the build")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunRefusesUnsafeWrites(t *testing.T) {
+	env := pipelinetest.New(t)
+	seedling := env.Seedling(t, "echo")
+	server := filepath.Join(env.Pipeline(t).RepoDir(seedling), "server")
+	outside := t.TempDir()
+	// The first time the server's asked for, its directory is swapped for a
+	// symlink out of the repo, and it's put back the next.
+	asked := 0
+	env.LLM.Before = func(ctx context.Context, prompt string) error {
+		if !strings.HasSuffix(prompt, "```go\n") {
+			return nil
+		}
+		asked++
+		switch asked {
+		case 1:
+			if err := os.RemoveAll(server); err != nil {
+				return err
+			}
+			return os.Symlink(outside, server)
+		case 2:
+			if err := os.Remove(server); err != nil {
+				return err
+			}
+			return os.Mkdir(server, 0755)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, env.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+	if asked < 2 {
+		t.Fatalf("the server was asked for %d times", asked)
+	}
+
+	var attempts []store.Attempt
+	if err := env.Deps.DB.SelectContext(ctx, &attempts,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 AND step = $2 ORDER BY id",
+		seedling.ID, pipeline.SeedlingStepServer); err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) < 2 || attempts[0].Success || !attempts[len(attempts)-1].Success {
+		t.Fatalf("server attempts %+v, want the refused one and then a success", attempts)
+	}
+	if want := "refusing to write server/main.go: server is a symlink out of the repo"; !strings.Contains(attempts[0].Output, want) {
+		t.Errorf("the refused attempt failed with %q, want %q", attempts[0].Output, want)
+	}
+	files, err := ioutil.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Errorf("%s was written out of the repo", f.Name())
+	}
+}
+
+func TestRunFixesExampleCallSyntax(t *testing.T) {
+	broken, err := ioutil.ReadFile(filepath.Join("testdata", "examplecall", "unclosed-if.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := pipelinetest.New(t)
+	var fix string
+	env.LLM.Reply = func(prompt string) (string, bool) {
+		switch {
+		case strings.HasSuffix(prompt, "```bash\n") && fix == "":
+			return string(broken) + "```", true
+		case strings.HasSuffix(prompt, "Write a version that fixes that error.\n") && strings.Contains(prompt, pipeline.ExampleCallFile):
+			fix = prompt
+			return "```bash\n" + pipelinetest.Replies["bash"], true
+		}
+		return "", false
+	}
+	seedling := env.Seedling(t, "echo")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	built, err := pipeline.Run(ctx, env.Deps, seedling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Step != pipeline.SeedlingStepComplete {
+		t.Fatalf("seedling stopped at %s: %s", built.Step, built.FailureReason)
+	}
+
+	var attempts []store.Attempt
+	if err := env.Deps.DB.SelectContext(ctx, &attempts,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 AND step = $2 ORDER BY id",
+		seedling.ID, pipeline.SeedlingStepExampleClientCall); err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[0].Success || !attempts[1].Success {
+		t.Fatalf("example call attempts %+v, want the broken one and a fix", attempts)
+	}
+	if !strings.Contains(attempts[0].Output, pipeline.ExampleCallFile) || !strings.Contains(attempts[0].Output, "syntax error") {
+		t.Errorf("the broken script failed with %q", attempts[0].Output)
+	}
+	// The fix is asked for with the error, like any other build's.
+	if !strings.Contains(fix, "syntax error") {
+		t.Errorf("the fix wasn't asked for with the error: %q", fix)
+	}
+	script, err := ioutil.ReadFile(filepath.Join(env.Pipeline(t).RepoDir(built), pipeline.ExampleCallFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), "${GARDEN_HTTP_PORT:?") {
+		t.Errorf("the stored script isn't the fix:\n%s", script)
+	}
+}
@@ -0,0 +1,18 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// PublishedPorts returns the host ports the seedling's container publishes
+// its gRPC and HTTP servers on, 0 for one that isn't published.
+func (s *Pipeline) PublishedPorts(ctx context.Context, seedling store.Seedling) (int, int, error) {
+	published, err := s.Docker.Ports(ctx, s.ContainerName(seedling))
+	if err != nil {
+		return 0, 0, err
+	}
+	ports := seedling.SeedlingPorts.WithDefaults()
+	return published[ports.GRPCContainerPort], published[ports.HTTPContainerPort], nil
+}
@@ -0,0 +1,159 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/imports"
+)
+
+// PreBuildHook runs on a generated file after it's written and before the
+// step's build command, which is much slower. A hook may rewrite the file,
+// in which case it describes what it fixed so the model can be told. An
+// error means the code isn't worth building.
+type PreBuildHook struct {
+	Name string
+	Run  func(ctx context.Context, target HookTarget) (fixed string, err error)
+}
+
+// HookTarget is the file a hook runs on, the repo it's in, and the runner
+// and Go version commands run on the generated code are run with.
+type HookTarget struct {
+	Dir       string
+	File      string
+	Runner    BuildRunner
+	Toolchain string
+}
+
+var (
+	// preBuildHooks are run in order for each language.
+	preBuildHooks = map[string][]PreBuildHook{
+		"go": {
+			{Name: "goimports", Run: goimportsHook},
+			{Name: "go vet", Run: goVetHook},
+		},
+		"yaml": {
+			{Name: "openapi", Run: openAPIHook},
+		},
+		"bash": {
+			{Name: "port", Run: exampleCallPortHook},
+		},
+	}
+)
+
+// runPreBuildHooks runs the hooks for lang, stopping at the first failure.
+// It returns what the hooks fixed.
+func runPreBuildHooks(ctx context.Context, lang string, target HookTarget) ([]string, error) {
+	fixes := []string{}
+	for _, hook := range preBuildHooks[lang] {
+		fixed, err := hook.Run(ctx, target)
+		if err != nil {
+			return fixes, fmt.Errorf("%s: %w", hook.Name, err)
+		}
+		if fixed != "" {
+			fixes = append(fixes, hook.Name+" "+fixed)
+		}
+	}
+	return fixes, nil
+}
+
+// goimportsHook adds missing imports and removes unused ones. Imports are
+// resolved from the standard library and the module cache; the goimports
+// run in the build command settles the rest once the seedling's modules
+// have been fetched.
+func goimportsHook(ctx context.Context, target HookTarget) (string, error) {
+	src, err := ioutil.ReadFile(target.File)
+	if err != nil {
+		return "", err
+	}
+	out, err := imports.Process(target.File, src, nil)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(src, out) {
+		return "", nil
+	}
+	if err := ioutil.WriteFile(target.File, out, 0644); err != nil {
+		return "", err
+	}
+
+	before, after := importPaths(src), importPaths(out)
+	changes := []string{}
+	for _, path := range setDifference(after, before) {
+		changes = append(changes, "added import "+strconv.Quote(path))
+	}
+	for _, path := range setDifference(before, after) {
+		changes = append(changes, "removed unused import "+strconv.Quote(path))
+	}
+	if len(changes) == 0 {
+		return "reformatted the code", nil
+	}
+	return strings.Join(changes, ", "), nil
+}
+
+// goVetHook vets the package of the generated file. Missing modules are
+// added to go.mod as they're found, as `go get` would.
+func goVetHook(ctx context.Context, target HookTarget) (string, error) {
+	rel, err := filepath.Rel(target.Dir, filepath.Dir(target.File))
+	if err != nil {
+		return "", err
+	}
+	cmd, err := target.Runner.Command(ctx, BuildSpec{
+		Dir:       target.Dir,
+		Env:       []string{"GOFLAGS=-mod=mod"},
+		Name:      "go",
+		Args:      []string{"vet", "./" + filepath.ToSlash(rel)},
+		Toolchain: target.Toolchain,
+	})
+	if err != nil {
+		return "", err
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", errors.New(strings.TrimSpace(string(out)))
+	}
+	return "", nil
+}
+
+// fixesNote tells the model what the hooks fixed in its last version, so it
+// doesn't make the same mistake again.
+func fixesNote(fixes []string) string {
+	if len(fixes) == 0 {
+		return ""
+	}
+	return "Before building that code, " + strings.Join(fixes, "; ") + ". Get that right yourself next time.\n\n"
+}
+
+func importPaths(src []byte) map[string]bool {
+	paths := map[string]bool{}
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ImportsOnly)
+	if err != nil {
+		return paths
+	}
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+			paths[path] = true
+		}
+	}
+	return paths
+}
+
+// setDifference returns the keys of a that aren't in b, sorted.
+func setDifference(a, b map[string]bool) []string {
+	diff := []string{}
+	for key := range a {
+		if !b[key] {
+			diff = append(diff, key)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
@@ -0,0 +1,395 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// The items of the hardening checklist a promotion refines a seedling
+// with, see PROMOTE_CHECKLIST.
+const (
+	HardeningGracefulShutdown  = "graceful_shutdown"
+	HardeningRequestTimeouts   = "request_timeouts"
+	HardeningInputValidation   = "input_validation"
+	HardeningStructuredLogging = "structured_logging"
+	HardeningHealthEndpoints   = "health_endpoints"
+	HardeningNonRootUser       = "non_root_user"
+
+	// VerifiedByCheck is an item verified by inspecting the code, the
+	// Dockerfile or the running container, VerifiedByModel one the model
+	// was asked about.
+	VerifiedByCheck = "check"
+	VerifiedByModel = "model"
+
+	// HardenedBranch is the branch of the seedling's repo a promotion
+	// commits to.
+	HardenedBranch = "hardened"
+
+	// HealthCheckTimeout is how long the health endpoints of a promoted
+	// seedling's container have to answer.
+	HealthCheckTimeout = 5 * time.Second
+)
+
+// DefaultHardeningChecklist are the items promotions know, in the order
+// they're applied.
+var DefaultHardeningChecklist = []store.HardeningItem{
+	{ID: HardeningGracefulShutdown, Instruction: "Shut down gracefully: create the main context with signal.NotifyContext for SIGINT and SIGTERM, and when it's done call GracefulStop on the gRPC server and Shutdown, with a timeout, on the HTTP server before exiting."},
+	{ID: HardeningRequestTimeouts, Instruction: "Give the HTTP server request timeouts: serve it from an http.Server with ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout set instead of http.ListenAndServe, and bound each handler's work with a context timeout."},
+	{ID: HardeningInputValidation, Instruction: "Validate every request's input before acting on it: reject missing required fields, out-of-range numbers and malformed strings with codes.InvalidArgument from gRPC and 400 from HTTP, with a message naming the field."},
+	{ID: HardeningStructuredLogging, Instruction: "Log with structured fields: every log line goes through logrus with WithField or WithFields for the method, the relevant request fields, the duration and any error, instead of formatting them into the message."},
+	{ID: HardeningHealthEndpoints, Instruction: "Serve health and readiness from the HTTP server: GET /healthz answers 200 while the process is up, and GET /readyz answers 200 once the gRPC server is serving and 503 while it's starting or shutting down."},
+	{ID: HardeningNonRootUser, Instruction: "Run the container as a non-root user: the final stage of the Dockerfile creates an unprivileged user and switches to it with USER before CMD."},
+}
+
+// HardeningItem is the checklist item with the id, if there's one.
+func HardeningItem(id string) (store.HardeningItem, bool) {
+	for _, item := range DefaultHardeningChecklist {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return store.HardeningItem{}, false
+}
+
+func defaultPromoteChecklist() []string {
+	ids := make([]string, len(DefaultHardeningChecklist))
+	for i, item := range DefaultHardeningChecklist {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+// CheckPromoteChecklist returns why PROMOTE_CHECKLIST is invalid, if it is.
+func CheckPromoteChecklist(ids []string) error {
+	for _, id := range ids {
+		if _, ok := HardeningItem(id); !ok {
+			return fmt.Errorf("unknown checklist item %q", id)
+		}
+	}
+	return nil
+}
+
+// hardeningChecks are the items that can be verified without the model.
+// Each returns whether the seedling does what the item asks and, if it
+// doesn't, what's missing.
+var hardeningChecks = map[string]func(s *Pipeline, ctx context.Context, seedling store.Seedling) (bool, string){
+	HardeningGracefulShutdown: func(s *Pipeline, ctx context.Context, seedling store.Seedling) (bool, string) {
+		src := scanServerSource(s.RepoDir(seedling))
+		switch {
+		case !src.calls["os/signal.NotifyContext"] && !src.calls["os/signal.Notify"]:
+			return false, "the server doesn't call signal.NotifyContext"
+		case !src.methods["GracefulStop"]:
+			return false, "the server never calls GracefulStop on the gRPC server"
+		case !src.methods["Shutdown"]:
+			return false, "the server never calls Shutdown on the HTTP server"
+		}
+		return true, ""
+	},
+	HardeningRequestTimeouts: func(s *Pipeline, ctx context.Context, seedling store.Seedling) (bool, string) {
+		src := scanServerSource(s.RepoDir(seedling))
+		if !src.fields["net/http.Server.ReadHeaderTimeout"] && !src.fields["net/http.Server.ReadTimeout"] {
+			return false, "no http.Server sets ReadHeaderTimeout or ReadTimeout"
+		}
+		return true, ""
+	},
+	HardeningStructuredLogging: func(s *Pipeline, ctx context.Context, seedling store.Seedling) (bool, string) {
+		src := scanServerSource(s.RepoDir(seedling))
+		for _, structured := range []string{
+			"github.com/sirupsen/logrus.WithField", "github.com/sirupsen/logrus.WithFields",
+			"log/slog.Info", "log/slog.With", "go.uber.org/zap.String",
+		} {
+			if src.calls[structured] {
+				return true, ""
+			}
+		}
+		return false, "the server never logs with fields, e.g. logrus.WithField"
+	},
+	HardeningHealthEndpoints: func(s *Pipeline, ctx context.Context, seedling store.Seedling) (bool, string) {
+		src := scanServerSource(s.RepoDir(seedling))
+		for _, path := range []string{"/healthz", "/readyz"} {
+			if !src.strings[path] {
+				return false, "the server doesn't handle " + path
+			}
+		}
+		if seedling.HTTPPort == 0 {
+			return false, "the container isn't running, so /healthz and /readyz couldn't be requested"
+		}
+		client := &http.Client{Timeout: HealthCheckTimeout}
+		for _, path := range []string{"/healthz", "/readyz"} {
+			url := fmt.Sprintf("http://%s:%d%s", s.Config.SeedlingHost, seedling.HTTPPort, path)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return false, err.Error()
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return false, fmt.Sprintf("GET %s failed: %v", path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return false, fmt.Sprintf("GET %s answered %d", path, resp.StatusCode)
+			}
+		}
+		return true, ""
+	},
+	HardeningNonRootUser: func(s *Pipeline, ctx context.Context, seedling store.Seedling) (bool, string) {
+		user, err := dockerfileUser(filepath.Join(s.RepoDir(seedling), "Dockerfile"))
+		switch {
+		case err != nil:
+			return false, err.Error()
+		case user == "":
+			return false, "the Dockerfile's final stage has no USER"
+		case user == "root" || user == "0" || strings.HasPrefix(user, "root:") || strings.HasPrefix(user, "0:"):
+			return false, "the Dockerfile's final stage runs as " + user
+		}
+		return true, ""
+	},
+}
+
+// dockerfileUser is the user the final stage of the Dockerfile runs as,
+// "" if it doesn't set one.
+func dockerfileUser(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	user := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			user = ""
+		case "USER":
+			user = fields[1]
+		}
+	}
+	return user, scanner.Err()
+}
+
+// serverScan is what the hardening checks look for in the server's code:
+// the package functions it calls, by import path, the methods it calls on
+// anything, the fields of the struct literals of imported types it sets,
+// as path.Type.Field, and its string literals.
+type serverScan struct {
+	calls   map[string]bool
+	methods map[string]bool
+	fields  map[string]bool
+	strings map[string]bool
+}
+
+func scanServerSource(dir string) serverScan {
+	scan := serverScan{calls: map[string]bool{}, methods: map[string]bool{}, fields: map[string]bool{}, strings: map[string]bool{}}
+	paths, err := serverFilePaths(dir)
+	if err != nil {
+		return scan
+	}
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			continue
+		}
+		imports := map[string]string{}
+		for _, spec := range f.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			name := guessPackageName(importPath)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports[name] = importPath
+		}
+		// qualified is the path.Name of a selector on an imported
+		// package, "" for anything else.
+		qualified := func(expr ast.Expr) string {
+			sel, ok := expr.(*ast.SelectorExpr)
+			if !ok {
+				return ""
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && imports[pkg.Name] != "" {
+				return imports[pkg.Name] + "." + sel.Sel.Name
+			}
+			return ""
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if name := qualified(n.Fun); name != "" {
+					scan.calls[name] = true
+				} else if sel, ok := n.Fun.(*ast.SelectorExpr); ok {
+					scan.methods[sel.Sel.Name] = true
+				}
+			case *ast.CompositeLit:
+				typ := qualified(n.Type)
+				if typ == "" {
+					break
+				}
+				for _, elt := range n.Elts {
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						if key, ok := kv.Key.(*ast.Ident); ok {
+							scan.fields[typ+"."+key.Name] = true
+						}
+					}
+				}
+			case *ast.BasicLit:
+				if n.Kind == token.STRING {
+					if value, err := strconv.Unquote(n.Value); err == nil {
+						scan.strings[value] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	return scan
+}
+
+const hardeningVerifyPrompt = `Here is the server implementation of a service that %s:
+
+` + "```go\n%s```" + `
+
+Does it do the following? %s
+
+Answer "yes" or "no", then why in one sentence.
+
+Answer:`
+
+// verifyHardening checks the item with its check if it has one, and asks
+// the model otherwise.
+func (s *Pipeline) verifyHardening(ctx context.Context, seedling store.Seedling, item store.HardeningItem) store.HardeningResult {
+	result := store.HardeningResult{ID: item.ID, VerifiedBy: VerifiedByCheck}
+	if check, ok := hardeningChecks[item.ID]; ok {
+		result.Passed, result.Detail = check(s, ctx, seedling)
+		return result
+	}
+	result.VerifiedBy = VerifiedByModel
+	server, err := serverSource(s.RepoDir(seedling))
+	if err != nil {
+		result.Detail = "failed to read server code: " + err.Error()
+		return result
+	}
+	ctx = llm.WithFixtureName(ctx, seedling.Name)
+	answer, err := s.CompleteText(ctx, "", fmt.Sprintf(hardeningVerifyPrompt, seedling.Brief(), server, item.Instruction), 0)
+	if err != nil {
+		result.Detail = "failed to ask the model: " + err.Error()
+		return result
+	}
+	answer = strings.TrimSpace(answer)
+	result.Passed = strings.HasPrefix(strings.ToLower(answer), "yes")
+	result.Detail = answer
+	return result
+}
+
+// AdvancePromotion verifies the item of the seedling's promotion whose
+// refine just completed, refining it again if it wasn't done and it has
+// refines left, and starts the refine of the next item that isn't done
+// yet. Items whose check already passes aren't refined. Once every item
+// has a result the promotion is finished, and the seedling is hardened if
+// they all passed. It's called once the build's lease is released.
+func (s *Pipeline) AdvancePromotion(ctx context.Context, seedling *store.Seedling) {
+	promotion := seedling.Promotion
+	if !promotion.InProgress() {
+		return
+	}
+	if promotion.Refines > 0 {
+		item := promotion.Items[len(promotion.Results)]
+		result := s.verifyHardening(ctx, *seedling, item)
+		if !result.Passed && promotion.Refines < s.Config.PromoteItemRefines {
+			s.refineHardening(ctx, seedling, item, result.Detail)
+			return
+		}
+		result.Refines = promotion.Refines
+		result.Commit, _ = RepoHead(ctx, s.RepoDir(*seedling))
+		promotion.Results = append(promotion.Results, result)
+		promotion.Refines = 0
+	}
+	for len(promotion.Results) < len(promotion.Items) {
+		item := promotion.Items[len(promotion.Results)]
+		if check, ok := hardeningChecks[item.ID]; ok {
+			if passed, _ := check(s, ctx, *seedling); passed {
+				promotion.Results = append(promotion.Results, store.HardeningResult{ID: item.ID, Passed: true, VerifiedBy: VerifiedByCheck})
+				continue
+			}
+		}
+		s.refineHardening(ctx, seedling, item, "")
+		return
+	}
+
+	now := time.Now()
+	promotion.FinishedAt = &now
+	seedling.Hardened = true
+	for _, result := range promotion.Results {
+		seedling.Hardened = seedling.Hardened && result.Passed
+	}
+	if err := s.savePromotion(ctx, *seedling); err != nil {
+		logrus.WithField("error", err).Error("failed to finish promotion")
+		return
+	}
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventPromoted, Step: seedling.Step, Payload: EventPayload{
+		"hardened": seedling.Hardened,
+		"results":  promotion.Results,
+	}})
+	logrus.WithField("name", seedling.Name).WithField("hardened", seedling.Hardened).Info("Promoted seedling")
+}
+
+// refineHardening saves the promotion and refines the seedling from the
+// server step with the item, and with why its last refine didn't do it if
+// it's being refined again.
+func (s *Pipeline) refineHardening(ctx context.Context, seedling *store.Seedling, item store.HardeningItem, missing string) {
+	seedling.Promotion.Refines++
+	if err := s.savePromotion(ctx, *seedling); err != nil {
+		logrus.WithField("error", err).Error("failed to save promotion")
+		return
+	}
+	instruction := item.Instruction
+	if missing != "" {
+		instruction += "\n\nThe last change didn't do all of that: " + missing + "."
+	}
+	started, err := s.StartRefine(ctx, seedling, SeedlingStepServer, RefineRequest{Instruction: instruction}, seedling.Toolchain)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to start hardening refine")
+		return
+	}
+	if !started {
+		logrus.WithField("name", seedling.Name).WithField("item", item.ID).Warn("seedling isn't complete, hardening refine not started")
+	}
+}
+
+func (s *Pipeline) savePromotion(ctx context.Context, seedling store.Seedling) error {
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET promotion = $1, hardened = $2, version = version + 1 WHERE id = $3",
+		seedling.Promotion, seedling.Hardened, seedling.ID)
+	return err
+}
+
+// continuePromotion advances the promotion of the seedling, as it is now,
+// once a build of it completed.
+func (s *Pipeline) continuePromotion(ctx context.Context, id hide.Int64) {
+	var seedling store.Seedling
+	if err := s.DB.GetContext(ctx, &seedling, "SELECT * FROM seedlings WHERE id = $1", id); err != nil {
+		logrus.WithField("error", err).Error("failed to get promoted seedling")
+		return
+	}
+	if seedling.Step != SeedlingStepComplete {
+		return
+	}
+	s.AdvancePromotion(ctx, &seedling)
+}
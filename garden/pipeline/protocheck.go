@@ -0,0 +1,452 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tensorscale/garden/garden/store"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	lowerSnakeRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	upperSnakeRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+	pascalRegex     = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+)
+
+// ProtoCheckError lists the violations that rejected a generated proto. Its
+// message is what the model is reprompted with.
+type ProtoCheckError struct {
+	Violations []store.ProtoViolation
+}
+
+func (e *ProtoCheckError) Error() string {
+	msg := "The protobufs break these rules:\n"
+	breaking := false
+	for _, v := range e.Violations {
+		msg += fmt.Sprintf("- %s: %s\n", v.Rule, v.Message)
+		breaking = breaking || v.Breaking
+	}
+	if breaking {
+		msg += "Existing clients depend on the current API, so add new fields, messages and rpcs\n" +
+			"instead of removing, renaming or renumbering existing ones.\n"
+	}
+	return msg
+}
+
+// protoCheckErr is the ProtoCheckError for the violations of p that reject
+// the proto, or nil if there are none.
+func protoCheckErr(p *store.ProtoReport) error {
+	rejected := []store.ProtoViolation{}
+	for _, v := range p.Violations {
+		if !v.Breaking || !p.AllowBreaking {
+			rejected = append(rejected, v)
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	return &ProtoCheckError{Violations: rejected}
+}
+
+// checkProto lints the seedling's newly generated proto, or during a refine
+// compares it with the proto the refine started from. Refines aren't held
+// to lint rules their existing API may predate.
+func (s *Pipeline) checkProto(ctx context.Context, seedling store.Seedling, dir string) (*store.ProtoReport, error) {
+	pbFile := filepath.Join("protobufs", seedling.Name+".pb.go")
+	src, err := ioutil.ReadFile(filepath.Join(dir, pbFile))
+	if err != nil {
+		return nil, err
+	}
+	fd, err := fileDescriptor(src)
+	if err != nil {
+		return nil, err
+	}
+	report := &store.ProtoReport{Violations: []store.ProtoViolation{}}
+	if seedling.RefineInstruction == "" {
+		report.Violations = lintProto(fd)
+		return report, nil
+	}
+	if seedling.RefineBase == "" {
+		return report, nil
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "show", seedling.RefineBase+":./"+filepath.ToSlash(pbFile))
+	cmd.Dir = dir
+	prev, err := cmd.Output()
+	if err != nil {
+		// The refine started from a revision without generated code.
+		return report, nil
+	}
+	base, err := fileDescriptor(prev)
+	if err != nil {
+		return nil, err
+	}
+	report.Against = seedling.RefineBase
+	report.AllowBreaking = seedling.RefineAllowBreaking
+	report.Violations = breakingChanges(base, fd)
+	return report, nil
+}
+
+// fileDescriptor reads the file descriptor protoc-gen-go embeds in a .pb.go
+// as file_<name>_proto_rawDesc, either as a byte slice or, in newer
+// versions, a string.
+func fileDescriptor(src []byte) (*descriptorpb.FileDescriptorProto, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok || len(vs.Names) != 1 || len(vs.Values) != 1 || !strings.HasSuffix(vs.Names[0].Name, "_rawDesc") {
+				continue
+			}
+			raw, err := rawBytes(vs.Values[0])
+			if err != nil {
+				return nil, err
+			}
+			var fd descriptorpb.FileDescriptorProto
+			if err := proto.Unmarshal(raw, &fd); err != nil {
+				return nil, fmt.Errorf("invalid file descriptor: %w", err)
+			}
+			return &fd, nil
+		}
+	}
+	return nil, errors.New("no file descriptor in generated code")
+}
+
+func rawBytes(expr ast.Expr) ([]byte, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return rawBytes(e.X)
+	case *ast.CallExpr:
+		// string([]byte{...}) and []byte("...") conversions
+		if len(e.Args) == 1 {
+			return rawBytes(e.Args[0])
+		}
+	case *ast.BinaryExpr:
+		if e.Op == token.ADD {
+			x, err := rawBytes(e.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := rawBytes(e.Y)
+			if err != nil {
+				return nil, err
+			}
+			return append(x, y...), nil
+		}
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			s, err := strconv.Unquote(e.Value)
+			return []byte(s), err
+		}
+	case *ast.CompositeLit:
+		raw := make([]byte, 0, len(e.Elts))
+		for _, elt := range e.Elts {
+			lit, ok := elt.(*ast.BasicLit)
+			if !ok {
+				return nil, errors.New("unexpected element in file descriptor")
+			}
+			b, err := strconv.ParseUint(lit.Value, 0, 8)
+			if err != nil {
+				return nil, err
+			}
+			raw = append(raw, byte(b))
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("unexpected file descriptor expression %T", expr)
+}
+
+// protoDefs are a file's messages and enums, nested ones included, by name
+// within the package.
+type protoDefs struct {
+	messages map[string]*descriptorpb.DescriptorProto
+	enums    map[string]*descriptorpb.EnumDescriptorProto
+}
+
+func collectDefs(fd *descriptorpb.FileDescriptorProto) protoDefs {
+	defs := protoDefs{
+		messages: map[string]*descriptorpb.DescriptorProto{},
+		enums:    map[string]*descriptorpb.EnumDescriptorProto{},
+	}
+	var walk func(prefix string, messages []*descriptorpb.DescriptorProto)
+	walk = func(prefix string, messages []*descriptorpb.DescriptorProto) {
+		for _, m := range messages {
+			name := prefix + m.GetName()
+			defs.messages[name] = m
+			for _, e := range m.GetEnumType() {
+				defs.enums[name+"."+e.GetName()] = e
+			}
+			walk(name+".", m.GetNestedType())
+		}
+	}
+	walk("", fd.GetMessageType())
+	for _, e := range fd.GetEnumType() {
+		defs.enums[e.GetName()] = e
+	}
+	return defs
+}
+
+func (d protoDefs) messageNames() []string {
+	names := make([]string, 0, len(d.messages))
+	for name := range d.messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d protoDefs) enumNames() []string {
+	names := make([]string, 0, len(d.enums))
+	for name := range d.enums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lintProto checks a newly generated proto's naming and enum zero values.
+func lintProto(fd *descriptorpb.FileDescriptorProto) []store.ProtoViolation {
+	violations := []store.ProtoViolation{}
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, store.ProtoViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if pkg := fd.GetPackage(); pkg != "" {
+		for _, part := range strings.Split(pkg, ".") {
+			if !lowerSnakeRegex.MatchString(part) {
+				add("PACKAGE_LOWER_SNAKE_CASE", "package %q should be lower_snake_case", pkg)
+				break
+			}
+		}
+	}
+	defs := collectDefs(fd)
+	for _, name := range defs.messageNames() {
+		m := defs.messages[name]
+		if m.GetOptions().GetMapEntry() {
+			continue
+		}
+		if !pascalRegex.MatchString(m.GetName()) {
+			add("MESSAGE_PASCAL_CASE", "message %s should be PascalCase", name)
+		}
+		for _, field := range m.GetField() {
+			if !lowerSnakeRegex.MatchString(field.GetName()) {
+				add("FIELD_LOWER_SNAKE_CASE", "field %s.%s should be lower_snake_case", name, field.GetName())
+			}
+		}
+	}
+	for _, name := range defs.enumNames() {
+		e := defs.enums[name]
+		if !pascalRegex.MatchString(e.GetName()) {
+			add("ENUM_PASCAL_CASE", "enum %s should be PascalCase", name)
+		}
+		for _, value := range e.GetValue() {
+			if !upperSnakeRegex.MatchString(value.GetName()) {
+				add("ENUM_VALUE_UPPER_SNAKE_CASE", "enum value %s.%s should be UPPER_SNAKE_CASE", name, value.GetName())
+			}
+			if value.GetNumber() == 0 && !strings.HasSuffix(value.GetName(), "_UNSPECIFIED") {
+				add("ENUM_ZERO_VALUE_SUFFIX", "enum %s's zero value %s should be suffixed with _UNSPECIFIED, e.g. %s_UNSPECIFIED",
+					name, value.GetName(), upperSnake(e.GetName()))
+			}
+		}
+	}
+	for _, service := range fd.GetService() {
+		if !pascalRegex.MatchString(service.GetName()) {
+			add("SERVICE_PASCAL_CASE", "service %s should be PascalCase", service.GetName())
+		}
+		for _, method := range service.GetMethod() {
+			if !pascalRegex.MatchString(method.GetName()) {
+				add("RPC_PASCAL_CASE", "rpc %s.%s should be PascalCase", service.GetName(), method.GetName())
+			}
+		}
+	}
+	return violations
+}
+
+// upperSnake turns a PascalCase name into UPPER_SNAKE_CASE.
+func upperSnake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+// reservedField reports whether a message reserves field number n. Reserved
+// ranges' ends are exclusive.
+func reservedField(m *descriptorpb.DescriptorProto, n int32) bool {
+	for _, r := range m.GetReservedRange() {
+		if n >= r.GetStart() && n < r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedEnumValue reports whether an enum reserves value n. Unlike
+// messages', enums' reserved ranges include their ends.
+func reservedEnumValue(e *descriptorpb.EnumDescriptorProto, n int32) bool {
+	for _, r := range e.GetReservedRange() {
+		if n >= r.GetStart() && n <= r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+// breakingChanges are the changes from prev to next that would break
+// existing clients: fields and enum values are matched by number, the rest
+// by name, so renames show up as deletes. Removed field and enum value
+// numbers that have been reserved aren't breaking.
+func breakingChanges(prev, next *descriptorpb.FileDescriptorProto) []store.ProtoViolation {
+	violations := []store.ProtoViolation{}
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, store.ProtoViolation{Rule: rule, Message: fmt.Sprintf(format, args...), Breaking: true})
+	}
+
+	if prev.GetPackage() != next.GetPackage() {
+		add("FILE_SAME_PACKAGE", "package changed from %q to %q", prev.GetPackage(), next.GetPackage())
+	}
+
+	services := map[string]*descriptorpb.ServiceDescriptorProto{}
+	for _, service := range next.GetService() {
+		services[service.GetName()] = service
+	}
+	for _, service := range prev.GetService() {
+		ns, ok := services[service.GetName()]
+		if !ok {
+			add("SERVICE_NO_DELETE", "service %s was removed or renamed", service.GetName())
+			continue
+		}
+		methods := map[string]*descriptorpb.MethodDescriptorProto{}
+		for _, method := range ns.GetMethod() {
+			methods[method.GetName()] = method
+		}
+		for _, method := range service.GetMethod() {
+			name := service.GetName() + "." + method.GetName()
+			nm, ok := methods[method.GetName()]
+			if !ok {
+				add("RPC_NO_DELETE", "rpc %s was removed or renamed", name)
+				continue
+			}
+			if method.GetInputType() != nm.GetInputType() {
+				add("RPC_SAME_REQUEST_TYPE", "rpc %s's request changed from %s to %s",
+					name, strings.TrimPrefix(method.GetInputType(), "."), strings.TrimPrefix(nm.GetInputType(), "."))
+			}
+			if method.GetOutputType() != nm.GetOutputType() {
+				add("RPC_SAME_RESPONSE_TYPE", "rpc %s's response changed from %s to %s",
+					name, strings.TrimPrefix(method.GetOutputType(), "."), strings.TrimPrefix(nm.GetOutputType(), "."))
+			}
+			if method.GetClientStreaming() != nm.GetClientStreaming() {
+				add("RPC_SAME_CLIENT_STREAMING", "rpc %s changed whether its request is streamed", name)
+			}
+			if method.GetServerStreaming() != nm.GetServerStreaming() {
+				add("RPC_SAME_SERVER_STREAMING", "rpc %s changed whether its response is streamed", name)
+			}
+		}
+	}
+
+	prevDefs, nextDefs := collectDefs(prev), collectDefs(next)
+	for _, name := range prevDefs.messageNames() {
+		m := prevDefs.messages[name]
+		nm, ok := nextDefs.messages[name]
+		if !ok {
+			add("MESSAGE_NO_DELETE", "message %s was removed or renamed", name)
+			continue
+		}
+		byNumber := map[int32]*descriptorpb.FieldDescriptorProto{}
+		byName := map[string]*descriptorpb.FieldDescriptorProto{}
+		for _, field := range nm.GetField() {
+			byNumber[field.GetNumber()] = field
+			byName[field.GetName()] = field
+		}
+		for _, field := range m.GetField() {
+			nf, ok := byNumber[field.GetNumber()]
+			if !ok {
+				if renumbered, ok := byName[field.GetName()]; ok {
+					add("FIELD_NO_DELETE", "field %s.%s changed its number from %d to %d; field numbers can't change",
+						name, field.GetName(), field.GetNumber(), renumbered.GetNumber())
+				} else if !reservedField(nm, field.GetNumber()) {
+					add("FIELD_NO_DELETE", "field %d (%s) of %s was removed; reserve its number if it has to go",
+						field.GetNumber(), field.GetName(), name)
+				}
+				continue
+			}
+			if nf.GetName() != field.GetName() {
+				add("FIELD_SAME_NAME", "field %d of %s was renamed from %s to %s",
+					field.GetNumber(), name, field.GetName(), nf.GetName())
+			}
+			if nf.GetType() != field.GetType() || nf.GetTypeName() != field.GetTypeName() {
+				add("FIELD_SAME_TYPE", "field %s.%s changed type from %s to %s",
+					name, field.GetName(), fieldType(field), fieldType(nf))
+			}
+			if nf.GetLabel() != field.GetLabel() {
+				add("FIELD_SAME_LABEL", "field %s.%s changed from %s to %s",
+					name, field.GetName(), fieldLabel(field), fieldLabel(nf))
+			}
+		}
+	}
+
+	for _, name := range prevDefs.enumNames() {
+		e := prevDefs.enums[name]
+		ne, ok := nextDefs.enums[name]
+		if !ok {
+			add("ENUM_NO_DELETE", "enum %s was removed or renamed", name)
+			continue
+		}
+		byNumber := map[int32]string{}
+		for _, value := range ne.GetValue() {
+			byNumber[value.GetNumber()] = value.GetName()
+		}
+		for _, value := range e.GetValue() {
+			nv, ok := byNumber[value.GetNumber()]
+			if !ok {
+				if !reservedEnumValue(ne, value.GetNumber()) {
+					add("ENUM_VALUE_NO_DELETE", "enum value %d (%s) of %s was removed", value.GetNumber(), value.GetName(), name)
+				}
+				continue
+			}
+			if nv != value.GetName() {
+				add("ENUM_VALUE_SAME_NAME", "enum value %d of %s was renamed from %s to %s",
+					value.GetNumber(), name, value.GetName(), nv)
+			}
+		}
+	}
+	return violations
+}
+
+func fieldType(field *descriptorpb.FieldDescriptorProto) string {
+	if field.GetTypeName() != "" {
+		return strings.TrimPrefix(field.GetTypeName(), ".")
+	}
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+}
+
+func fieldLabel(field *descriptorpb.FieldDescriptorProto) string {
+	if field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		return "repeated"
+	}
+	return "singular"
+}
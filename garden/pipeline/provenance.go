@@ -0,0 +1,163 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// ProvenanceFile is the file at the root of a seedling's repo recording what
+// wrote each of its generated files.
+const ProvenanceFile = "provenance.json"
+
+var (
+	// provenanceComments are the comment prefixes of the languages whose
+	// generated files are stamped with a provenance header.
+	provenanceComments = map[string]string{
+		"go":         "//",
+		"proto":      "//",
+		"dockerfile": "#",
+		"bash":       "#",
+		"yaml":       "#",
+	}
+	// provenanceHeaderRegex matches a provenance header with the blank line
+	// after it, wherever it ended up in the code.
+	provenanceHeaderRegex = regexp.MustCompile(`(?m)^(?://|#) garden-provenance: .*\n(?:[ \t]*\n)?`)
+	// dockerDirectiveRegex matches a Dockerfile parser directive, e.g.
+	// "# syntax=docker/dockerfile:1", which only counts before any comment.
+	dockerDirectiveRegex = regexp.MustCompile(`^#\s*\w+\s*=`)
+)
+
+// provenanceHeader is what a generated file is stamped with: the seedling
+// it's from, when it was written, by which model and from which template.
+type provenanceHeader struct {
+	SeedlingID   string
+	GeneratedAt  time.Time
+	Model        string
+	TemplateHash string
+}
+
+func (h provenanceHeader) String() string {
+	model := h.Model
+	if model == "" {
+		// code a human accepted after the quality check
+		model = "unknown"
+	}
+	return fmt.Sprintf("garden-provenance: seedling=%s generated=%s model=%s template=%s",
+		h.SeedlingID, h.GeneratedAt.UTC().Format(time.RFC3339), model, h.TemplateHash)
+}
+
+// stripProvenance removes the provenance headers from code, so a file
+// written back by the model isn't stamped twice.
+func stripProvenance(code string) string {
+	return provenanceHeaderRegex.ReplaceAllString(code, "")
+}
+
+// stampProvenance prepends the header to code in lang's comment syntax,
+// after a shebang or Dockerfile parser directives, which have to stay first.
+// Code of other languages is returned as it is.
+func stampProvenance(code, lang string, h provenanceHeader) string {
+	prefix, ok := provenanceComments[lang]
+	if !ok {
+		return code
+	}
+	lines := strings.SplitAfter(stripProvenance(code), "\n")
+	n := 0
+	switch lang {
+	case "bash":
+		if strings.HasPrefix(lines[0], "#!") {
+			n = 1
+		}
+	case "dockerfile":
+		for n < len(lines) && dockerDirectiveRegex.MatchString(lines[n]) {
+			n++
+		}
+	}
+	return strings.Join(lines[:n], "") + prefix + " " + h.String() + "\n\n" + strings.Join(lines[n:], "")
+}
+
+// asWritten is code laid out as runSeedling writes it, for reading the
+// lines its build reports errors at. What the header says doesn't matter,
+// only its lines.
+func (s *Pipeline) asWritten(code, lang string) string {
+	if !s.Config.ProvenanceStamping {
+		return code
+	}
+	return stampProvenance(code, lang, provenanceHeader{})
+}
+
+// provenanceHeader is the header of code the model wrote for the seedling
+// now.
+func (s *Pipeline) provenanceHeader(ctx context.Context, seedling store.Seedling, model string) (provenanceHeader, error) {
+	h := provenanceHeader{
+		SeedlingID:   PublicID(seedling.ID),
+		GeneratedAt:  time.Now(),
+		Model:        model,
+		TemplateHash: "none",
+	}
+	tmpl, err := s.seedlingTemplate(ctx, seedling)
+	if err != nil || tmpl == nil {
+		return h, err
+	}
+	sum := sha256.Sum256([]byte(tmpl.ProtoSkeleton + "\x00" + tmpl.ServerHints + "\x00" + tmpl.QualityRules))
+	h.TemplateHash = hex.EncodeToString(sum[:8])
+	return h, nil
+}
+
+// Provenance is the contents of ProvenanceFile: the seedling and what wrote
+// each generated file, by its path in the repo.
+type Provenance struct {
+	Seedling string                    `json:"seedling"`
+	Files    map[string]FileProvenance `json:"files"`
+}
+
+// FileProvenance is the model and attempt that wrote a file, and the
+// SHA-256 of the file as it was committed.
+type FileProvenance struct {
+	Model   string `json:"model"`
+	Attempt int    `json:"attempt"`
+	SHA256  string `json:"sha256"`
+}
+
+// recordProvenance updates the ProvenanceFile of the seedling's repo with
+// the file at rel, which the attempt just wrote. The caller holds the repo
+// lock, other steps writing the file too.
+func (s *Pipeline) recordProvenance(seedling store.Seedling, rel, model string, attempt int) error {
+	path := filepath.Join(s.RepoDir(seedling), ProvenanceFile)
+	p := Provenance{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &p); err != nil {
+			return fmt.Errorf("invalid %s: %w", ProvenanceFile, err)
+		}
+	}
+	if p.Files == nil {
+		p.Files = map[string]FileProvenance{}
+	}
+
+	code, err := ioutil.ReadFile(filepath.Join(s.RepoDir(seedling), rel))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(code)
+	p.Seedling = PublicID(seedling.ID)
+	p.Files[filepath.ToSlash(rel)] = FileProvenance{Model: model, Attempt: attempt, SHA256: hex.EncodeToString(sum[:])}
+	data, err = json.MarshalIndent(&p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.SafeWriteFile(s.RepoDir(seedling), ProvenanceFile, append(data, '\n'), 0644)
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+)
+
+const (
+	QualityGood = "good"
+	QualityBad  = "bad"
+)
+
+// parseQualityCheck finds the first JSON object in a quality check
+// completion with a valid verdict, ignoring any prose or further objects
+// around it.
+func parseQualityCheck(text string) (QualityCheck, error) {
+	err := errors.New("no JSON object in response")
+	for i := strings.IndexByte(text, '{'); i != -1; i = NextObject(text, i) {
+		var qc QualityCheck
+		if decodeErr := json.NewDecoder(strings.NewReader(text[i:])).Decode(&qc); decodeErr != nil {
+			err = fmt.Errorf("invalid JSON: %w", decodeErr)
+			continue
+		}
+		qc.Quality = strings.ToLower(strings.TrimSpace(qc.Quality))
+		switch qc.Quality {
+		case QualityGood, QualityBad:
+			return qc, nil
+		case "":
+			err = errors.New(`missing "quality"`)
+		default:
+			err = fmt.Errorf(`"quality" must be %q or %q, not %q`, QualityGood, QualityBad, qc.Quality)
+		}
+	}
+	return QualityCheck{}, err
+}
+
+func NextObject(text string, i int) int {
+	next := strings.IndexByte(text[i+1:], '{')
+	if next == -1 {
+		return -1
+	}
+	return i + 1 + next
+}
+
+// QualityCheckRecord is a persisted quality check verdict on a version of a
+// seedling's server code. A rejected check can be accepted by a human, in
+// which case the next build of the server step uses its code as-is.
+type QualityCheckRecord struct {
+	ID           int64      `db:"id" json:"id"`
+	SeedlingID   hide.Int64 `db:"seedling_id" json:"seedlingId"`
+	Quality      string     `db:"quality" json:"quality"`
+	Reason       string     `db:"reason" json:"reason"`
+	Suggestions  string     `db:"suggestions" json:"suggestions"`
+	CodeHash     string     `db:"code_hash" json:"codeHash"`
+	Code         string     `db:"code" json:"code"`
+	Accepted     bool       `db:"accepted" json:"accepted"`
+	OverriddenBy string     `db:"overridden_by" json:"overriddenBy,omitempty"`
+	OverriddenAt *time.Time `db:"overridden_at" json:"overriddenAt,omitempty"`
+	// Consumed is set once a build has picked up the override.
+	Consumed  bool      `db:"consumed" json:"consumed"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+func codeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Pipeline) recordQualityCheck(ctx context.Context, seedlingID hide.Int64, qc QualityCheck, code string) error {
+	_, err := s.DB.NamedExecContext(ctx, `
+	 INSERT INTO quality_checks
+	 (seedling_id, quality, reason, suggestions, code_hash, code, accepted, created_at)
+	 VALUES (:seedling_id, :quality, :reason, :suggestions, :code_hash, :code, :accepted, :created_at)
+	 `, &QualityCheckRecord{
+		SeedlingID:  seedlingID,
+		Quality:     qc.Quality,
+		Reason:      qc.Reason,
+		Suggestions: qc.Suggestions,
+		CodeHash:    codeHash(code),
+		Code:        code,
+		Accepted:    qc.Error() == nil,
+		CreatedAt:   time.Now(),
+	})
+	return err
+}
+
+// takeQualityOverride returns the code of an override no build has used yet,
+// marking it used, or nil if there isn't one. This is the checkpoint a build
+// resumes from after a human accepts rejected code.
+func (s *Pipeline) takeQualityOverride(ctx context.Context, seedlingID hide.Int64) (*QualityCheckRecord, error) {
+	var record QualityCheckRecord
+	if err := s.DB.GetContext(ctx, &record, `
+	 SELECT * FROM quality_checks
+	 WHERE seedling_id = $1 AND overridden_at IS NOT NULL AND NOT consumed
+	 ORDER BY id DESC LIMIT 1
+	 `, seedlingID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE quality_checks SET consumed = TRUE WHERE id = $1", record.ID); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQualityCheck(t *testing.T) {
+	tests := []struct {
+		name, completion string
+		// want is the verdict, and err is in the error if there isn't one.
+		want, err string
+	}{
+		{name: "object", completion: `{"quality": "good", "reason": "complete", "suggestions": "none"}`, want: QualityGood},
+		{name: "prose before", completion: "Here's my review:\n" + `{"quality": "bad", "reason": "stubbed", "suggestions": "implement it"}`, want: QualityBad},
+		{name: "prose after", completion: `{"quality": "good", "reason": "fine", "suggestions": "none"}` + "\nLet me know if you need anything else!", want: QualityGood},
+		{name: "both examples", completion: `{"quality": "good", "reason": "fine", "suggestions": "none"}` + "\n\n" + `{"quality": "bad", "reason": "unimplemented method", "suggestions": "implement it"}`, want: QualityGood},
+		{name: "fenced", completion: "```json\n" + `{"quality": "bad", "reason": "TODOs", "suggestions": "remove them"}` + "\n```", want: QualityBad},
+		{name: "casing and space", completion: `{"quality": " Good ", "reason": "fine"}`, want: QualityGood},
+		{name: "braces in prose first", completion: "The handler {Say} is fine.\n" + `{"quality": "good", "reason": "fine"}`, want: QualityGood},
+		{name: "invalid object first", completion: `{"quality": "meh"}` + "\n" + `{"quality": "bad", "reason": "stubbed"}`, want: QualityBad},
+		{name: "nested object", completion: `{"quality": "bad", "reason": "stubbed", "details": {"quality": "good"}}`, want: QualityBad},
+		{name: "no object", completion: "The code looks good to me.", err: "no JSON object"},
+		{name: "empty", completion: "", err: "no JSON object"},
+		{name: "truncated", completion: `{"quality": "good", "reason": "fi`, err: "invalid JSON"},
+		{name: "missing quality", completion: `{"reason": "fine", "suggestions": "none"}`, err: `missing "quality"`},
+		{name: "not in the enum", completion: `{"quality": "excellent", "reason": "fine"}`, err: `not "excellent"`},
+		{name: "wrong type", completion: `{"quality": true}`, err: "invalid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qc, err := parseQualityCheck(tt.completion)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if qc.Quality != tt.want {
+				t.Errorf("quality %q, want %q", qc.Quality, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// SeedlingStepReadme writes the README of a complete seedling. It isn't one
+// of the build steps: it runs after the build completes and failing it
+// doesn't fail the build. Its model is README_MODEL unless MODELS sets one
+// for it.
+const SeedlingStepReadme = "SeedlingStepReadme"
+
+const readmePrompt = `Here is the gRPC API of a service that %s:
+
+` + "```protobuf\n%s```" + `
+
+%sWrite the overview section of the service's README: one or two short
+paragraphs saying what the service does and how its RPCs are meant to be
+used together. Write only the prose, without a heading, lists or code.
+
+Overview:
+`
+
+// readmeData is what a README is assembled from besides the overview: the
+// parsed proto, where the container is published and the example call.
+type readmeData struct {
+	Overview     string
+	Proto        string
+	ProtoPackage string
+	Services     []store.EndpointService
+	// Paths are the HTTP shim's operations, e.g. "POST /convert: Convert a
+	// file".
+	Paths    []string
+	GRPCAddr string
+	HTTPAddr string
+	Example  string
+}
+
+// httpOperations lists the operations of an OpenAPI spec sorted by path,
+// with their summaries.
+func httpOperations(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		return nil, err
+	}
+	ops := []string{}
+	for path, item := range doc.Paths {
+		for method, op := range item.Operations() {
+			line := method + " " + path
+			if summary := strings.TrimSpace(op.Summary); summary != "" {
+				line += ": " + summary
+			}
+			ops = append(ops, line)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		pi, pj := strings.Fields(ops[i])[1], strings.Fields(ops[j])[1]
+		if pi != pj {
+			return pi < pj
+		}
+		return ops[i] < ops[j]
+	})
+	return ops, nil
+}
+
+func renderReadme(seedling store.Seedling, data readmeData) string {
+	ports := seedling.SeedlingPorts.WithDefaults()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n%s\n\n", seedling.Name, strings.TrimSpace(data.Overview))
+
+	b.WriteString("## gRPC API\n\n")
+	if data.ProtoPackage != "" {
+		fmt.Fprintf(&b, "Package `%s`, defined in `protobufs/%s.proto`.\n\n", data.ProtoPackage, seedling.Name)
+	}
+	for _, service := range data.Services {
+		fmt.Fprintf(&b, "- `%s`: %s\n", service.Name, "`"+strings.Join(service.Methods, "`, `")+"`")
+	}
+	if len(data.Services) > 0 {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "```protobuf\n%s\n```\n\n", strings.TrimSpace(data.Proto))
+
+	if len(data.Paths) > 0 {
+		fmt.Fprintf(&b, "## HTTP API\n\nThe HTTP server on port %d serves:\n\n", ports.HTTPContainerPort)
+		for _, path := range data.Paths {
+			fmt.Fprintf(&b, "- %s\n", path)
+		}
+		b.WriteString("\nIts OpenAPI spec is `openapi.yaml`.\n\n")
+	}
+
+	fmt.Fprintf(&b, "## Running\n\n```sh\ndocker build -t %s .\ndocker run --rm -p %d -p %d %s\n```\n\n",
+		seedling.Name, ports.GRPCContainerPort, ports.HTTPContainerPort, seedling.Name)
+	fmt.Fprintf(&b, "The gRPC server listens on port %d and the HTTP server on port %d.", ports.GRPCContainerPort, ports.HTTPContainerPort)
+	if data.GRPCAddr != "" || data.HTTPAddr != "" {
+		b.WriteString(" The running instance is published on")
+		if data.GRPCAddr != "" {
+			fmt.Fprintf(&b, " `%s` (gRPC)", data.GRPCAddr)
+		}
+		if data.GRPCAddr != "" && data.HTTPAddr != "" {
+			b.WriteString(" and")
+		}
+		if data.HTTPAddr != "" {
+			fmt.Fprintf(&b, " `%s` (HTTP)", data.HTTPAddr)
+		}
+		b.WriteString(".")
+	}
+	b.WriteString("\n")
+
+	if data.Example != "" {
+		fmt.Fprintf(&b, "\n## Example\n\n`example-client-call.sh` calls the HTTP server:\n\n```bash\n%s\n```\n", strings.TrimSpace(data.Example))
+	}
+	return b.String()
+}
+
+// writeReadme generates README.md for a complete seedling and commits it.
+func (s *Pipeline) writeReadme(ctx context.Context, seedling store.Seedling) error {
+	dir := s.RepoDir(seedling)
+	proto, err := ioutil.ReadFile(filepath.Join(dir, "protobufs", seedling.Name+".proto"))
+	if err != nil {
+		return err
+	}
+	data := readmeData{Proto: string(proto)}
+	if data.ProtoPackage, data.Services, err = GRPCServices(filepath.Join(dir, "protobufs", seedling.Name+"_grpc.pb.go")); err != nil {
+		return fmt.Errorf("failed to parse services: %w", err)
+	}
+	if data.Paths, err = httpOperations(filepath.Join(dir, "openapi.yaml")); err != nil {
+		// The spec is validated when it's written, so this is a repo
+		// edited by hand; the rest of the README still stands.
+		logrus.WithField("error", err).Warn("failed to load OpenAPI spec for README")
+	}
+	host := s.Config.SeedlingHost
+	if seedling.GRPCPort != 0 {
+		data.GRPCAddr = fmt.Sprintf("%s:%d", host, seedling.GRPCPort)
+	}
+	if seedling.HTTPPort != 0 {
+		data.HTTPAddr = fmt.Sprintf("%s:%d", host, seedling.HTTPPort)
+		if data.Example, err = s.ExampleCurl(seedling, host); err != nil {
+			return fmt.Errorf("failed to read example client call: %w", err)
+		}
+	}
+
+	overview, err := s.CompleteText(ctx, SeedlingStepReadme,
+		fmt.Sprintf(readmePrompt, seedling.Brief(), proto, seedling.Plan.PlanHint()), 0.3)
+	if err != nil {
+		return fmt.Errorf("failed to write overview: %w", err)
+	}
+	if data.Overview = strings.TrimSpace(overview); data.Overview == "" {
+		data.Overview = seedling.Brief()
+	}
+
+	if err := s.SafeWriteFile(dir, "README.md", []byte(renderReadme(seedling, data)), 0644); err != nil {
+		return err
+	}
+	CommitRepo(ctx, dir, fmt.Sprintf("seedling %s: README", seedling.Name))
+	return nil
+}
+
+// GenerateReadme writes the seedling's README and records the outcome on the
+// seedling. It's best effort: a failure is kept in ReadmeError, from where a
+// refine with readme set retries it.
+func (s *Pipeline) GenerateReadme(ctx context.Context, seedling *store.Seedling) {
+	err := s.writeReadme(ctx, *seedling)
+	if err != nil {
+		logrus.WithField("error", err).WithField("name", seedling.Name).Warn("failed to generate README")
+		seedling.ReadmeError = err.Error()
+	} else {
+		now := time.Now()
+		seedling.ReadmeGeneratedAt = &now
+		seedling.ReadmeError = ""
+	}
+	if _, err := s.DB.NamedExecContext(ctx, `
+	 UPDATE seedlings SET
+	   readme_generated_at = :readme_generated_at,
+	   readme_error = :readme_error
+	 WHERE id = :id
+	 `, seedling); err != nil {
+		logrus.WithField("error", err).Error("failed to record README generation")
+	}
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/c2h5oh/hide"
+)
+
+// ReapedProcess is a process left behind by a build that had already ended,
+// killed by reap.
+type ReapedProcess struct {
+	PID        int
+	PGID       int
+	SeedlingID hide.Int64
+	Command    string
+}
+
+// Reap kills the processes still in the process groups of builds that have
+// been released, e.g. ones whose commands were started after the build
+// bailed out, and forgets the groups that are empty. Groups whose leader's
+// pid has been reused by a running build command are left alone.
+func (br *BuildRegistry) Reap() ([]ReapedProcess, error) {
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	running := br.runningGroups(0)
+	live := map[int]bool{}
+	reaped := []ReapedProcess{}
+	for _, proc := range procs {
+		id, ok := br.orphans[proc.pgid]
+		if !ok || running[proc.pgid] || proc.pid == os.Getpid() {
+			continue
+		}
+		live[proc.pgid] = true
+		if err := syscall.Kill(proc.pid, syscall.SIGKILL); err != nil {
+			if err != syscall.ESRCH {
+				return reaped, err
+			}
+			continue
+		}
+		reaped = append(reaped, ReapedProcess{PID: proc.pid, PGID: proc.pgid, SeedlingID: id, Command: proc.command})
+	}
+	for pgid := range br.orphans {
+		if !live[pgid] {
+			delete(br.orphans, pgid)
+		}
+	}
+	return reaped, nil
+}
+
+type process struct {
+	pid, pgid int
+	command   string
+}
+
+// listProcesses reads the pid, process group and command line of every
+// live process from /proc. Processes that exit while it's read are left
+// out, as are zombies, which are already dead.
+func listProcesses() ([]process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	procs := []process{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", entry.Name())
+		stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses can have spaces and
+		// parentheses of its own, so fields are counted from the last
+		// ")": state, ppid, pgrp.
+		i := strings.LastIndexByte(string(stat), ')')
+		if i == -1 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 3 || fields[0] == "Z" {
+			continue
+		}
+		pgid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+		command := strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		procs = append(procs, process{pid: pid, pgid: pgid, command: command})
+	}
+	return procs, nil
+}
@@ -0,0 +1,159 @@
+package pipeline
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/tensorscale/garden/garden/store"
+	"github.com/tensorscale/garden/garden/storetest"
+)
+
+// groupMembers is the commands of the live processes in the process group.
+func groupMembers(t *testing.T, pgid int) []string {
+	t.Helper()
+	procs, err := listProcesses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := []string{}
+	for _, proc := range procs {
+		if proc.pgid == pgid {
+			members = append(members, proc.command)
+		}
+	}
+	return members
+}
+
+// waitForMembers waits for the process group to have n live processes.
+func waitForMembers(t *testing.T, pgid, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		members := groupMembers(t, pgid)
+		if len(members) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process group %d has %q, want %d processes", pgid, members, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startBuild starts a fake build command that leaves sleeps running in the
+// background, the way docker buildx and go build leave their helpers, in a
+// build of the seedling with the id.
+func startBuild(t *testing.T, br *BuildRegistry, id hide.Int64) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", "sleep 600 & sleep 600 & wait")
+	br.Running(id, cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// The leader is reaped here once it's killed, so it's no zombie.
+	go cmd.Wait()
+	waitForMembers(t, cmd.Process.Pid, 3)
+	return cmd
+}
+
+func acquire(t *testing.T, br *BuildRegistry, id hide.Int64) {
+	t.Helper()
+	ok, err := br.TryAcquire(context.Background(), store.Seedling{DBRow: store.DBRow{ID: id}, Name: "echo"}, func() {})
+	if err != nil || !ok {
+		t.Fatalf("acquired %v: %v", ok, err)
+	}
+}
+
+func TestReleaseKillsProcessGroup(t *testing.T) {
+	br := NewBuildRegistry(storetest.Open(t))
+	acquire(t, br, 1)
+	cmd := startBuild(t, br, 1)
+	pgid := cmd.Process.Pid
+
+	// The build bails out, e.g. on a provider error, without waiting for
+	// its command, which is still running when the build's released.
+	br.Release(1)
+	waitForMembers(t, pgid, 0)
+	if _, ok := br.orphans[pgid]; !ok {
+		t.Errorf("the group isn't left to reap")
+	}
+
+	// The reaper finds nothing left and forgets the group.
+	reaped, err := br.Reap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 0 {
+		t.Errorf("reaped %+v", reaped)
+	}
+	if _, ok := br.orphans[pgid]; ok {
+		t.Errorf("the empty group is still to reap")
+	}
+}
+
+func TestKillKillsProcessGroup(t *testing.T) {
+	br := NewBuildRegistry(storetest.Open(t))
+	acquire(t, br, 1)
+	defer br.Release(1)
+	cmd := startBuild(t, br, 1)
+	if !br.Kill(1) {
+		t.Fatal("no build to kill")
+	}
+	waitForMembers(t, cmd.Process.Pid, 0)
+}
+
+func TestReap(t *testing.T) {
+	br := NewBuildRegistry(storetest.Open(t))
+
+	// A group left behind by a build that's been released, say by a
+	// command it started after it was.
+	left := exec.Command("sh", "-c", "sleep 601 & wait")
+	left.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := left.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go left.Wait()
+	waitForMembers(t, left.Process.Pid, 2)
+	br.orphans[left.Process.Pid] = 7
+
+	// A group whose leader's pid is now that of another build's running
+	// command isn't touched.
+	acquire(t, br, 2)
+	defer br.Release(2)
+	running := startBuild(t, br, 2)
+	br.orphans[running.Process.Pid] = 8
+
+	reaped, err := br.Reap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForMembers(t, left.Process.Pid, 0)
+	if len(reaped) != 2 {
+		t.Fatalf("reaped %+v, want the shell and its sleep", reaped)
+	}
+	commands := []string{}
+	for _, proc := range reaped {
+		if proc.PGID != left.Process.Pid || proc.SeedlingID != 7 {
+			t.Errorf("reaped %+v", proc)
+		}
+		commands = append(commands, proc.Command)
+	}
+	if got := strings.Join(commands, ", "); !strings.Contains(got, "sleep 601") {
+		t.Errorf("reaped %s", got)
+	}
+	if n := len(groupMembers(t, running.Process.Pid)); n != 3 {
+		t.Errorf("the running build's group has %d processes, want 3", n)
+	}
+
+	if _, err := br.Reap(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := br.orphans[left.Process.Pid]; ok {
+		t.Error("the reaped group is still to reap")
+	}
+}
@@ -0,0 +1,15 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Pipeline) ClearBitrot(ctx context.Context, id hide.Int64) {
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET bitrot = FALSE, bitrot_reason = '' WHERE id = $1", id); err != nil {
+		logrus.WithField("error", err).Error("failed to clear seedling bitrot")
+	}
+}
@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// RedactedText replaces what a redact pattern matched; known values are
+	// replaced with their name in it, e.g. [REDACTED:DB_PASSWORD].
+	RedactedText = "[REDACTED]"
+	// RedactMinLength is the shortest known value that's masked. Shorter
+	// ones would match all over the code.
+	RedactMinLength = 6
+)
+
+// builtinRedactPatterns match tokens of the services generated code is
+// most often given keys for, whoever they belong to.
+var builtinRedactPatterns = []string{
+	`sk-[A-Za-z0-9_-]{16,}`,
+	`AKIA[0-9A-Z]{16}`,
+	`gh[pousr]_[A-Za-z0-9]{36,}`,
+	`xox[abprs]-[A-Za-z0-9-]{10,}`,
+	`(?i)bearer\s+(?P<secret>[A-Za-z0-9._~+/=-]{8,})`,
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// valueBreak is what may come between two characters of a known value in
+// build output: spaces, or a wrapped line with BuildKit's step prefix.
+const valueBreak = `[ \t]*(?:\r?\n(?:#\d+ [0-9.]+ )?[ \t]*)?`
+
+// CompileRedactPatterns compiles the built-in patterns and extra.
+func CompileRedactPatterns(extra []string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range append(append([]string{}, builtinRedactPatterns...), extra...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+type redactValue struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// redactor masks secrets in text: known values, such as a seedling's
+// secrets and garden's own keys, and anything matching the patterns.
+type redactor struct {
+	values   []redactValue
+	patterns []*regexp.Regexp
+	// Count is how many matches were masked.
+	Count int
+}
+
+// add masks value as name. Values shorter than RedactMinLength are ignored.
+func (r *redactor) add(name, value string) {
+	var parts []string
+	for _, c := range value {
+		if !unicode.IsSpace(c) {
+			parts = append(parts, regexp.QuoteMeta(string(c)))
+		}
+	}
+	if len(parts) < RedactMinLength {
+		return
+	}
+	r.values = append(r.values, redactValue{name: name, re: regexp.MustCompile(strings.Join(parts, valueBreak))})
+}
+
+// Redact returns text with every secret masked.
+func (r *redactor) Redact(text string) string {
+	if text == "" {
+		return text
+	}
+	for _, v := range r.values {
+		mask := "[REDACTED:" + v.name + "]"
+		text = v.re.ReplaceAllStringFunc(text, func(string) string {
+			r.Count++
+			return mask
+		})
+	}
+	for _, re := range r.patterns {
+		group := re.SubexpIndex("secret")
+		var b strings.Builder
+		last := 0
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := m[0], m[1]
+			if group >= 0 && m[2*group] >= 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			b.WriteString(text[last:start])
+			b.WriteString(RedactedText)
+			last = end
+			r.Count++
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text
+}
+
+// SeedlingRedactor is a redactor for what's stored about a seedling: its
+// secrets and env values, garden's API keys and tokens, and the configured
+// patterns.
+func (s *Pipeline) SeedlingRedactor(seedling store.Seedling, secretNames []string) (*redactor, error) {
+	r := &redactor{patterns: s.RedactPatterns}
+	dir := SeedlingSecretsDir(s.RepoDir(seedling))
+	for _, name := range secretNames {
+		value, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.add(name, strings.TrimSpace(string(value)))
+	}
+	// Env values are named after their variable, so the directory listing
+	// is enough.
+	envDir := SeedlingEnvDir(s.RepoDir(seedling))
+	envFiles, err := ioutil.ReadDir(envDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, file := range envFiles {
+		value, err := ioutil.ReadFile(filepath.Join(envDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		r.add(file.Name(), strings.TrimSpace(string(value)))
+	}
+
+	keys := make([]string, 0, len(s.Config.APIKeys))
+	for name := range s.Config.APIKeys {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	for _, name := range keys {
+		r.add("API_KEYS", s.Config.APIKeys[name])
+	}
+	r.add("GIT_TOKEN", s.Config.GitToken)
+	r.add("HONEYCOMB_API_KEY", s.Config.MarkersAPIKey)
+	r.add("OPENAI_API_KEY", s.Config.OpenAIKey)
+	for _, key := range s.Config.OpenAIKeys {
+		r.add("OPENAI_API_KEYS", key)
+	}
+	if seedling.LLMKey != "" {
+		// A key that no longer decrypts can't be used either.
+		if key, err := s.openLLMKey(seedling.LLMKeyID, seedling.LLMKey); err == nil {
+			r.add(OpenAIKeyHeader, key)
+		}
+	}
+
+	// Longer values first, so one containing another is masked whole.
+	sort.SliceStable(r.values, func(i, j int) bool {
+		return len(r.values[i].re.String()) > len(r.values[j].re.String())
+	})
+	return r, nil
+}
@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+type RefineRequest struct {
+	Instruction   string `json:"instruction"`
+	AllowBreaking bool   `json:"allowBreaking"`
+	// Readme regenerates the README. Without an instruction that's all the
+	// refine does, which is how a failed README generation is retried.
+	Readme bool `json:"readme"`
+	// Toolchain moves the seedling to another Go version, which rebuilds
+	// it from the server step even without an instruction.
+	Toolchain string `json:"toolchain"`
+}
+
+// refinePrompt is the context a refine's first step is prompted with: the
+// current protobufs and server code, and the change to make to them.
+func (s *Pipeline) refinePrompt(seedling store.Seedling) string {
+	dir := s.RepoDir(seedling)
+	proto, err := ioutil.ReadFile(filepath.Join(dir, "protobufs", seedling.Name+".proto"))
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to read protobufs for refine")
+	}
+	server, err := serverSource(dir)
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to read server code for refine")
+	}
+	return fmt.Sprintf(`This is an existing service that %s.
+%sIts protobufs are:
+
+`+"```protobuf\n%s```"+`
+
+and its server implementation is:
+
+`+"```go\n%s```"+`
+
+Change it as follows, keeping everything else working the same: %s
+
+`, seedling.Brief(), seedling.Plan.PlanHint(), proto, server, seedling.RefineInstruction)
+}
+
+func RepoHead(ctx context.Context, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// finishRefine squashes the commits made by each step of the refine into
+// one and clears the refine. Seedlings in the shared repo aren't squashed,
+// since other seedlings commit to it too.
+func (s *Pipeline) finishRefine(ctx context.Context, seedling store.Seedling) {
+	if seedling.RefineBase != "" && HasOwnRepo(s.RepoDir(seedling)) {
+		dir := s.RepoDir(seedling)
+		cmd := exec.CommandContext(ctx, "git", "reset", "--soft", seedling.RefineBase)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			logrus.WithField("error", err).WithField("output", string(out)).Error("failed to squash refine commits")
+		} else {
+			CommitRepo(ctx, dir, fmt.Sprintf("refine %d: %s", seedling.Revision, seedling.RefineInstruction))
+		}
+	}
+	if _, err := s.DB.ExecContext(ctx,
+		"UPDATE seedlings SET refine_instruction = '', refine_base = '', refine_allow_breaking = FALSE WHERE id = $1", seedling.ID); err != nil {
+		logrus.WithField("error", err).Error("failed to clear seedling refine")
+	}
+}
+
+// StartRefine moves a complete seedling to step, to be rebuilt from there
+// with the change req asks for and toolchain, and queues its build. It
+// returns false if the seedling wasn't complete, e.g. because another refine
+// started first.
+func (s *Pipeline) StartRefine(ctx context.Context, seedling *store.Seedling, step string, req RefineRequest, toolchain string) (bool, error) {
+	base, err := RepoHead(ctx, s.RepoDir(*seedling))
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to get seedling repo HEAD, refine won't be squashed")
+	}
+
+	// Only one of concurrent refines moves the seedling off the complete
+	// step.
+	now := time.Now()
+	result, err := s.DB.ExecContext(ctx, `
+	 UPDATE seedlings
+	 SET step = $1, step_started_at = $2, modified_at = $2, revision = revision + 1, version = version + 1,
+	   refine_instruction = $3, refine_base = $4, refine_allow_breaking = $5, toolchain = $6
+	 WHERE id = $7 AND step = $8
+	 `, step, now, req.Instruction, base, req.AllowBreaking, toolchain, seedling.ID, SeedlingStepComplete)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	seedling.Step = step
+	seedling.StepStartedAt = &now
+	seedling.ModifiedAt = now
+	seedling.Revision++
+	seedling.RefineInstruction = req.Instruction
+	seedling.RefineBase = base
+	seedling.RefineAllowBreaking = req.AllowBreaking
+	if toolchain != seedling.Toolchain {
+		seedling.Toolchain = toolchain
+		// The first step's commit picks it up, so it's squashed into the
+		// refine's.
+		if err := setGoDirective(s.RepoDir(*seedling), toolchain); err != nil {
+			logrus.WithField("error", err).Error("failed to set go directive")
+		}
+	}
+	s.Emit(ctx, seedling.ID, SeedlingEvent{
+		Type: EventRefined,
+		Step: step,
+		Payload: EventPayload{
+			"instruction":   EventText(req.Instruction),
+			"revision":      seedling.Revision,
+			"allowBreaking": req.AllowBreaking,
+			"toolchain":     toolchain,
+		},
+	})
+	s.Notify(ctx, *seedling, EventStepChanged, step)
+	s.SubmitBuild(ctx, *seedling)
+	return true, nil
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ReportDateLayout = "2006-01-02"
+	ReportTimeLayout = "15:04"
+
+	// ReportCheckInterval is how often the report loop checks whether the
+	// last day's report is due.
+	ReportCheckInterval = time.Minute
+	// MAX_REPORT_ATTEMPTS is how many times a report is posted to
+	// REPORT_WEBHOOK_URL before it's given up on, backing off
+	// exponentially from ReportBackoff.
+	MAX_REPORT_ATTEMPTS = 3
+	ReportBackoff       = 5 * time.Second
+	ReportTimeout       = 10 * time.Second
+)
+
+// DefaultModelPrices are what OpenAI's models cost in US dollars per 1,000
+// prompt and completion tokens, as of April 2023. MODEL_PRICES overrides
+// them.
+var DefaultModelPrices = map[string]ModelPrice{
+	"gpt-4":                  {Prompt: 0.03, Completion: 0.06},
+	"gpt-4-32k":              {Prompt: 0.06, Completion: 0.12},
+	"gpt-3.5-turbo":          {Prompt: 0.002, Completion: 0.002},
+	"text-davinci-003":       {Prompt: 0.02, Completion: 0.02},
+	"text-davinci-002":       {Prompt: 0.02, Completion: 0.02},
+	"code-davinci-002":       {Prompt: 0.02, Completion: 0.02},
+	"text-curie-001":         {Prompt: 0.002, Completion: 0.002},
+	"text-babbage-001":       {Prompt: 0.0005, Completion: 0.0005},
+	"text-ada-001":           {Prompt: 0.0004, Completion: 0.0004},
+	"text-embedding-ada-002": {Prompt: 0.0004},
+}
+
+// ModelPrice is what a model costs in US dollars per 1,000 tokens.
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// parseModelPrice parses a MODEL_PRICES price: the prompt and completion
+// prices joined by "/", or one price for both.
+func parseModelPrice(value string) (ModelPrice, error) {
+	prompt, completion, split := strings.Cut(value, "/")
+	var price ModelPrice
+	var err error
+	if price.Prompt, err = strconv.ParseFloat(strings.TrimSpace(prompt), 64); err != nil || price.Prompt < 0 {
+		return price, fmt.Errorf("invalid price %q", value)
+	}
+	price.Completion = price.Prompt
+	if split {
+		if price.Completion, err = strconv.ParseFloat(strings.TrimSpace(completion), 64); err != nil || price.Completion < 0 {
+			return price, fmt.Errorf("invalid price %q", value)
+		}
+	}
+	return price, nil
+}
+
+// Cost is what the tokens cost at the price of model, ok if it has one. A
+// model without a price of its own takes that of the longest priced name
+// it's a dated version of, e.g. gpt-4-0314 gpt-4's.
+func (rs ReportSchedule) Cost(model string, prompt, completion int64) (float64, bool) {
+	best := ""
+	for name := range rs.prices {
+		if (model == name || strings.HasPrefix(model, name+"-")) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return 0, false
+	}
+	price := rs.prices[best]
+	return (float64(prompt)*price.Prompt + float64(completion)*price.Completion) / 1000, true
+}
+
+// ReportSchedule is when daily reports are due and what they price tokens
+// at: REPORT_TIME_ZONE, REPORT_TIME and MODEL_PRICES.
+type ReportSchedule struct {
+	Location     *time.Location
+	hour, minute int
+	prices       map[string]ModelPrice
+}
+
+func NewReportSchedule(config Config) (ReportSchedule, error) {
+	rs := ReportSchedule{prices: map[string]ModelPrice{}}
+	var err error
+	if rs.Location, err = time.LoadLocation(config.ReportTimeZone); err != nil {
+		return rs, fmt.Errorf("REPORT_TIME_ZONE: %w", err)
+	}
+	at, err := time.Parse(ReportTimeLayout, config.ReportTime)
+	if err != nil {
+		return rs, fmt.Errorf("REPORT_TIME must be HH:MM, not %q", config.ReportTime)
+	}
+	rs.hour, rs.minute = at.Hour(), at.Minute()
+	for model, price := range DefaultModelPrices {
+		rs.prices[model] = price
+	}
+	for model, value := range config.ModelPrices {
+		if rs.prices[model], err = parseModelPrice(value); err != nil {
+			return rs, fmt.Errorf("MODEL_PRICES %s: %w", model, err)
+		}
+	}
+	return rs, nil
+}
+
+// Day is the start and end of the day at date in the report time zone.
+func (rs ReportSchedule) Day(date string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation(ReportDateLayout, date, rs.Location)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, start.AddDate(0, 0, 1), nil
+}
+
+// Due is the date of the last day whose report is due at now, empty before
+// REPORT_TIME on the day after it.
+func (rs ReportSchedule) Due(now time.Time) string {
+	now = now.In(rs.Location)
+	at := time.Date(now.Year(), now.Month(), now.Day(), rs.hour, rs.minute, 0, 0, rs.Location)
+	if now.Before(at) {
+		return ""
+	}
+	return now.AddDate(0, 0, -1).Format(ReportDateLayout)
+}
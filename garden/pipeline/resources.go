@@ -0,0 +1,24 @@
+package pipeline
+
+import (
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// ResourcesWithDefaults fills in the config's defaults for fields of r that
+// aren't set, which are only missing on seedlings created before limits were
+// stored.
+func ResourcesWithDefaults(r store.SeedlingResources, config Config) store.SeedlingResources {
+	if r.Memory == "" {
+		r.Memory = config.ContainerMemory
+	}
+	if r.CPUs == "" {
+		r.CPUs = config.ContainerCPUs
+	}
+	if r.PidsLimit == 0 {
+		r.PidsLimit = config.ContainerPidsLimit
+	}
+	if r.RestartPolicy == "" {
+		r.RestartPolicy = config.ContainerRestartPolicy
+	}
+	return r
+}
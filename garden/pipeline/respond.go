@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ErrCodeInvalidRequest  = "invalid_request"
+	ErrCodeUnauthorized    = "unauthorized"
+	ErrCodeForbidden       = "forbidden"
+	ErrCodeNotFound        = "not_found"
+	ErrCodeConflict        = "conflict"
+	ErrCodeTooLarge        = "too_large"
+	ErrCodeTooManyRequests = "too_many_requests"
+	ErrCodeInternal        = "internal"
+	ErrCodeBadGateway      = "bad_gateway"
+	ErrCodeUnavailable     = "unavailable"
+	// ErrCodePreconditionRequired and ErrCodePreconditionFailed errors
+	// are updates without If-Match, or whose If-Match isn't the current
+	// version. Their details have the current version to retry with.
+	ErrCodePreconditionRequired = "precondition_required"
+	ErrCodePreconditionFailed   = "precondition_failed"
+	// ErrCodeValidation errors are 422s listing every problem with the
+	// request body.
+	ErrCodeValidation = "validation_failed"
+	// ErrCodeNameConflict and ErrCodeImageMissing errors are container
+	// operations docker refused: a container already has the seedling's
+	// container's name, or its image is gone and it has to be rebuilt.
+	ErrCodeNameConflict = "name_conflict"
+	ErrCodeImageMissing = "image_missing"
+	// ErrCodeBuildFailed errors are ?sync=true creates whose build failed
+	// at its first step. Their details are the seedling.
+	ErrCodeBuildFailed = "build_failed"
+	// ErrCodePossibleDuplicate errors are creates of seedlings whose
+	// description is like existing seedlings', which are listed in their
+	// details' matches. They're made with ignoreDuplicates.
+	ErrCodePossibleDuplicate = "possible_duplicate"
+
+	RequestIDHeader = "X-Request-ID"
+)
+
+type (
+	requestIDKey struct{}
+	loggerKey    struct{}
+)
+
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logrus.WithField("error", err).Error("failed to generate request id")
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func WithLogger(ctx context.Context, log *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// LoggerFromContext returns the request-scoped logger set by the logging
+// middleware, falling back to the standard logger.
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	if log, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return log
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// RequestIDFromContext returns the request ID assigned by the logging
+// middleware, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,157 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// SeedlingRevision is a build of a seedling that completed, end to end: the
+// commit its image was built from, the image and the ports its container
+// ran on, and what wrote its code. Revisions are numbered from 1 for each
+// seedling and aren't changed once they're recorded. Deploying a revision
+// again rebuilds its image from CommitSHA, so ImageID is the image it was
+// first built as.
+type SeedlingRevision struct {
+	SeedlingID hide.Int64 `db:"seedling_id" json:"-"`
+	Number     int        `db:"number" json:"number"`
+	CommitSHA  string     `db:"commit_sha" json:"commitSha"`
+	ImageID    string     `db:"image_id" json:"imageId,omitempty"`
+	GRPCPort   int        `db:"grpc_port" json:"grpcPort,omitempty"`
+	HTTPPort   int        `db:"http_port" json:"httpPort,omitempty"`
+	// Models are the models that wrote the revision's generated files, as
+	// its ProvenanceFile records them, or the seedling's model without one.
+	ModelsJSON   string     `db:"models" json:"-"`
+	Models       []string   `db:"-" json:"models"`
+	Template     string     `db:"template" json:"template,omitempty"`
+	TemplateHash string     `db:"template_hash" json:"templateHash"`
+	Toolchain    string     `db:"toolchain" json:"toolchain,omitempty"`
+	Platform     string     `db:"platform" json:"platform,omitempty"`
+	CommittedAt  *time.Time `db:"committed_at" json:"committedAt,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"createdAt"`
+	// Current is set for the revision the seedling's container runs.
+	Current   bool   `db:"-" json:"current"`
+	DiffURL   string `db:"-" json:"diffUrl"`
+	ExportURL string `db:"-" json:"exportUrl"`
+	DeployURL string `db:"-" json:"deployUrl"`
+}
+
+// Decode fills in the fields derived from the stored ones for the seedling
+// the revision is of.
+func (rev *SeedlingRevision) Decode(seedling store.Seedling) error {
+	rev.Models = []string{}
+	if rev.ModelsJSON != "" {
+		if err := json.Unmarshal([]byte(rev.ModelsJSON), &rev.Models); err != nil {
+			return fmt.Errorf("invalid revision models: %w", err)
+		}
+	}
+	rev.Current = rev.Number == seedling.CurrentRevision
+	base := "/api/v1/seedlings/" + PublicID(seedling.ID) + "/revisions/" + strconv.Itoa(rev.Number)
+	rev.DiffURL = base + "/diff"
+	rev.ExportURL = base + "/export"
+	rev.DeployURL = base + "/deploy"
+	return nil
+}
+
+// revisionModels returns the models the ProvenanceFile at sha says wrote
+// the seedling's files, or its model if the file isn't there.
+func revisionModels(ctx context.Context, seedling store.Seedling, dir, sha string) []string {
+	models := []string{}
+	var p Provenance
+	if data, err := GitOutput(ctx, dir, "show", sha+":./"+ProvenanceFile); err == nil && json.Unmarshal([]byte(data), &p) == nil {
+		seen := map[string]bool{}
+		for _, f := range p.Files {
+			if f.Model != "" && !seen[f.Model] {
+				seen[f.Model] = true
+				models = append(models, f.Model)
+			}
+		}
+		sort.Strings(models)
+	}
+	if len(models) == 0 && seedling.Model != "" {
+		models = append(models, seedling.Model)
+	}
+	return models
+}
+
+// RecordRevision records the build of the seedling that just completed as
+// its next revision, which the container it started runs, and makes it the
+// seedling's current revision.
+func (s *Pipeline) RecordRevision(ctx context.Context, seedling *store.Seedling) error {
+	dir := s.RepoDir(*seedling)
+	sha, err := RepoHead(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("failed to get repo head: %w", err)
+	}
+	rev := SeedlingRevision{
+		SeedlingID: seedling.ID,
+		CommitSHA:  sha,
+		GRPCPort:   seedling.GRPCPort,
+		HTTPPort:   seedling.HTTPPort,
+		Template:   seedling.Template,
+		Toolchain:  seedling.Toolchain,
+		Platform:   seedling.Platform,
+		CreatedAt:  time.Now(),
+	}
+	if rev.ImageID, err = s.Docker.ImageID(ctx, s.ContainerName(*seedling)); err != nil {
+		logrus.WithField("error", err).Warn("failed to get seedling image id")
+	}
+	b, err := json.Marshal(revisionModels(ctx, *seedling, dir, sha))
+	if err != nil {
+		return err
+	}
+	rev.ModelsJSON = string(b)
+	h, err := s.provenanceHeader(ctx, *seedling, "")
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to get seedling template")
+	}
+	rev.TemplateHash = h.TemplateHash
+	if out, err := GitOutput(ctx, dir, "show", "-s", "--format=%cI", sha); err == nil {
+		if at, err := time.Parse(time.RFC3339, strings.TrimSpace(out)); err == nil {
+			rev.CommittedAt = &at
+		}
+	}
+
+	if err := s.InTx(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &rev.Number,
+			"SELECT COALESCE(MAX(number), 0) + 1 FROM seedling_revisions WHERE seedling_id = $1", seedling.ID); err != nil {
+			return err
+		}
+		if _, err := tx.NamedExecContext(ctx, `
+		 INSERT INTO seedling_revisions (seedling_id, number, commit_sha, image_id, grpc_port, http_port, models, template, template_hash, toolchain, platform, committed_at, created_at)
+		 VALUES (:seedling_id, :number, :commit_sha, :image_id, :grpc_port, :http_port, :models, :template, :template_hash, :toolchain, :platform, :committed_at, :created_at)
+		 `, &rev); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			"UPDATE seedlings SET current_revision = $1, version = version + 1 WHERE id = $2", rev.Number, seedling.ID)
+		return err
+	}); err != nil {
+		return err
+	}
+	seedling.CurrentRevision = rev.Number
+	seedling.Version++
+	logrus.WithField("name", seedling.Name).
+		WithField("revision", rev.Number).
+		WithField("sha", sha).
+		Info("Recorded seedling revision")
+	s.EmitRevisionDeployed(ctx, *seedling, rev, true)
+	return nil
+}
+
+func (s *Pipeline) EmitRevisionDeployed(ctx context.Context, seedling store.Seedling, rev SeedlingRevision, created bool) {
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: EventRevisionDeployed, Step: seedling.Step, Payload: EventPayload{
+		"revision":  rev.Number,
+		"commitSha": rev.CommitSHA,
+		"created":   created,
+	}})
+}
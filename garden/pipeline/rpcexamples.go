@@ -0,0 +1,374 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/c2h5oh/hide"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// SeedlingStepExamples writes an example call of each rpc of a complete
+// seedling. Like SeedlingStepReadme it isn't one of the build steps, and
+// failing it doesn't fail the build.
+const SeedlingStepExamples = "SeedlingStepExamples"
+
+const examplePrompt = `Here is the gRPC API of a service that %s:
+
+` + "```protobuf\n%s```" + `
+
+Write an example call of its %s rpc: a realistic request and the response
+the service would answer it with. The request and response are JSON objects
+with these fields:
+
+%s
+Output exactly one JSON object like:
+
+` + "```" + `
+{"request": {...}, "response": {...}}
+` + "```" + `
+
+Use only the fields listed, with values of their types.
+` + "```json\n"
+
+// rawJSON is JSON stored as text, encoded as it is.
+type rawJSON string
+
+func (j rawJSON) MarshalJSON() ([]byte, error) {
+	if j == "" {
+		return []byte("null"), nil
+	}
+	return []byte(j), nil
+}
+
+// RPCExample is an example call of one of a seedling's rpcs. Its request and
+// response are checked against the rpc's messages; an example that still
+// didn't match them after ExamplesMaxAttempts is kept with Valid unset and
+// the last problem in Error. HTTPMethod and HTTPPath are the HTTP server's
+// operation for the rpc, if its OpenAPI spec has one.
+type RPCExample struct {
+	ID         hide.Int64 `db:"id" json:"id"`
+	SeedlingID hide.Int64 `db:"seedling_id" json:"-"`
+	// Revision is the seedling's revision the example was written for.
+	Revision   int       `db:"revision" json:"revision"`
+	Service    string    `db:"service" json:"service"`
+	RPC        string    `db:"rpc" json:"rpc"`
+	Request    rawJSON   `db:"request" json:"request"`
+	Response   rawJSON   `db:"response" json:"response"`
+	HTTPMethod string    `db:"http_method" json:"httpMethod,omitempty"`
+	HTTPPath   string    `db:"http_path" json:"httpPath,omitempty"`
+	Valid      bool      `db:"valid" json:"valid"`
+	Error      string    `db:"error" json:"error,omitempty"`
+	Attempts   int       `db:"attempts" json:"attempts"`
+	CreatedAt  time.Time `db:"created_at" json:"createdAt"`
+}
+
+// httpRoute is an operation of a seedling's OpenAPI spec.
+type httpRoute struct {
+	method      string
+	path        string
+	operationID string
+}
+
+// httpRoutes lists the operations of an OpenAPI spec, none if there isn't
+// one.
+func httpRoutes(file string) ([]httpRoute, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := openapi3.NewLoader().LoadFromData(data)
+	if err != nil {
+		return nil, err
+	}
+	routes := []httpRoute{}
+	for path, item := range doc.Paths {
+		for method, op := range item.Operations() {
+			routes = append(routes, httpRoute{method: method, path: path, operationID: op.OperationID})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].path != routes[j].path {
+			return routes[i].path < routes[j].path
+		}
+		return routes[i].method < routes[j].method
+	})
+	return routes, nil
+}
+
+// routeFor finds the HTTP operation of an rpc: the one with the rpc's name
+// as its operationId, or else whose path ends in it, ignoring case and
+// punctuation, e.g. /convert-file for ConvertFile.
+func routeFor(routes []httpRoute, rpc string) (httpRoute, bool) {
+	for _, route := range routes {
+		if strings.EqualFold(route.operationID, rpc) {
+			return route, true
+		}
+	}
+	fold := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, s)
+	}
+	for _, route := range routes {
+		if fold(route.path[strings.LastIndex(route.path, "/")+1:]) == fold(rpc) {
+			return route, true
+		}
+	}
+	return httpRoute{}, false
+}
+
+// protoFile builds the descriptor of the seedling's proto from the one
+// protoc-gen-go embedded in its generated code.
+func (s *Pipeline) protoFile(seedling store.Seedling) (protoreflect.FileDescriptor, error) {
+	src, err := ioutil.ReadFile(filepath.Join(s.RepoDir(seedling), "protobufs", seedling.Name+".pb.go"))
+	if err != nil {
+		return nil, err
+	}
+	fd, err := fileDescriptor(src)
+	if err != nil {
+		return nil, err
+	}
+	return protodesc.NewFile(fd, protoregistry.GlobalFiles)
+}
+
+// messageFields describes the fields of md, and of the messages they hold,
+// as they're named in JSON.
+func messageFields(md protoreflect.MessageDescriptor) string {
+	var b strings.Builder
+	seen := map[protoreflect.FullName]bool{}
+	var walk func(md protoreflect.MessageDescriptor)
+	walk = func(md protoreflect.MessageDescriptor) {
+		if seen[md.FullName()] {
+			return
+		}
+		seen[md.FullName()] = true
+		fmt.Fprintf(&b, "%s {\n", md.FullName())
+		held := []protoreflect.MessageDescriptor{}
+		fields := md.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			fmt.Fprintf(&b, "  %q: %s\n", field.Name(), jsonFieldType(field))
+			if field.IsMap() {
+				field = field.MapValue()
+			}
+			// The well-known types have JSON forms of their own.
+			if m := field.Message(); m != nil && !strings.HasPrefix(string(m.FullName()), "google.protobuf.") {
+				held = append(held, m)
+			}
+		}
+		b.WriteString("}\n")
+		for _, m := range held {
+			walk(m)
+		}
+	}
+	walk(md)
+	return b.String()
+}
+
+func jsonFieldType(field protoreflect.FieldDescriptor) string {
+	switch {
+	case field.IsMap():
+		return "map of " + kindName(field.MapKey()) + " to " + kindName(field.MapValue())
+	case field.IsList():
+		return "list of " + kindName(field)
+	}
+	return kindName(field)
+}
+
+func kindName(field protoreflect.FieldDescriptor) string {
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(field.Message().FullName())
+	case protoreflect.EnumKind:
+		values := field.Enum().Values()
+		names := make([]string, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return "enum, one of " + strings.Join(names, ", ")
+	case protoreflect.BytesKind:
+		return "bytes, base64"
+	}
+	return field.Kind().String()
+}
+
+// parseExample reads the first example out of a completion whose request
+// and response unmarshal into the rpc's messages, returning them indented.
+// Objects nested in the first one are tried in case the model wrapped the
+// example, but the error is the first one's.
+func parseExample(text string, method protoreflect.MethodDescriptor) (string, string, error) {
+	err := errors.New("no JSON object in response")
+	decoded := false
+	for i := strings.IndexByte(text, '{'); i != -1; i = NextObject(text, i) {
+		var example struct {
+			Request  json.RawMessage `json:"request"`
+			Response json.RawMessage `json:"response"`
+		}
+		if decodeErr := json.NewDecoder(strings.NewReader(text[i:])).Decode(&example); decodeErr != nil {
+			if !decoded {
+				err = fmt.Errorf("invalid JSON: %w", decodeErr)
+			}
+			continue
+		}
+		checkErr := checkExample(method, example.Request, example.Response)
+		if checkErr == nil {
+			var req, resp bytes.Buffer
+			json.Indent(&req, example.Request, "", "  ")
+			if len(example.Response) > 0 {
+				json.Indent(&resp, example.Response, "", "  ")
+			}
+			return req.String(), resp.String(), nil
+		}
+		if !decoded {
+			err, decoded = checkErr, true
+		}
+	}
+	return "", "", err
+}
+
+// checkExample returns why the request or response isn't one of the rpc's.
+func checkExample(method protoreflect.MethodDescriptor, req, resp json.RawMessage) error {
+	if len(req) == 0 {
+		return errors.New("the object has no request")
+	}
+	if err := protojson.Unmarshal(req, dynamicpb.NewMessage(method.Input())); err != nil {
+		return fmt.Errorf("the request isn't a %s: %w", method.Input().FullName(), err)
+	}
+	if len(resp) == 0 {
+		return nil
+	}
+	if err := protojson.Unmarshal(resp, dynamicpb.NewMessage(method.Output())); err != nil {
+		return fmt.Errorf("the response isn't a %s: %w", method.Output().FullName(), err)
+	}
+	return nil
+}
+
+// writeExample asks the model for an example call of the rpc until one
+// matches its messages or ExamplesMaxAttempts is reached.
+func (s *Pipeline) writeExample(ctx context.Context, seedling store.Seedling, proto []byte, method protoreflect.MethodDescriptor) (RPCExample, error) {
+	example := RPCExample{
+		SeedlingID: seedling.ID,
+		Revision:   seedling.Revision,
+		Service:    string(method.Parent().FullName()),
+		RPC:        string(method.Name()),
+	}
+	prompt := fmt.Sprintf(examplePrompt, seedling.Brief(), proto, method.Name(),
+		messageFields(method.Input())+messageFields(method.Output()))
+	for example.Attempts = 1; ; example.Attempts++ {
+		out, err := s.CompleteText(ctx, SeedlingStepExamples, prompt, 0.3)
+		if err != nil {
+			return example, err
+		}
+		req, resp, err := parseExample(out, method)
+		if err == nil {
+			example.Request, example.Response = rawJSON(req), rawJSON(resp)
+			example.Valid, example.Error = true, ""
+			return example, nil
+		}
+		example.Error = err.Error()
+		if example.Attempts >= s.Config.ExamplesMaxAttempts {
+			return example, nil
+		}
+		prompt += out + "\n```\n\nThat example is invalid: " + err.Error() + ". Respond with exactly one JSON object in the format above.\n```json\n"
+	}
+}
+
+// writeExamples writes an example call of every rpc of the seedling's
+// proto.
+func (s *Pipeline) writeExamples(ctx context.Context, seedling store.Seedling) ([]RPCExample, error) {
+	dir := s.RepoDir(seedling)
+	proto, err := ioutil.ReadFile(filepath.Join(dir, "protobufs", seedling.Name+".proto"))
+	if err != nil {
+		return nil, err
+	}
+	fd, err := s.protoFile(seedling)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load proto descriptor: %w", err)
+	}
+	routes, err := httpRoutes(filepath.Join(dir, "openapi.yaml"))
+	if err != nil {
+		// Examples are still worth having without their HTTP routes.
+		logrus.WithField("error", err).Warn("failed to load OpenAPI spec for examples")
+	}
+
+	examples := []RPCExample{}
+	for i := 0; i < fd.Services().Len(); i++ {
+		methods := fd.Services().Get(i).Methods()
+		for j := 0; j < methods.Len(); j++ {
+			example, err := s.writeExample(ctx, seedling, proto, methods.Get(j))
+			if err != nil {
+				return nil, fmt.Errorf("failed to write example of %s: %w", methods.Get(j).Name(), err)
+			}
+			if route, ok := routeFor(routes, example.RPC); ok {
+				example.HTTPMethod, example.HTTPPath = route.method, route.path
+			}
+			examples = append(examples, example)
+		}
+	}
+	return examples, nil
+}
+
+// generateExamples replaces the seedling's examples with ones of its rpcs
+// as they are now. It's best effort: on failure the examples of the
+// previous revision stay.
+func (s *Pipeline) generateExamples(ctx context.Context, seedling store.Seedling) {
+	if s.Config.ExamplesMaxAttempts <= 0 {
+		return
+	}
+	examples, err := s.writeExamples(ctx, seedling)
+	if err != nil {
+		logrus.WithField("error", err).WithField("name", seedling.Name).Warn("failed to generate examples")
+		return
+	}
+	now := time.Now()
+	if err := s.InTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM seedling_examples WHERE seedling_id = $1", seedling.ID); err != nil {
+			return err
+		}
+		for i := range examples {
+			examples[i].CreatedAt = now
+			if _, err := tx.NamedExecContext(ctx, `
+			 INSERT INTO seedling_examples
+			 (seedling_id, revision, service, rpc, request, response, http_method, http_path, valid, error, attempts, created_at)
+			 VALUES (:seedling_id, :revision, :service, :rpc, :request, :response, :http_method, :http_path, :valid, :error, :attempts, :created_at)
+			 `, &examples[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		logrus.WithField("error", err).Error("failed to store examples")
+	}
+}
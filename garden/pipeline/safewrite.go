@@ -0,0 +1,232 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// UnsafeWriteError is a write to a seedling's repo that was refused, before
+// anything was written, because its path leads out of the repo or into
+// .git, or because it's over REPO_FILE_MAX_BYTES or REPO_MAX_BYTES.
+type UnsafeWriteError struct {
+	Path     string
+	Reason   string
+	TooLarge bool
+}
+
+func (e *UnsafeWriteError) Error() string {
+	return fmt.Sprintf("refusing to write %s: %s", e.Path, e.Reason)
+}
+
+// repoLimits cap what's written to a seedling's repo: each file, and the
+// repo's files in all without .git. Zero doesn't cap.
+type repoLimits struct {
+	file  int64
+	total int64
+}
+
+func (s *Pipeline) repoLimits() repoLimits {
+	return repoLimits{file: s.Config.RepoFileMaxBytes, total: s.Config.RepoMaxBytes}
+}
+
+// repoWrite is a file to write to a repo, its path relative to it.
+type repoWrite struct {
+	path string
+	data []byte
+	perm os.FileMode
+}
+
+// SafeWriteFile writes data to rel in the seedling repo at root, creating
+// the directories it's in. See writeRepoFiles.
+func (s *Pipeline) SafeWriteFile(root, rel string, data []byte, perm os.FileMode) error {
+	return writeRepoFiles(root, []repoWrite{{path: rel, data: data, perm: perm}}, s.repoLimits())
+}
+
+// writeRepoFiles writes files to the repo at root, or none of them if any
+// is refused with an UnsafeWriteError: one whose path is absolute, has a ..
+// in it, is in .git, is a symlink or is under a symlink that leads out of
+// the repo or into its .git, and any over the limits. Symlinks within the
+// repo are followed.
+func writeRepoFiles(root string, files []repoWrite, limits repoLimits) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	targets := make([]string, len(files))
+	var added int64
+	for i, f := range files {
+		if limits.file > 0 && int64(len(f.data)) > limits.file {
+			return &UnsafeWriteError{Path: f.path, TooLarge: true,
+				Reason: fmt.Sprintf("it's %d bytes, more than the %d allowed for a file", len(f.data), limits.file)}
+		}
+		if targets[i], err = ResolveRepoPath(realRoot, f.path, false); err != nil {
+			return err
+		}
+		added += int64(len(f.data))
+		if info, err := os.Lstat(targets[i]); err == nil {
+			added -= info.Size()
+		}
+	}
+	if limits.total > 0 {
+		size, err := repoSize(realRoot)
+		if err != nil {
+			return err
+		}
+		if size+added > limits.total {
+			return &UnsafeWriteError{Path: files[len(files)-1].path, TooLarge: true,
+				Reason: fmt.Sprintf("the repo would have %d bytes of files, more than the %d allowed", size+added, limits.total)}
+		}
+	}
+	for i, f := range files {
+		// Resolved again as the directories are made, in case a
+		// symlink appeared since.
+		if targets[i], err = ResolveRepoPath(realRoot, f.path, true); err != nil {
+			return err
+		}
+		if err := writeNoFollow(targets[i], f.data, f.perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveRepoPath is where rel is in the repo at realRoot, which has no
+// symlinks in it, following the symlinks of the directories it's in. With
+// create, the directories that don't exist yet are made.
+func ResolveRepoPath(realRoot, rel string, create bool) (string, error) {
+	refuse := func(reason string) (string, error) {
+		return "", &UnsafeWriteError{Path: rel, Reason: reason}
+	}
+	if rel == "" {
+		return refuse("it's not a file path")
+	}
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, "/") {
+		return refuse("it's an absolute path")
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, part := range parts {
+		if part == ".." {
+			return refuse("it has a .. in it")
+		}
+	}
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if clean == "." {
+		return refuse("it's not a file path")
+	}
+	parts = strings.Split(clean, string(filepath.Separator))
+	dir := realRoot
+	for i, part := range parts {
+		next := filepath.Join(dir, part)
+		if inGitDir(realRoot, next) {
+			return refuse("it's in the repo's .git")
+		}
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			if i == len(parts)-1 {
+				return next, nil
+			}
+			if !create {
+				// Nothing below a directory that isn't there yet can
+				// be a symlink.
+				return filepath.Join(append([]string{next}, parts[i+1:]...)...), nil
+			}
+			if err := os.Mkdir(next, 0755); err != nil {
+				return "", err
+			}
+			dir = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if i == len(parts)-1 {
+				return refuse("it's a symlink")
+			}
+			resolved, err := filepath.EvalSymlinks(next)
+			if err != nil {
+				return "", err
+			}
+			if !WithinDir(realRoot, resolved) {
+				return refuse(fmt.Sprintf("%s is a symlink out of the repo", filepath.ToSlash(filepath.Join(parts[:i+1]...))))
+			}
+			if inGitDir(realRoot, resolved) {
+				return refuse(fmt.Sprintf("%s is a symlink into the repo's .git", filepath.ToSlash(filepath.Join(parts[:i+1]...))))
+			}
+			if info, err = os.Stat(resolved); err != nil {
+				return "", err
+			}
+			next = resolved
+		}
+		if i < len(parts)-1 && !info.IsDir() {
+			return refuse(fmt.Sprintf("%s isn't a directory", filepath.ToSlash(filepath.Join(parts[:i+1]...))))
+		}
+		dir = next
+	}
+	return dir, nil
+}
+
+// WithinDir is whether path is dir or under it.
+func WithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// inGitDir is whether path, under the repo at realRoot, is in a .git.
+func inGitDir(realRoot, path string) bool {
+	rel, err := filepath.Rel(realRoot, path)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.EqualFold(part, ".git") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNoFollow is ioutil.WriteFile, except that it fails rather than
+// write through a symlink that was put at path.
+func writeNoFollow(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, perm)
+	if errors.Is(err, syscall.ELOOP) {
+		return &UnsafeWriteError{Path: path, Reason: "it's a symlink"}
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// repoSize is the size of the repo's files, without .git.
+func repoSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,220 @@
+package pipeline
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRepo is a repo with a .git and a file in it, next to a directory
+// outside of it, with symlinks from the repo to both and into its .git.
+func testRepo(t *testing.T) (root, outside string) {
+	t.Helper()
+	dir := t.TempDir()
+	root, outside = filepath.Join(dir, "repo"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(root, ".git", "hooks"), filepath.Join(root, "server"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, data := range map[string]string{
+		filepath.Join(root, "go.mod"):      "module echo\n",
+		filepath.Join(outside, "keep.txt"): "outside\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"out":      outside,
+		"relout":   filepath.Join("..", "outside"),
+		"hooks":    filepath.Join(".git", "hooks"),
+		"srv":      "server",
+		"passwd":   filepath.Join(outside, "keep.txt"),
+		"mod.link": "go.mod",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+// checkUntouched fails if anything was written outside the repo or to its
+// .git.
+func checkUntouched(t *testing.T, root, outside string) {
+	t.Helper()
+	for _, dir := range []string{outside, filepath.Join(root, ".git")} {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.IsDir() && path != filepath.Join(outside, "keep.txt") {
+				t.Errorf("%s was written", path)
+			}
+			return nil
+		})
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(outside, "keep.txt")); err != nil || string(data) != "outside\n" {
+		t.Errorf("the file outside the repo has %q: %v", data, err)
+	}
+}
+
+func TestResolveRepoPath(t *testing.T) {
+	root, outside := testRepo(t)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rel    string
+		want   string
+		reason string
+	}{
+		{"server/main.go", "server/main.go", ""},
+		{"protobufs/echo/echo.proto", "protobufs/echo/echo.proto", ""},
+		{"./server//main.go", "server/main.go", ""},
+		{"srv/main.go", "server/main.go", ""},
+		{"", "", "it's not a file path"},
+		{".", "", "it's not a file path"},
+		{"../outside/keep.txt", "", "it has a .. in it"},
+		{"server/../../outside/keep.txt", "", "it has a .. in it"},
+		{"/etc/passwd", "", "it's an absolute path"},
+		{filepath.Join(outside, "keep.txt"), "", "it's an absolute path"},
+		{".git/config", "", "it's in the repo's .git"},
+		{".git/hooks/pre-commit", "", "it's in the repo's .git"},
+		{"server/.GIT/HEAD", "", "it's in the repo's .git"},
+		{"out/keep.txt", "", "out is a symlink out of the repo"},
+		{"relout/new.txt", "", "relout is a symlink out of the repo"},
+		{"hooks/pre-commit", "", "hooks is a symlink into the repo's .git"},
+		{"passwd", "", "it's a symlink"},
+		{"mod.link", "", "it's a symlink"},
+		{"go.mod/x", "", "go.mod isn't a directory"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveRepoPath(realRoot, tt.rel, false)
+		var unsafeErr *UnsafeWriteError
+		switch {
+		case tt.reason == "":
+			if err != nil || got != filepath.Join(realRoot, filepath.FromSlash(tt.want)) {
+				t.Errorf("%q resolved to %q, %v, want %s", tt.rel, got, err, tt.want)
+			}
+		case !errors.As(err, &unsafeErr):
+			t.Errorf("%q resolved to %q, %v, want it refused", tt.rel, got, err)
+		case unsafeErr.Path != tt.rel || unsafeErr.Reason != tt.reason || unsafeErr.TooLarge:
+			t.Errorf("%q refused with %+v, want %q", tt.rel, unsafeErr, tt.reason)
+		}
+	}
+	// Resolving without create makes nothing.
+	if _, err := os.Stat(filepath.Join(root, "protobufs")); !os.IsNotExist(err) {
+		t.Errorf("protobufs was made: %v", err)
+	}
+	checkUntouched(t, root, outside)
+}
+
+func TestWriteRepoFiles(t *testing.T) {
+	root, outside := testRepo(t)
+
+	// Directories are made, through symlinks within the repo.
+	if err := writeRepoFiles(root, []repoWrite{
+		{path: "protobufs/echo/echo.proto", data: []byte("syntax = \"proto3\";\n"), perm: 0644},
+		{path: "srv/main.go", data: []byte("package main\n"), perm: 0644},
+	}, repoLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"protobufs/echo/echo.proto": "syntax = \"proto3\";\n", "server/main.go": "package main\n"} {
+		if data, err := ioutil.ReadFile(filepath.Join(root, path)); err != nil || string(data) != want {
+			t.Errorf("%s has %q: %v", path, data, err)
+		}
+	}
+
+	// A batch with one refused file writes none of them.
+	for _, refused := range []string{"../outside/new.txt", "out/new.txt", "relout/deeper/new.txt", "hooks/pre-commit", ".git/config", "passwd", "/tmp/new.txt"} {
+		err := writeRepoFiles(root, []repoWrite{
+			{path: "README.md", data: []byte("# echo\n"), perm: 0644},
+			{path: refused, data: []byte("#!/bin/sh\n"), perm: 0755},
+		}, repoLimits{})
+		var unsafeErr *UnsafeWriteError
+		if !errors.As(err, &unsafeErr) || unsafeErr.Path != refused {
+			t.Errorf("writing %s: %v", refused, err)
+		}
+		if !strings.HasPrefix(err.Error(), "refusing to write "+refused+": ") {
+			t.Errorf("writing %s: %q", refused, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "README.md")); !os.IsNotExist(err) {
+			t.Errorf("README.md was written along with %s", refused)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "deeper")); !os.IsNotExist(err) {
+		t.Errorf("a directory was made outside the repo: %v", err)
+	}
+	checkUntouched(t, root, outside)
+}
+
+func TestWriteRepoFilesLimits(t *testing.T) {
+	root, outside := testRepo(t)
+	tooLarge := func(err error) bool {
+		var unsafeErr *UnsafeWriteError
+		return errors.As(err, &unsafeErr) && unsafeErr.TooLarge
+	}
+	// The repo starts with go.mod's 12 bytes, .git not counted.
+	limits := repoLimits{file: 10, total: 30}
+
+	if err := writeRepoFiles(root, []repoWrite{{path: "a.txt", data: []byte("0123456789"), perm: 0644}}, limits); err != nil {
+		t.Fatalf("a file at the cap: %v", err)
+	}
+	err := writeRepoFiles(root, []repoWrite{{path: "b.txt", data: []byte("0123456789a"), perm: 0644}}, limits)
+	if !tooLarge(err) || !strings.Contains(err.Error(), "11 bytes, more than the 10 allowed for a file") {
+		t.Errorf("a file over the cap: %v", err)
+	}
+
+	// Together they'd be over the repo's cap, so neither is written.
+	err = writeRepoFiles(root, []repoWrite{
+		{path: "b.txt", data: []byte("01234"), perm: 0644},
+		{path: "c.txt", data: []byte("0123456789"), perm: 0644},
+	}, limits)
+	if !tooLarge(err) || !strings.Contains(err.Error(), "the repo would have 37 bytes of files, more than the 30 allowed") {
+		t.Errorf("files over the repo's cap: %v", err)
+	}
+	for _, name := range []string{"b.txt", "c.txt"} {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was written", name)
+		}
+	}
+
+	// Rewriting a file only counts what it grows by, and .git isn't
+	// counted at all.
+	if err := ioutil.WriteFile(filepath.Join(root, ".git", "packed"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRepoFiles(root, []repoWrite{
+		{path: "a.txt", data: []byte("9876543210"), perm: 0644},
+		{path: "b.txt", data: []byte("01234567"), perm: 0644},
+	}, limits); err != nil {
+		t.Errorf("rewriting within the repo's cap: %v", err)
+	}
+
+	// Refusing a file over the limits doesn't depend on where it'd go.
+	if err := writeRepoFiles(root, []repoWrite{{path: "../outside/big.txt", data: make([]byte, 11), perm: 0644}}, limits); !tooLarge(err) {
+		t.Errorf("a big file out of the repo: %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, ".git", "packed")); err != nil {
+		t.Fatal(err)
+	}
+	checkUntouched(t, root, outside)
+}
+
+func TestWriteNoFollow(t *testing.T) {
+	root, outside := testRepo(t)
+	// A symlink put where the file was resolved to, as if after it was, is
+	// refused rather than written through.
+	err := writeNoFollow(filepath.Join(root, "passwd"), []byte("root::0:0::/:/bin/sh\n"), 0644)
+	var unsafeErr *UnsafeWriteError
+	if !errors.As(err, &unsafeErr) || unsafeErr.Reason != "it's a symlink" {
+		t.Errorf("writing through a symlink: %v", err)
+	}
+	checkUntouched(t, root, outside)
+}
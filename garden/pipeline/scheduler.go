@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// Scheduler runs seedling builds on a fixed number of workers, queueing any
+// builds submitted while all workers are busy. A running build may also
+// reserve an idle worker to run one of its steps alongside the rest.
+// Background jobs, such as scheduled rebuilds, only run on workers no build
+// is waiting for.
+type Scheduler struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queue      []queuedBuild
+	background []func()
+	build      func(context.Context, store.Seedling)
+	workers    int
+	// started is how many worker goroutines there are, which never goes
+	// down: those over workers just stay idle.
+	started int
+	// busy is how many workers are running a build or are reserved.
+	busy int
+}
+
+// queuedBuild is a build waiting for a worker, with the context it was
+// submitted with.
+type queuedBuild struct {
+	ctx      context.Context
+	seedling store.Seedling
+}
+
+func NewScheduler(workers int, build func(context.Context, store.Seedling)) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	sc := &Scheduler{build: build}
+	sc.cond = sync.NewCond(&sc.mu)
+	sc.Resize(workers)
+	return sc
+}
+
+// Resize changes how many builds run at once. Shrinking doesn't stop
+// builds: it keeps busy workers from taking more until they're under it.
+func (sc *Scheduler) Resize(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	sc.mu.Lock()
+	sc.workers = workers
+	for ; sc.started < workers; sc.started++ {
+		go sc.worker()
+	}
+	sc.mu.Unlock()
+	sc.cond.Broadcast()
+}
+
+// Submit queues the seedling's build, which is run with ctx. It has to
+// outlive the request submitting it, see BuildRegistry.detach.
+func (sc *Scheduler) Submit(ctx context.Context, seedling store.Seedling) {
+	sc.mu.Lock()
+	sc.queue = append(sc.queue, queuedBuild{ctx: ctx, seedling: seedling})
+	sc.mu.Unlock()
+	sc.cond.Signal()
+}
+
+// SubmitBackground queues job to run once a worker is idle and no build is
+// queued. A running job isn't stopped for builds submitted after it.
+func (sc *Scheduler) SubmitBackground(job func()) {
+	sc.mu.Lock()
+	sc.background = append(sc.background, job)
+	sc.mu.Unlock()
+	sc.cond.Signal()
+}
+
+// QueueDepth is the number of builds waiting for a worker.
+func (sc *Scheduler) QueueDepth() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.queue)
+}
+
+// Queued returns the builds waiting for a worker, next first.
+func (sc *Scheduler) Queued() []store.Seedling {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	queued := make([]store.Seedling, len(sc.queue))
+	for i, b := range sc.queue {
+		queued[i] = b.seedling
+	}
+	return queued
+}
+
+func (sc *Scheduler) worker() {
+	for {
+		sc.mu.Lock()
+		for len(sc.queue) == 0 && len(sc.background) == 0 || sc.busy >= sc.workers {
+			sc.cond.Wait()
+		}
+		sc.busy++
+		if len(sc.queue) == 0 {
+			job := sc.background[0]
+			sc.background = sc.background[1:]
+			sc.mu.Unlock()
+			job()
+			sc.Release()
+			continue
+		}
+		next := sc.queue[0]
+		sc.queue = sc.queue[1:]
+		sc.mu.Unlock()
+
+		sc.build(next.ctx, next.seedling)
+		sc.Release()
+	}
+}
+
+// TryReserve reserves an idle worker, returning false if there's none. A
+// reserved worker doesn't take builds off the queue until it's released.
+func (sc *Scheduler) TryReserve() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.busy >= sc.workers {
+		return false
+	}
+	sc.busy++
+	return true
+}
+
+// Release frees a worker that ran a build or was reserved.
+func (sc *Scheduler) Release() {
+	sc.mu.Lock()
+	sc.busy--
+	sc.mu.Unlock()
+	sc.cond.Broadcast()
+}
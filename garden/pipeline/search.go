@@ -0,0 +1,8 @@
+package pipeline
+
+const (
+	// MAX_LIST_LIMIT caps ?limit= on list endpoints.
+	MAX_LIST_LIMIT = 1000
+
+	TotalCountHeader = "X-Total-Count"
+)
@@ -0,0 +1,21 @@
+package pipeline
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/c2h5oh/hide"
+)
+
+// SeedlingSecretsDir is where a seedling's secrets are written. It is
+// mounted read-only at /secrets in the seedling's container.
+func SeedlingSecretsDir(repoDir string) string {
+	return filepath.Join(repoDir, "secrets")
+}
+
+func (s *Pipeline) SecretNames(ctx context.Context, seedlingID hide.Int64) ([]string, error) {
+	names := []string{}
+	err := s.DB.SelectContext(ctx, &names,
+		"SELECT name FROM seedling_secrets WHERE seedling_id = $1 ORDER BY name", seedlingID)
+	return names, err
+}
@@ -0,0 +1,8 @@
+package pipeline
+
+const (
+	// DBFile is the database and LockFile the instance's lock, under
+	// DATA_DIR.
+	DBFile   = "garden.sqlite3"
+	LockFile = "garden.lock"
+)
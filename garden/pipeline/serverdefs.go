@@ -0,0 +1,308 @@
+package pipeline
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// The generated code quoted in the server step's prompt may take up
+// 1/SERVER_DEFS_CONTEXT_SHARE of its model's context.
+const SERVER_DEFS_CONTEXT_SHARE = 3
+
+// Each packing level quotes less of the generated code than the one before,
+// until the definitions fit their budget.
+const (
+	packFull = iota
+	packNoComments
+	packNoTags
+	packDirectOnly
+)
+
+var packLevelNames = []string{"full", "no comments", "no comments or tags", "direct types only"}
+
+// gofmtConfig prints declarations aligned the way gofmt does.
+var gofmtConfig = &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+// goDecl is a declaration in a generated file, with how far it is from the
+// service interfaces: 0 for the interfaces themselves, 1 for the types they
+// use and so on. Depth is -1 for declarations that aren't used.
+type goDecl struct {
+	name  string
+	decl  ast.Decl
+	depth int
+}
+
+// generatedFile is a parsed pb.go or _grpc.pb.go file.
+type generatedFile struct {
+	fset  *token.FileSet
+	file  *ast.File
+	decls map[string]*goDecl
+	// enumValues are the const blocks declaring each enum's values.
+	enumValues map[string]*ast.GenDecl
+}
+
+func parseGeneratedFile(filename string) (*generatedFile, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	g := &generatedFile{fset: fset, file: file, decls: map[string]*goDecl{}, enumValues: map[string]*ast.GenDecl{}}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			switch decl.Tok {
+			case token.TYPE:
+				for _, spec := range decl.Specs {
+					ts := spec.(*ast.TypeSpec)
+					d := decl
+					if len(decl.Specs) > 1 {
+						d = &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{spec}}
+					}
+					g.decls[ts.Name.Name] = &goDecl{name: ts.Name.Name, decl: d, depth: -1}
+				}
+			case token.CONST:
+				for _, spec := range decl.Specs {
+					if vs, ok := spec.(*ast.ValueSpec); ok {
+						if ident, ok := vs.Type.(*ast.Ident); ok {
+							g.enumValues[ident.Name] = decl
+						}
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil && strings.HasPrefix(decl.Name.Name, "Register") {
+				// Only the signature, the body is plumbing.
+				g.decls[decl.Name.Name] = &goDecl{
+					name:  decl.Name.Name,
+					decl:  &ast.FuncDecl{Name: decl.Name, Type: decl.Type},
+					depth: -1,
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+// oneofImplementers maps each oneof interface, e.g. isFoo_Value, to the
+// wrapper structs implementing it, which are never referenced by name.
+func oneofImplementers(files ...*generatedFile) map[string][]string {
+	impls := map[string][]string{}
+	for _, g := range files {
+		for _, decl := range g.file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 || !strings.HasPrefix(fn.Name.Name, "is") {
+				continue
+			}
+			recv := fn.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			if ident, ok := recv.(*ast.Ident); ok {
+				impls[fn.Name.Name] = append(impls[fn.Name.Name], ident.Name)
+			}
+		}
+	}
+	return impls
+}
+
+// resolveDepths marks the service interfaces of the gRPC file and every type
+// they reach, through method signatures, struct fields and oneofs, with its
+// depth.
+func resolveDepths(pb, grpc *generatedFile) {
+	lookup := func(name string) *goDecl {
+		if d, ok := grpc.decls[name]; ok {
+			return d
+		}
+		return pb.decls[name]
+	}
+	queue := []*goDecl{}
+	for name, d := range grpc.decls {
+		if _, ok := d.decl.(*ast.FuncDecl); ok {
+			d.depth = 0
+			continue
+		}
+		// Unexported ones, like echoChatServer, are the stream plumbing.
+		if ast.IsExported(name) && strings.HasSuffix(name, "Server") && !strings.HasPrefix(name, "Unsafe") && !strings.HasPrefix(name, "Unimplemented") {
+			d.depth = 0
+			queue = append(queue, d)
+		}
+	}
+	impls := oneofImplementers(pb, grpc)
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		visit := func(name string) {
+			if next := lookup(name); next != nil && next.depth == -1 {
+				next.depth = d.depth + 1
+				queue = append(queue, next)
+			}
+		}
+		ast.Inspect(d.decl, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok && ident.Name != d.name {
+				visit(ident.Name)
+			}
+			return true
+		})
+		for _, impl := range impls[d.name] {
+			visit(impl)
+		}
+	}
+}
+
+// stripDecl removes what the server doesn't need from a quoted declaration:
+// the internal fields of messages always, comments from packNoComments and
+// field tags from packNoTags.
+func stripDecl(decl ast.Decl, level int) {
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok {
+		return
+	}
+	if level >= packNoComments {
+		gen.Doc = nil
+	}
+	for _, spec := range gen.Specs {
+		ts, ok := spec.(*ast.TypeSpec)
+		if !ok {
+			continue
+		}
+		if level >= packNoComments {
+			ts.Doc, ts.Comment = nil, nil
+		}
+		var list *ast.FieldList
+		switch t := ts.Type.(type) {
+		case *ast.StructType:
+			list = t.Fields
+		case *ast.InterfaceType:
+			list = t.Methods
+		default:
+			continue
+		}
+		fields := list.List[:0]
+		for _, field := range list.List {
+			if _, ok := ts.Type.(*ast.StructType); ok && len(field.Names) > 0 && !field.Names[0].IsExported() {
+				continue
+			}
+			if level >= packNoComments {
+				field.Doc, field.Comment = nil, nil
+			}
+			if level >= packNoTags {
+				field.Tag = nil
+			}
+			fields = append(fields, field)
+		}
+		list.List = fields
+	}
+}
+
+// render quotes the declarations of the file used by the service in full, in
+// source order, followed by the names of the rest.
+func (g *generatedFile) render(level int) (string, error) {
+	quoted := []*goDecl{}
+	others := []string{}
+	for _, d := range g.decls {
+		full := d.depth >= 0 && (level < packDirectOnly || d.depth <= 1)
+		if full {
+			quoted = append(quoted, d)
+		} else if ast.IsExported(d.name) {
+			others = append(others, d.name)
+		}
+	}
+	sort.Slice(quoted, func(i, j int) bool { return quoted[i].decl.Pos() < quoted[j].decl.Pos() })
+	sort.Strings(others)
+
+	var b strings.Builder
+	for _, d := range quoted {
+		stripDecl(d.decl, level)
+		var node interface{} = d.decl
+		if level == packFull {
+			node = &printer.CommentedNode{Node: d.decl, Comments: g.file.Comments}
+		}
+		var decl bytes.Buffer
+		if err := gofmtConfig.Fprint(&decl, g.fset, node); err != nil {
+			return "", err
+		}
+		// Stripped fields and comments leave blank lines where they were.
+		for _, line := range strings.Split(decl.String(), "\n") {
+			if strings.TrimSpace(line) != "" {
+				b.WriteString(line + "\n")
+			}
+		}
+		b.WriteString("\n")
+		if gen, ok := d.decl.(*ast.GenDecl); ok && gen.Tok == token.TYPE {
+			if values, ok := g.enumValues[d.name]; ok {
+				if err := gofmtConfig.Fprint(&b, g.fset, values); err != nil {
+					return "", err
+				}
+				b.WriteString("\n\n")
+			}
+		}
+	}
+	if len(others) > 0 {
+		b.WriteString("// Also declared: " + strings.Join(others, ", ") + "\n")
+	}
+	return strings.TrimSpace(b.String()) + "\n", nil
+}
+
+// packServerDefs quotes the generated code the server step is prompted with:
+// the service interfaces from the _grpc.pb.go file, the messages and enums
+// they use from the pb.go file, and only the names of everything else. Less
+// is quoted, a level at a time, until the two fit in budget tokens.
+func packServerDefs(pbFile, grpcFile string, budget int) (string, string, error) {
+	var pbDefs, grpcDefs string
+	for level := packFull; level <= packDirectOnly; level++ {
+		// Rendering strips declarations, so each level starts from a
+		// fresh parse.
+		pb, err := parseGeneratedFile(pbFile)
+		if err != nil {
+			return "", "", err
+		}
+		grpc, err := parseGeneratedFile(grpcFile)
+		if err != nil {
+			return "", "", err
+		}
+		resolveDepths(pb, grpc)
+		if pbDefs, err = pb.render(level); err != nil {
+			return "", "", err
+		}
+		if grpcDefs, err = grpc.render(level); err != nil {
+			return "", "", err
+		}
+
+		tokens := llm.EstimateTokens(pbDefs) + llm.EstimateTokens(grpcDefs)
+		log := logrus.WithField("file", pbFile).
+			WithField("packing", packLevelNames[level]).
+			WithField("tokens", tokens).
+			WithField("budget", budget)
+		if tokens <= budget {
+			log.Debug("packed generated code for the server prompt")
+			return pbDefs, grpcDefs, nil
+		}
+		if level == packDirectOnly {
+			log.Warn("generated code for the server prompt is over budget even with only direct types")
+			break
+		}
+		log.Debug("generated code for the server prompt is over budget, quoting less")
+	}
+	return pbDefs, grpcDefs, nil
+}
+
+// serverDefsBudget is how many tokens of generated code the server step's
+// prompt may quote, a share of the context of the model it's written with.
+func (s *Pipeline) serverDefsBudget(seedling store.Seedling) int {
+	model := s.modelChain(SeedlingStepServer, seedling.Model)[0]
+	context := llm.ContextTokens(model)
+	if context == 0 {
+		context = s.Config.ChatContextTokens
+	}
+	return context / SERVER_DEFS_CONTEXT_SHARE
+}
@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/tensorscale/garden/garden/llm"
+)
+
+const (
+	samplePB   = "testdata/serverdefs/echo.pb.go"
+	sampleGRPC = "testdata/serverdefs/echo_grpc.pb.go"
+)
+
+// packedAt is the sample pair rendered at the packing level.
+func packedAt(t *testing.T, level int) (string, string) {
+	t.Helper()
+	pb, err := parseGeneratedFile(samplePB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpc, err := parseGeneratedFile(sampleGRPC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolveDepths(pb, grpc)
+	pbDefs, err := pb.render(level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcDefs, err := grpc.render(level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pbDefs, grpcDefs
+}
+
+func checkQuoted(t *testing.T, label, defs string, want, notWant []string) {
+	t.Helper()
+	for _, s := range want {
+		if !strings.Contains(defs, s) {
+			t.Errorf("%s doesn't have %q:\n%s", label, s, defs)
+		}
+	}
+	for _, s := range notWant {
+		if strings.Contains(defs, s) {
+			t.Errorf("%s has %q:\n%s", label, s, defs)
+		}
+	}
+}
+
+func TestPackServerDefs(t *testing.T) {
+	pbDefs, grpcDefs, err := packServerDefs(samplePB, sampleGRPC, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkQuoted(t, "the gRPC definitions", grpcDefs, []string{
+		"type EchoServer interface {",
+		"	// Say echoes the text it's sent.\n	Say(context.Context, *SayRequest) (*SayReply, error)",
+		"type Echo_ChatServer interface {",
+		"func RegisterEchoServer(s grpc.ServiceRegistrar, srv EchoServer)\n",
+		"// Also declared: EchoClient, Echo_ChatClient, UnimplementedEchoServer, UnsafeEchoServer\n",
+	}, []string{
+		"type EchoClient interface",
+		"type UnimplementedEchoServer struct",
+		"type echoChatServer",
+		"RegisterService",
+		"Echo_ServiceDesc",
+		"_Echo_Say_Handler",
+	})
+	checkQuoted(t, "the message definitions", pbDefs, []string{
+		"// SayRequest is what to say, and to whom.\ntype SayRequest struct {",
+		"	// Text is echoed back.\n	Text string",
+		`json:"text,omitempty"`,
+		"type SayReply struct {",
+		// Through the request's fields, its oneof and their fields.
+		"type Tone int32",
+		"	Tone_TONE_LOUD        Tone = 1",
+		"type Metadata struct {",
+		"type Origin struct {",
+		"type isSayRequest_Target interface {",
+		"type SayRequest_User struct {",
+		"type SayRequest_Room struct {",
+		"// Also declared: Unused\n",
+	}, []string{
+		"type Unused struct",
+		"state ",
+		"sizeCache",
+		"unknownFields",
+		"func (x *SayRequest)",
+		"rawDesc",
+		"Tone_name",
+		"EnforceVersion",
+	})
+}
+
+func TestPackServerDefsBudget(t *testing.T) {
+	tokens := make([]int, len(packLevelNames))
+	for level := range packLevelNames {
+		pbDefs, grpcDefs := packedAt(t, level)
+		tokens[level] = llm.EstimateTokens(pbDefs) + llm.EstimateTokens(grpcDefs)
+		if level > 0 && tokens[level] >= tokens[level-1] {
+			t.Fatalf("packing %s is %d tokens, no fewer than %s", packLevelNames[level], tokens[level], packLevelNames[level-1])
+		}
+	}
+
+	logger := logrus.StandardLogger()
+	level := logger.GetLevel()
+	logger.SetLevel(logrus.DebugLevel)
+	defer logger.SetLevel(level)
+	hooks := logrus.LevelHooks{}
+	for level, hs := range logger.Hooks {
+		hooks[level] = append([]logrus.Hook(nil), hs...)
+	}
+	hook := logtest.NewGlobal()
+	defer logger.ReplaceHooks(hooks)
+
+	tests := []struct {
+		budget int
+		want   string
+		// quoted and stripped are in and not in the packed definitions.
+		quoted, stripped []string
+	}{
+		{tokens[packFull], "full", []string{"// Text is echoed back.", `json:"text,omitempty"`}, nil},
+		{tokens[packFull] - 1, "no comments", []string{`json:"text,omitempty"`, "type Origin struct"}, []string{"// Text is echoed back.", "// Say echoes"}},
+		{tokens[packNoTags], "no comments or tags", []string{"type Origin struct", "	Text string\n"}, []string{`json:"`, "protobuf_oneof"}},
+		{tokens[packNoTags] - 1, "direct types only", []string{"type SayRequest struct", "type SayReply struct", "type EchoServer interface",
+			"// Also declared: Metadata, Origin, SayRequest_Room, SayRequest_User, Tone, Unused\n"}, []string{"type Origin struct", "type Tone int32"}},
+		// Over budget at every level, the least is quoted.
+		{1, "direct types only", []string{"type SayRequest struct"}, []string{"type Metadata struct"}},
+	}
+	for _, tt := range tests {
+		hook.Reset()
+		pbDefs, grpcDefs, err := packServerDefs(samplePB, sampleGRPC, tt.budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkQuoted(t, "packing "+tt.want, pbDefs+grpcDefs, tt.quoted, tt.stripped)
+
+		// Every level tried is logged, ending with the one used.
+		entries := hook.AllEntries()
+		if len(entries) == 0 {
+			t.Fatalf("budget %d: packing wasn't logged", tt.budget)
+		}
+		last := entries[len(entries)-1]
+		if last.Data["packing"] != tt.want || last.Data["budget"] != tt.budget {
+			t.Errorf("budget %d: logged packing %v within %v, want %s", tt.budget, last.Data["packing"], last.Data["budget"], tt.want)
+		}
+		if tt.budget == 1 && last.Level != logrus.WarnLevel {
+			t.Errorf("packing over budget logged at %s", last.Level)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	// sourceFileHeader starts each file in the code of a step that writes
+	// several, e.g. "// file: server/handlers.go".
+	sourceFileHeader = "// file: "
+	// MAX_MANIFEST_ATTEMPTS is how many times the model is asked for a
+	// multi-file server's manifest before it's written as one file.
+	MAX_MANIFEST_ATTEMPTS = 3
+)
+
+var (
+	serverFileNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*\.go$`)
+	sourceFileRegex     = regexp.MustCompile(`^` + sourceFileHeader + `(\S+)$`)
+)
+
+// sourceFile is a file a step writes, relative to the repo, and its code.
+type sourceFile struct {
+	path string
+	code string
+}
+
+// joinSourceFiles is the code of files as one text, each file's after a
+// sourceFileHeader line. A single file's is just its code.
+func joinSourceFiles(files []sourceFile) string {
+	if len(files) == 1 {
+		return files[0].code
+	}
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(sourceFileHeader + f.path + "\n" + strings.TrimRight(f.code, "\n") + "\n\n")
+	}
+	return b.String()
+}
+
+// splitSourceFiles undoes joinSourceFiles, nil if code has no headers.
+func splitSourceFiles(code string) []sourceFile {
+	var files []sourceFile
+	for _, line := range strings.SplitAfter(code, "\n") {
+		if m := sourceFileRegex.FindStringSubmatch(strings.TrimRight(line, "\n")); m != nil {
+			files = append(files, sourceFile{path: m[1]})
+			continue
+		}
+		if n := len(files); n > 0 {
+			files[n-1].code += line
+		}
+	}
+	for i := range files {
+		files[i].code = strings.TrimRight(files[i].code, "\n") + "\n"
+	}
+	return files
+}
+
+// ServerFile is a file of a server written file by file: its name in
+// server/, what the manifest says it's for and the code written so far.
+type ServerFile struct {
+	Path    string `json:"path"`
+	Purpose string `json:"purpose"`
+	Code    string `json:"-"`
+}
+
+// serverFiles are the files of a server written file by file, main.go
+// first, and the error reports of the ones to write again, by path.
+type serverFiles struct {
+	files []ServerFile
+	fixes map[string]string
+}
+
+func (sf *serverFiles) sources() []sourceFile {
+	files := make([]sourceFile, len(sf.files))
+	for i, f := range sf.files {
+		files[i] = sourceFile{path: "server/" + f.Path, code: f.Code}
+	}
+	return files
+}
+
+// code is the files' code as one text, see joinSourceFiles.
+func (sf *serverFiles) code() string {
+	return joinSourceFiles(sf.sources())
+}
+
+// setCode replaces the files' code with code as joined by code(), e.g. code
+// a human accepted.
+func (sf *serverFiles) setCode(code string) {
+	purposes := map[string]string{}
+	for _, f := range sf.files {
+		purposes[f.Path] = f.Purpose
+	}
+	files := splitSourceFiles(code)
+	if files == nil {
+		files = []sourceFile{{path: "server/main.go", code: code}}
+	}
+	sf.files = nil
+	for _, f := range files {
+		name := strings.TrimPrefix(f.path, "server/")
+		sf.files = append(sf.files, ServerFile{Path: name, Purpose: purposes[name], Code: f.code})
+	}
+	sf.fixes = nil
+}
+
+// implicate files the build's errors under the files they're in, so that
+// only those are written again, and returns the fix prompt of the whole
+// server and its kind. Errors that don't point into a file are every file's
+// to fix.
+func (sf *serverFiles) implicate(output string, lines int) (string, string) {
+	sf.fixes = map[string]string{}
+	kind := FixPromptDiagnostics
+	diags := ParseDiagnostics(output)
+	if len(diags) > MAX_DIAGNOSTICS {
+		diags = diags[:MAX_DIAGNOSTICS]
+	}
+	for _, d := range diags {
+		for _, f := range sf.files {
+			if !sameFile(d.File, "server/"+f.Path) {
+				continue
+			}
+			if sf.fixes[f.Path] == "" {
+				sf.fixes[f.Path] = "It got these errors:\n\n"
+			}
+			sf.fixes[f.Path] += d.String() + "\n"
+			if excerpt := sourceExcerpt(f.Code, d.Line); excerpt != "" {
+				sf.fixes[f.Path] += "```\n" + excerpt + "```\n"
+			}
+		}
+	}
+	if len(sf.fixes) == 0 {
+		var report string
+		report, kind = errorReport(sf.code(), "server", output, lines)
+		sf.fixAll(report)
+	}
+	names := []string{}
+	reports := ""
+	for _, f := range sf.files {
+		if report := sf.fixes[f.Path]; report != "" {
+			names = append(names, "server/"+f.Path)
+			reports += "server/" + f.Path + ": " + report + "\n"
+		}
+	}
+	versions := "versions of " + strings.Join(names, ", ") + " that fix"
+	if len(names) == 1 {
+		versions = "a version of " + names[0] + " that fixes"
+	}
+	return "That code didn't work.\n\n" + reports + "Write " + versions + " those errors.\n", kind
+}
+
+// fixAll has every file written again to fix report.
+func (sf *serverFiles) fixAll(report string) {
+	sf.fixes = map[string]string{}
+	for _, f := range sf.files {
+		sf.fixes[f.Path] = report
+	}
+}
+
+// multiFileServer reports whether the seedling's server is big enough to be
+// written file by file, having SERVER_MULTI_FILE_RPCS methods or more.
+func (s *Pipeline) multiFileServer(seedling store.Seedling) bool {
+	if s.Config.ServerMultiFileRPCs <= 0 {
+		return false
+	}
+	_, services, err := GRPCServices(filepath.Join(s.RepoDir(seedling), "protobufs", seedling.Name+"_grpc.pb.go"))
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to read gRPC services")
+		return false
+	}
+	methods := 0
+	for _, service := range services {
+		methods += len(service.Methods)
+	}
+	return methods >= s.Config.ServerMultiFileRPCs
+}
+
+// parseServerManifest reads the first JSON manifest of at most maxFiles
+// server files in text, with main.go moved first.
+func parseServerManifest(text string, maxFiles int) ([]ServerFile, error) {
+	err := errors.New("no JSON object in response")
+	for i := strings.IndexByte(text, '{'); i != -1; i = NextObject(text, i) {
+		var manifest struct {
+			Files []ServerFile `json:"files"`
+		}
+		if decodeErr := json.NewDecoder(strings.NewReader(text[i:])).Decode(&manifest); decodeErr != nil {
+			err = fmt.Errorf("invalid JSON: %w", decodeErr)
+			continue
+		}
+		if err = checkServerManifest(manifest.Files, maxFiles); err != nil {
+			continue
+		}
+		sort.SliceStable(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path == "main.go" })
+		return manifest.Files, nil
+	}
+	return nil, err
+}
+
+func checkServerManifest(files []ServerFile, maxFiles int) error {
+	if len(files) == 0 {
+		return errors.New(`missing "files"`)
+	}
+	if len(files) > maxFiles {
+		return fmt.Errorf("%d files, more than the %d allowed", len(files), maxFiles)
+	}
+	seen := map[string]bool{}
+	for i := range files {
+		files[i].Path = strings.TrimPrefix(path.Clean(files[i].Path), "server/")
+		name := files[i].Path
+		if !serverFileNameRegex.MatchString(name) || strings.HasSuffix(name, "_test.go") {
+			return fmt.Errorf("%q isn't a lowercase Go file name in server/", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("%q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	if !seen["main.go"] {
+		return errors.New("main.go isn't listed")
+	}
+	return nil
+}
+
+// serverManifest asks for the files the server is to be written in.
+// Without a valid manifest after MAX_MANIFEST_ATTEMPTS, it's written as
+// one.
+func (s *Pipeline) serverManifest(ctx context.Context, seedling store.Seedling, base string, temperature float32) ([]ServerFile, error) {
+	prompt := fmt.Sprintf(`%s
+The server is too big to write in one go, so it'll be written one file at a
+time, every file in package main in the server directory. First, plan its
+files: at most %d, including main.go, which starts the gRPC and HTTP servers
+and has the Environment comment block if there is one. Split the RPC handlers
+between the other files by what they do.
+
+Output exactly one JSON object like this one:
+
+`+"```"+`json
+{"files": [{"path": "main.go", "purpose": "starts the gRPC and HTTP servers"}, {"path": "images.go", "purpose": "the image RPC handlers"}]}
+`+"```"+`
+
+`+"```json\n", base, s.Config.ServerMaxFiles)
+	for i := 0; i < MAX_MANIFEST_ATTEMPTS; i++ {
+		text, _, err := s.completeSeedlingText(ctx, seedling, SeedlingStepServerManifest, prompt, temperature)
+		if err != nil {
+			return nil, err
+		}
+		files, err := parseServerManifest(text, s.Config.ServerMaxFiles)
+		if err == nil {
+			return files, nil
+		}
+		logrus.WithField("error", err).Warn("invalid server manifest")
+		prompt += strings.TrimSpace(text) + "\n```\n\nThat wasn't a valid plan (" + err.Error() + ")." +
+			` Respond with exactly one JSON object with "files", and nothing else.` + "\n```json\n"
+	}
+	logrus.WithField("name", seedling.Name).Warn("no valid server manifest, writing the server in one file")
+	return []ServerFile{{Path: "main.go", Purpose: "the whole server"}}, nil
+}
+
+// completeServerFiles writes the files of a server written file by file
+// that don't have code or have errors to fix, each in its own completion,
+// and returns the code of them all. sf is made with a new manifest if it's
+// nil.
+func (s *Pipeline) completeServerFiles(ctx context.Context, seedling store.Seedling, base string, sf **serverFiles, temperature float32) (string, llm.CompletionOptions, error) {
+	var opts llm.CompletionOptions
+	if *sf == nil {
+		files, err := s.serverManifest(ctx, seedling, base, temperature)
+		if err != nil {
+			return "", opts, err
+		}
+		*sf = &serverFiles{files: files}
+	}
+	files := *sf
+	all := len(files.fixes) == 0
+	for i := range files.files {
+		f := &files.files[i]
+		fix := files.fixes[f.Path]
+		if !all && fix == "" && f.Code != "" {
+			continue
+		}
+		text, o, err := s.completeSeedlingText(ctx, seedling, SeedlingStepServerFile, serverFilePrompt(base, files.files, i, fix), temperature)
+		if err != nil {
+			return "", opts, err
+		}
+		opts = o
+		f.Code = attemptCode(text, "go")
+	}
+	files.fixes = nil
+	return files.code(), opts, nil
+}
+
+// serverFilePrompt asks for the i'th of files, given what the files before
+// it declare, or to fix it if fix is set, given what all the others do.
+func serverFilePrompt(base string, files []ServerFile, i int, fix string) string {
+	var b strings.Builder
+	b.WriteString(base + "\nThe server is split into these files in the server directory, all package main:\n\n")
+	for _, f := range files {
+		b.WriteString("- server/" + f.Path + ": " + f.Purpose + "\n")
+	}
+	decls := ""
+	for j, f := range files {
+		if j == i || f.Code == "" || (fix == "" && j > i) {
+			continue
+		}
+		if sigs := fileSignatures(f.Code); sigs != "" {
+			decls += sourceFileHeader + "server/" + f.Path + "\n" + sigs
+		}
+	}
+	if decls != "" {
+		b.WriteString("\nThe other files declare these, which server/" + files[i].Path +
+			" can use and mustn't declare again:\n\n```go\n" + decls + "```\n")
+	}
+	name := "server/" + files[i].Path
+	if fix != "" {
+		b.WriteString("\nThis is " + name + ":\n\n```go\n" + files[i].Code + "```\n\nThat code didn't work.\n\n" +
+			fix + "\nWrite a version of " + name + " that fixes that. Write only the code.\n")
+	} else {
+		b.WriteString("\nNow write " + name + ", which has " + files[i].Purpose + ". Write only the code.\n")
+	}
+	b.WriteString("```go\n")
+	return b.String()
+}
+
+// fileSignatures is what Go code declares for the rest of its package: its
+// types, constants and variables, and the signatures of its functions and
+// methods. In package main that's every top-level declaration, exported or
+// not. Code that doesn't parse declares what could be parsed of it.
+func fileSignatures(code string) string {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "", code, 0)
+	if file == nil {
+		return ""
+	}
+	var b bytes.Buffer
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			decl = &ast.FuncDecl{Recv: decl.Recv, Name: decl.Name, Type: decl.Type}
+			gofmtConfig.Fprint(&b, fset, decl)
+		case *ast.GenDecl:
+			if decl.Tok == token.IMPORT {
+				continue
+			}
+			if decl.Tok == token.VAR {
+				for _, spec := range decl.Specs {
+					if vs, ok := spec.(*ast.ValueSpec); ok && vs.Type != nil {
+						vs.Values = nil
+					}
+				}
+			}
+			gofmtConfig.Fprint(&b, fset, decl)
+		default:
+			continue
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// serverSource is the seedling's server code as the model is shown it:
+// server/main.go, followed by the other files of the server if it was
+// written file by file.
+func serverSource(dir string) (string, error) {
+	paths, err := serverFilePaths(dir)
+	if err != nil {
+		return "", err
+	}
+	files := []sourceFile{}
+	for _, p := range paths {
+		code, err := ioutil.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files = append(files, sourceFile{path: filepath.ToSlash(rel), code: string(code)})
+	}
+	return joinSourceFiles(files), nil
+}
+
+// serverFilePaths are the server's Go files in dir, main.go first, not
+// counting its tests.
+func serverFilePaths(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "server", "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, p := range paths {
+		if !strings.HasSuffix(p, "_test.go") {
+			files = append(files, p)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return filepath.Base(files[i]) == "main.go" })
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", filepath.Join(dir, "server"))
+	}
+	return files, nil
+}
+
+// removeStaleServerFiles removes the server's Go files that files don't
+// write, e.g. the ones of an earlier manifest, except tests.
+func removeStaleServerFiles(dir string, files []sourceFile) error {
+	keep := map[string]bool{}
+	for _, f := range files {
+		keep[filepath.Join(dir, filepath.FromSlash(f.path))] = true
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "server", "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if keep[p] || strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
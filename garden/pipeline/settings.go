@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// PipelineSettings are the build pipeline's knobs admins can turn while
+// garden runs, with PUT /api/v1/admin/settings. A build reads them as each
+// attempt starts, so an attempt runs with the values it started with.
+type PipelineSettings struct {
+	// MaxErrs is how many failed attempts in a row a step gets before it's
+	// started over, and MaxRuns how many times before the build gives up.
+	MaxErrs   int `json:"maxErrs"`
+	MaxRuns   int `json:"maxRuns"`
+	MaxNudges int `json:"maxNudges"`
+	// QualityCheckMaxErrs is how many quality checks that can't be parsed
+	// the server's code gets.
+	QualityCheckMaxErrs int `json:"qualityCheckMaxErrs"`
+	// Temperature is the first attempt's at a step, and goes down by
+	// TemperatureDecay with each failed attempt after it.
+	Temperature      float64 `json:"temperature"`
+	TemperatureDecay float64 `json:"temperatureDecay"`
+	// ErrorOutputLines is how much of a failed build's output fix prompts
+	// quote.
+	ErrorOutputLines int `json:"errorOutputLines"`
+	// BuildWorkers is how many builds run at once.
+	BuildWorkers int `json:"buildWorkers"`
+	// RestartOnEdit, at 1, restarts a build from the step it started at
+	// when the seedling's description or generation parameters are changed
+	// while it runs. At 0 the build carries on with what it started with
+	// and the seedling is marked StaleDescription.
+	RestartOnEdit int `json:"restartOnEdit"`
+}
+
+func DefaultPipelineSettings(config Config) PipelineSettings {
+	return PipelineSettings{
+		MaxErrs:             3,
+		MaxRuns:             5,
+		MaxNudges:           2,
+		QualityCheckMaxErrs: 5,
+		Temperature:         1.0,
+		TemperatureDecay:    0.2,
+		ErrorOutputLines:    ERROR_OUTPUT_LINES,
+		BuildWorkers:        config.BuildWorkers,
+	}
+}
+
+// temperature is what an attempt after errs failed ones is made with.
+func (p PipelineSettings) temperature(errs int) float32 {
+	return float32(math.Max(p.Temperature-float64(errs)*p.TemperatureDecay, 0))
+}
+
+// SettingRange is the values a setting may be set to. Integer settings
+// are only set to whole numbers.
+type SettingRange struct {
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Integer bool    `json:"integer"`
+}
+
+// SettingRanges is every setting by its key, the json name of its
+// PipelineSettings field.
+var SettingRanges = map[string]SettingRange{
+	"maxErrs":             {Min: 1, Max: 50, Integer: true},
+	"maxRuns":             {Min: 2, Max: 20, Integer: true},
+	"maxNudges":           {Min: 0, Max: 10, Integer: true},
+	"qualityCheckMaxErrs": {Min: 1, Max: 20, Integer: true},
+	"temperature":         {Min: 0, Max: store.MaxTemperature},
+	"temperatureDecay":    {Min: 0, Max: 1},
+	"errorOutputLines":    {Min: 5, Max: MAX_ERROR_OUTPUT_LINES, Integer: true},
+	"buildWorkers":        {Min: 1, Max: 64, Integer: true},
+	"restartOnEdit":       {Min: 0, Max: 1, Integer: true},
+}
+
+func (r SettingRange) Check(value float64) string {
+	if r.Integer && value != math.Trunc(value) {
+		return "must be a whole number"
+	}
+	if value < r.Min || value > r.Max {
+		return fmt.Sprintf("must be between %s and %s", formatSetting(r.Min), formatSetting(r.Max))
+	}
+	return ""
+}
+
+func formatSetting(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Values is the settings by key.
+func (p PipelineSettings) Values() map[string]float64 {
+	data, _ := json.Marshal(p)
+	values := map[string]float64{}
+	json.Unmarshal(data, &values)
+	return values
+}
+
+// with is the settings with the values by key replaced.
+func (p PipelineSettings) with(values map[string]float64) PipelineSettings {
+	merged := p.Values()
+	for key, value := range values {
+		merged[key] = value
+	}
+	data, _ := json.Marshal(merged)
+	json.Unmarshal(data, &p)
+	return p
+}
+
+// Settings caches the pipeline's settings, which are stored in the settings
+// table over their defaults. Only changes made through this process are
+// seen by it before it restarts.
+type Settings struct {
+	Defaults PipelineSettings
+	// Updates serializes changes, so each is recorded against the values
+	// it replaced.
+	Updates sync.Mutex
+
+	mu       sync.RWMutex
+	current  PipelineSettings
+	onChange []func(PipelineSettings)
+}
+
+func NewSettings(defaults PipelineSettings) *Settings {
+	return &Settings{Defaults: defaults, current: defaults}
+}
+
+// Current is the settings as they are now.
+func (st *Settings) Current() PipelineSettings {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.current
+}
+
+// OnChange calls fn with the new settings after every change.
+func (st *Settings) OnChange(fn func(PipelineSettings)) {
+	st.mu.Lock()
+	st.onChange = append(st.onChange, fn)
+	st.mu.Unlock()
+}
+
+// Set replaces the values by key, notifying of the change.
+func (st *Settings) Set(values map[string]float64) PipelineSettings {
+	st.mu.Lock()
+	st.current = st.current.with(values)
+	current, onChange := st.current, append([]func(PipelineSettings){}, st.onChange...)
+	st.mu.Unlock()
+	for _, fn := range onChange {
+		fn(current)
+	}
+	return current
+}
+
+// LoadSettings reads the stored settings. Ones that are no longer valid,
+// e.g. for a setting that was removed, are left at their defaults.
+func (s *Pipeline) LoadSettings(ctx context.Context) error {
+	rows := []struct {
+		Key   string  `db:"key"`
+		Value float64 `db:"value"`
+	}{}
+	if err := s.DB.SelectContext(ctx, &rows, "SELECT key, value FROM settings"); err != nil {
+		return err
+	}
+	values := map[string]float64{}
+	for _, row := range rows {
+		r, ok := SettingRanges[row.Key]
+		if !ok || r.Check(row.Value) != "" {
+			logrus.WithField("key", row.Key).Warn("ignoring invalid stored setting")
+			continue
+		}
+		values[row.Key] = row.Value
+	}
+	s.Settings.Set(values)
+	return nil
+}
+
+type settingsKey struct{}
+
+// withSettings makes what's done with ctx use the settings, so an attempt
+// keeps the ones it started with.
+func withSettings(ctx context.Context, settings PipelineSettings) context.Context {
+	return context.WithValue(ctx, settingsKey{}, settings)
+}
+
+// pipelineSettings is the settings of ctx, or the current ones.
+func (s *Pipeline) pipelineSettings(ctx context.Context) PipelineSettings {
+	if settings, ok := ctx.Value(settingsKey{}).(PipelineSettings); ok {
+		return settings
+	}
+	return s.Settings.Current()
+}
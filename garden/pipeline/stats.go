@@ -0,0 +1,22 @@
+package pipeline
+
+import (
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// SeedlingSteps is the ordered list of steps the pipeline runs for seedling.
+func SeedlingSteps(seedling store.Seedling) []string {
+	steps := []string{
+		SeedlingStepProtobufs,
+		SeedlingStepServer,
+	}
+	if !seedling.SkipTests {
+		steps = append(steps, SeedlingStepServerTests)
+	}
+	return append(steps,
+		SeedlingStepOpenAPI,
+		SeedlingStepDockerfile,
+		SeedlingStepExampleClientCall,
+		SeedlingStepComplete,
+	)
+}
@@ -0,0 +1,597 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// The statuses of a seedling's steps, stored in seedling_step_statuses.
+const (
+	StepStatusPending = "pending"
+	StepStatusRunning = "running"
+	// StepStatusFixing is a step whose last attempt failed, being fixed.
+	StepStatusFixing    = "fixing"
+	StepStatusSucceeded = "succeeded"
+	StepStatusFailed    = "failed"
+	// StepStatusBlocked is reported for a pending step waiting on one that's
+	// being fixed or failed. It isn't stored.
+	StepStatusBlocked = "blocked"
+)
+
+var (
+	// stepDependencies are the steps each step builds on. A dependency a
+	// seedling's pipeline doesn't have is replaced by its own dependencies.
+	// SeedlingStepComplete depends on every other step.
+	stepDependencies = map[string][]string{
+		SeedlingStepServer:            {SeedlingStepProtobufs},
+		SeedlingStepServerTests:       {SeedlingStepServer},
+		SeedlingStepOpenAPI:           {SeedlingStepServer},
+		SeedlingStepDockerfile:        {SeedlingStepServerTests},
+		SeedlingStepExampleClientCall: {SeedlingStepServer},
+	}
+
+	// branchSteps only read the server code, so rather than waiting on the
+	// image to build each runs on its own, alongside the rest of the build,
+	// once the steps it depends on have succeeded.
+	branchSteps = map[string]bool{
+		SeedlingStepOpenAPI:           true,
+		SeedlingStepExampleClientCall: true,
+	}
+)
+
+// stepAncestors returns every step the step builds on, directly or not.
+func stepAncestors(step string) map[string]bool {
+	ancestors := map[string]bool{}
+	var visit func(string)
+	visit = func(step string) {
+		for _, dep := range stepDependencies[step] {
+			if !ancestors[dep] {
+				ancestors[dep] = true
+				visit(dep)
+			}
+		}
+	}
+	visit(step)
+	return ancestors
+}
+
+// StepDeps returns the steps each of steps depends on among steps.
+func StepDeps(steps []string) map[string][]string {
+	in := map[string]bool{}
+	for _, step := range steps {
+		in[step] = true
+	}
+	var resolve func(string) []string
+	resolve = func(step string) []string {
+		deps := []string{}
+		for _, dep := range stepDependencies[step] {
+			if in[dep] {
+				deps = append(deps, dep)
+			} else {
+				deps = append(deps, resolve(dep)...)
+			}
+		}
+		return deps
+	}
+	deps := map[string][]string{}
+	for _, step := range steps {
+		if step != SeedlingStepComplete {
+			deps[step] = resolve(step)
+			deps[SeedlingStepComplete] = append(deps[SeedlingStepComplete], step)
+		}
+	}
+	return deps
+}
+
+// stepGraph is the steps of a build: where each of them is, and the branch
+// steps running alongside Build's loop through the others.
+type stepGraph struct {
+	s        *Pipeline
+	ctx      context.Context
+	seedling store.Seedling
+	steps    []string
+	deps     map[string][]string
+
+	mu       sync.Mutex
+	status   map[string]string
+	branches map[string]*branchRun
+	// step is the seedling's Step as last written.
+	step string
+}
+
+// branchRun is a run of a branch step.
+type branchRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	prompt string
+	// deferred is set on a run that didn't get a worker, which the build
+	// runs itself once it's waiting on the branches.
+	deferred bool
+	err      error
+}
+
+// newStepGraph starts the build's steps at steps[start], the steps before it
+// having succeeded.
+func (s *Pipeline) newStepGraph(ctx context.Context, seedling store.Seedling, steps []string, start int) (*stepGraph, error) {
+	g := &stepGraph{
+		s:        s,
+		ctx:      ctx,
+		seedling: seedling,
+		steps:    steps,
+		deps:     StepDeps(steps),
+		status:   map[string]string{},
+		branches: map[string]*branchRun{},
+		step:     seedling.Step,
+	}
+	now := time.Now()
+	err := s.InTx(ctx, func(tx *sqlx.Tx) error {
+		for i, step := range steps {
+			if i < start {
+				g.status[step] = StepStatusSucceeded
+				// Seedlings built before steps had statuses have no rows.
+				if _, err := tx.ExecContext(ctx, `
+				 INSERT INTO seedling_step_statuses (seedling_id, step, status, updated_at)
+				 VALUES ($1, $2, $3, $4)
+				 ON CONFLICT (seedling_id, step) DO NOTHING
+				 `, seedling.ID, step, StepStatusSucceeded, now); err != nil {
+					return err
+				}
+				continue
+			}
+			g.status[step] = StepStatusPending
+			if err := resetStepStatus(ctx, tx, seedling.ID, step, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return g, err
+}
+
+func resetStepStatus(ctx context.Context, tx *sqlx.Tx, seedlingID hide.Int64, step string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+	 INSERT INTO seedling_step_statuses (seedling_id, step, status, updated_at)
+	 VALUES ($1, $2, $3, $4)
+	 ON CONFLICT (seedling_id, step) DO UPDATE SET
+	   status = excluded.status,
+	   error = "",
+	   started_at = NULL,
+	   finished_at = NULL,
+	   updated_at = excluded.updated_at
+	 `, seedlingID, step, StepStatusPending, now)
+	return err
+}
+
+// set records the step's status, and moves the seedling's Step on if the
+// first step that hasn't succeeded changed.
+func (g *stepGraph) set(step, status, reason string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status[step] = status
+	now := time.Now()
+	var startedAt, finishedAt *time.Time
+	switch status {
+	case StepStatusRunning, StepStatusFixing:
+		startedAt = &now
+	case StepStatusSucceeded, StepStatusFailed:
+		startedAt, finishedAt = &now, &now
+	}
+	if _, err := g.s.DB.ExecContext(g.ctx, `
+	 INSERT INTO seedling_step_statuses (seedling_id, step, status, error, started_at, finished_at, updated_at)
+	 VALUES ($1, $2, $3, $4, $5, $6, $7)
+	 ON CONFLICT (seedling_id, step) DO UPDATE SET
+	   status = excluded.status,
+	   error = excluded.error,
+	   started_at = COALESCE(seedling_step_statuses.started_at, excluded.started_at),
+	   finished_at = excluded.finished_at,
+	   updated_at = excluded.updated_at
+	 `, g.seedling.ID, step, status, reason, startedAt, finishedAt, now); err != nil {
+		return err
+	}
+	return g.syncStep()
+}
+
+// start records that the step is running, unless it's already being fixed.
+func (g *stepGraph) start(step string) {
+	g.mu.Lock()
+	pending := g.status[step] == StepStatusPending
+	g.mu.Unlock()
+	if !pending {
+		return
+	}
+	if err := g.set(step, StepStatusRunning, ""); err != nil {
+		logrus.WithField("error", err).Error("failed to update step status")
+	}
+}
+
+// syncStep writes the first step that hasn't succeeded as the seedling's
+// Step. g.mu must be held.
+func (g *stepGraph) syncStep() error {
+	step := SeedlingStepComplete
+	for _, s := range g.steps {
+		if g.status[s] != StepStatusSucceeded {
+			step = s
+			break
+		}
+	}
+	if step == g.step {
+		return nil
+	}
+	if _, err := g.s.DB.ExecContext(
+		g.ctx,
+		"UPDATE seedlings SET step = $1, step_started_at = $2, version = version + 1 WHERE id = $3",
+		step,
+		time.Now(),
+		g.seedling.ID,
+	); err != nil {
+		return err
+	}
+	g.step = step
+	g.s.Notify(g.ctx, g.seedling, EventStepChanged, step)
+	return nil
+}
+
+// startBranches starts every branch step whose dependencies have all
+// succeeded and that isn't running yet, prompted with the build's prompt so
+// far. A branch that can't get a worker is left for wait.
+func (g *stepGraph) startBranches(prompt string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, step := range g.steps {
+		if !branchSteps[step] || g.status[step] != StepStatusPending || g.branches[step] != nil {
+			continue
+		}
+		ready := true
+		for _, dep := range g.deps[step] {
+			if g.status[dep] != StepStatusSucceeded {
+				ready = false
+			}
+		}
+		if !ready {
+			continue
+		}
+		ctx, cancel := context.WithCancel(g.ctx)
+		run := &branchRun{ctx: ctx, cancel: cancel, done: make(chan struct{}), prompt: prompt}
+		g.branches[step] = run
+		if !g.s.Scheduler.TryReserve() {
+			run.deferred = true
+			continue
+		}
+		go func(step string) {
+			defer g.s.Scheduler.Release()
+			g.runBranch(step, run)
+		}(step)
+	}
+}
+
+func (g *stepGraph) runBranch(step string, run *branchRun) {
+	defer close(run.done)
+	defer run.cancel()
+	run.err = g.runStep(run.ctx, step, run.prompt)
+	if run.ctx.Err() != nil {
+		// Stopped with the build, or to be run again.
+		return
+	}
+	status, reason := StepStatusSucceeded, ""
+	if run.err != nil {
+		status, reason = StepStatusFailed, run.err.Error()
+		logrus.WithField("name", g.seedling.Name).
+			WithField("step", step).
+			WithField("error", run.err).
+			Error("branch step failed")
+	}
+	if err := g.set(step, status, reason); err != nil {
+		logrus.WithField("error", err).Error("failed to update step status")
+	}
+}
+
+// wait runs the branches that didn't get a worker and waits for the rest,
+// returning why the first one in the pipeline to fail failed.
+func (g *stepGraph) wait() error {
+	for _, step := range g.steps {
+		g.mu.Lock()
+		run := g.branches[step]
+		g.mu.Unlock()
+		if run == nil {
+			continue
+		}
+		if run.deferred {
+			run.deferred = false
+			g.runBranch(step, run)
+		}
+		<-run.done
+		if run.err != nil {
+			return fmt.Errorf("%s failed: %w", step, run.err)
+		}
+	}
+	return nil
+}
+
+// rerun has the step and every step after it that depends on it run again,
+// stopping the branches among them.
+func (g *stepGraph) rerun(step string) error {
+	rerun := map[string]bool{step: true}
+	for _, s := range g.steps {
+		for _, dep := range g.deps[s] {
+			if rerun[dep] {
+				rerun[s] = true
+			}
+		}
+	}
+	g.mu.Lock()
+	runs := []*branchRun{}
+	for s, run := range g.branches {
+		if rerun[s] {
+			runs = append(runs, run)
+			delete(g.branches, s)
+		}
+	}
+	g.mu.Unlock()
+	stopBranches(runs)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	err := g.s.InTx(g.ctx, func(tx *sqlx.Tx) error {
+		for _, s := range g.steps {
+			if rerun[s] {
+				g.status[s] = StepStatusPending
+				if err := resetStepStatus(g.ctx, tx, g.seedling.ID, s, now); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return g.syncStep()
+}
+
+// stop stops the branches still running, before the build returns.
+func (g *stepGraph) stop() {
+	g.mu.Lock()
+	runs := []*branchRun{}
+	for _, run := range g.branches {
+		runs = append(runs, run)
+	}
+	g.branches = map[string]*branchRun{}
+	g.mu.Unlock()
+	stopBranches(runs)
+}
+
+func stopBranches(runs []*branchRun) {
+	for _, run := range runs {
+		run.cancel()
+		if !run.deferred {
+			<-run.done
+		}
+	}
+}
+
+// branchStep is the instructions of a branch step, the file it writes and
+// the command that verifies it, if there is one.
+type branchStep struct {
+	prompt   string
+	repoPath string
+	codeType string
+	cmd      string
+	cmdArgs  []string
+}
+
+// scriptCheck is the command that verifies a generated shell script: bash
+// -n, then shellcheck if it's installed, for errors only.
+func scriptCheck(file string) (string, []string) {
+	line := "bash -n " + file
+	if _, err := exec.LookPath("shellcheck"); err == nil {
+		line += " && shellcheck --shell=bash --severity=error " + file
+	}
+	return "sh", []string{"-c", line}
+}
+
+func (s *Pipeline) branchStep(seedling store.Seedling, step, prompt string) (branchStep, error) {
+	ports := seedling.SeedlingPorts.WithDefaults()
+	serverContents, err := serverSource(s.RepoDir(seedling))
+	if err != nil {
+		return branchStep{}, fmt.Errorf("failed to read server code: %w", err)
+	}
+	switch step {
+	case SeedlingStepOpenAPI:
+		return branchStep{
+			prompt:   fmt.Sprintf(openAPIPrompt, prompt, ports.HTTPContainerPort, ports.HTTPContainerPort, serverContents),
+			repoPath: "openapi.yaml",
+			codeType: "yaml",
+		}, nil
+	case SeedlingStepExampleClientCall:
+		cmd, args := scriptCheck(ExampleCallFile)
+		return branchStep{
+			prompt: fmt.Sprintf(`%s
+Now write me a bash script with an example client call with curl to the HTTP
+service. It's running on the host in the GARDEN_HTTP_HOST environment
+variable, localhost if it's unset, on the port in GARDEN_HTTP_PORT, which the
+script must fail without:
+
+`+"```"+`
+host="${GARDEN_HTTP_HOST:-localhost}"
+port="${GARDEN_HTTP_PORT:?set GARDEN_HTTP_PORT to the HTTP port of the service}"
+`+"```"+`
+
+Don't look the port up with docker: the script is run from other machines
+than the one the container is on.
+
+If it needs an input file or multiple input files, pass those in as args. If
+this is true and the args aren't present, error out.
+
+In the script, set:
+
+`+"```"+`
+set -euxo pipefail
+`+"```"+`
+
+Remember, the server code is:
+`+"```go\n%s```", prompt, serverContents),
+			repoPath: ExampleCallFile,
+			codeType: "bash",
+			cmd:      cmd,
+			cmdArgs:  args,
+		}, nil
+	}
+	return branchStep{}, fmt.Errorf("%s isn't a branch step", step)
+}
+
+// runStep runs a branch step to success, fixing it the way Build fixes
+// the others, starting it over after MaxErrs failures in a row and giving
+// up after MaxRuns runs, as the settings are when each attempt starts.
+// before is the build's prompt so far.
+func (g *stepGraph) runStep(ctx context.Context, step, before string) error {
+	s, seedling := g.s, g.seedling
+	spec, err := s.branchStep(seedling, step, before)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(s.RepoDir(seedling), spec.repoPath)
+
+	attempt := 0
+	history, err := s.StepAttempts(ctx, seedling, step)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to get step attempts")
+	}
+	for _, a := range history {
+		if a.Attempt > attempt {
+			attempt = a.Attempt
+		}
+	}
+	g.start(step)
+	for runs := 1; ; runs++ {
+		set := s.Settings.Current()
+		if runs >= set.MaxRuns {
+			return fmt.Errorf("exceeded %d errors in each of %d runs", set.MaxErrs, set.MaxRuns)
+		}
+		s.resetMessages(ctx, seedling.ID, []string{step})
+		prompt := spec.prompt + "```" + spec.codeType + "\n"
+		s.appendMessage(ctx, seedling.ID, step, llm.RoleSystem, stepMessage(before, prompt))
+		failedOutputs := map[string]string{}
+		errs, repeats, nudges := 0, 0, 0
+		for ; errs <= set.MaxErrs; set = s.Settings.Current() {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// openapi.yaml is verified by its pre-build hook, the
+			// example call by its command.
+			name := spec.cmd
+			if name == "" {
+				name = "true"
+			}
+			buildCmd, err := HostRunner{Env: s.BuildEnv()}.Command(ctx, BuildSpec{Dir: s.RepoDir(seedling), Name: name, Args: spec.cmdArgs, Toolchain: seedling.Toolchain})
+			if err != nil {
+				return fmt.Errorf("failed to set up build command: %w", err)
+			}
+			attemptStart := time.Now()
+			temperature := set.temperature(errs)
+			if seedling.Temperature != nil {
+				temperature = *seedling.Temperature
+			}
+			temperature += float32(repeats) * RepeatTemperatureBump
+			if temperature > store.MaxTemperature {
+				temperature = store.MaxTemperature
+			}
+			gptOutput, opts, err := s.complete(ctx, seedling, step, spec.codeType, prompt, temperature)
+			if err != nil {
+				return fmt.Errorf("completion failed: %w", err)
+			}
+			reply := s.appendMessage(ctx, seedling.ID, step, llm.RoleAssistant, gptOutput)
+
+			attempt++
+			code := attemptCode(gptOutput, spec.codeType)
+			hash := outputHash(step, code)
+			var output string
+			var fixes []string
+			var buildDuration time.Duration
+			previous, duplicate := failedOutputs[hash]
+			if duplicate {
+				observeDuplicateOutput(step)
+				output, err = previous, errDuplicateOutput
+			} else {
+				output, fixes, _, buildDuration, err = s.runSeedling(withSettings(ctx, set), file, spec.codeType, buildCmd, gptOutput, step, attempt, prompt, seedling, opts.Model, false)
+			}
+			a := store.Attempt{
+				SeedlingID:       seedling.ID,
+				Step:             step,
+				Attempt:          attempt,
+				Success:          err == nil,
+				Output:           output,
+				BuildDurationMS:  buildDuration.Milliseconds(),
+				DurationMS:       time.Since(attemptStart).Milliseconds(),
+				File:             spec.repoPath,
+				Model:            opts.Model,
+				Temperature:      opts.Temperature,
+				MaxTokens:        opts.MaxTokens,
+				Code:             code,
+				AutoFixes:        strings.Join(fixes, "; "),
+				Duplicate:        duplicate,
+				PromptTokens:     llm.EstimateTokens(prompt),
+				CompletionTokens: llm.EstimateTokens(gptOutput),
+			}
+			if err == nil {
+				if a.CommitSHA, err = RepoHead(ctx, s.RepoDir(seedling)); err != nil {
+					logrus.WithField("error", err).Warn("failed to get seedling repo HEAD")
+					err = nil
+				}
+			}
+			if rerr := s.RecordBranchAttempt(ctx, a); rerr != nil {
+				logrus.WithField("error", rerr).Error("failed to record attempt")
+			} else if a.Success {
+				s.publishAttemptDiff(ctx, seedling, step)
+			}
+			if err == nil {
+				s.acceptMessage(ctx, reply)
+				observeStepAttempts(step, attempt)
+				return nil
+			}
+			s.emitAttemptFailed(ctx, seedling, a, err)
+
+			var fix string
+			switch {
+			case errors.Is(err, errNoCode) && nudges < set.MaxNudges:
+				nudges++
+				fix = "That wasn't code. Output only the code, with no explanation.\n"
+			case duplicate:
+				nudges = 0
+				repeats++
+				errs++
+				fix = duplicateFix(code, filepath.Base(spec.repoPath), output, set.ErrorOutputLines, repeats)
+			default:
+				nudges, repeats = 0, 0
+				errs++
+				failedOutputs[hash] = output
+				if strings.TrimSpace(output) == "" {
+					output = err.Error() + "\n"
+				}
+				report, kind := errorReport(s.asWritten(code, spec.codeType), spec.repoPath, output, set.ErrorOutputLines)
+				observeFixPrompt(step, kind)
+				fix = fixesNote(fixes) + "That code didn't work.\n\n" + report +
+					"\nWrite a version that fixes that error.\n"
+			}
+			if err := g.set(step, StepStatusFixing, err.Error()); err != nil {
+				logrus.WithField("error", err).Error("failed to update step status")
+			}
+			prompt += gptOutput + "```\n\n" + fix
+			s.appendMessage(ctx, seedling.ID, step, llm.RoleUser, fix)
+		}
+		logrus.WithField("name", seedling.Name).WithField("step", step).Error("hit max errs, starting the step over")
+	}
+}
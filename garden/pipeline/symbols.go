@@ -0,0 +1,501 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/store"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	// The symbols quoted in a fix prompt may take up
+	// 1/SYMBOL_DOCS_CONTEXT_SHARE of its model's context.
+	SYMBOL_DOCS_CONTEXT_SHARE = 8
+	// MAX_SYMBOL_SUGGESTIONS is how many of a package's names are suggested
+	// for an identifier it doesn't have.
+	MAX_SYMBOL_SUGGESTIONS = 10
+
+	FixDocsSymbols = "symbols"
+	FixDocsGoDoc   = "godoc"
+)
+
+// symbolRefRegex finds the qualified identifiers the compiler's errors
+// quote, like s3.PutObjectInput in "undefined: s3.PutObjectInput" or
+// s3.Client in "(type *s3.Client has no field or method PutObjectt)".
+var symbolRefRegex = regexp.MustCompile(`\b([A-Za-z_]\w*)\.([A-Za-z_]\w*)\b`)
+
+// symbolRef is an identifier of an imported package a diagnostic is about.
+type symbolRef struct {
+	path, name string
+}
+
+// packageSymbols is what's quoted of an imported package at a version: the
+// declarations of the identifiers looked up so far, "" for those it doesn't
+// have, and its exported names.
+type packageSymbols struct {
+	decls map[string]string
+	names []string
+}
+
+// symbolCache holds packages' symbols by path@version, which can't change.
+type symbolCache struct {
+	mu       sync.Mutex
+	packages map[string]*packageSymbols
+}
+
+func (c *symbolCache) get(key string) *packageSymbols {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.packages[key]
+}
+
+func (c *symbolCache) put(key string, syms *packageSymbols) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.packages == nil {
+		c.packages = map[string]*packageSymbols{}
+	}
+	c.packages[key] = syms
+}
+
+// symbolDocs documents the identifiers of imported packages that the
+// diagnostics in output are about, from the packages' source: the
+// declaration and doc comment of each, with a type's methods, and the
+// names a package does have like those it doesn't. It's "" if none of the
+// diagnostics name one, or the packages can't be loaded, for which the
+// prompt falls back to goDocs.
+func (s *Pipeline) symbolDocs(ctx context.Context, seedling store.Seedling, output string) string {
+	dir := s.RepoDir(seedling)
+	refs := symbolRefs(dir, ParseDiagnostics(output))
+	if len(refs) == 0 {
+		return ""
+	}
+	versions := moduleVersions(dir)
+
+	byPath := map[string][]string{}
+	paths := []string{}
+	for _, ref := range refs {
+		if byPath[ref.path] == nil {
+			paths = append(paths, ref.path)
+		}
+		byPath[ref.path] = append(byPath[ref.path], ref.name)
+	}
+	// Only packages missing from the cache, or some of whose refs are, are
+	// loaded.
+	cached := map[string]*packageSymbols{}
+	load := []string{}
+	for _, path := range paths {
+		key := ""
+		if version := versions.version(path); version != "" {
+			key = path + "@" + version
+		}
+		syms := s.symbols.get(key)
+		if key == "" || syms == nil || !syms.has(byPath[path]) {
+			load = append(load, path)
+			continue
+		}
+		cached[path] = syms
+	}
+	if len(load) > 0 {
+		loaded, err := s.loadSymbols(ctx, dir, load, byPath)
+		if err != nil {
+			LoggerFromContext(ctx).WithField("error", err).Warn("failed to load imported packages for symbol docs")
+			return ""
+		}
+		for path, syms := range loaded {
+			if version := versions.version(path); version != "" {
+				s.symbols.put(path+"@"+version, syms)
+			}
+			cached[path] = syms
+		}
+	}
+
+	budget := s.symbolDocsBudget(seedling)
+	var b strings.Builder
+	quoted := 0
+	for _, ref := range refs {
+		syms := cached[ref.path]
+		if syms == nil {
+			continue
+		}
+		section := ""
+		if decl := syms.decls[ref.name]; decl != "" {
+			section = fmt.Sprintf("\n%s.%s:\n\n```go\n%s```\n", ref.path, ref.name, decl)
+		} else if similar := similarNames(ref.name, syms.names); len(similar) > 0 {
+			section = fmt.Sprintf("\n%s has no %s. It has %s.\n", ref.path, ref.name, strings.Join(similar, ", "))
+		} else {
+			continue
+		}
+		if quoted > 0 && llm.EstimateTokens(b.String()+section) > budget {
+			break
+		}
+		b.WriteString(section)
+		quoted++
+	}
+	if quoted == 0 {
+		return ""
+	}
+	return "\nHere are the declarations those errors are about:\n" + b.String()
+}
+
+// has reports whether every one of names has been looked up.
+func (p *packageSymbols) has(names []string) bool {
+	for _, name := range names {
+		if _, ok := p.decls[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// symbolDocsBudget is how many tokens of declarations a fix prompt may
+// quote, a share of the context of the model the server is written with.
+func (s *Pipeline) symbolDocsBudget(seedling store.Seedling) int {
+	model := s.modelChain(SeedlingStepServer, seedling.Model)[0]
+	context := llm.ContextTokens(model)
+	if context == 0 {
+		context = s.Config.ChatContextTokens
+	}
+	return context / SYMBOL_DOCS_CONTEXT_SHARE
+}
+
+// symbolRefs finds the identifiers of non-standard imports that diags are
+// about, each once, in the order they're first mentioned. Qualifiers are
+// matched against the imports of the file each diagnostic is in, since the
+// packages aren't loaded yet.
+func symbolRefs(dir string, diags []Diagnostic) []symbolRef {
+	imports := map[string]map[string]string{}
+	refs := []symbolRef{}
+	seen := map[symbolRef]bool{}
+	for _, d := range diags {
+		file := filepath.Join(dir, filepath.FromSlash(d.File))
+		if _, ok := imports[file]; !ok {
+			imports[file] = fileImports(file)
+		}
+		for _, m := range symbolRefRegex.FindAllStringSubmatch(d.Message, -1) {
+			path, ok := imports[file][m[1]]
+			if !ok || !token.IsExported(m[2]) {
+				continue
+			}
+			ref := symbolRef{path: path, name: m[2]}
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+// fileImports maps the names the file's non-standard imports are used by
+// to their paths. Packages are guessed to be named like their path's last
+// element, without a major version or go- prefix.
+func fileImports(file string) map[string]string {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	imports := map[string]string{}
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || !strings.Contains(path, ".") || strings.HasPrefix(path, ".") {
+			continue
+		}
+		// Types are still named by the package's name in errors about a
+		// renamed import.
+		if spec.Name != nil {
+			imports[spec.Name.Name] = path
+		}
+		if name := guessPackageName(path); imports[name] == "" {
+			imports[name] = path
+		}
+	}
+	return imports
+}
+
+var majorVersionRegex = regexp.MustCompile(`^v[0-9]+$`)
+
+func guessPackageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if majorVersionRegex.MatchString(name) && len(elems) > 1 {
+		name = elems[len(elems)-2]
+	}
+	if i := strings.Index(name, ".v"); i > 0 {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	return strings.ReplaceAll(name, "-", "")
+}
+
+// repoVersions are the versions of the modules a repo requires.
+type repoVersions map[string]string
+
+func moduleVersions(dir string) repoVersions {
+	versions := repoVersions{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return versions
+	}
+	mf, err := modfile.ParseLax("go.mod", data, nil)
+	if err != nil {
+		return versions
+	}
+	for _, req := range mf.Require {
+		versions[req.Mod.Path] = req.Mod.Version
+	}
+	return versions
+}
+
+// version is the version of the module the package is in, "" if the repo
+// doesn't require it.
+func (v repoVersions) version(path string) string {
+	best := ""
+	for mod := range v {
+		if hasPathPrefix(path, mod) && len(mod) > len(best) {
+			best = mod
+		}
+	}
+	return v[best]
+}
+
+// loadSymbols loads the packages with go/packages and renders the
+// declarations of the names of each in byPath. Only their syntax is
+// loaded: type checking them would need their dependencies' export data,
+// which is in whichever format the host's go writes.
+func (s *Pipeline) loadSymbols(ctx context.Context, dir string, paths []string, byPath map[string][]string) (map[string]*packageSymbols, error) {
+	cfg := &packages.Config{
+		Context: ctx,
+		Dir:     dir,
+		Env:     s.BuildEnv(),
+		Fset:    token.NewFileSet(),
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedSyntax,
+	}
+	pkgs, err := packages.Load(cfg, paths...)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]*packageSymbols{}
+	for _, pkg := range pkgs {
+		if len(pkg.Syntax) == 0 {
+			continue
+		}
+		syms := &packageSymbols{decls: map[string]string{}, names: exportedNames(pkg.Syntax)}
+		for _, name := range byPath[pkg.PkgPath] {
+			syms.decls[name] = renderSymbol(cfg.Fset, pkg.Syntax, name)
+		}
+		loaded[pkg.PkgPath] = syms
+	}
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("none of %s could be loaded", strings.Join(paths, ", "))
+	}
+	return loaded, nil
+}
+
+// exportedNames are the package-level exported names files declare, sorted.
+func exportedNames(files []*ast.File) []string {
+	names := []string{}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil && decl.Name.IsExported() {
+					names = append(names, decl.Name.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if spec.Name.IsExported() {
+							names = append(names, spec.Name.Name)
+						}
+					case *ast.ValueSpec:
+						for _, ident := range spec.Names {
+							if ident.IsExported() {
+								names = append(names, ident.Name)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderSymbol is the declaration of name in a package's files with its doc
+// comment, its body left out, and a type's exported methods without
+// theirs. It's "" if the package doesn't declare name.
+func renderSymbol(fset *token.FileSet, files []*ast.File, name string) string {
+	var b, methods bytes.Buffer
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv != nil {
+					if receiverName(decl.Recv) == name && decl.Name.IsExported() {
+						fn := *decl
+						fn.Doc, fn.Body = nil, nil
+						gofmtConfig.Fprint(&methods, fset, &fn)
+						methods.WriteString("\n")
+					}
+					continue
+				}
+				if decl.Name.Name != name {
+					continue
+				}
+				writeDoc(&b, decl.Doc)
+				fn := *decl
+				fn.Doc, fn.Body = nil, nil
+				gofmtConfig.Fprint(&b, fset, &fn)
+				b.WriteString("\n")
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if !specDeclares(spec, name) {
+						continue
+					}
+					doc := decl.Doc
+					if d := specDoc(spec); d != nil {
+						doc = d
+					}
+					writeDoc(&b, doc)
+					gofmtConfig.Fprint(&b, fset, &ast.GenDecl{Tok: decl.Tok, Specs: []ast.Spec{spec}})
+					b.WriteString("\n")
+				}
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	if methods.Len() > 0 {
+		b.WriteString("\n")
+		b.Write(methods.Bytes())
+	}
+	return b.String()
+}
+
+// receiverName is the name of the type a method is declared on.
+func receiverName(recv *ast.FieldList) string {
+	if len(recv.List) == 0 {
+		return ""
+	}
+	expr := recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch index := expr.(type) {
+	case *ast.IndexExpr:
+		expr = index.X
+	case *ast.IndexListExpr:
+		expr = index.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+func specDeclares(spec ast.Spec, name string) bool {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Name.Name == name
+	case *ast.ValueSpec:
+		for _, ident := range spec.Names {
+			if ident.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func specDoc(spec ast.Spec) *ast.CommentGroup {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Doc
+	case *ast.ValueSpec:
+		return spec.Doc
+	}
+	return nil
+}
+
+func writeDoc(b *bytes.Buffer, doc *ast.CommentGroup) {
+	for _, line := range strings.Split(strings.TrimRight(doc.Text(), "\n"), "\n") {
+		if line != "" || doc != nil {
+			b.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+		}
+	}
+}
+
+// similarNames are those of names that name could be a typo or a guess
+// at: ones containing it or it contains regardless of case, or a few edits
+// away, closest first.
+func similarNames(name string, names []string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	lower := strings.ToLower(name)
+	limit := len(name)/4 + 1
+	candidates := []candidate{}
+	for _, other := range names {
+		otherLower := strings.ToLower(other)
+		distance := editDistance(lower, otherLower)
+		if distance <= limit || strings.Contains(otherLower, lower) || strings.Contains(lower, otherLower) && len(other) > 3 {
+			candidates = append(candidates, candidate{other, distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	similar := []string{}
+	for i := 0; i < len(candidates) && i < MAX_SYMBOL_SUGGESTIONS; i++ {
+		similar = append(similar, candidates[i].name)
+	}
+	return similar
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
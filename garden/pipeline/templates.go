@@ -0,0 +1,289 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tensorscale/garden/garden/store"
+)
+
+var (
+
+	// BuiltinTemplates are the archetypes most seedlings turn out to be.
+	// They can't be replaced by custom templates.
+	BuiltinTemplates = []Template{
+		{
+			Name:        "crud",
+			Description: "create, read, update, delete and list one entity stored in SQLite",
+			Params:      []string{"entity", "fields"},
+			ProtoSkeleton: `syntax = "proto3";
+option go_package = "./protobufs";
+
+// {{.entity}} has an id and these fields: {{.fields}}
+message {{.entity}} {
+  string id = 1;
+}
+
+message Create{{.entity}}Request {}
+message Get{{.entity}}Request { string id = 1; }
+message List{{.entity}}sRequest { int32 page_size = 1; string page_token = 2; }
+message List{{.entity}}sResponse { repeated {{.entity}} items = 1; string next_page_token = 2; }
+message Update{{.entity}}Request {}
+message Delete{{.entity}}Request { string id = 1; }
+message Delete{{.entity}}Response {}
+
+service {{.entity}}Service {
+  rpc Create{{.entity}}(Create{{.entity}}Request) returns ({{.entity}});
+  rpc Get{{.entity}}(Get{{.entity}}Request) returns ({{.entity}});
+  rpc List{{.entity}}s(List{{.entity}}sRequest) returns (List{{.entity}}sResponse);
+  rpc Update{{.entity}}(Update{{.entity}}Request) returns ({{.entity}});
+  rpc Delete{{.entity}}(Delete{{.entity}}Request) returns (Delete{{.entity}}Response);
+}
+`,
+			ServerHints: `Store each {{.entity}} as a row in SQLite using github.com/mattn/go-sqlite3,
+creating the table at startup if it doesn't exist. Return codes.NotFound for
+ids that don't exist and codes.InvalidArgument for invalid fields.`,
+			QualityRules: `The quality check should return "bad" if any of the RPCs doesn't read or
+write the SQLite database, or if List{{.entity}}s doesn't paginate.`,
+		},
+		{
+			Name:        "converter",
+			Description: "convert files from one format to another",
+			Params:      []string{"from", "to"},
+			ProtoSkeleton: `syntax = "proto3";
+option go_package = "./protobufs";
+
+message ConvertRequest {
+  // the {{.from}} file
+  bytes file = 1;
+}
+
+message ConvertResponse {
+  // the {{.to}} file
+  bytes file = 1;
+}
+
+service Converter {
+  rpc Convert(ConvertRequest) returns (ConvertResponse);
+}
+`,
+			ServerHints: `Convert {{.from}} to {{.to}} with a library or a binary installed in the
+container. Receive the file in the HTTP server with FormFile and write the
+converted file under /outputs.`,
+			QualityRules: `The quality check should return "bad" if the conversion doesn't actually
+produce {{.to}}, e.g. if it copies the input or returns a placeholder.`,
+		},
+		{
+			Name:        "webhook",
+			Description: "receive and act on webhooks",
+			Params:      []string{"source", "action"},
+			ProtoSkeleton: `syntax = "proto3";
+option go_package = "./protobufs";
+
+message Event {
+  string id = 1;
+  string type = 2;
+  // the raw JSON body of the {{.source}} webhook
+  string payload = 3;
+  string received_at = 4;
+}
+
+message HandleEventResponse {}
+message ListEventsRequest { int32 limit = 1; }
+message ListEventsResponse { repeated Event events = 1; }
+
+service WebhookReceiver {
+  rpc HandleEvent(Event) returns (HandleEventResponse);
+  rpc ListEvents(ListEventsRequest) returns (ListEventsResponse);
+}
+`,
+			ServerHints: `The HTTP endpoint receiving {{.source}} webhooks must verify the request
+signature the way {{.source}} documents it, reading the signing secret from
+/secrets, and respond within a few seconds. For each event: {{.action}}. Keep
+the most recent events in memory for ListEvents.`,
+			QualityRules: `The quality check should return "bad" if webhook signatures aren't verified
+or if events are acknowledged without being acted on.`,
+		},
+		{
+			Name:        "scheduler",
+			Description: "run a task on a schedule",
+			Params:      []string{"task", "schedule"},
+			ProtoSkeleton: `syntax = "proto3";
+option go_package = "./protobufs";
+
+message Run {
+  string id = 1;
+  string started_at = 2;
+  string finished_at = 3;
+  bool success = 4;
+  string output = 5;
+}
+
+message RunNowRequest {}
+message ListRunsRequest { int32 limit = 1; }
+message ListRunsResponse { repeated Run runs = 1; }
+
+service Scheduler {
+  rpc RunNow(RunNowRequest) returns (Run);
+  rpc ListRuns(ListRunsRequest) returns (ListRunsResponse);
+}
+`,
+			ServerHints: `Run this task {{.schedule}}: {{.task}}. Don't start a run while the
+previous one is still going. Keep the most recent runs in memory for ListRuns.`,
+			QualityRules: `The quality check should return "bad" if the task never runs on the
+schedule, only when RunNow is called.`,
+		},
+	}
+)
+
+// Template is an archetype of service whose shape doesn't have to be
+// rediscovered from the description. Its prompts are text/template
+// templates, executed with the seedling's name, description and the
+// template's params.
+type Template struct {
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+	// Params are the names of the params every seedling using the template
+	// has to set.
+	ParamList     string    `db:"params" json:"-"`
+	Params        []string  `db:"-" json:"params"`
+	ProtoSkeleton string    `db:"proto_skeleton" json:"protoSkeleton"`
+	ServerHints   string    `db:"server_hints" json:"serverHints"`
+	QualityRules  string    `db:"quality_rules" json:"qualityRules"`
+	Builtin       bool      `db:"-" json:"builtin"`
+	CreatedAt     time.Time `db:"created_at" json:"createdAt"`
+}
+
+// templatePrompts are a template's prompts rendered for one seedling.
+type templatePrompts struct {
+	ProtoSkeleton string
+	ServerHints   string
+	QualityRules  string
+}
+
+func BuiltinTemplate(name string) *Template {
+	for _, t := range BuiltinTemplates {
+		if t.Name == name {
+			t.Builtin = true
+			return &t
+		}
+	}
+	return nil
+}
+
+// GetTemplate returns the built-in or custom template called name, or
+// sql.ErrNoRows if there isn't one.
+func (s *Pipeline) GetTemplate(ctx context.Context, name string) (*Template, error) {
+	if t := BuiltinTemplate(name); t != nil {
+		return t, nil
+	}
+	var t Template
+	if err := s.DB.GetContext(ctx, &t, "SELECT * FROM templates WHERE name = $1", name); err != nil {
+		return nil, err
+	}
+	t.Params = SplitParams(t.ParamList)
+	return &t, nil
+}
+
+func SplitParams(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
+
+// CheckParams returns why params can't be used with the template, or "" if
+// they can.
+func (t *Template) CheckParams(params store.TemplateParams) string {
+	missing := []string{}
+	for _, name := range t.Params {
+		if strings.TrimSpace(params[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("template %q requires params: %s", t.Name, strings.Join(missing, ", "))
+	}
+	for name := range params {
+		known := false
+		for _, p := range t.Params {
+			known = known || p == name
+		}
+		if !known {
+			return fmt.Sprintf("template %q has no param %q", t.Name, name)
+		}
+	}
+	return ""
+}
+
+func (t *Template) Render(seedling store.Seedling) (*templatePrompts, error) {
+	data := map[string]string{}
+	for name, value := range seedling.TemplateParams {
+		data[name] = value
+	}
+	data["name"] = seedling.Name
+	data["description"] = seedling.Description
+
+	prompts := &templatePrompts{}
+	for _, field := range []struct {
+		text *string
+		out  *string
+	}{
+		{&t.ProtoSkeleton, &prompts.ProtoSkeleton},
+		{&t.ServerHints, &prompts.ServerHints},
+		{&t.QualityRules, &prompts.QualityRules},
+	} {
+		tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(*field.text)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		*field.out = strings.TrimSpace(buf.String())
+	}
+	return prompts, nil
+}
+
+// seedlingTemplate renders the prompts of the seedling's template, or returns
+// nil if it wasn't created from one.
+func (s *Pipeline) seedlingTemplate(ctx context.Context, seedling store.Seedling) (*templatePrompts, error) {
+	if seedling.Template == "" {
+		return nil, nil
+	}
+	t, err := s.GetTemplate(ctx, seedling.Template)
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", seedling.Template, err)
+	}
+	return t.Render(seedling)
+}
+
+// protoHint is added to the protobufs prompt of a seedling with a template.
+func (tp *templatePrompts) protoHint() string {
+	if tp == nil || tp.ProtoSkeleton == "" {
+		return ""
+	}
+	return "Start from this skeleton, filling in the messages and adding whatever else the service needs:\n\n" +
+		"```protobuf\n" + tp.ProtoSkeleton + "\n```\n"
+}
+
+// serverHint is added to the server instructions.
+func (tp *templatePrompts) serverHint() string {
+	if tp == nil || tp.ServerHints == "" {
+		return ""
+	}
+	return "\n\nAlso:\n\n" + tp.ServerHints
+}
+
+// qualityHint is added to the quality check rules.
+func (tp *templatePrompts) qualityHint() string {
+	if tp == nil || tp.QualityRules == "" {
+		return ""
+	}
+	return "\n" + tp.QualityRules + "\n"
+}
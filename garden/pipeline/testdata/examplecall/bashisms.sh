@@ -0,0 +1,18 @@
+#!/bin/bash
+set -euxo pipefail
+
+host="${GARDEN_HTTP_HOST:-localhost}"
+port="${GARDEN_HTTP_PORT:?set GARDEN_HTTP_PORT to the HTTP port of the service}"
+
+if [[ $# -lt 1 ]]; then
+  echo "usage: $0 <text> [<text>...]" >&2
+  exit 1
+fi
+
+read -r first _ <<< "$*"
+texts=("$@")
+for text in "${texts[@]}"; do
+  body=$(printf '{"text": "%s", "first": "%s"}' "${text^^}" "$first")
+  curl -s -X POST -H $'Content-Type: application/json' \
+    -d "$body" "http://${host}:${port}/v1/say"
+done
@@ -0,0 +1,5 @@
+#!/bin/bash
+set -euxo pipefail
+
+curl -s -X POST -d '{"text": "hello"}' \
+  "http://localhost:$(docker inspect -f '{{ (index .NetworkSettings.Ports "8000/tcp" 0).HostPort }}' seedling-echo)/v1/say"
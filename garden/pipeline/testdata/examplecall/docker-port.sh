@@ -0,0 +1,5 @@
+#!/bin/bash
+set -euxo pipefail
+
+PORT=$(docker port seedling-echo 8000 | cut -d: -f2)
+curl -s -X POST -d '{"text": "hello"}' "http://localhost:${PORT}/v1/say"
@@ -0,0 +1,7 @@
+#!/bin/bash
+set -euxo pipefail
+
+port="${GARDEN_HTTP_PORT:?set GARDEN_HTTP_PORT}"
+for text in "$@"
+  curl -s -X POST -d "{\"text\": \"${text}\"}" "http://localhost:${port}/v1/say"
+done
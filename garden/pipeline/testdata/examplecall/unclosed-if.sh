@@ -0,0 +1,9 @@
+#!/bin/bash
+set -euxo pipefail
+
+port="${GARDEN_HTTP_PORT:?set GARDEN_HTTP_PORT}"
+if [[ -z "${1:-}" ]]; then
+  echo "usage: $0 <text>" >&2
+  exit 1
+
+curl -s -X POST -d "{\"text\": \"$1\"}" "http://localhost:${port}/v1/say"
@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Tool is an executable the build steps shell out to.
+type Tool struct {
+	Name string
+	// VersionArgs print the tool's version; nil if it has no version flag.
+	VersionArgs []string
+	// Package is what `go install` installs the tool from, or "" if it
+	// can't be installed that way.
+	Package string
+}
+
+type ToolStatus struct {
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Installed bool   `json:"installed"`
+	// AutoInstalled is set when garden installed the tool into its GOBIN.
+	AutoInstalled bool   `json:"autoInstalled,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type EnvReport struct {
+	GOBIN     string       `json:"gobin"`
+	Tools     []ToolStatus `json:"tools"`
+	OK        bool         `json:"ok"`
+	CheckedAt time.Time    `json:"checkedAt"`
+}
+
+var (
+	buildTools = []Tool{
+		{Name: "go", VersionArgs: []string{"version"}},
+		{Name: "protoc", VersionArgs: []string{"--version"}},
+		{Name: "protoc-gen-go", VersionArgs: []string{"--version"}, Package: "google.golang.org/protobuf/cmd/protoc-gen-go@latest"},
+		{Name: "protoc-gen-go-grpc", VersionArgs: []string{"--version"}, Package: "google.golang.org/grpc/cmd/protoc-gen-go-grpc@latest"},
+		{Name: "goimports", Package: "golang.org/x/tools/cmd/goimports@latest"},
+	}
+)
+
+// toolsPath is PATH with garden's GOBIN in front, so tools it installed are
+// found first.
+func (s *Pipeline) toolsPath() string {
+	return s.Config.ToolsBin + string(os.PathListSeparator) + os.Getenv("PATH")
+}
+
+// BuildEnv is the environment build commands run with.
+func (s *Pipeline) BuildEnv() []string {
+	return append(os.Environ(), "PATH="+s.toolsPath(), "GOMODCACHE="+s.Config.ModCacheDir)
+}
+
+// lookTool finds a tool on toolsPath.
+func (s *Pipeline) lookTool(name string) (string, error) {
+	for _, dir := range filepath.SplitList(s.toolsPath()) {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+func (s *Pipeline) checkTool(ctx context.Context, tool Tool, autoInstall bool) ToolStatus {
+	status := ToolStatus{Name: tool.Name}
+	path, err := s.lookTool(tool.Name)
+	if err != nil && autoInstall && tool.Package != "" {
+		cmd := exec.CommandContext(ctx, "go", "install", tool.Package)
+		cmd.Env = append(s.BuildEnv(), "GOBIN="+s.Config.ToolsBin)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			status.Error = fmt.Sprintf("go install %s failed: %s", tool.Package, strings.TrimSpace(string(out)))
+			return status
+		}
+		status.AutoInstalled = true
+		path, err = s.lookTool(tool.Name)
+	}
+	if err != nil {
+		status.Error = tool.Name + " not found on PATH"
+		if tool.Package != "" {
+			status.Error += fmt.Sprintf(", install it with `go install %s` or set TOOLS_AUTO_INSTALL=true", tool.Package)
+		}
+		return status
+	}
+
+	status.Path = path
+	status.Installed = true
+	if tool.VersionArgs != nil {
+		out, err := exec.CommandContext(ctx, path, tool.VersionArgs...).CombinedOutput()
+		if err != nil {
+			status.Error = fmt.Sprintf("%s %s failed: %s", tool.Name, strings.Join(tool.VersionArgs, " "), err)
+		}
+		status.Version = strings.TrimSpace(string(out))
+	}
+	return status
+}
+
+func (s *Pipeline) checkTools(ctx context.Context) *EnvReport {
+	if err := os.MkdirAll(s.Config.ToolsBin, 0755); err != nil {
+		logrus.WithField("error", err).Error("failed to create tools dir")
+	}
+	report := &EnvReport{GOBIN: s.Config.ToolsBin, Tools: []ToolStatus{}, OK: true, CheckedAt: time.Now()}
+	for _, tool := range buildTools {
+		status := s.checkTool(ctx, tool, s.Config.ToolsAutoInstall)
+		report.OK = report.OK && status.Installed
+		report.Tools = append(report.Tools, status)
+	}
+	return report
+}
+
+// Toolchain returns the environment report, checking the tools the first
+// time it's called or whenever refresh is set.
+func (s *Pipeline) Toolchain(ctx context.Context, refresh bool) *EnvReport {
+	s.envMu.Lock()
+	defer s.envMu.Unlock()
+	if s.env == nil || refresh || !s.env.OK {
+		s.env = s.checkTools(ctx)
+		for _, tool := range s.env.Tools {
+			logrus.WithField("tool", tool.Name).
+				WithField("path", tool.Path).
+				WithField("version", tool.Version).
+				WithField("error", tool.Error).
+				Info("Checked build tool")
+		}
+	}
+	return s.env
+}
+
+// missingTools describes every required tool that isn't installed, or ""
+// if they all are.
+func (r *EnvReport) missingTools() string {
+	missing := []string{}
+	for _, tool := range r.Tools {
+		if !tool.Installed {
+			missing = append(missing, tool.Error)
+		}
+	}
+	return strings.Join(missing, "; ")
+}
@@ -0,0 +1,497 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tensorscale/garden/garden/store"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// The kinds of transcoding mismatch.
+const (
+	// TranscodingName is a field the handler names other than by its proto
+	// JSON name, e.g. user_id for userId.
+	TranscodingName = "name"
+	// TranscodingMissing is a field of the request message the handler
+	// doesn't read.
+	TranscodingMissing = "missing"
+	// TranscodingUnknown is a field the handler reads that the request
+	// message doesn't have.
+	TranscodingUnknown = "unknown"
+)
+
+// TranscodingError lists the mismatches that rejected a server. Its message
+// has them as diagnostics, so the fix prompt can point into the files.
+type TranscodingError struct {
+	RPCs []store.TranscodingRPC
+}
+
+func (e *TranscodingError) Error() string {
+	var b strings.Builder
+	for _, rpc := range e.RPCs {
+		for _, m := range rpc.Mismatches {
+			fmt.Fprintf(&b, "%s:%d: %s\n", m.File, m.Line, m.Message)
+		}
+	}
+	return b.String()
+}
+
+// fix is the reprompt after a server's HTTP handlers didn't match its
+// proto, with the canonical request of each rpc they got wrong.
+func (e *TranscodingError) fix(code, file string) string {
+	var b strings.Builder
+	b.WriteString("That code builds, but its HTTP server doesn't take the JSON the proto3 JSON mapping gives the gRPC requests:\n\n")
+	for _, rpc := range e.RPCs {
+		for _, m := range rpc.Mismatches {
+			fmt.Fprintf(&b, "%s:%d: %s\n", m.File, m.Line, m.Message)
+			if !sameFile(m.File, file) {
+				continue
+			}
+			if excerpt := sourceExcerpt(code, m.Line); excerpt != "" {
+				b.WriteString("```\n" + excerpt + "```\n")
+			}
+		}
+	}
+	b.WriteString("\nThis is the JSON of each of those requests, with every field:\n\n")
+	for _, rpc := range e.RPCs {
+		fmt.Fprintf(&b, "%s:\n```json\n%s\n```\n", rpc.RPC, rpc.Example)
+	}
+	b.WriteString("\nWrite a version whose HTTP handlers take exactly these fields, e.g. by decoding the body into the\n" +
+		"generated request message with protojson.Unmarshal.\n")
+	return b.String()
+}
+
+// transcodingErr is the TranscodingError for the rpcs of r with mismatches,
+// or nil if there are none or the report is advisory.
+func transcodingErr(r *store.TranscodingReport) error {
+	if r.Advisory {
+		return nil
+	}
+	failed := []store.TranscodingRPC{}
+	for _, rpc := range r.RPCs {
+		if len(rpc.Mismatches) > 0 {
+			failed = append(failed, rpc)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &TranscodingError{RPCs: failed}
+}
+
+// handlerField is a JSON field an HTTP handler decodes, at line.
+type handlerField struct {
+	name string
+	line int
+}
+
+// requestDecode is somewhere the server decodes JSON: into a struct of its
+// own with fields, or, message being set, into a generated message with
+// encoding/json, which goes by the proto field names rather than the JSON
+// ones. hint is what the handler is called, by its func or the path it's
+// registered at, for telling which rpc it serves.
+type requestDecode struct {
+	file     string
+	line     int
+	typeName string
+	fields   []handlerField
+	message  string
+	hint     string
+}
+
+// checkTranscoding checks that, for each rpc of the seedling's proto whose
+// request its HTTP server decodes from JSON, the server reads the fields of
+// the request message by their proto JSON names. The descriptor is the one
+// protoc compiled into the generated code, as for SeedlingStepProtobufs'
+// checks, and the handlers' fields are read from the server's source: the
+// json tags of the structs it decodes into.
+func (s *Pipeline) checkTranscoding(seedling store.Seedling, dir string, advisory bool) (*store.TranscodingReport, error) {
+	fd, err := s.protoFile(seedling)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := serverFilePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	decodes, err := requestDecodes(dir, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcs := []protoreflect.MethodDescriptor{}
+	services := fd.Services()
+	for i := 0; i < services.Len(); i++ {
+		methods := services.Get(i).Methods()
+		for j := 0; j < methods.Len(); j++ {
+			rpcs = append(rpcs, methods.Get(j))
+		}
+	}
+	report := &store.TranscodingReport{Advisory: advisory, RPCs: []store.TranscodingRPC{}}
+	for _, method := range rpcs {
+		input := method.Input()
+		// protojson varies its whitespace, so the example is reindented.
+		compact, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(dynamicpb.NewMessage(input))
+		if err != nil {
+			return nil, err
+		}
+		var example bytes.Buffer
+		if err := json.Indent(&example, compact, "", "  "); err != nil {
+			return nil, err
+		}
+		rpc := store.TranscodingRPC{
+			Service:    string(method.Parent().FullName()),
+			RPC:        string(method.Name()),
+			Example:    example.Bytes(),
+			Mismatches: []store.TranscodingMismatch{},
+		}
+		for _, d := range decodes {
+			if !decodeServes(d, method, rpcs) {
+				continue
+			}
+			if rpc.Handler == "" {
+				rpc.Handler = fmt.Sprintf("%s:%d", d.file, d.line)
+				rpc.Decodes = d.typeName
+			}
+			rpc.Mismatches = append(rpc.Mismatches, transcodingMismatches(d, input)...)
+		}
+		report.RPCs = append(report.RPCs, rpc)
+	}
+	return report, nil
+}
+
+// decodeServes reports whether the decode reads the method's request: it
+// decodes the method's input message, or a struct of the server's named
+// after the method or its input, or is in a handler named after it. When
+// several methods' names fit, the longest wins, so that a GetUserStats
+// handler isn't taken for GetUser's.
+func decodeServes(d requestDecode, method protoreflect.MethodDescriptor, rpcs []protoreflect.MethodDescriptor) bool {
+	if d.message != "" {
+		return d.message == string(method.Input().Name())
+	}
+	name := foldName(trimRequestSuffix(d.typeName))
+	for _, m := range rpcs {
+		if name != "" && (name == foldName(string(m.Name())) || name == foldName(trimRequestSuffix(string(m.Input().Name())))) {
+			return m == method
+		}
+	}
+	hint := foldName(d.hint)
+	best := protoreflect.MethodDescriptor(nil)
+	for _, m := range rpcs {
+		if rpc := foldName(string(m.Name())); rpc != "" && strings.Contains(hint, rpc) &&
+			(best == nil || len(rpc) > len(foldName(string(best.Name())))) {
+			best = m
+		}
+	}
+	return best == method
+}
+
+// transcodingMismatches compares the fields the decode reads with the
+// message's.
+func transcodingMismatches(d requestDecode, md protoreflect.MessageDescriptor) []store.TranscodingMismatch {
+	mismatches := []store.TranscodingMismatch{}
+	fields := md.Fields()
+	if d.message != "" {
+		// The generated structs' json tags are the proto field names.
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			if !strings.EqualFold(string(field.Name()), field.JSONName()) {
+				mismatches = append(mismatches, store.TranscodingMismatch{
+					File: d.file, Line: d.line, Kind: TranscodingName,
+					Field: string(field.Name()), JSONName: field.JSONName(),
+					Message: fmt.Sprintf("HTTP handler decodes %s with encoding/json, which expects '%s' but proto JSON name is '%s'; decode it with protojson.Unmarshal",
+						d.typeName, field.Name(), field.JSONName()),
+				})
+			}
+		}
+		return mismatches
+	}
+
+	read := map[protoreflect.Name]bool{}
+	for _, f := range d.fields {
+		var match, near protoreflect.FieldDescriptor
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			if strings.EqualFold(f.name, field.JSONName()) {
+				match = field
+				break
+			}
+			if foldName(f.name) == foldName(string(field.Name())) {
+				near = field
+			}
+		}
+		switch {
+		case match != nil:
+			read[match.Name()] = true
+		case near != nil:
+			read[near.Name()] = true
+			mismatches = append(mismatches, store.TranscodingMismatch{
+				File: d.file, Line: f.line, Kind: TranscodingName,
+				Field: f.name, JSONName: near.JSONName(),
+				Message: fmt.Sprintf("HTTP handler expects '%s' but proto JSON name is '%s'", f.name, near.JSONName()),
+			})
+		default:
+			mismatches = append(mismatches, store.TranscodingMismatch{
+				File: d.file, Line: f.line, Kind: TranscodingUnknown,
+				Field:   f.name,
+				Message: fmt.Sprintf("HTTP handler expects '%s', which isn't a field of %s", f.name, md.Name()),
+			})
+		}
+	}
+	for i := 0; i < fields.Len(); i++ {
+		if field := fields.Get(i); !read[field.Name()] {
+			mismatches = append(mismatches, store.TranscodingMismatch{
+				File: d.file, Line: d.line, Kind: TranscodingMissing,
+				JSONName: field.JSONName(),
+				Message:  fmt.Sprintf("HTTP handler's %s has no field for '%s' of %s", d.typeName, field.JSONName(), md.Name()),
+			})
+		}
+	}
+	return mismatches
+}
+
+// requestDecodes finds the JSON decodes in the server's files: the targets
+// of json.Unmarshal and of Decode on a json.NewDecoder. Decodes with
+// protojson follow the mapping already and aren't listed.
+func requestDecodes(dir string, paths []string) ([]requestDecode, error) {
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	structs := map[string]*ast.StructType{}
+	for _, p := range paths {
+		f, err := parser.ParseFile(fset, p, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				if ts, ok := spec.(*ast.TypeSpec); ok {
+					if st, ok := ts.Type.(*ast.StructType); ok {
+						structs[ts.Name.Name] = st
+					}
+				}
+			}
+		}
+	}
+
+	decodes := []requestDecode{}
+	for i, f := range files {
+		rel, _ := filepath.Rel(dir, paths[i])
+		rel = filepath.ToSlash(rel)
+		jsonPkg, pbPkg := "", ""
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			switch {
+			case path == "encoding/json":
+				jsonPkg = name
+			case strings.HasSuffix(path, "/protobufs"):
+				pbPkg = name
+			}
+		}
+		if jsonPkg == "" {
+			continue
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			vars := map[string]ast.Expr{}
+			hints := map[ast.Node]string{}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.ValueSpec:
+					for j, name := range n.Names {
+						if n.Type != nil {
+							vars[name.Name] = n.Type
+						} else if j < len(n.Values) {
+							vars[name.Name] = n.Values[j]
+						}
+					}
+				case *ast.AssignStmt:
+					for j, lhs := range n.Lhs {
+						if id, ok := lhs.(*ast.Ident); ok && j < len(n.Rhs) && len(n.Lhs) == len(n.Rhs) {
+							vars[id.Name] = n.Rhs[j]
+						}
+					}
+				case *ast.CallExpr:
+					// Handlers registered inline take the path they're
+					// registered at as their name.
+					for _, arg := range n.Args {
+						if lit, ok := arg.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+							for _, other := range n.Args {
+								if fl, ok := other.(*ast.FuncLit); ok {
+									hints[fl], _ = strconv.Unquote(lit.Value)
+								}
+							}
+						}
+					}
+					target := decodeTarget(n, jsonPkg)
+					if target == nil {
+						return true
+					}
+					d := requestDecode{file: rel, line: fset.Position(n.Pos()).Line, hint: fn.Name.Name}
+					for hinted, hint := range hints {
+						if hinted.Pos() <= n.Pos() && n.End() <= hinted.End() {
+							d.hint = hint
+						}
+					}
+					if !resolveDecode(&d, target, vars, structs, pbPkg, fset) {
+						return true
+					}
+					decodes = append(decodes, d)
+				}
+				return true
+			})
+		}
+	}
+	return decodes, nil
+}
+
+// decodeTarget is what call decodes JSON into, if it's json.Unmarshal or a
+// json.NewDecoder's Decode.
+func decodeTarget(call *ast.CallExpr, jsonPkg string) ast.Expr {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	switch sel.Sel.Name {
+	case "Unmarshal":
+		if id, ok := sel.X.(*ast.Ident); ok && id.Name == jsonPkg && len(call.Args) == 2 {
+			return call.Args[1]
+		}
+	case "Decode":
+		inner, ok := sel.X.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return nil
+		}
+		if isel, ok := inner.Fun.(*ast.SelectorExpr); ok && isel.Sel.Name == "NewDecoder" {
+			if id, ok := isel.X.(*ast.Ident); ok && id.Name == jsonPkg {
+				return call.Args[0]
+			}
+		}
+	}
+	return nil
+}
+
+// resolveDecode fills in what the decode's target is: one of the server's
+// structs or a generated message. Targets it can't tell the type of, like
+// maps, aren't checked.
+func resolveDecode(d *requestDecode, target ast.Expr, vars map[string]ast.Expr, structs map[string]*ast.StructType, pbPkg string, fset *token.FileSet) bool {
+	for depth := 0; depth < 4; depth++ {
+		switch t := target.(type) {
+		case *ast.UnaryExpr:
+			target = t.X
+			continue
+		case *ast.StarExpr:
+			target = t.X
+			continue
+		case *ast.CompositeLit:
+			target = t.Type
+			continue
+		case *ast.CallExpr:
+			// new(T)
+			if id, ok := t.Fun.(*ast.Ident); ok && id.Name == "new" && len(t.Args) == 1 {
+				target = t.Args[0]
+				continue
+			}
+			return false
+		case *ast.Ident:
+			if st, ok := structs[t.Name]; ok {
+				d.typeName = t.Name
+				d.fields = structFields(st, fset)
+				return true
+			}
+			v, ok := vars[t.Name]
+			if !ok {
+				return false
+			}
+			target = v
+			continue
+		case *ast.SelectorExpr:
+			if id, ok := t.X.(*ast.Ident); ok && pbPkg != "" && id.Name == pbPkg {
+				d.typeName = pbPkg + "." + t.Sel.Name
+				d.message = t.Sel.Name
+				return true
+			}
+			return false
+		case *ast.StructType:
+			d.typeName = "request struct"
+			d.fields = structFields(t, fset)
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// structFields are the JSON fields a struct decodes, by their json tags or
+// else their names. Embedded fields aren't followed.
+func structFields(st *ast.StructType, fset *token.FileSet) []handlerField {
+	fields := []handlerField{}
+	for _, field := range st.Fields.List {
+		tag := ""
+		if field.Tag != nil {
+			if unquoted, err := strconv.Unquote(field.Tag.Value); err == nil {
+				tag = reflect.StructTag(unquoted).Get("json")
+			}
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		for _, id := range field.Names {
+			if !id.IsExported() {
+				continue
+			}
+			n := name
+			if n == "" {
+				n = id.Name
+			}
+			fields = append(fields, handlerField{name: n, line: fset.Position(id.Pos()).Line})
+		}
+	}
+	return fields
+}
+
+// foldName is name lower-cased without punctuation, for comparing names
+// across casing conventions.
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// trimRequestSuffix drops what request types tend to be named with, e.g.
+// the Request of CreateUserRequest or the Body of createUserBody.
+func trimRequestSuffix(name string) string {
+	for _, suffix := range []string{"HTTPRequest", "Request", "Req", "Body", "Input", "Payload"} {
+		if trimmed := strings.TrimSuffix(name, suffix); trimmed != name && trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
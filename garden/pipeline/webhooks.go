@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/sirupsen/logrus"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+const (
+	WebhookSignatureHeader = "X-Garden-Signature"
+	WebhookEventHeader     = "X-Garden-Event"
+
+	// MAX_WEBHOOK_ATTEMPTS is how many times a delivery is tried when the
+	// endpoint errors or returns a 5xx, backing off exponentially from
+	// WebhookBackoff between attempts.
+	MAX_WEBHOOK_ATTEMPTS = 5
+	WebhookBackoff       = time.Second
+	WebhookTimeout       = 10 * time.Second
+	// MAX_WEBHOOK_RESPONSE_BYTES is how much of each response body is kept
+	// in the delivery history.
+	MAX_WEBHOOK_RESPONSE_BYTES = 1024
+)
+
+type Webhook struct {
+	store.DBRow
+	URL       string   `db:"url" json:"url"`
+	EventList string   `db:"events" json:"-"`
+	Events    []string `db:"-" json:"events"`
+	Secret    string   `db:"secret" json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID           int64      `db:"id" json:"id"`
+	WebhookID    hide.Int64 `db:"webhook_id" json:"webhookId"`
+	SeedlingID   hide.Int64 `db:"seedling_id" json:"seedlingId"`
+	Event        string     `db:"event" json:"event"`
+	Attempt      int        `db:"attempt" json:"attempt"`
+	StatusCode   int        `db:"status_code" json:"statusCode"`
+	ResponseBody string     `db:"response_body" json:"responseBody"`
+	Error        string     `db:"error" json:"error,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"createdAt"`
+}
+
+type WebhookPayload struct {
+	Event    string          `json:"event"`
+	Step     string          `json:"step"`
+	Seedling *store.Seedling `json:"seedling"`
+	At       time.Time       `json:"at"`
+}
+
+func (wh *Webhook) subscribed(event string) bool {
+	for _, e := range strings.Split(wh.EventList, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// signWebhook returns the X-Garden-Signature value for a payload: the
+// hex-encoded HMAC-SHA256 of the body keyed with the webhook's secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify records a build event in the seedling's history, publishes it to
+// the seedling's event subscribers and delivers it to every webhook
+// subscribed to it. Webhooks are delivered in the background so a slow
+// endpoint can't stall the build.
+func (s *Pipeline) Notify(ctx context.Context, seedling store.Seedling, event, step string) {
+	var details EventPayload
+	switch event {
+	case EventFailed:
+		details = EventPayload{"reason": EventText(seedling.FailureReason)}
+	case EventBitrot:
+		details = EventPayload{"reason": EventText(seedling.BitrotReason)}
+	}
+	s.Emit(ctx, seedling.ID, SeedlingEvent{Type: event, Step: step, Payload: details})
+	switch event {
+	case EventCompleted:
+		s.Markers.Send(s.seedlingMarker(seedling, MarkerCompleted, "build completed"))
+	case EventFailed:
+		s.Markers.Send(s.seedlingMarker(seedling, MarkerFailed, "build failed at "+step+": "+seedling.FailureReason))
+	}
+
+	hooks := []Webhook{}
+	if err := s.DB.SelectContext(ctx, &hooks, "SELECT * FROM webhooks"); err != nil {
+		logrus.WithField("error", err).Error("failed to get webhooks")
+		return
+	}
+	seedling.Step = step
+	seedling.TraceURL = s.TraceURL(seedling.TraceID)
+	payload := WebhookPayload{Event: event, Step: step, Seedling: &seedling, At: time.Now()}
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to encode webhook payload")
+		return
+	}
+	for _, hook := range hooks {
+		if hook.subscribed(event) {
+			go s.deliverWebhook(hook, seedling.ID, event, body)
+		}
+	}
+}
+
+// deliverWebhook posts body to the webhook, retrying network errors and 5xx
+// responses with exponential backoff, and records every attempt.
+func (s *Pipeline) deliverWebhook(hook Webhook, seedlingID hide.Int64, event string, body []byte) {
+	backoff := WebhookBackoff
+	for attempt := 1; attempt <= MAX_WEBHOOK_ATTEMPTS; attempt++ {
+		delivery := WebhookDelivery{
+			WebhookID:  hook.ID,
+			SeedlingID: seedlingID,
+			Event:      event,
+			Attempt:    attempt,
+			CreatedAt:  time.Now(),
+		}
+
+		req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			logrus.WithField("error", err).Error("failed to create webhook request")
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookEventHeader, event)
+		if hook.Secret != "" {
+			req.Header.Set(WebhookSignatureHeader, signWebhook(hook.Secret, body))
+		}
+
+		resp, err := s.WebhookClient.Do(req)
+		if err != nil {
+			delivery.Error = err.Error()
+		} else {
+			delivery.StatusCode = resp.StatusCode
+			respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_WEBHOOK_RESPONSE_BYTES))
+			resp.Body.Close()
+			delivery.ResponseBody = string(respBody)
+		}
+		if _, err := s.DB.NamedExec(`
+		 INSERT INTO webhook_deliveries
+		 (webhook_id, seedling_id, event, attempt, status_code, response_body, error, created_at)
+		 VALUES (:webhook_id, :seedling_id, :event, :attempt, :status_code, :response_body, :error, :created_at)
+		 `, &delivery); err != nil {
+			logrus.WithField("error", err).Error("failed to record webhook delivery")
+		}
+
+		if delivery.Error == "" && delivery.StatusCode < 500 {
+			return
+		}
+		if attempt < MAX_WEBHOOK_ATTEMPTS {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	logrus.WithField("webhook_id", hook.ID).
+		WithField("event", event).
+		Warn("giving up on webhook delivery")
+}
@@ -0,0 +1,420 @@
+// Package pipelinetest runs the pipeline in tests, with an LLM, build runner
+// and container runtime that are faked.
+package pipelinetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tensorscale/garden/garden/dockerx"
+	"github.com/tensorscale/garden/garden/llm"
+	"github.com/tensorscale/garden/garden/pipeline"
+	"github.com/tensorscale/garden/garden/store"
+	"github.com/tensorscale/garden/garden/storetest"
+)
+
+// Replies are what LLM answers a prompt ending with the opening of a code
+// block of each language with, for a service that echoes what it's sent.
+var Replies = map[string]string{
+	"protobuf": `syntax = "proto3";
+
+package echo;
+
+option go_package = "./protobufs";
+
+service Echo {
+  rpc Say(SayRequest) returns (SayReply);
+}
+
+message SayRequest {
+  string text = 1;
+}
+
+message SayReply {
+  string text = 1;
+}
+` + "```",
+	"go": `package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func main() {
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	http.ListenAndServe(":8001", nil)
+}
+` + "```",
+	"json": `{"quality": "good", "reason": "implements the service", "suggestions": "none"}` + "\n```",
+	"dockerfile": `FROM golang:1.20 AS builder
+COPY . /app
+WORKDIR /app
+RUN go get ./...
+RUN go build -o /tmp/svc ./server
+
+FROM debian:bookworm-slim
+RUN groupadd -r appuser && useradd -r -g appuser appuser
+COPY --from=builder /tmp/svc /bin/svc
+USER appuser
+EXPOSE 8000
+EXPOSE 8001
+CMD ["/bin/svc"]
+` + "```",
+	"yaml": `openapi: 3.0.0
+info:
+  title: echo
+  version: 1.0.0
+paths:
+  /healthz:
+    get:
+      responses:
+        "200":
+          description: ok
+` + "```",
+	"bash": `#!/bin/bash
+set -euxo pipefail
+host="${GARDEN_HTTP_HOST:-localhost}"
+port="${GARDEN_HTTP_PORT:?set GARDEN_HTTP_PORT}"
+curl -s "http://$host:$port/healthz"
+` + "```",
+}
+
+// LLM answers every prompt from Replies, and anything else, such as a
+// README, with plain text.
+type LLM struct {
+	// Before, if set, is called with each prompt before it's answered, and
+	// fails the completion if it returns an error.
+	Before func(ctx context.Context, prompt string) error
+	// Reply, if set, answers the prompts it returns a reply for instead of
+	// Replies.
+	Reply func(prompt string) (string, bool)
+
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (f *LLM) Complete(ctx context.Context, prompt string, opts llm.CompletionOptions) (string, error) {
+	f.mu.Lock()
+	f.prompts = append(f.prompts, prompt)
+	f.mu.Unlock()
+	if f.Before != nil {
+		if err := f.Before(ctx, prompt); err != nil {
+			return "", err
+		}
+	}
+	if f.Reply != nil {
+		if reply, ok := f.Reply(prompt); ok {
+			return reply, nil
+		}
+	}
+	for lang, reply := range Replies {
+		if strings.HasSuffix(prompt, "```"+lang+"\n") {
+			return reply, nil
+		}
+	}
+	return "An echo service.", nil
+}
+
+// Prompts are the prompts completed so far, in order.
+func (f *LLM) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.prompts...)
+}
+
+// Runner succeeds at every build command, writing what protoc would have
+// generated.
+type Runner struct {
+	mu    sync.Mutex
+	specs []pipeline.BuildSpec
+}
+
+const generatedPB = `package protobufs
+
+type SayRequest struct {
+	Text string
+}
+
+type SayReply struct {
+	Text string
+}
+`
+
+const generatedGRPC = `package protobufs
+
+type EchoServer interface {
+	Say(*SayRequest) (*SayReply, error)
+}
+`
+
+func (r *Runner) Command(ctx context.Context, spec pipeline.BuildSpec) (*exec.Cmd, error) {
+	r.mu.Lock()
+	r.specs = append(r.specs, spec)
+	r.mu.Unlock()
+	if spec.Name == "protoc" {
+		name := strings.TrimSuffix(filepath.Base(spec.Args[len(spec.Args)-1]), ".proto")
+		dir := filepath.Join(spec.Dir, "protobufs")
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".pb.go"), []byte(generatedPB), 0644); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name+"_grpc.pb.go"), []byte(generatedGRPC), 0644); err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.CommandContext(ctx, "true")
+	cmd.Dir = spec.Dir
+	return cmd, nil
+}
+
+// Ran is how many times the named command was set up, which a step does
+// for each of its attempts, including those that end before running it.
+func (r *Runner) Ran(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, spec := range r.specs {
+		if spec.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+// Docker keeps the state of the containers it's asked to run, publishing
+// each one's ports on new host ports every time it starts.
+type Docker struct {
+	// HostPorts, if set, are the host ports every container's ports are
+	// published on instead.
+	HostPorts map[int]int
+
+	mu       sync.Mutex
+	runs     []dockerx.RunOptions
+	states   map[string]string
+	ports    map[string]map[int]int
+	nextPort int
+}
+
+// publish starts the named container. d.mu must be held.
+func (d *Docker) publish(name string, ports []int) {
+	if d.states == nil {
+		d.states, d.ports, d.nextPort = map[string]string{}, map[string]map[int]int{}, 32000
+	}
+	d.states[name] = "running"
+	published := map[int]int{}
+	for _, port := range ports {
+		published[port] = d.nextPort
+		d.nextPort++
+	}
+	d.ports[name] = published
+}
+
+// restart starts the named container again. d.mu must be held.
+func (d *Docker) restart(name string) error {
+	if _, ok := d.states[name]; !ok {
+		return dockerx.ErrNoContainer
+	}
+	var ports []int
+	for port := range d.ports[name] {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	d.publish(name, ports)
+	return nil
+}
+
+func (d *Docker) Ping(ctx context.Context) error { return nil }
+
+func (d *Docker) States(ctx context.Context) (map[string]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	states := map[string]string{}
+	for name, state := range d.states {
+		states[name] = state
+	}
+	return states, nil
+}
+
+func (d *Docker) State(ctx context.Context, name string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if state, ok := d.states[name]; ok {
+		return state, nil
+	}
+	return dockerx.StateMissing, nil
+}
+
+func (d *Docker) Ports(ctx context.Context, name string) (map[int]int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.HostPorts != nil {
+		return d.HostPorts, nil
+	}
+	if _, ok := d.states[name]; !ok {
+		return nil, dockerx.ErrNoContainer
+	}
+	ports := map[int]int{}
+	if d.states[name] == "running" {
+		for port, host := range d.ports[name] {
+			ports[port] = host
+		}
+	}
+	return ports, nil
+}
+
+func (d *Docker) Run(ctx context.Context, opts dockerx.RunOptions) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.states[opts.Name]; ok {
+		return "", dockerx.ErrNameConflict
+	}
+	d.runs = append(d.runs, opts)
+	d.publish(opts.Name, opts.Ports)
+	return fmt.Sprintf("%016x", len(d.runs)), nil
+}
+
+func (d *Docker) Start(ctx context.Context, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.states[name] == "running" {
+		return nil
+	}
+	return d.restart(name)
+}
+
+func (d *Docker) Stop(ctx context.Context, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.states[name]; !ok {
+		return dockerx.ErrNoContainer
+	}
+	d.states[name] = "exited"
+	return nil
+}
+
+func (d *Docker) Restart(ctx context.Context, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.restart(name)
+}
+
+func (d *Docker) Remove(ctx context.Context, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.states, name)
+	delete(d.ports, name)
+	return nil
+}
+
+func (d *Docker) RemoveImage(ctx context.Context, name string) error         { return nil }
+func (d *Docker) ImageSize(ctx context.Context, name string) (int64, error)  { return 0, nil }
+func (d *Docker) ImageID(ctx context.Context, name string) (string, error)   { return "sha256:0", nil }
+func (d *Docker) Pull(ctx context.Context, image string) error               { return nil }
+func (d *Docker) Build(ctx context.Context, opts dockerx.BuildOptions) error { return nil }
+func (d *Docker) EnsureNetwork(ctx context.Context, name string) error       { return nil }
+
+func (d *Docker) Logs(ctx context.Context, name string, opts dockerx.LogsOptions, stdout, stderr io.Writer) error {
+	return nil
+}
+
+// Runs are the options of every container run so far, in order.
+func (d *Docker) Runs() []dockerx.RunOptions {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]dockerx.RunOptions(nil), d.runs...)
+}
+
+// Env is the dependencies of a pipeline with a database and data dir under
+// a test's temp dir, and the fakes in them.
+type Env struct {
+	Deps   pipeline.Deps
+	LLM    *LLM
+	Runner *Runner
+	Docker *Docker
+}
+
+// New is an Env for t. The image builds that run the docker CLI on the host
+// get one on PATH that succeeds at everything, and the checks that would
+// run the built service are off.
+func New(t testing.TB) *Env {
+	t.Helper()
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("GIT_AUTHOR_NAME", "garden")
+	t.Setenv("GIT_AUTHOR_EMAIL", "garden@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "garden")
+	t.Setenv("GIT_COMMITTER_EMAIL", "garden@example.com")
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "docker"), []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := pipeline.LoadConfig()
+	config.DockerfileTemplate = false
+	config.TranscodingCheck = false
+	config.GRPCSmokeTest = false
+	config.SymbolDocs = false
+	if err := os.MkdirAll(filepath.Join(config.ReposDir(), "seedlings"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	e := &Env{LLM: &LLM{}, Runner: &Runner{}, Docker: &Docker{}}
+	e.Deps = pipeline.Deps{DB: storetest.Open(t), Config: config, LLM: e.LLM, Runner: e.Runner, Docker: e.Docker}
+	return e
+}
+
+// Pipeline is a pipeline of e's dependencies.
+func (e *Env) Pipeline(t testing.TB) *pipeline.Pipeline {
+	t.Helper()
+	p, err := pipeline.New(e.Deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// Seedling stores a seedling at its first step with its repo, the way a
+// create does.
+func (e *Env) Seedling(t testing.TB, name string) store.Seedling {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	seedling := store.Seedling{
+		Name:        name,
+		Garden:      store.DefaultGarden,
+		Description: "echoes what it's sent",
+		Step:        pipeline.SeedlingStepProtobufs,
+		SkipTests:   true,
+		Platform:    pipeline.HostPlatform(),
+		Toolchain:   e.Deps.Config.GoToolchain,
+	}
+	res, err := e.Deps.DB.ExecContext(ctx,
+		`INSERT INTO seedlings (name, garden, description, created_at, modified_at, step, step_started_at, skip_tests, platform, toolchain)
+		 VALUES ($1, $2, $3, $4, $4, $5, $4, $6, $7, $8)`,
+		seedling.Name, seedling.Garden, seedling.Description, now, seedling.Step, seedling.SkipTests, seedling.Platform, seedling.Toolchain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Deps.DB.GetContext(ctx, &seedling, "SELECT * FROM seedlings WHERE id = $1", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Pipeline(t).WriteSeedlingToRepo(ctx, seedling); err != nil {
+		t.Fatal(err)
+	}
+	return seedling
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ApprovalSteps are the steps whose artifacts have to be approved before a
+// seedling's build goes on, stored as a JSON array. A seedling's is nil to
+// use its garden's.
+type ApprovalSteps []string
+
+func (a ApprovalSteps) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal([]string(a))
+	return string(b), err
+}
+
+func (a *ApprovalSteps) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into ApprovalSteps", src)
+	}
+	*a = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, a)
+}
+
+// SeedlingApproval is the seedling's approval gates and, at
+// SeedlingStepAwaitingApproval, the step whose artifact is waiting to be
+// reviewed. ApprovalRequired is its garden's while it's nil. A rejection
+// moves the seedling back to ApprovalStep, whose prompt has ApprovalComment
+// added to it until it's waiting again.
+type SeedlingApproval struct {
+	ApprovalRequired    ApprovalSteps `db:"approval_required" json:"approvalRequired"`
+	ApprovalStep        string        `db:"approval_step" json:"approvalStep,omitempty"`
+	ApprovalRequestedAt *time.Time    `db:"approval_requested_at" json:"approvalRequestedAt,omitempty"`
+	ApprovalComment     string        `db:"approval_comment" json:"approvalComment,omitempty"`
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/c2h5oh/hide"
+)
+
+type Attempt struct {
+	ID              int64      `db:"id" json:"id"`
+	SeedlingID      hide.Int64 `db:"seedling_id" json:"seedlingId"`
+	Step            string     `db:"step" json:"step"`
+	Attempt         int        `db:"attempt" json:"attempt"`
+	Success         bool       `db:"success" json:"success"`
+	Output          string     `db:"output" json:"output"`
+	BuildDurationMS int64      `db:"build_duration_ms" json:"buildDurationMs"`
+	BuildCache      bool       `db:"build_cache" json:"buildCache"`
+	DurationMS      int64      `db:"duration_ms" json:"durationMs"`
+	// File is the repo path the attempt generated, and Code what it wrote
+	// there.
+	File string `db:"file" json:"file"`
+	Code string `db:"code" json:"-"`
+	// Model is the model that wrote the code, with Temperature and
+	// MaxTokens.
+	Model       string  `db:"model" json:"model,omitempty"`
+	Temperature float32 `db:"temperature" json:"temperature"`
+	MaxTokens   int     `db:"max_tokens" json:"maxTokens,omitempty"`
+	// PromptTokens and CompletionTokens are estimated from the prompt and
+	// completion, 0 for code a human accepted.
+	PromptTokens     int `db:"prompt_tokens" json:"promptTokens"`
+	CompletionTokens int `db:"completion_tokens" json:"completionTokens"`
+	// AutoFixes describes what pre-build hooks fixed in the code.
+	AutoFixes string `db:"auto_fixes" json:"autoFixes,omitempty"`
+	// RejectedModules are the modules the import policy rejected the code
+	// for, comma separated.
+	RejectedModules string `db:"rejected_modules" json:"rejectedModules,omitempty"`
+	// ProtoReport is the lint and breaking change check of a protobufs
+	// attempt that compiled.
+	ProtoReport *ProtoReport `db:"proto_report" json:"protoReport,omitempty"`
+	// TranscodingReport is the check of a server attempt's HTTP handlers
+	// against the proto3 JSON mapping of its requests.
+	TranscodingReport *TranscodingReport `db:"transcoding_report" json:"transcodingReport,omitempty"`
+	// Duplicate is set when the code is what an earlier attempt at the step
+	// failed with, so it wasn't built and Output is that attempt's.
+	Duplicate bool `db:"duplicate" json:"duplicate,omitempty"`
+	// TraceID is the OTel trace of the build the attempt was made in, and
+	// TraceURL where to see it.
+	TraceID  string `db:"trace_id" json:"traceId,omitempty"`
+	TraceURL string `db:"-" json:"traceUrl,omitempty"`
+	// CommitSHA is the seedling repo's commit after a successful attempt.
+	CommitSHA string    `db:"commit_sha" json:"commitSha,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// PreviousAttempt returns the attempt at the same step before a, or nil if
+// it was the first.
+func (s *Store) PreviousAttempt(ctx context.Context, a *Attempt) (*Attempt, error) {
+	var prev Attempt
+	if err := s.DB.GetContext(ctx, &prev, `
+	 SELECT * FROM seedling_attempts
+	 WHERE seedling_id = $1 AND step = $2 AND id < $3
+	 ORDER BY id DESC LIMIT 1
+	 `, a.SeedlingID, a.Step, a.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &prev, nil
+}
+
+func (s *Store) GetAttempt(ctx context.Context, seedlingID hide.Int64, param string) (*Attempt, error) {
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+	var a Attempt
+	if err := s.DB.GetContext(ctx, &a,
+		"SELECT * FROM seedling_attempts WHERE seedling_id = $1 AND id = $2", seedlingID, id); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TakenNames returns which of the resource names existing seedlings have, and
+// whether the seedling with each is soft-deleted. Resource names are unique
+// across gardens, so this also checks names are unique within each garden.
+// Deleted seedlings keep their name until they're purged, since their repo is
+// still in its directory.
+func (s *Store) TakenNames(ctx context.Context, names []string) (map[string]bool, error) {
+	query, args, err := sqlx.In("SELECT "+seedlingResourceNameSQL+" AS name, deleted_at IS NOT NULL AS deleted FROM seedlings WHERE "+
+		seedlingResourceNameSQL+" IN (?)", names)
+	if err != nil {
+		return nil, err
+	}
+	rows := []struct {
+		Name    string `db:"name"`
+		Deleted bool   `db:"deleted"`
+	}{}
+	if err := s.DB.SelectContext(ctx, &rows, s.DB.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for _, row := range rows {
+		taken[row.Name] = row.Deleted
+	}
+	return taken, nil
+}
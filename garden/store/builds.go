@@ -0,0 +1,14 @@
+package store
+
+import (
+	"time"
+
+	"github.com/c2h5oh/hide"
+)
+
+type BuildLease struct {
+	SeedlingID  hide.Int64 `db:"seedling_id" json:"-"`
+	Holder      string     `db:"holder" json:"holder"`
+	AcquiredAt  time.Time  `db:"acquired_at" json:"acquiredAt"`
+	HeartbeatAt time.Time  `db:"heartbeat_at" json:"heartbeatAt"`
+}
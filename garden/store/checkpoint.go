@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+)
+
+// SeedlingCheckpoint is where a build is within its current step, saved
+// after every attempt so a build resumed after a restart carries on with the
+// same conversation instead of starting the step over.
+type SeedlingCheckpoint struct {
+	SeedlingID    hide.Int64 `db:"seedling_id"`
+	Step          string     `db:"step"`
+	Attempt       int        `db:"attempt"`
+	Errs          int        `db:"errs"`
+	ErrMode       bool       `db:"err_mode"`
+	DumpedModDocs bool       `db:"dumped_mod_docs"`
+	// Prompt is the conversation so far, including the last attempt and
+	// what went wrong with it.
+	Prompt     string    `db:"prompt"`
+	LastOutput string    `db:"last_output"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+// RecordAttempt stores an attempt and, in the same transaction, the
+// checkpoint the build would resume from after it.
+func (s *Store) RecordAttempt(ctx context.Context, a Attempt, cp SeedlingCheckpoint) error {
+	a.CreatedAt = time.Now()
+	cp.SeedlingID = a.SeedlingID
+	cp.UpdatedAt = a.CreatedAt
+
+	return s.InTx(ctx, func(tx *sqlx.Tx) error {
+		if err := insertAttempt(ctx, tx, &a); err != nil {
+			return err
+		}
+		_, err := tx.NamedExecContext(ctx, `
+		 INSERT INTO seedling_checkpoints
+		 (seedling_id, step, attempt, errs, err_mode, dumped_mod_docs, prompt, last_output, updated_at)
+		 VALUES (:seedling_id, :step, :attempt, :errs, :err_mode, :dumped_mod_docs, :prompt, :last_output, :updated_at)
+		 ON CONFLICT (seedling_id) DO UPDATE SET
+		   step = excluded.step,
+		   attempt = excluded.attempt,
+		   errs = excluded.errs,
+		   err_mode = excluded.err_mode,
+		   dumped_mod_docs = excluded.dumped_mod_docs,
+		   prompt = excluded.prompt,
+		   last_output = excluded.last_output,
+		   updated_at = excluded.updated_at
+		 `, &cp)
+		return err
+	})
+}
+
+// RecordBranchAttempt stores an attempt at a step run on a branch. Branches
+// have no checkpoint, a resumed build starts them over.
+func (s *Store) RecordBranchAttempt(ctx context.Context, a Attempt) error {
+	a.CreatedAt = time.Now()
+	return s.InTx(ctx, func(tx *sqlx.Tx) error {
+		return insertAttempt(ctx, tx, &a)
+	})
+}
+
+func insertAttempt(ctx context.Context, tx *sqlx.Tx, a *Attempt) error {
+	_, err := tx.NamedExecContext(ctx, `
+	 INSERT INTO seedling_attempts
+	 (seedling_id, step, attempt, success, output, build_duration_ms, build_cache, duration_ms, file, code, model, temperature, max_tokens, prompt_tokens, completion_tokens, auto_fixes, rejected_modules, proto_report, transcoding_report, duplicate, commit_sha, trace_id, created_at)
+	 VALUES (:seedling_id, :step, :attempt, :success, :output, :build_duration_ms, :build_cache, :duration_ms, :file, :code, :model, :temperature, :max_tokens, :prompt_tokens, :completion_tokens, :auto_fixes, :rejected_modules, :proto_report, :transcoding_report, :duplicate, :commit_sha, :trace_id, :created_at)
+	 `, a)
+	return err
+}
+
+// LoadCheckpoint returns the seedling's checkpoint, or nil if it has none.
+func (s *Store) LoadCheckpoint(ctx context.Context, seedlingID hide.Int64) (*SeedlingCheckpoint, error) {
+	var cp SeedlingCheckpoint
+	if err := s.DB.GetContext(ctx, &cp,
+		"SELECT * FROM seedling_checkpoints WHERE seedling_id = $1", seedlingID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (s *Store) ClearCheckpoint(ctx context.Context, seedlingID hide.Int64) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM seedling_checkpoints WHERE seedling_id = $1", seedlingID)
+	return err
+}
+
+// StepAttempts returns the attempts at the step since the seedling entered
+// it, oldest first, which a resumed build carries on from whether or not it
+// has a checkpoint.
+func (s *Store) StepAttempts(ctx context.Context, seedling Seedling, step string) ([]Attempt, error) {
+	since := time.Time{}
+	if seedling.StepStartedAt != nil {
+		since = *seedling.StepStartedAt
+	}
+	attempts := []Attempt{}
+	err := s.DB.SelectContext(ctx, &attempts, `
+	 SELECT * FROM seedling_attempts
+	 WHERE seedling_id = $1 AND step = $2 AND created_at >= $3
+	 ORDER BY id
+	 `, seedling.ID, step, since)
+	return attempts, err
+}
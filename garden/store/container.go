@@ -0,0 +1,15 @@
+package store
+
+import (
+	"time"
+)
+
+// SeedlingContainer is the state of the container running a seedling and the
+// last action taken on it through the API.
+type SeedlingContainer struct {
+	// ContainerState is read from docker, not stored.
+	ContainerState    string     `db:"-" json:"containerState,omitempty"`
+	ContainerAction   string     `db:"container_action" json:"containerAction,omitempty"`
+	ContainerActionBy string     `db:"container_action_by" json:"containerActionBy,omitempty"`
+	ContainerActionAt *time.Time `db:"container_action_at" json:"containerActionAt,omitempty"`
+}
@@ -0,0 +1,15 @@
+package store
+
+import (
+	"time"
+)
+
+// SeedlingDeleted is set on seedlings that were deleted without ?hard=true.
+// They're hidden and their container is stopped, but their repo and image
+// are kept until the GC purges them DeletedRetention after DeletedAt.
+type SeedlingDeleted struct {
+	DeletedAt *time.Time `db:"deleted_at" json:"deletedAt,omitempty"`
+	// DeletedWhileRunning is whether the container was running when the
+	// seedling was deleted, so a restore can start it again.
+	DeletedWhileRunning bool `db:"deleted_while_running" json:"-"`
+}
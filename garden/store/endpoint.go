@@ -0,0 +1,8 @@
+package store
+
+// EndpointService is a gRPC service and the names of its methods, streaming
+// or not.
+type EndpointService struct {
+	Name    string   `json:"name"`
+	Methods []string `json:"methods"`
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"time"
+
+	"github.com/c2h5oh/hide"
+)
+
+// EnvRequirement is an environment variable a seedling's server reads.
+// Required ones must be set before its container is started.
+type EnvRequirement struct {
+	SeedlingID  hide.Int64 `db:"seedling_id" json:"-"`
+	Name        string     `db:"name" json:"name"`
+	Required    bool       `db:"required" json:"required"`
+	Description string     `db:"description" json:"description,omitempty"`
+	// Provided is whether a value is set. Values are never returned.
+	Provided   bool      `db:"provided" json:"provided"`
+	CreatedAt  time.Time `db:"created_at" json:"createdAt"`
+	ModifiedAt time.Time `db:"modified_at" json:"modifiedAt"`
+}
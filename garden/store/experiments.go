@@ -0,0 +1,13 @@
+package store
+
+import (
+	"github.com/c2h5oh/hide"
+)
+
+// SeedlingExperiment links a seedling to the experiment that created it, as
+// its variant, numbered from 1. Kept seedlings outlive their experiment.
+type SeedlingExperiment struct {
+	ExperimentID      *hide.Int64 `db:"experiment_id" json:"experimentId,omitempty"`
+	ExperimentVariant int         `db:"experiment_variant" json:"experimentVariant,omitempty"`
+	Kept              bool        `db:"kept" json:"kept,omitempty"`
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+)
+
+// DefaultGarden is the garden of seedlings created without one, and of every
+// seedling created before there were gardens. Its seedlings keep the
+// directories, network and container names they always had.
+const DefaultGarden = "default"
+
+// seedlingResourceNameSQL is Seedling.ResourceName as an SQL expression over
+// the seedlings table.
+const seedlingResourceNameSQL = "(CASE WHEN garden = '" + DefaultGarden + "' THEN name ELSE garden || '.' || name END)"
+
+// GardenName is the seedling's garden, DefaultGarden if it isn't set.
+func (seedling Seedling) GardenName() string {
+	if seedling.Garden == "" {
+		return DefaultGarden
+	}
+	return seedling.Garden
+}
+
+// ResourceName names the seedling's container, image, outputs directory and
+// archive, which are shared by every garden on the instance: its name in the
+// default garden, and garden.name in the others. Garden names have no dots,
+// so a seedling in the default garden can't take another's resource name
+// without it being caught as a taken name.
+func (seedling Seedling) ResourceName() string {
+	if seedling.GardenName() == DefaultGarden {
+		return seedling.Name
+	}
+	return seedling.GardenName() + "." + seedling.Name
+}
+
+func (s *Store) GardenExists(ctx context.Context, garden string) (bool, error) {
+	var n int
+	err := s.DB.GetContext(ctx, &n, "SELECT COUNT(*) FROM gardens WHERE name = $1", garden)
+	return n > 0, err
+}
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"time"
+)
+
+// SeedlingGit is where a seedling's repo is pushed to and the outcome of the
+// last push.
+type SeedlingGit struct {
+	GitRemoteURL      string     `db:"git_remote_url" json:"remoteUrl"`
+	GitBranch         string     `db:"git_branch" json:"branch"`
+	GitPushOnComplete bool       `db:"git_push_on_complete" json:"pushOnComplete"`
+	GitPushedURL      string     `db:"git_pushed_url" json:"pushedUrl,omitempty"`
+	GitPushedSHA      string     `db:"git_pushed_sha" json:"pushedSha,omitempty"`
+	GitPushedAt       *time.Time `db:"git_pushed_at" json:"pushedAt,omitempty"`
+	GitPushError      string     `db:"git_push_error" json:"pushError,omitempty"`
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// GRPCSmoke is what gRPC reflection on a seedling's running container found,
+// checked against the services its proto declares.
+type GRPCSmoke struct {
+	Services []EndpointService `json:"services"`
+	// Missing are the declared services and rpcs the server doesn't expose.
+	Missing   []string  `json:"missing"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+func (g GRPCSmoke) Value() (driver.Value, error) {
+	b, err := json.Marshal(g)
+	return string(b), err
+}
+
+func (g *GRPCSmoke) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into GRPCSmoke", src)
+	}
+	return json.Unmarshal(data, g)
+}
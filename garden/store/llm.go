@@ -0,0 +1,31 @@
+package store
+
+import (
+	"fmt"
+)
+
+const (
+	// MaxTemperature bounds the temperature a seedling can choose and the
+	// one it's raised to when the model repeats itself.
+	MaxTemperature = 2.0
+)
+
+// SeedlingGeneration are the completion parameters a seedling is built with.
+// Unset, the temperature falls with each error, MaxTokens is the config's and
+// Model is each step's configured model.
+type SeedlingGeneration struct {
+	Model       string   `db:"model" json:"model,omitempty"`
+	Temperature *float32 `db:"temperature" json:"temperature,omitempty"`
+	MaxTokens   int      `db:"max_tokens" json:"maxTokens,omitempty"`
+}
+
+// Check returns why the parameters are out of bounds, or "" if they aren't.
+func (g SeedlingGeneration) Check(maxTokensLimit int) string {
+	if g.Temperature != nil && (*g.Temperature < 0 || *g.Temperature > MaxTemperature) {
+		return fmt.Sprintf("temperature must be between 0 and %g", MaxTemperature)
+	}
+	if g.MaxTokens < 0 || g.MaxTokens > maxTokensLimit {
+		return fmt.Sprintf("maxTokens must be between 1 and %d", maxTokensLimit)
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"time"
+
+	"github.com/c2h5oh/hide"
+)
+
+type DBRow struct {
+	ID         hide.Int64 `db:"id" json:"id"`
+	CreatedAt  time.Time  `db:"created_at" json:"createdAt"`
+	ModifiedAt time.Time  `db:"modified_at" json:"modifiedAt"`
+}
+
+type Seedling struct {
+	DBRow
+	Name        string `db:"name" json:"name"`
+	Garden      string `db:"garden" json:"garden"`
+	Description string `db:"description" json:"description"`
+	Step        string `db:"step" json:"step"`
+	SkipTests   bool   `db:"skip_tests" json:"skipTests"`
+	// StepStartedAt is when the seedling entered its current step.
+	StepStartedAt *time.Time `db:"step_started_at" json:"stepStartedAt,omitempty"`
+	// ETASeconds is computed from historical step durations, not stored.
+	ETASeconds  int64  `db:"-" json:"etaSeconds"`
+	TestsPassed int    `db:"tests_passed" json:"testsPassed"`
+	TestsFailed int    `db:"tests_failed" json:"testsFailed"`
+	Platform    string `db:"platform" json:"platform"`
+	// Toolchain is the Go version the seedling is built with, one of
+	// GO_TOOLCHAINS.
+	Toolchain   string `db:"toolchain" json:"toolchain"`
+	Archived    bool   `db:"archived" json:"archived"`
+	SeedlingGit `json:"git"`
+	SeedlingRefine
+	SeedlingTemplate
+	SeedlingContainer
+	SeedlingDeleted
+	SeedlingGeneration
+	SeedlingResources `json:"resources"`
+	SeedlingPorts     `json:"ports"`
+	SeedlingReadme
+	SeedlingExperiment
+	SeedlingRebuildSchedule
+	SeedlingPromotion
+	SeedlingApproval
+	// Plan is the plan the seedling was approved with, or is waiting at
+	// SeedlingStepPlan to be approved with. AutoApprove builds it without
+	// waiting, from the plan it's created with if any.
+	Plan        *SeedlingPlan `db:"plan" json:"plan,omitempty"`
+	AutoApprove bool          `db:"-" json:"autoApprove,omitempty"`
+	// IgnoreDuplicates creates the seedling even if its description is
+	// like an existing seedling's.
+	IgnoreDuplicates bool `db:"-" json:"ignoreDuplicates,omitempty"`
+	// RequestedName is the name the seedling was created with, returned
+	// by creates when it was normalized into Name.
+	RequestedName string `db:"-" json:"requestedName,omitempty"`
+	// FailureReason is why the seedling's last build stopped without
+	// completing, at FailedStep, which a retry resumes from.
+	FailureReason string     `db:"failure_reason" json:"failureReason,omitempty"`
+	FailedStep    string     `db:"failed_step" json:"failedStep,omitempty"`
+	FailedAt      *time.Time `db:"failed_at" json:"failedAt,omitempty"`
+	// StaleDescription is set when the seedling's description or generation
+	// parameters were changed while it was being built, by a build that
+	// carried on without them. It's cleared by a build from the first step
+	// or a refine.
+	StaleDescription bool `db:"stale_description" json:"staleDescription,omitempty"`
+	// CurrentRevision is the number of the revision the container runs, 0
+	// until the seedling's first build completes.
+	CurrentRevision int `db:"current_revision" json:"currentRevision,omitempty"`
+	// GRPCPort and HTTPPort are the host ports the container was last seen
+	// published on.
+	GRPCPort int `db:"grpc_port" json:"grpcPort,omitempty"`
+	HTTPPort int `db:"http_port" json:"httpPort,omitempty"`
+	// GRPCSmoke is the last gRPC smoke test of the running container.
+	GRPCSmoke *GRPCSmoke `db:"grpc_smoke" json:"grpcSmoke,omitempty"`
+	// OutputsQuotaExceeded is set when the container was stopped for writing
+	// more than OutputsMaxBytes to /outputs.
+	OutputsQuotaExceeded bool `db:"outputs_quota_exceeded" json:"outputsQuotaExceeded"`
+	// LLMKeyID names the OpenAI key the seedling is built with: "" for
+	// the server's, apikey:<name> for its API key's in OPENAI_API_KEYS,
+	// or request:<fingerprint> for one it was created with in
+	// X-OpenAI-Key, which is stored encrypted in LLMKey.
+	LLMKeyID string `db:"llm_key_id" json:"llmKeyId,omitempty"`
+	LLMKey   string `db:"llm_key" json:"-"`
+	// Version goes up with every change to the seedling, by clients or its
+	// build. It's the seedling's ETag, which updates must send in If-Match.
+	Version int64 `db:"version" json:"version"`
+	// Tags are stored in seedling_tags.
+	Tags []string `db:"-" json:"tags"`
+	// DependsOn names the complete seedlings of its garden the seedling's
+	// server calls, which it's created with. They're stored in
+	// seedling_dependencies, and listed with Dependents, the seedlings
+	// depending on it, only for single seedlings.
+	DependsOn  []string `db:"-" json:"dependsOn,omitempty"`
+	Dependents []string `db:"-" json:"dependents,omitempty"`
+	// Env is stored in seedling_env_requirements and only returned for
+	// single seedlings.
+	Env []EnvRequirement `db:"-" json:"env,omitempty"`
+	// Steps are stored in seedling_step_statuses and only returned for
+	// single seedlings.
+	Steps []SeedlingStepStatus `db:"-" json:"steps,omitempty"`
+	// Lease is the build lease currently held on the seedling, if any.
+	Lease *BuildLease `db:"-" json:"lease,omitempty"`
+	// Progress is only returned by GetSeedling, with ?expand=progress.
+	Progress *SeedlingProgress `db:"-" json:"progress,omitempty"`
+	// Warning is set on update responses whose changes the seedling's
+	// running build won't pick up.
+	Warning string `db:"-" json:"warning,omitempty"`
+	// TraceID is the OTel trace of the seedling's last build, and
+	// TraceURL where to see it. Both are omitted with OTel off.
+	TraceID  string `db:"trace_id" json:"traceId,omitempty"`
+	TraceURL string `db:"-" json:"traceUrl,omitempty"`
+}
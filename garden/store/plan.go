@@ -0,0 +1,103 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SeedlingPlan expands a seedling's description into what it should
+// implement. Unless the seedling is created with autoApprove, it waits at
+// SeedlingStepPlan until its plan is approved, and the approved plan is what
+// it's prompted with from then on.
+type SeedlingPlan struct {
+	// Summary is a one sentence description of the service, which stands in
+	// for the description in prompts.
+	Summary  string       `json:"summary"`
+	Entities []PlanEntity `json:"entities"`
+	RPCs     []PlanRPC    `json:"rpcs"`
+	// Notes are non-functional requirements, e.g. persistence or limits.
+	Notes []string `json:"notes"`
+}
+
+type PlanEntity struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+type PlanRPC struct {
+	Name        string `json:"name"`
+	Request     string `json:"request"`
+	Response    string `json:"response"`
+	Description string `json:"description"`
+}
+
+func (p SeedlingPlan) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *SeedlingPlan) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into SeedlingPlan", src)
+	}
+	return json.Unmarshal(data, p)
+}
+
+// Check returns why a plan can't be built from, or "" if it can.
+func (p *SeedlingPlan) Check() string {
+	if strings.TrimSpace(p.Summary) == "" {
+		return "plan has no summary"
+	}
+	if len(p.RPCs) == 0 {
+		return "plan has no rpcs"
+	}
+	for _, rpc := range p.RPCs {
+		if strings.TrimSpace(rpc.Name) == "" {
+			return "plan has an rpc without a name"
+		}
+	}
+	return ""
+}
+
+// PlanHint describes the plan for prompts, or is empty without one.
+func (p *SeedlingPlan) PlanHint() string {
+	if p == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\nImplement this plan for it.\n\n")
+	if len(p.Entities) > 0 {
+		b.WriteString("Entities:\n")
+		for _, e := range p.Entities {
+			fmt.Fprintf(&b, "- %s: %s\n", e.Name, strings.Join(e.Fields, ", "))
+		}
+	}
+	b.WriteString("RPCs:\n")
+	for _, rpc := range p.RPCs {
+		fmt.Fprintf(&b, "- %s(%s) returns (%s): %s\n", rpc.Name, rpc.Request, rpc.Response, rpc.Description)
+	}
+	if len(p.Notes) > 0 {
+		b.WriteString("Notes:\n")
+		for _, note := range p.Notes {
+			fmt.Fprintf(&b, "- %s\n", note)
+		}
+	}
+	return b.String() + "\n"
+}
+
+// Brief is what the seedling is described as in prompts: its approved plan's
+// summary, or its description.
+func (seedling Seedling) Brief() string {
+	if seedling.Plan != nil && seedling.Plan.Summary != "" {
+		return seedling.Plan.Summary
+	}
+	return seedling.Description
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"fmt"
+)
+
+const (
+	DefaultGRPCContainerPort = 8000
+	DefaultHTTPContainerPort = 8001
+)
+
+// SeedlingPorts are the ports the seedling's gRPC server and HTTP shim
+// listen on inside its container. They're written into its prompts,
+// Dockerfile and compose file, so seedlings composed onto one network can be
+// given ports that don't clash. The host ports they're published on are
+// GRPCPort and HTTPPort.
+type SeedlingPorts struct {
+	GRPCContainerPort int `db:"grpc_container_port" json:"grpc,omitempty"`
+	HTTPContainerPort int `db:"http_container_port" json:"http,omitempty"`
+}
+
+// WithDefaults fills in 8000 and 8001 for ports that aren't set.
+func (p SeedlingPorts) WithDefaults() SeedlingPorts {
+	if p.GRPCContainerPort == 0 {
+		p.GRPCContainerPort = DefaultGRPCContainerPort
+	}
+	if p.HTTPContainerPort == 0 {
+		p.HTTPContainerPort = DefaultHTTPContainerPort
+	}
+	return p
+}
+
+// Check returns why the ports are invalid, or "" if they aren't. Unset
+// ports are checked as their defaults.
+func (p SeedlingPorts) Check() string {
+	if p.GRPCContainerPort < 0 || p.GRPCContainerPort > 65535 {
+		return fmt.Sprintf("invalid gRPC port %d", p.GRPCContainerPort)
+	}
+	if p.HTTPContainerPort < 0 || p.HTTPContainerPort > 65535 {
+		return fmt.Sprintf("invalid HTTP port %d", p.HTTPContainerPort)
+	}
+	if p = p.WithDefaults(); p.GRPCContainerPort == p.HTTPContainerPort {
+		return fmt.Sprintf("the gRPC and HTTP ports must differ, both are %d", p.GRPCContainerPort)
+	}
+	return ""
+}
+
+// RunPorts are the container ports to publish on random host ports.
+func (p SeedlingPorts) RunPorts() []int {
+	p = p.WithDefaults()
+	return []int{p.HTTPContainerPort, p.GRPCContainerPort}
+}
+
+// ComposeService is the ports as the expose key of a docker-compose service,
+// indented to go under it.
+func (p SeedlingPorts) ComposeService() string {
+	p = p.WithDefaults()
+	return fmt.Sprintf("    expose:\n    - \"%d\"\n    - \"%d\"\n", p.GRPCContainerPort, p.HTTPContainerPort)
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// SeedlingProgress is what a seedling's build has got to, for following it
+// without access to the repo: where each step is, the files it has
+// generated so far and its container. Degraded names the parts that couldn't
+// be read and are left out.
+type SeedlingProgress struct {
+	Steps          []ProgressStep `json:"steps"`
+	Files          []ProgressFile `json:"files"`
+	FilesTruncated bool           `json:"filesTruncated,omitempty"`
+	ContainerState string         `json:"containerState,omitempty"`
+	Degraded       []string       `json:"degraded,omitempty"`
+}
+
+// ProgressStep is one step of a seedling's pipeline. Status is pending,
+// running, succeeded or failed: steps being fixed are running, and blocked
+// ones pending. Attempt is the number of its latest attempt, and Error what
+// the latest one failed with while the step is running or once it's failed.
+type ProgressStep struct {
+	Step       string     `json:"step"`
+	Status     string     `json:"status"`
+	Attempt    int        `json:"attempt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// ProgressFile is a file in a seedling's repo, by its path in it.
+type ProgressFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// LatestAttempt is the last attempt at a step, with the start of its output.
+type LatestAttempt struct {
+	Step    string `db:"step"`
+	Attempt int    `db:"attempt"`
+	Success bool   `db:"success"`
+	Output  string `db:"output"`
+}
+
+// LatestAttempts returns the seedling's last attempt at each step.
+func (s *Store) LatestAttempts(ctx context.Context, seedling Seedling) (map[string]LatestAttempt, error) {
+	rows := []LatestAttempt{}
+	if err := s.Reads.SelectContext(ctx, &rows, `
+	 SELECT step, attempt, success, substr(output, 1, 8192) AS output FROM seedling_attempts
+	 WHERE id IN (SELECT MAX(id) FROM seedling_attempts WHERE seedling_id = $1 GROUP BY step)
+	 `, seedling.ID); err != nil {
+		return nil, err
+	}
+	attempts := map[string]LatestAttempt{}
+	for _, row := range rows {
+		attempts[row.Step] = row
+	}
+	return attempts, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// HardeningItem is a change a promotion makes to a seedling, with its own
+// refine.
+type HardeningItem struct {
+	ID          string `json:"id"`
+	Instruction string `json:"instruction"`
+}
+
+// SeedlingPromotion is whether a seedling has been hardened for production
+// by a promotion, which sets Hardened once every item of its checklist was
+// verified, and the last promotion.
+type SeedlingPromotion struct {
+	Hardened  bool       `db:"hardened" json:"hardened"`
+	Promotion *Promotion `db:"promotion" json:"promotion,omitempty"`
+}
+
+// Promotion is a seedling's run through a hardening checklist. Items are
+// refined one by one on HardenedBranch, branched from From, and each has
+// a result once its refine completed and it was verified. It's finished
+// once they all have.
+type Promotion struct {
+	Items   []HardeningItem   `json:"items"`
+	Results []HardeningResult `json:"results"`
+	// Refines is how many refines the current item has had.
+	Refines    int        `json:"refines,omitempty"`
+	From       string     `json:"from"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// HardeningResult is how an item was verified. Refines is 0 for an item
+// its check found already done, and Commit is the one its last refine made.
+type HardeningResult struct {
+	ID         string `json:"id"`
+	Passed     bool   `json:"passed"`
+	VerifiedBy string `json:"verifiedBy"`
+	Detail     string `json:"detail,omitempty"`
+	Refines    int    `json:"refines"`
+	Commit     string `json:"commit,omitempty"`
+}
+
+func (p Promotion) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *Promotion) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into Promotion", src)
+	}
+	return json.Unmarshal(data, p)
+}
+
+// InProgress is whether the promotion still has items to refine.
+func (p *Promotion) InProgress() bool {
+	return p != nil && p.FinishedAt == nil
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// ProtoViolation is a rule a generated proto broke. Rules are named after
+// buf's lint and breaking change rules.
+type ProtoViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Breaking is set on changes from the proto a refine started from that
+	// would break its existing clients.
+	Breaking bool `json:"breaking,omitempty"`
+}
+
+// ProtoReport is what linting a generated proto and comparing it with the
+// one a refine started from found.
+type ProtoReport struct {
+	// Against is the commit the proto was compared with, empty when it was
+	// only linted.
+	Against string `json:"against,omitempty"`
+	// AllowBreaking is set when the refine asked for breaking changes to be
+	// let through.
+	AllowBreaking bool             `json:"allowBreaking,omitempty"`
+	Violations    []ProtoViolation `json:"violations"`
+}
+
+func (p ProtoReport) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *ProtoReport) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into ProtoReport", src)
+	}
+	return json.Unmarshal(data, p)
+}
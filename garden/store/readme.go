@@ -0,0 +1,11 @@
+package store
+
+import (
+	"time"
+)
+
+// SeedlingReadme is the outcome of the last README generation.
+type SeedlingReadme struct {
+	ReadmeGeneratedAt *time.Time `db:"readme_generated_at" json:"readmeGeneratedAt,omitempty"`
+	ReadmeError       string     `db:"readme_error" json:"readmeError,omitempty"`
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinRebuildInterval is how often a seedling may be rebuilt at most.
+const MinRebuildInterval = time.Hour
+
+var (
+	// rebuildScheduleAliases are the schedules with names, as cron spells
+	// them.
+	rebuildScheduleAliases = map[string]string{
+		"@hourly":  "0 * * * *",
+		"@daily":   "0 0 * * *",
+		"@weekly":  "0 0 * * 0",
+		"@monthly": "0 0 1 * *",
+	}
+)
+
+// SeedlingRebuildSchedule is when a complete seedling's committed code is
+// built again, without the model, to find out whether it still builds as
+// its dependencies and base images move on. One that doesn't is flagged
+// Bitrot until it builds again, and with AutoHeal refined to fix it.
+type SeedlingRebuildSchedule struct {
+	// RebuildSchedule is a cron schedule in UTC, e.g. "0 4 * * 1", one of
+	// @hourly, @daily, @weekly and @monthly, or "@every 12h". Nothing is
+	// rebuilt without one.
+	RebuildSchedule string     `db:"rebuild_schedule" json:"rebuildSchedule,omitempty"`
+	RebuildDueAt    *time.Time `db:"rebuild_due_at" json:"rebuildDueAt,omitempty"`
+	AutoHeal        bool       `db:"auto_heal" json:"autoHeal,omitempty"`
+	Bitrot          bool       `db:"bitrot" json:"bitrot"`
+	BitrotReason    string     `db:"bitrot_reason" json:"bitrotReason,omitempty"`
+}
+
+// SetSchedule validates the schedule and sets when the first rebuild after
+// now is due.
+func (r *SeedlingRebuildSchedule) SetSchedule(spec string, now time.Time) error {
+	r.RebuildSchedule = strings.Join(strings.Fields(spec), " ")
+	r.RebuildDueAt = nil
+	if r.RebuildSchedule == "" {
+		return nil
+	}
+	schedule, err := parseRebuildSchedule(r.RebuildSchedule)
+	if err != nil {
+		return err
+	}
+	due := schedule.next(now)
+	r.RebuildDueAt = &due
+	return nil
+}
+
+// rebuildSchedule is a parsed RebuildSchedule: every interval, or the
+// minutes, hours, days, months and weekdays of a cron schedule as bitsets.
+type rebuildSchedule struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+func parseRebuildSchedule(spec string) (rebuildSchedule, error) {
+	if every := strings.TrimPrefix(spec, "@every "); every != spec {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil {
+			return rebuildSchedule{}, fmt.Errorf("invalid interval %q", every)
+		}
+		if d < MinRebuildInterval {
+			return rebuildSchedule{}, fmt.Errorf("seedlings are rebuilt at most every %s", MinRebuildInterval)
+		}
+		return rebuildSchedule{every: d}, nil
+	}
+	if alias, ok := rebuildScheduleAliases[spec]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return rebuildSchedule{}, fmt.Errorf("invalid schedule %q, want 5 cron fields, @hourly, @daily, @weekly, @monthly or @every <duration>", spec)
+	}
+	if _, err := strconv.Atoi(fields[0]); err != nil {
+		return rebuildSchedule{}, fmt.Errorf("seedlings are rebuilt at most hourly, so the minute must be a single number")
+	}
+	var sc rebuildSchedule
+	var err error
+	for i, f := range []struct {
+		name     string
+		min, max int
+		set      *uint64
+	}{
+		{"minute", 0, 59, &sc.minute},
+		{"hour", 0, 23, &sc.hour},
+		{"day of month", 1, 31, &sc.dom},
+		{"month", 1, 12, &sc.month},
+		{"day of week", 0, 7, &sc.dow},
+	} {
+		if *f.set, err = parseCronField(fields[i], f.min, f.max); err != nil {
+			return rebuildSchedule{}, fmt.Errorf("invalid %s %q: %v", f.name, fields[i], err)
+		}
+	}
+	// Sunday is both 0 and 7.
+	if sc.dow&(1<<7) != 0 {
+		sc.dow |= 1
+	}
+	sc.anyDom, sc.anyDow = fields[2] == "*", fields[4] == "*"
+	if sc.next(time.Now()).IsZero() {
+		return rebuildSchedule{}, fmt.Errorf("schedule %q never runs", spec)
+	}
+	return sc, nil
+}
+
+// parseCronField parses a comma separated list of *, n, n-m, each
+// optionally followed by /step, into a bitset of the values in min..max.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.New("invalid step")
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, errors.New("not a number")
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, errors.New("not a number")
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next is the first time the schedule runs after t, or the zero time if
+// it doesn't in the next five years.
+func (sc rebuildSchedule) next(t time.Time) time.Time {
+	if sc.every > 0 {
+		return t.UTC().Add(sc.every)
+	}
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case sc.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !sc.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case sc.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+		case sc.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches is cron's: with both the day of the month and of the week
+// restricted, either matching is enough.
+func (sc rebuildSchedule) dayMatches(t time.Time) bool {
+	dom := sc.dom&(1<<uint(t.Day())) != 0
+	dow := sc.dow&(1<<uint(t.Weekday())) != 0
+	if !sc.anyDom && !sc.anyDow {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,17 @@
+package store
+
+// SeedlingRefine is a change requested to a completed seedling. Refining
+// rebuilds it from the protobufs or the server step with its current code
+// and the instruction in the prompt.
+type SeedlingRefine struct {
+	// RefineInstruction is set while a refine is in progress.
+	RefineInstruction string `db:"refine_instruction" json:"refineInstruction,omitempty"`
+	// RefineBase is the commit the refine started from, which its commits
+	// are squashed onto once it completes.
+	RefineBase string `db:"refine_base" json:"-"`
+	// Revision counts completed and in-progress refines.
+	Revision int `db:"revision" json:"revision"`
+	// RefineAllowBreaking lets the refine make changes to the proto that
+	// break existing clients, which are otherwise fed back to the model.
+	RefineAllowBreaking bool `db:"refine_allow_breaking" json:"refineAllowBreaking,omitempty"`
+}
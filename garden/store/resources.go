@@ -0,0 +1,49 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/tensorscale/garden/garden/dockerx"
+)
+
+// SeedlingResources limit the container a seedling runs in, as docker run's
+// --memory, --cpus, --pids-limit and --restart. Fields left empty in the
+// create request are set to the config's defaults, so what's stored is what
+// the container runs with.
+type SeedlingResources struct {
+	Memory        string `db:"memory" json:"memory,omitempty"`
+	CPUs          string `db:"cpus" json:"cpus,omitempty"`
+	PidsLimit     int    `db:"pids_limit" json:"pidsLimit,omitempty"`
+	RestartPolicy string `db:"restart_policy" json:"restartPolicy,omitempty"`
+}
+
+// RunOptions applies the limits to the container run with opts.
+func (r SeedlingResources) RunOptions(opts *dockerx.RunOptions) {
+	opts.Memory = r.Memory
+	opts.CPUs = r.CPUs
+	opts.PidsLimit = r.PidsLimit
+	opts.RestartPolicy = r.RestartPolicy
+}
+
+// ComposeService is the limits as keys of a docker-compose service, indented
+// to go under it.
+func (r SeedlingResources) ComposeService() string {
+	out := ""
+	if r.RestartPolicy != "" {
+		out += fmt.Sprintf("    restart: %q\n", r.RestartPolicy)
+	}
+	limits := ""
+	if r.CPUs != "" {
+		limits += fmt.Sprintf("          cpus: %q\n", r.CPUs)
+	}
+	if r.Memory != "" {
+		limits += fmt.Sprintf("          memory: %s\n", r.Memory)
+	}
+	if r.PidsLimit > 0 {
+		limits += fmt.Sprintf("          pids: %d\n", r.PidsLimit)
+	}
+	if limits != "" {
+		out += "    deploy:\n      resources:\n        limits:\n" + limits
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+
+	"github.com/c2h5oh/hide"
+	"github.com/jmoiron/sqlx"
+)
+
+// SetTags replaces a seedling's tags.
+func SetTags(ctx context.Context, tx *sqlx.Tx, seedlingID hide.Int64, tags []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM seedling_tags WHERE seedling_id = $1", seedlingID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO seedling_tags (seedling_id, tag) VALUES ($1, $2)", seedlingID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) ReplaceTags(ctx context.Context, seedlingID hide.Int64, tags []string) error {
+	return s.InTx(ctx, func(tx *sqlx.Tx) error {
+		return SetTags(ctx, tx, seedlingID, tags)
+	})
+}
+
+// AttachTags sets Tags on each seedling.
+func (s *Store) AttachTags(ctx context.Context, seedlings []*Seedling) error {
+	if len(seedlings) == 0 {
+		return nil
+	}
+	ids := make([]hide.Int64, len(seedlings))
+	byID := map[hide.Int64]*Seedling{}
+	for i, seedling := range seedlings {
+		ids[i] = seedling.ID
+		byID[seedling.ID] = seedling
+		seedling.Tags = []string{}
+	}
+	query, args, err := sqlx.In("SELECT seedling_id, tag FROM seedling_tags WHERE seedling_id IN (?) ORDER BY tag", ids)
+	if err != nil {
+		return err
+	}
+	rows := []struct {
+		SeedlingID hide.Int64 `db:"seedling_id"`
+		Tag        string     `db:"tag"`
+	}{}
+	if err := s.Reads.SelectContext(ctx, &rows, s.Reads.Rebind(query), args...); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if seedling, ok := byID[row.SeedlingID]; ok {
+			seedling.Tags = append(seedling.Tags, row.Tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/opentelemetry-go-extra/otelsql"
+	"github.com/uptrace/opentelemetry-go-extra/otelsqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+const (
+	MAX_SQLITE_CONNS = 1
+	// MAX_SQLITE_TX_ATTEMPTS is how many times a write transaction is run
+	// before SQLITE_BUSY is returned, backing off from SQLiteBusyBackoff.
+	MAX_SQLITE_TX_ATTEMPTS = 4
+	SQLiteBusyBackoff      = 100 * time.Millisecond
+)
+
+// Store is the database seedlings and their attempts are kept in.
+type Store struct {
+	DB *sqlx.DB
+	// Reads is the read-only pool API reads are served from, which is DB
+	// unless another is set.
+	Reads *sqlx.DB
+}
+
+// New is a Store that reads and writes db.
+func New(db *sqlx.DB) *Store {
+	return &Store{DB: db, Reads: db}
+}
+
+func OpenDB(dsn string) (*sqlx.DB, error) {
+	db, err := otelsqlx.Open("sqlite3", dsn,
+		otelsql.WithAttributes(semconv.DBSystemSqlite))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(MAX_SQLITE_CONNS)
+
+	if _, err := db.Exec("PRAGMA temp_store = MEMORY;"); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("PRAGMA mmap_size = 30000000000;"); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// SQLiteDSN is the DSN of the database at path. Writes take the lock when
+// they begin, so a transaction waits out the busy timeout up front instead
+// of failing when it first writes.
+func SQLiteDSN(path string, busyTimeout time.Duration, readOnly bool) string {
+	if readOnly {
+		return fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", path, busyTimeout.Milliseconds())
+	}
+	return fmt.Sprintf("%s?cache=shared&_synchronous=normal&_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate",
+		path, busyTimeout.Milliseconds())
+}
+
+// OpenReadDB opens a pool of conns read-only connections to the database.
+func OpenReadDB(dsn string, conns int) (*sqlx.DB, error) {
+	db, err := otelsqlx.Open("sqlite3", dsn,
+		otelsql.WithAttributes(semconv.DBSystemSqlite))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(conns)
+	if _, err := db.Exec("PRAGMA temp_store = MEMORY;"); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteBusy reports whether err is SQLite giving up on a lock.
+func sqliteBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// SQLiteUnique reports whether err is a UNIQUE constraint failing, e.g. a
+// seedling name taken by a concurrent create.
+func SQLiteUnique(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// InTx runs fn in a transaction, committing it if fn succeeds. Transactions
+// that fail because the database is busy are retried from the start.
+func (s *Store) InTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	backoff := SQLiteBusyBackoff
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, s.DB, fn)
+		if !sqliteBusy(err) || attempt == MAX_SQLITE_TX_ATTEMPTS {
+			return err
+		}
+		logrus.WithField("error", err).WithField("attempt", attempt).Warn("database busy, retrying transaction")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func runTx(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
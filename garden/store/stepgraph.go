@@ -0,0 +1,21 @@
+package store
+
+import (
+	"time"
+
+	"github.com/c2h5oh/hide"
+)
+
+// SeedlingStepStatus is where one of a seedling's steps is. Seedling.Step is
+// the first step in the pipeline that hasn't succeeded.
+type SeedlingStepStatus struct {
+	SeedlingID hide.Int64 `db:"seedling_id" json:"-"`
+	Step       string     `db:"step" json:"step"`
+	Status     string     `db:"status" json:"status"`
+	// Error is why the last attempt at the step failed, while it's being
+	// fixed or once it's failed.
+	Error      string     `db:"error" json:"error,omitempty"`
+	StartedAt  *time.Time `db:"started_at" json:"startedAt,omitempty"`
+	FinishedAt *time.Time `db:"finished_at" json:"finishedAt,omitempty"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updatedAt"`
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// TemplateParams are stored as a JSON object.
+type TemplateParams map[string]string
+
+func (p TemplateParams) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(map[string]string(p))
+	return string(b), err
+}
+
+func (p *TemplateParams) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into TemplateParams", src)
+	}
+	*p = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, p)
+}
+
+// SeedlingTemplate is the template a seedling was created from. It's kept
+// on the seedling so every rebuild is prompted the same way.
+type SeedlingTemplate struct {
+	Template       string         `db:"template" json:"template,omitempty"`
+	TemplateParams TemplateParams `db:"template_params" json:"templateParams,omitempty"`
+}
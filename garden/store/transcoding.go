@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// TranscodingReport is what checking the JSON the seedling's HTTP server
+// decodes each rpc's request from against the proto3 JSON mapping of the
+// request message found. Advisory is set when mismatches didn't fail the
+// attempt.
+type TranscodingReport struct {
+	Advisory bool             `json:"advisory,omitempty"`
+	RPCs     []TranscodingRPC `json:"rpcs"`
+}
+
+// TranscodingRPC is the check of one rpc. Example is the canonical protojson
+// request, with every field. Handler is where the HTTP server decodes the
+// request and Decodes into what, both empty if no decode of it was found
+// and the rpc wasn't checked.
+type TranscodingRPC struct {
+	Service    string                `json:"service"`
+	RPC        string                `json:"rpc"`
+	Example    json.RawMessage       `json:"example"`
+	Handler    string                `json:"handler,omitempty"`
+	Decodes    string                `json:"decodes,omitempty"`
+	Mismatches []TranscodingMismatch `json:"mismatches"`
+}
+
+// TranscodingMismatch is a field the handler and the proto JSON mapping
+// disagree on, at the line of the handler's field.
+type TranscodingMismatch struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Kind     string `json:"kind"`
+	Field    string `json:"field,omitempty"`
+	JSONName string `json:"jsonName,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (r TranscodingReport) Value() (driver.Value, error) {
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+func (r *TranscodingReport) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into TranscodingReport", src)
+	}
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,47 @@
+// Package storetest opens databases for tests, migrated to the latest
+// schema.
+package storetest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tensorscale/garden/garden/store"
+)
+
+// Open is a new database under t's temp dir with every migration applied.
+// The search migration needs FTS5, which go-sqlite3 only builds with the
+// sqlite_fts5 tag, so t is skipped without it.
+func Open(t testing.TB) *sqlx.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "garden.sqlite3")
+	db, err := store.OpenDB(store.SQLiteDSN(path, 5*time.Second, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE VIRTUAL TABLE fts5_check USING fts5(x); DROP TABLE fts5_check"); err != nil {
+		t.Skip("the database needs FTS5, run the tests with -tags sqlite_fts5")
+	}
+	_, file, _, _ := runtime.Caller(0)
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(file), "..", "migrations", "*.up.sql"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		up, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(up)); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(f), err)
+		}
+	}
+	return db
+}